@@ -0,0 +1,150 @@
+// Package blob stores large payloads (such as multi-megabyte command
+// results) outside of the primary storage so that they can be
+// referenced by URL instead of being stored inline.
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrInvalidKey = errors.New("invalid blob key")
+
+// Store stores blobs by key.
+type Store interface {
+	// Put stores the content of r as key and returns its URL.
+	// Putting the same key again replaces the blob.
+	Put(ctx context.Context, key string, r io.Reader) (string, error)
+}
+
+// Key returns the (content-addressed) key of content: the hex SHA-256
+// of content. Identical content is only stored once.
+func Key(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// validKey reports whether key is a safe file name.
+func validKey(key string) bool {
+	return key != "" && !strings.ContainsAny(key, `/\`) && key != "." && key != ".."
+}
+
+// Dir stores blobs as files in a directory.
+type Dir struct {
+	path    string
+	baseURL string
+}
+
+// NewDir creates a new directory blob store at path. The URL of blobs
+// is baseURL (e.g. that of the blob API of the server) with the key
+// appended.
+func NewDir(path, baseURL string) (*Dir, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	return &Dir{path: path, baseURL: baseURL}, nil
+}
+
+func (d *Dir) Put(_ context.Context, key string, r io.Reader) (string, error) {
+	if !validKey(key) {
+		return "", ErrInvalidKey
+	}
+	f, err := ioutil.TempFile(d.path, ".tmp-"+key)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(d.path, key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return d.baseURL + key, nil
+}
+
+// Open opens the blob key. It returns an os.ErrNotExist error if the
+// blob does not exist.
+func (d *Dir) Open(key string) (io.ReadCloser, error) {
+	if !validKey(key) || strings.HasPrefix(key, ".tmp-") {
+		return nil, fmt.Errorf("%w: %v", os.ErrNotExist, ErrInvalidKey)
+	}
+	return os.Open(filepath.Join(d.path, key))
+}
+
+// HTTP stores blobs with HTTP PUT requests, e.g. to an object storage
+// bucket or WebDAV server.
+type HTTP struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewHTTP creates a new HTTP blob store which PUTs blobs to baseURL
+// with the key appended. The URL of blobs is the URL they were PUT to.
+func NewHTTP(baseURL string, client *http.Client) *HTTP {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTP{baseURL: baseURL, client: client}
+}
+
+func (h *HTTP) Put(ctx context.Context, key string, r io.Reader) (string, error) {
+	if !validKey(key) {
+		return "", ErrInvalidKey
+	}
+	url := h.baseURL + key
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, r)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("unexpected HTTP status %d %s", resp.StatusCode, resp.Status)
+	}
+	return url, nil
+}
+
+// Offloader stores content larger than a size threshold in a Store.
+type Offloader struct {
+	store     Store
+	threshold int
+}
+
+// NewOffloader creates a new Offloader which stores content larger
+// than threshold bytes in store.
+func NewOffloader(store Store, threshold int) *Offloader {
+	return &Offloader{store: store, threshold: threshold}
+}
+
+// Offload stores content if it is larger than the threshold and returns
+// its URL. It returns an empty URL for content that is not offloaded.
+func (o *Offloader) Offload(ctx context.Context, content []byte) (string, error) {
+	if o == nil || len(content) <= o.threshold {
+		return "", nil
+	}
+	return o.Put(ctx, content)
+}
+
+// Put stores content regardless of the threshold and returns its URL.
+func (o *Offloader) Put(ctx context.Context, content []byte) (string, error) {
+	return o.store.Put(ctx, Key(content), bytes.NewReader(content))
+}
@@ -0,0 +1,61 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDirOffload(t *testing.T) {
+	dir, err := NewDir(t.TempDir(), "/v1/blobs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := NewOffloader(dir, 4)
+	if url, err := o.Offload(context.Background(), []byte("1234")); err != nil || url != "" {
+		t.Fatalf("offloaded content within threshold: %q (err %v)", url, err)
+	}
+	content := []byte("12345")
+	url, err := o.Offload(context.Background(), content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/v1/blobs/" + Key(content); url != want {
+		t.Errorf("have URL %q, want %q", url, want)
+	}
+	rc, err := dir.Open(Key(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if have, _ := ioutil.ReadAll(rc); !bytes.Equal(have, content) {
+		t.Errorf("have %q, want %q", have, content)
+	}
+	for _, key := range []string{"../x", ".tmp-x", ""} {
+		if _, err = dir.Open(key); err == nil {
+			t.Errorf("opened invalid key %q", key)
+		}
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	var put string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		put = r.Method + " " + r.URL.Path + " " + string(body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	url, err := NewHTTP(srv.URL+"/bucket/", nil).Put(context.Background(), "key", strings.NewReader("content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != srv.URL+"/bucket/key" || put != "PUT /bucket/key content" {
+		t.Errorf("unexpected put: %s (URL %s)", put, url)
+	}
+}
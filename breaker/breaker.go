@@ -0,0 +1,215 @@
+// Package breaker implements circuit breakers for calls to external
+// dependencies (storage, APNs, and webhooks) so that a failing
+// dependency is given time to recover and callers fail fast rather
+// than waiting for every call to time out.
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/log"
+)
+
+var ErrOpen = errors.New("circuit breaker open")
+
+// State is the state of a circuit breaker.
+type State int
+
+const (
+	// Closed passes calls.
+	Closed State = iota
+	// HalfOpen passes a single probe call after the cooldown. Its
+	// outcome closes or re-opens the breaker.
+	HalfOpen
+	// Open fails calls with ErrOpen until the cooldown passed.
+	Open
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case HalfOpen:
+		return "half-open"
+	case Open:
+		return "open"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+// Breaker is a circuit breaker. It opens after a number of consecutive
+// failed calls and fails calls while open. After a cooldown it lets a
+// single probe call through: a successful probe closes the breaker
+// again while a failed probe re-opens it for another cooldown.
+//
+// A nil *Breaker passes all calls.
+type Breaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+	isFailure func(error) bool
+	logger    log.Logger
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+	opened   uint64
+	rejected uint64
+}
+
+type Option func(*Breaker)
+
+func WithLogger(logger log.Logger) Option {
+	return func(b *Breaker) {
+		b.logger = logger
+	}
+}
+
+// WithIsFailure sets the function that reports whether the error of a
+// call is a failure of the dependency. By default all errors other
+// than context.Canceled (e.g. a client that went away) are.
+func WithIsFailure(isFailure func(error) bool) Option {
+	return func(b *Breaker) {
+		b.isFailure = isFailure
+	}
+}
+
+// New creates a new closed circuit breaker named name that opens after
+// threshold consecutive failures for cooldown.
+func New(name string, threshold int, cooldown time.Duration, opts ...Option) *Breaker {
+	b := &Breaker{
+		name:      name,
+		threshold: threshold,
+		cooldown:  cooldown,
+		isFailure: func(err error) bool { return err != nil && !errors.Is(err, context.Canceled) },
+		logger:    log.NopLogger,
+		now:       time.Now,
+	}
+	if b.threshold < 1 {
+		b.threshold = 1
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Name returns the name of b.
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// Allow reports whether a call may proceed. It returns an error
+// wrapping ErrOpen if b is open (or half-open with a probe already in
+// progress). Allowed calls must report their outcome with Record.
+func (b *Breaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == Open && b.now().Sub(b.openedAt) >= b.cooldown {
+		b.state = HalfOpen
+		b.probing = false
+		b.logger.Info("msg", "circuit breaker half-open", "breaker", b.name)
+	}
+	switch {
+	case b.state == Open, b.state == HalfOpen && b.probing:
+		b.rejected++
+		return fmt.Errorf("%s: %w", b.name, ErrOpen)
+	case b.state == HalfOpen:
+		b.probing = true
+	}
+	return nil
+}
+
+// Record records the outcome of an allowed call with error err. Errors
+// that aren't failures neither count as failures nor as successes.
+func (b *Breaker) Record(err error) {
+	if b == nil {
+		return
+	}
+	failed := b.isFailure(err)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case err != nil && !failed:
+		// inconclusive: let another probe through
+		b.probing = false
+	case !failed && b.state == HalfOpen:
+		b.state = Closed
+		b.failures = 0
+		b.logger.Info("msg", "circuit breaker closed", "breaker", b.name)
+	case !failed:
+		b.failures = 0
+	case b.state == HalfOpen:
+		b.open(err)
+	case b.state == Closed:
+		b.failures++
+		if b.failures >= b.threshold {
+			b.open(err)
+		}
+	}
+}
+
+// open opens b after the failure err. b.mu must be held.
+func (b *Breaker) open(err error) {
+	b.state = Open
+	b.openedAt = b.now()
+	b.probing = false
+	b.failures = 0
+	b.opened++
+	b.logger.Info("msg", "circuit breaker open", "breaker", b.name, "cooldown", b.cooldown, "err", err)
+}
+
+// Do calls fn if b allows it and records its outcome.
+func (b *Breaker) Do(fn func() error) error {
+	if err := b.Allow(); err != nil {
+		return err
+	}
+	err := fn()
+	b.Record(err)
+	return err
+}
+
+// State returns the state of b. An open breaker whose cooldown passed
+// is reported as half-open.
+func (b *Breaker) State() State {
+	if b == nil {
+		return Closed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == Open && b.now().Sub(b.openedAt) >= b.cooldown {
+		return HalfOpen
+	}
+	return b.state
+}
+
+// Opened returns the number of times b opened.
+func (b *Breaker) Opened() uint64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.opened
+}
+
+// Rejected returns the number of calls b failed while open.
+func (b *Breaker) Rejected() uint64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rejected
+}
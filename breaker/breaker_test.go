@@ -0,0 +1,108 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/service"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := New("test", 2, time.Minute)
+	b.now = func() time.Time { return now }
+	errFail := errors.New("fail")
+
+	// failures below the threshold and reset by successes keep it closed
+	b.Do(func() error { return errFail })
+	b.Do(func() error { return nil })
+	b.Do(func() error { return errFail })
+	b.Do(func() error { return context.Canceled })
+	if have, want := b.State(), Closed; have != want {
+		t.Fatalf("state: have %v, want %v", have, want)
+	}
+
+	b.Do(func() error { return errFail })
+	if have, want := b.State(), Open; have != want {
+		t.Fatalf("state: have %v, want %v", have, want)
+	}
+	called := false
+	if err := b.Do(func() error { called = true; return nil }); !errors.Is(err, ErrOpen) || called {
+		t.Fatalf("open: have %v (called %v), want %v", err, called, ErrOpen)
+	}
+
+	// after the cooldown a single failing probe re-opens it
+	now = now.Add(time.Minute)
+	if have, want := b.State(), HalfOpen; have != want {
+		t.Fatalf("state: have %v, want %v", have, want)
+	}
+	if err := b.Allow(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrOpen) {
+		t.Fatalf("second probe: have %v, want %v", err, ErrOpen)
+	}
+	b.Record(errFail)
+	if have, want := b.State(), Open; have != want {
+		t.Fatalf("state: have %v, want %v", have, want)
+	}
+
+	// and a successful probe closes it
+	now = now.Add(time.Minute)
+	if err := b.Do(func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := b.State(), Closed; have != want {
+		t.Fatalf("state: have %v, want %v", have, want)
+	}
+	if have, want := b.Opened(), uint64(2); have != want {
+		t.Errorf("opened: have %d, want %d", have, want)
+	}
+	if have, want := b.Rejected(), uint64(2); have != want {
+		t.Errorf("rejected: have %d, want %d", have, want)
+	}
+}
+
+func TestNilBreaker(t *testing.T) {
+	var b *Breaker
+	if err := b.Do(func() error { return errors.New("fail") }); err == nil {
+		t.Fatal("expected error of fn")
+	}
+	if have, want := b.State(), Closed; have != want {
+		t.Errorf("state: have %v, want %v", have, want)
+	}
+}
+
+type fakeService struct {
+	service.CheckinAndCommandService
+	err error
+}
+
+func (s *fakeService) Authenticate(*mdm.Request, *mdm.Authenticate) error {
+	return s.err
+}
+
+func TestService(t *testing.T) {
+	next := &fakeService{err: &service.RejectError{StatusCode: http.StatusForbidden}}
+	b := New("storage", 1, time.Minute)
+	svc := NewService(next, b)
+	r := &mdm.Request{Context: context.Background()}
+
+	// rejections aren't failures
+	svc.Authenticate(r, &mdm.Authenticate{})
+	if have, want := b.State(), Closed; have != want {
+		t.Fatalf("state: have %v, want %v", have, want)
+	}
+
+	next.err = errors.New("storage down")
+	svc.Authenticate(r, &mdm.Authenticate{})
+	err := svc.Authenticate(r, &mdm.Authenticate{})
+	var rejectErr *service.RejectError
+	if !errors.As(err, &rejectErr) || rejectErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("have %v, want HTTP 503 rejection", err)
+	}
+}
@@ -0,0 +1,63 @@
+package breaker
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/service"
+)
+
+// Service is a service middleware that fails device requests fast
+// while its breaker is open. It is intended to wrap the services that
+// call storage so that an unavailable storage backend answers devices
+// with HTTP 503 (which they retry later) at once rather than holding
+// every device connection open until it times out.
+//
+// Errors of the next service other than rejections (see
+// service.RejectError), which in practice are storage errors, count as
+// failures.
+type Service struct {
+	next    service.CheckinAndCommandService
+	breaker *Breaker
+}
+
+// NewService creates a new circuit breaker service middleware.
+func NewService(next service.CheckinAndCommandService, b *Breaker) *Service {
+	return &Service{next: next, breaker: b}
+}
+
+// do calls fn if the breaker allows it and records its outcome.
+func (s *Service) do(fn func() error) error {
+	if err := s.breaker.Allow(); err != nil {
+		return &service.RejectError{StatusCode: http.StatusServiceUnavailable, Reason: err.Error()}
+	}
+	err := fn()
+	var rejectErr *service.RejectError
+	if errors.As(err, &rejectErr) {
+		s.breaker.Record(nil)
+	} else {
+		s.breaker.Record(err)
+	}
+	return err
+}
+
+func (s *Service) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	return s.do(func() error { return s.next.Authenticate(r, m) })
+}
+
+func (s *Service) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	return s.do(func() error { return s.next.TokenUpdate(r, m) })
+}
+
+func (s *Service) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	return s.do(func() error { return s.next.CheckOut(r, m) })
+}
+
+func (s *Service) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (cmd *mdm.Command, err error) {
+	err = s.do(func() error {
+		cmd, err = s.next.CommandAndReportResults(r, results)
+		return err
+	})
+	return
+}
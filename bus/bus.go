@@ -0,0 +1,82 @@
+// Package bus is an internal publish/subscribe notification bus.
+//
+// Services publish messages on topics and other parts of NanoMDM (like
+// the APIs waiting for command results) subscribe to them. The Local
+// bus delivers messages within the process and the Redis bus delivers
+// them to the subscribers of every NanoMDM instance using it.
+package bus
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Bus publishes messages to the subscribers of their topics.
+type Bus interface {
+	Publish(ctx context.Context, topic string, payload []byte) error
+	// Subscribe subscribes to the messages of topic. The returned
+	// channel buffers size messages; messages are dropped if the buffer
+	// is full. The subscription must be cancelled.
+	Subscribe(topic string, size int) (<-chan []byte, func())
+}
+
+// CommandResultTopic is the topic of the results of commandUUID. Its
+// payloads are JSON CommandResults.
+func CommandResultTopic(commandUUID string) string {
+	return "command.result." + commandUUID
+}
+
+// CommandResult is a command result reported by an enrollment.
+// NotNow results are not published.
+type CommandResult struct {
+	ID          string    `json:"id"`
+	CommandUUID string    `json:"command_uuid"`
+	Status      string    `json:"status"`
+	ReceivedAt  time.Time `json:"received_at"`
+	// Raw is the command result plist.
+	Raw []byte `json:"raw"`
+}
+
+// Local is a Bus that delivers messages within the process.
+type Local struct {
+	mu   sync.Mutex
+	subs map[string]map[chan []byte]struct{}
+}
+
+// NewLocal creates a new in-process bus.
+func NewLocal() *Local {
+	return &Local{subs: make(map[string]map[chan []byte]struct{})}
+}
+
+// Publish sends payload to the subscribers of topic.
+func (b *Local) Publish(_ context.Context, topic string, payload []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs[topic] {
+		select {
+		case ch <- payload:
+		default:
+		}
+	}
+	return nil
+}
+
+// Subscribe subscribes to the messages of topic.
+func (b *Local) Subscribe(topic string, size int) (<-chan []byte, func()) {
+	ch := make(chan []byte, size)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[topic] == nil {
+		b.subs[topic] = make(map[chan []byte]struct{})
+	}
+	b.subs[topic][ch] = struct{}{}
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs[topic], ch)
+		if len(b.subs[topic]) < 1 {
+			delete(b.subs, topic)
+		}
+	}
+}
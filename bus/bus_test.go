@@ -0,0 +1,99 @@
+package bus
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a Redis server supporting AUTH, SUBSCRIBE, and PUBLISH.
+type fakeRedis struct {
+	ln   net.Listener
+	mu   sync.Mutex
+	subs []*redisConn
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{ln: ln}
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(&redisConn{Conn: nc, r: bufio.NewReader(nc)})
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(c *redisConn) {
+	for {
+		reply, err := c.read()
+		if err != nil {
+			return
+		}
+		args, _ := reply.([]interface{})
+		cmd, _ := args[0].([]byte)
+		switch string(cmd) {
+		case "AUTH":
+			c.Write([]byte("+OK\r\n"))
+		case "SUBSCRIBE":
+			f.mu.Lock()
+			f.subs = append(f.subs, c)
+			f.mu.Unlock()
+			c.send("subscribe", string(args[1].([]byte)))
+		case "PUBLISH":
+			f.mu.Lock()
+			for _, sub := range f.subs {
+				sub.send("message", string(args[1].([]byte)), string(args[2].([]byte)))
+			}
+			f.mu.Unlock()
+			c.Write([]byte(":1\r\n"))
+		}
+	}
+}
+
+func TestRedis(t *testing.T) {
+	f := newFakeRedis(t)
+	defer f.ln.Close()
+	b, err := NewRedis("redis://:secret@" + f.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	ch, cancel := b.Subscribe("topic", 1)
+	defer cancel()
+	// wait for the subscription
+	for i := 0; ; i++ {
+		f.mu.Lock()
+		n := len(f.subs)
+		f.mu.Unlock()
+		if n > 0 {
+			break
+		} else if i > 100 {
+			t.Fatal("not subscribed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, topic := range []string{"other", "topic"} {
+		if err := b.Publish(context.Background(), topic, []byte(topic)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case payload := <-ch:
+		if string(payload) != "topic" {
+			t.Errorf("have %q, want %q", payload, "topic")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no message received")
+	}
+}
@@ -0,0 +1,298 @@
+package bus
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/log"
+)
+
+// DefaultRedisChannel is the Redis Pub/Sub channel of the bus messages.
+const DefaultRedisChannel = "nanomdm"
+
+// redisMessage is a bus message as published on the Redis channel.
+type redisMessage struct {
+	Topic   string `json:"topic"`
+	Payload []byte `json:"payload"`
+}
+
+// Redis is a Bus that delivers messages through a Redis Pub/Sub
+// channel to the subscribers of every instance using the channel.
+// Messages published while disconnected from Redis are lost.
+type Redis struct {
+	local  *Local
+	addr   string
+	tls    bool
+	user   string
+	pass   string
+	chName string
+	logger log.Logger
+
+	mu   sync.Mutex // publishing connection
+	conn *redisConn
+
+	done chan struct{}
+}
+
+type RedisOption func(*Redis)
+
+func WithLogger(logger log.Logger) RedisOption {
+	return func(b *Redis) {
+		b.logger = logger
+	}
+}
+
+// NewRedis creates a new Redis bus from a URL of the form
+// "redis://[[user]:password@]host[:port][?channel=name]" (or
+// "rediss://" for TLS) and starts receiving messages.
+func NewRedis(rawURL string, opts ...RedisOption) (*Redis, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("invalid redis URL scheme: %s", u.Scheme)
+	}
+	b := &Redis{
+		local:  NewLocal(),
+		addr:   u.Host,
+		tls:    u.Scheme == "rediss",
+		chName: u.Query().Get("channel"),
+		logger: log.NopLogger,
+		done:   make(chan struct{}),
+	}
+	if u.Port() == "" {
+		b.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		b.user = u.User.Username()
+		b.pass, _ = u.User.Password()
+	}
+	if b.chName == "" {
+		b.chName = DefaultRedisChannel
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	go b.receive()
+	return b, nil
+}
+
+// Close stops receiving messages.
+func (b *Redis) Close() error {
+	close(b.done)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn != nil {
+		return b.conn.Close()
+	}
+	return nil
+}
+
+// Publish publishes payload on topic to the Redis channel.
+func (b *Redis) Publish(ctx context.Context, topic string, payload []byte) error {
+	msg, err := json.Marshal(&redisMessage{Topic: topic, Payload: payload})
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for retry := 0; ; retry++ {
+		if b.conn == nil {
+			if b.conn, err = b.dial(ctx); err != nil {
+				return err
+			}
+		}
+		if _, err = b.conn.do("PUBLISH", b.chName, string(msg)); err == nil {
+			return nil
+		}
+		// the connection may have been idle and closed: retry once on
+		// a new connection
+		b.conn.Close()
+		b.conn = nil
+		if retry > 0 {
+			return err
+		}
+	}
+}
+
+// Subscribe subscribes to the messages of topic from any instance.
+func (b *Redis) Subscribe(topic string, size int) (<-chan []byte, func()) {
+	return b.local.Subscribe(topic, size)
+}
+
+// receive subscribes to the Redis channel and delivers its messages
+// to the local subscribers, reconnecting until closed.
+func (b *Redis) receive() {
+	backoff := time.Second
+	for {
+		err := b.subscribe()
+		select {
+		case <-b.done:
+			return
+		default:
+		}
+		b.logger.Info("msg", "redis subscription", "err", err, "retry_in", backoff)
+		select {
+		case <-b.done:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > 30*time.Second {
+			backoff = 30 * time.Second
+		}
+	}
+}
+
+// subscribe receives the messages of the Redis channel until an error.
+func (b *Redis) subscribe() error {
+	conn, err := b.dial(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-b.done:
+			conn.Close()
+		case <-stop:
+		}
+	}()
+	if err = conn.send("SUBSCRIBE", b.chName); err != nil {
+		return err
+	}
+	for {
+		reply, err := conn.read()
+		if err != nil {
+			return err
+		}
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) != 3 {
+			continue
+		}
+		if kind, _ := parts[0].([]byte); string(kind) != "message" {
+			// e.g. the subscribe confirmation
+			continue
+		}
+		data, _ := parts[2].([]byte)
+		msg := new(redisMessage)
+		if err = json.Unmarshal(data, msg); err != nil {
+			b.logger.Info("msg", "decoding redis message", "err", err)
+			continue
+		}
+		b.local.Publish(context.Background(), msg.Topic, msg.Payload)
+	}
+}
+
+// dial connects (and authenticates) to Redis.
+func (b *Redis) dial(ctx context.Context) (*redisConn, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var nc net.Conn
+	var err error
+	if b.tls {
+		host, _, _ := net.SplitHostPort(b.addr)
+		nc, err = tls.DialWithDialer(dialer, "tcp", b.addr, &tls.Config{ServerName: host})
+	} else {
+		nc, err = dialer.DialContext(ctx, "tcp", b.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: nc, r: bufio.NewReader(nc)}
+	if b.pass != "" {
+		args := []string{"AUTH", b.pass}
+		if b.user != "" {
+			args = []string{"AUTH", b.user, b.pass}
+		}
+		if _, err = conn.do(args...); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("redis auth: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// redisConn is a connection speaking the Redis serialization protocol
+// (RESP).
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// send writes a command.
+func (c *redisConn) send(args ...string) error {
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	_, err := c.Write(buf)
+	return err
+}
+
+// do writes a command and reads its reply.
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	defer c.SetDeadline(time.Time{})
+	if err := c.send(args...); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+// read reads a reply. Error replies are returned as errors, bulk
+// strings as []byte, and arrays as []interface{}.
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: invalid reply")
+	}
+	kind, line := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, fmt.Errorf("redis: %s", line)
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err = io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		reply := make([]interface{}, n)
+		for i := range reply {
+			if reply[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return reply, nil
+	}
+	return nil, fmt.Errorf("redis: invalid reply type: %q", kind)
+}
@@ -0,0 +1,69 @@
+// Package chaos injects faults (latency and errors) into storage and
+// APNs pushes for resilience testing, e.g. of webhook consumers and
+// device behavior under partial failures. It must never be enabled in
+// production by accident.
+package chaos
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrInjected is the error of injected faults.
+var ErrInjected = errors.New("chaos: injected fault")
+
+// Faults configures the injected faults. Faults are safe for
+// concurrent use.
+type Faults struct {
+	// Latency is the maximum (uniformly random) delay added to calls.
+	Latency time.Duration
+	// ErrorRate is the probability (0 to 1) of a call failing.
+	ErrorRate float64
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// float64 returns a random number in [0.0,1.0).
+func (f *Faults) float64() float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.rand == nil {
+		f.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return f.rand.Float64()
+}
+
+// delay sleeps for a random duration up to the latency or until ctx
+// is done.
+func (f *Faults) delay(ctx context.Context) {
+	if f.Latency <= 0 {
+		return
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	t := time.NewTimer(time.Duration(f.float64() * float64(f.Latency)))
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
+
+// fail reports whether a call should fail.
+func (f *Faults) fail() bool {
+	return f.ErrorRate > 0 && f.float64() < f.ErrorRate
+}
+
+// inject delays a call and returns ErrInjected if it should fail.
+func (f *Faults) inject(ctx context.Context) error {
+	f.delay(ctx)
+	if f.fail() {
+		return ErrInjected
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package chaos
+
+import (
+	"crypto/tls"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/push"
+)
+
+type pushFactory struct{}
+
+func (pushFactory) NewPushProvider(*tls.Certificate) (push.PushProvider, error) {
+	return okProvider{}, nil
+}
+
+type okProvider struct{}
+
+func (okProvider) Push(pushes []*mdm.Push) (map[string]*push.Response, error) {
+	responses := make(map[string]*push.Response)
+	for _, p := range pushes {
+		responses[p.Token.String()] = &push.Response{Id: "ok"}
+	}
+	return responses, nil
+}
+
+func TestPushFaults(t *testing.T) {
+	pushes := []*mdm.Push{{Token: []byte{1}}, {Token: []byte{2}}}
+	for _, test := range []struct {
+		rate   float64
+		failed int
+	}{
+		{0, 0},
+		{1, 2},
+	} {
+		f := NewPushProviderFactory(pushFactory{}, &Faults{Latency: time.Millisecond, ErrorRate: test.rate})
+		provider, err := f.NewPushProvider(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		responses, err := provider.Push(pushes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		failed := 0
+		for _, resp := range responses {
+			if errors.Is(resp.Err, ErrInjected) {
+				failed++
+			}
+		}
+		if len(responses) != 2 || failed != test.failed {
+			t.Errorf("error rate %v: have %d responses, %d failed", test.rate, len(responses), failed)
+		}
+	}
+}
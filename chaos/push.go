@@ -0,0 +1,58 @@
+package chaos
+
+import (
+	"context"
+	"crypto/tls"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/push"
+)
+
+// PushProviderFactory creates push providers that inject faults into
+// the pushes of the providers of the embedded factory.
+type PushProviderFactory struct {
+	push.PushProviderFactory
+	faults *Faults
+}
+
+// NewPushProviderFactory creates a new fault-injecting push provider
+// factory decorator of next.
+func NewPushProviderFactory(next push.PushProviderFactory, faults *Faults) *PushProviderFactory {
+	return &PushProviderFactory{PushProviderFactory: next, faults: faults}
+}
+
+func (f *PushProviderFactory) NewPushProvider(cert *tls.Certificate) (push.PushProvider, error) {
+	provider, err := f.PushProviderFactory.NewPushProvider(cert)
+	if err != nil {
+		return nil, err
+	}
+	return &pushProvider{next: provider, faults: f.faults}, nil
+}
+
+// pushProvider delays pushes and fails individual pushes (as if
+// rejected by APNs) at the error rate.
+type pushProvider struct {
+	next   push.PushProvider
+	faults *Faults
+}
+
+func (p *pushProvider) Push(pushes []*mdm.Push) (map[string]*push.Response, error) {
+	p.faults.delay(context.Background())
+	responses := make(map[string]*push.Response)
+	var passed []*mdm.Push
+	for _, pushInfo := range pushes {
+		if p.faults.fail() {
+			responses[pushInfo.Token.String()] = &push.Response{Err: ErrInjected}
+			continue
+		}
+		passed = append(passed, pushInfo)
+	}
+	if len(passed) < 1 {
+		return responses, nil
+	}
+	nextResponses, err := p.next.Push(passed)
+	for token, resp := range nextResponses {
+		responses[token] = resp
+	}
+	return responses, err
+}
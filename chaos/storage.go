@@ -0,0 +1,132 @@
+package chaos
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// Storage injects faults into the storage calls of device requests
+// (check-ins, command reports and queues, and certificate
+// associations), push info retrieval, and command enqueueing. Other
+// calls are passed to the embedded storage unchanged.
+//
+// Optional interfaces of the embedded storage (such as
+// storage.CommandReportAndNextStore) are hidden.
+type Storage struct {
+	storage.AllStorage
+	faults *Faults
+}
+
+// NewStorage creates a new fault-injecting storage decorator of next.
+func NewStorage(next storage.AllStorage, faults *Faults) *Storage {
+	return &Storage{AllStorage: next, faults: faults}
+}
+
+// inject injects faults into the call of method with ctx.
+func (s *Storage) inject(ctx context.Context, method string) error {
+	if err := s.faults.inject(ctx); err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	return nil
+}
+
+func (s *Storage) StoreAuthenticate(r *mdm.Request, msg *mdm.Authenticate) error {
+	if err := s.inject(r.Context, "StoreAuthenticate"); err != nil {
+		return err
+	}
+	return s.AllStorage.StoreAuthenticate(r, msg)
+}
+
+func (s *Storage) StoreTokenUpdate(r *mdm.Request, msg *mdm.TokenUpdate) error {
+	if err := s.inject(r.Context, "StoreTokenUpdate"); err != nil {
+		return err
+	}
+	return s.AllStorage.StoreTokenUpdate(r, msg)
+}
+
+func (s *Storage) Disable(r *mdm.Request) error {
+	if err := s.inject(r.Context, "Disable"); err != nil {
+		return err
+	}
+	return s.AllStorage.Disable(r)
+}
+
+func (s *Storage) StoreCommandReport(r *mdm.Request, report *mdm.CommandResults) error {
+	if err := s.inject(r.Context, "StoreCommandReport"); err != nil {
+		return err
+	}
+	return s.AllStorage.StoreCommandReport(r, report)
+}
+
+func (s *Storage) RetrieveNextCommand(r *mdm.Request, skipNotNow bool) (*mdm.Command, error) {
+	if err := s.inject(r.Context, "RetrieveNextCommand"); err != nil {
+		return nil, err
+	}
+	return s.AllStorage.RetrieveNextCommand(r, skipNotNow)
+}
+
+func (s *Storage) ClearQueue(r *mdm.Request) error {
+	if err := s.inject(r.Context, "ClearQueue"); err != nil {
+		return err
+	}
+	return s.AllStorage.ClearQueue(r)
+}
+
+func (s *Storage) RetrieveNextCommands(r *mdm.Request, skipNotNow bool, limit int) ([]*mdm.Command, error) {
+	if err := s.inject(r.Context, "RetrieveNextCommands"); err != nil {
+		return nil, err
+	}
+	return s.AllStorage.RetrieveNextCommands(r, skipNotNow, limit)
+}
+
+func (s *Storage) StoreCommandDelivered(r *mdm.Request, uuid string) error {
+	if err := s.inject(r.Context, "StoreCommandDelivered"); err != nil {
+		return err
+	}
+	return s.AllStorage.StoreCommandDelivered(r, uuid)
+}
+
+func (s *Storage) RetrievePushInfo(ctx context.Context, ids []string) (map[string]*mdm.Push, error) {
+	if err := s.inject(ctx, "RetrievePushInfo"); err != nil {
+		return nil, err
+	}
+	return s.AllStorage.RetrievePushInfo(ctx, ids)
+}
+
+func (s *Storage) EnqueueCommand(ctx context.Context, id []string, cmd *mdm.Command) (map[string]error, error) {
+	if err := s.inject(ctx, "EnqueueCommand"); err != nil {
+		return nil, err
+	}
+	return s.AllStorage.EnqueueCommand(ctx, id, cmd)
+}
+
+func (s *Storage) HasCertHash(r *mdm.Request, hash string) (bool, error) {
+	if err := s.inject(r.Context, "HasCertHash"); err != nil {
+		return false, err
+	}
+	return s.AllStorage.HasCertHash(r, hash)
+}
+
+func (s *Storage) EnrollmentHasCertHash(r *mdm.Request, hash string) (bool, error) {
+	if err := s.inject(r.Context, "EnrollmentHasCertHash"); err != nil {
+		return false, err
+	}
+	return s.AllStorage.EnrollmentHasCertHash(r, hash)
+}
+
+func (s *Storage) IsCertHashAssociated(r *mdm.Request, hash string) (bool, error) {
+	if err := s.inject(r.Context, "IsCertHashAssociated"); err != nil {
+		return false, err
+	}
+	return s.AllStorage.IsCertHashAssociated(r, hash)
+}
+
+func (s *Storage) AssociateCertHash(r *mdm.Request, hash string) error {
+	if err := s.inject(r.Context, "AssociateCertHash"); err != nil {
+		return err
+	}
+	return s.AllStorage.AssociateCertHash(r, hash)
+}
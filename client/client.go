@@ -0,0 +1,318 @@
+// Package client is a Go client of the NanoMDM versioned (v1) API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/cmdplist"
+	"github.com/jessepeterson/nanomdm/service/callback"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// DefaultUsername is the HTTP Basic username of the NanoMDM API.
+const DefaultUsername = "nanomdm"
+
+// Error is an error response of the API.
+type Error struct {
+	StatusCode int
+	// Code is the machine-readable error code (e.g. "invalid_request").
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("nanomdm api: %s (HTTP %d): %s", e.Code, e.StatusCode, e.Message)
+}
+
+// EnrollmentStatus is the enqueue and push status of an enrollment.
+type EnrollmentStatus struct {
+	PushError    string `json:"push_error,omitempty"`
+	PushResult   string `json:"push_result,omitempty"`
+	CommandError string `json:"command_error,omitempty"`
+}
+
+// CommandRequest enqueues a command. The command is either a cmdplist
+// catalog RequestType (with Args, Data, and additional Fields) or the
+// raw command Plist.
+type CommandRequest struct {
+	EnrollmentIDs []string          `json:"enrollment_ids"`
+	Channel       string            `json:"channel,omitempty"`
+	RequestType   string            `json:"request_type,omitempty"`
+	Args          map[string]string `json:"args,omitempty"`
+	Data          map[string][]byte `json:"data,omitempty"`
+	Fields        json.RawMessage   `json:"fields,omitempty"`
+	Plist         []byte            `json:"plist,omitempty"`
+	NoPush        bool              `json:"no_push,omitempty"`
+	CallbackURL   string            `json:"callback_url,omitempty"`
+	// Wait is the duration to wait for the command results (of at most
+	// five minutes) formatted as a Go duration.
+	Wait string `json:"wait,omitempty"`
+}
+
+// CommandResponse is the response of an enqueued command.
+type CommandResponse struct {
+	CommandUUID string                       `json:"command_uuid"`
+	RequestType string                       `json:"request_type"`
+	Enrollments map[string]*EnrollmentStatus `json:"enrollments"`
+	Results     map[string]*callback.Result  `json:"results,omitempty"`
+	WaitTimeout bool                         `json:"wait_timeout,omitempty"`
+}
+
+// Client is a NanoMDM API client. GET requests (and pushes) that fail
+// with network errors or HTTP 429 or 5xx statuses are retried. Commands
+// are not retried as they may already have been enqueued.
+type Client struct {
+	baseURL  *url.URL
+	username string
+	apiKey   string
+	client   *http.Client
+	retries  int
+	backoff  time.Duration
+}
+
+type Option func(*Client)
+
+// WithHTTPClient uses client for API requests.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.client = client
+	}
+}
+
+// WithRetries retries requests up to retries times waiting backoff
+// (doubling every retry) in between. Defaults to 3 retries from 500ms.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+// WithUsername sets the HTTP Basic username. Defaults to
+// DefaultUsername.
+func WithUsername(username string) Option {
+	return func(c *Client) {
+		c.username = username
+	}
+}
+
+// New creates a new client of the NanoMDM server at serverURL (e.g.
+// "https://mdm.example.com", including any API prefix) with apiKey.
+func New(serverURL, apiKey string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimRight(serverURL, "/") + "/api/v1/")
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		baseURL:  u,
+		username: DefaultUsername,
+		apiKey:   apiKey,
+		client:   &http.Client{Timeout: 6 * time.Minute},
+		retries:  3,
+		backoff:  500 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// do sends a request to the API path and decodes the data of the
+// response envelope into data and its pagination into page.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, data interface{}, page *pagination) error {
+	u, err := c.baseURL.Parse(path)
+	if err != nil {
+		return err
+	}
+	u.RawQuery = query.Encode()
+	var b []byte
+	if body != nil {
+		if b, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	retries := c.retries
+	if method != http.MethodGet && path != "push" {
+		retries = 0
+	}
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		retry, err = c.attempt(ctx, method, u.String(), b, data, page)
+		if attempt >= retries || !retry {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+type pagination struct {
+	Total      int    `json:"total"`
+	NextCursor string `json:"next_cursor"`
+}
+
+// attempt sends a request and reports whether a failed request may be
+// retried (on network errors and HTTP 429 or 5xx statuses).
+func (c *Client) attempt(ctx context.Context, method, u string, body []byte, data interface{}, page *pagination) (retry bool, err error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return false, err
+	}
+	req.SetBasicAuth(c.username, c.apiKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return retry, err
+	}
+	env := &struct {
+		Data       interface{} `json:"data"`
+		Pagination *pagination `json:"pagination"`
+		Error      *Error      `json:"error"`
+	}{Data: data, Pagination: page}
+	if err = json.Unmarshal(b, env); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			// e.g. authentication failures are not JSON
+			return retry, &Error{StatusCode: resp.StatusCode, Code: "http_error", Message: strings.TrimSpace(string(b))}
+		}
+		return false, fmt.Errorf("decoding response: %w", err)
+	}
+	if env.Error != nil {
+		env.Error.StatusCode = resp.StatusCode
+		return retry, env.Error
+	} else if resp.StatusCode != http.StatusOK {
+		return retry, &Error{StatusCode: resp.StatusCode, Code: "http_error", Message: resp.Status}
+	}
+	return false, nil
+}
+
+// ListEnrollments lists the enrollments (of all pages). The optional
+// query filters them (e.g. "device" or "awaiting_configuration").
+func (c *Client) ListEnrollments(ctx context.Context, query url.Values) ([]*storage.Enrollment, error) {
+	var enrollments []*storage.Enrollment
+	err := c.list(ctx, "enrollments", query, func() interface{} {
+		return &[]*storage.Enrollment{}
+	}, func(v interface{}) {
+		enrollments = append(enrollments, *v.(*[]*storage.Enrollment)...)
+	})
+	return enrollments, err
+}
+
+// CommandDeliveries returns the command delivery audit trail of
+// enrollment id (of all pages).
+func (c *Client) CommandDeliveries(ctx context.Context, id string) ([]*storage.CommandDelivery, error) {
+	var deliveries []*storage.CommandDelivery
+	err := c.list(ctx, "enrollments/"+url.PathEscape(id)+"/commands", nil, func() interface{} {
+		return &[]*storage.CommandDelivery{}
+	}, func(v interface{}) {
+		deliveries = append(deliveries, *v.(*[]*storage.CommandDelivery)...)
+	})
+	return deliveries, err
+}
+
+// list requests all pages of the API list at path.
+func (c *Client) list(ctx context.Context, path string, query url.Values, newPage func() interface{}, add func(interface{})) error {
+	q := url.Values{"limit": {"1000"}}
+	for k, v := range query {
+		q[k] = v
+	}
+	for {
+		data, page := newPage(), new(pagination)
+		if err := c.do(ctx, http.MethodGet, path, q, nil, data, page); err != nil {
+			return err
+		}
+		add(data)
+		if page.NextCursor == "" {
+			return nil
+		}
+		q.Set("cursor", page.NextCursor)
+	}
+}
+
+// EnqueueCommand enqueues (and pushes) the command in req.
+func (c *Client) EnqueueCommand(ctx context.Context, req *CommandRequest) (*CommandResponse, error) {
+	resp := new(CommandResponse)
+	return resp, c.do(ctx, http.MethodPost, "commands", nil, req, resp, nil)
+}
+
+// Enqueue enqueues (and pushes) cmd to the enrollments ids.
+func (c *Client) Enqueue(ctx context.Context, ids []string, cmd *cmdplist.Command) (*CommandResponse, error) {
+	b, err := cmd.Plist()
+	if err != nil {
+		return nil, err
+	}
+	return c.EnqueueCommand(ctx, &CommandRequest{EnrollmentIDs: ids, Plist: b})
+}
+
+// EnqueueAndWait enqueues (and pushes) cmd to the enrollments ids and
+// waits up to wait for their results.
+func (c *Client) EnqueueAndWait(ctx context.Context, ids []string, cmd *cmdplist.Command, wait time.Duration) (*CommandResponse, error) {
+	b, err := cmd.Plist()
+	if err != nil {
+		return nil, err
+	}
+	return c.EnqueueCommand(ctx, &CommandRequest{EnrollmentIDs: ids, Plist: b, Wait: wait.String()})
+}
+
+// Push sends APNs pushes to the enrollments ids.
+func (c *Client) Push(ctx context.Context, ids []string) (map[string]*EnrollmentStatus, error) {
+	resp := new(struct {
+		Enrollments map[string]*EnrollmentStatus `json:"enrollments"`
+	})
+	err := c.do(ctx, http.MethodPost, "push", nil, map[string][]string{"enrollment_ids": ids}, resp, nil)
+	return resp.Enrollments, err
+}
+
+// Result returns the result of command uuid from enrollment id that
+// already arrived (only its status). It returns an Error with code
+// "not_found" if there is no result.
+func (c *Client) Result(ctx context.Context, uuid, id string) (*callback.Result, error) {
+	return c.result(ctx, uuid, url.Values{"id": {id}})
+}
+
+// WaitResult waits up to wait for the result of command uuid (from
+// enrollment id, if not empty). It returns an Error with code
+// "not_found" if no result arrived in time.
+func (c *Client) WaitResult(ctx context.Context, uuid, id string, wait time.Duration) (*callback.Result, error) {
+	q := url.Values{"wait": {wait.String()}}
+	if id != "" {
+		q.Set("id", id)
+	}
+	return c.result(ctx, uuid, q)
+}
+
+func (c *Client) result(ctx context.Context, uuid string, query url.Values) (*callback.Result, error) {
+	result := new(callback.Result)
+	return result, c.do(ctx, http.MethodGet, "commands/"+url.PathEscape(uuid)+"/result", query, nil, result, nil)
+}
+
+// IsNotFound reports whether err is an API "not_found" error.
+func IsNotFound(err error) bool {
+	apiErr, ok := err.(*Error)
+	return ok && apiErr.Code == "not_found"
+}
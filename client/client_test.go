@@ -0,0 +1,69 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClient(t *testing.T) {
+	var failed bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != DefaultUsername || pass != "key" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v1/enrollments":
+			// fail the first request to exercise retries
+			if !failed {
+				failed = true
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(`{"error":{"code":"internal_error","message":"unavailable"}}`))
+				return
+			}
+			if r.URL.Query().Get("cursor") == "" {
+				w.Write([]byte(`{"data":[{"id":"A"}],"pagination":{"total":2,"next_cursor":"MQ"}}`))
+			} else {
+				w.Write([]byte(`{"data":[{"id":"B"}],"pagination":{"total":2}}`))
+			}
+		case "/api/v1/commands/uuid/result":
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": map[string]string{"code": "not_found", "message": "no result"},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL, "key", WithRetries(1, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	enrollments, err := c.ListEnrollments(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(enrollments) != 2 || enrollments[0].ID != "A" || enrollments[1].ID != "B" {
+		t.Errorf("unexpected enrollments: %v", enrollments)
+	}
+
+	_, err = c.Result(ctx, "uuid", "A")
+	if !IsNotFound(err) {
+		t.Errorf("expected not found error, have: %v", err)
+	}
+
+	c, _ = New(srv.URL, "wrong")
+	_, err = c.ListEnrollments(ctx, nil)
+	if apiErr, ok := err.(*Error); !ok || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected unauthorized error, have: %v", err)
+	}
+}
@@ -2,15 +2,20 @@
 package cli
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
+	"github.com/jessepeterson/nanomdm/cryptoutil"
 	"github.com/jessepeterson/nanomdm/log"
 	"github.com/jessepeterson/nanomdm/storage"
 	"github.com/jessepeterson/nanomdm/storage/allmulti"
 	"github.com/jessepeterson/nanomdm/storage/file"
+	"github.com/jessepeterson/nanomdm/storage/memqueue"
 	"github.com/jessepeterson/nanomdm/storage/mysql"
+	"github.com/jessepeterson/nanomdm/storage/splitqueue"
 )
 
 type StringAccumulator []string
@@ -27,6 +32,41 @@ func (s *StringAccumulator) Set(value string) error {
 type Storage struct {
 	Storage StringAccumulator
 	DSN     StringAccumulator
+
+	// Envelope, if set, encrypts sensitive columns of SQL storage and
+	// the raw plists and push keys of file storage.
+	Envelope *cryptoutil.Envelope
+	// RotateSecrets re-encrypts sensitive columns of SQL storage
+	// with the primary KEK of Envelope at setup.
+	RotateSecrets bool
+
+	// Queue, if set, names the command queue storage backend used in
+	// place of the queue of the (enrollment) storage.
+	Queue string
+
+	// DualWrite compares the results of the two storage backends (the
+	// primary and a shadow being migrated to) in multi-storage.
+	DualWrite bool
+
+	// Connection pool settings of SQL storage. Zero values keep the
+	// database/sql defaults.
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+
+	// SlowQuery, if set, instruments SQL storage queries and logs
+	// those taking at least this long.
+	SlowQuery time.Duration
+
+	// ConnectRetry is how long to retry connecting to SQL storage at
+	// startup.
+	ConnectRetry time.Duration
+	// HealthCheckInterval, if set, pings SQL storage at this interval
+	// and resets its connection pool after HealthCheckFailures
+	// consecutive failures.
+	HealthCheckInterval time.Duration
+	HealthCheckFailures int
 }
 
 func NewStorage() *Storage {
@@ -34,6 +74,22 @@ func NewStorage() *Storage {
 }
 
 func (s *Storage) Parse(logger log.Logger) (storage.AllStorage, error) {
+	mdmStorage, err := s.parse(logger)
+	if err != nil || s.Queue == "" {
+		return mdmStorage, err
+	}
+	logger.Info("msg", "queue storage setup", "storage", s.Queue)
+	var queue storage.QueueStore
+	switch s.Queue {
+	case "memory":
+		queue = memqueue.New()
+	default:
+		return nil, fmt.Errorf("unknown queue storage: %s", s.Queue)
+	}
+	return splitqueue.New(mdmStorage, queue), nil
+}
+
+func (s *Storage) parse(logger log.Logger) (storage.AllStorage, error) {
 	if len(s.Storage) != len(s.DSN) {
 		return nil, errors.New("must have same number of storage and DSN flags")
 	}
@@ -51,16 +107,46 @@ func (s *Storage) Parse(logger log.Logger) (storage.AllStorage, error) {
 		)
 		switch storage {
 		case "file":
-			fileStorage, err := file.New(dsn)
+			opts := []file.Option{file.WithLogger(logger.With("storage", "file"))}
+			if s.Envelope != nil {
+				opts = append(opts, file.WithEnvelope(s.Envelope))
+			}
+			fileStorage, err := file.New(dsn, opts...)
 			if err != nil {
 				return nil, err
 			}
 			mdmStorage = append(mdmStorage, fileStorage)
 		case "mysql":
-			mysqlStorage, err := mysql.New(dsn, logger.With("storage", "mysql"))
+			var opts []mysql.Option
+			if s.Envelope != nil {
+				opts = append(opts, mysql.WithEnvelope(s.Envelope))
+			}
+			opts = append(opts,
+				mysql.WithMaxOpenConns(s.MaxOpenConns),
+				mysql.WithMaxIdleConns(s.MaxIdleConns),
+				mysql.WithConnMaxLifetime(s.ConnMaxLifetime),
+				mysql.WithConnMaxIdleTime(s.ConnMaxIdleTime),
+			)
+			if s.SlowQuery > 0 {
+				opts = append(opts, mysql.WithQueryInstrumentation(s.SlowQuery))
+			}
+			if s.ConnectRetry > 0 {
+				opts = append(opts, mysql.WithConnectRetry(s.ConnectRetry))
+			}
+			if s.HealthCheckInterval > 0 {
+				opts = append(opts, mysql.WithHealthCheck(s.HealthCheckInterval, s.HealthCheckFailures))
+			}
+			mysqlStorage, err := mysql.New(dsn, logger.With("storage", "mysql"), opts...)
 			if err != nil {
 				return nil, err
 			}
+			if s.Envelope != nil && s.RotateSecrets {
+				count, err := mysqlStorage.RotateSecrets(context.Background())
+				if err != nil {
+					return nil, fmt.Errorf("rotating secrets: %w", err)
+				}
+				logger.Info("msg", "rotated secrets", "storage", storage, "count", count)
+			}
 			mdmStorage = append(mdmStorage, mysqlStorage)
 		default:
 			return nil, fmt.Errorf("unknown storage: %s", storage)
@@ -72,6 +158,13 @@ func (s *Storage) Parse(logger log.Logger) (storage.AllStorage, error) {
 	if len(mdmStorage) == 1 {
 		return mdmStorage[0], nil
 	}
+	if s.DualWrite {
+		if len(mdmStorage) != 2 {
+			return nil, errors.New("dual-write requires two storage backends")
+		}
+		logger.Info("msg", "storage setup", "storage", "dual-write")
+		return allmulti.NewDualWrite(logger.With("component", "dual-write"), mdmStorage[0], mdmStorage[1]), nil
+	}
 	logger.Info("msg", "storage setup", "storage", "multi-storage", "count", len(mdmStorage))
 	return allmulti.New(
 		logger.With("component", "multi-storage"),
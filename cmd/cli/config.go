@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envRe matches ${NAME} and ${NAME:-default} references.
+var envRe = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// InterpolateEnv replaces ${NAME} references in b with the value of
+// the environment variable NAME. ${NAME:-default} uses default if NAME
+// is unset or empty. A literal "$$" is replaced with "$".
+func InterpolateEnv(b []byte) []byte {
+	return envRe.ReplaceAllFunc(b, func(m []byte) []byte {
+		if string(m) == "$$" {
+			return []byte("$")
+		}
+		sub := envRe.FindSubmatch(m)
+		if v := os.Getenv(string(sub[1])); v != "" {
+			return []byte(v)
+		}
+		return sub[3]
+	})
+}
+
+// configValue returns the flag value of the config scalar v. Only
+// string values have environment variables interpolated (see
+// InterpolateEnv) so that values cannot alter the config structure.
+func configValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return string(InterpolateEnv([]byte(s)))
+	}
+	return fmt.Sprint(v)
+}
+
+// flattenConfig flattens nested config maps into flag names joined by
+// hyphens. For example {"webhook": {"url": "x"}} becomes "webhook-url".
+// Lists become multiple values for the same flag.
+func flattenConfig(prefix string, v interface{}, out map[string][]string) error {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, v2 := range t {
+			name := k
+			if prefix != "" {
+				name = prefix + "-" + k
+			}
+			if err := flattenConfig(name, v2, out); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, v2 := range t {
+			switch v2.(type) {
+			case map[string]interface{}, []interface{}:
+				return fmt.Errorf("config %s: nested values in lists not supported", prefix)
+			}
+			out[prefix] = append(out[prefix], configValue(v2))
+		}
+	case nil:
+	default:
+		out[prefix] = append(out[prefix], configValue(t))
+	}
+	return nil
+}
+
+// LoadConfigFlags reads the YAML (or JSON) config file at path and sets
+// the flags in fs from it. Config keys are flag names and nested maps
+// are joined with hyphens (so "webhook: {url: ...}" sets -webhook-url).
+// Lists set a flag multiple times. Environment variables are
+// interpolated into string values using ${NAME} syntax after parsing.
+// Flags explicitly set on the command line take precedence over the
+// config file.
+func LoadConfigFlags(fs *flag.FlagSet, path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var config map[string]interface{}
+	if err := yaml.Unmarshal(b, &config); err != nil {
+		return fmt.Errorf("parsing config: %w", err)
+	}
+	values := make(map[string][]string)
+	if err := flattenConfig("", config, values); err != nil {
+		return err
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var errs []string
+	for _, name := range names {
+		if fs.Lookup(name) == nil {
+			errs = append(errs, "unknown config key: "+name)
+			continue
+		}
+		if set[name] {
+			continue
+		}
+		for _, v := range values[name] {
+			if err := fs.Set(name, v); err != nil {
+				errs = append(errs, fmt.Sprintf("config %s: %v", name, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
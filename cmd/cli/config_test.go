@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInterpolateEnv(t *testing.T) {
+	os.Setenv("NANOMDM_TEST_VAR", "hello")
+	defer os.Unsetenv("NANOMDM_TEST_VAR")
+	for _, test := range []struct {
+		in  string
+		out string
+	}{
+		{"a ${NANOMDM_TEST_VAR} b", "a hello b"},
+		{"${NANOMDM_TEST_UNSET}", ""},
+		{"${NANOMDM_TEST_UNSET:-def}", "def"},
+		{"$$NOT_A_VAR", "$NOT_A_VAR"},
+	} {
+		if have, want := string(InterpolateEnv([]byte(test.in))), test.out; have != want {
+			t.Errorf("InterpolateEnv(%q): have %q, want %q", test.in, have, want)
+		}
+	}
+}
+
+func TestLoadConfigFlags(t *testing.T) {
+	config := `
+listen: ":9001"
+debug: true
+storage: [file, mysql]
+webhook:
+  url: http://example.com/${NANOMDM_TEST_UNSET:-hook}
+api: ${NANOMDM_TEST_INJECT}
+`
+	// values must not be able to inject config keys
+	os.Setenv("NANOMDM_TEST_INJECT", "key\ndebug: false # x")
+	defer os.Unsetenv("NANOMDM_TEST_INJECT")
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	var storage StringAccumulator
+	fs.Var(&storage, "storage", "")
+	listen := fs.String("listen", ":9000", "")
+	debug := fs.Bool("debug", false, "")
+	webhook := fs.String("webhook-url", "", "")
+	api := fs.String("api", "", "")
+	if err := fs.Parse([]string{"-listen", ":9002"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadConfigFlags(fs, path); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := *listen, ":9002"; have != want {
+		t.Errorf("command-line flag should take precedence: have %q, want %q", have, want)
+	}
+	if !*debug {
+		t.Error("debug not set")
+	}
+	if have, want := *webhook, "http://example.com/hook"; have != want {
+		t.Errorf("webhook-url: have %q, want %q", have, want)
+	}
+	if have, want := storage.String(), "file,mysql"; have != want {
+		t.Errorf("storage: have %q, want %q", have, want)
+	}
+	if have, want := *api, "key\ndebug: false # x"; have != want {
+		t.Errorf("api: have %q, want %q", have, want)
+	}
+}
+
+func TestSetFlagsFromEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := ioutil.WriteFile(path, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("NANOMDM_TEST_WEBHOOK_URL", "http://example.com/")
+	os.Setenv("NANOMDM_TEST_DSN_FILE", path)
+	os.Setenv("NANOMDM_TEST_API_KEY", "key")
+	defer os.Unsetenv("NANOMDM_TEST_WEBHOOK_URL")
+	defer os.Unsetenv("NANOMDM_TEST_DSN_FILE")
+	defer os.Unsetenv("NANOMDM_TEST_API_KEY")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	webhook := fs.String("webhook-url", "", "")
+	dsn := fs.String("dsn", "", "")
+	api := fs.String("api", "", "")
+	if err := SetFlagsFromEnv(fs, "NANOMDM_TEST", map[string]string{"api": "NANOMDM_TEST_API_KEY"}); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := *webhook, "http://example.com/"; have != want {
+		t.Errorf("webhook-url: have %q, want %q", have, want)
+	}
+	if have, want := *dsn, "s3cret"; have != want {
+		t.Errorf("dsn: have %q, want %q", have, want)
+	}
+	if have, want := *api, "key"; have != want {
+		t.Errorf("api: have %q, want %q", have, want)
+	}
+}
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// EnvName returns the environment variable name for flag name using
+// prefix. For example the "webhook-url" flag with a prefix of
+// "NANOMDM" is "NANOMDM_WEBHOOK_URL".
+func EnvName(prefix, name string) string {
+	return prefix + "_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// SetFlagsFromEnv sets any flags in fs not already set from environment
+// variables named by EnvName. If the variable is not set but a variable
+// of the same name with a "_FILE" suffix is then the flag is set from
+// the contents of that file (with trailing newlines trimmed) which is
+// useful for container secrets. Additional environment variable names
+// for flags can be given in aliases which maps flag names to variable
+// names.
+func SetFlagsFromEnv(fs *flag.FlagSet, prefix string, aliases map[string]string) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	var errs []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] {
+			return
+		}
+		names := []string{EnvName(prefix, f.Name)}
+		if alias, ok := aliases[f.Name]; ok {
+			names = append(names, alias)
+		}
+		for _, name := range names {
+			value, ok, err := lookupEnvOrFile(name)
+			if err != nil {
+				errs = append(errs, err.Error())
+				return
+			}
+			if !ok {
+				continue
+			}
+			if err := fs.Set(f.Name, value); err != nil {
+				errs = append(errs, fmt.Sprintf("env %s: %v", name, err))
+			}
+			return
+		}
+	})
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// lookupEnvOrFile looks up the environment variable name or, failing
+// that, reads the file named by the name+"_FILE" variable.
+func lookupEnvOrFile(name string) (string, bool, error) {
+	if value, ok := os.LookupEnv(name); ok {
+		return value, true, nil
+	}
+	path, ok := os.LookupEnv(name + "_FILE")
+	if !ok || path == "" {
+		return "", false, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("env %s_FILE: %w", name, err)
+	}
+	return string(bytes.TrimRight(b, "\r\n")), true, nil
+}
@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd.
+// See sd_listen_fds(3).
+const listenFdsStart = 3
+
+// Listen creates a network listener for addr. Addresses are one of:
+//
+//   - a TCP address such as ":9000" or "127.0.0.1:9000"
+//   - "unix:" followed by the path to a Unix domain socket
+//   - "systemd" or "systemd:N" to use the first (or Nth, zero-indexed)
+//     socket passed using systemd socket activation
+//
+// Any stale Unix domain socket file is removed before listening.
+func Listen(addr string) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		path := strings.TrimPrefix(addr, "unix:")
+		if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(path); err != nil {
+				return nil, err
+			}
+		}
+		return net.Listen("unix", path)
+	case addr == "systemd" || strings.HasPrefix(addr, "systemd:"):
+		n := 0
+		if idx := strings.TrimPrefix(addr, "systemd"); idx != "" {
+			var err error
+			if n, err = strconv.Atoi(idx[1:]); err != nil {
+				return nil, fmt.Errorf("invalid systemd socket index: %w", err)
+			}
+		}
+		return systemdListener(n)
+	default:
+		return net.Listen("tcp", addr)
+	}
+}
+
+// systemdListener returns the nth socket passed to us via systemd
+// socket activation.
+func systemdListener(n int) (net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, errors.New("no sockets passed by systemd (LISTEN_PID)")
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, errors.New("no sockets passed by systemd (LISTEN_FDS)")
+	}
+	if n < 0 || n >= fds {
+		return nil, fmt.Errorf("systemd socket index %d out of range (%d passed)", n, fds)
+	}
+	f := os.NewFile(uintptr(listenFdsStart+n), "systemd-socket-"+strconv.Itoa(n))
+	defer f.Close()
+	return net.FileListener(f)
+}
@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/vault"
+)
+
+// VaultRef is a flag whose value was resolved from a Vault secret
+// reference.
+type VaultRef struct {
+	Ref   string
+	Value string
+	Lease time.Duration
+}
+
+// HasVaultRefs returns true if any flag in fs has a Vault secret
+// reference value (see vault.RefPrefix).
+func HasVaultRefs(fs *flag.FlagSet) bool {
+	var has bool
+	fs.VisitAll(func(f *flag.Flag) {
+		if acc, ok := f.Value.(*StringAccumulator); ok {
+			for _, v := range *acc {
+				has = has || vault.IsRef(v)
+			}
+		} else if vault.IsRef(f.Value.String()) {
+			has = true
+		}
+	})
+	return has
+}
+
+// ResolveVaultFlags sets the flags in fs with Vault secret reference
+// values to the referenced secrets. The resolved references are
+// returned by flag name so they may be watched for rotation (except
+// those of repeated flags like -dsn which are only resolved).
+func ResolveVaultFlags(ctx context.Context, fs *flag.FlagSet, client *vault.Client) (map[string]*VaultRef, error) {
+	refs := make(map[string]*VaultRef)
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		// resolve repeated flag values in place
+		if acc, ok := f.Value.(*StringAccumulator); ok {
+			for i, ref := range *acc {
+				if !vault.IsRef(ref) {
+					continue
+				}
+				if (*acc)[i], _, err = client.Lookup(ctx, ref); err != nil {
+					err = fmt.Errorf("flag %s: %w", f.Name, err)
+					return
+				}
+			}
+			return
+		}
+		ref := f.Value.String()
+		if !vault.IsRef(ref) {
+			return
+		}
+		r := &VaultRef{Ref: ref}
+		if r.Value, r.Lease, err = client.Lookup(ctx, ref); err != nil {
+			err = fmt.Errorf("flag %s: %w", f.Name, err)
+			return
+		}
+		if err = fs.Set(f.Name, r.Value); err != nil {
+			err = fmt.Errorf("flag %s: %w", f.Name, err)
+			return
+		}
+		refs[f.Name] = r
+	})
+	return refs, err
+}
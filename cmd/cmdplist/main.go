@@ -0,0 +1,124 @@
+// Command cmdplist generates raw MDM command plists suitable for
+// sending to the NanoMDM enqueue API.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"text/tabwriter"
+
+	"github.com/jessepeterson/nanomdm/cmdplist"
+)
+
+// overridden by -ldflags -X
+var version = "unknown"
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] <command> [command flags]\n\nflags:\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintf(flag.CommandLine.Output(), "\nuse -list to see available commands\n")
+}
+
+func readFileOrStdin(path string) ([]byte, error) {
+	if path == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(path)
+}
+
+func main() {
+	var (
+		flUUID    = flag.String("uuid", "", "command UUID (auto-generated if not specified)")
+		flJSON    = flag.String("json", "", "path to JSON object merged into the command (\"-\" for stdin)")
+		flList    = flag.Bool("list", false, "list available commands")
+		flVersion = flag.Bool("version", false, "print version")
+	)
+	flag.Usage = usage
+	flag.Parse()
+
+	if *flVersion {
+		fmt.Println(version)
+		return
+	}
+
+	if *flList {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, name := range cmdplist.Names() {
+			fmt.Fprintf(w, "%s\t%s\n", name, cmdplist.Lookup(name).Usage)
+		}
+		w.Flush()
+		return
+	}
+
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	spec := cmdplist.Lookup(flag.Arg(0))
+	if spec == nil {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", flag.Arg(0))
+		os.Exit(2)
+	}
+
+	// setup per-command flags from the catalog
+	fs := flag.NewFlagSet(spec.Name(), flag.ExitOnError)
+	strArgs := make(map[string]*string)
+	for _, arg := range spec.Args {
+		usage := arg.Usage
+		if arg.Required {
+			usage += " (required)"
+		}
+		strArgs[arg.Key] = fs.String(arg.Flag(), "", usage)
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "%s: %s\n", spec.RequestType, spec.Usage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(flag.Args()[1:])
+
+	args := make(map[string]string)
+	data := make(map[string][]byte)
+	for _, arg := range spec.Args {
+		v := *strArgs[arg.Key]
+		if v == "" {
+			continue
+		}
+		if arg.Type == cmdplist.Data {
+			b, err := readFileOrStdin(v)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "reading %s: %v\n", arg.Flag(), err)
+				os.Exit(1)
+			}
+			data[arg.Key] = b
+			continue
+		}
+		args[arg.Key] = v
+	}
+
+	var js []byte
+	if *flJSON != "" {
+		var err error
+		if js, err = readFileOrStdin(*flJSON); err != nil {
+			fmt.Fprintf(os.Stderr, "reading JSON: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	cmd, err := spec.BuildJSON(args, data, js)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *flUUID != "" {
+		cmd.CommandUUID = *flUUID
+	}
+
+	b, err := cmd.Plist()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Stdout.Write(b)
+}
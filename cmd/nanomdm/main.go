@@ -1,75 +1,255 @@
 package main
 
 import (
-	"crypto/subtle"
+	"context"
+	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	stdlog "log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/jessepeterson/nanomdm"
+	"github.com/jessepeterson/nanomdm/blob"
+	"github.com/jessepeterson/nanomdm/breaker"
+	"github.com/jessepeterson/nanomdm/bus"
 	"github.com/jessepeterson/nanomdm/certverify"
+	"github.com/jessepeterson/nanomdm/chaos"
 	"github.com/jessepeterson/nanomdm/cmd/cli"
+	"github.com/jessepeterson/nanomdm/cryptoutil"
 	mdmhttp "github.com/jessepeterson/nanomdm/http"
 	"github.com/jessepeterson/nanomdm/log"
 	"github.com/jessepeterson/nanomdm/log/stdlogfmt"
 	"github.com/jessepeterson/nanomdm/push/buford"
-	pushsvc "github.com/jessepeterson/nanomdm/push/service"
-	"github.com/jessepeterson/nanomdm/service"
+	"github.com/jessepeterson/nanomdm/secevent"
 	"github.com/jessepeterson/nanomdm/service/certauth"
-	"github.com/jessepeterson/nanomdm/service/dump"
+	"github.com/jessepeterson/nanomdm/service/compliance"
+	"github.com/jessepeterson/nanomdm/service/deliverywindow"
+	"github.com/jessepeterson/nanomdm/service/forward"
+	"github.com/jessepeterson/nanomdm/service/guard"
+	"github.com/jessepeterson/nanomdm/service/identityrotation"
 	"github.com/jessepeterson/nanomdm/service/microwebhook"
-	"github.com/jessepeterson/nanomdm/service/multi"
-	"github.com/jessepeterson/nanomdm/service/nanomdm"
+	nanosvc "github.com/jessepeterson/nanomdm/service/nanomdm"
+	"github.com/jessepeterson/nanomdm/service/pushrepair"
+	"github.com/jessepeterson/nanomdm/service/reject"
+	"github.com/jessepeterson/nanomdm/service/setup"
+	"github.com/jessepeterson/nanomdm/service/topic"
+	"github.com/jessepeterson/nanomdm/service/workflow"
+	"github.com/jessepeterson/nanomdm/storage/mysql"
+	"github.com/jessepeterson/nanomdm/tokenauth"
+	"github.com/jessepeterson/nanomdm/vault"
 )
 
 // overridden by -ldflags -X
 var version = "unknown"
 
-const (
-	endpointMDM     = "/mdm"
-	endpointCheckin = "/checkin"
-
-	endpointAPIPushCert  = "/v1/pushcert"
-	endpointAPIPush      = "/v1/push/"
-	endpointAPIEnqueue   = "/v1/enqueue/"
-	endpointAPIMigration = "/migration"
-)
-
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "shell" {
+		if err := runShell(os.Args[2:]); err != nil {
+			stdlog.Fatal(err)
+		}
+		return
+	}
+
 	cliStorage := cli.NewStorage()
 	flag.Var(&cliStorage.Storage, "storage", "name of storage system")
 	flag.Var(&cliStorage.DSN, "dsn", "data source name (e.g. connection string or path)")
+	var flAPIAllow cli.StringAccumulator
+	flag.Var(&flAPIAllow, "api-allow", "restrict API endpoints to CIDR network(s), comma-separated or repeated")
+	var flTrustedProxies cli.StringAccumulator
+	flag.Var(&flTrustedProxies, "trusted-proxies", "only accept reverse proxy headers (cert, client IP, X-Forwarded-For, X-Request-Id) from CIDR network(s), comma-separated or repeated")
+	var flFwdHeaders cli.StringAccumulator
+	flag.Var(&flFwdHeaders, "forward-header", "set a \"Name: value\" HTTP header on forwarded requests (repeatable)")
+	var flHeaders, flAPIHeaders cli.StringAccumulator
+	flag.Var(&flHeaders, "response-header", "set a \"Name: value\" HTTP header on all responses (repeatable)")
+	flag.Var(&flAPIHeaders, "api-response-header", "set a \"Name: value\" HTTP header on API responses (repeatable)")
 	var (
-		flListen     = flag.String("listen", ":9000", "HTTP listen address")
-		flAPIKey     = flag.String("api", "", "API key for API endpoints")
-		flVersion    = flag.Bool("version", false, "print version")
-		flRootsPath  = flag.String("ca", "", "path to CA cert for verification")
-		flWebhook    = flag.String("webhook-url", "", "URL to send requests to")
-		flCertHeader = flag.String("cert-header", "", "HTTP header containing URL-escaped TLS client certificate")
-		flDebug      = flag.Bool("debug", false, "log debug messages")
-		flDump       = flag.Bool("dump", false, "dump MDM requests and responses to stdout")
-		flDisableMDM = flag.Bool("disable-mdm", false, "disable MDM HTTP endpoint")
-		flCheckin    = flag.Bool("checkin", false, "enable separate HTTP endpoint for MDM check-ins")
-		flMigration  = flag.Bool("migration", false, "HTTP endpoint for enrollment migrations")
-		flRetro      = flag.Bool("retro", false, "Allow retroactive certificate-authorization association")
+		flListen      = flag.String("listen", ":9000", "HTTP listen address (or \"unix:/path\" or \"systemd\" for socket activation)")
+		flAPIKey      = flag.String("api", "", "API key for API endpoints")
+		flVersion     = flag.Bool("version", false, "print version")
+		flRootsPath   = flag.String("ca", "", "path to CA cert for verification")
+		flWebhook     = flag.String("webhook-url", "", "URL to send requests to")
+		flHookFormat  = flag.String("webhook-format", "micromdm", "webhook event format: micromdm or cloudevents")
+		flHookSource  = flag.String("webhook-source", "/nanomdm", "CloudEvents source of webhook events")
+		flHookFilter  = flag.String("webhook-filter", "", "only send webhook events matching this filter expression (e.g. 'status != Idle')")
+		flHookToken   = flag.String("webhook-token", "", "static bearer token to authenticate webhook requests with")
+		flHookOAuth   = flag.String("webhook-oauth-token-url", "", "OAuth 2.0 token URL to fetch webhook bearer tokens from with client credentials")
+		flHookClient  = flag.String("webhook-oauth-client-id", "", "OAuth 2.0 client ID of webhook client credentials")
+		flHookSecret  = flag.String("webhook-oauth-client-secret", "", "OAuth 2.0 client secret of webhook client credentials")
+		flHookScopes  = flag.String("webhook-oauth-scopes", "", "comma-separated OAuth 2.0 scopes of webhook client credentials")
+		flHookSign    = flag.String("webhook-signing-secret", "", "secret to sign HTTP webhook request bodies with (HMAC-SHA256)")
+		flHookCert    = flag.String("webhook-tls-cert", "", "path to PEM client certificate for mutual TLS to webhook receivers")
+		flHookKey     = flag.String("webhook-tls-key", "", "path to PEM private key of -webhook-tls-cert")
+		flHookCA      = flag.String("webhook-tls-ca", "", "path to PEM CA certificate(s) to verify webhook receivers with instead of the system roots")
+		flHookProxy   = flag.String("webhook-proxy", "", "HTTP(S) proxy URL to send webhook events through (default from HTTPS_PROXY and NO_PROXY)")
+		flFwdURL      = flag.String("forward-url", "", "MDM server URL of another (e.g. legacy) MDM server to forward copies of check-ins and command reports to")
+		flFwdCheckin  = flag.String("forward-checkin-url", "", "check-in URL of the other MDM server (defaults to -forward-url)")
+		flFwdMessages = flag.String("forward-messages", "", "comma-separated check-in message types, Idle, and command request types to forward (default all)")
+		flFwdCert     = flag.String("forward-cert-header", "", "HTTP header to send the URL-escaped device identity certificate to the other MDM server in")
+		flFwdProxy    = flag.String("forward-proxy", "", "HTTP(S) proxy URL to forward to the other MDM server through (default from HTTPS_PROXY and NO_PROXY)")
+		flCbProxy     = flag.String("callback-proxy", "", "HTTP(S) proxy URL to post command results to callback URLs through (default from HTTPS_PROXY and NO_PROXY)")
+		flCertHeader  = flag.String("cert-header", "", "HTTP header containing URL-escaped TLS client certificate")
+		flDebug       = flag.Bool("debug", false, "log debug messages")
+		flDebugAPI    = flag.Bool("debug-api", false, "serve pprof profiles, expvar variables, and a status summary at /debug/ (requires -api)")
+		flDump        = flag.Bool("dump", false, "dump MDM requests and responses to stdout")
+		flSecLog      = flag.String("security-log", "", "append security events as JSON lines to this file (\"-\" for stdout)")
+		flMaintenance = flag.Bool("maintenance", false, "start in maintenance mode: accept device requests without processing them and refuse API writes (switch at /v1/maintenance)")
+		flDisableMDM  = flag.Bool("disable-mdm", false, "disable MDM HTTP endpoint")
+		flCheckin     = flag.Bool("checkin", false, "enable separate HTTP endpoint for MDM check-ins")
+		flMigration   = flag.Bool("migration", false, "HTTP endpoint for enrollment migrations")
+		flRetro       = flag.Bool("retro", false, "Allow retroactive certificate-authorization association")
+		flConfig      = flag.String("config", "", "path to YAML config file (command-line flags take precedence)")
+		flTLSCert     = flag.String("tls-cert", "", "path to TLS certificate for the listener")
+		flTLSKey      = flag.String("tls-key", "", "path to TLS private key for the listener")
+		flAPIListen   = flag.String("api-listen", "", "separate HTTP listen address for API endpoints")
+		flAPITLSCert  = flag.String("api-tls-cert", "", "path to TLS certificate for the API listener")
+		flAPITLSKey   = flag.String("api-tls-key", "", "path to TLS private key for the API listener")
+		flPrefix      = flag.String("path-prefix", "", "URL path prefix for all endpoints")
+		flAPIPrefix   = flag.String("api-path-prefix", "", "URL path prefix for API endpoints (after any path-prefix)")
+		flMDMPath     = flag.String("mdm-path", mdmhttp.DefaultPaths.MDM, "URL path of the MDM endpoint")
+		flCheckinPath = flag.String("checkin-path", mdmhttp.DefaultPaths.Checkin, "URL path of the separate check-in endpoint")
+		flCompress    = flag.Bool("compress", false, "gzip MDM responses to clients that accept it")
+		flReplay      = flag.Duration("replay-window", 0, "reject Authenticate and TokenUpdate messages identical to one processed within this duration (e.g. 10m)")
+		flAuthFails   = flag.Int("auth-failure-limit", 0, "block client addresses after this many authentication failures (0 to disable)")
+		flAuthWindow  = flag.Duration("auth-failure-window", guard.DefaultWindow, "forget authentication failures after this duration")
+		flAuthBlock   = flag.Duration("auth-failure-max-block", guard.DefaultMaxBlock, "maximum duration of doubling authentication failure blocks")
+		flHSTS        = flag.Duration("hsts-max-age", 0, "send a Strict-Transport-Security header with this max-age (e.g. 8760h)")
+		flClientIP    = flag.String("client-ip-header", "", "HTTP header with the client address set by a reverse proxy (e.g. X-Real-IP)")
+		flCompliance  = flag.String("compliance-rules", "", "path to YAML compliance rules")
+		flSetupCmds   = flag.String("setup-commands", "", "path to YAML commands to enqueue (followed by DeviceConfigured) to devices awaiting configuration")
+		flSetupURL    = flag.String("setup-approval-url", "", "URL of a webhook that must approve releasing devices awaiting configuration")
+		flInventory   = flag.Bool("inventory", false, "collect device inventory from DeviceInformation and SecurityInfo results")
+		flOSUpdates   = flag.Bool("os-updates", false, "track OS update states and enable the OS update rollout API")
+		flAppInstall  = flag.Bool("app-installs", false, "track app install results and enable the app install and manifest APIs")
+		flManifestURL = flag.String("manifest-base-url", "", "public base URL of hosted app manifests (e.g. https://mdm.example.com)")
+		flAppInv      = flag.Duration("app-inventory", 0, "poll enrollments for installed applications at this interval (e.g. 24h)")
+		flQuota       = flag.Int("enrollment-limit", 0, "maximum number of enabled device enrollments (0 for unlimited)")
+		flTopicQuota  = flag.String("topic-enrollment-limits", "", "comma-separated APNs topic=limit maximum enabled device enrollments per topic")
+		flEnrollResp  = flag.String("enrollment-status-responses", "", "comma-separated state[.RequestType]=HTTP status responses to unknown or disabled enrollments (e.g. unknown=401)")
+		flStuckAfter  = flag.Duration("stuck-after", 0, "flag enrollments with pending commands not seen within this duration as stuck (e.g. 72h)")
+		flSoftDelete  = flag.Bool("soft-delete", false, "soft-delete enrollments on CheckOut so their queues can be restored on re-enrollment")
+		flQueueGC     = flag.Duration("queue-gc", 0, "purge the command queues of disabled (checked-out) enrollments at this interval (e.g. 24h)")
+		flQueueRetain = flag.Duration("queue-retention", 0, "with -queue-gc also purge the queues of enrollments not seen within this duration (e.g. 2160h)")
+		flQueueArch   = flag.String("queue-archive", "", "with -queue-gc append purged commands and results to this file as JSON lines")
+		flQueuePolicy = flag.String("queue-policy", "", "choose the next command among -queue-window queued commands: \"fifo\", \"priority\", or \"type\" (grouped by request type)")
+		flQueueWindow = flag.Int("queue-window", nanosvc.DefaultQueueWindow, "number of queued commands considered by -queue-policy per Connect")
+		flQueuePrio   = flag.String("queue-priorities", "", "with -queue-policy priority, comma-separated request type priorities (e.g. DeviceLock=10,InstallProfile=5)")
+		flCmdLimit    = flag.Int("connect-command-limit", 0, "maximum commands delivered per device Connect session (0 is unlimited)")
+		flRateLimit   = flag.Int("command-rate-limit", 0, "maximum commands delivered per enrollment per hour (0 is unlimited)")
+		flRateOverr   = flag.String("command-rate-limit-overrides", "", "comma-separated request types delivered despite -command-rate-limit (e.g. DeviceLock,EraseDevice)")
+		flWindows     = flag.String("delivery-windows", "", "path to YAML delivery windows outside of which only urgent commands are delivered and pushed")
+		flFollowUps   = flag.String("follow-up-rules", "", "path to YAML rules of follow-up commands to enqueue after command reports")
+		flFollowDepth = flag.Int("follow-up-depth", nanosvc.DefaultFollowUpDepth, "maximum chain of follow-ups to follow-up commands")
+		flBusURL      = flag.String("bus-url", "", "Redis URL (redis:// or rediss://) of the notification bus shared by instances")
+		flLostMode    = flag.String("lost-mode-api-key", "", "dedicated API key for the Lost Mode API (enables Lost Mode)")
+		flEscrowKey   = flag.String("escrow-key", "", "hex or base64 AES-256 key to escrow Activation Lock bypass codes with (enables escrow)")
+		flUnlockToken = flag.Bool("unlock-tokens", false, "escrow Unlock Tokens sealed with the escrow key and enable the clear passcode API")
+		flDevicePw    = flag.Bool("device-passwords", false, "manage recovery lock and firmware passwords sealed with the escrow key and enable the device password API")
+		flDevicePwRot = flag.Duration("device-password-rotation", 0, "rotate set device passwords at this interval (e.g. 720h)")
+		flRotProfile  = flag.String("identity-rotation-profile", "", "path to a profile (e.g. with a SCEP payload) installed to rotate MDM identities (enables the identity rotation API)")
+		flRotTimeout  = flag.Duration("identity-rotation-timeout", identityrotation.DefaultTimeout, "fail identity rotations not completed within this duration")
+		flWorkflows   = flag.String("workflows", "", "path to YAML workflows (command sequences) to enable the workflows API")
+		flDecommID    = flag.String("decommission-profile-id", "", "PayloadIdentifier of the MDM enrollment profile to remove when decommissioning devices (enables the decommission API)")
+		flAuthTokens  = flag.String("auth-tokens", "", "path to YAML static bearer tokens authenticating account-driven User Enrollments without client certificates")
+		flIntrospect  = flag.String("token-introspection-url", "", "OAuth 2.0 token introspection URL of the identity provider validating bearer tokens")
+		flIntroID     = flag.String("token-introspection-client-id", "", "OAuth 2.0 client ID of the token introspection endpoint")
+		flIntroSecret = flag.String("token-introspection-client-secret", "", "OAuth 2.0 client secret of the token introspection endpoint")
+		flIntroProxy  = flag.String("token-introspection-proxy", "", "HTTP(S) proxy URL to connect to the token introspection endpoint through (default from HTTPS_PROXY and NO_PROXY)")
+		flEnrollProf  = flag.String("enroll-profile", "", "path to an enrollment profile to serve to devices")
+		flSignCert    = flag.String("response-signing-cert", "", "path to a PEM certificate (chain) to sign MDM responses and profiles with")
+		flSignKey     = flag.String("response-signing-key", "", "path to the PEM private key (or key reference) of the response signing certificate")
+		flEnrollAuth  = flag.String("enroll-auth-url", "", "URL of a web page devices authenticate the user at (for bearer tokens) before the enrollment profile is served")
+		flCheckinHook = flag.String("checkin-hook-url", "", "URL of a webhook that may reject Authenticate and TokenUpdate check-ins with a 4xx response")
+		flTopicCheck  = flag.String("topic-check", "", "validate enrollment APNs topics against stored push certs: \"log\" or \"reject\" mismatches")
+		flStrict      = flag.String("strict-plist", "", "strictly validate check-in and command result plists: \"log\" or \"reject\" invalid ones")
+		flKEK         = flag.String("storage-kek", "", "hex or base64 AES-256 key encryption key for sensitive SQL storage columns and file storage plists and push keys (enables encryption at rest)")
+		flKEKPrevious = flag.String("storage-kek-previous", "", "comma-separated previous storage KEKs still used for decryption")
+		flKEKRotate   = flag.Bool("storage-kek-rotate", false, "re-encrypt sensitive SQL storage columns with the storage KEK at startup")
+		flQueue       = flag.String("queue-storage", "", "command queue storage separate from the -storage enrollment storage: memory (default is the -storage queue)")
+		flDualWrite   = flag.Bool("storage-dual-write", false, "write to both of two storage backends and compare their results to verify migrating from the first to the second")
+		flChaos       = flag.Bool("chaos", false, "inject faults for resilience testing (never in production)")
+		flChaosTarget = flag.String("chaos-targets", "storage,push", "with -chaos, comma-separated targets to inject faults into: storage and/or push")
+		flChaosDelay  = flag.Duration("chaos-latency", 0, "with -chaos, maximum random latency added to calls")
+		flChaosErrors = flag.Float64("chaos-error-rate", 0, "with -chaos, probability (0 to 1) of calls (or individual pushes) failing")
+		flMaxOpen     = flag.Int("storage-max-open-conns", 0, "maximum open SQL storage connections (0 is unlimited)")
+		flMaxIdle     = flag.Int("storage-max-idle-conns", 0, "maximum idle SQL storage connections (0 is the default of 2)")
+		flConnLife    = flag.Duration("storage-conn-max-lifetime", 0, "close SQL storage connections open longer than this duration (e.g. 5m)")
+		flConnIdle    = flag.Duration("storage-conn-max-idle-time", 0, "close SQL storage connections idle longer than this duration (e.g. 1m)")
+		flConnRetry   = flag.Duration("storage-connect-retry", 0, "retry connecting to SQL storage at startup with backoff for up to this duration (e.g. 2m)")
+		flHealthInt   = flag.Duration("storage-health-check", 0, "ping SQL storage at this interval (e.g. 30s) and reset its connection pool after persistent failures")
+		flHealthFails = flag.Int("storage-health-check-failures", mysql.DefaultHealthCheckFailures, "consecutive failed -storage-health-check pings after which the connection pool is reset")
+		flSlowQuery   = flag.Duration("storage-slow-query", 0, "log SQL storage queries taking at least this duration (e.g. 500ms) and count queries per storage method")
+		flAPIClientCA = flag.String("api-client-ca", "", "path to CA cert for required API TLS client certificates")
+		flTopicReport = flag.Bool("topic-report", false, "print the reconciliation of enrollment topics and push certificates as JSON and exit")
+		flOffloadSize = flag.Int("result-offload-size", 0, "store raw command results larger than this many bytes in blob storage instead of inline (0 disables)")
+		flOffloadURL  = flag.String("result-offload-url", "", "with -result-offload-size, a directory or http(s) URL (blobs are PUT to it) to store large command results in")
+		flOffloadBase = flag.String("result-offload-base-url", "", "URL prefix of command results stored in a -result-offload-url directory (default the blob API path)")
+		flBlobProxy   = flag.String("result-offload-proxy", "", "HTTP(S) proxy URL to PUT command results to an http(s) -result-offload-url through (default from HTTPS_PROXY and NO_PROXY)")
+		flVaultProxy  = flag.String("vault-proxy", "", "HTTP(S) proxy URL to connect to Vault through (default from HTTPS_PROXY and NO_PROXY)")
+		flPushRepair  = flag.Bool("push-repair-report", false, "print the enrollments with missing or stale push info as JSON and exit")
+		flPushStale   = flag.Duration("push-stale-after", 0, "report enrollments not seen within this duration as having stale push info (e.g. 720h)")
+		flStoreTO     = flag.Duration("storage-timeout", 0, "deadline of the storage (and other) calls of device check-ins and command reports (e.g. 30s)")
+		flPushTO      = flag.Duration("push-timeout", 0, "deadline of APNs pushes including retrieving their push info (e.g. 30s)")
+		flPushProxy   = flag.String("push-proxy", "", "HTTP(S) proxy URL to connect to APNs through (default from HTTPS_PROXY and NO_PROXY)")
+		flHookTO      = flag.Duration("webhook-timeout", 0, "deadline of sending each webhook event (e.g. 30s)")
+		flHookLimit   = flag.Int("webhook-payload-limit", 0, "omit or truncate the raw payloads of webhook events larger than this many bytes (0 disables)")
+		flHookPolicy  = flag.String("webhook-payload-policy", "omit", "with -webhook-payload-limit, omit or truncate large raw payloads")
+		flCBFailures  = flag.Int("circuit-breaker-failures", 0, "open the storage, push, and webhook circuit breakers after this many consecutive failures (0 disables)")
+		flCBCooldown  = flag.Duration("circuit-breaker-cooldown", 30*time.Second, "duration an open circuit breaker fails calls before probing again")
 	)
 	flag.Parse()
 
+	// environment variables take precedence over the config file but
+	// not over command-line flags.
+	if err := cli.SetFlagsFromEnv(flag.CommandLine, "NANOMDM", map[string]string{"api": "NANOMDM_API_KEY"}); err != nil {
+		stdlog.Fatal(err)
+	}
+
+	if *flConfig != "" {
+		if err := cli.LoadConfigFlags(flag.CommandLine, *flConfig); err != nil {
+			stdlog.Fatal(err)
+		}
+	}
+
 	if *flVersion {
 		fmt.Println(version)
 		return
 	}
 
-	if *flDisableMDM && *flAPIKey == "" {
-		stdlog.Fatal("nothing for server to do")
-	}
-
 	logger := stdlogfmt.New(stdlog.Default(), *flDebug)
 
+	// resolve any "vault:" secret references in flags
+	var vaultClient *vault.Client
+	var vaultRefs map[string]*cli.VaultRef
+	if cli.HasVaultRefs(flag.CommandLine) {
+		vaultProxy, err := parseProxy(*flVaultProxy)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		vaultOpts := []vault.Option{vault.WithLogger(logger.With("component", "vault"))}
+		if vaultProxy != nil {
+			vaultOpts = append(vaultOpts, vault.WithHTTPClient(proxyClient(vaultProxy, 0)))
+		}
+		vaultClient, err = vault.NewFromEnv(vaultOpts...)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		vaultRefs, err = cli.ResolveVaultFlags(context.Background(), flag.CommandLine, vaultClient)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+	}
+
 	if *flRootsPath == "" {
 		stdlog.Fatal("must supply CA cert path flag")
 	}
@@ -82,131 +262,632 @@ func main() {
 		stdlog.Fatal(err)
 	}
 
+	if *flKEK != "" {
+		keys := []string{*flKEK}
+		if *flKEKPrevious != "" {
+			keys = append(keys, strings.Split(*flKEKPrevious, ",")...)
+		}
+		cliStorage.Envelope, err = cryptoutil.ParseLocalEnvelope(keys...)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		cliStorage.RotateSecrets = *flKEKRotate
+	}
+
+	cliStorage.DualWrite = *flDualWrite
+	cliStorage.Queue = *flQueue
+	cliStorage.MaxOpenConns = *flMaxOpen
+	cliStorage.MaxIdleConns = *flMaxIdle
+	cliStorage.ConnMaxLifetime = *flConnLife
+	cliStorage.ConnMaxIdleTime = *flConnIdle
+	cliStorage.SlowQuery = *flSlowQuery
+	cliStorage.ConnectRetry = *flConnRetry
+	cliStorage.HealthCheckInterval = *flHealthInt
+	cliStorage.HealthCheckFailures = *flHealthFails
 	mdmStorage, err := cliStorage.Parse(logger)
 	if err != nil {
 		stdlog.Fatal(err)
 	}
 
-	// create 'core' MDM service
-	nano := nanomdm.New(mdmStorage, logger.With("service", "nanomdm"))
+	if *flTopicReport {
+		report, err := topic.Reconcile(context.Background(), mdmStorage, mdmStorage, time.Now())
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(report); err != nil {
+			stdlog.Fatal(err)
+		}
+		return
+	}
 
-	mux := http.NewServeMux()
+	if *flPushRepair {
+		found, err := pushrepair.New(mdmStorage, pushrepair.WithStaleAfter(*flPushStale)).Detect(context.Background())
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(found); err != nil {
+			stdlog.Fatal(err)
+		}
+		return
+	}
 
-	if !*flDisableMDM {
-		var mdmService service.CheckinAndCommandService = nano
-		if *flWebhook != "" {
-			webhookService := microwebhook.New(*flWebhook)
-			mdmService = multi.New(logger.With("service", "multi"), mdmService, webhookService)
+	var faults *chaos.Faults
+	chaosTargets := make(map[string]bool)
+	if *flChaos {
+		if *flChaosErrors < 0 || *flChaosErrors > 1 {
+			stdlog.Fatal("chaos error rate must be between 0 and 1")
 		}
-		certAuthOpts := []certauth.Option{certauth.WithLogger(logger.With("service", "certauth"))}
-		if *flRetro {
-			certAuthOpts = append(certAuthOpts, certauth.WithAllowRetroactive())
+		faults = &chaos.Faults{Latency: *flChaosDelay, ErrorRate: *flChaosErrors}
+		for _, target := range strings.Split(*flChaosTarget, ",") {
+			if target != "storage" && target != "push" {
+				stdlog.Fatalf("unknown chaos target: %s", target)
+			}
+			chaosTargets[target] = true
 		}
-		mdmService = certauth.New(mdmService, mdmStorage, certAuthOpts...)
-		if *flDump {
-			mdmService = dump.New(mdmService, os.Stdout)
+		logger.Info("msg", "CHAOS: injecting faults", "targets", *flChaosTarget, "latency", faults.Latency, "error_rate", faults.ErrorRate)
+		if chaosTargets["storage"] {
+			mdmStorage = chaos.NewStorage(mdmStorage, faults)
 		}
+	}
 
-		// register 'core' MDM HTTP handler
-		var mdmHandler http.Handler
-		if *flCheckin {
-			// if we use the check-in handler then only handle commands
-			mdmHandler = mdmhttp.CommandAndReportResultsHandlerFunc(mdmService, logger.With("handler", "command"))
-		} else {
-			// if we don't use a check-in handler then do both
-			mdmHandler = mdmhttp.CheckinAndCommandHandlerFunc(mdmService, logger.With("handler", "checkin-command"))
+	paths := mdmhttp.DefaultPaths
+	paths.MDM = *flMDMPath
+	paths.Checkin = *flCheckinPath
+	paths = paths.WithAPIPrefix(*flAPIPrefix).WithPrefix(*flPrefix)
+
+	opts := []nanomdm.Option{
+		nanomdm.WithLogger(logger),
+		nanomdm.WithPaths(paths),
+		nanomdm.WithVersion(version),
+	}
+	var apiKey atomic.Value
+	apiKey.Store(*flAPIKey)
+	if vaultRefs["api"] != nil {
+		// the API key may be rotated in Vault
+		opts = append(opts, nanomdm.WithAPIKeyFunc(func() string { return apiKey.Load().(string) }))
+	} else {
+		opts = append(opts, nanomdm.WithAPIKey(*flAPIKey))
+	}
+	if *flDisableMDM {
+		opts = append(opts, nanomdm.WithoutMDM())
+	}
+	var webhookOpts []microwebhook.Option
+	if *flOffloadSize > 0 {
+		var store blob.Store
+		switch {
+		case *flOffloadURL == "":
+			stdlog.Fatal("must supply result offload URL or directory")
+		case strings.HasPrefix(*flOffloadURL, "http://"), strings.HasPrefix(*flOffloadURL, "https://"):
+			blobProxy, err := parseProxy(*flBlobProxy)
+			if err != nil {
+				stdlog.Fatal(err)
+			}
+			store = blob.NewHTTP(*flOffloadURL, proxyClient(blobProxy, 0))
+		default:
+			baseURL := *flOffloadBase
+			if baseURL == "" {
+				baseURL = paths.Blobs
+			}
+			if store, err = blob.NewDir(*flOffloadURL, baseURL); err != nil {
+				stdlog.Fatal(err)
+			}
+		}
+		opts = append(opts, nanomdm.WithResultOffload(store, *flOffloadSize))
+		webhookOpts = append(webhookOpts, microwebhook.WithOffload(blob.NewOffloader(store, *flOffloadSize)))
+	}
+	switch {
+	case *flHookToken != "" && *flHookOAuth != "":
+		stdlog.Fatal("webhook token and OAuth token URL are mutually exclusive")
+	case *flHookToken != "":
+		webhookOpts = append(webhookOpts, microwebhook.WithTokenSource(microwebhook.StaticToken(*flHookToken)))
+	case *flHookOAuth != "":
+		var scopes []string
+		if *flHookScopes != "" {
+			scopes = strings.Split(*flHookScopes, ",")
+		}
+		webhookOpts = append(webhookOpts, microwebhook.WithTokenSource(
+			microwebhook.NewClientCredentials(*flHookOAuth, *flHookClient, *flHookSecret, scopes),
+		))
+	}
+	if *flHookSign != "" {
+		webhookOpts = append(webhookOpts, microwebhook.WithSigningSecret(*flHookSign))
+	}
+	var hookClient *http.Client
+	if *flHookCert != "" || *flHookKey != "" || *flHookCA != "" {
+		if hookClient, err = microwebhook.NewTLSClient(*flHookCert, *flHookKey, *flHookCA); err != nil {
+			stdlog.Fatal(err)
+		}
+		webhookOpts = append(webhookOpts, microwebhook.WithClient(hookClient))
+	}
+	hookProxy, err := parseProxy(*flHookProxy)
+	if err != nil {
+		stdlog.Fatal(err)
+	} else if hookProxy != nil {
+		webhookOpts = append(webhookOpts, microwebhook.WithProxy(hookProxy))
+	}
+	if *flHookTO > 0 {
+		webhookOpts = append(webhookOpts, microwebhook.WithTimeout(*flHookTO))
+	}
+	if *flHookLimit > 0 {
+		policy := microwebhook.PayloadPolicy(*flHookPolicy)
+		if policy != microwebhook.PayloadOmit && policy != microwebhook.PayloadTruncate {
+			stdlog.Fatalf("invalid webhook-payload-policy: %q", *flHookPolicy)
+		}
+		webhookOpts = append(webhookOpts, microwebhook.WithPayloadLimit(*flHookLimit, policy))
+	}
+	if *flCBFailures > 0 {
+		newBreaker := func(name string) *breaker.Breaker {
+			return breaker.New(name, *flCBFailures, *flCBCooldown, breaker.WithLogger(logger))
 		}
-		mdmHandler = mdmhttp.CertVerifyMiddleware(mdmHandler, verifier, logger.With("handler", "cert-verify"))
-		if *flCertHeader != "" {
-			mdmHandler = mdmhttp.CertExtractPEMHeaderMiddleware(mdmHandler, *flCertHeader, logger.With("handler", "cert-extract"))
-		} else {
-			mdmHandler = mdmhttp.CertExtractMdmSignatureMiddleware(mdmHandler, logger.With("handler", "cert-extract"))
+		webhookBreaker := newBreaker("webhook")
+		webhookOpts = append(webhookOpts, microwebhook.WithBreaker(webhookBreaker))
+		opts = append(opts, nanomdm.WithCircuitBreakers(newBreaker("storage"), newBreaker("push"), webhookBreaker))
+	}
+	switch *flHookFormat {
+	case "micromdm":
+	case "cloudevents":
+		webhookOpts = append(webhookOpts, microwebhook.WithCloudEvents(*flHookSource))
+	default:
+		stdlog.Fatalf("invalid webhook-format: %q", *flHookFormat)
+	}
+	if *flHookFilter != "" {
+		filter, err := microwebhook.ParseFilter(*flHookFilter)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		webhookOpts = append(webhookOpts, microwebhook.WithFilter(filter), microwebhook.WithRequestTypes(mdmStorage))
+	}
+	if len(webhookOpts) > 0 {
+		opts = append(opts, nanomdm.WithWebhookOptions(webhookOpts...))
+	}
+	if *flWebhook != "" {
+		webhook := microwebhook.New(*flWebhook, webhookOpts...)
+		if err := webhook.Err(); err != nil {
+			stdlog.Fatal(err)
+		}
+		opts = append(opts, nanomdm.WithServices(webhook))
+	}
+	if *flFwdURL != "" {
+		headers, err := mdmhttp.ParseHeaders(flFwdHeaders)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		fwdOpts := []forward.Option{
+			forward.WithLogger(logger.With("service", "forward")),
+			forward.WithCheckinURL(*flFwdCheckin),
+			forward.WithHeaders(headers),
+			forward.WithCertHeader(*flFwdCert),
+		}
+		fwdProxy, err := parseProxy(*flFwdProxy)
+		if err != nil {
+			stdlog.Fatal(err)
+		} else if fwdProxy != nil {
+			fwdOpts = append(fwdOpts, forward.WithClient(proxyClient(fwdProxy, 30*time.Second)))
 		}
-		mux.Handle(endpointMDM, mdmHandler)
+		if *flFwdMessages != "" {
+			fwdOpts = append(fwdOpts, forward.WithMessages(mdmStorage, strings.Split(*flFwdMessages, ",")...))
+		}
+		opts = append(opts, nanomdm.WithServices(forward.New(*flFwdURL, fwdOpts...)))
+	}
+	if *flRetro {
+		opts = append(opts, nanomdm.WithCertAuthOptions(certauth.WithAllowRetroactive()))
+	}
+	if *flDump {
+		opts = append(opts, nanomdm.WithDump(os.Stdout))
+	}
+	if *flSecLog != "" {
+		out := os.Stdout
+		if *flSecLog != "-" {
+			out, err = os.OpenFile(*flSecLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+			if err != nil {
+				stdlog.Fatal(err)
+			}
+			defer out.Close()
+		}
+		opts = append(opts, nanomdm.WithSecurityEvents(secevent.NewJSONWriter(out, func(err error) {
+			logger.Info("msg", "writing security event", "err", err)
+		})))
+	}
+	if *flCheckin {
+		opts = append(opts, nanomdm.WithSeparateCheckin())
+	}
+	if *flCertHeader != "" {
+		opts = append(opts, nanomdm.WithCertHeader(*flCertHeader))
+	}
+	if *flMigration {
+		opts = append(opts, nanomdm.WithMigration())
+	}
+	if *flCompress {
+		opts = append(opts, nanomdm.WithCompression())
+	}
+	if *flCompliance != "" {
+		rules, err := compliance.LoadRules(*flCompliance)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		opts = append(opts, nanomdm.WithCompliance(rules, *flWebhook))
+	}
+	if *flSetupCmds != "" || *flSetupURL != "" {
+		if *flSetupURL != "" {
+			if err := microwebhook.New(*flSetupURL, webhookOpts...).Err(); err != nil {
+				stdlog.Fatal(err)
+			}
+		}
+		var cmds []*setup.Command
+		if *flSetupCmds != "" {
+			var err error
+			if cmds, err = setup.LoadCommands(*flSetupCmds); err != nil {
+				stdlog.Fatal(err)
+			}
+		}
+		opts = append(opts, nanomdm.WithSetupRelease(cmds, *flSetupURL))
+	}
+	if *flInventory {
+		opts = append(opts, nanomdm.WithInventory())
+	}
+	if *flOSUpdates {
+		opts = append(opts, nanomdm.WithOSUpdates())
+	}
+	if *flAppInstall {
+		opts = append(opts, nanomdm.WithAppInstalls(*flManifestURL))
+	}
+	if *flAppInv > 0 {
+		opts = append(opts, nanomdm.WithAppInventory(*flAppInv, *flWebhook))
+	}
+	if *flSoftDelete {
+		opts = append(opts, nanomdm.WithSoftDelete())
+	}
+	if *flQueueGC > 0 {
+		opts = append(opts, nanomdm.WithQueueGC(*flQueueGC, *flQueueRetain, *flQueueArch))
+	}
+	switch *flQueuePolicy {
+	case "":
+	case "fifo":
+		opts = append(opts, nanomdm.WithQueuePolicy(nanosvc.FIFOPolicy, *flQueueWindow))
+	case "priority":
+		priorities, err := parseIntPairs(*flQueuePrio, "queue priority")
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		opts = append(opts, nanomdm.WithQueuePolicy(nanosvc.NewPriorityPolicy(priorities), *flQueueWindow))
+	case "type":
+		opts = append(opts, nanomdm.WithQueuePolicy(nanosvc.NewTypeGroupedPolicy(), *flQueueWindow))
+	default:
+		stdlog.Fatalf("invalid queue-policy: %q", *flQueuePolicy)
+	}
+	if *flCmdLimit > 0 {
+		opts = append(opts, nanomdm.WithCommandLimit(*flCmdLimit))
+	}
+	if *flRateLimit > 0 {
+		var overrides []string
+		if *flRateOverr != "" {
+			overrides = strings.Split(*flRateOverr, ",")
+		}
+		opts = append(opts, nanomdm.WithCommandRateLimit(*flRateLimit, time.Hour, overrides))
+	}
+	if *flWindows != "" {
+		config, err := deliverywindow.Load(*flWindows)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		opts = append(opts, nanomdm.WithDeliveryWindows(config))
+	}
+	if *flFollowUps != "" {
+		rules, err := nanosvc.LoadFollowUps(*flFollowUps)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		opts = append(opts, nanomdm.WithFollowUps(rules, *flFollowDepth))
+	}
+	if *flBusURL != "" {
+		b, err := bus.NewRedis(*flBusURL, bus.WithLogger(logger.With("service", "bus")))
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		opts = append(opts, nanomdm.WithBus(b))
+	}
+	topicLimits, err := parseIntPairs(*flTopicQuota, "topic enrollment limit")
+	if err != nil {
+		stdlog.Fatal(err)
+	}
+	if *flQuota > 0 || len(topicLimits) > 0 {
+		opts = append(opts, nanomdm.WithEnrollmentQuota(*flQuota, topicLimits, *flWebhook))
+	}
+	enrollResponses, err := parseIntPairs(*flEnrollResp, "enrollment status response")
+	if err != nil {
+		stdlog.Fatal(err)
+	}
+	if len(enrollResponses) > 0 {
+		opts = append(opts, nanomdm.WithEnrollmentStatusResponses(enrollResponses))
+	}
+	if *flPushStale > 0 {
+		opts = append(opts, nanomdm.WithPushRepairStaleAfter(*flPushStale))
+	}
 
-		if *flCheckin {
-			// if we specified a separate check-in handler, set it up
-			var checkinHandler http.Handler
-			checkinHandler = mdmhttp.CheckinHandlerFunc(mdmService, logger.With("handler", "checkin"))
-			checkinHandler = mdmhttp.CertVerifyMiddleware(checkinHandler, verifier, logger.With("handler", "cert-verify"))
-			if *flCertHeader != "" {
-				checkinHandler = mdmhttp.CertExtractPEMHeaderMiddleware(checkinHandler, *flCertHeader, logger.With("handler", "cert-extract"))
-			} else {
-				checkinHandler = mdmhttp.CertExtractMdmSignatureMiddleware(checkinHandler, logger.With("handler", "cert-extract"))
+	if *flStuckAfter > 0 {
+		opts = append(opts, nanomdm.WithStuckDetection(*flStuckAfter, *flWebhook))
+	}
+	if *flLostMode != "" {
+		opts = append(opts, nanomdm.WithLostMode(*flLostMode))
+	}
+	if *flEscrowKey != "" {
+		key, err := cryptoutil.ParseSealKey(*flEscrowKey)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		opts = append(opts, nanomdm.WithBypassCodeEscrow(key))
+	}
+	if *flUnlockToken {
+		if *flEscrowKey == "" {
+			stdlog.Fatal("unlock tokens require an escrow key")
+		}
+		key, err := cryptoutil.ParseSealKey(*flEscrowKey)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		opts = append(opts, nanomdm.WithUnlockTokenEscrow(key))
+	}
+	if *flDevicePw {
+		if *flEscrowKey == "" {
+			stdlog.Fatal("device passwords require an escrow key")
+		}
+		key, err := cryptoutil.ParseSealKey(*flEscrowKey)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		opts = append(opts, nanomdm.WithDevicePasswords(key, *flDevicePwRot))
+	}
+	if *flRotProfile != "" {
+		profile, err := ioutil.ReadFile(*flRotProfile)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		opts = append(opts, nanomdm.WithIdentityRotation(profile, *flRotTimeout))
+	}
+	if *flWorkflows != "" {
+		workflows, err := workflow.LoadWorkflows(*flWorkflows)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		opts = append(opts, nanomdm.WithWorkflows(workflows, *flWebhook))
+	}
+	if *flDecommID != "" {
+		opts = append(opts, nanomdm.WithDecommission(*flDecommID))
+	}
+	switch *flTopicCheck {
+	case "":
+	case "log", "reject":
+		opts = append(opts, nanomdm.WithTopicValidation(*flTopicCheck == "reject"))
+	default:
+		stdlog.Fatalf("invalid topic-check: %q", *flTopicCheck)
+	}
+	switch *flStrict {
+	case "":
+	case "log", "reject":
+		opts = append(opts, nanomdm.WithStrictPlistValidation(*flStrict == "reject"))
+	default:
+		stdlog.Fatalf("invalid strict-plist: %q", *flStrict)
+	}
+	switch {
+	case *flAuthTokens != "" && *flIntrospect != "":
+		stdlog.Fatal("auth tokens and token introspection URL are mutually exclusive")
+	case *flAuthTokens != "":
+		tokens, err := tokenauth.LoadStaticTokens(*flAuthTokens)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		opts = append(opts, nanomdm.WithTokenAuth(tokens))
+	case *flIntrospect != "":
+		introProxy, err := parseProxy(*flIntroProxy)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		var introOpts []tokenauth.IntrospectionOption
+		if introProxy != nil {
+			introOpts = append(introOpts, tokenauth.WithIntrospectionClient(proxyClient(introProxy, 0)))
+		}
+		opts = append(opts, nanomdm.WithTokenAuth(tokenauth.NewIntrospection(*flIntrospect, *flIntroID, *flIntroSecret, introOpts...)))
+	}
+	if *flSignCert != "" || *flSignKey != "" {
+		certPEM, err := ioutil.ReadFile(*flSignCert)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		keyPEM, err := ioutil.ReadFile(*flSignKey)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		pair, err := cryptoutil.X509KeyPair(context.Background(), certPEM, keyPEM)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		signer, err := cryptoutil.NewCMSSigner(pair)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		opts = append(opts, nanomdm.WithResponseSigning(signer))
+	}
+	if *flEnrollProf != "" {
+		profile, err := ioutil.ReadFile(*flEnrollProf)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		opts = append(opts, nanomdm.WithEnrollProfile(profile, *flEnrollAuth))
+	}
+	if *flCheckinHook != "" {
+		var hookOpts []reject.WebhookOption
+		if *flHookSign != "" {
+			hookOpts = append(hookOpts, reject.WithSigningSecret(*flHookSign))
+		}
+		if hookClient != nil || hookProxy != nil {
+			client := &http.Client{Timeout: 10 * time.Second}
+			if hookClient != nil {
+				client.Transport = hookClient.Transport
 			}
-			mux.Handle(endpointCheckin, checkinHandler)
-		}
-	}
-
-	if *flAPIKey != "" {
-		const apiUsername = "nanomdm"
-
-		// create our push provider and push service
-		pushProviderFactory := buford.NewPushProviderFactory()
-		pushService := pushsvc.New(mdmStorage, mdmStorage, pushProviderFactory, logger.With("service", "push"))
-
-		// register API handler for push cert storage/upload.
-		var pushCertHandler http.Handler
-		pushCertHandler = mdmhttp.StorePushCertHandlerFunc(mdmStorage, logger.With("handler", "store-cert"))
-		pushCertHandler = basicAuth(pushCertHandler, apiUsername, *flAPIKey, "nanomdm")
-		mux.Handle(endpointAPIPushCert, pushCertHandler)
-
-		// register API handler for push notifications.
-		// we strip the prefix to use the path as an id.
-		var pushHandler http.Handler
-		pushHandler = mdmhttp.PushHandlerFunc(pushService, logger.With("handler", "push"))
-		pushHandler = http.StripPrefix(endpointAPIPush, pushHandler)
-		pushHandler = basicAuth(pushHandler, apiUsername, *flAPIKey, "nanomdm")
-		mux.Handle(endpointAPIPush, pushHandler)
-
-		// register API handler for new command queueing.
-		// we strip the prefix to use the path as an id.
-		var enqueueHandler http.Handler
-		enqueueHandler = mdmhttp.RawCommandEnqueueHandler(mdmStorage, pushService, logger.With("handler", "enqueue"))
-		enqueueHandler = http.StripPrefix(endpointAPIEnqueue, enqueueHandler)
-		enqueueHandler = basicAuth(enqueueHandler, apiUsername, *flAPIKey, "nanomdm")
-		mux.Handle(endpointAPIEnqueue, enqueueHandler)
-
-		if *flMigration {
-			// setup a "migration" handler that takes Check-In messages
-			// without bothering with certificate auth or other
-			// middleware.
-			//
-			// if the source MDM can put together enough of an
-			// authenticate and tokenupdate message to effectively
-			// generate "enrollments" then this effively allows us to
-			// migrate MDM enrollments between servers.
-			var migHandler http.Handler
-			migHandler = mdmhttp.CheckinHandlerFunc(nano, logger.With("handler", "migration"))
-			migHandler = basicAuth(migHandler, apiUsername, *flAPIKey, "nanomdm")
-			mux.Handle(endpointAPIMigration, migHandler)
-		}
-	}
-
-	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte(`{"version":"` + version + `"}`))
-	})
-
-	logger.Info("msg", "starting server", "listen", *flListen)
-	http.ListenAndServe(*flListen, simpleLog(mux, logger.With("handler", "log")))
-}
+			if hookProxy != nil {
+				client.Transport = microwebhook.ProxyTransport(client.Transport, hookProxy)
+			}
+			hookOpts = append(hookOpts, reject.WithClient(client))
+		}
+		opts = append(opts, nanomdm.WithCheckinHook(reject.NewWebhook(*flCheckinHook, hookOpts...)))
+	}
+	pushProxy, err := parseProxy(*flPushProxy)
+	if err != nil {
+		stdlog.Fatal(err)
+	} else if pushProxy != nil {
+		opts = append(opts, nanomdm.WithPushProxy(pushProxy))
+	}
+	if cbProxy, err := parseProxy(*flCbProxy); err != nil {
+		stdlog.Fatal(err)
+	} else if cbProxy != nil {
+		opts = append(opts, nanomdm.WithCallbackProxy(cbProxy))
+	}
+	if chaosTargets["push"] {
+		bufordOpts := []buford.Option{buford.WithTimeout(*flPushTO)}
+		if pushProxy != nil {
+			bufordOpts = append(bufordOpts, buford.WithProxy(pushProxy))
+		}
+		opts = append(opts, nanomdm.WithPushProviderFactory(chaos.NewPushProviderFactory(buford.NewPushProviderFactory(bufordOpts...), faults)))
+	}
+	if *flMaintenance {
+		opts = append(opts, nanomdm.WithMaintenance())
+	}
+	if *flDebugAPI {
+		opts = append(opts, nanomdm.WithDebug())
+	}
+	opts = append(opts, nanomdm.WithStorageTimeout(*flStoreTO), nanomdm.WithPushTimeout(*flPushTO))
+	if *flReplay > 0 {
+		opts = append(opts, nanomdm.WithReplayProtection(*flReplay))
+	}
+	if *flAuthFails > 0 {
+		opts = append(opts, nanomdm.WithAuthFailureBlocking(*flAuthFails, *flAuthWindow, *flAuthBlock, *flWebhook))
+	}
+	if *flClientIP != "" {
+		opts = append(opts, nanomdm.WithClientIPHeader(*flClientIP))
+	}
+	trustedProxies, err := mdmhttp.ParseNetworks(flTrustedProxies)
+	if err != nil {
+		stdlog.Fatal(err)
+	}
+	if len(trustedProxies) > 0 {
+		opts = append(opts, nanomdm.WithTrustedProxies(trustedProxies))
+	}
+	deviceHeaders, err := mdmhttp.ParseHeaders(flHeaders)
+	if err != nil {
+		stdlog.Fatal(err)
+	}
+	if *flHSTS > 0 {
+		deviceHeaders.Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d; includeSubDomains", int64(flHSTS.Seconds())))
+	}
+	apiHeaders, err := mdmhttp.ParseHeaders(flAPIHeaders)
+	if err != nil {
+		stdlog.Fatal(err)
+	}
+	for name, values := range deviceHeaders {
+		if _, ok := apiHeaders[name]; !ok {
+			apiHeaders[name] = values
+		}
+	}
+	if len(deviceHeaders) > 0 || len(apiHeaders) > 0 {
+		opts = append(opts, nanomdm.WithResponseHeaders(deviceHeaders, apiHeaders))
+	}
+	if len(flAPIAllow) > 0 {
+		networks, err := mdmhttp.ParseNetworks(flAPIAllow)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		opts = append(opts, nanomdm.WithAPINetworks(networks))
+	}
+	if *flAPIClientCA != "" {
+		apiCAPEM, err := ioutil.ReadFile(*flAPIClientCA)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		apiVerifier, err := certverify.NewPoolVerifier(apiCAPEM, x509.ExtKeyUsageClientAuth)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		opts = append(opts, nanomdm.WithAPIClientCertVerifier(apiVerifier))
+	}
 
-func basicAuth(next http.Handler, username, password, realm string) http.HandlerFunc {
-	uBytes := []byte(username)
-	pBytes := []byte(password)
-	return func(w http.ResponseWriter, r *http.Request) {
-		u, p, ok := r.BasicAuth()
-		if !ok || subtle.ConstantTimeCompare([]byte(u), uBytes) != 1 || subtle.ConstantTimeCompare([]byte(p), pBytes) != 1 {
-			w.Header().Set("WWW-Authenticate", `Basic realm="`+realm+`"`)
-			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
-			return
+	server, err := nanomdm.New(mdmStorage, verifier, opts...)
+	if err != nil {
+		stdlog.Fatal(err)
+	}
+	server.Start(context.Background())
+
+	for name, ref := range vaultRefs {
+		name := name
+		fn := func(string) {
+			logger.Info("msg", "secret rotated: restart to apply", "flag", name)
 		}
-		next.ServeHTTP(w, r)
+		if name == "api" {
+			fn = func(value string) { apiKey.Store(value) }
+		}
+		go vaultClient.Watch(context.Background(), ref.Ref, ref.Value, ref.Lease, fn)
+	}
+
+	// API endpoints may be served from a separate listener
+	var listeners []listenerConfig
+	if *flAPIListen != "" {
+		mdmHandlers, apiHandlers := server.MDMHandlers(), server.APIHandlers()
+		listeners = []listenerConfig{
+			{"mdm", *flListen, *flTLSCert, *flTLSKey, false, mdmhttp.NewServeMux(&mdmHandlers, paths)},
+			{"api", *flAPIListen, *flAPITLSCert, *flAPITLSKey, *flAPIClientCA != "", mdmhttp.NewServeMux(&apiHandlers, paths)},
+		}
+	} else {
+		listeners = []listenerConfig{{"mdm", *flListen, *flTLSCert, *flTLSKey, *flAPIClientCA != "", server.Handler()}}
+	}
+
+	errs := make(chan error, len(listeners))
+	for _, lc := range listeners {
+		if lc.clientCert && lc.certFile == "" {
+			stdlog.Fatalf("%s listener: API client certificates require TLS", lc.name)
+		}
+		listener, err := cli.Listen(lc.addr)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		logger.Info("msg", "starting server", "server", lc.name, "listen", listener.Addr().String(), "tls", lc.certFile != "")
+		go func(lc listenerConfig, listener net.Listener) {
+			srv := &http.Server{Handler: simpleLog(lc.handler, trustedProxies, logger.With("handler", "log", "server", lc.name))}
+			if lc.clientCert {
+				// client certificates are verified by the API middleware
+				srv.TLSConfig = &tls.Config{ClientAuth: tls.RequestClientCert}
+			}
+			if lc.certFile != "" || lc.keyFile != "" {
+				errs <- srv.ServeTLS(listener, lc.certFile, lc.keyFile)
+			} else {
+				errs <- srv.Serve(listener)
+			}
+		}(lc, listener)
 	}
+	err = <-errs
+	logs := []interface{}{"msg", "server shutdown"}
+	if err != nil {
+		logs = append(logs, "err", err)
+	}
+	logger.Info(logs...)
+}
+
+// listenerConfig configures a single HTTP listener.
+type listenerConfig struct {
+	name     string
+	addr     string
+	certFile string
+	keyFile  string
+	// request (but don't verify) TLS client certificates
+	clientCert bool
+	handler    http.Handler
 }
 
-func simpleLog(next http.Handler, logger log.Logger) http.HandlerFunc {
+// simpleLog logs requests. The X-Forwarded-For and X-Request-Id
+// headers are only logged from trusted proxies.
+func simpleLog(next http.Handler, trustedProxies []*net.IPNet, logger log.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		host, _, err := net.SplitHostPort(r.RemoteAddr)
 		if err != nil {
@@ -218,10 +899,63 @@ func simpleLog(next http.Handler, logger log.Logger) http.HandlerFunc {
 			"path", r.URL.Path,
 			"agent", r.UserAgent(),
 		}
-		if fwdedFor := r.Header.Get("X-Forwarded-For"); fwdedFor != "" {
-			logs = append(logs, "real_ip", fwdedFor)
+		if mdmhttp.TrustedProxy(r, trustedProxies) {
+			if fwdedFor := r.Header.Get("X-Forwarded-For"); fwdedFor != "" {
+				logs = append(logs, "real_ip", fwdedFor)
+			}
+			if requestID := r.Header.Get("X-Request-Id"); requestID != "" {
+				logs = append(logs, "request_id", requestID)
+			}
 		}
 		logger.Info(logs...)
 		next.ServeHTTP(w, r)
 	}
 }
+
+// parseIntPairs parses comma-separated name=integer pairs (e.g.
+// RequestType=priority) of kind (for errors).
+func parseIntPairs(s, kind string) (map[string]int, error) {
+	pairs := make(map[string]int)
+	if s == "" {
+		return pairs, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid %s: %q", kind, pair)
+		}
+		n, err := strconv.Atoi(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %q: %w", kind, pair, err)
+		}
+		pairs[strings.TrimSpace(kv[0])] = n
+	}
+	return pairs, nil
+}
+
+// parseProxy parses the proxy URL s (nil if empty).
+func parseProxy(s string) (*url.URL, error) {
+	if s == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(s)
+	if err == nil && (u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5")) {
+		err = errors.New("must be an http, https, or socks5 URL")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %w", s, err)
+	}
+	return u, nil
+}
+
+// proxyClient returns an HTTP client with timeout (0 for none) that
+// connects through proxy or nil if proxy is nil.
+func proxyClient(proxy *url.URL, timeout time.Duration) *http.Client {
+	if proxy == nil {
+		return nil
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: microwebhook.ProxyTransport(nil, proxy),
+	}
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/cmd/cli"
+	"github.com/jessepeterson/nanomdm/cmdplist"
+	mdmhttp "github.com/jessepeterson/nanomdm/http"
+	"golang.org/x/term"
+)
+
+// shell is an interactive administration shell that talks to the
+// NanoMDM API.
+type shell struct {
+	url    string
+	apiKey string
+	paths  mdmhttp.Paths
+	client *http.Client
+	out    io.Writer
+}
+
+type shellCommand struct {
+	args  string
+	usage string
+	run   func(sh *shell, args []string) error
+}
+
+var shellCommands map[string]shellCommand
+
+func init() {
+	// initialized here to avoid an initialization loop with the help command
+	shellCommands = map[string]shellCommand{
+		"enrollments": {"", "list enrollments", (*shell).enrollments},
+		"enqueue":     {"<id>[,<id>...] <command> [command flags]", "enqueue a command and push", (*shell).enqueue},
+		"push":        {"<id>[,<id>...]", "send APNs pushes", (*shell).push},
+		"queue":       {"<id>", "show command delivery history of an enrollment", (*shell).queue},
+		"pushcert":    {"<cert.pem> <key.pem>", "upload a push certificate and key", (*shell).pushCert},
+		"commands":    {"", "list MDM commands available for enqueue", (*shell).commands},
+		"version":     {"", "print server version", (*shell).version},
+		"help":        {"", "show this help", (*shell).help},
+		"exit":        {"", "exit the shell", nil},
+	}
+}
+
+func shellCommandNames() []string {
+	var names []string
+	for name := range shellCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runShell starts the interactive shell using command-line arguments args.
+func runShell(args []string) error {
+	fs := flag.NewFlagSet("shell", flag.ExitOnError)
+	var (
+		flURL    = fs.String("url", "http://127.0.0.1:9000", "NanoMDM server URL")
+		flAPIKey = fs.String("api", "", "API key for API endpoints")
+		flPrefix = fs.String("api-path-prefix", "", "URL path prefix for API endpoints")
+	)
+	fs.Parse(args)
+	if err := cli.SetFlagsFromEnv(fs, "NANOMDM", map[string]string{"api": "NANOMDM_API_KEY"}); err != nil {
+		return err
+	}
+	sh := &shell{
+		url:    strings.TrimRight(*flURL, "/"),
+		apiKey: *flAPIKey,
+		paths:  mdmhttp.DefaultPaths.WithAPIPrefix(*flPrefix),
+		client: http.DefaultClient,
+	}
+
+	// without a terminal just execute lines from stdin
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		sh.out = os.Stdout
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if !sh.exec(scanner.Text()) {
+				break
+			}
+		}
+		return scanner.Err()
+	}
+
+	oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return err
+	}
+	defer term.Restore(int(os.Stdin.Fd()), oldState)
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, "nanomdm> ")
+	t.AutoCompleteCallback = sh.completer(t)
+	sh.out = t
+	fmt.Fprintf(t, "connected to %s; type \"help\" for commands\n", sh.url)
+	for {
+		line, err := t.ReadLine()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !sh.exec(line) {
+			return nil
+		}
+	}
+}
+
+// exec runs a single shell command line. It returns false if the shell
+// should exit.
+func (sh *shell) exec(line string) bool {
+	args, err := splitWords(line)
+	if err != nil {
+		fmt.Fprintln(sh.out, err)
+		return true
+	}
+	if len(args) < 1 {
+		return true
+	}
+	if args[0] == "exit" || args[0] == "quit" {
+		return false
+	}
+	cmd, ok := shellCommands[args[0]]
+	if !ok {
+		fmt.Fprintf(sh.out, "unknown command: %s\n", args[0])
+		return true
+	}
+	if err := cmd.run(sh, args[1:]); err != nil {
+		fmt.Fprintf(sh.out, "%s: %v\n", args[0], err)
+	}
+	return true
+}
+
+// splitWords splits line into words honoring single and double quotes.
+func splitWords(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	var quote rune
+	inWord := false
+	for _, c := range line {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(c)
+		case c == '"' || c == '\'':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// completer returns a tab-completion callback for the terminal.
+func (sh *shell) completer(t *term.Terminal) func(string, int, rune) (string, int, bool) {
+	return func(line string, pos int, key rune) (string, int, bool) {
+		if key != '\t' || pos != len(line) {
+			return "", 0, false
+		}
+		words := strings.Fields(line)
+		if len(words) == 0 || strings.HasSuffix(line, " ") {
+			words = append(words, "")
+		}
+		prefix := words[len(words)-1]
+		var candidates []string
+		switch {
+		case len(words) == 1:
+			candidates = shellCommandNames()
+		case words[0] == "enqueue" && len(words) == 3:
+			candidates = cmdplist.Names()
+		case words[0] == "enqueue" && len(words) > 3 && strings.HasPrefix(prefix, "-"):
+			if spec := cmdplist.Lookup(words[2]); spec != nil {
+				for _, arg := range spec.Args {
+					candidates = append(candidates, "-"+arg.Flag())
+				}
+			}
+		}
+		var matches []string
+		for _, c := range candidates {
+			if strings.HasPrefix(c, prefix) {
+				matches = append(matches, c)
+			}
+		}
+		if len(matches) == 0 {
+			return "", 0, false
+		}
+		completed := commonPrefix(matches)
+		if len(matches) == 1 {
+			completed += " "
+		} else if completed == prefix {
+			fmt.Fprintln(t, strings.Join(matches, "  "))
+		}
+		newLine := line[:len(line)-len(prefix)] + completed
+		return newLine, len(newLine), true
+	}
+}
+
+func commonPrefix(s []string) string {
+	prefix := s[0]
+	for _, v := range s[1:] {
+		for !strings.HasPrefix(v, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// do performs an API request and returns the response body.
+func (sh *shell) do(method, path string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, sh.url+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth("nanomdm", sh.apiKey)
+	resp, err := sh.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return b, fmt.Errorf("unexpected HTTP status: %s", resp.Status)
+	}
+	return b, nil
+}
+
+func (sh *shell) help(_ []string) error {
+	w := tabwriter.NewWriter(sh.out, 0, 0, 2, ' ', 0)
+	for _, name := range shellCommandNames() {
+		cmd := shellCommands[name]
+		fmt.Fprintf(w, "%s %s\t%s\n", name, cmd.args, cmd.usage)
+	}
+	return w.Flush()
+}
+
+func (sh *shell) version(_ []string) error {
+	b, err := sh.do(http.MethodGet, sh.paths.Version, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(sh.out, string(b))
+	return nil
+}
+
+func (sh *shell) commands(_ []string) error {
+	w := tabwriter.NewWriter(sh.out, 0, 0, 2, ' ', 0)
+	for _, name := range cmdplist.Names() {
+		fmt.Fprintf(w, "%s\t%s\n", name, cmdplist.Lookup(name).Usage)
+	}
+	return w.Flush()
+}
+
+func (sh *shell) enrollments(_ []string) error {
+	b, err := sh.do(http.MethodGet, sh.paths.Enrollments, nil)
+	if err != nil {
+		return err
+	}
+	var output struct {
+		Enrollments []struct {
+			ID      string `json:"id"`
+			Type    string `json:"type"`
+			Topic   string `json:"topic"`
+			Enabled bool   `json:"enabled"`
+		} `json:"enrollments"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(b, &output); err != nil {
+		return err
+	}
+	if output.Error != "" {
+		return errors.New(output.Error)
+	}
+	w := tabwriter.NewWriter(sh.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTYPE\tENABLED\tTOPIC")
+	for _, e := range output.Enrollments {
+		fmt.Fprintf(w, "%s\t%s\t%t\t%s\n", e.ID, e.Type, e.Enabled, e.Topic)
+	}
+	return w.Flush()
+}
+
+func (sh *shell) queue(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: queue " + shellCommands["queue"].args)
+	}
+	b, err := sh.do(http.MethodGet, sh.paths.Queue+args[0], nil)
+	if err != nil {
+		return err
+	}
+	var output struct {
+		Commands []struct {
+			CommandUUID      string     `json:"command_uuid"`
+			RequestType      string     `json:"request_type"`
+			Status           string     `json:"status"`
+			Active           bool       `json:"active"`
+			EnqueuedAt       time.Time  `json:"enqueued_at"`
+			FirstDeliveredAt *time.Time `json:"first_delivered_at"`
+			DeliveryCount    int        `json:"delivery_count"`
+			NotNowCount      int        `json:"not_now_count"`
+			ResolvedAt       *time.Time `json:"resolved_at"`
+		} `json:"commands"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(b, &output); err != nil {
+		return err
+	}
+	if output.Error != "" {
+		return errors.New(output.Error)
+	}
+	fmtTime := func(t *time.Time) string {
+		if t == nil {
+			return "-"
+		}
+		return t.Local().Format(time.RFC3339)
+	}
+	w := tabwriter.NewWriter(sh.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "COMMAND UUID\tREQUEST TYPE\tSTATUS\tACTIVE\tENQUEUED\tFIRST DELIVERED\tDELIVERIES\tNOTNOWS\tRESOLVED")
+	for _, c := range output.Commands {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\t%s\t%d\t%d\t%s\n",
+			c.CommandUUID, c.RequestType, c.Status, c.Active, fmtTime(&c.EnqueuedAt),
+			fmtTime(c.FirstDeliveredAt), c.DeliveryCount, c.NotNowCount, fmtTime(c.ResolvedAt))
+	}
+	return w.Flush()
+}
+
+// printAPIResult prints the JSON output of the push and enqueue APIs.
+func (sh *shell) printAPIResult(b []byte) error {
+	var output struct {
+		Status map[string]struct {
+			PushError    string `json:"push_error"`
+			PushResult   string `json:"push_result"`
+			CommandError string `json:"command_error"`
+		} `json:"status"`
+		PushError    string `json:"push_error"`
+		CommandError string `json:"command_error"`
+		CommandUUID  string `json:"command_uuid"`
+		RequestType  string `json:"request_type"`
+	}
+	if err := json.Unmarshal(b, &output); err != nil {
+		return err
+	}
+	if output.CommandUUID != "" {
+		fmt.Fprintf(sh.out, "command %s (%s)\n", output.CommandUUID, output.RequestType)
+	}
+	if output.CommandError != "" {
+		fmt.Fprintf(sh.out, "command error: %s\n", output.CommandError)
+	}
+	if output.PushError != "" {
+		fmt.Fprintf(sh.out, "push error: %s\n", output.PushError)
+	}
+	w := tabwriter.NewWriter(sh.out, 0, 0, 2, ' ', 0)
+	for id, status := range output.Status {
+		result := status.PushResult
+		for _, err := range []string{status.CommandError, status.PushError} {
+			if err != "" {
+				result += " error: " + err
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\n", id, strings.TrimSpace(result))
+	}
+	return w.Flush()
+}
+
+func (sh *shell) push(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: push " + shellCommands["push"].args)
+	}
+	b, err := sh.do(http.MethodGet, sh.paths.Push+args[0], nil)
+	if err != nil {
+		return err
+	}
+	return sh.printAPIResult(b)
+}
+
+func (sh *shell) enqueue(args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: enqueue " + shellCommands["enqueue"].args)
+	}
+	spec := cmdplist.Lookup(args[1])
+	if spec == nil {
+		return fmt.Errorf("unknown command: %s", args[1])
+	}
+	fs := flag.NewFlagSet(spec.Name(), flag.ContinueOnError)
+	fs.SetOutput(sh.out)
+	strArgs := make(map[string]*string)
+	for _, arg := range spec.Args {
+		strArgs[arg.Key] = fs.String(arg.Flag(), "", arg.Usage)
+	}
+	flJSON := fs.String("json", "", "path to JSON object merged into the command")
+	flNoPush := fs.Bool("nopush", false, "do not send a push notification")
+	if err := fs.Parse(args[2:]); err != nil {
+		return err
+	}
+	values := make(map[string]string)
+	data := make(map[string][]byte)
+	for _, arg := range spec.Args {
+		v := *strArgs[arg.Key]
+		if v == "" {
+			continue
+		}
+		if arg.Type == cmdplist.Data {
+			b, err := ioutil.ReadFile(v)
+			if err != nil {
+				return err
+			}
+			data[arg.Key] = b
+			continue
+		}
+		values[arg.Key] = v
+	}
+	var js []byte
+	if *flJSON != "" {
+		var err error
+		if js, err = ioutil.ReadFile(*flJSON); err != nil {
+			return err
+		}
+	}
+	cmd, err := spec.BuildJSON(values, data, js)
+	if err != nil {
+		return err
+	}
+	body, err := cmd.Plist()
+	if err != nil {
+		return err
+	}
+	path := sh.paths.Enqueue + args[0]
+	if *flNoPush {
+		path += "?nopush=1"
+	}
+	b, err := sh.do(http.MethodPut, path, body)
+	if err != nil {
+		return err
+	}
+	return sh.printAPIResult(b)
+}
+
+func (sh *shell) pushCert(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: pushcert " + shellCommands["pushcert"].args)
+	}
+	var body []byte
+	for _, path := range args {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		body = append(body, b...)
+	}
+	b, err := sh.do(http.MethodPut, sh.paths.PushCert, body)
+	if err != nil {
+		return err
+	}
+	var output struct {
+		Error string `json:"error"`
+		Topic string `json:"topic"`
+	}
+	if err := json.Unmarshal(b, &output); err != nil {
+		return err
+	}
+	if output.Error != "" {
+		return errors.New(output.Error)
+	}
+	fmt.Fprintf(sh.out, "stored push certificate for topic %s\n", output.Topic)
+	return nil
+}
@@ -0,0 +1,107 @@
+// Command pushcsr creates MDM vendor-signed APNs push certificate
+// requests for upload to https://identity.apple.com.
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/jessepeterson/nanomdm/pushcsr"
+)
+
+// overridden by -ldflags -X
+var version = "unknown"
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: %s [flags]
+
+Signs a push certificate CSR with an MDM vendor certificate key. Either
+supply an existing CSR with -csr or generate a new private key and CSR
+with -key-out (keep the key: it is needed to use the push certificate).
+
+flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	var (
+		flVendorCert = flag.String("vendor-cert", "", "path to PEM MDM vendor certificate followed by its intermediate and root certificates")
+		flVendorKey  = flag.String("vendor-key", "", "path to PEM MDM vendor certificate private key")
+		flCSR        = flag.String("csr", "", "path to existing PEM or DER push certificate CSR")
+		flKeyOut     = flag.String("key-out", "", "path to write a newly generated push certificate private key")
+		flCN         = flag.String("cn", "NanoMDM Push", "common name of a generated CSR")
+		flEmail      = flag.String("email", "", "email address of a generated CSR")
+		flOut        = flag.String("out", "PushCertificateRequest.b64", "path to write the push certificate request (\"-\" for stdout)")
+		flVersion    = flag.Bool("version", false, "print version")
+	)
+	flag.Usage = usage
+	flag.Parse()
+
+	if *flVersion {
+		fmt.Println(version)
+		return
+	}
+
+	if *flVendorCert == "" || *flVendorKey == "" || (*flCSR == "") == (*flKeyOut == "") {
+		usage()
+		os.Exit(2)
+	}
+
+	if err := run(*flVendorCert, *flVendorKey, *flCSR, *flKeyOut, *flCN, *flEmail, *flOut); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(vendorCertPath, vendorKeyPath, csrPath, keyOutPath, cn, email, outPath string) error {
+	b, err := ioutil.ReadFile(vendorCertPath)
+	if err != nil {
+		return err
+	}
+	chain, err := pushcsr.ParseCertificates(b)
+	if err != nil {
+		return fmt.Errorf("vendor cert: %w", err)
+	}
+	if b, err = ioutil.ReadFile(vendorKeyPath); err != nil {
+		return err
+	}
+	vendorKey, err := pushcsr.ParsePrivateKey(b)
+	if err != nil {
+		return fmt.Errorf("vendor key: %w", err)
+	}
+
+	var csr []byte
+	if csrPath != "" {
+		if b, err = ioutil.ReadFile(csrPath); err != nil {
+			return err
+		}
+		if csr, err = pushcsr.ParseCSR(b); err != nil {
+			return fmt.Errorf("csr: %w", err)
+		}
+	} else {
+		key, newCSR, err := pushcsr.NewKeyAndCSR(cn, email)
+		if err != nil {
+			return err
+		}
+		pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+		if err = ioutil.WriteFile(keyOutPath, pemKey, 0600); err != nil {
+			return err
+		}
+		csr = newCSR
+	}
+
+	req, err := pushcsr.Sign(csr, vendorKey, chain)
+	if err != nil {
+		return err
+	}
+	if outPath == "-" {
+		_, err = os.Stdout.Write(req)
+		return err
+	}
+	return ioutil.WriteFile(outPath, req, 0644)
+}
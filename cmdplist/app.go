@@ -0,0 +1,64 @@
+package cmdplist
+
+import "errors"
+
+// Application is an application to install with an InstallApplication
+// or InstallEnterpriseApplication command.
+// See https://developer.apple.com/documentation/devicemanagement/installapplicationcommand/command
+type Application struct {
+	// ITunesStoreID installs an App Store (i.e. VPP) app.
+	ITunesStoreID int64 `json:"itunes_store_id,omitempty"`
+	// Identifier installs an App Store app by bundle identifier.
+	Identifier string `json:"identifier,omitempty"`
+	// ManifestURL installs an enterprise app.
+	ManifestURL string `json:"manifest_url,omitempty"`
+	// ManagementFlags is a bitmask: 1 removes the app when the MDM
+	// profile is removed, 4 prevents backup of app data.
+	ManagementFlags  int  `json:"management_flags,omitempty"`
+	InstallAsManaged bool `json:"install_as_managed,omitempty"`
+	// Configuration is the managed app configuration. JSON numbers
+	// (when decoded with UseNumber) become plist integers or reals.
+	Configuration map[string]interface{} `json:"configuration,omitempty"`
+	// Enterprise uses the (macOS) InstallEnterpriseApplication command
+	// rather than InstallApplication. Requires ManifestURL.
+	Enterprise bool `json:"enterprise,omitempty"`
+}
+
+// NewInstallApplication creates a new InstallApplication or (if
+// app.Enterprise is set) InstallEnterpriseApplication command for app.
+func NewInstallApplication(app Application) (*Command, error) {
+	if app.Enterprise {
+		if app.ManifestURL == "" {
+			return nil, errors.New("enterprise application requires manifest URL")
+		}
+		c := New("InstallEnterpriseApplication").Set("ManifestURL", app.ManifestURL)
+		if app.InstallAsManaged {
+			c.Set("InstallAsManaged", true)
+		}
+		if len(app.Configuration) > 0 {
+			c.Set("Configuration", convertJSONNumbers(app.Configuration))
+		}
+		return c, nil
+	}
+	c := New("InstallApplication")
+	switch {
+	case app.ITunesStoreID != 0:
+		c.Set("iTunesStoreID", app.ITunesStoreID)
+	case app.Identifier != "":
+		c.Set("Identifier", app.Identifier)
+	case app.ManifestURL != "":
+		c.Set("ManifestURL", app.ManifestURL)
+	default:
+		return nil, errors.New("application requires store ID, identifier, or manifest URL")
+	}
+	if app.ManagementFlags != 0 {
+		c.Set("ManagementFlags", app.ManagementFlags)
+	}
+	if app.InstallAsManaged {
+		c.Set("InstallAsManaged", true)
+	}
+	if len(app.Configuration) > 0 {
+		c.Set("Configuration", convertJSONNumbers(app.Configuration))
+	}
+	return c, nil
+}
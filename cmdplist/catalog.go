@@ -0,0 +1,329 @@
+package cmdplist
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ArgType is the plist type of a command argument.
+type ArgType int
+
+const (
+	String ArgType = iota
+	Strings
+	Integer
+	Bool
+	Data
+)
+
+// Arg describes a single top-level key of an MDM command.
+type Arg struct {
+	Key      string
+	Type     ArgType
+	Usage    string
+	Required bool
+}
+
+// Flag returns the command-line (kebab-case) name of the argument.
+func (a Arg) Flag() string {
+	return kebab(a.Key)
+}
+
+// Spec describes an MDM command in the catalog.
+type Spec struct {
+	RequestType string
+	Usage       string
+	Args        []Arg
+}
+
+// Name returns the command-line (kebab-case) name of the command.
+func (s *Spec) Name() string {
+	return kebab(s.RequestType)
+}
+
+// Build assembles a new command from the string values in args keyed
+// by argument Key. Data arguments are expected to already be read and
+// are supplied in data. Unset optional arguments are omitted.
+func (s *Spec) Build(args map[string]string, data map[string][]byte) (*Command, error) {
+	return s.BuildJSON(args, data, nil)
+}
+
+// BuildJSON is like Build but also merges the keys of the JSON object
+// in js (if not empty) into the command (see MergeJSON) before checking
+// for required arguments. Required arguments may thus be supplied in
+// js instead of args or data.
+func (s *Spec) BuildJSON(args map[string]string, data map[string][]byte, js []byte) (*Command, error) {
+	c := New(s.RequestType)
+	for _, arg := range s.Args {
+		if arg.Type == Data {
+			if b, ok := data[arg.Key]; ok {
+				c.Set(arg.Key, b)
+			}
+			continue
+		}
+		v, ok := args[arg.Key]
+		if !ok || v == "" {
+			continue
+		}
+		switch arg.Type {
+		case String:
+			c.Set(arg.Key, v)
+		case Strings:
+			c.Set(arg.Key, splitList(v))
+		case Integer:
+			i, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("argument %s: %w", arg.Key, err)
+			}
+			c.Set(arg.Key, i)
+		case Bool:
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("argument %s: %w", arg.Key, err)
+			}
+			c.Set(arg.Key, b)
+		}
+	}
+	if len(js) > 0 {
+		if err := c.MergeJSON(js); err != nil {
+			return nil, fmt.Errorf("decoding JSON: %w", err)
+		}
+	}
+	for _, arg := range s.Args {
+		if _, ok := c.Command[arg.Key]; arg.Required && !ok {
+			return nil, fmt.Errorf("missing required argument: %s", arg.Key)
+		}
+	}
+	return c, nil
+}
+
+func splitList(s string) []string {
+	var r []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			r = append(r, v)
+		}
+	}
+	return r
+}
+
+// kebab converts a CamelCase key into kebab-case. Runs of upper-case
+// letters are kept together (e.g. "PIN" becomes "pin", "OSUpdateStatus"
+// becomes "os-update-status").
+func kebab(s string) string {
+	var b strings.Builder
+	r := []rune(s)
+	for i, c := range r {
+		if unicode.IsUpper(c) && i > 0 {
+			prevLower := unicode.IsLower(r[i-1]) || unicode.IsDigit(r[i-1])
+			nextLower := i+1 < len(r) && unicode.IsLower(r[i+1])
+			if prevLower || (unicode.IsUpper(r[i-1]) && nextLower) {
+				b.WriteRune('-')
+			}
+		}
+		b.WriteRune(unicode.ToLower(c))
+	}
+	return b.String()
+}
+
+// Lookup finds a command Spec in the catalog by either its RequestType
+// or its kebab-case name.
+func Lookup(name string) *Spec {
+	for i := range Catalog {
+		if Catalog[i].RequestType == name || Catalog[i].Name() == strings.ToLower(name) {
+			return &Catalog[i]
+		}
+	}
+	return nil
+}
+
+// Names returns the sorted kebab-case names of all commands in the catalog.
+func Names() []string {
+	names := make([]string, len(Catalog))
+	for i := range Catalog {
+		names[i] = Catalog[i].Name()
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Catalog is the list of known MDM commands and their top-level
+// arguments. Commands with deeply nested structures (e.g. Settings or
+// ScheduleOSUpdate) are best supplied with additional JSON input.
+// See https://developer.apple.com/documentation/devicemanagement/commands_and_queries
+var Catalog = []Spec{
+	// queries
+	{RequestType: "DeviceInformation", Usage: "query device attributes", Args: []Arg{
+		{Key: "Queries", Type: Strings, Usage: "comma-separated list of queries (e.g. UDID,OSVersion)"},
+	}},
+	{RequestType: "SecurityInfo", Usage: "query security-related information"},
+	{RequestType: "CertificateList", Usage: "list installed certificates", Args: []Arg{
+		{Key: "ManagedOnly", Type: Bool, Usage: "only return managed certificates"},
+	}},
+	{RequestType: "ProfileList", Usage: "list installed profiles", Args: []Arg{
+		{Key: "ManagedOnly", Type: Bool, Usage: "only return managed profiles"},
+	}},
+	{RequestType: "ProvisioningProfileList", Usage: "list installed provisioning profiles"},
+	{RequestType: "InstalledApplicationList", Usage: "list installed applications", Args: []Arg{
+		{Key: "Identifiers", Type: Strings, Usage: "comma-separated bundle identifiers"},
+		{Key: "ManagedAppsOnly", Type: Bool, Usage: "only return managed apps"},
+	}},
+	{RequestType: "ManagedApplicationList", Usage: "list managed applications", Args: []Arg{
+		{Key: "Identifiers", Type: Strings, Usage: "comma-separated bundle identifiers"},
+	}},
+	{RequestType: "ManagedMediaList", Usage: "list managed books"},
+	{RequestType: "Restrictions", Usage: "query restrictions", Args: []Arg{
+		{Key: "ProfileRestrictions", Type: Bool, Usage: "include per-profile restrictions"},
+	}},
+	{RequestType: "UserList", Usage: "list users (Shared iPad, macOS)"},
+	{RequestType: "AvailableOSUpdates", Usage: "list available OS updates"},
+	{RequestType: "OSUpdateStatus", Usage: "query OS update status"},
+	{RequestType: "ActivationLockBypassCode", Usage: "retrieve the Activation Lock bypass code"},
+	{RequestType: "DeviceLocation", Usage: "query location of a device in Lost Mode"},
+	{RequestType: "NSExtensionMappings", Usage: "list app extension mappings"},
+	{RequestType: "LOMDeviceRequest", Usage: "send a lights-out management request"},
+
+	// profiles
+	{RequestType: "InstallProfile", Usage: "install a configuration profile", Args: []Arg{
+		{Key: "Payload", Type: Data, Usage: "path to profile (mobileconfig) to install", Required: true},
+	}},
+	{RequestType: "RemoveProfile", Usage: "remove a configuration profile", Args: []Arg{
+		{Key: "Identifier", Type: String, Usage: "profile identifier", Required: true},
+	}},
+	{RequestType: "InstallProvisioningProfile", Usage: "install a provisioning profile", Args: []Arg{
+		{Key: "ProvisioningProfile", Type: Data, Usage: "path to provisioning profile", Required: true},
+	}},
+	{RequestType: "RemoveProvisioningProfile", Usage: "remove a provisioning profile", Args: []Arg{
+		{Key: "UUID", Type: String, Usage: "provisioning profile UUID", Required: true},
+	}},
+	{RequestType: "DeclarativeManagement", Usage: "synchronize declarative management", Args: []Arg{
+		{Key: "Data", Type: Data, Usage: "path to synchronization tokens JSON"},
+	}},
+
+	// applications and media
+	{RequestType: "InstallApplication", Usage: "install an App Store or enterprise app", Args: []Arg{
+		{Key: "iTunesStoreID", Type: Integer, Usage: "App Store ID"},
+		{Key: "Identifier", Type: String, Usage: "bundle identifier"},
+		{Key: "ManifestURL", Type: String, Usage: "URL of the app manifest"},
+		{Key: "ManagementFlags", Type: Integer, Usage: "management flags (e.g. 1 to remove with MDM)"},
+		{Key: "InstallAsManaged", Type: Bool, Usage: "install as managed (macOS)"},
+	}},
+	{RequestType: "InstallEnterpriseApplication", Usage: "install a macOS enterprise app", Args: []Arg{
+		{Key: "ManifestURL", Type: String, Usage: "URL of the app manifest", Required: true},
+		{Key: "InstallAsManaged", Type: Bool, Usage: "install as managed"},
+	}},
+	{RequestType: "RemoveApplication", Usage: "remove a managed app", Args: []Arg{
+		{Key: "Identifier", Type: String, Usage: "bundle identifier", Required: true},
+	}},
+	{RequestType: "ValidateApplications", Usage: "validate enterprise app provisioning", Args: []Arg{
+		{Key: "Identifiers", Type: Strings, Usage: "comma-separated bundle identifiers"},
+	}},
+	{RequestType: "InstallMedia", Usage: "install a book", Args: []Arg{
+		{Key: "iTunesStoreID", Type: Integer, Usage: "store ID of the book"},
+		{Key: "MediaURL", Type: String, Usage: "URL of the book"},
+		{Key: "MediaType", Type: String, Usage: "media type (e.g. Book)"},
+		{Key: "PersistentID", Type: String, Usage: "persistent ID of the book"},
+	}},
+	{RequestType: "RemoveMedia", Usage: "remove a book", Args: []Arg{
+		{Key: "MediaType", Type: String, Usage: "media type (e.g. Book)", Required: true},
+		{Key: "iTunesStoreID", Type: Integer, Usage: "store ID of the book"},
+		{Key: "PersistentID", Type: String, Usage: "persistent ID of the book"},
+	}},
+	{RequestType: "InviteToProgram", Usage: "invite a user to a volume purchase program", Args: []Arg{
+		{Key: "ProgramID", Type: String, Usage: "program ID", Required: true},
+		{Key: "InvitationURL", Type: String, Usage: "invitation URL", Required: true},
+	}},
+
+	// security and device actions
+	{RequestType: "DeviceLock", Usage: "lock the device", Args: []Arg{
+		{Key: "PIN", Type: String, Usage: "six-digit PIN (macOS)"},
+		{Key: "Message", Type: String, Usage: "lock screen message"},
+		{Key: "PhoneNumber", Type: String, Usage: "lock screen phone number"},
+	}},
+	{RequestType: "EraseDevice", Usage: "erase the device", Args: []Arg{
+		{Key: "PIN", Type: String, Usage: "six-digit PIN (macOS)"},
+		{Key: "PreserveDataPlan", Type: Bool, Usage: "preserve the data plan"},
+		{Key: "DisallowProximitySetup", Type: Bool, Usage: "disable Proximity Setup on the next reboot"},
+		{Key: "ObliterationBehavior", Type: String, Usage: "obliteration behavior (macOS)"},
+	}},
+	{RequestType: "ClearPasscode", Usage: "clear the device passcode", Args: []Arg{
+		{Key: "UnlockToken", Type: Data, Usage: "path to the unlock token", Required: true},
+	}},
+	{RequestType: "ClearRestrictionsPassword", Usage: "clear the restrictions password"},
+	{RequestType: "ClearActivationLockBypassCode", Usage: "clear the Activation Lock bypass code"},
+	{RequestType: "EnableLostMode", Usage: "enable Lost Mode", Args: []Arg{
+		{Key: "Message", Type: String, Usage: "lock screen message"},
+		{Key: "PhoneNumber", Type: String, Usage: "lock screen phone number"},
+		{Key: "Footnote", Type: String, Usage: "lock screen footnote"},
+	}},
+	{RequestType: "DisableLostMode", Usage: "disable Lost Mode"},
+	{RequestType: "PlayLostModeSound", Usage: "play a sound on a device in Lost Mode"},
+	{RequestType: "SetRecoveryLock", Usage: "set the recovery lock password (macOS)", Args: []Arg{
+		{Key: "CurrentPassword", Type: String, Usage: "current recovery lock password"},
+		{Key: "NewPassword", Type: String, Usage: "new recovery lock password (empty to clear)"},
+	}},
+	{RequestType: "VerifyRecoveryLock", Usage: "verify the recovery lock password (macOS)", Args: []Arg{
+		{Key: "Password", Type: String, Usage: "recovery lock password", Required: true},
+	}},
+	{RequestType: "SetFirmwarePassword", Usage: "set the firmware password (macOS)", Args: []Arg{
+		{Key: "CurrentPassword", Type: String, Usage: "current firmware password"},
+		{Key: "NewPassword", Type: String, Usage: "new firmware password"},
+		{Key: "AllowOroms", Type: Bool, Usage: "allow option ROMs"},
+	}},
+	{RequestType: "VerifyFirmwarePassword", Usage: "verify the firmware password (macOS)", Args: []Arg{
+		{Key: "Password", Type: String, Usage: "firmware password", Required: true},
+	}},
+	{RequestType: "RotateFileVaultKey", Usage: "rotate the FileVault recovery key (macOS)", Args: []Arg{
+		{Key: "KeyType", Type: String, Usage: "key type (personal or institutional)", Required: true},
+	}},
+
+	// power, users and sessions
+	{RequestType: "RestartDevice", Usage: "restart the device", Args: []Arg{
+		{Key: "NotifyUser", Type: Bool, Usage: "notify the user (macOS)"},
+		{Key: "RebuildKernelCache", Type: Bool, Usage: "rebuild the kernel cache (macOS)"},
+	}},
+	{RequestType: "ShutDownDevice", Usage: "shut down the device"},
+	{RequestType: "LogOutUser", Usage: "log out the current user (Shared iPad)"},
+	{RequestType: "DeleteUser", Usage: "delete a user", Args: []Arg{
+		{Key: "UserName", Type: String, Usage: "user name", Required: true},
+		{Key: "ForceDeletion", Type: Bool, Usage: "delete even if the user has data to sync"},
+		{Key: "DeleteAllUsers", Type: Bool, Usage: "delete all users"},
+	}},
+	{RequestType: "UnlockUserAccount", Usage: "unlock a local user account (macOS)", Args: []Arg{
+		{Key: "UserName", Type: String, Usage: "user name", Required: true},
+	}},
+	{RequestType: "SetAutoAdminPassword", Usage: "set the managed admin password (macOS)", Args: []Arg{
+		{Key: "GUID", Type: String, Usage: "admin account GUID", Required: true},
+		{Key: "passwordHash", Type: Data, Usage: "path to salted SHA512 PBKDF2 password hash", Required: true},
+	}},
+	{RequestType: "AccountConfiguration", Usage: "configure accounts during setup (macOS)", Args: []Arg{
+		{Key: "SkipPrimarySetupAccountCreation", Type: Bool, Usage: "skip primary account creation"},
+		{Key: "SetPrimarySetupAccountAsRegularUser", Type: Bool, Usage: "create the primary account as a standard user"},
+		{Key: "DontAutoPopulatePrimaryAccountInfo", Type: Bool, Usage: "don't pre-fill primary account info"},
+		{Key: "LockPrimaryAccountInfo", Type: Bool, Usage: "lock primary account info"},
+		{Key: "PrimaryAccountFullName", Type: String, Usage: "primary account full name"},
+		{Key: "PrimaryAccountUserName", Type: String, Usage: "primary account user name"},
+	}},
+	{RequestType: "DeviceConfigured", Usage: "release a device awaiting configuration"},
+	{RequestType: "Settings", Usage: "change device settings (supply Settings with JSON)"},
+
+	// OS updates
+	{RequestType: "ScheduleOSUpdate", Usage: "schedule OS updates (supply Updates with JSON)"},
+	{RequestType: "ScheduleOSUpdateScan", Usage: "scan for OS updates", Args: []Arg{
+		{Key: "Force", Type: Bool, Usage: "force a scan in the foreground (macOS)"},
+	}},
+
+	// screen sharing
+	{RequestType: "RequestMirroring", Usage: "request AirPlay mirroring", Args: []Arg{
+		{Key: "DestinationName", Type: String, Usage: "destination name"},
+		{Key: "DestinationDeviceID", Type: String, Usage: "destination device ID"},
+		{Key: "ScanTime", Type: Integer, Usage: "scan time in seconds"},
+		{Key: "Password", Type: String, Usage: "screen sharing password"},
+	}},
+	{RequestType: "StopMirroring", Usage: "stop AirPlay mirroring"},
+	{RequestType: "EnableRemoteDesktop", Usage: "enable remote desktop (macOS)"},
+	{RequestType: "DisableRemoteDesktop", Usage: "disable remote desktop (macOS)"},
+}
@@ -0,0 +1,102 @@
+// Package cmdplist generates raw MDM command plists.
+package cmdplist
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/groob/plist"
+	"github.com/jessepeterson/nanomdm/mdm"
+)
+
+// Command is a generic MDM command ready to be marshalled into a plist.
+type Command struct {
+	CommandUUID string
+	Command     map[string]interface{}
+}
+
+// NewUUID generates a random (version 4) UUID string.
+func NewUUID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// New creates a new command of requestType with a random CommandUUID.
+func New(requestType string) *Command {
+	return &Command{
+		CommandUUID: NewUUID(),
+		Command:     map[string]interface{}{"RequestType": requestType},
+	}
+}
+
+// RequestType returns the RequestType of c.
+func (c *Command) RequestType() string {
+	rt, _ := c.Command["RequestType"].(string)
+	return rt
+}
+
+// Set sets the command key to value and returns c.
+func (c *Command) Set(key string, value interface{}) *Command {
+	c.Command[key] = value
+	return c
+}
+
+// Plist marshals c into an XML plist.
+func (c *Command) Plist() ([]byte, error) {
+	if c.CommandUUID == "" || c.RequestType() == "" {
+		return nil, errors.New("command UUID and RequestType required")
+	}
+	return plist.MarshalIndent(c, "\t")
+}
+
+// MDMCommand marshals c and decodes it back into an mdm.Command.
+func (c *Command) MDMCommand() (*mdm.Command, error) {
+	b, err := c.Plist()
+	if err != nil {
+		return nil, err
+	}
+	return mdm.DecodeCommand(b)
+}
+
+// MergeJSON merges the keys of the JSON object in b into c's command
+// dictionary. Integral JSON numbers become plist integers.
+func (c *Command) MergeJSON(b []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var m map[string]interface{}
+	if err := dec.Decode(&m); err != nil {
+		return err
+	}
+	for k, v := range m {
+		c.Command[k] = convertJSONNumbers(v)
+	}
+	return nil
+}
+
+func convertJSONNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		f, _ := t.Float64()
+		return f
+	case map[string]interface{}:
+		for k, v2 := range t {
+			t[k] = convertJSONNumbers(v2)
+		}
+	case []interface{}:
+		for i, v2 := range t {
+			t[i] = convertJSONNumbers(v2)
+		}
+	}
+	return v
+}
@@ -0,0 +1,90 @@
+package cmdplist
+
+import (
+	"testing"
+)
+
+func TestKebab(t *testing.T) {
+	for _, test := range []struct {
+		in  string
+		out string
+	}{
+		{"DeviceInformation", "device-information"},
+		{"OSUpdateStatus", "os-update-status"},
+		{"PIN", "pin"},
+		{"NSExtensionMappings", "ns-extension-mappings"},
+	} {
+		if have, want := kebab(test.in), test.out; have != want {
+			t.Errorf("kebab(%q): have %q, want %q", test.in, have, want)
+		}
+	}
+}
+
+func TestBuild(t *testing.T) {
+	spec := Lookup("device-information")
+	if spec == nil {
+		t.Fatal("command not found")
+	}
+	c, err := spec.Build(map[string]string{"Queries": "UDID, OSVersion"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.MergeJSON([]byte(`{"Extra":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	cmd, err := c.MDMCommand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, have, want := "incorrect RequestType", cmd.Command.RequestType, "DeviceInformation"; have != want {
+		t.Errorf("%s: %q, want: %q", msg, have, want)
+	}
+	if msg, have, want := "incorrect CommandUUID", cmd.CommandUUID, c.CommandUUID; have != want {
+		t.Errorf("%s: %q, want: %q", msg, have, want)
+	}
+	queries, ok := c.Command["Queries"].([]string)
+	if !ok || len(queries) != 2 || queries[1] != "OSVersion" {
+		t.Errorf("incorrect Queries: %v", c.Command["Queries"])
+	}
+}
+
+func TestBuildRequired(t *testing.T) {
+	if _, err := Lookup("RemoveProfile").Build(nil, nil); err == nil {
+		t.Error("expected error for missing required argument")
+	}
+	c, err := Lookup("RemoveProfile").BuildJSON(nil, nil, []byte(`{"Identifier":"com.example"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := c.Command["Identifier"], "com.example"; have != want {
+		t.Errorf("incorrect Identifier: %v, want: %v", have, want)
+	}
+}
+
+func TestNewInstallApplication(t *testing.T) {
+	c, err := NewInstallApplication(Application{ITunesStoreID: 361309726, ManagementFlags: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, have, want := "incorrect RequestType", c.RequestType(), "InstallApplication"; have != want {
+		t.Errorf("%s: %q, want: %q", msg, have, want)
+	}
+	if have, ok := c.Command["iTunesStoreID"].(int64); !ok || have != 361309726 {
+		t.Errorf("incorrect iTunesStoreID: %v", c.Command["iTunesStoreID"])
+	}
+
+	c, err = NewInstallApplication(Application{ManifestURL: "https://example.com/m.plist", Enterprise: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, have, want := "incorrect RequestType", c.RequestType(), "InstallEnterpriseApplication"; have != want {
+		t.Errorf("%s: %q, want: %q", msg, have, want)
+	}
+
+	if _, err = NewInstallApplication(Application{Enterprise: true}); err == nil {
+		t.Error("expected error for enterprise app without manifest URL")
+	}
+	if _, err = NewInstallApplication(Application{}); err == nil {
+		t.Error("expected error for app without store ID, identifier, or manifest URL")
+	}
+}
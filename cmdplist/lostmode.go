@@ -0,0 +1,30 @@
+package cmdplist
+
+import "errors"
+
+// LostMode is the Lost Mode lock screen content of an EnableLostMode
+// command. At least a message or phone number is required.
+// See https://developer.apple.com/documentation/devicemanagement/enablelostmodecommand/command
+type LostMode struct {
+	Message     string `json:"message,omitempty"`
+	PhoneNumber string `json:"phone_number,omitempty"`
+	Footnote    string `json:"footnote,omitempty"`
+}
+
+// NewEnableLostMode creates a new EnableLostMode command.
+func NewEnableLostMode(lm LostMode) (*Command, error) {
+	if lm.Message == "" && lm.PhoneNumber == "" {
+		return nil, errors.New("lost mode requires message or phone number")
+	}
+	c := New("EnableLostMode")
+	if lm.Message != "" {
+		c.Set("Message", lm.Message)
+	}
+	if lm.PhoneNumber != "" {
+		c.Set("PhoneNumber", lm.PhoneNumber)
+	}
+	if lm.Footnote != "" {
+		c.Set("Footnote", lm.Footnote)
+	}
+	return c, nil
+}
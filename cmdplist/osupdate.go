@@ -0,0 +1,38 @@
+package cmdplist
+
+// OSUpdate is an OS update to schedule with a ScheduleOSUpdate command.
+// See https://developer.apple.com/documentation/devicemanagement/scheduleosupdatecommand/command/updatesitem
+type OSUpdate struct {
+	ProductKey     string `json:"product_key,omitempty"`
+	ProductVersion string `json:"product_version,omitempty"`
+	// InstallAction is one of Default, DownloadOnly, InstallASAP,
+	// NotifyOnly, or InstallLater.
+	InstallAction    string `json:"install_action"`
+	MaxUserDeferrals int    `json:"max_user_deferrals,omitempty"`
+	Priority         string `json:"priority,omitempty"`
+}
+
+// NewScheduleOSUpdate creates a new ScheduleOSUpdate command for updates.
+func NewScheduleOSUpdate(updates ...OSUpdate) *Command {
+	items := make([]interface{}, 0, len(updates))
+	for _, u := range updates {
+		item := map[string]interface{}{"InstallAction": u.InstallAction}
+		if u.InstallAction == "" {
+			item["InstallAction"] = "Default"
+		}
+		if u.ProductKey != "" {
+			item["ProductKey"] = u.ProductKey
+		}
+		if u.ProductVersion != "" {
+			item["ProductVersion"] = u.ProductVersion
+		}
+		if u.MaxUserDeferrals > 0 {
+			item["MaxUserDeferrals"] = u.MaxUserDeferrals
+		}
+		if u.Priority != "" {
+			item["Priority"] = u.Priority
+		}
+		items = append(items, item)
+	}
+	return New("ScheduleOSUpdate").Set("Updates", items)
+}
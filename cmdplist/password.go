@@ -0,0 +1,36 @@
+package cmdplist
+
+// NewSetRecoveryLock creates a new SetRecoveryLock command. An empty
+// newPassword clears the recovery lock.
+// See https://developer.apple.com/documentation/devicemanagement/setrecoverylockcommand/command
+func NewSetRecoveryLock(currentPassword, newPassword string) *Command {
+	c := New("SetRecoveryLock").Set("NewPassword", newPassword)
+	if currentPassword != "" {
+		c.Set("CurrentPassword", currentPassword)
+	}
+	return c
+}
+
+// NewVerifyRecoveryLock creates a new VerifyRecoveryLock command.
+func NewVerifyRecoveryLock(password string) *Command {
+	return New("VerifyRecoveryLock").Set("Password", password)
+}
+
+// NewSetFirmwarePassword creates a new SetFirmwarePassword command. An
+// empty newPassword clears the firmware password.
+// See https://developer.apple.com/documentation/devicemanagement/setfirmwarepasswordcommand/command
+func NewSetFirmwarePassword(currentPassword, newPassword string, allowOroms bool) *Command {
+	c := New("SetFirmwarePassword").Set("NewPassword", newPassword)
+	if currentPassword != "" {
+		c.Set("CurrentPassword", currentPassword)
+	}
+	if allowOroms {
+		c.Set("AllowOroms", true)
+	}
+	return c
+}
+
+// NewVerifyFirmwarePassword creates a new VerifyFirmwarePassword command.
+func NewVerifyFirmwarePassword(password string) *Command {
+	return New("VerifyFirmwarePassword").Set("Password", password)
+}
@@ -0,0 +1,72 @@
+package cryptoutil
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+
+	"go.mozilla.org/pkcs7"
+)
+
+// CMSSigner signs content as PKCS #7 (CMS) signed data with an
+// identity certificate and its private key.
+type CMSSigner struct {
+	cert  *x509.Certificate
+	key   crypto.Signer
+	chain []*x509.Certificate
+}
+
+// NewCMSSigner creates a new CMS signer from the certificate chain and
+// private key of pair (see X509KeyPair). The intermediate certificates
+// of the chain are included in signatures.
+func NewCMSSigner(pair tls.Certificate) (*CMSSigner, error) {
+	if len(pair.Certificate) < 1 {
+		return nil, errors.New("no certificate found")
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("private key is not a signer")
+	}
+	s := &CMSSigner{key: key, cert: pair.Leaf}
+	for i, der := range pair.Certificate {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			s.cert = cert
+		} else {
+			s.chain = append(s.chain, cert)
+		}
+	}
+	return s, nil
+}
+
+// sign signs content with SHA-256, detaching it if detach is true.
+func (s *CMSSigner) sign(content []byte, detach bool) ([]byte, error) {
+	sd, err := pkcs7.NewSignedData(content)
+	if err != nil {
+		return nil, err
+	}
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	if err = sd.AddSignerChain(s.cert, s.key, s.chain, pkcs7.SignerInfoConfig{}); err != nil {
+		return nil, err
+	}
+	if detach {
+		sd.Detach()
+	}
+	return sd.Finish()
+}
+
+// Sign returns the DER-encoded signed data containing content (e.g. a
+// signed configuration profile).
+func (s *CMSSigner) Sign(content []byte) ([]byte, error) {
+	return s.sign(content, false)
+}
+
+// SignDetached returns a DER-encoded detached signature of content
+// (i.e. signed data without the content).
+func (s *CMSSigner) SignDetached(content []byte) ([]byte, error) {
+	return s.sign(content, true)
+}
@@ -0,0 +1,49 @@
+package cryptoutil
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestCMSSigner(t *testing.T) {
+	pemCert, pemKey := selfSignedPushCert(t, "com.apple.mgmt.External.test", time.Now().Add(time.Hour))
+	pair, err := tls.X509KeyPair(pemCert, pemKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := NewCMSSigner(pair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("<plist></plist>")
+
+	sig, err := signer.SignDetached(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := VerifyMdmSignature(base64.StdEncoding.EncodeToString(sig), content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cert.Raw, pair.Certificate[0]) {
+		t.Error("signer certificate mismatch")
+	}
+	if _, err = VerifyMdmSignature(base64.StdEncoding.EncodeToString(sig), []byte("tampered")); err == nil {
+		t.Error("expected verification error")
+	}
+
+	signed, err := signer.Sign(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedContent, _, err := VerifySignedData(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(signedContent, content) {
+		t.Errorf("content: have %q, want %q", signedContent, content)
+	}
+}
@@ -66,6 +66,24 @@ func VerifyMdmSignature(header string, body []byte) (*x509.Certificate, error) {
 	return cert, nil
 }
 
+// VerifySignedData verifies the signature of the PKCS #7 (CMS) signed
+// data and returns its content and signing certificate. The signing
+// certificate is not verified against any roots.
+func VerifySignedData(signedData []byte) ([]byte, *x509.Certificate, error) {
+	p7, err := pkcs7.Parse(signedData)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = p7.Verify(); err != nil {
+		return nil, nil, err
+	}
+	cert := p7.GetOnlySigner()
+	if cert == nil {
+		return nil, nil, errors.New("invalid or missing signer")
+	}
+	return p7.Content, cert, nil
+}
+
 // PEMCertificate returns derBytes encoded as a PEM block
 func PEMCertificate(derBytes []byte) []byte {
 	block := &pem.Block{
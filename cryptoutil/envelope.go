@@ -0,0 +1,169 @@
+package cryptoutil
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// envelopePEMType is the PEM block type of envelope encrypted data.
+// Using PEM keeps encrypted values recognizable (and distinguishable
+// from unencrypted values) in storage.
+const envelopePEMType = "NANOMDM ENVELOPE"
+
+// KEK is a key encryption key that wraps (encrypts) the per-value data
+// encryption keys of an Envelope. It may be a local key (LocalKEK) or
+// be backed by a KMS.
+type KEK interface {
+	// ID identifies the KEK. It is stored with wrapped keys so the
+	// KEK that wrapped them can be found after rotation.
+	ID() string
+	WrapKey(ctx context.Context, dek []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// LocalKEK is a KEK using a local 32 byte (AES-256) key.
+type LocalKEK struct {
+	id  string
+	key []byte
+}
+
+// NewLocalKEK creates a new KEK from the 32 byte key. Its ID is derived
+// from a hash of the key.
+func NewLocalKEK(key []byte) (*LocalKEK, error) {
+	if len(key) != 32 {
+		return nil, ErrSealKeySize
+	}
+	sum := sha256.Sum256(append([]byte("nanomdm-kek:"), key...))
+	return &LocalKEK{id: "local:" + hex.EncodeToString(sum[:4]), key: key}, nil
+}
+
+func (k *LocalKEK) ID() string {
+	return k.id
+}
+
+func (k *LocalKEK) WrapKey(_ context.Context, dek []byte) ([]byte, error) {
+	return Seal(k.key, dek, []byte(k.id))
+}
+
+func (k *LocalKEK) UnwrapKey(_ context.Context, wrapped []byte) ([]byte, error) {
+	return Open(k.key, wrapped, []byte(k.id))
+}
+
+// Envelope encrypts values with a random data encryption key (DEK)
+// which is itself wrapped by the primary KEK. Values encrypted with
+// previous KEKs can still be decrypted which allows for KEK rotation.
+type Envelope struct {
+	primary KEK
+	keks    map[string]KEK
+}
+
+// NewEnvelope creates a new Envelope encrypting with primary and
+// decrypting with primary or any of previous.
+func NewEnvelope(primary KEK, previous ...KEK) *Envelope {
+	e := &Envelope{primary: primary, keks: map[string]KEK{primary.ID(): primary}}
+	for _, kek := range previous {
+		if _, ok := e.keks[kek.ID()]; !ok {
+			e.keks[kek.ID()] = kek
+		}
+	}
+	return e
+}
+
+// Encrypt encrypts plaintext (and authenticates additionalData) and
+// returns it as a PEM block.
+func (e *Envelope) Encrypt(ctx context.Context, plaintext, additionalData []byte) ([]byte, error) {
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, err
+	}
+	wrapped, err := e.primary.WrapKey(ctx, dek)
+	if err != nil {
+		return nil, fmt.Errorf("wrapping key: %w", err)
+	}
+	ciphertext, err := Seal(dek, plaintext, additionalData)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type: envelopePEMType,
+		Headers: map[string]string{
+			"KEK": e.primary.ID(),
+			"DEK": base64.StdEncoding.EncodeToString(wrapped),
+		},
+		Bytes: ciphertext,
+	}), nil
+}
+
+// decodeEnvelope returns the envelope PEM block of data or nil if data
+// is not envelope encrypted.
+func decodeEnvelope(data []byte) *pem.Block {
+	if !bytes.HasPrefix(data, []byte("-----BEGIN "+envelopePEMType+"-----")) {
+		return nil
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != envelopePEMType {
+		return nil
+	}
+	return block
+}
+
+// Decrypt decrypts data encrypted with Encrypt. Data that is not
+// envelope encrypted is returned unchanged so that values stored before
+// encryption was enabled continue to work.
+func (e *Envelope) Decrypt(ctx context.Context, data, additionalData []byte) ([]byte, error) {
+	block := decodeEnvelope(data)
+	if block == nil {
+		return data, nil
+	}
+	kek, ok := e.keks[block.Headers["KEK"]]
+	if !ok {
+		return nil, fmt.Errorf("unknown KEK: %q", block.Headers["KEK"])
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(block.Headers["DEK"])
+	if err != nil {
+		return nil, err
+	}
+	dek, err := kek.UnwrapKey(ctx, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping key: %w", err)
+	}
+	return Open(dek, block.Bytes, additionalData)
+}
+
+// NeedsRotation returns true if data is not envelope encrypted or was
+// encrypted with a KEK other than the primary KEK.
+func (e *Envelope) NeedsRotation(data []byte) bool {
+	block := decodeEnvelope(data)
+	return block == nil || block.Headers["KEK"] != e.primary.ID()
+}
+
+// ErrEnvelopeNoKEK is returned when parsing an empty KEK list.
+var ErrEnvelopeNoKEK = errors.New("no KEK")
+
+// ParseLocalEnvelope creates an Envelope from hex or base64 encoded
+// local keys. The first key is the primary KEK.
+func ParseLocalEnvelope(keys ...string) (*Envelope, error) {
+	var keks []KEK
+	for _, s := range keys {
+		key, err := ParseSealKey(s)
+		if err != nil {
+			return nil, err
+		}
+		kek, err := NewLocalKEK(key)
+		if err != nil {
+			return nil, err
+		}
+		keks = append(keks, kek)
+	}
+	if len(keks) < 1 {
+		return nil, ErrEnvelopeNoKEK
+	}
+	return NewEnvelope(keks[0], keks[1:]...), nil
+}
@@ -0,0 +1,118 @@
+package cryptoutil
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	pkcs12 "software.sslmate.com/src/go-pkcs12"
+)
+
+// mdmTopicPrefix is the prefix of APNs MDM push certificate topics.
+const mdmTopicPrefix = "com.apple.mgmt.External."
+
+var (
+	ErrPushCertExpired     = errors.New("push certificate expired")
+	ErrPushCertNotYetValid = errors.New("push certificate not yet valid")
+)
+
+// PushCertInfo is metadata about an APNs push certificate.
+type PushCertInfo struct {
+	Topic     string    `json:"topic"`
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	Serial    string    `json:"serial"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+}
+
+// ValidatePushCert checks that the PEM-encoded cert is an APNs MDM push
+// certificate valid at now and that it matches the PEM-encoded key (or
+// key reference).
+func ValidatePushCert(ctx context.Context, pemCert, pemKey []byte, now time.Time) (*PushCertInfo, error) {
+	cert, err := DecodePEMCertificate(pemCert)
+	if err != nil {
+		return nil, err
+	}
+	topic, err := TopicFromCert(cert)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(topic, mdmTopicPrefix) {
+		return nil, fmt.Errorf("not an APNs MDM topic: %q", topic)
+	}
+	if _, err = X509KeyPair(ctx, pemCert, pemKey); err != nil {
+		return nil, fmt.Errorf("push certificate and key: %w", err)
+	}
+	if now.After(cert.NotAfter) {
+		return nil, fmt.Errorf("%w: %s", ErrPushCertExpired, cert.NotAfter.Format(time.RFC3339))
+	}
+	if now.Before(cert.NotBefore) {
+		return nil, fmt.Errorf("%w: %s", ErrPushCertNotYetValid, cert.NotBefore.Format(time.RFC3339))
+	}
+	return newPushCertInfo(topic, cert), nil
+}
+
+func newPushCertInfo(topic string, cert *x509.Certificate) *PushCertInfo {
+	return &PushCertInfo{
+		Topic:     topic,
+		Subject:   cert.Subject.String(),
+		Issuer:    cert.Issuer.String(),
+		Serial:    cert.SerialNumber.String(),
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+	}
+}
+
+// PushCertInfoFromPEM returns the metadata of the PEM-encoded (stored)
+// push certificate pemCert without validating it.
+func PushCertInfoFromPEM(pemCert []byte) (*PushCertInfo, error) {
+	cert, err := DecodePEMCertificate(pemCert)
+	if err != nil {
+		return nil, err
+	}
+	topic, err := TopicFromCert(cert)
+	if err != nil {
+		return nil, err
+	}
+	return newPushCertInfo(topic, cert), nil
+}
+
+// PEMFromPKCS12 decodes the password-protected PKCS#12 bundle data
+// and returns its PEM-encoded push certificate and private key. Any
+// other (e.g. intermediate) certificates in the bundle are ignored.
+// Both legacy (3DES/RC2) and modern (AES/PBES2) bundles are supported.
+func PEMFromPKCS12(data []byte, password string) (pemCert, pemKey []byte, err error) {
+	key, leaf, chain, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, cert := range append([]*x509.Certificate{leaf}, chain...) {
+		if _, err = TopicFromCert(cert); err == nil {
+			pemCert = PEMCertificate(cert.Raw)
+			break
+		}
+	}
+	if len(pemCert) < 1 {
+		return nil, nil, errors.New("push certificate not found in PKCS#12")
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		pemKey = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		pemKey = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	default:
+		return nil, nil, fmt.Errorf("unsupported PKCS#12 private key type: %T", key)
+	}
+	return pemCert, pemKey, nil
+}
@@ -0,0 +1,110 @@
+package cryptoutil
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	pkcs12 "software.sslmate.com/src/go-pkcs12"
+)
+
+// selfSignedPushCert returns a PEM-encoded self-signed cert with topic
+// and its PEM-encoded key.
+func selfSignedPushCert(t *testing.T, topic string, notAfter time.Time) ([]byte, []byte) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName: "APSP:test",
+			ExtraNames: []pkix.AttributeTypeAndValue{{Type: oidUID, Value: topic}},
+		},
+		NotBefore: notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:  notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return PEMCertificate(der), pemKey
+}
+
+func TestValidatePushCert(t *testing.T) {
+	now := time.Now()
+	topic := "com.apple.mgmt.External.e3b8ceac-1f18-2c8e-8a63-dd17d99435d9"
+
+	pemCert, pemKey := selfSignedPushCert(t, topic, now.Add(time.Hour))
+	info, err := ValidatePushCert(context.Background(), pemCert, pemKey, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Topic != topic {
+		t.Errorf("topic: have %q, want %q", info.Topic, topic)
+	}
+
+	if _, err = ValidatePushCert(context.Background(), pemCert, pemKey, now.Add(2*time.Hour)); !errors.Is(err, ErrPushCertExpired) {
+		t.Errorf("have %v, want %v", err, ErrPushCertExpired)
+	}
+
+	_, otherKey := selfSignedPushCert(t, topic, now.Add(time.Hour))
+	if _, err = ValidatePushCert(context.Background(), pemCert, otherKey, now); err == nil {
+		t.Error("expected key mismatch error")
+	}
+
+	pemCert, pemKey = selfSignedPushCert(t, "com.apple.mgmt.test", now.Add(time.Hour))
+	if _, err = ValidatePushCert(context.Background(), pemCert, pemKey, now); err == nil {
+		t.Error("expected non-MDM topic error")
+	}
+}
+
+func TestPEMFromPKCS12(t *testing.T) {
+	now := time.Now()
+	topic := "com.apple.mgmt.External.e3b8ceac-1f18-2c8e-8a63-dd17d99435d9"
+	pemCert, pemKey := selfSignedPushCert(t, topic, now.Add(time.Hour))
+	cert, err := DecodePEMCertificate(pemCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(pemKey)
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPEM, _ := selfSignedPushCert(t, "com.apple.mgmt.test", now.Add(time.Hour))
+	ca, err := DecodePEMCertificate(caPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, enc := range []*pkcs12.Encoder{pkcs12.Modern, pkcs12.LegacyDES} {
+		data, err := enc.Encode(key, cert, []*x509.Certificate{ca}, "secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err = PEMFromPKCS12(data, "wrong"); err == nil {
+			t.Error("expected password error")
+		}
+		haveCert, haveKey, err := PEMFromPKCS12(data, "secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(haveCert, pemCert) {
+			t.Error("push certificate mismatch")
+		}
+		if _, err = ValidatePushCert(context.Background(), haveCert, haveKey, now); err != nil {
+			t.Error(err)
+		}
+	}
+}
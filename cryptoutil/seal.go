@@ -0,0 +1,71 @@
+package cryptoutil
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// ErrSealKeySize is returned for sealing keys that are not 32 bytes.
+var ErrSealKeySize = errors.New("sealing key must be 32 bytes")
+
+// ParseSealKey decodes a 32 byte (AES-256) sealing key from its hex or
+// base64 encoding.
+func ParseSealKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		key, err = base64.StdEncoding.DecodeString(s)
+	}
+	if err != nil {
+		return nil, errors.New("sealing key must be hex or base64 encoded")
+	}
+	if len(key) != 32 {
+		return nil, ErrSealKeySize
+	}
+	return key, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, ErrSealKeySize
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts and authenticates plaintext (and authenticates
+// additionalData) with AES-256-GCM using key. The random nonce is
+// prepended to the returned ciphertext.
+func Seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// Open decrypts and authenticates ciphertext (and additionalData)
+// sealed with Seal using key.
+func Open(key, ciphertext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
@@ -0,0 +1,33 @@
+package cryptoutil
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	key, err := ParseSealKey("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := Seal(key, []byte("secret"), []byte("id1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("secret")) {
+		t.Error("plaintext in sealed output")
+	}
+	opened, err := Open(key, sealed, []byte("id1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := string(opened), "secret"; have != want {
+		t.Errorf("have %q, want %q", have, want)
+	}
+	if _, err = Open(key, sealed, []byte("id2")); err == nil {
+		t.Error("expected error opening with different additional data")
+	}
+	if _, err = ParseSealKey("00"); err != ErrSealKeySize {
+		t.Errorf("expected key size error, have %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package cryptoutil
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// keyRefPEMType is the PEM block type of private key references.
+const keyRefPEMType = "NANOMDM KEY REFERENCE"
+
+// SignerProvider provides the crypto.Signer of a private key that is
+// held outside of NanoMDM storage, e.g. in a KMS or a PKCS#11 HSM.
+type SignerProvider interface {
+	// Signer returns the signer of the key referenced by uri.
+	Signer(ctx context.Context, uri string) (crypto.Signer, error)
+}
+
+// SignerProviderFunc is an adapter to use a function as a SignerProvider.
+type SignerProviderFunc func(ctx context.Context, uri string) (crypto.Signer, error)
+
+func (f SignerProviderFunc) Signer(ctx context.Context, uri string) (crypto.Signer, error) {
+	return f(ctx, uri)
+}
+
+var (
+	signerProvidersMu sync.RWMutex
+	signerProviders   = map[string]SignerProvider{
+		"file": SignerProviderFunc(fileSigner),
+	}
+)
+
+// RegisterSignerProvider makes provider available for key reference
+// URIs with scheme (e.g. "awskms", "gcpkms", or "pkcs11"). Programs
+// embedding NanoMDM register providers for their KMS or HSM at
+// startup, much like database/sql drivers. A "file" provider for PEM
+// keys on disk is registered by default.
+func RegisterSignerProvider(scheme string, provider SignerProvider) {
+	signerProvidersMu.Lock()
+	defer signerProvidersMu.Unlock()
+	signerProviders[scheme] = provider
+}
+
+// KeyReferencePEM returns a PEM-encoded reference to the private key at
+// uri. It is stored in place of a PEM-encoded private key.
+func KeyReferencePEM(uri string) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: keyRefPEMType, Bytes: []byte(uri)})
+}
+
+// keyReference returns the URI of the key reference in pemKey or an
+// empty string if pemKey is not a key reference.
+func keyReference(pemKey []byte) string {
+	if !bytes.Contains(pemKey, []byte("-----BEGIN "+keyRefPEMType+"-----")) {
+		return ""
+	}
+	block, _ := pem.Decode(pemKey)
+	if block == nil || block.Type != keyRefPEMType {
+		return ""
+	}
+	return string(block.Bytes)
+}
+
+// ResolveSigner returns the signer of the key referenced by uri using
+// the provider registered for its scheme.
+func ResolveSigner(ctx context.Context, uri string) (crypto.Signer, error) {
+	i := strings.Index(uri, ":")
+	if i < 1 {
+		return nil, fmt.Errorf("invalid key reference: %q", uri)
+	}
+	signerProvidersMu.RLock()
+	provider, ok := signerProviders[uri[:i]]
+	signerProvidersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no signer provider for scheme: %q", uri[:i])
+	}
+	return provider.Signer(ctx, uri)
+}
+
+// X509KeyPair is like tls.X509KeyPair but pemKey may also be a key
+// reference (see KeyReferencePEM) in which case the private key of the
+// returned certificate is the crypto.Signer of the referenced key.
+func X509KeyPair(ctx context.Context, pemCert, pemKey []byte) (tls.Certificate, error) {
+	uri := keyReference(pemKey)
+	if uri == "" {
+		return tls.X509KeyPair(pemCert, pemKey)
+	}
+	var cert tls.Certificate
+	for {
+		var block *pem.Block
+		block, pemCert = pem.Decode(pemCert)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			cert.Certificate = append(cert.Certificate, block.Bytes)
+		}
+	}
+	if len(cert.Certificate) < 1 {
+		return cert, errors.New("no certificate found")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return cert, err
+	}
+	signer, err := ResolveSigner(ctx, uri)
+	if err != nil {
+		return cert, err
+	}
+	pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(leaf.PublicKey) {
+		return cert, errors.New("private key does not match public key")
+	}
+	cert.PrivateKey = signer
+	cert.Leaf = leaf
+	return cert, nil
+}
+
+// fileSigner returns the signer of the PEM-encoded private key at the
+// path of a "file:" URI.
+func fileSigner(_ context.Context, uri string) (crypto.Signer, error) {
+	path := strings.TrimPrefix(strings.TrimPrefix(uri, "file:"), "//")
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM private key found")
+	}
+	var key interface{}
+	if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+		if key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+			if key, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+				return nil, err
+			}
+		}
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("private key is not a signer")
+	}
+	return signer, nil
+}
@@ -0,0 +1,43 @@
+package cryptoutil
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestX509KeyPairReference(t *testing.T) {
+	pemCert, pemKey := selfSignedPushCert(t, "com.apple.mgmt.External.test", time.Now().Add(time.Hour))
+	dir, err := ioutil.TempDir("", "signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "push.key")
+	if err = ioutil.WriteFile(path, pemKey, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := X509KeyPair(context.Background(), pemCert, KeyReferencePEM("file:"+path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cert.PrivateKey.(crypto.Signer); !ok || cert.Leaf == nil {
+		t.Errorf("unexpected certificate: %+v", cert)
+	}
+
+	RegisterSignerProvider("test", SignerProviderFunc(func(context.Context, string) (crypto.Signer, error) {
+		return nil, errors.New("unavailable")
+	}))
+	if _, err = X509KeyPair(context.Background(), pemCert, KeyReferencePEM("test:key")); err == nil {
+		t.Error("expected provider error")
+	}
+	if _, err = X509KeyPair(context.Background(), pemCert, KeyReferencePEM("unknown:key")); err == nil {
+		t.Error("expected unknown scheme error")
+	}
+}
@@ -7,6 +7,10 @@ require (
 	github.com/go-sql-driver/mysql v1.6.0
 	github.com/groob/plist v0.0.0-20210519001750-9f754062e6d6
 	go.mozilla.org/pkcs7 v0.0.0-20200128120323-432b2356ecb1
+	golang.org/x/crypto v0.21.0 // indirect
+	golang.org/x/term v0.18.0
+	gopkg.in/yaml.v3 v3.0.1
+	software.sslmate.com/src/go-pkcs12 v0.4.0
 )
 
 replace go.mozilla.org/pkcs7 v0.0.0-20200128120323-432b2356ecb1 => github.com/omorsi/pkcs7 v0.0.0-20210217142924-a7b80a2a8568
@@ -2,18 +2,22 @@ package http
 
 import (
 	"bytes"
-	"crypto/x509"
+	"context"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 
+	"github.com/jessepeterson/nanomdm/bus"
 	"github.com/jessepeterson/nanomdm/cryptoutil"
 	"github.com/jessepeterson/nanomdm/log"
 	"github.com/jessepeterson/nanomdm/mdm"
 	"github.com/jessepeterson/nanomdm/push"
+	"github.com/jessepeterson/nanomdm/service/callback"
 	"github.com/jessepeterson/nanomdm/storage"
 )
 
@@ -35,6 +39,33 @@ type apiResult struct {
 	CommandError string             `json:"command_error,omitempty"`
 	CommandUUID  string             `json:"command_uuid,omitempty"`
 	RequestType  string             `json:"request_type,omitempty"`
+	// Results are the command results of the enrollments waited for.
+	Results     map[string]*callback.Result `json:"results,omitempty"`
+	WaitTimeout bool                        `json:"wait_timeout,omitempty"`
+}
+
+type pushOptions struct {
+	groups      storage.GroupStore
+	smartGroups SmartGroupStore
+}
+
+// PushOption configures PushHandlerFunc.
+type PushOption func(*pushOptions)
+
+// WithPushGroups pushes to the members of the group named by the
+// "group" query parameter.
+func WithPushGroups(groups storage.GroupStore) PushOption {
+	return func(o *pushOptions) {
+		o.groups = groups
+	}
+}
+
+// WithPushSmartGroups pushes to the members of the smart group named by
+// the "smart_group" query parameter.
+func WithPushSmartGroups(smartGroups SmartGroupStore) PushOption {
+	return func(o *pushOptions) {
+		o.smartGroups = smartGroups
+	}
 }
 
 // PushHandlerFunc sends APNs push notifications to MDM enrollments.
@@ -43,9 +74,18 @@ type apiResult struct {
 // probably necessitates stripping the URL prefix before using. Also
 // note we expose Go errors to the output as this is meant for "API"
 // users.
-func PushHandlerFunc(pusher push.Pusher, logger log.Logger) http.HandlerFunc {
+func PushHandlerFunc(pusher push.Pusher, logger log.Logger, opts ...PushOption) http.HandlerFunc {
+	config := new(pushOptions)
+	for _, opt := range opts {
+		opt(config)
+	}
 	return func(w http.ResponseWriter, r *http.Request) {
-		ids := strings.Split(r.URL.Path, ",")
+		ids, status, err := targetIDs(r.Context(), r, config.groups, config.smartGroups)
+		if err != nil {
+			logger.Info("msg", "resolving group", "err", err)
+			http.Error(w, err.Error(), status)
+			return
+		}
 		output := apiResult{
 			Status: make(enrolledAPIResults),
 		}
@@ -79,6 +119,81 @@ func PushHandlerFunc(pusher push.Pusher, logger log.Logger) http.HandlerFunc {
 	}
 }
 
+// errInvalidChannel is returned for an invalid channel parameter.
+var errInvalidChannel = errors.New("invalid channel")
+
+// deviceChannelID returns the device channel enrollment ID of id by the
+// NanoMDM enrollment ID convention (i.e. with any user channel ID after
+// a colon removed).
+func deviceChannelID(id string) string {
+	if i := strings.Index(id, ":"); i >= 0 {
+		return id[:i]
+	}
+	return id
+}
+
+// channelIDs resolves the enrollment IDs of channel for the enrollment
+// ids. The "device" channel is the device channel enrollments of ids,
+// "user" all user channel enrollments of their devices, and "all"
+// both. An empty channel uses ids as given. User channels need lister.
+func channelIDs(ctx context.Context, lister storage.UserChannelLister, ids []string, channel string) ([]string, error) {
+	if channel == "" {
+		return ids, nil
+	}
+	var devices []string
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		id = deviceChannelID(id)
+		if !seen[id] {
+			seen[id] = true
+			devices = append(devices, id)
+		}
+	}
+	switch channel {
+	case "device":
+		return devices, nil
+	case "user", "all":
+	default:
+		return nil, fmt.Errorf("%w: %q", errInvalidChannel, channel)
+	}
+	if lister == nil {
+		return nil, fmt.Errorf("%w: user channels not supported by storage", errInvalidChannel)
+	}
+	userIDs, err := lister.ListUserChannelIDs(ctx, devices)
+	if err != nil {
+		return nil, fmt.Errorf("listing user channels: %w", err)
+	}
+	var resolved []string
+	for _, id := range devices {
+		if channel == "all" {
+			resolved = append(resolved, id)
+		}
+		resolved = append(resolved, userIDs[id]...)
+	}
+	if len(resolved) < 1 {
+		return nil, fmt.Errorf("%w: no user channel enrollments", errInvalidChannel)
+	}
+	return resolved, nil
+}
+
+// MaxEnqueueWait is the longest the enqueue API waits for results.
+const MaxEnqueueWait = 5 * time.Minute
+
+type enqueueOptions struct {
+	bus bus.Bus
+}
+
+// EnqueueOption configures RawCommandEnqueueHandler.
+type EnqueueOption func(*enqueueOptions)
+
+// WithResultBus waits for command results from b if requested with
+// the "wait" query parameter.
+func WithResultBus(b bus.Bus) EnqueueOption {
+	return func(o *enqueueOptions) {
+		o.bus = b
+	}
+}
+
 // RawCommandEnqueueHandler enqueues a raw MDM command plist and sends
 // push notifications to MDM enrollments.
 //
@@ -86,7 +201,30 @@ func PushHandlerFunc(pusher push.Pusher, logger log.Logger) http.HandlerFunc {
 // push to. This probably necessitates stripping the URL prefix before
 // using. Also note we expose Go errors to the output as this is meant
 // for "API" users.
-func RawCommandEnqueueHandler(enqueuer storage.CommandEnqueuer, pusher push.Pusher, logger log.Logger) http.HandlerFunc {
+//
+// The "group" query parameter enqueues to the members of the group (in
+// place of the URL path identifiers) if enqueuer is a
+// storage.GroupStore. Likewise the "smart_group" query parameter
+// enqueues to the members of the smart group if enqueuer is a
+// SmartGroupStore.
+//
+// The "channel" query parameter enqueues to the "device" channel, the
+// "user" channel enrollments, or "all" channels of the devices of the
+// identifiers if enqueuer is a storage.UserChannelLister.
+//
+// The "callback_url" query parameter is an HTTP(S) URL that the results
+// of the command are posted to if enqueuer is a
+// storage.CommandCallbackStore.
+//
+// The "wait" query parameter is a duration (of at most MaxEnqueueWait)
+// to wait for the command results of the enrollments. The parsed
+// results received within the duration are returned with the response.
+// Requires the WithResultBus option.
+func RawCommandEnqueueHandler(enqueuer storage.CommandEnqueuer, pusher push.Pusher, logger log.Logger, opts ...EnqueueOption) http.HandlerFunc {
+	config := new(enqueueOptions)
+	for _, opt := range opts {
+		opt(config)
+	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		b, err := ReadAllAndReplaceBody(r)
 		if err != nil {
@@ -100,7 +238,57 @@ func RawCommandEnqueueHandler(enqueuer storage.CommandEnqueuer, pusher push.Push
 			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 			return
 		}
-		ids := strings.Split(r.URL.Path, ",")
+		groups, _ := enqueuer.(storage.GroupStore)
+		smartGroups, _ := enqueuer.(SmartGroupStore)
+		ids, status, err := targetIDs(r.Context(), r, groups, smartGroups)
+		if err != nil {
+			logger.Info("msg", "resolving group", "err", err)
+			http.Error(w, err.Error(), status)
+			return
+		}
+		lister, _ := enqueuer.(storage.UserChannelLister)
+		ids, err = channelIDs(r.Context(), lister, ids, r.URL.Query().Get("channel"))
+		if errors.Is(err, errInvalidChannel) {
+			logger.Info("msg", "resolving channel", "err", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			logger.Info("msg", "resolving channel", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		callbackURL := r.URL.Query().Get("callback_url")
+		callbacks, _ := enqueuer.(storage.CommandCallbackStore)
+		if callbackURL != "" {
+			if err = validCallbackURL(callbackURL); err == nil && callbacks == nil {
+				err = errors.New("command callbacks not supported")
+			}
+			if err != nil {
+				logger.Info("msg", "callback url", "err", err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		var wait time.Duration
+		if waitParam := r.URL.Query().Get("wait"); waitParam != "" {
+			if wait, err = time.ParseDuration(waitParam); err == nil && (wait <= 0 || wait > MaxEnqueueWait) {
+				err = fmt.Errorf("wait must be positive and at most %s", MaxEnqueueWait)
+			} else if err == nil && config.bus == nil {
+				err = errors.New("waiting for results not supported")
+			}
+			if err != nil {
+				logger.Info("msg", "wait", "err", err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		var results <-chan []byte
+		if wait > 0 {
+			// subscribe before enqueuing so no result is missed
+			var cancel func()
+			results, cancel = config.bus.Subscribe(bus.CommandResultTopic(command.CommandUUID), len(ids))
+			defer cancel()
+		}
 		nopush := r.URL.Query().Get("nopush") != ""
 		output := apiResult{
 			Status:      make(enrolledAPIResults),
@@ -109,9 +297,25 @@ func RawCommandEnqueueHandler(enqueuer storage.CommandEnqueuer, pusher push.Push
 			RequestType: command.Command.RequestType,
 		}
 		idErrs, err := enqueuer.EnqueueCommand(r.Context(), ids, command)
+		var enqueued []string
 		if err != nil {
 			logger.Info("msg", "enqueue command", "err", err)
 			output.CommandError = err.Error()
+		} else {
+			for _, id := range ids {
+				if idErrs[id] == nil {
+					enqueued = append(enqueued, id)
+				}
+			}
+			emitEnqueued(r, enqueued, command.Command.RequestType, command.CommandUUID)
+		}
+		if err == nil && callbackURL != "" {
+			// store before pushing so the callback exists by the time
+			// the device responds
+			if err = callbacks.StoreCommandCallback(r.Context(), enqueued, command.CommandUUID, callbackURL); err != nil {
+				logger.Info("msg", "store command callback", "err", err)
+				output.CommandError = err.Error()
+			}
 		}
 		pushResp := make(map[string]*push.Response)
 		if !nopush {
@@ -150,11 +354,27 @@ func RawCommandEnqueueHandler(enqueuer storage.CommandEnqueuer, pusher push.Push
 			"id_first", ids[0],
 		)
 		logger.Debug("msg", "push", "count", len(pushResp))
+		if results != nil && output.CommandError == "" {
+			waiting := make(map[string]bool)
+			for _, id := range ids {
+				if idErrs[id] == nil {
+					waiting[id] = true
+				}
+			}
+			output.Results, output.WaitTimeout = waitResults(r.Context(), results, waiting, wait)
+		}
+		status = storageErrorStatus(err)
+		if status == 0 && idErrs != nil {
+			status = enqueueErrorStatus(ids, idErrs)
+		}
 		json, err := json.MarshalIndent(output, "", "\t")
 		if err != nil {
 			logger.Info("msg", "marshal json", "err", err)
 		}
 		w.Header().Set("Content-type", "application/json")
+		if status != 0 {
+			w.WriteHeader(status)
+		}
 		_, err = w.Write(json)
 		if err != nil {
 			logger.Info("msg", "writing body", "err", err)
@@ -162,11 +382,94 @@ func RawCommandEnqueueHandler(enqueuer storage.CommandEnqueuer, pusher push.Push
 	}
 }
 
+// storageErrorStatus returns the HTTP status code of storage error err:
+// 404 for storage.ErrNotFound, 409 for storage.ErrConflict, and 410 for
+// storage.ErrDisabledEnrollment. It returns zero for other errors.
+func storageErrorStatus(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, storage.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, storage.ErrDisabledEnrollment):
+		return http.StatusGone
+	}
+	return 0
+}
+
+// enqueueErrorStatus returns the status code (see storageErrorStatus)
+// of the enqueue errors of ids if the command was enqueued to none of
+// ids and all failed with the same status. Otherwise it returns zero.
+func enqueueErrorStatus(ids []string, idErrs map[string]error) int {
+	var status int
+	for _, id := range ids {
+		idStatus := storageErrorStatus(idErrs[id])
+		if idStatus == 0 || (status != 0 && idStatus != status) {
+			return 0
+		}
+		status = idStatus
+	}
+	return status
+}
+
+// waitResults receives results for the waiting enrollment IDs until
+// all are received or wait elapses (reported with timedOut).
+func waitResults(ctx context.Context, results <-chan []byte, waiting map[string]bool, wait time.Duration) (received map[string]*callback.Result, timedOut bool) {
+	received = make(map[string]*callback.Result)
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for len(received) < len(waiting) {
+		select {
+		case payload := <-results:
+			result, err := callback.DecodeResult(payload)
+			if err == nil && waiting[result.ID] {
+				received[result.ID] = result
+			}
+		case <-timer.C:
+			return received, true
+		case <-ctx.Done():
+			return received, true
+		}
+	}
+	return received, false
+}
+
+// validCallbackURL checks that rawURL is an absolute HTTP(S) URL.
+func validCallbackURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid callback url: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid callback url: %s", rawURL)
+	}
+	return nil
+}
+
+// PKCS12PasswordHeader is the HTTP header with the password of an
+// uploaded PKCS#12 push certificate bundle.
+const PKCS12PasswordHeader = "X-PKCS12-Password"
+
 // StorePushCertHandlerFunc reads a PEM-encoded certificate and private
 // key from the HTTP body and saves it to storage. This effectively
 // enables us to do something like:
 // "% cat push.pem push.key | curl -T - http://api.example.com/" to
 // upload our push certs.
+//
+// A PKCS#12 bundle (e.g. as exported from Keychain Access) is accepted
+// instead if the body is not PEM-encoded or the Content-Type is
+// "application/x-pkcs12". Its password is read from the
+// PKCS12PasswordHeader header.
+//
+// Instead of a private key a key reference (see
+// cryptoutil.KeyReferencePEM) to a key held in a KMS or HSM may be
+// uploaded with a PEM certificate.
+//
+// The certificate must be a currently valid APNs MDM push certificate
+// matching the private key. Metadata about the stored certificate is
+// returned.
 func StorePushCertHandlerFunc(storage storage.PushCertStore, logger log.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		b, err := ReadAllAndReplaceBody(r)
@@ -175,54 +478,240 @@ func StorePushCertHandlerFunc(storage storage.PushCertStore, logger log.Logger)
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
-		// if the PEM blocks are mushed together with no newline then add one
-		b = bytes.ReplaceAll(b, []byte("----------"), []byte("-----\n-----"))
-		var pemCert []byte
-		var pemKey []byte
-		var topic string
-		var block *pem.Block
-		for {
-			block, b = pem.Decode(b)
-			if block == nil {
-				break
-			}
-			switch block.Type {
-			case "CERTIFICATE":
-				pemCert = pem.EncodeToMemory(block)
-				cert, err := x509.ParseCertificate(block.Bytes)
-				if err == nil {
-					topic, err = cryptoutil.TopicFromCert(cert)
-				}
-			case "RSA PRIVATE KEY", "PRIVATE KEY":
-				pemKey = pem.EncodeToMemory(block)
-			default:
-				err = fmt.Errorf("unrecognized PEM type: %q", block.Type)
-			}
-			if err != nil {
-				break
-			}
+		var pemCert, pemKey []byte
+		if r.Header.Get("Content-Type") == "application/x-pkcs12" || !bytes.Contains(b, []byte("-----BEGIN ")) {
+			pemCert, pemKey, err = cryptoutil.PEMFromPKCS12(b, r.Header.Get(PKCS12PasswordHeader))
+		} else {
+			pemCert, pemKey, err = decodePushCertPEM(b)
 		}
+		var info *cryptoutil.PushCertInfo
 		if err == nil {
-			if len(pemCert) == 0 {
-				err = errors.New("cert not found")
-			} else if len(pemKey) == 0 {
-				err = errors.New("private key not found")
-			}
+			info, err = cryptoutil.ValidatePushCert(r.Context(), pemCert, pemKey, time.Now())
 		}
 		if err == nil {
 			err = storage.StorePushCert(r.Context(), pemCert, pemKey)
 		}
 		output := &struct {
-			Error string `json:"error,omitempty"`
-			Topic string `json:"topic,omitempty"`
-		}{
-			Topic: topic,
+			Error    string                   `json:"error,omitempty"`
+			Topic    string                   `json:"topic,omitempty"`
+			PushCert *cryptoutil.PushCertInfo `json:"push_cert,omitempty"`
+		}{}
+		if info != nil {
+			output.Topic = info.Topic
 		}
 		if err != nil {
 			logger.Info("msg", "store push cert", "err", err)
 			output.Error = err.Error()
 		} else {
-			logger.Info("msg", "stored push cert", "topic", topic)
+			output.PushCert = info
+			logger.Info("msg", "stored push cert", "topic", info.Topic, "not_after", info.NotAfter)
+		}
+		json, err := json.MarshalIndent(output, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
+
+// decodePushCertPEM returns the PEM-encoded certificate and private key
+// in the PEM blocks in b.
+func decodePushCertPEM(b []byte) (pemCert, pemKey []byte, err error) {
+	// if the PEM blocks are mushed together with no newline then add one
+	b = bytes.ReplaceAll(b, []byte("----------"), []byte("-----\n-----"))
+	var block *pem.Block
+	for {
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "CERTIFICATE":
+			pemCert = pem.EncodeToMemory(block)
+		case "RSA PRIVATE KEY", "PRIVATE KEY", "EC PRIVATE KEY", "NANOMDM KEY REFERENCE":
+			pemKey = pem.EncodeToMemory(block)
+		default:
+			return nil, nil, fmt.Errorf("unrecognized PEM type: %q", block.Type)
+		}
+	}
+	if len(pemCert) == 0 {
+		return nil, nil, errors.New("cert not found")
+	} else if len(pemKey) == 0 {
+		return nil, nil, errors.New("private key not found")
+	}
+	return pemCert, pemKey, nil
+}
+
+// ListEnrollmentsHandlerFunc returns a JSON list of MDM enrollments.
+// The "device" query parameter lists only the device channel enrollment
+// of that ID and its user channel enrollments. The
+// "awaiting_configuration" query parameter lists only enrollments that
+// are (or with "0" are not) awaiting configuration.
+func ListEnrollmentsHandlerFunc(lister storage.EnrollmentLister, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		output := &struct {
+			Enrollments []*storage.Enrollment `json:"enrollments"`
+			Error       string                `json:"error,omitempty"`
+		}{}
+		var err error
+		output.Enrollments, err = lister.ListEnrollments(r.Context())
+		if err != nil {
+			logger.Info("msg", "list enrollments", "err", err)
+			output.Error = err.Error()
+		} else {
+			if device := r.URL.Query().Get("device"); device != "" {
+				output.Enrollments = deviceEnrollments(output.Enrollments, device)
+			}
+			if awaiting := r.URL.Query().Get("awaiting_configuration"); awaiting != "" {
+				output.Enrollments = awaitingEnrollments(output.Enrollments, awaiting != "0")
+			}
+			logger.Debug("msg", "list enrollments", "count", len(output.Enrollments))
+		}
+		if output.Enrollments == nil {
+			output.Enrollments = []*storage.Enrollment{}
+		}
+		json, err := json.MarshalIndent(output, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
+
+// deviceEnrollments filters enrollments to the device channel
+// enrollment id and its user channel enrollments.
+func deviceEnrollments(enrollments []*storage.Enrollment, id string) []*storage.Enrollment {
+	var filtered []*storage.Enrollment
+	for _, e := range enrollments {
+		if e.ID == id || e.ParentID == id {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
+
+// awaitingEnrollments filters enrollments to those whose last
+// TokenUpdate AwaitingConfiguration is awaiting.
+func awaitingEnrollments(enrollments []*storage.Enrollment, awaiting bool) []*storage.Enrollment {
+	var filtered []*storage.Enrollment
+	for _, e := range enrollments {
+		if e.AwaitingConfiguration == awaiting {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
+
+// CommandDeliveriesHandlerFunc returns a JSON list of the delivery audit
+// trail of the commands queued for an enrollment.
+//
+// Note the whole URL path is used as the enrollment identifier. This
+// probably necessitates stripping the URL prefix before using.
+func CommandDeliveriesHandlerFunc(store storage.CommandDeliveryStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "" {
+			http.Error(w, "missing enrollment id", http.StatusBadRequest)
+			return
+		}
+		output := &struct {
+			Commands []*storage.CommandDelivery `json:"commands"`
+			Error    string                     `json:"error,omitempty"`
+		}{}
+		var err error
+		output.Commands, err = store.RetrieveCommandDeliveries(r.Context(), r.URL.Path)
+		if err != nil {
+			logger.Info("msg", "retrieve command deliveries", "id", r.URL.Path, "err", err)
+			output.Error = err.Error()
+		} else {
+			logger.Debug("msg", "retrieve command deliveries", "id", r.URL.Path, "count", len(output.Commands))
+		}
+		if output.Commands == nil {
+			output.Commands = []*storage.CommandDelivery{}
+		}
+		json, err := json.MarshalIndent(output, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
+
+// InventoryHandlerFunc returns a JSON list of device inventory.
+//
+// Note the whole URL path is used as the (comma-separated) enrollment
+// identifiers. An empty path returns the inventory of all enrollments.
+// This probably necessitates stripping the URL prefix before using.
+func InventoryHandlerFunc(store storage.InventoryStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ids []string
+		if r.URL.Path != "" {
+			ids = strings.Split(r.URL.Path, ",")
+		}
+		output := &struct {
+			Inventory []*storage.DeviceInventory `json:"inventory"`
+			Error     string                     `json:"error,omitempty"`
+		}{}
+		var err error
+		output.Inventory, err = store.RetrieveInventory(r.Context(), ids)
+		if err != nil {
+			logger.Info("msg", "retrieve inventory", "err", err)
+			output.Error = err.Error()
+		} else {
+			logger.Debug("msg", "retrieve inventory", "count", len(output.Inventory))
+		}
+		if output.Inventory == nil {
+			output.Inventory = []*storage.DeviceInventory{}
+		}
+		json, err := json.MarshalIndent(output, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
+
+// AppInventoryHandlerFunc returns a JSON list of the applications
+// installed on enrollments.
+//
+// Note the whole URL path is used as the (comma-separated) enrollment
+// identifiers. An empty path returns the app inventory of all enrollments.
+// This probably necessitates stripping the URL prefix before using.
+func AppInventoryHandlerFunc(store storage.AppInventoryStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ids []string
+		if r.URL.Path != "" {
+			ids = strings.Split(r.URL.Path, ",")
+		}
+		output := &struct {
+			Apps  []*storage.InstalledApp `json:"apps"`
+			Error string                  `json:"error,omitempty"`
+		}{}
+		var err error
+		output.Apps, err = store.RetrieveAppInventory(r.Context(), ids)
+		if err != nil {
+			logger.Info("msg", "retrieve app inventory", "err", err)
+			output.Error = err.Error()
+		} else {
+			logger.Debug("msg", "retrieve app inventory", "count", len(output.Apps))
+		}
+		if output.Apps == nil {
+			output.Apps = []*storage.InstalledApp{}
 		}
 		json, err := json.MarshalIndent(output, "", "\t")
 		if err != nil {
@@ -0,0 +1,169 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/storage"
+	"github.com/jessepeterson/nanomdm/storage/memqueue"
+)
+
+// enrollmentList is a static EnrollmentLister.
+type enrollmentList []*storage.Enrollment
+
+func (l enrollmentList) ListEnrollments(context.Context) ([]*storage.Enrollment, error) {
+	return l, nil
+}
+
+// listEnrollments returns the IDs of the enrollments listed by handler
+// for the query.
+func listEnrollments(t *testing.T, handler http.HandlerFunc, query string) []string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/?"+query, nil))
+	var output struct {
+		Enrollments []*storage.Enrollment `json:"enrollments"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&output); err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, e := range output.Enrollments {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func TestListEnrollmentsAwaitingConfiguration(t *testing.T) {
+	handler := ListEnrollmentsHandlerFunc(enrollmentList{
+		{ID: "A", Type: "Device", AwaitingConfiguration: true},
+		{ID: "B", Type: "Device"},
+	}, log.NopLogger)
+	for _, test := range []struct {
+		query string
+		want  string
+	}{
+		{"", "A,B"},
+		{"awaiting_configuration=1", "A"},
+		{"awaiting_configuration=0", "B"},
+	} {
+		if have := strings.Join(listEnrollments(t, handler, test.query), ","); have != test.want {
+			t.Errorf("%q: have %q, want %q", test.query, have, test.want)
+		}
+	}
+}
+
+// userChannels is a static UserChannelLister of device IDs to their
+// user channel enrollment IDs.
+type userChannels map[string][]string
+
+func (u userChannels) ListUserChannelIDs(_ context.Context, deviceIDs []string) (map[string][]string, error) {
+	ids := make(map[string][]string)
+	for _, id := range deviceIDs {
+		if len(u[id]) > 0 {
+			ids[id] = u[id]
+		}
+	}
+	return ids, nil
+}
+
+func TestChannelIDs(t *testing.T) {
+	lister := userChannels{"A": {"A:1", "A:2"}}
+	for _, test := range []struct {
+		ids     string
+		channel string
+		want    string
+		err     bool
+	}{
+		{"A:1,B", "", "A:1,B", false},
+		{"A:1,A,B", "device", "A,B", false},
+		{"A,B", "user", "A:1,A:2", false},
+		{"A:2,B", "all", "A,A:1,A:2,B", false},
+		{"B", "user", "", true}, // no user channels
+		{"A", "other", "", true},
+	} {
+		ids, err := channelIDs(context.Background(), lister, strings.Split(test.ids, ","), test.channel)
+		if have := err != nil; have != test.err {
+			t.Errorf("%s %q: have error %v, want error %v", test.ids, test.channel, err, test.err)
+		} else if have := strings.Join(ids, ","); have != test.want {
+			t.Errorf("%s %q: have %q, want %q", test.ids, test.channel, have, test.want)
+		}
+	}
+	if _, err := channelIDs(context.Background(), nil, []string{"A"}, "user"); err == nil {
+		t.Error("expected error without user channel lister")
+	}
+}
+
+// channelQueue is a memory queue that lists user channels.
+type channelQueue struct {
+	*memqueue.MemQueue
+	userChannels
+}
+
+func TestRawCommandEnqueueChannel(t *testing.T) {
+	pusher := new(recordingPusher)
+	handler := RawCommandEnqueueHandler(&channelQueue{memqueue.New(), userChannels{"A": {"A:1"}}}, pusher, log.NopLogger)
+	for _, test := range []struct {
+		query  string
+		status int
+		pushed string
+	}{
+		{"channel=user", http.StatusOK, "A:1"},
+		{"channel=all", http.StatusOK, "A,A:1"},
+		{"channel=other", http.StatusBadRequest, ""},
+	} {
+		*pusher = nil
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPut, "/?"+test.query, strings.NewReader(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>Command</key>
+	<dict>
+		<key>RequestType</key>
+		<string>DeviceInformation</string>
+	</dict>
+	<key>CommandUUID</key>
+	<string>`+test.query+`</string>
+</dict>
+</plist>`))
+		r.URL.Path = "A"
+		handler(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s: have %d, want %d", test.query, w.Code, test.status)
+		}
+		if have := strings.Join(*pusher, ","); have != test.pushed {
+			t.Errorf("%s: pushed %q, want %q", test.query, have, test.pushed)
+		}
+	}
+}
+
+func TestListDeviceEnrollments(t *testing.T) {
+	enrollments := enrollmentList{
+		{ID: "A", Type: "Device"},
+		{ID: "A:1", Type: "User", ParentID: "A"},
+		{ID: "B", Type: "Device"},
+		{ID: "B:1", Type: "User", ParentID: "B"},
+	}
+	storage.LinkUserChannels(enrollments)
+	if have := enrollments[0].UserChannels; len(have) != 1 || have[0] != "A:1" {
+		t.Errorf("user channels of A: have %v, want [A:1]", have)
+	}
+	handler := ListEnrollmentsHandlerFunc(enrollments, log.NopLogger)
+	for _, test := range []struct {
+		query string
+		want  string
+	}{
+		{"device=A", "A,A:1"},
+		{"device=B:1", "B:1"},
+		{"device=C", ""},
+	} {
+		if have := strings.Join(listEnrollments(t, handler, test.query), ","); have != test.want {
+			t.Errorf("%q: have %q, want %q", test.query, have, test.want)
+		}
+	}
+}
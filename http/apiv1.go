@@ -0,0 +1,493 @@
+package http
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/bus"
+	"github.com/jessepeterson/nanomdm/cmdplist"
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/push"
+	"github.com/jessepeterson/nanomdm/service/callback"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// Machine-readable error codes of the v1 API.
+const (
+	ErrCodeInvalidRequest   = "invalid_request"
+	ErrCodeNotFound         = "not_found"
+	ErrCodeMethodNotAllowed = "method_not_allowed"
+	ErrCodeUnsupported      = "unsupported"
+	ErrCodeConflict         = "conflict"
+	ErrCodeDisabled         = "enrollment_disabled"
+	ErrCodeInternal         = "internal_error"
+)
+
+const (
+	// DefaultPageLimit is the default number of items per v1 API page.
+	DefaultPageLimit = 100
+	// MaxPageLimit is the maximum number of items per v1 API page.
+	MaxPageLimit = 1000
+)
+
+// APIv1Store is the storage used by the v1 API.
+type APIv1Store interface {
+	storage.EnrollmentLister
+	storage.UserChannelLister
+	storage.CommandEnqueuer
+	storage.CommandDeliveryStore
+	storage.CommandCallbackStore
+}
+
+// apiv1Error is the error of a v1 API response.
+type apiv1Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// apiv1Page is the pagination of a v1 API list response. The next page
+// is requested with NextCursor as the "cursor" query parameter.
+type apiv1Page struct {
+	Total      int    `json:"total"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// apiv1Envelope is the JSON body of every v1 API response.
+type apiv1Envelope struct {
+	Data       interface{} `json:"data,omitempty"`
+	Pagination *apiv1Page  `json:"pagination,omitempty"`
+	Error      *apiv1Error `json:"error,omitempty"`
+}
+
+// apiv1Command is the JSON body of a v1 API command request. The
+// command is either built from the cmdplist catalog by RequestType
+// (with Args, Data, and additional Fields) or is the raw Plist.
+type apiv1Command struct {
+	EnrollmentIDs []string          `json:"enrollment_ids"`
+	Group         string            `json:"group,omitempty"`
+	SmartGroup    string            `json:"smart_group,omitempty"`
+	Channel       string            `json:"channel,omitempty"`
+	RequestType   string            `json:"request_type,omitempty"`
+	Args          map[string]string `json:"args,omitempty"`
+	Data          map[string][]byte `json:"data,omitempty"`
+	Fields        json.RawMessage   `json:"fields,omitempty"`
+	Plist         []byte            `json:"plist,omitempty"`
+	NoPush        bool              `json:"no_push,omitempty"`
+	CallbackURL   string            `json:"callback_url,omitempty"`
+	Wait          string            `json:"wait,omitempty"`
+}
+
+// apiv1CommandResult is the response data of a v1 API command request.
+type apiv1CommandResult struct {
+	CommandUUID string                      `json:"command_uuid"`
+	RequestType string                      `json:"request_type"`
+	Enrollments enrolledAPIResults          `json:"enrollments"`
+	Results     map[string]*callback.Result `json:"results,omitempty"`
+	WaitTimeout bool                        `json:"wait_timeout,omitempty"`
+}
+
+type apiv1 struct {
+	store  APIv1Store
+	pusher push.Pusher
+	bus    bus.Bus
+	logger log.Logger
+}
+
+// APIv1Handler serves the versioned JSON REST API (described by the
+// OpenAPI document at "openapi.json"). Responses are JSON envelopes
+// with the response "data", the "pagination" of lists, or an "error"
+// with a machine-readable code.
+//
+// Note the whole URL path is used as the API route. This probably
+// necessitates stripping the URL prefix before using.
+func APIv1Handler(store APIv1Store, pusher push.Pusher, b bus.Bus, logger log.Logger) http.Handler {
+	return &apiv1{store: store, pusher: pusher, bus: b, logger: logger}
+}
+
+func (a *apiv1) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	method := func(m string) bool {
+		if r.Method != m {
+			a.writeError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "method not allowed: "+r.Method)
+			return false
+		}
+		return true
+	}
+	switch {
+	case len(parts) == 1 && parts[0] == "openapi.json":
+		if method(http.MethodGet) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(OpenAPIv1))
+		}
+	case len(parts) == 1 && parts[0] == "enrollments":
+		if method(http.MethodGet) {
+			a.listEnrollments(w, r)
+		}
+	case len(parts) == 3 && parts[0] == "enrollments" && parts[2] == "commands":
+		if method(http.MethodGet) {
+			a.listCommands(w, r, parts[1])
+		}
+	case len(parts) == 1 && parts[0] == "commands":
+		if method(http.MethodPost) {
+			a.enqueue(w, r)
+		}
+	case len(parts) == 3 && parts[0] == "commands" && parts[2] == "result":
+		if method(http.MethodGet) {
+			a.result(w, r, parts[1])
+		}
+	case len(parts) == 1 && parts[0] == "push":
+		if method(http.MethodPost) {
+			a.push(w, r)
+		}
+	default:
+		a.writeError(w, http.StatusNotFound, ErrCodeNotFound, "not found: "+r.URL.Path)
+	}
+}
+
+func (a *apiv1) write(w http.ResponseWriter, status int, env *apiv1Envelope) {
+	b, err := json.MarshalIndent(env, "", "\t")
+	if err != nil {
+		a.logger.Info("msg", "marshal json", "err", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err = w.Write(b); err != nil {
+		a.logger.Info("msg", "writing body", "err", err)
+	}
+}
+
+func (a *apiv1) writeError(w http.ResponseWriter, status int, code, message string) {
+	a.write(w, status, &apiv1Envelope{Error: &apiv1Error{Code: code, Message: message}})
+}
+
+// storageError returns the status code and error of storage error err.
+func storageError(err error) (int, *apiv1Error) {
+	status := storageErrorStatus(err)
+	code := ErrCodeInternal
+	switch status {
+	case http.StatusNotFound:
+		code = ErrCodeNotFound
+	case http.StatusConflict:
+		code = ErrCodeConflict
+	case http.StatusGone:
+		code = ErrCodeDisabled
+	default:
+		status = http.StatusInternalServerError
+	}
+	return status, &apiv1Error{Code: code, Message: err.Error()}
+}
+
+// writeStorageError writes the error response of storage error err.
+func (a *apiv1) writeStorageError(w http.ResponseWriter, err error) {
+	status, apiErr := storageError(err)
+	a.write(w, status, &apiv1Envelope{Error: apiErr})
+}
+
+// page returns the bounds of the page of n items requested by the
+// "limit" and "cursor" query parameters of r.
+func page(r *http.Request, n int) (start, end int, p *apiv1Page, err error) {
+	limit := DefaultPageLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > MaxPageLimit {
+			return 0, 0, nil, fmt.Errorf("limit must be between 1 and %d", MaxPageLimit)
+		}
+	}
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		b, err := base64.RawURLEncoding.DecodeString(v)
+		if err == nil {
+			start, err = strconv.Atoi(string(b))
+		}
+		if err != nil || start < 0 {
+			return 0, 0, nil, errors.New("invalid cursor")
+		}
+	}
+	if start > n {
+		start = n
+	}
+	end = start + limit
+	p = &apiv1Page{Total: n}
+	if end < n {
+		p.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(end)))
+	} else {
+		end = n
+	}
+	return start, end, p, nil
+}
+
+func (a *apiv1) listEnrollments(w http.ResponseWriter, r *http.Request) {
+	enrollments, err := a.store.ListEnrollments(r.Context())
+	if err != nil {
+		a.logger.Info("msg", "list enrollments", "err", err)
+		a.writeError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	if device := r.URL.Query().Get("device"); device != "" {
+		enrollments = deviceEnrollments(enrollments, device)
+	}
+	if awaiting := r.URL.Query().Get("awaiting_configuration"); awaiting != "" {
+		enrollments = awaitingEnrollments(enrollments, awaiting != "0")
+	}
+	start, end, p, err := page(r, len(enrollments))
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	a.write(w, http.StatusOK, &apiv1Envelope{Data: append([]*storage.Enrollment{}, enrollments[start:end]...), Pagination: p})
+}
+
+func (a *apiv1) listCommands(w http.ResponseWriter, r *http.Request, id string) {
+	deliveries, err := a.store.RetrieveCommandDeliveries(r.Context(), id)
+	if err != nil {
+		a.logger.Info("msg", "retrieve command deliveries", "id", id, "err", err)
+		a.writeStorageError(w, err)
+		return
+	}
+	start, end, p, err := page(r, len(deliveries))
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	a.write(w, http.StatusOK, &apiv1Envelope{Data: append([]*storage.CommandDelivery{}, deliveries[start:end]...), Pagination: p})
+}
+
+// parseWait parses a wait duration of at most MaxEnqueueWait.
+func parseWait(v string) (time.Duration, error) {
+	if v == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(v)
+	if err == nil && (wait <= 0 || wait > MaxEnqueueWait) {
+		err = fmt.Errorf("wait must be positive and at most %s", MaxEnqueueWait)
+	}
+	return wait, err
+}
+
+// command builds the MDM command of req.
+func (req *apiv1Command) command() (*mdm.Command, error) {
+	if len(req.Plist) > 0 {
+		if req.RequestType != "" {
+			return nil, errors.New("request_type and plist are exclusive")
+		}
+		return mdm.DecodeCommand(req.Plist)
+	}
+	spec := cmdplist.Lookup(req.RequestType)
+	if spec == nil {
+		return nil, fmt.Errorf("unknown request_type: %q", req.RequestType)
+	}
+	cmd, err := spec.BuildJSON(req.Args, req.Data, req.Fields)
+	if err != nil {
+		return nil, err
+	}
+	return cmd.MDMCommand()
+}
+
+func (a *apiv1) enqueue(w http.ResponseWriter, r *http.Request) {
+	req := new(apiv1Command)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		a.writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "decoding body: "+err.Error())
+		return
+	}
+	targets, ok := a.targetIDs(w, r, req.EnrollmentIDs, req.Group, req.SmartGroup)
+	if !ok {
+		return
+	}
+	cmd, err := req.command()
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	wait, err := parseWait(req.Wait)
+	if err == nil && req.CallbackURL != "" {
+		err = validCallbackURL(req.CallbackURL)
+	}
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	if wait > 0 && a.bus == nil {
+		a.writeError(w, http.StatusBadRequest, ErrCodeUnsupported, "waiting for results not supported")
+		return
+	}
+	ids, err := channelIDs(r.Context(), a.store, targets, req.Channel)
+	if errors.Is(err, errInvalidChannel) {
+		a.writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	} else if err != nil {
+		a.logger.Info("msg", "resolving channel", "err", err)
+		a.writeError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	var results <-chan []byte
+	if wait > 0 {
+		// subscribe before enqueuing so no result is missed
+		var cancel func()
+		results, cancel = a.bus.Subscribe(bus.CommandResultTopic(cmd.CommandUUID), len(ids))
+		defer cancel()
+	}
+	output := &apiv1CommandResult{
+		CommandUUID: cmd.CommandUUID,
+		RequestType: cmd.Command.RequestType,
+		Enrollments: make(enrolledAPIResults),
+	}
+	idErrs, err := a.store.EnqueueCommand(r.Context(), ids, cmd)
+	if err != nil {
+		a.logger.Info("msg", "enqueue command", "err", err)
+		a.writeStorageError(w, err)
+		return
+	}
+	waiting := make(map[string]bool)
+	var enqueued []string
+	for _, id := range ids {
+		output.Enrollments[id] = &enrolledAPIResult{}
+		if idErrs[id] != nil {
+			output.Enrollments[id].CommandError = idErrs[id].Error()
+			continue
+		}
+		waiting[id] = true
+		enqueued = append(enqueued, id)
+	}
+	if req.CallbackURL != "" && len(enqueued) > 0 {
+		if err = a.store.StoreCommandCallback(r.Context(), enqueued, cmd.CommandUUID, req.CallbackURL); err != nil {
+			a.logger.Info("msg", "store command callback", "err", err)
+			a.writeStorageError(w, err)
+			return
+		}
+	}
+	emitEnqueued(r, enqueued, cmd.Command.RequestType, cmd.CommandUUID)
+	if !req.NoPush && len(enqueued) > 0 {
+		a.pushTo(r, enqueued, output.Enrollments)
+	}
+	a.logger.Debug("msg", "enqueue", "command_uuid", cmd.CommandUUID, "request_type", cmd.Command.RequestType, "id_count", len(ids))
+	if len(enqueued) < 1 {
+		// enqueued to no enrollment: respond with the storage error
+		// if they all failed the same way (e.g. all disabled)
+		if enqueueErrorStatus(ids, idErrs) != 0 {
+			status, apiErr := storageError(idErrs[ids[0]])
+			a.write(w, status, &apiv1Envelope{Data: output, Error: apiErr})
+			return
+		}
+	}
+	if results != nil {
+		output.Results, output.WaitTimeout = waitResults(r.Context(), results, waiting, wait)
+	}
+	a.write(w, http.StatusOK, &apiv1Envelope{Data: output})
+}
+
+// targetIDs resolves the members of group or smartGroup in place of
+// ids (if either is not empty). It writes the error response and
+// returns false on error.
+func (a *apiv1) targetIDs(w http.ResponseWriter, r *http.Request, ids []string, group, smartGroup string) ([]string, bool) {
+	if group == "" && smartGroup == "" {
+		if len(ids) < 1 {
+			a.writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "no enrollment_ids, group, or smart_group")
+			return nil, false
+		}
+		return ids, true
+	}
+	if len(ids) > 0 || (group != "" && smartGroup != "") {
+		a.writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "more than one of enrollment_ids, group, and smart_group given")
+		return nil, false
+	}
+	var err error
+	if smartGroup != "" {
+		smartGroups, ok := a.store.(SmartGroupStore)
+		if !ok {
+			a.writeError(w, http.StatusBadRequest, ErrCodeUnsupported, errSmartGroupsUnsupported.Error())
+			return nil, false
+		}
+		ids, err = smartGroupTargets(r.Context(), smartGroups, smartGroup)
+	} else {
+		groups, ok := a.store.(storage.GroupStore)
+		if !ok {
+			a.writeError(w, http.StatusBadRequest, ErrCodeUnsupported, errGroupsUnsupported.Error())
+			return nil, false
+		}
+		ids, err = groups.RetrieveGroupMembers(r.Context(), group)
+	}
+	if err != nil {
+		a.logger.Info("msg", "retrieving group members", "group", group, "smart_group", smartGroup, "err", err)
+		a.writeStorageError(w, err)
+		return nil, false
+	}
+	return ids, true
+}
+
+// pushTo pushes to ids recording the results in status.
+func (a *apiv1) pushTo(r *http.Request, ids []string, status enrolledAPIResults) {
+	pushResp, err := a.pusher.Push(r.Context(), ids)
+	for _, id := range ids {
+		if status[id] == nil {
+			status[id] = &enrolledAPIResult{}
+		}
+		if err != nil {
+			status[id].PushError = err.Error()
+		} else if resp, ok := pushResp[id]; ok {
+			status[id].PushResult = resp.Id
+			if resp.Err != nil {
+				status[id].PushError = resp.Err.Error()
+			}
+		}
+	}
+	if err != nil {
+		a.logger.Info("msg", "push", "err", err)
+	}
+}
+
+func (a *apiv1) push(w http.ResponseWriter, r *http.Request) {
+	req := new(struct {
+		EnrollmentIDs []string `json:"enrollment_ids"`
+		Group         string   `json:"group,omitempty"`
+		SmartGroup    string   `json:"smart_group,omitempty"`
+	})
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		a.writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "decoding body: "+err.Error())
+		return
+	}
+	ids, ok := a.targetIDs(w, r, req.EnrollmentIDs, req.Group, req.SmartGroup)
+	if !ok {
+		return
+	}
+	status := make(enrolledAPIResults)
+	a.pushTo(r, ids, status)
+	a.write(w, http.StatusOK, &apiv1Envelope{Data: &struct {
+		Enrollments enrolledAPIResults `json:"enrollments"`
+	}{status}})
+}
+
+func (a *apiv1) result(w http.ResponseWriter, r *http.Request, uuid string) {
+	wait, err := parseWait(r.URL.Query().Get("wait"))
+	if err != nil {
+		a.writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" && wait == 0 {
+		a.writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "id or wait required")
+		return
+	} else if a.bus == nil {
+		a.writeError(w, http.StatusBadRequest, ErrCodeUnsupported, "command results not supported")
+		return
+	}
+	result, timedOut, err := commandResult(r.Context(), a.bus, a.store, uuid, id, wait)
+	if err != nil {
+		a.logger.Info("msg", "command result", "command_uuid", uuid, "err", err)
+		a.writeError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	if result == nil {
+		msg := "no result"
+		if timedOut {
+			msg = "no result within " + wait.String()
+		}
+		a.writeError(w, http.StatusNotFound, ErrCodeNotFound, msg)
+		return
+	}
+	a.write(w, http.StatusOK, &apiv1Envelope{Data: result})
+}
@@ -0,0 +1,63 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+func TestPage(t *testing.T) {
+	var cursor string
+	var seen int
+	for i := 0; ; i++ {
+		r := httptest.NewRequest("GET", "/enrollments?limit=2&cursor="+cursor, nil)
+		start, end, p, err := page(r, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Total != 5 || start != seen {
+			t.Fatalf("page %d: unexpected start %d or total %d", i, start, p.Total)
+		}
+		seen = end
+		if cursor = p.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if seen != 5 {
+		t.Errorf("have %d items, want 5", seen)
+	}
+	for _, query := range []string{"limit=0", "limit=5000", "cursor=!"} {
+		if _, _, _, err := page(httptest.NewRequest("GET", "/?"+query, nil), 5); err == nil {
+			t.Errorf("%s: expected error", query)
+		}
+	}
+}
+
+func TestOpenAPIv1(t *testing.T) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(OpenAPIv1), &doc); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEnqueueErrorStatus(t *testing.T) {
+	disabled := fmt.Errorf("%w: a", storage.ErrDisabledEnrollment)
+	for _, test := range []struct {
+		idErrs map[string]error
+		want   int
+	}{
+		{map[string]error{"a": disabled, "b": disabled}, http.StatusGone},
+		{map[string]error{"a": disabled, "b": storage.ErrNotFound}, 0},
+		{map[string]error{"a": disabled}, 0},
+		{map[string]error{"a": errors.New("other"), "b": errors.New("other")}, 0},
+	} {
+		if have := enqueueErrorStatus([]string{"a", "b"}, test.idErrs); have != test.want {
+			t.Errorf("%v: have %d, want %d", test.idErrs, have, test.want)
+		}
+	}
+}
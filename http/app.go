@@ -0,0 +1,248 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/groob/plist"
+	"github.com/jessepeterson/nanomdm/cmdplist"
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/push"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// appInstallRequest is the JSON body of an application install request.
+type appInstallRequest struct {
+	cmdplist.Application
+	// Manifest is the name of a hosted manifest to use as the
+	// application's manifest URL.
+	Manifest string `json:"manifest,omitempty"`
+}
+
+// AppInstallHandlerFunc installs applications and reports on their
+// installs. A GET returns a JSON list of the application installs of
+// enrollments. A POST enqueues an InstallApplication (or
+// InstallEnterpriseApplication) command for the application in the JSON
+// body, sends push notifications, and tracks the installs. A hosted
+// manifest name in the body is resolved to a URL under manifestURL.
+//
+// Note the whole URL path is used as the (comma-separated) enrollment
+// identifiers. This probably necessitates stripping the URL prefix
+// before using.
+func AppInstallHandlerFunc(store storage.AppInstallStore, enqueuer storage.CommandEnqueuer, pusher push.Pusher, manifestURL string, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ids []string
+		if r.URL.Path != "" {
+			ids = strings.Split(r.URL.Path, ",")
+		}
+		output := &struct {
+			Installs    []*storage.AppInstall `json:"installs"`
+			CommandUUID string                `json:"command_uuid,omitempty"`
+			RequestType string                `json:"request_type,omitempty"`
+			Status      enrolledAPIResults    `json:"status,omitempty"`
+			Error       string                `json:"error,omitempty"`
+		}{}
+		var err error
+		switch r.Method {
+		case http.MethodGet:
+			output.Installs, err = store.RetrieveAppInstalls(r.Context(), ids)
+			if err != nil {
+				logger.Info("msg", "retrieve app installs", "err", err)
+				output.Error = err.Error()
+			}
+		case http.MethodPost:
+			req := new(appInstallRequest)
+			dec := json.NewDecoder(r.Body)
+			dec.UseNumber()
+			if err = dec.Decode(req); err != nil {
+				logger.Info("msg", "decoding body", "err", err)
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			if req.Manifest != "" {
+				req.ManifestURL = manifestURL + req.Manifest
+			}
+			var cmd *cmdplist.Command
+			if len(ids) < 1 {
+				err = errors.New("no enrollment IDs")
+			} else if req.Manifest != "" && manifestURL == "" {
+				err = errors.New("manifest hosting URL not configured")
+			} else {
+				cmd, err = cmdplist.NewInstallApplication(req.Application)
+			}
+			if err == nil {
+				output.CommandUUID, output.RequestType = cmd.CommandUUID, cmd.RequestType()
+				output.Status = make(enrolledAPIResults)
+				output.Installs, err = installApplication(r, store, enqueuer, pusher, ids, cmd, &req.Application, output.Status)
+			}
+			if err != nil {
+				logger.Info("msg", "install application", "err", err)
+				output.Error = err.Error()
+			} else {
+				logger.Debug(
+					"msg", "install application",
+					"command_uuid", output.CommandUUID,
+					"request_type", output.RequestType,
+					"id_count", len(ids),
+				)
+			}
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if output.Installs == nil {
+			output.Installs = []*storage.AppInstall{}
+		}
+		json, err := json.MarshalIndent(output, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
+
+// installApplication enqueues cmd to ids, tracks the installs of app,
+// and pushes to the enrollments.
+func installApplication(r *http.Request, store storage.AppInstallStore, enqueuer storage.CommandEnqueuer, pusher push.Pusher, ids []string, cmd *cmdplist.Command, app *cmdplist.Application, status enrolledAPIResults) ([]*storage.AppInstall, error) {
+	mdmCmd, err := cmd.MDMCommand()
+	if err != nil {
+		return nil, err
+	}
+	idErrs, err := enqueuer.EnqueueCommand(r.Context(), ids, mdmCmd)
+	if err != nil {
+		return nil, err
+	}
+	var enqueued []string
+	var insts []*storage.AppInstall
+	for _, id := range ids {
+		if idErrs[id] != nil {
+			status[id] = &enrolledAPIResult{CommandError: idErrs[id].Error()}
+			continue
+		}
+		enqueued = append(enqueued, id)
+		now := time.Now()
+		inst := &storage.AppInstall{
+			ID:            id,
+			CommandUUID:   cmd.CommandUUID,
+			RequestType:   cmd.RequestType(),
+			Identifier:    app.Identifier,
+			ITunesStoreID: app.ITunesStoreID,
+			ManifestURL:   app.ManifestURL,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		if err = store.StoreAppInstall(r.Context(), inst); err != nil {
+			return insts, err
+		}
+		insts = append(insts, inst)
+	}
+	if len(enqueued) < 1 {
+		return insts, nil
+	}
+	pushResp, err := pusher.Push(r.Context(), enqueued)
+	if err != nil {
+		return insts, err
+	}
+	for id, resp := range pushResp {
+		res := &enrolledAPIResult{PushResult: resp.Id}
+		if resp.Err != nil {
+			res.PushError = resp.Err.Error()
+		}
+		status[id] = res
+	}
+	return insts, nil
+}
+
+// StoreAppManifestHandlerFunc stores the application manifest plist in
+// the HTTP body (on PUT) or returns it (on GET).
+//
+// Note the whole URL path is used as the manifest name. This probably
+// necessitates stripping the URL prefix before using.
+func StoreAppManifestHandlerFunc(store storage.AppManifestStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			AppManifestHandlerFunc(store, logger)(w, r)
+			return
+		} else if r.Method != http.MethodPut {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		b, err := ReadAllAndReplaceBody(r)
+		if err != nil {
+			logger.Info("msg", "reading body", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		output := &struct {
+			Name  string `json:"name"`
+			Error string `json:"error,omitempty"`
+		}{Name: r.URL.Path}
+		manifest := &struct {
+			Items []interface{} `plist:"items"`
+		}{}
+		if r.URL.Path == "" || strings.Contains(r.URL.Path, "/") {
+			err = errors.New("invalid manifest name")
+		} else if err = plist.Unmarshal(b, manifest); err == nil && len(manifest.Items) < 1 {
+			err = errors.New("manifest has no items")
+		}
+		if err == nil {
+			err = store.StoreAppManifest(r.Context(), r.URL.Path, b)
+		}
+		if err != nil {
+			logger.Info("msg", "store app manifest", "name", r.URL.Path, "err", err)
+			output.Error = err.Error()
+		} else {
+			logger.Info("msg", "stored app manifest", "name", r.URL.Path)
+		}
+		json, err := json.MarshalIndent(output, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
+
+// AppManifestHandlerFunc serves hosted application manifests to
+// devices. It does not require authentication as devices fetch
+// manifests without MDM credentials.
+//
+// Note the whole URL path is used as the manifest name. This probably
+// necessitates stripping the URL prefix before using.
+func AppManifestHandlerFunc(store storage.AppManifestStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		var manifest []byte
+		var err error
+		if r.URL.Path != "" && !strings.Contains(r.URL.Path, "/") {
+			manifest, err = store.RetrieveAppManifest(r.Context(), r.URL.Path)
+		}
+		if err != nil {
+			logger.Info("msg", "retrieve app manifest", "name", r.URL.Path, "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if manifest == nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-type", "application/xml")
+		_, err = w.Write(manifest)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package http
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/jessepeterson/nanomdm/log"
+)
+
+// BlobOpener opens stored blobs by key.
+type BlobOpener interface {
+	// Open returns an os.ErrNotExist error for missing blobs.
+	Open(key string) (io.ReadCloser, error)
+}
+
+// BlobHandlerFunc serves the blobs (e.g. offloaded command results) of
+// opener. The URL path is the blob key (the path prefix must be
+// stripped).
+func BlobHandlerFunc(opener BlobOpener, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		rc, err := opener.Open(r.URL.Path)
+		if errors.Is(err, os.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			logger.Info("msg", "opening blob", "key", r.URL.Path, "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		defer rc.Close()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if _, err = io.Copy(w, rc); err != nil {
+			logger.Info("msg", "writing blob", "key", r.URL.Path, "err", err)
+		}
+	}
+}
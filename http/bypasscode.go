@@ -0,0 +1,174 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/jessepeterson/nanomdm/cmdplist"
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/push"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// BypassCodeEscrow retrieves escrowed Activation Lock bypass codes.
+type BypassCodeEscrow interface {
+	// Retrieve returns an empty code if none is escrowed for id.
+	Retrieve(ctx context.Context, id string) (string, error)
+}
+
+// BypassCodeHandlerFunc retrieves escrowed Activation Lock bypass codes
+// and drives the escrow flow. A GET returns the bypass code of a single
+// enrollment. A POST enqueues (and pushes) an ActivationLockBypassCode
+// command to escrow the bypass codes of enrollments with the "escrow"
+// action in the JSON body or a ClearActivationLockBypassCode command
+// with the "clear" action. Codes are only cleared from enrollments with
+// an escrowed code. All retrievals are logged for auditing.
+//
+// Note the whole URL path is used as the (comma-separated) enrollment
+// identifiers. This probably necessitates stripping the URL prefix
+// before using.
+func BypassCodeHandlerFunc(escrow BypassCodeEscrow, enqueuer storage.CommandEnqueuer, pusher push.Pusher, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		addr, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			addr = r.RemoteAddr
+		}
+		switch r.Method {
+		case http.MethodGet:
+			output := &struct {
+				ID         string `json:"id"`
+				BypassCode string `json:"bypass_code,omitempty"`
+				Error      string `json:"error,omitempty"`
+			}{ID: r.URL.Path}
+			if r.URL.Path == "" || strings.Contains(r.URL.Path, ",") {
+				err = errors.New("single enrollment ID required")
+			} else {
+				output.BypassCode, err = escrow.Retrieve(r.Context(), r.URL.Path)
+			}
+			if err == nil && output.BypassCode == "" {
+				err = errors.New("no bypass code escrowed")
+			}
+			// audit every retrieval attempt
+			logs := []interface{}{"msg", "retrieve bypass code", "id", r.URL.Path, "addr", addr}
+			if err != nil {
+				logs = append(logs, "err", err)
+				output.Error = err.Error()
+			}
+			logger.Info(logs...)
+			writeJSON(w, output, logger)
+		case http.MethodPost:
+			req := &struct {
+				// Action is one of escrow or clear.
+				Action string `json:"action"`
+			}{}
+			if err = json.NewDecoder(r.Body).Decode(req); err != nil {
+				logger.Info("msg", "decoding body", "err", err)
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			var ids []string
+			if r.URL.Path != "" {
+				ids = strings.Split(r.URL.Path, ",")
+			}
+			output := apiResult{Status: make(enrolledAPIResults)}
+			var cmd *cmdplist.Command
+			switch {
+			case len(ids) < 1:
+				err = errors.New("no enrollment IDs")
+			case req.Action == "escrow":
+				cmd = cmdplist.New("ActivationLockBypassCode")
+			case req.Action == "clear":
+				cmd = cmdplist.New("ClearActivationLockBypassCode")
+				ids, err = escrowedIDs(r.Context(), escrow, ids, output.Status)
+			default:
+				err = fmt.Errorf("invalid action: %q", req.Action)
+			}
+			if err == nil && len(ids) > 0 {
+				output.CommandUUID, output.RequestType = cmd.CommandUUID, cmd.RequestType()
+				err = enqueueAndPush(r.Context(), enqueuer, pusher, ids, cmd, output.Status)
+			}
+			logs := []interface{}{"msg", "bypass code", "action", req.Action, "id_count", len(ids), "addr", addr}
+			if err != nil {
+				logs = append(logs, "err", err)
+				output.CommandError = err.Error()
+			} else if len(ids) > 0 {
+				logs = append(logs, "command_uuid", output.CommandUUID, "id_first", ids[0])
+			}
+			logger.Info(logs...)
+			writeJSON(w, output, logger)
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// escrowedIDs returns the ids that have an escrowed bypass code.
+func escrowedIDs(ctx context.Context, escrow BypassCodeEscrow, ids []string, status enrolledAPIResults) ([]string, error) {
+	var escrowed []string
+	for _, id := range ids {
+		code, err := escrow.Retrieve(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if code == "" {
+			status[id] = &enrolledAPIResult{CommandError: "no bypass code escrowed"}
+			continue
+		}
+		escrowed = append(escrowed, id)
+	}
+	return escrowed, nil
+}
+
+// enqueueAndPush enqueues cmd to ids and pushes to them, recording
+// per-enrollment errors in status.
+func enqueueAndPush(ctx context.Context, enqueuer storage.CommandEnqueuer, pusher push.Pusher, ids []string, cmd *cmdplist.Command, status enrolledAPIResults) error {
+	mdmCmd, err := cmd.MDMCommand()
+	if err != nil {
+		return err
+	}
+	idErrs, err := enqueuer.EnqueueCommand(ctx, ids, mdmCmd)
+	if err != nil {
+		return err
+	}
+	var enqueued []string
+	for _, id := range ids {
+		if idErrs[id] != nil {
+			status[id] = &enrolledAPIResult{CommandError: idErrs[id].Error()}
+			continue
+		}
+		enqueued = append(enqueued, id)
+	}
+	if len(enqueued) < 1 {
+		return nil
+	}
+	pushResp, err := pusher.Push(ctx, enqueued)
+	if err != nil {
+		return err
+	}
+	for id, resp := range pushResp {
+		res := &enrolledAPIResult{PushResult: resp.Id}
+		if resp.Err != nil {
+			res.PushError = resp.Err.Error()
+		}
+		status[id] = res
+	}
+	return nil
+}
+
+// writeJSON writes v as indented JSON to w.
+func writeJSON(w http.ResponseWriter, v interface{}, logger log.Logger) {
+	json, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		logger.Info("msg", "marshal json", "err", err)
+	}
+	w.Header().Set("Content-type", "application/json")
+	_, err = w.Write(json)
+	if err != nil {
+		logger.Info("msg", "writing body", "err", err)
+	}
+}
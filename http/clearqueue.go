@@ -0,0 +1,136 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/secevent"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// QueueClearStore clears the command queues of device enrollments.
+type QueueClearStore interface {
+	storage.EnrollmentLister
+	storage.UserChannelLister
+	storage.QueueStatsStore
+	ClearQueue(r *mdm.Request) error
+}
+
+// pendingCommands returns the number of pending commands of ids.
+func pendingCommands(r *http.Request, store storage.QueueStatsStore, ids []string) (int, error) {
+	stats, err := store.RetrieveQueueStats(r.Context(), ids)
+	var pending int
+	for _, st := range stats {
+		pending += st.Pending
+	}
+	return pending, err
+}
+
+// deviceEnrollType returns the enrollment type of the device channel
+// enrollment e or 0 if e is a user channel enrollment.
+func deviceEnrollType(e *storage.Enrollment) mdm.EnrollType {
+	if e.ParentID != "" {
+		return 0
+	}
+	for _, et := range []mdm.EnrollType{mdm.Device, mdm.UserEnrollmentDevice} {
+		if e.Type == et.String() {
+			return et
+		}
+	}
+	return 0
+}
+
+// ClearQueueHandlerFunc clears the command queue of a device channel
+// enrollment and its user channel enrollments so that operators can
+// unstick a device. The URL path is the enrollment ID followed by
+// "/queue" and only the DELETE method is allowed. User channel
+// enrollment IDs are rejected as bad requests. The number of pending
+// commands cleared (the difference of the pending commands before and
+// after clearing) is returned as JSON. Clearing is logged and reported
+// as a QueueCleared security event.
+//
+// Note the whole URL path is used as the identifier. This probably
+// necessitates stripping the URL prefix before using.
+func ClearQueueHandlerFunc(store QueueClearStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSuffix(r.URL.Path, "/queue")
+		if id == "" || id == r.URL.Path || strings.Contains(id, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodDelete {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		logger := logger.With("id", id)
+		enrollments, err := store.ListEnrollments(r.Context())
+		if err != nil {
+			logger.Info("msg", "list enrollments", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		var enrollment *storage.Enrollment
+		for _, e := range enrollments {
+			if e.ID == id {
+				enrollment = e
+				break
+			}
+		}
+		if enrollment == nil {
+			http.Error(w, storage.ErrNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		enrollType := deviceEnrollType(enrollment)
+		if enrollType == 0 {
+			http.Error(w, "not a device channel enrollment", http.StatusBadRequest)
+			return
+		}
+
+		ids := []string{id}
+		userChannels, err := store.ListUserChannelIDs(r.Context(), ids)
+		if err != nil {
+			logger.Info("msg", "listing user channels", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		ids = append(ids, userChannels[id]...)
+		before, err := pendingCommands(r, store, ids)
+		if err != nil {
+			logger.Info("msg", "retrieving queue stats", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		err = store.ClearQueue(&mdm.Request{
+			Context:  r.Context(),
+			EnrollID: &mdm.EnrollID{ID: id, Type: enrollType},
+		})
+		if err != nil {
+			logger.Info("msg", "clearing queue", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		// commands enqueued meanwhile make the count inexact (or
+		// negative, in which case it is omitted)
+		var cleared *int
+		if after, err := pendingCommands(r, store, ids); err != nil {
+			logger.Info("msg", "retrieving queue stats", "err", err)
+		} else if n := before - after; n >= 0 {
+			cleared = &n
+		}
+		logger.Info("msg", "cleared queue", "pending", before, "addr", clientAddr(r))
+		secevent.Emit(r.Context(), &secevent.Event{
+			Type:   secevent.QueueCleared,
+			Source: clientAddr(r),
+			Path:   requestPath(r),
+			IDs:    ids,
+		})
+		writeJSON(w, &struct {
+			ID      string   `json:"id"`
+			IDs     []string `json:"ids"`
+			Cleared *int     `json:"cleared,omitempty"`
+		}{id, ids, cleared}, logger)
+	}
+}
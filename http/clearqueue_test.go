@@ -0,0 +1,115 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/secevent"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// memQueues is an in-memory QueueClearStore of pending command counts.
+type memQueues struct {
+	pending      map[string]int
+	userChannels map[string][]string
+}
+
+func (q *memQueues) ListEnrollments(_ context.Context) ([]*storage.Enrollment, error) {
+	var enrollments []*storage.Enrollment
+	for id, userChannels := range q.userChannels {
+		enrollments = append(enrollments, &storage.Enrollment{ID: id, Type: mdm.EnrollType(mdm.Device).String()})
+		for _, userID := range userChannels {
+			enrollments = append(enrollments, &storage.Enrollment{ID: userID, Type: mdm.EnrollType(mdm.User).String(), ParentID: id})
+		}
+	}
+	return enrollments, nil
+}
+
+func (q *memQueues) ListUserChannelIDs(_ context.Context, ids []string) (map[string][]string, error) {
+	return map[string][]string{ids[0]: q.userChannels[ids[0]]}, nil
+}
+
+func (q *memQueues) RetrieveQueueStats(_ context.Context, ids []string) ([]*storage.QueueStats, error) {
+	var stats []*storage.QueueStats
+	for _, id := range ids {
+		stats = append(stats, &storage.QueueStats{ID: id, Pending: q.pending[id]})
+	}
+	return stats, nil
+}
+
+func (q *memQueues) ClearQueue(r *mdm.Request) error {
+	if r.Type != mdm.Device {
+		return errors.New("can only clear a device channel queue")
+	}
+	q.pending[r.ID] = 0
+	for _, id := range q.userChannels[r.ID] {
+		q.pending[id] = 0
+	}
+	return nil
+}
+
+// recordingEmitter records the security events emitted.
+type recordingEmitter []*secevent.Event
+
+func (e *recordingEmitter) Emit(_ context.Context, ev *secevent.Event) {
+	*e = append(*e, ev)
+}
+
+func TestClearQueue(t *testing.T) {
+	store := &memQueues{
+		pending:      map[string]int{"dev": 2, "user": 1},
+		userChannels: map[string][]string{"dev": {"user"}},
+	}
+	handler := ClearQueueHandlerFunc(store, log.NopLogger)
+	for _, test := range []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "dev/queue", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "dev", http.StatusNotFound},
+		{http.MethodDelete, "dev/queue/x", http.StatusNotFound},
+		{http.MethodDelete, "unknown/queue", http.StatusNotFound},
+		{http.MethodDelete, "user/queue", http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest(test.method, "/v1/enrollments/"+test.path, nil)
+		req.URL.Path = test.path // stripped path prefix
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("%s %s: have status %d, want %d", test.method, test.path, rec.Code, test.status)
+		}
+	}
+
+	var events recordingEmitter
+	req := httptest.NewRequest(http.MethodDelete, "/v1/enrollments/dev/queue", nil)
+	req = req.WithContext(secevent.NewContext(req.Context(), &events))
+	req.URL.Path = "dev/queue"
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("have status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var output struct {
+		IDs     []string `json:"ids"`
+		Cleared *int     `json:"cleared"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&output); err != nil {
+		t.Fatal(err)
+	}
+	if output.Cleared == nil || *output.Cleared != 3 || len(output.IDs) != 2 {
+		t.Errorf("unexpected output: %+v", output)
+	}
+	if store.pending["dev"] != 0 || store.pending["user"] != 0 {
+		t.Errorf("queues not cleared: %v", store.pending)
+	}
+	if len(events) != 1 || events[0].Type != secevent.QueueCleared || events[0].Path != "/v1/enrollments/dev/queue" {
+		t.Errorf("unexpected events: %+v", events)
+	}
+}
@@ -0,0 +1,119 @@
+package http
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jessepeterson/nanomdm/log"
+)
+
+// DecompressMiddleware transparently decodes gzip or deflate
+// Content-Encoded request bodies before calling next. Decoded bodies
+// are limited to maxSize bytes (if greater than zero) to guard
+// against decompression bombs.
+//
+// It should wrap any certificate extraction middleware so that the
+// Mdm-Signature is verified against the decoded body.
+func DecompressMiddleware(next http.Handler, maxSize int64, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+		var body io.ReadCloser
+		var err error
+		switch encoding {
+		case "", "identity":
+			next.ServeHTTP(w, r)
+			return
+		case "gzip", "x-gzip":
+			body, err = gzip.NewReader(r.Body)
+		case "deflate":
+			body, err = zlib.NewReader(r.Body)
+		default:
+			logger.Info("msg", "unsupported content encoding", "encoding", encoding)
+			http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+			return
+		}
+		if err != nil {
+			logger.Info("msg", "decoding body", "encoding", encoding, "err", err)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		if maxSize > 0 {
+			body = http.MaxBytesReader(w, body, maxSize)
+		}
+		r.Body = body
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+		r.ContentLength = -1
+		next.ServeHTTP(w, r)
+	}
+}
+
+// gzipResponseWriter gzips the response body written to it.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.Header().Del("Content-Length")
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.gz.Write(b)
+}
+
+// acceptsGzip reports whether the Accept-Encoding header allows gzip.
+func acceptsGzip(header string) bool {
+	for _, enc := range strings.Split(header, ",") {
+		parts := strings.Split(enc, ";")
+		if strings.TrimSpace(strings.ToLower(parts[0])) != "gzip" {
+			continue
+		}
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// CompressMiddleware gzip-compresses responses for clients that send
+// an Accept-Encoding header allowing gzip.
+func CompressMiddleware(next http.Handler, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gz := gzip.NewWriter(w)
+		gzw := &gzipResponseWriter{ResponseWriter: w, gz: gz}
+		next.ServeHTTP(gzw, r)
+		if !gzw.wroteHeader {
+			// nothing written: don't emit an empty gzip stream
+			return
+		}
+		if err := gz.Close(); err != nil && !errors.Is(err, http.ErrBodyNotAllowed) {
+			logger.Info("msg", "closing gzip writer", "err", err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/log"
+)
+
+func TestDecompressAndCompress(t *testing.T) {
+	body := []byte("<?xml version=\"1.0\"?><plist/>")
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(b)
+	})
+	handler := DecompressMiddleware(CompressMiddleware(echo, log.NopLogger), 1024, log.NopLogger)
+
+	buf := new(bytes.Buffer)
+	gz := gzip.NewWriter(buf)
+	gz.Write(body)
+	gz.Close()
+	r := httptest.NewRequest("POST", "/mdm", buf)
+	r.Header.Set("Content-Encoding", "gzip")
+	r.Header.Set("Accept-Encoding", "deflate, gzip;q=0.5")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if have, want := w.Header().Get("Content-Encoding"), "gzip"; have != want {
+		t.Fatalf("Content-Encoding: have %q, want %q", have, want)
+	}
+	gzr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(gzr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, body) {
+		t.Errorf("body: have %q, want %q", b, body)
+	}
+
+	// uncompressed
+	r = httptest.NewRequest("POST", "/mdm", bytes.NewReader(body))
+	r.Header.Set("Accept-Encoding", "gzip;q=0")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Header().Get("Content-Encoding") != "" || !bytes.Equal(w.Body.Bytes(), body) {
+		t.Errorf("unexpected compressed response")
+	}
+
+	// unsupported
+	r = httptest.NewRequest("POST", "/mdm", bytes.NewReader(body))
+	r.Header.Set("Content-Encoding", "br")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("status: have %d, want %d", w.Code, http.StatusUnsupportedMediaType)
+	}
+}
@@ -0,0 +1,111 @@
+// Package debug provides the HTTP handler of the NanoMDM runtime
+// diagnostics: pprof profiles, expvar variables, and a status summary.
+//
+// Like importing net/http/pprof and expvar (which it does) importing
+// this package registers their handlers on http.DefaultServeMux.
+// NanoMDM itself never serves http.DefaultServeMux.
+package debug
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/log"
+)
+
+// StatusFunc returns a section of the status summary. It is encoded as
+// JSON.
+type StatusFunc func() interface{}
+
+// Status is the status summary.
+type Status struct {
+	Uptime     string                 `json:"uptime"`
+	Goroutines int                    `json:"goroutines"`
+	Memory     Memory                 `json:"memory"`
+	Sections   map[string]interface{} `json:"sections,omitempty"`
+}
+
+// Memory is a summary of the memory statistics.
+type Memory struct {
+	HeapAlloc uint64 `json:"heap_alloc"`
+	HeapInuse uint64 `json:"heap_inuse"`
+	Sys       uint64 `json:"sys"`
+	NumGC     uint32 `json:"num_gc"`
+}
+
+var started = time.Now()
+
+// Handler serves the diagnostics at the (prefix-stripped) URL paths:
+//
+//	pprof/         the pprof index and profiles (e.g. pprof/heap)
+//	vars           the expvar variables
+//	status         the status summary
+//
+// The status summary includes the sections returned by the functions
+// of sections by name (e.g. storage connection pool statistics).
+func Handler(sections map[string]StatusFunc, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/")
+		switch {
+		case path == "status":
+			status(w, sections, logger)
+		case path == "vars":
+			expvar.Handler().ServeHTTP(w, r)
+		case path == "pprof":
+			// the index links to profiles relatively (and the
+			// stripped path can't be redirected to absolutely)
+			w.Header().Set("Location", "pprof/")
+			w.WriteHeader(http.StatusMovedPermanently)
+		case path == "pprof/":
+			pprof.Index(w, r)
+		case strings.HasPrefix(path, "pprof/"):
+			switch name := strings.TrimPrefix(path, "pprof/"); name {
+			case "cmdline":
+				pprof.Cmdline(w, r)
+			case "profile":
+				pprof.Profile(w, r)
+			case "symbol":
+				pprof.Symbol(w, r)
+			case "trace":
+				pprof.Trace(w, r)
+			default:
+				pprof.Handler(name).ServeHTTP(w, r)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}
+}
+
+// status writes the status summary.
+func status(w http.ResponseWriter, sections map[string]StatusFunc, logger log.Logger) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	s := &Status{
+		Uptime:     time.Since(started).Round(time.Second).String(),
+		Goroutines: runtime.NumGoroutine(),
+		Memory: Memory{
+			HeapAlloc: ms.HeapAlloc,
+			HeapInuse: ms.HeapInuse,
+			Sys:       ms.Sys,
+			NumGC:     ms.NumGC,
+		},
+	}
+	if len(sections) > 0 {
+		s.Sections = make(map[string]interface{})
+		for name, f := range sections {
+			s.Sections[name] = f()
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s); err != nil {
+		logger.Info("msg", "encoding debug status", "err", err)
+	}
+}
@@ -0,0 +1,48 @@
+package debug
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/log"
+)
+
+func TestHandler(t *testing.T) {
+	h := Handler(map[string]StatusFunc{
+		"pending": func() interface{} { return 3 },
+	}, log.NopLogger)
+
+	for _, test := range []struct {
+		path   string
+		status int
+	}{
+		{"status", http.StatusOK},
+		{"vars", http.StatusOK},
+		{"pprof", http.StatusMovedPermanently},
+		{"pprof/", http.StatusOK},
+		{"pprof/goroutine", http.StatusOK},
+		{"pprof/cmdline", http.StatusOK},
+		{"other", http.StatusNotFound},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+test.path, nil))
+		if rec.Code != test.status {
+			t.Errorf("%s: status: have %d, want %d", test.path, rec.Code, test.status)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var status Status
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Goroutines < 1 {
+		t.Errorf("goroutines: have %d", status.Goroutines)
+	}
+	if have := status.Sections["pending"]; have != float64(3) {
+		t.Errorf("pending section: have %v, want 3", have)
+	}
+}
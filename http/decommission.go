@@ -0,0 +1,68 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/jessepeterson/nanomdm/log"
+)
+
+// Decommissioner decommissions devices.
+type Decommissioner interface {
+	// Start returns command UUIDs and errors by enrollment ID.
+	Start(ctx context.Context, ids []string) (map[string]string, map[string]error, error)
+}
+
+// DecommissionHandlerFunc decommissions devices in one call. A POST
+// replaces the command queues of the devices with a command that
+// removes their MDM enrollment profile, pushes them, and disables
+// their enrollments once they acknowledge it.
+//
+// Note the whole URL path is used as the (comma-separated) enrollment
+// identifiers. This probably necessitates stripping the URL prefix
+// before using.
+func DecommissionHandlerFunc(decommissioner Decommissioner, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		var ids []string
+		if r.URL.Path != "" {
+			ids = strings.Split(r.URL.Path, ",")
+		}
+		addr, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			addr = r.RemoteAddr
+		}
+		output := &struct {
+			CommandUUIDs map[string]string `json:"command_uuids,omitempty"`
+			Errors       map[string]string `json:"errors,omitempty"`
+			Error        string            `json:"error,omitempty"`
+		}{}
+		var idErrs map[string]error
+		if len(ids) < 1 {
+			err = errors.New("no enrollment IDs")
+		} else {
+			output.CommandUUIDs, idErrs, err = decommissioner.Start(r.Context(), ids)
+		}
+		if len(idErrs) > 0 {
+			output.Errors = make(map[string]string)
+			for id, idErr := range idErrs {
+				output.Errors[id] = idErr.Error()
+			}
+		}
+		logs := []interface{}{"msg", "decommission", "id_count", len(ids), "addr", addr}
+		if err != nil {
+			logs = append(logs, "err", err)
+			output.Error = err.Error()
+		} else {
+			logs = append(logs, "sent", len(output.CommandUUIDs))
+		}
+		logger.Info(logs...)
+		writeJSON(w, output, logger)
+	}
+}
@@ -0,0 +1,151 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// DevicePasswordManager manages recovery lock and firmware passwords.
+type DevicePasswordManager interface {
+	List(ctx context.Context, ids []string, kind string) ([]*storage.DevicePassword, error)
+	Reveal(ctx context.Context, id, kind string) (string, error)
+	// Set and Verify return command UUIDs and errors by enrollment ID.
+	Set(ctx context.Context, ids []string, kind string, clear bool) (map[string]string, map[string]error, error)
+	Verify(ctx context.Context, ids []string, kind string) (map[string]string, map[string]error, error)
+}
+
+type devicePasswordJSON struct {
+	ID          string     `json:"id"`
+	Kind        string     `json:"kind"`
+	Status      string     `json:"status"`
+	CommandUUID string     `json:"command_uuid,omitempty"`
+	Verified    *bool      `json:"verified,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	RotatedAt   *time.Time `json:"rotated_at,omitempty"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// DevicePasswordHandlerFunc lists, reveals, and changes recovery lock
+// and firmware passwords. A GET returns the password status of
+// enrollments (optionally of the kind in the "kind" query parameter).
+// A GET of a single enrollment with the "reveal" query parameter and a
+// kind returns the password itself; these retrievals are logged for
+// auditing. A POST with a kind and the "set", "clear", or "verify"
+// action in the JSON body enqueues (and pushes) the matching command.
+//
+// Note the whole URL path is used as the (comma-separated) enrollment
+// identifiers. This probably necessitates stripping the URL prefix
+// before using.
+func DevicePasswordHandlerFunc(mgr DevicePasswordManager, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		addr, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			addr = r.RemoteAddr
+		}
+		var ids []string
+		if r.URL.Path != "" {
+			ids = strings.Split(r.URL.Path, ",")
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Query().Get("reveal") != "":
+			kind := r.URL.Query().Get("kind")
+			output := &struct {
+				ID       string `json:"id"`
+				Kind     string `json:"kind"`
+				Password string `json:"password,omitempty"`
+				Error    string `json:"error,omitempty"`
+			}{ID: r.URL.Path, Kind: kind}
+			if len(ids) != 1 {
+				err = errors.New("single enrollment ID required")
+			} else {
+				output.Password, err = mgr.Reveal(r.Context(), ids[0], kind)
+			}
+			// audit every retrieval attempt
+			logs := []interface{}{"msg", "reveal device password", "id", r.URL.Path, "kind", kind, "addr", addr}
+			if err != nil {
+				logs = append(logs, "err", err)
+				output.Error = err.Error()
+			}
+			logger.Info(logs...)
+			writeJSON(w, output, logger)
+		case r.Method == http.MethodGet:
+			output := &struct {
+				Passwords []*devicePasswordJSON `json:"passwords"`
+				Error     string                `json:"error,omitempty"`
+			}{Passwords: []*devicePasswordJSON{}}
+			pws, err := mgr.List(r.Context(), ids, r.URL.Query().Get("kind"))
+			if err != nil {
+				logger.Info("msg", "retrieving device passwords", "err", err)
+				output.Error = err.Error()
+			}
+			for _, pw := range pws {
+				output.Passwords = append(output.Passwords, &devicePasswordJSON{
+					ID:          pw.ID,
+					Kind:        pw.Kind,
+					Status:      pw.Status,
+					CommandUUID: pw.CommandUUID,
+					Verified:    pw.Verified,
+					Error:       pw.Error,
+					RotatedAt:   pw.RotatedAt,
+					UpdatedAt:   pw.UpdatedAt,
+				})
+			}
+			writeJSON(w, output, logger)
+		case r.Method == http.MethodPost:
+			req := &struct {
+				Kind string `json:"kind"`
+				// Action is one of set, clear, or verify.
+				Action string `json:"action"`
+			}{}
+			if err = json.NewDecoder(r.Body).Decode(req); err != nil {
+				logger.Info("msg", "decoding body", "err", err)
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			output := &struct {
+				CommandUUIDs map[string]string `json:"command_uuids,omitempty"`
+				Errors       map[string]string `json:"errors,omitempty"`
+				Error        string            `json:"error,omitempty"`
+			}{}
+			var uuids map[string]string
+			var idErrs map[string]error
+			switch {
+			case len(ids) < 1:
+				err = errors.New("no enrollment IDs")
+			case req.Action == "set" || req.Action == "clear":
+				uuids, idErrs, err = mgr.Set(r.Context(), ids, req.Kind, req.Action == "clear")
+			case req.Action == "verify":
+				uuids, idErrs, err = mgr.Verify(r.Context(), ids, req.Kind)
+			default:
+				err = fmt.Errorf("invalid action: %q", req.Action)
+			}
+			output.CommandUUIDs = uuids
+			if len(idErrs) > 0 {
+				output.Errors = make(map[string]string)
+				for id, idErr := range idErrs {
+					output.Errors[id] = idErr.Error()
+				}
+			}
+			logs := []interface{}{"msg", "device password", "action", req.Action, "kind", req.Kind, "id_count", len(ids), "addr", addr}
+			if err != nil {
+				logs = append(logs, "err", err)
+				output.Error = err.Error()
+			} else {
+				logs = append(logs, "sent", len(uuids))
+			}
+			logger.Info(logs...)
+			writeJSON(w, output, logger)
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	}
+}
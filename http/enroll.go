@@ -0,0 +1,117 @@
+package http
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jessepeterson/nanomdm/cryptoutil"
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/service"
+	"github.com/jessepeterson/nanomdm/tokenauth"
+)
+
+// EnrollAuthorizer authorizes the enrollment of a device by an
+// authenticated user before the enrollment profile is returned. Info is
+// nil if the device sent no device information. Info is unauthenticated
+// (its signature is not chained to Apple's device CA) so it must not be
+// relied upon to identify the device. Returning a *service.RejectError
+// responds with its HTTP status and body.
+type EnrollAuthorizer interface {
+	AuthorizeEnrollment(ctx context.Context, info *mdm.MachineInfo, id *tokenauth.Identity) error
+}
+
+// machineInfo decodes the signed device information of an enrollment
+// profile request from the x-apple-aspen-deviceinfo header (web-based
+// enrollment) or the body (Automated Device Enrollment). It returns nil
+// if the request has none.
+//
+// Note the signature is only checked against the certificate included
+// in the signed data, which is not chained to Apple's device CA. Anyone
+// can self-sign device information so it is unauthenticated.
+func machineInfo(r *http.Request) (*mdm.MachineInfo, error) {
+	var signed []byte
+	if header := r.Header.Get("x-apple-aspen-deviceinfo"); header != "" {
+		var err error
+		if signed, err = base64.StdEncoding.DecodeString(header); err != nil {
+			return nil, err
+		}
+	} else if r.Method == http.MethodPost {
+		var err error
+		if signed, err = ReadAllAndReplaceBody(r); err != nil {
+			return nil, err
+		}
+	}
+	if len(signed) == 0 {
+		return nil, nil
+	}
+	content, _, err := cryptoutil.VerifySignedData(signed)
+	if err != nil {
+		return nil, fmt.Errorf("decoding device info: %w", err)
+	}
+	return mdm.DecodeMachineInfo(content)
+}
+
+// EnrollHandlerFunc serves the enrollment profile to devices. If
+// provider is not nil the request must be authenticated with a bearer
+// token of the user (see tokenauth) otherwise the device is sent to
+// authURL to authenticate with an HTTP 401 "apple-as-web" challenge.
+// The device then retries with the token the authentication page
+// returns. If authorizer is not nil it must authorize the enrollment.
+func EnrollHandlerFunc(profile []byte, provider tokenauth.IdentityProvider, authURL string, authorizer EnrollAuthorizer, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		info, err := machineInfo(r)
+		if err != nil {
+			logger.Info("msg", "decoding device info", "err", err)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		logs := []interface{}{"msg", "enrollment profile"}
+		if info != nil {
+			logs = append(logs, "udid", info.UDID, "serial_number", info.SerialNumber)
+		}
+		var id *tokenauth.Identity
+		if provider != nil {
+			auth := r.Header.Get("Authorization")
+			if len(auth) >= 7 && strings.EqualFold(auth[:7], "Bearer ") {
+				id, err = provider.Identify(r.Context(), strings.TrimSpace(auth[7:]))
+			}
+			if (id == nil && err == nil) || errors.Is(err, tokenauth.ErrInvalidToken) {
+				logger.Debug(append(logs, "err", "authentication required")...)
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer method="apple-as-web" url="%s"`, authURL))
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			} else if err != nil {
+				logger.Info(append(logs, "err", err)...)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			logs = append(logs, "subject", id.Subject)
+		}
+		if authorizer != nil {
+			if err = authorizer.AuthorizeEnrollment(r.Context(), info, id); err != nil {
+				logger.Info(append(logs, "err", err)...)
+				var rejectErr *service.RejectError
+				if errors.As(err, &rejectErr) {
+					writeReject(w, rejectErr)
+				} else {
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				}
+				return
+			}
+		}
+		logger.Info(logs...)
+		w.Header().Set("Content-Type", "application/x-apple-aspen-config")
+		if _, err = w.Write(profile); err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/service"
+	"github.com/jessepeterson/nanomdm/tokenauth"
+)
+
+type enrollAuthorizer string
+
+func (a enrollAuthorizer) AuthorizeEnrollment(_ context.Context, _ *mdm.MachineInfo, id *tokenauth.Identity) error {
+	if id.Subject != string(a) {
+		return &service.RejectError{StatusCode: http.StatusForbidden}
+	}
+	return nil
+}
+
+func TestEnrollHandler(t *testing.T) {
+	tokens := tokenauth.StaticTokens{
+		{Token: "alice-token", Identity: tokenauth.Identity{Subject: "alice"}},
+		{Token: "bob-token", Identity: tokenauth.Identity{Subject: "bob"}},
+	}
+	h := EnrollHandlerFunc([]byte("profile"), tokens, "https://idp.example.com/login", enrollAuthorizer("alice"), log.NopLogger)
+	for _, test := range []struct {
+		token  string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"invalid", http.StatusUnauthorized},
+		{"bob-token", http.StatusForbidden},
+		{"alice-token", http.StatusOK},
+	} {
+		r := httptest.NewRequest("GET", "/enroll", nil)
+		if test.token != "" {
+			r.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("token %q: status: have %d, want %d", test.token, w.Code, test.status)
+		}
+		if challenge := w.Header().Get("WWW-Authenticate"); w.Code == http.StatusUnauthorized && !strings.Contains(challenge, `method="apple-as-web"`) {
+			t.Errorf("token %q: unexpected challenge: %q", test.token, challenge)
+		}
+		if w.Code == http.StatusOK && w.Body.String() != "profile" {
+			t.Errorf("unexpected profile: %q", w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package http
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// exportedEnrollment is an exported enrollment with its inventory.
+type exportedEnrollment struct {
+	*storage.Enrollment
+	Inventory *storage.DeviceInventory `json:"inventory,omitempty"`
+}
+
+// exportColumns are the CSV columns of exported enrollments.
+var exportColumns = []string{
+	"id", "device_id", "type", "topic", "enabled", "last_seen_at",
+	"parent_id", "user_short_name", "user_long_name", "awaiting_configuration",
+	"serial_number", "model", "model_name", "product_name", "device_name",
+	"os_version", "build_version", "filevault_enabled", "inventory_updated_at",
+}
+
+// formatTime formats t for CSV (empty if nil or zero).
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// record returns the CSV record of e in exportColumns order.
+func (e *exportedEnrollment) record() []string {
+	rec := []string{
+		e.ID, e.DeviceID, e.Type, e.Topic, strconv.FormatBool(e.Enabled), formatTime(e.LastSeenAt),
+		e.ParentID, e.UserShortName, e.UserLongName, strconv.FormatBool(e.AwaitingConfiguration),
+	}
+	inv := e.Inventory
+	if inv == nil {
+		return append(rec, make([]string, len(exportColumns)-len(rec))...)
+	}
+	var fileVault string
+	if inv.FileVaultEnabled != nil {
+		fileVault = strconv.FormatBool(*inv.FileVaultEnabled)
+	}
+	return append(rec,
+		inv.SerialNumber, inv.Model, inv.ModelName, inv.ProductName, inv.DeviceName,
+		inv.OSVersion, inv.BuildVersion, fileVault, formatTime(&inv.UpdatedAt),
+	)
+}
+
+// exportFormat returns the export format requested by r: "csv" (the
+// default) or "ndjson".
+func exportFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return strings.ToLower(format)
+	}
+	accept := r.Header.Get("Accept")
+	if strings.Contains(accept, "application/x-ndjson") || strings.Contains(accept, "application/jsonl") {
+		return "ndjson"
+	}
+	return "csv"
+}
+
+// ExportEnrollmentsHandlerFunc exports all enrollments with their
+// inventory attributes (if inventory is not nil and has any) for
+// spreadsheets and BI tools. Enrollments are streamed as CSV with a
+// header row or, with the "format=ndjson" query parameter (or an
+// "application/x-ndjson" Accept header), as newline-delimited JSON.
+func ExportEnrollmentsHandlerFunc(lister storage.EnrollmentLister, inventory storage.InventoryStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := exportFormat(r)
+		if format != "csv" && format != "ndjson" {
+			http.Error(w, "unsupported format: "+format, http.StatusBadRequest)
+			return
+		}
+		enrollments, err := lister.ListEnrollments(r.Context())
+		if err != nil {
+			logger.Info("msg", "list enrollments", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		invs := make(map[string]*storage.DeviceInventory)
+		if inventory != nil {
+			inventories, err := inventory.RetrieveInventory(r.Context(), nil)
+			if err != nil {
+				// export the enrollments without inventory
+				logger.Info("msg", "retrieving inventory", "err", err)
+			}
+			for _, inv := range inventories {
+				invs[inv.ID] = inv
+			}
+		}
+		logger.Debug("msg", "export enrollments", "format", format, "count", len(enrollments))
+
+		filename := "enrollments-" + time.Now().UTC().Format("20060102") + "." + format
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		if format == "ndjson" {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			enc := json.NewEncoder(w)
+			for _, e := range enrollments {
+				if err = enc.Encode(&exportedEnrollment{Enrollment: e, Inventory: invs[e.ID]}); err != nil {
+					break
+				}
+			}
+		} else {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			cw := csv.NewWriter(w)
+			err = cw.Write(exportColumns)
+			for _, e := range enrollments {
+				if err != nil {
+					break
+				}
+				err = cw.Write((&exportedEnrollment{Enrollment: e, Inventory: invs[e.ID]}).record())
+			}
+			cw.Flush()
+			if err == nil {
+				err = cw.Error()
+			}
+		}
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
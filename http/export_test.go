@@ -0,0 +1,88 @@
+package http
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// exportStore lists enrollments and their inventory.
+type exportStore struct {
+	storage.InventoryStore
+	enrollments []*storage.Enrollment
+	inventory   []*storage.DeviceInventory
+}
+
+func (s *exportStore) ListEnrollments(context.Context) ([]*storage.Enrollment, error) {
+	return s.enrollments, nil
+}
+
+func (s *exportStore) RetrieveInventory(context.Context, []string) ([]*storage.DeviceInventory, error) {
+	return s.inventory, nil
+}
+
+func TestExportEnrollments(t *testing.T) {
+	seen := time.Date(2024, 6, 4, 12, 0, 0, 0, time.UTC)
+	store := &exportStore{
+		enrollments: []*storage.Enrollment{
+			{ID: "A", DeviceID: "A", Type: "Device", Topic: "com.apple.mgmt.test", Enabled: true, LastSeenAt: &seen},
+			{ID: "A:U", DeviceID: "A", Type: "User", ParentID: "A", UserShortName: "user, name"},
+		},
+		inventory: []*storage.DeviceInventory{{ID: "A", SerialNumber: "C02TEST", OSVersion: "14.5", UpdatedAt: seen}},
+	}
+	handler := ExportEnrollmentsHandlerFunc(store, store, log.NopLogger)
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("GET", "/v1/enrollments/export", nil))
+	if have, want := rec.Header().Get("Content-Type"), "text/csv; charset=utf-8"; have != want {
+		t.Errorf("content type: have %q, want %q", have, want)
+	}
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected header and 2 records: %v", records)
+	}
+	row := make(map[string]string)
+	for i, col := range records[0] {
+		row[col] = records[1][i]
+	}
+	for col, want := range map[string]string{"id": "A", "enabled": "true", "last_seen_at": "2024-06-04T12:00:00Z", "serial_number": "C02TEST", "os_version": "14.5"} {
+		if have := row[col]; have != want {
+			t.Errorf("%s: have %q, want %q", col, have, want)
+		}
+	}
+	if have, want := records[2][7], "user, name"; have != want {
+		t.Errorf("user_short_name: have %q, want %q", have, want)
+	}
+
+	rec = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/v1/enrollments/export", nil)
+	r.Header.Set("Accept", "application/x-ndjson")
+	handler(rec, r)
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines: %q", rec.Body.String())
+	}
+	var e exportedEnrollment
+	if err := json.Unmarshal([]byte(lines[0]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Enrollment == nil || e.ID != "A" || e.Inventory == nil || e.Inventory.SerialNumber != "C02TEST" {
+		t.Errorf("unexpected enrollment: %s", lines[0])
+	}
+
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("GET", "/v1/enrollments/export?format=xml", nil))
+	if rec.Code != 400 {
+		t.Errorf("unsupported format: have status %d", rec.Code)
+	}
+}
@@ -0,0 +1,139 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// errGroupsUnsupported is returned when targeting a group without
+// group storage.
+var errGroupsUnsupported = errors.New("groups not supported by storage")
+
+// validGroupName checks that name can be used in URL paths and as a
+// query parameter value next to comma-separated identifiers.
+func validGroupName(name string) error {
+	if name == "" || len(name) > 255 || strings.ContainsAny(name, "/,") {
+		return fmt.Errorf("invalid group name: %q", name)
+	}
+	return nil
+}
+
+// targetIDs returns the enrollment IDs targeted by r: the members of
+// the group named by the "group" query parameter, those of the smart
+// group named by the "smart_group" query parameter, or otherwise the
+// comma-separated identifiers of the URL path. On error it also returns
+// the HTTP status code of the error.
+func targetIDs(ctx context.Context, r *http.Request, groups storage.GroupStore, smartGroups SmartGroupStore) ([]string, int, error) {
+	group, smartGroup := r.URL.Query().Get("group"), r.URL.Query().Get("smart_group")
+	if group == "" && smartGroup == "" {
+		return strings.Split(r.URL.Path, ","), 0, nil
+	}
+	if r.URL.Path != "" || (group != "" && smartGroup != "") {
+		return nil, http.StatusBadRequest, errors.New("more than one of group, smart group, and enrollment IDs given")
+	}
+	var ids []string
+	var err error
+	switch {
+	case smartGroup != "" && smartGroups == nil:
+		return nil, http.StatusBadRequest, errSmartGroupsUnsupported
+	case smartGroup != "":
+		ids, err = smartGroupTargets(ctx, smartGroups, smartGroup)
+	case groups == nil:
+		return nil, http.StatusBadRequest, errGroupsUnsupported
+	default:
+		ids, err = groups.RetrieveGroupMembers(ctx, group)
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, http.StatusNotFound, err
+	} else if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("retrieving group members: %w", err)
+	}
+	return ids, 0, nil
+}
+
+// GroupsHandlerFunc manages named groups of enrollments. The URL path
+// is the group name optionally followed by a slash and comma-separated
+// enrollment IDs:
+//
+//	GET                  lists the groups and their member counts
+//	GET    <group>       lists the members of the group
+//	PUT    <group>/<ids> adds the enrollments to the group
+//	DELETE <group>/<ids> removes the enrollments from the group
+//	DELETE <group>       deletes the group
+//
+// Groups are created by adding members and no longer exist once they
+// have none. The push and enqueue APIs target the members of a group
+// with the "group" query parameter.
+//
+// Note the whole URL path is used as the group name and identifiers.
+// This probably necessitates stripping the URL prefix before using.
+func GroupsHandlerFunc(store storage.GroupStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, idList := r.URL.Path, ""
+		if i := strings.Index(name, "/"); i >= 0 {
+			name, idList = name[:i], name[i+1:]
+		}
+		var ids []string
+		if idList != "" {
+			ids = strings.Split(idList, ",")
+		}
+		if name == "" {
+			if r.Method != http.MethodGet {
+				http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+				return
+			}
+			groups, err := store.ListGroups(r.Context())
+			if err != nil {
+				logger.Info("msg", "listing groups", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, &struct {
+				Groups map[string]int `json:"groups"`
+			}{groups}, logger)
+			return
+		}
+		if err := validGroupName(name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger := logger.With("group", name)
+		var msg string
+		var err error
+		switch {
+		case r.Method == http.MethodGet && ids == nil:
+			var members []string
+			if members, err = store.RetrieveGroupMembers(r.Context(), name); err == nil {
+				writeJSON(w, &struct {
+					Name          string   `json:"name"`
+					EnrollmentIDs []string `json:"enrollment_ids"`
+				}{name, members}, logger)
+				return
+			}
+		case r.Method == http.MethodPut && ids != nil:
+			msg, err = "added group members", store.AddGroupMembers(r.Context(), name, ids)
+		case r.Method == http.MethodDelete && ids != nil:
+			msg, err = "removed group members", store.RemoveGroupMembers(r.Context(), name, ids)
+		case r.Method == http.MethodDelete:
+			msg, err = "deleted group", store.DeleteGroup(r.Context(), name)
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else if err != nil {
+			logger.Info("msg", "group", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		} else {
+			logger.Info("msg", msg, "id_count", len(ids))
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/push"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// memGroups is an in-memory storage.GroupStore.
+type memGroups map[string][]string
+
+func (g memGroups) AddGroupMembers(_ context.Context, name string, ids []string) error {
+	g[name] = append(g[name], ids...)
+	return nil
+}
+
+func (g memGroups) RemoveGroupMembers(context.Context, string, []string) error {
+	return nil
+}
+
+func (g memGroups) DeleteGroup(_ context.Context, name string) error {
+	if _, ok := g[name]; !ok {
+		return storage.ErrNotFound
+	}
+	delete(g, name)
+	return nil
+}
+
+func (g memGroups) RetrieveGroupMembers(_ context.Context, name string) ([]string, error) {
+	if ids, ok := g[name]; ok {
+		return ids, nil
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (g memGroups) ListGroups(context.Context) (map[string]int, error) {
+	counts := make(map[string]int)
+	for name, ids := range g {
+		counts[name] = len(ids)
+	}
+	return counts, nil
+}
+
+// recordingPusher records the ids pushed to.
+type recordingPusher []string
+
+func (p *recordingPusher) Push(_ context.Context, ids []string) (map[string]*push.Response, error) {
+	*p = append(*p, ids...)
+	return nil, nil
+}
+
+func TestGroups(t *testing.T) {
+	groups := make(memGroups)
+	handler := GroupsHandlerFunc(groups, log.NopLogger)
+	for _, test := range []struct {
+		method, path string
+		want         int
+	}{
+		{"PUT", "lab/A,B", http.StatusNoContent},
+		{"GET", "lab", http.StatusOK},
+		{"GET", "", http.StatusOK},
+		{"PUT", "lab", http.StatusMethodNotAllowed},
+		{"GET", "a,b", http.StatusBadRequest},
+		{"DELETE", "other", http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(test.method, "/", nil)
+		r.URL.Path = test.path
+		handler(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s %s: have %d, want %d", test.method, test.path, w.Code, test.want)
+		}
+	}
+
+	pusher := new(recordingPusher)
+	push := PushHandlerFunc(pusher, log.NopLogger, WithPushGroups(groups))
+	for _, test := range []struct {
+		path, group string
+		want        int
+	}{
+		{"", "lab", http.StatusOK},
+		{"C", "", http.StatusOK},
+		{"C", "lab", http.StatusBadRequest},
+		{"", "other", http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/?group="+test.group, nil)
+		r.URL.Path = test.path
+		push(w, r)
+		if w.Code != test.want {
+			t.Errorf("push %q to group %q: have %d, want %d", test.path, test.group, w.Code, test.want)
+		}
+	}
+	if have, want := strings.Join(*pusher, ","), "A,B,C"; have != want {
+		t.Errorf("pushed to %s, want %s", have, want)
+	}
+	if have, want := fmt.Sprint(groups["lab"]), "[A B]"; have != want {
+		t.Errorf("members: have %s, want %s", have, want)
+	}
+}
@@ -0,0 +1,35 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ParseHeaders parses HTTP headers in "Name: value" form.
+func ParseHeaders(lines []string) (http.Header, error) {
+	headers := make(http.Header)
+	for _, line := range lines {
+		i := strings.IndexByte(line, ':')
+		if i < 1 {
+			return nil, fmt.Errorf("invalid header (want Name: value): %q", line)
+		}
+		name := strings.TrimSpace(line[:i])
+		if name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid header name: %q", name)
+		}
+		headers.Add(name, strings.TrimSpace(line[i+1:]))
+	}
+	return headers, nil
+}
+
+// HeaderMiddleware sets headers on the responses of next. Handlers may
+// still replace them (e.g. the Content-Type).
+func HeaderMiddleware(next http.Handler, headers http.Header) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for name, values := range headers {
+			w.Header()[name] = values
+		}
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,41 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResponseHeaders(t *testing.T) {
+	if _, err := ParseHeaders([]string{"no colon"}); err == nil {
+		t.Error("expected error")
+	}
+	device, err := ParseHeaders([]string{"Strict-Transport-Security: max-age=60", "x-trace: a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api, err := ParseHeaders([]string{"Cache-Control: no-store"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	h := &Handlers{MDM: ok, Enqueue: ok}
+	wrap := func(headers http.Header) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler { return HeaderMiddleware(next, headers) }
+	}
+	h.Wrap(wrap(device), wrap(api))
+	if h.Push != nil {
+		t.Error("nil handler wrapped")
+	}
+
+	w := httptest.NewRecorder()
+	h.MDM.ServeHTTP(w, httptest.NewRequest("POST", "/mdm", nil))
+	if w.Header().Get("X-Trace") != "a" || w.Header().Get("Strict-Transport-Security") != "max-age=60" || w.Header().Get("Cache-Control") != "" {
+		t.Errorf("unexpected device headers: %v", w.Header())
+	}
+	w = httptest.NewRecorder()
+	h.Enqueue.ServeHTTP(w, httptest.NewRequest("PUT", "/v1/enqueue/a", nil))
+	if w.Header().Get("Cache-Control") != "no-store" || w.Header().Get("X-Trace") != "" {
+		t.Errorf("unexpected API headers: %v", w.Header())
+	}
+}
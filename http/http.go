@@ -3,6 +3,7 @@ package http
 
 import (
 	"bytes"
+	"crypto/subtle"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -18,3 +19,26 @@ func ReadAllAndReplaceBody(r *http.Request) ([]byte, error) {
 	r.Body = io.NopCloser(bytes.NewBuffer(b))
 	return b, nil
 }
+
+// BasicAuthMiddleware requires HTTP Basic authentication using username
+// and password before calling next.
+func BasicAuthMiddleware(next http.Handler, username, password, realm string) http.HandlerFunc {
+	return BasicAuthFuncMiddleware(next, username, func() string { return password }, realm)
+}
+
+// BasicAuthFuncMiddleware is like BasicAuthMiddleware but the password
+// is returned by password for each request. This allows for rotating
+// the password without restarting.
+func BasicAuthFuncMiddleware(next http.Handler, username string, password func() string, realm string) http.HandlerFunc {
+	uBytes := []byte(username)
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(u), uBytes) != 1 || subtle.ConstantTimeCompare([]byte(p), []byte(password())) != 1 {
+			emitAPIAuthFailed(r, "invalid credentials")
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+realm+`"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
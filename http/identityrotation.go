@@ -0,0 +1,85 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// IdentityRotator rotates the MDM identities of enrollments.
+type IdentityRotator interface {
+	List(ctx context.Context, ids []string) ([]*storage.IdentityRotation, error)
+	// Rotate returns command UUIDs and errors by enrollment ID.
+	Rotate(ctx context.Context, ids []string) (map[string]string, map[string]error, error)
+}
+
+// IdentityRotationHandlerFunc starts and reports on identity rotations.
+// A GET returns the identity rotations of enrollments. A POST starts
+// identity rotations by enqueuing (and pushing) the rotation profile.
+//
+// Note the whole URL path is used as the (comma-separated) enrollment
+// identifiers. This probably necessitates stripping the URL prefix
+// before using.
+func IdentityRotationHandlerFunc(rotator IdentityRotator, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ids []string
+		if r.URL.Path != "" {
+			ids = strings.Split(r.URL.Path, ",")
+		}
+		switch r.Method {
+		case http.MethodGet:
+			output := &struct {
+				Rotations []*storage.IdentityRotation `json:"rotations"`
+				Error     string                      `json:"error,omitempty"`
+			}{}
+			rots, err := rotator.List(r.Context(), ids)
+			if err != nil {
+				logger.Info("msg", "retrieving identity rotations", "err", err)
+				output.Error = err.Error()
+			}
+			output.Rotations = rots
+			if output.Rotations == nil {
+				output.Rotations = []*storage.IdentityRotation{}
+			}
+			writeJSON(w, output, logger)
+		case http.MethodPost:
+			addr, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				addr = r.RemoteAddr
+			}
+			output := &struct {
+				CommandUUIDs map[string]string `json:"command_uuids,omitempty"`
+				Errors       map[string]string `json:"errors,omitempty"`
+				Error        string            `json:"error,omitempty"`
+			}{}
+			var idErrs map[string]error
+			if len(ids) < 1 {
+				err = errors.New("no enrollment IDs")
+			} else {
+				output.CommandUUIDs, idErrs, err = rotator.Rotate(r.Context(), ids)
+			}
+			if len(idErrs) > 0 {
+				output.Errors = make(map[string]string)
+				for id, idErr := range idErrs {
+					output.Errors[id] = idErr.Error()
+				}
+			}
+			logs := []interface{}{"msg", "identity rotation", "id_count", len(ids), "addr", addr}
+			if err != nil {
+				logs = append(logs, "err", err)
+				output.Error = err.Error()
+			} else {
+				logs = append(logs, "sent", len(output.CommandUUIDs))
+			}
+			logger.Info(logs...)
+			writeJSON(w, output, logger)
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/cmdplist"
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/push"
+	"github.com/jessepeterson/nanomdm/service/lostmode"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// lostModeRequest is the JSON body of a Lost Mode request.
+type lostModeRequest struct {
+	// Action is one of enable, disable, or locate.
+	Action string `json:"action"`
+	cmdplist.LostMode
+}
+
+// LostModeHandlerFunc drives the Lost Mode workflow. A GET returns a
+// JSON list of the Lost Mode states (including last locations) of
+// enrollments. A POST enqueues an EnableLostMode, DisableLostMode, or
+// DeviceLocation command depending on the action in the JSON body,
+// sends push notifications, and tracks the Lost Mode states. Devices
+// are only located when in Lost Mode.
+//
+// Note the whole URL path is used as the (comma-separated) enrollment
+// identifiers. This probably necessitates stripping the URL prefix
+// before using. Given the privacy sensitivity of locations this handler
+// should be protected with a dedicated credential.
+func LostModeHandlerFunc(store storage.LostModeStore, enqueuer storage.CommandEnqueuer, pusher push.Pusher, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ids []string
+		if r.URL.Path != "" {
+			ids = strings.Split(r.URL.Path, ",")
+		}
+		output := &struct {
+			States      []*storage.LostModeState `json:"states"`
+			CommandUUID string                   `json:"command_uuid,omitempty"`
+			RequestType string                   `json:"request_type,omitempty"`
+			Status      enrolledAPIResults       `json:"status,omitempty"`
+			Error       string                   `json:"error,omitempty"`
+		}{}
+		var err error
+		switch r.Method {
+		case http.MethodGet:
+			output.States, err = store.RetrieveLostMode(r.Context(), ids)
+			if err != nil {
+				logger.Info("msg", "retrieve lost mode", "err", err)
+				output.Error = err.Error()
+			}
+		case http.MethodPost:
+			req := new(lostModeRequest)
+			if err = json.NewDecoder(r.Body).Decode(req); err != nil {
+				logger.Info("msg", "decoding body", "err", err)
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			var cmd *cmdplist.Command
+			if len(ids) < 1 {
+				err = errors.New("no enrollment IDs")
+			} else {
+				switch req.Action {
+				case "enable":
+					cmd, err = cmdplist.NewEnableLostMode(req.LostMode)
+				case "disable":
+					cmd = cmdplist.New("DisableLostMode")
+				case "locate":
+					cmd = cmdplist.New("DeviceLocation")
+				default:
+					err = fmt.Errorf("invalid action: %q", req.Action)
+				}
+			}
+			if err == nil {
+				output.CommandUUID, output.RequestType = cmd.CommandUUID, cmd.RequestType()
+				output.Status = make(enrolledAPIResults)
+				output.States, err = sendLostMode(r, store, enqueuer, pusher, ids, cmd, &req.LostMode, output.Status)
+			}
+			if err != nil {
+				logger.Info("msg", "lost mode", "action", req.Action, "err", err)
+				output.Error = err.Error()
+			} else {
+				// audit all Lost Mode actions
+				logger.Info(
+					"msg", "lost mode",
+					"action", req.Action,
+					"command_uuid", output.CommandUUID,
+					"id_count", len(ids),
+					"id_first", ids[0],
+				)
+			}
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if output.States == nil {
+			output.States = []*storage.LostModeState{}
+		}
+		json, err := json.MarshalIndent(output, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
+
+// sendLostMode enqueues the Lost Mode cmd to ids, tracks their Lost
+// Mode states, and pushes to the enrollments.
+func sendLostMode(r *http.Request, store storage.LostModeStore, enqueuer storage.CommandEnqueuer, pusher push.Pusher, ids []string, cmd *cmdplist.Command, lm *cmdplist.LostMode, status enrolledAPIResults) ([]*storage.LostModeState, error) {
+	existing, err := store.RetrieveLostMode(r.Context(), ids)
+	if err != nil {
+		return nil, err
+	}
+	states := make(map[string]*storage.LostModeState)
+	for _, st := range existing {
+		states[st.ID] = st
+	}
+	requestType := cmd.RequestType()
+	var send []string
+	for _, id := range ids {
+		if requestType == "DeviceLocation" && (states[id] == nil || states[id].State != lostmode.StateEnabled) {
+			status[id] = &enrolledAPIResult{CommandError: "not in lost mode"}
+			continue
+		}
+		send = append(send, id)
+	}
+	if len(send) < 1 {
+		return nil, nil
+	}
+	mdmCmd, err := cmd.MDMCommand()
+	if err != nil {
+		return nil, err
+	}
+	idErrs, err := enqueuer.EnqueueCommand(r.Context(), send, mdmCmd)
+	if err != nil {
+		return nil, err
+	}
+	var enqueued []string
+	var stored []*storage.LostModeState
+	for _, id := range send {
+		if idErrs[id] != nil {
+			status[id] = &enrolledAPIResult{CommandError: idErrs[id].Error()}
+			continue
+		}
+		enqueued = append(enqueued, id)
+		st := states[id]
+		if st == nil {
+			st = &storage.LostModeState{ID: id, State: lostmode.StateDisabled}
+		}
+		st.CommandUUID = cmd.CommandUUID
+		st.RequestType = requestType
+		st.Error = ""
+		st.UpdatedAt = time.Now()
+		switch requestType {
+		case "EnableLostMode":
+			st.State = lostmode.StateEnabling
+			st.Message, st.PhoneNumber, st.Footnote = lm.Message, lm.PhoneNumber, lm.Footnote
+		case "DisableLostMode":
+			st.State = lostmode.StateDisabling
+		}
+		if err = store.StoreLostMode(r.Context(), st); err != nil {
+			return stored, err
+		}
+		stored = append(stored, st)
+	}
+	if len(enqueued) < 1 {
+		return stored, nil
+	}
+	pushResp, err := pusher.Push(r.Context(), enqueued)
+	if err != nil {
+		return stored, err
+	}
+	for id, resp := range pushResp {
+		res := &enrolledAPIResult{PushResult: resp.Id}
+		if resp.Err != nil {
+			res.PushError = resp.Err.Error()
+		}
+		status[id] = res
+	}
+	return stored, nil
+}
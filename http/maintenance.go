@@ -0,0 +1,82 @@
+package http
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/jessepeterson/nanomdm/log"
+)
+
+// Maintenance is the runtime maintenance mode switch. The zero value
+// is not in maintenance mode.
+type Maintenance struct {
+	enabled int32
+}
+
+// Enabled reports whether maintenance mode is enabled.
+func (m *Maintenance) Enabled() bool {
+	return atomic.LoadInt32(&m.enabled) != 0
+}
+
+// SetEnabled enables or disables maintenance mode.
+func (m *Maintenance) SetEnabled(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&m.enabled, v)
+}
+
+// MaintenanceDeviceMiddleware accepts device requests without calling
+// next while m is in maintenance mode. The request body is discarded
+// and an empty HTTP 200 response (no command) is returned so devices
+// neither retry nor receive commands and storage is left untouched.
+func MaintenanceDeviceMiddleware(next http.Handler, m *Maintenance, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !m.Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		io.Copy(ioutil.Discard, r.Body)
+		logger.Debug("msg", "maintenance mode", "addr", clientAddr(r))
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// MaintenanceAPIMiddleware refuses API requests that may write to
+// storage (that is, other than GET and HEAD requests) with an HTTP 503
+// while m is in maintenance mode.
+func MaintenanceAPIMiddleware(next http.Handler, m *Maintenance) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if m.Enabled() && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "maintenance mode", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// MaintenanceHandlerFunc reports and switches maintenance mode m. A
+// GET returns the mode, a PUT (or POST) enables it, and a DELETE
+// disables it. All return the (new) mode as JSON.
+func MaintenanceHandlerFunc(m *Maintenance, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			m.SetEnabled(true)
+			logger.Info("msg", "maintenance mode enabled", "addr", clientAddr(r))
+		case http.MethodDelete:
+			m.SetEnabled(false)
+			logger.Info("msg", "maintenance mode disabled", "addr", clientAddr(r))
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, &struct {
+			Maintenance bool `json:"maintenance"`
+		}{m.Enabled()}, logger)
+	}
+}
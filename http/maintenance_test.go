@@ -0,0 +1,50 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/log"
+)
+
+func TestMaintenance(t *testing.T) {
+	m := new(Maintenance)
+	var called int
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called++
+		w.Write([]byte("command"))
+	})
+	device := MaintenanceDeviceMiddleware(next, m, log.NopLogger)
+	api := MaintenanceAPIMiddleware(next, m)
+	switcher := MaintenanceHandlerFunc(m, log.NopLogger)
+
+	w := httptest.NewRecorder()
+	switcher.ServeHTTP(w, httptest.NewRequest("PUT", "/v1/maintenance", nil))
+	if !m.Enabled() || !strings.Contains(w.Body.String(), `"maintenance": true`) {
+		t.Fatalf("maintenance mode not enabled: %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	device.ServeHTTP(w, httptest.NewRequest("PUT", "/mdm", strings.NewReader("<plist/>")))
+	if called != 0 || w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("device request processed in maintenance mode: %d %q", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest("PUT", "/v1/enqueue/a", nil))
+	if called != 0 || w.Code != http.StatusServiceUnavailable {
+		t.Errorf("API write in maintenance mode: %d", w.Code)
+	}
+	api.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/enrollments", nil))
+	if called != 1 {
+		t.Error("API read refused in maintenance mode")
+	}
+
+	switcher.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/v1/maintenance", nil))
+	w = httptest.NewRecorder()
+	device.ServeHTTP(w, httptest.NewRequest("PUT", "/mdm", nil))
+	if m.Enabled() || called != 2 || w.Body.String() != "command" {
+		t.Error("device request not processed after maintenance mode")
+	}
+}
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -51,12 +52,50 @@ func CheckinHandlerFunc(service service.Checkin, logger log.Logger) http.Handler
 			return
 		}
 		if err != nil {
-			logger.Info("msg", "service error in check-in", "err", err)
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			checkinError(w, err, logger)
 		}
 	}
 }
 
+// checkinError responds to a failed check-in. Rejected check-ins (see
+// service.RejectError) are responded to as requested.
+func checkinError(w http.ResponseWriter, err error, logger log.Logger) {
+	var rejectErr *service.RejectError
+	if !errors.As(err, &rejectErr) {
+		logger.Info("msg", "service error in check-in", "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	logger.Info("msg", "rejected check-in", "status", rejectErr.StatusCode, "err", err)
+	writeReject(w, rejectErr)
+}
+
+// commandError responds to a failed command report, honoring
+// service.RejectError like checkinError.
+func commandError(w http.ResponseWriter, err error, logger log.Logger) {
+	var rejectErr *service.RejectError
+	if !errors.As(err, &rejectErr) {
+		logger.Info("msg", "command report results", "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	logger.Info("msg", "rejected command report", "status", rejectErr.StatusCode, "err", err)
+	writeReject(w, rejectErr)
+}
+
+// writeReject responds with the status and body of rejectErr.
+func writeReject(w http.ResponseWriter, rejectErr *service.RejectError) {
+	if len(rejectErr.Body) == 0 {
+		http.Error(w, http.StatusText(rejectErr.StatusCode), rejectErr.StatusCode)
+		return
+	}
+	if rejectErr.ContentType != "" {
+		w.Header().Set("Content-Type", rejectErr.ContentType)
+	}
+	w.WriteHeader(rejectErr.StatusCode)
+	w.Write(rejectErr.Body)
+}
+
 // CommandAndReportResultsHandlerFunc decodes an MDM command request and adapts it to service.
 func CommandAndReportResultsHandlerFunc(service service.CommandAndReportResults, logger log.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -78,8 +117,8 @@ func CommandAndReportResultsHandlerFunc(service service.CommandAndReportResults,
 		}
 		cmd, err := service.CommandAndReportResults(mdmReq, report)
 		if err != nil {
-			logger.Info("msg", "command report results", "err", err)
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			commandError(w, err, logger)
+			return
 		}
 		if cmd != nil {
 			w.Write(cmd.Raw)
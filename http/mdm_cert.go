@@ -8,6 +8,7 @@ import (
 
 	"github.com/jessepeterson/nanomdm/cryptoutil"
 	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/tokenauth"
 )
 
 type contextKeyCert struct{}
@@ -30,12 +31,14 @@ func CertExtractPEMHeaderMiddleware(next http.Handler, header string, logger log
 		pemCert, err := url.QueryUnescape(escapedCert)
 		if err != nil {
 			logger.Info("msg", "unescaping header", "header", header, "err", err)
+			emitCertVerifyFailed(r, nil, err)
 			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 			return
 		}
 		cert, err := cryptoutil.DecodePEMCertificate([]byte(pemCert))
 		if err != nil {
 			logger.Info("msg", "decoding cert", "header", header, "err", err)
+			emitCertVerifyFailed(r, nil, err)
 			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 			return
 		}
@@ -84,6 +87,7 @@ func CertExtractMdmSignatureMiddleware(next http.Handler, logger log.Logger) htt
 		cert, err := cryptoutil.VerifyMdmSignature(mdmSig, b)
 		if err != nil {
 			logger.Info("msg", "verifying Mdm-Signature header", "err", err)
+			emitCertVerifyFailed(r, nil, err)
 			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 			return
 		}
@@ -105,14 +109,22 @@ type CertVerifier interface {
 }
 
 // CertVerifyMiddleware checks the MDM certificate against verifier and
-// returns an error if it fails.
+// returns an error if it fails. Requests without a certificate that were
+// authenticated with a bearer token (see TokenAuthMiddleware) are passed
+// through.
 //
 // We deliberately do not reply with 401 as this may cause unintentional
 // MDM unenrollments in the case of bugs or something going wrong.
 func CertVerifyMiddleware(next http.Handler, verifier CertVerifier, logger log.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if err := verifier.Verify(GetCert(r.Context())); err != nil {
+		cert := GetCert(r.Context())
+		if cert == nil && tokenauth.FromContext(r.Context()) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err := verifier.Verify(cert); err != nil {
 			logger.Info("msg", "error verifying MDM certificate", "err", err)
+			emitCertVerifyFailed(r, cert, err)
 			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 			return
 		}
@@ -0,0 +1,39 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/tokenauth"
+)
+
+// TokenAuthMiddleware identifies the bearer token in the Authorization
+// header of account-driven User Enrollment requests with provider and
+// adds its identity to the HTTP request context (see
+// tokenauth.FromContext). Requests without a bearer token are passed
+// through. Invalid tokens are rejected with HTTP 401 which prompts the
+// device to authenticate the user for a new token.
+func TokenAuthMiddleware(next http.Handler, provider tokenauth.IdentityProvider, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		id, err := provider.Identify(r.Context(), strings.TrimSpace(auth[7:]))
+		if errors.Is(err, tokenauth.ErrInvalidToken) || (err == nil && id == nil) {
+			logger.Info("msg", "invalid bearer token")
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			logger.Info("msg", "identifying bearer token", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		logger.Debug("msg", "bearer token identified", "subject", id.Subject)
+		next.ServeHTTP(w, r.WithContext(tokenauth.NewContext(r.Context(), id)))
+	}
+}
@@ -0,0 +1,262 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Paths are the URL paths at which the NanoMDM HTTP handlers are
+// registered. Paths that end in a slash take an identifier (or list of
+// identifiers) as the remainder of the URL path.
+type Paths struct {
+	MDM              string
+	Checkin          string
+	Manifest         string
+	Enroll           string
+	PushCert         string
+	PushCerts        string
+	TopicReport      string
+	PushRepair       string
+	Push             string
+	Enqueue          string
+	Enrollments      string
+	Export           string
+	EnrollmentQueue  string
+	Groups           string
+	SmartGroups      string
+	Deleted          string
+	Queue            string
+	QueueStats       string
+	Stuck            string
+	Stats            string
+	Inventory        string
+	AppInventory     string
+	OSUpdate         string
+	Apps             string
+	LostMode         string
+	BypassCode       string
+	ClearPasscode    string
+	Results          string
+	Blobs            string
+	APIv1            string
+	DevicePasswords  string
+	IdentityRotation string
+	Workflows        string
+	Decommission     string
+	Maintenance      string
+	Manifests        string
+	Migration        string
+	Metrics          string
+	Debug            string
+	Version          string
+}
+
+// DefaultPaths are the default NanoMDM URL paths.
+var DefaultPaths = Paths{
+	MDM:              "/mdm",
+	Checkin:          "/checkin",
+	Manifest:         "/manifest/",
+	Enroll:           "/enroll",
+	PushCert:         "/v1/pushcert",
+	PushCerts:        "/v1/pushcerts/",
+	TopicReport:      "/v1/topicreport",
+	PushRepair:       "/v1/pushrepair",
+	Push:             "/v1/push/",
+	Enqueue:          "/v1/enqueue/",
+	Enrollments:      "/v1/enrollments",
+	Export:           "/v1/enrollments/export",
+	EnrollmentQueue:  "/v1/enrollments/",
+	Groups:           "/v1/groups/",
+	SmartGroups:      "/v1/smartgroups/",
+	Deleted:          "/v1/deleted/",
+	Queue:            "/v1/queue/",
+	QueueStats:       "/v1/queuestats/",
+	Stuck:            "/v1/stuck",
+	Stats:            "/v1/stats",
+	Inventory:        "/v1/inventory/",
+	AppInventory:     "/v1/appinventory/",
+	OSUpdate:         "/v1/osupdate/",
+	Apps:             "/v1/apps/",
+	LostMode:         "/v1/lostmode/",
+	BypassCode:       "/v1/bypasscode/",
+	ClearPasscode:    "/v1/clearpasscode/",
+	Results:          "/v1/results/",
+	Blobs:            "/v1/blobs/",
+	APIv1:            "/api/v1/",
+	DevicePasswords:  "/v1/devicepasswords/",
+	IdentityRotation: "/v1/identityrotation/",
+	Workflows:        "/v1/workflows/",
+	Decommission:     "/v1/decommission/",
+	Maintenance:      "/v1/maintenance",
+	Manifests:        "/v1/manifests/",
+	Migration:        "/migration",
+	Metrics:          "/metrics",
+	Debug:            "/debug/",
+	Version:          "/version",
+}
+
+// WithAPIPrefix returns a copy of p with prefix prepended to the API
+// (that is, the non-device-facing) paths.
+func (p Paths) WithAPIPrefix(prefix string) Paths {
+	prefix = strings.TrimRight(prefix, "/")
+	for _, path := range []*string{&p.PushCert, &p.PushCerts, &p.TopicReport, &p.PushRepair, &p.Push, &p.Enqueue, &p.Enrollments, &p.Export, &p.EnrollmentQueue, &p.Groups, &p.SmartGroups, &p.Deleted, &p.Queue, &p.QueueStats, &p.Stuck, &p.Stats, &p.Inventory, &p.AppInventory, &p.OSUpdate, &p.Apps, &p.LostMode, &p.BypassCode, &p.ClearPasscode, &p.Results, &p.Blobs, &p.APIv1, &p.DevicePasswords, &p.IdentityRotation, &p.Workflows, &p.Decommission, &p.Maintenance, &p.Manifests, &p.Migration, &p.Metrics, &p.Debug, &p.Version} {
+		*path = prefix + *path
+	}
+	return p
+}
+
+// WithPrefix returns a copy of p with prefix prepended to every path.
+// This is useful for mounting NanoMDM under a sub-path of an existing
+// HTTP service.
+func (p Paths) WithPrefix(prefix string) Paths {
+	p = p.WithAPIPrefix(prefix)
+	prefix = strings.TrimRight(prefix, "/")
+	p.MDM = prefix + p.MDM
+	p.Checkin = prefix + p.Checkin
+	p.Manifest = prefix + p.Manifest
+	p.Enroll = prefix + p.Enroll
+	return p
+}
+
+// Mux registers HTTP handlers for a URL pattern.
+// Satisfied by *http.ServeMux (and many other routers).
+type Mux interface {
+	Handle(pattern string, handler http.Handler)
+}
+
+// Handlers are the NanoMDM HTTP handlers. Nil handlers are not registered.
+type Handlers struct {
+	MDM              http.Handler
+	Checkin          http.Handler
+	Manifest         http.Handler
+	Enroll           http.Handler
+	PushCert         http.Handler
+	PushCerts        http.Handler
+	TopicReport      http.Handler
+	PushRepair       http.Handler
+	Push             http.Handler
+	Enqueue          http.Handler
+	Enrollments      http.Handler
+	Export           http.Handler
+	EnrollmentQueue  http.Handler
+	Groups           http.Handler
+	SmartGroups      http.Handler
+	Deleted          http.Handler
+	Queue            http.Handler
+	QueueStats       http.Handler
+	Stuck            http.Handler
+	Stats            http.Handler
+	Inventory        http.Handler
+	AppInventory     http.Handler
+	OSUpdate         http.Handler
+	Apps             http.Handler
+	LostMode         http.Handler
+	BypassCode       http.Handler
+	ClearPasscode    http.Handler
+	Results          http.Handler
+	Blobs            http.Handler
+	APIv1            http.Handler
+	DevicePasswords  http.Handler
+	IdentityRotation http.Handler
+	Workflows        http.Handler
+	Decommission     http.Handler
+	Maintenance      http.Handler
+	Manifests        http.Handler
+	Migration        http.Handler
+	Metrics          http.Handler
+	Debug            http.Handler
+	Version          http.Handler
+}
+
+// endpoint is a handler of Handlers and its path.
+type endpoint struct {
+	path    string
+	handler *http.Handler
+}
+
+// endpoints returns the handlers of h and their paths.
+func (h *Handlers) endpoints(paths Paths) []endpoint {
+	return []endpoint{
+		{paths.MDM, &h.MDM},
+		{paths.Checkin, &h.Checkin},
+		{paths.Manifest, &h.Manifest},
+		{paths.Enroll, &h.Enroll},
+		{paths.PushCert, &h.PushCert},
+		{paths.PushCerts, &h.PushCerts},
+		{paths.TopicReport, &h.TopicReport},
+		{paths.PushRepair, &h.PushRepair},
+		{paths.Push, &h.Push},
+		{paths.Enqueue, &h.Enqueue},
+		{paths.Enrollments, &h.Enrollments},
+		{paths.Export, &h.Export},
+		{paths.EnrollmentQueue, &h.EnrollmentQueue},
+		{paths.Groups, &h.Groups},
+		{paths.SmartGroups, &h.SmartGroups},
+		{paths.Deleted, &h.Deleted},
+		{paths.Queue, &h.Queue},
+		{paths.QueueStats, &h.QueueStats},
+		{paths.Stuck, &h.Stuck},
+		{paths.Stats, &h.Stats},
+		{paths.Inventory, &h.Inventory},
+		{paths.AppInventory, &h.AppInventory},
+		{paths.OSUpdate, &h.OSUpdate},
+		{paths.Apps, &h.Apps},
+		{paths.LostMode, &h.LostMode},
+		{paths.BypassCode, &h.BypassCode},
+		{paths.ClearPasscode, &h.ClearPasscode},
+		{paths.Results, &h.Results},
+		{paths.Blobs, &h.Blobs},
+		{paths.APIv1, &h.APIv1},
+		{paths.DevicePasswords, &h.DevicePasswords},
+		{paths.IdentityRotation, &h.IdentityRotation},
+		{paths.Workflows, &h.Workflows},
+		{paths.Decommission, &h.Decommission},
+		{paths.Maintenance, &h.Maintenance},
+		{paths.Manifests, &h.Manifests},
+		{paths.Migration, &h.Migration},
+		{paths.Metrics, &h.Metrics},
+		{paths.Debug, &h.Debug},
+		{paths.Version, &h.Version},
+	}
+}
+
+// Register registers the non-nil handlers in h on mux at paths. The
+// handlers of paths that take identifiers in the URL path (those paths
+// ending in a slash) have their path prefix stripped so the identifiers
+// are the only remaining part of the URL path.
+func (h *Handlers) Register(mux Mux, paths Paths) {
+	for _, e := range h.endpoints(paths) {
+		if *e.handler == nil || e.path == "" {
+			continue
+		}
+		handler := *e.handler
+		if strings.HasSuffix(e.path, "/") {
+			handler = http.StripPrefix(e.path, handler)
+		}
+		mux.Handle(e.path, handler)
+	}
+}
+
+// Wrap wraps the non-nil handlers of the device endpoints in h (MDM,
+// Checkin, Manifest, and Enroll) with device and those of the other
+// (API) endpoints with api. Nil middleware leaves handlers unwrapped.
+func (h *Handlers) Wrap(device, api func(http.Handler) http.Handler) {
+	for _, e := range h.endpoints(Paths{}) {
+		mw := api
+		switch e.handler {
+		case &h.MDM, &h.Checkin, &h.Manifest, &h.Enroll:
+			mw = device
+		}
+		if *e.handler != nil && mw != nil {
+			*e.handler = mw(*e.handler)
+		}
+	}
+}
+
+// NewServeMux creates a new ServeMux and registers the handlers in h on
+// it at paths.
+func NewServeMux(h *Handlers, paths Paths) *http.ServeMux {
+	mux := http.NewServeMux()
+	h.Register(mux, paths)
+	return mux
+}
@@ -0,0 +1,83 @@
+package http
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/jessepeterson/nanomdm/log"
+)
+
+// ParseNetworks parses CIDR networks (such as "10.0.0.0/8"). Each
+// value may contain several comma-separated networks. Bare IP
+// addresses are treated as single-host networks.
+func ParseNetworks(values []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, value := range values {
+		for _, s := range strings.Split(value, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
+			}
+			if !strings.Contains(s, "/") {
+				ip := net.ParseIP(s)
+				if ip == nil {
+					return nil, fmt.Errorf("invalid IP address: %s", s)
+				}
+				bits := 8 * net.IPv6len
+				if ip.To4() != nil {
+					ip = ip.To4()
+					bits = 8 * net.IPv4len
+				}
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+			_, ipNet, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, err
+			}
+			nets = append(nets, ipNet)
+		}
+	}
+	return nets, nil
+}
+
+// IPAllowMiddleware only calls next if the remote address of the
+// request is in one of networks. Otherwise an HTTP 403 is returned.
+//
+// Only the connection's remote address is considered (not any
+// X-Forwarded-For header). Requests without an IP remote address (for
+// example those over Unix sockets) are refused.
+func IPAllowMiddleware(next http.Handler, networks []*net.IPNet, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if remoteIPIn(r, networks) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		logger.Info("msg", "address not allowed", "addr", r.RemoteAddr)
+		emitAPIAuthFailed(r, "address not allowed")
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	}
+}
+
+// ClientCertMiddleware only calls next if the request's TLS client
+// certificate is verified by verifier. Otherwise an HTTP 403 is
+// returned. The TLS listener must request client certificates.
+func ClientCertMiddleware(next http.Handler, verifier CertVerifier, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) < 1 {
+			logger.Info("msg", "missing client certificate", "addr", r.RemoteAddr)
+			emitAPIAuthFailed(r, "missing client certificate")
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		if err := verifier.Verify(r.TLS.PeerCertificates[0]); err != nil {
+			logger.Info("msg", "verifying client certificate", "addr", r.RemoteAddr, "err", err)
+			emitAPIAuthFailed(r, "client certificate: "+err.Error())
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
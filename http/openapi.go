@@ -0,0 +1,199 @@
+package http
+
+// OpenAPIv1 is the OpenAPI document of the v1 API served by
+// APIv1Handler.
+const OpenAPIv1 = `{
+	"openapi": "3.0.3",
+	"info": {
+		"title": "NanoMDM API",
+		"version": "1",
+		"description": "Versioned JSON API of NanoMDM. Every response is an Envelope with the response data, the pagination of lists, or an error with a machine-readable code."
+	},
+	"servers": [{"url": "/api/v1"}],
+	"security": [{"basicAuth": []}],
+	"paths": {
+		"/enrollments": {
+			"get": {
+				"summary": "List enrollments",
+				"operationId": "listEnrollments",
+				"parameters": [
+					{"$ref": "#/components/parameters/limit"},
+					{"$ref": "#/components/parameters/cursor"},
+					{"name": "device", "in": "query", "description": "Only the device channel enrollment of this ID and its user channel enrollments.", "schema": {"type": "string"}},
+					{"name": "awaiting_configuration", "in": "query", "description": "Only enrollments that are (1) or are not (0) awaiting configuration.", "schema": {"type": "string", "enum": ["0", "1"]}}
+				],
+				"responses": {
+					"200": {"description": "Enrollments", "content": {"application/json": {"schema": {"allOf": [{"$ref": "#/components/schemas/Envelope"}, {"properties": {"data": {"type": "array", "items": {"$ref": "#/components/schemas/Enrollment"}}}}]}}}},
+					"default": {"$ref": "#/components/responses/Error"}
+				}
+			}
+		},
+		"/enrollments/{id}/commands": {
+			"get": {
+				"summary": "List the command delivery audit trail of an enrollment",
+				"operationId": "listEnrollmentCommands",
+				"parameters": [
+					{"$ref": "#/components/parameters/id"},
+					{"$ref": "#/components/parameters/limit"},
+					{"$ref": "#/components/parameters/cursor"}
+				],
+				"responses": {
+					"200": {"description": "Command deliveries in queue order", "content": {"application/json": {"schema": {"allOf": [{"$ref": "#/components/schemas/Envelope"}, {"properties": {"data": {"type": "array", "items": {"$ref": "#/components/schemas/CommandDelivery"}}}}]}}}},
+					"default": {"$ref": "#/components/responses/Error"}
+				}
+			}
+		},
+		"/commands": {
+			"post": {
+				"summary": "Enqueue a command (and push)",
+				"operationId": "enqueueCommand",
+				"requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/CommandRequest"}}}},
+				"responses": {
+					"200": {"description": "Enqueued command", "content": {"application/json": {"schema": {"allOf": [{"$ref": "#/components/schemas/Envelope"}, {"properties": {"data": {"$ref": "#/components/schemas/CommandResponse"}}}]}}}},
+					"404": {"description": "No enrollment exists (data has the per-enrollment errors)", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Envelope"}}}},
+					"409": {"description": "Duplicate command UUID", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Envelope"}}}},
+					"410": {"description": "All enrollments are disabled (data has the per-enrollment errors)", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Envelope"}}}},
+					"default": {"$ref": "#/components/responses/Error"}
+				}
+			}
+		},
+		"/commands/{uuid}/result": {
+			"get": {
+				"summary": "Get (or wait for) the result of a command",
+				"description": "Returns the result of the enrollment that already arrived (only its status) or waits for the next result.",
+				"operationId": "getCommandResult",
+				"parameters": [
+					{"name": "uuid", "in": "path", "required": true, "schema": {"type": "string"}},
+					{"name": "id", "in": "query", "description": "Enrollment ID. Required without wait.", "schema": {"type": "string"}},
+					{"name": "wait", "in": "query", "description": "Duration to wait for a result (e.g. 30s, at most 5m).", "schema": {"type": "string"}}
+				],
+				"responses": {
+					"200": {"description": "Command result", "content": {"application/json": {"schema": {"allOf": [{"$ref": "#/components/schemas/Envelope"}, {"properties": {"data": {"$ref": "#/components/schemas/CommandResult"}}}]}}}},
+					"default": {"$ref": "#/components/responses/Error"}
+				}
+			}
+		},
+		"/push": {
+			"post": {
+				"summary": "Send APNs pushes to enrollments",
+				"operationId": "push",
+				"requestBody": {"required": true, "content": {"application/json": {"schema": {"type": "object", "description": "Either enrollment_ids or the name of a group or smart group of enrollments.", "properties": {"enrollment_ids": {"type": "array", "items": {"type": "string"}}, "group": {"type": "string"}, "smart_group": {"type": "string"}}}}}},
+				"responses": {
+					"200": {"description": "Push results", "content": {"application/json": {"schema": {"allOf": [{"$ref": "#/components/schemas/Envelope"}, {"properties": {"data": {"type": "object", "properties": {"enrollments": {"$ref": "#/components/schemas/EnrollmentStatus"}}}}}]}}}},
+					"default": {"$ref": "#/components/responses/Error"}
+				}
+			}
+		}
+	},
+	"components": {
+		"securitySchemes": {
+			"basicAuth": {"type": "http", "scheme": "basic", "description": "Username nanomdm and the API key as the password."}
+		},
+		"parameters": {
+			"id": {"name": "id", "in": "path", "required": true, "description": "Enrollment ID", "schema": {"type": "string"}},
+			"limit": {"name": "limit", "in": "query", "description": "Items per page (1 to 1000).", "schema": {"type": "integer", "default": 100}},
+			"cursor": {"name": "cursor", "in": "query", "description": "The next_cursor of the previous page.", "schema": {"type": "string"}}
+		},
+		"responses": {
+			"Error": {"description": "Error", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Envelope"}}}}
+		},
+		"schemas": {
+			"Envelope": {
+				"type": "object",
+				"properties": {
+					"data": {},
+					"pagination": {"type": "object", "properties": {"total": {"type": "integer"}, "next_cursor": {"type": "string"}}},
+					"error": {"type": "object", "properties": {"code": {"type": "string", "enum": ["invalid_request", "not_found", "method_not_allowed", "unsupported", "conflict", "enrollment_disabled", "internal_error"]}, "message": {"type": "string"}}}
+				}
+			},
+			"Enrollment": {
+				"type": "object",
+				"properties": {
+					"id": {"type": "string"},
+					"device_id": {"type": "string"},
+					"type": {"type": "string"},
+					"topic": {"type": "string"},
+					"enabled": {"type": "boolean"},
+					"last_seen_at": {"type": "string", "format": "date-time"},
+					"parent_id": {"type": "string"},
+					"user_short_name": {"type": "string"},
+					"user_long_name": {"type": "string"},
+					"user_channels": {"type": "array", "items": {"type": "string"}},
+					"has_push_magic": {"type": "boolean"},
+					"awaiting_configuration": {"type": "boolean"},
+					"not_on_console": {"type": "boolean"}
+				}
+			},
+			"CommandDelivery": {
+				"type": "object",
+				"properties": {
+					"id": {"type": "string"},
+					"command_uuid": {"type": "string"},
+					"request_type": {"type": "string"},
+					"status": {"type": "string"},
+					"active": {"type": "boolean"},
+					"enqueued_at": {"type": "string", "format": "date-time"},
+					"first_delivered_at": {"type": "string", "format": "date-time"},
+					"last_delivered_at": {"type": "string", "format": "date-time"},
+					"delivery_count": {"type": "integer"},
+					"last_not_now_at": {"type": "string", "format": "date-time"},
+					"not_now_count": {"type": "integer"},
+					"resolved_at": {"type": "string", "format": "date-time"}
+				}
+			},
+			"CommandRequest": {
+				"type": "object",
+				"description": "Either request_type (a cmdplist catalog command with args, data, and additional fields) or a raw command plist sent to either enrollment_ids or the members of a group or smart group.",
+				"properties": {
+					"enrollment_ids": {"type": "array", "items": {"type": "string"}},
+					"group": {"type": "string"},
+					"smart_group": {"type": "string"},
+					"channel": {"type": "string", "enum": ["device", "user", "all"]},
+					"request_type": {"type": "string"},
+					"args": {"type": "object", "additionalProperties": {"type": "string"}},
+					"data": {"type": "object", "additionalProperties": {"type": "string", "format": "byte"}},
+					"fields": {"type": "object", "description": "Additional command keys merged into the command."},
+					"plist": {"type": "string", "format": "byte"},
+					"no_push": {"type": "boolean"},
+					"callback_url": {"type": "string", "format": "uri"},
+					"wait": {"type": "string", "description": "Duration to wait for results (e.g. 30s, at most 5m)."}
+				}
+			},
+			"EnrollmentStatus": {
+				"type": "object",
+				"additionalProperties": {
+					"type": "object",
+					"properties": {
+						"push_error": {"type": "string"},
+						"push_result": {"type": "string"},
+						"command_error": {"type": "string"}
+					}
+				}
+			},
+			"CommandResponse": {
+				"type": "object",
+				"properties": {
+					"command_uuid": {"type": "string"},
+					"request_type": {"type": "string"},
+					"enrollments": {"$ref": "#/components/schemas/EnrollmentStatus"},
+					"results": {"type": "object", "additionalProperties": {"$ref": "#/components/schemas/CommandResult"}},
+					"wait_timeout": {"type": "boolean"}
+				}
+			},
+			"CommandResult": {
+				"type": "object",
+				"properties": {
+					"command_uuid": {"type": "string"},
+					"id": {"type": "string"},
+					"udid": {"type": "string"},
+					"enrollment_id": {"type": "string"},
+					"status": {"type": "string"},
+					"error_chain": {"type": "array", "items": {"type": "object"}},
+					"responded_at": {"type": "string", "format": "date-time"},
+					"result": {"type": "object", "description": "The command result plist."}
+				}
+			}
+		}
+	}
+}
+`
@@ -0,0 +1,185 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/cmdplist"
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/push"
+	"github.com/jessepeterson/nanomdm/service/osupdate"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// osUpdateRollout is the JSON body of an OS update rollout request.
+type osUpdateRollout struct {
+	cmdplist.OSUpdate
+	Cohort string `json:"cohort,omitempty"`
+	// Force reschedules enrollments that already installed the update.
+	Force bool `json:"force,omitempty"`
+}
+
+// OSUpdateHandlerFunc drives OS update rollouts. A GET returns a JSON
+// list of the OS update states of enrollments, optionally limited to
+// the "cohort" query parameter. A POST schedules the OS update in the
+// JSON body on the enrollments, sends push notifications, and records
+// the enrollments as scheduled in the body's cohort. Enrollments that
+// already installed the same update are skipped unless forced.
+//
+// Note the whole URL path is used as the (comma-separated) enrollment
+// identifiers. This probably necessitates stripping the URL prefix
+// before using.
+func OSUpdateHandlerFunc(store storage.OSUpdateStore, enqueuer storage.CommandEnqueuer, pusher push.Pusher, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ids []string
+		if r.URL.Path != "" {
+			ids = strings.Split(r.URL.Path, ",")
+		}
+		output := &struct {
+			States      []*storage.OSUpdateState `json:"states"`
+			CommandUUID string                   `json:"command_uuid,omitempty"`
+			Skipped     []string                 `json:"skipped,omitempty"`
+			Status      enrolledAPIResults       `json:"status,omitempty"`
+			Error       string                   `json:"error,omitempty"`
+		}{}
+		var err error
+		switch r.Method {
+		case http.MethodGet:
+			output.States, err = store.RetrieveOSUpdateStates(r.Context(), ids, r.URL.Query().Get("cohort"))
+			if err != nil {
+				logger.Info("msg", "retrieve OS update states", "err", err)
+				output.Error = err.Error()
+			}
+		case http.MethodPost:
+			rollout := new(osUpdateRollout)
+			if err = json.NewDecoder(r.Body).Decode(rollout); err != nil {
+				logger.Info("msg", "decoding body", "err", err)
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			if len(ids) < 1 {
+				err = errors.New("no enrollment IDs")
+			} else if rollout.ProductKey == "" && rollout.ProductVersion == "" {
+				err = errors.New("product key or product version required")
+			}
+			if err == nil {
+				output.Status = make(enrolledAPIResults)
+				ids, output.Skipped, err = schedulable(r, store, ids, rollout)
+			}
+			if err == nil && len(ids) > 0 {
+				output.CommandUUID, output.States, err = scheduleOSUpdate(r, store, enqueuer, pusher, ids, rollout, output.Status)
+			}
+			if err != nil {
+				logger.Info("msg", "schedule OS update", "err", err)
+				output.Error = err.Error()
+			} else {
+				logger.Debug(
+					"msg", "schedule OS update",
+					"command_uuid", output.CommandUUID,
+					"cohort", rollout.Cohort,
+					"id_count", len(ids),
+					"skipped", len(output.Skipped),
+				)
+			}
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if output.States == nil {
+			output.States = []*storage.OSUpdateState{}
+		}
+		json, err := json.MarshalIndent(output, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
+
+// schedulable splits ids into the enrollments to schedule rollout on
+// and those that already installed it.
+func schedulable(r *http.Request, store storage.OSUpdateStore, ids []string, rollout *osUpdateRollout) (schedule []string, skipped []string, err error) {
+	if rollout.Force {
+		return ids, nil, nil
+	}
+	states, err := store.RetrieveOSUpdateStates(r.Context(), ids, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	installed := make(map[string]bool)
+	for _, st := range states {
+		if st.State == osupdate.StateInstalled &&
+			st.ProductKey == rollout.ProductKey &&
+			st.ProductVersion == rollout.ProductVersion {
+			installed[st.ID] = true
+		}
+	}
+	for _, id := range ids {
+		if installed[id] {
+			skipped = append(skipped, id)
+		} else {
+			schedule = append(schedule, id)
+		}
+	}
+	return
+}
+
+// scheduleOSUpdate enqueues the ScheduleOSUpdate command of rollout to
+// ids, pushes to them, and stores their scheduled states.
+func scheduleOSUpdate(r *http.Request, store storage.OSUpdateStore, enqueuer storage.CommandEnqueuer, pusher push.Pusher, ids []string, rollout *osUpdateRollout, status enrolledAPIResults) (string, []*storage.OSUpdateState, error) {
+	cmd, err := cmdplist.NewScheduleOSUpdate(rollout.OSUpdate).MDMCommand()
+	if err != nil {
+		return "", nil, err
+	}
+	idErrs, err := enqueuer.EnqueueCommand(r.Context(), ids, cmd)
+	if err != nil {
+		return cmd.CommandUUID, nil, err
+	}
+	var enqueued []string
+	for _, id := range ids {
+		if idErrs[id] != nil {
+			status[id] = &enrolledAPIResult{CommandError: idErrs[id].Error()}
+			continue
+		}
+		enqueued = append(enqueued, id)
+	}
+	var states []*storage.OSUpdateState
+	for _, id := range enqueued {
+		st := &storage.OSUpdateState{
+			ID:             id,
+			Cohort:         rollout.Cohort,
+			ProductKey:     rollout.ProductKey,
+			ProductVersion: rollout.ProductVersion,
+			InstallAction:  rollout.InstallAction,
+			CommandUUID:    cmd.CommandUUID,
+			State:          osupdate.StateScheduled,
+			UpdatedAt:      time.Now(),
+		}
+		if err = store.StoreOSUpdateState(r.Context(), st); err != nil {
+			return cmd.CommandUUID, states, err
+		}
+		states = append(states, st)
+	}
+	if len(enqueued) < 1 {
+		return cmd.CommandUUID, states, nil
+	}
+	pushResp, err := pusher.Push(r.Context(), enqueued)
+	if err != nil {
+		return cmd.CommandUUID, states, err
+	}
+	for id, resp := range pushResp {
+		res := &enrolledAPIResult{PushResult: resp.Id}
+		if resp.Err != nil {
+			res.PushError = resp.Err.Error()
+		}
+		status[id] = res
+	}
+	return cmd.CommandUUID, states, nil
+}
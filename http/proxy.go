@@ -0,0 +1,74 @@
+package http
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/secevent"
+)
+
+// ProxyHeaders are the request headers set by reverse proxies that
+// TrustedProxyMiddleware removes from requests of untrusted clients.
+var ProxyHeaders = []string{
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Proto",
+	"X-Real-IP",
+	"X-Request-Id",
+}
+
+// remoteIPIn reports whether the connection's remote address of r is
+// in one of networks. Requests without an IP remote address (for
+// example those over Unix sockets) are in none.
+func remoteIPIn(r *http.Request, networks []*net.IPNet) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return ipIn(net.ParseIP(host), networks)
+}
+
+// TrustedProxy reports whether r may supply reverse proxy headers,
+// that is whether networks is empty (all clients are trusted) or the
+// connection's remote address is in one of networks.
+func TrustedProxy(r *http.Request, networks []*net.IPNet) bool {
+	return len(networks) < 1 || remoteIPIn(r, networks)
+}
+
+// TrustedProxyMiddleware guards the headers only reverse proxies
+// connecting from networks may supply. Requests from other clients
+// have the strip headers removed and are refused with an HTTP 403 if
+// they contain any of the reject headers (such as the header of
+// CertExtractPEMHeaderMiddleware) as that can only be an attempt to
+// spoof an identity.
+func TrustedProxyMiddleware(next http.Handler, networks []*net.IPNet, strip, reject []string, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if TrustedProxy(r, networks) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, header := range reject {
+			if header != "" && r.Header.Get(header) != "" {
+				logger.Info("msg", "untrusted proxy header", "header", header, "addr", r.RemoteAddr)
+				secevent.Emit(r.Context(), &secevent.Event{
+					Type:   secevent.UntrustedProxyHeader,
+					Source: clientAddr(r),
+					Path:   requestPath(r),
+					Reason: "untrusted " + http.CanonicalHeaderKey(header) + " header",
+				})
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+		}
+		for _, header := range strip {
+			if header == "" || r.Header.Get(header) == "" {
+				continue
+			}
+			logger.Debug("msg", "removing untrusted proxy header", "header", header, "addr", r.RemoteAddr)
+			r.Header.Del(header)
+		}
+		next.ServeHTTP(w, r)
+	}
+}
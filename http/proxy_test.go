@@ -0,0 +1,49 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/log"
+)
+
+func TestTrustedProxyMiddleware(t *testing.T) {
+	networks, err := ParseNetworks([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got *http.Request
+	h := TrustedProxyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	}), networks, ProxyHeaders, []string{"X-Ssl-Client-Cert"}, log.NopLogger)
+
+	r := httptest.NewRequest("POST", "/mdm", nil)
+	r.RemoteAddr = "10.1.2.3:1234"
+	r.Header.Set("X-Forwarded-For", "192.0.2.1")
+	r.Header.Set("X-Ssl-Client-Cert", "cert")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got == nil || got.Header.Get("X-Forwarded-For") != "192.0.2.1" {
+		t.Error("trusted proxy headers not passed")
+	}
+
+	got = nil
+	r = httptest.NewRequest("POST", "/mdm", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("X-Forwarded-For", "10.1.2.3")
+	r.Header.Set("X-Request-Id", "a")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got == nil || got.Header.Get("X-Forwarded-For") != "" || got.Header.Get("X-Request-Id") != "" {
+		t.Error("untrusted proxy headers not removed")
+	}
+
+	got = nil
+	r.Header.Set("X-Ssl-Client-Cert", "cert")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got != nil || w.Code != http.StatusForbidden {
+		t.Errorf("untrusted certificate header: got status %d", w.Code)
+	}
+}
@@ -0,0 +1,111 @@
+package http
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/cryptoutil"
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/service/topic"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// StoredPushCert is the metadata of a stored push certificate.
+type StoredPushCert struct {
+	*cryptoutil.PushCertInfo
+	Expired bool   `json:"expired"`
+	Error   string `json:"error,omitempty"`
+}
+
+// storedPushCert returns the metadata of the stored push certificate
+// pemCert of topic.
+func storedPushCert(topic string, pemCert []byte, now time.Time) *StoredPushCert {
+	info, err := cryptoutil.PushCertInfoFromPEM(pemCert)
+	if err != nil {
+		return &StoredPushCert{PushCertInfo: &cryptoutil.PushCertInfo{Topic: topic}, Error: err.Error()}
+	}
+	return &StoredPushCert{PushCertInfo: info, Expired: now.After(info.NotAfter)}
+}
+
+// PushCertsHandlerFunc lists and deletes the stored push certificates.
+// The URL path is the topic (the path prefix must be stripped). A GET
+// without a topic lists the metadata (such as the serial number and
+// expiry) of all push certificates and with a topic returns that of
+// the topic's push certificate. A DELETE deletes the push certificate
+// and private key of the topic. Pushes to the topic fail afterwards.
+func PushCertsHandlerFunc(store storage.PushCertListStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		topic := r.URL.Path
+		switch {
+		case r.Method == http.MethodGet:
+		case r.Method == http.MethodDelete && topic != "":
+			if err := store.DeletePushCert(r.Context(), topic); err != nil {
+				logger.Info("msg", "deleting push cert", "topic", topic, "err", err)
+				status := storageErrorStatus(err)
+				if status == 0 {
+					status = http.StatusInternalServerError
+				}
+				http.Error(w, http.StatusText(status), status)
+				return
+			}
+			logger.Info("msg", "deleted push cert", "topic", topic)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		certs, err := store.ListPushCerts(r.Context())
+		if err != nil {
+			logger.Info("msg", "listing push certs", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		now := time.Now()
+		if topic != "" {
+			pemCert, ok := certs[topic]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			writeJSON(w, storedPushCert(topic, pemCert, now), logger)
+			return
+		}
+		output := struct {
+			PushCerts []*StoredPushCert `json:"push_certs"`
+		}{PushCerts: []*StoredPushCert{}}
+		for topic, pemCert := range certs {
+			output.PushCerts = append(output.PushCerts, storedPushCert(topic, pemCert, now))
+		}
+		sort.Slice(output.PushCerts, func(i, j int) bool {
+			return output.PushCerts[i].Topic < output.PushCerts[j].Topic
+		})
+		writeJSON(w, &output, logger)
+	}
+}
+
+// TopicReportStore lists enrollments and push certificates.
+type TopicReportStore interface {
+	storage.EnrollmentLister
+	storage.PushCertListStore
+}
+
+// TopicReportHandlerFunc returns the reconciliation of the topics of
+// the enabled enrollments with the stored push certificates (see
+// topic.Reconcile) as JSON.
+func TopicReportHandlerFunc(store TopicReportStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		report, err := topic.Reconcile(r.Context(), store, store, time.Now())
+		if err != nil {
+			logger.Info("msg", "reconciling topics", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, report, logger)
+	}
+}
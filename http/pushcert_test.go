@@ -0,0 +1,110 @@
+package http
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/cryptoutil"
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+type pushCertStore map[string][]byte
+
+func (s pushCertStore) ListPushCerts(_ context.Context) (map[string][]byte, error) {
+	return s, nil
+}
+
+func (s pushCertStore) DeletePushCert(_ context.Context, topic string) error {
+	if _, ok := s[topic]; !ok {
+		return fmt.Errorf("%w: %s", storage.ErrNotFound, topic)
+	}
+	delete(s, topic)
+	return nil
+}
+
+func pushCertPEM(t *testing.T, topic string, serial int64, notAfter time.Time) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject: pkix.Name{
+			CommonName: "APSP:test",
+			ExtraNames: []pkix.AttributeTypeAndValue{{Type: asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 1}, Value: topic}},
+		},
+		NotBefore: notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:  notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cryptoutil.PEMCertificate(der)
+}
+
+func TestPushCertsHandler(t *testing.T) {
+	const a, b = "com.apple.mgmt.External.a", "com.apple.mgmt.External.b"
+	store := pushCertStore{
+		b: pushCertPEM(t, b, 2, time.Now().Add(-time.Hour)),
+		a: pushCertPEM(t, a, 1, time.Now().Add(time.Hour)),
+	}
+	h := PushCertsHandlerFunc(store, log.NopLogger)
+	do := func(method, topic string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(method, "/", nil)
+		r.URL.Path = topic
+		h.ServeHTTP(rec, r)
+		return rec
+	}
+
+	var list struct {
+		PushCerts []*StoredPushCert `json:"push_certs"`
+	}
+	if err := json.Unmarshal(do(http.MethodGet, "").Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.PushCerts) != 2 {
+		t.Fatalf("push certs: have %d, want 2", len(list.PushCerts))
+	}
+	for i, want := range []struct {
+		topic   string
+		serial  string
+		expired bool
+	}{{a, "1", false}, {b, "2", true}} {
+		have := list.PushCerts[i]
+		if have.Topic != want.topic || have.Serial != want.serial || have.Expired != want.expired {
+			t.Errorf("push cert %d: have %s %s expired=%v, want %v", i, have.Topic, have.Serial, have.Expired, want)
+		}
+	}
+
+	if rec := do(http.MethodDelete, b); rec.Code != http.StatusNoContent {
+		t.Errorf("delete: have %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if rec := do(http.MethodDelete, b); rec.Code != http.StatusNotFound {
+		t.Errorf("delete again: have %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := do(http.MethodGet, b); rec.Code != http.StatusNotFound {
+		t.Errorf("get deleted: have %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := do(http.MethodGet, a); rec.Code != http.StatusOK {
+		t.Errorf("get: have %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := do(http.MethodDelete, ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("delete all: have %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,120 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/service/pushrepair"
+)
+
+// PushRepairer detects and repairs enrollments with missing or stale
+// push info.
+type PushRepairer interface {
+	Detect(ctx context.Context) ([]*pushrepair.Enrollment, error)
+	// Refresh and Reenroll return errors by enrollment ID.
+	Refresh(ctx context.Context, ids []string) (map[string]error, error)
+	Reenroll(ctx context.Context, ids []string) (map[string]error, error)
+}
+
+// PushRepairRequest is the body of a push info repair POST.
+type PushRepairRequest struct {
+	// Action is "refresh" (re-install the MDM enrollment to send new
+	// push info) or "reenroll" (mark as unenrolled).
+	Action string `json:"action"`
+	// IDs are the enrollment IDs to repair. All detected enrollments
+	// are repaired if empty.
+	IDs []string `json:"ids"`
+}
+
+// PushRepairHandlerFunc detects and repairs enrollments with missing or
+// stale push info (e.g. after a partial migration). A GET returns the
+// enabled enrollments with push info problems. A POST of a
+// PushRepairRequest repairs enrollments.
+func PushRepairHandlerFunc(repairer PushRepairer, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			found, err := repairer.Detect(r.Context())
+			if err != nil {
+				logger.Info("msg", "detecting push info problems", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, &struct {
+				Enrollments []*pushrepair.Enrollment `json:"enrollments"`
+			}{Enrollments: found}, logger)
+		case http.MethodPost:
+			addr, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				addr = r.RemoteAddr
+			}
+			req := new(PushRepairRequest)
+			if err = json.NewDecoder(r.Body).Decode(req); err != nil {
+				logger.Info("msg", "decoding push repair request", "err", err)
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			var repair func(context.Context, []string) (map[string]error, error)
+			switch req.Action {
+			case "refresh":
+				repair = repairer.Refresh
+			case "reenroll":
+				repair = repairer.Reenroll
+			default:
+				http.Error(w, "unknown action", http.StatusBadRequest)
+				return
+			}
+			output := &struct {
+				Repaired []string          `json:"repaired"`
+				Errors   map[string]string `json:"errors,omitempty"`
+				Error    string            `json:"error,omitempty"`
+			}{Repaired: []string{}}
+			ids := req.IDs
+			if len(ids) < 1 {
+				found, err := repairer.Detect(r.Context())
+				if err != nil {
+					logger.Info("msg", "detecting push info problems", "err", err)
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+				for _, e := range found {
+					ids = append(ids, e.ID)
+				}
+			}
+			var idErrs map[string]error
+			if len(ids) < 1 {
+				err = errors.New("no enrollment IDs")
+			} else {
+				idErrs, err = repair(r.Context(), ids)
+			}
+			if err == nil {
+				for _, id := range ids {
+					if idErrs[id] == nil {
+						output.Repaired = append(output.Repaired, id)
+					}
+				}
+			}
+			if len(idErrs) > 0 {
+				output.Errors = make(map[string]string)
+				for id, idErr := range idErrs {
+					output.Errors[id] = idErr.Error()
+				}
+			}
+			logs := []interface{}{"msg", "push info repair", "action", req.Action, "id_count", len(ids), "addr", addr}
+			if err != nil {
+				logs = append(logs, "err", err)
+				output.Error = err.Error()
+			} else {
+				logs = append(logs, "repaired", len(output.Repaired))
+			}
+			logger.Info(logs...)
+			writeJSON(w, output, logger)
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// queueLengthBuckets are the upper bounds of the queue length
+// distribution buckets.
+var queueLengthBuckets = []int{1, 2, 5, 10, 25, 50, 100}
+
+// queueSummary is the aggregate of the queue statistics of enrollments.
+type queueSummary struct {
+	// Enrollments is the number of enrollments with pending commands.
+	Enrollments int `json:"enrollments"`
+	Pending     int `json:"pending"`
+	// OldestAgeSeconds is the age of the oldest pending command.
+	OldestAgeSeconds float64 `json:"oldest_age_seconds"`
+	// Buckets are the cumulative counts of enrollments with pending
+	// commands by queue length upper bound.
+	Buckets map[string]int `json:"buckets"`
+}
+
+// summarizeQueueStats aggregates the queue statistics in stats at now.
+func summarizeQueueStats(stats []*storage.QueueStats, now time.Time) *queueSummary {
+	sum := &queueSummary{Buckets: make(map[string]int)}
+	for _, le := range queueLengthBuckets {
+		sum.Buckets[strconv.Itoa(le)] = 0
+	}
+	for _, st := range stats {
+		if st.Pending < 1 {
+			continue
+		}
+		sum.Enrollments++
+		sum.Pending += st.Pending
+		if st.OldestEnqueuedAt != nil {
+			if age := now.Sub(*st.OldestEnqueuedAt).Seconds(); age > sum.OldestAgeSeconds {
+				sum.OldestAgeSeconds = age
+			}
+		}
+		for _, le := range queueLengthBuckets {
+			if st.Pending <= le {
+				sum.Buckets[strconv.Itoa(le)]++
+			}
+		}
+	}
+	sum.Buckets["+Inf"] = sum.Enrollments
+	return sum
+}
+
+// QueueStatsHandlerFunc returns the pending command queue statistics of
+// enrollments (or all enrollments with pending commands) as JSON along
+// with an aggregate summary.
+//
+// Note the whole URL path is used as the (comma-separated) enrollment
+// identifiers. This probably necessitates stripping the URL prefix
+// before using.
+func QueueStatsHandlerFunc(store storage.QueueStatsStore, logger log.Logger) http.HandlerFunc {
+	type queueStats struct {
+		*storage.QueueStats
+		OldestAgeSeconds float64 `json:"oldest_age_seconds,omitempty"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ids []string
+		if r.URL.Path != "" {
+			ids = strings.Split(r.URL.Path, ",")
+		}
+		output := &struct {
+			Stats   []*queueStats `json:"stats"`
+			Summary *queueSummary `json:"summary,omitempty"`
+			Error   string        `json:"error,omitempty"`
+		}{Stats: []*queueStats{}}
+		stats, err := store.RetrieveQueueStats(r.Context(), ids)
+		if err != nil {
+			logger.Info("msg", "retrieving queue stats", "err", err)
+			output.Error = err.Error()
+		} else {
+			now := time.Now()
+			for _, st := range stats {
+				qs := &queueStats{QueueStats: st}
+				if st.OldestEnqueuedAt != nil {
+					qs.OldestAgeSeconds = now.Sub(*st.OldestEnqueuedAt).Seconds()
+				}
+				output.Stats = append(output.Stats, qs)
+			}
+			output.Summary = summarizeQueueStats(stats, now)
+		}
+		writeJSON(w, output, logger)
+	}
+}
+
+// MetricsCounter is an additional counter exposed by MetricsHandlerFunc.
+type MetricsCounter struct {
+	Name  string
+	Help  string
+	Value func() uint64
+
+	// Type is the Prometheus metric type. Defaults to "counter".
+	Type string
+}
+
+// MetricsHandlerFunc exposes command queue metrics (and counters) in
+// the Prometheus text format. Per-enrollment metrics of enrollments
+// with pending commands are included with the "enrollments" query
+// parameter (e.g. "?enrollments=1") as they may have a high cardinality.
+func MetricsHandlerFunc(store storage.QueueStatsStore, logger log.Logger, counters ...MetricsCounter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats, err := store.RetrieveQueueStats(r.Context(), nil)
+		if err != nil {
+			logger.Info("msg", "retrieving queue stats", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		now := time.Now()
+		sum := summarizeQueueStats(stats, now)
+		var b strings.Builder
+		b.WriteString("# HELP nanomdm_queue_pending_commands Number of pending queued commands.\n")
+		b.WriteString("# TYPE nanomdm_queue_pending_commands gauge\n")
+		fmt.Fprintf(&b, "nanomdm_queue_pending_commands %d\n", sum.Pending)
+		b.WriteString("# HELP nanomdm_queue_enrollments Number of enrollments with pending queued commands.\n")
+		b.WriteString("# TYPE nanomdm_queue_enrollments gauge\n")
+		fmt.Fprintf(&b, "nanomdm_queue_enrollments %d\n", sum.Enrollments)
+		b.WriteString("# HELP nanomdm_queue_oldest_age_seconds Age of the oldest pending queued command.\n")
+		b.WriteString("# TYPE nanomdm_queue_oldest_age_seconds gauge\n")
+		fmt.Fprintf(&b, "nanomdm_queue_oldest_age_seconds %g\n", sum.OldestAgeSeconds)
+		b.WriteString("# HELP nanomdm_queue_length Distribution of the queue lengths of enrollments with pending commands.\n")
+		b.WriteString("# TYPE nanomdm_queue_length histogram\n")
+		for _, le := range queueLengthBuckets {
+			fmt.Fprintf(&b, "nanomdm_queue_length_bucket{le=\"%d\"} %d\n", le, sum.Buckets[strconv.Itoa(le)])
+		}
+		fmt.Fprintf(&b, "nanomdm_queue_length_bucket{le=\"+Inf\"} %d\n", sum.Enrollments)
+		fmt.Fprintf(&b, "nanomdm_queue_length_sum %d\n", sum.Pending)
+		fmt.Fprintf(&b, "nanomdm_queue_length_count %d\n", sum.Enrollments)
+		for _, c := range counters {
+			fmt.Fprintf(&b, "# HELP %s %s\n", c.Name, c.Help)
+			typ := c.Type
+			if typ == "" {
+				typ = "counter"
+			}
+			fmt.Fprintf(&b, "# TYPE %s %s\n", c.Name, typ)
+			fmt.Fprintf(&b, "%s %d\n", c.Name, c.Value())
+		}
+		if r.URL.Query().Get("enrollments") != "" {
+			b.WriteString("# HELP nanomdm_enrollment_queue_pending_commands Number of pending queued commands of an enrollment.\n")
+			b.WriteString("# TYPE nanomdm_enrollment_queue_pending_commands gauge\n")
+			for _, st := range stats {
+				fmt.Fprintf(&b, "nanomdm_enrollment_queue_pending_commands{id=%q} %d\n", st.ID, st.Pending)
+			}
+			b.WriteString("# HELP nanomdm_enrollment_queue_oldest_age_seconds Age of the oldest pending queued command of an enrollment.\n")
+			b.WriteString("# TYPE nanomdm_enrollment_queue_oldest_age_seconds gauge\n")
+			for _, st := range stats {
+				if st.OldestEnqueuedAt != nil {
+					fmt.Fprintf(&b, "nanomdm_enrollment_queue_oldest_age_seconds{id=%q} %g\n", st.ID, now.Sub(*st.OldestEnqueuedAt).Seconds())
+				}
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if _, err = w.Write([]byte(b.String())); err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
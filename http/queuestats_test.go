@@ -0,0 +1,26 @@
+package http
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+func TestSummarizeQueueStats(t *testing.T) {
+	now := time.Now()
+	old, older := now.Add(-time.Minute), now.Add(-time.Hour)
+	sum := summarizeQueueStats([]*storage.QueueStats{
+		{ID: "a", Pending: 1, OldestEnqueuedAt: &old},
+		{ID: "b", Pending: 12, OldestEnqueuedAt: &older},
+		{ID: "c"},
+	}, now)
+	if sum.Enrollments != 2 || sum.Pending != 13 || sum.OldestAgeSeconds != time.Hour.Seconds() {
+		t.Errorf("unexpected summary: %+v", sum)
+	}
+	for le, want := range map[string]int{"1": 1, "10": 1, "25": 2, "+Inf": 2} {
+		if have := sum.Buckets[le]; have != want {
+			t.Errorf("bucket %s: have %d, want %d", le, have, want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package http
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type contextKeyRemoteAddr struct{}
+
+// headerAddr returns the client address of the (comma-separated)
+// header values: the rightmost address not in one of trusted. The
+// leftmost entries are supplied by the client and so can't be trusted
+// while each proxy appends the address it saw. The leftmost address is
+// returned if all are trusted.
+func headerAddr(values []string, trusted []*net.IPNet) string {
+	var addrs []string
+	for _, v := range values {
+		for _, addr := range strings.Split(v, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	for i := len(addrs) - 1; i >= 0; i-- {
+		if i == 0 || !ipIn(net.ParseIP(addrs[i]), trusted) {
+			return addrs[i]
+		}
+	}
+	return ""
+}
+
+// ipIn reports whether ip is in one of networks.
+func ipIn(ip net.IP, networks []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoteAddrMiddleware stores the client address of the request in the
+// HTTP request context. If header is not empty and present in the
+// request then the rightmost of its (comma-separated) addresses that
+// is not in one of the trusted proxy networks is used, otherwise the
+// host part of the connection's remote address is used.
+//
+// The header should only be used behind a reverse proxy that sets it
+// (such as X-Real-IP or X-Forwarded-For). With a single reverse proxy
+// trusted may be empty. The addresses of further reverse proxies
+// appending to the header must be in trusted.
+func RemoteAddrMiddleware(next http.Handler, header string, trusted []*net.IPNet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		addr := ""
+		if header != "" {
+			addr = headerAddr(r.Header.Values(header), trusted)
+		}
+		if addr == "" {
+			var err error
+			addr, _, err = net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				addr = r.RemoteAddr
+			}
+		}
+		ctx := context.WithValue(r.Context(), contextKeyRemoteAddr{}, addr)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// GetRemoteAddr retrieves the client address from the HTTP request context.
+func GetRemoteAddr(ctx context.Context) string {
+	addr, _ := ctx.Value(contextKeyRemoteAddr{}).(string)
+	return addr
+}
@@ -0,0 +1,42 @@
+package http
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRemoteAddrMiddleware(t *testing.T) {
+	_, trusted, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		header  []string
+		trusted []*net.IPNet
+		addr    string
+	}{
+		{nil, nil, "192.0.2.9"},
+		{[]string{"198.51.100.1"}, nil, "198.51.100.1"},
+		// client-supplied leftmost entries are ignored
+		{[]string{"203.0.113.7, 198.51.100.1"}, nil, "198.51.100.1"},
+		{[]string{"203.0.113.7, 198.51.100.1, 10.0.0.2"}, []*net.IPNet{trusted}, "198.51.100.1"},
+		{[]string{"203.0.113.7", "198.51.100.1", "10.0.0.2"}, []*net.IPNet{trusted}, "198.51.100.1"},
+		{[]string{"10.0.0.3, 10.0.0.2"}, []*net.IPNet{trusted}, "10.0.0.3"},
+	} {
+		var addr string
+		h := RemoteAddrMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			addr = GetRemoteAddr(r.Context())
+		}), "X-Forwarded-For", test.trusted)
+		r := httptest.NewRequest("POST", "/mdm", nil)
+		r.RemoteAddr = "192.0.2.9:1234"
+		for _, v := range test.header {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		if addr != test.addr {
+			t.Errorf("%v: have %q, want %q", test.header, addr, test.addr)
+		}
+	}
+}
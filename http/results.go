@@ -0,0 +1,118 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/bus"
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/service/callback"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// DefaultResultWait is how long ResultWaitHandlerFunc waits for a
+// command result by default.
+const DefaultResultWait = 30 * time.Second
+
+// ResultWaitHandlerFunc long-polls for the result of a command from
+// b. The URL path is the command UUID followed by "/wait".
+//
+// The "timeout" query parameter is the duration to wait (at most
+// MaxEnqueueWait) and the "id" query parameter only waits for the
+// result of that enrollment. With an id the delivery audit trail is
+// checked first so a result that already arrived is returned right
+// away, though only with its status (the parsed result isn't kept).
+// If no result arrives in time "wait_timeout" is set.
+//
+// Note the whole URL path is used as the command UUID. This probably
+// necessitates stripping the URL prefix before using.
+func ResultWaitHandlerFunc(b bus.Bus, deliveries storage.CommandDeliveryStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		uuid := strings.TrimSuffix(r.URL.Path, "/wait")
+		if uuid == r.URL.Path || uuid == "" || strings.Contains(uuid, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		wait := DefaultResultWait
+		if timeout := r.URL.Query().Get("timeout"); timeout != "" {
+			var err error
+			if wait, err = time.ParseDuration(timeout); err == nil && (wait <= 0 || wait > MaxEnqueueWait) {
+				err = fmt.Errorf("timeout must be positive and at most %s", MaxEnqueueWait)
+			}
+			if err != nil {
+				logger.Info("msg", "timeout", "err", err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		id := r.URL.Query().Get("id")
+		output := &struct {
+			Result      *callback.Result `json:"result,omitempty"`
+			WaitTimeout bool             `json:"wait_timeout,omitempty"`
+			Error       string           `json:"error,omitempty"`
+		}{}
+		var err error
+		output.Result, output.WaitTimeout, err = commandResult(r.Context(), b, deliveries, uuid, id, wait)
+		if err != nil {
+			logger.Info("msg", "retrieving command deliveries", "id", id, "err", err)
+			output.Error = err.Error()
+		}
+		writeJSON(w, output, logger)
+	}
+}
+
+// commandResult waits up to wait for the result of command uuid (of
+// enrollment id, if not empty) from b. A result of enrollment id that
+// already arrived is returned from its delivery audit trail.
+func commandResult(ctx context.Context, b bus.Bus, deliveries storage.CommandDeliveryStore, uuid, id string, wait time.Duration) (result *callback.Result, timedOut bool, err error) {
+	// subscribe before checking the audit trail so no result is missed
+	results, cancel := b.Subscribe(bus.CommandResultTopic(uuid), 1)
+	defer cancel()
+	if id != "" {
+		delivered, err := deliveries.RetrieveCommandDeliveries(ctx, id)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, d := range delivered {
+			if d.CommandUUID == uuid && d.Status != "" && d.Status != "NotNow" {
+				result = &callback.Result{CommandUUID: uuid, ID: id, Status: d.Status}
+				if d.ResolvedAt != nil {
+					result.RespondedAt = *d.ResolvedAt
+				}
+				return result, false, nil
+			}
+		}
+	}
+	if wait <= 0 {
+		return nil, false, nil
+	}
+	result, timedOut = waitResult(ctx, results, id, wait)
+	return result, timedOut, nil
+}
+
+// waitResult receives the first result (of enrollment id, if not empty)
+// until wait elapses (reported with timedOut).
+func waitResult(ctx context.Context, results <-chan []byte, id string, wait time.Duration) (result *callback.Result, timedOut bool) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		select {
+		case payload := <-results:
+			result, err := callback.DecodeResult(payload)
+			if err == nil && (id == "" || result.ID == id) {
+				return result, false
+			}
+		case <-timer.C:
+			return nil, true
+		case <-ctx.Done():
+			return nil, true
+		}
+	}
+}
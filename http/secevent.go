@@ -0,0 +1,76 @@
+package http
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/jessepeterson/nanomdm/secevent"
+)
+
+// clientAddr returns the client address of r stored by
+// RemoteAddrMiddleware or, if not set, the host of its remote address.
+func clientAddr(r *http.Request) string {
+	if addr := GetRemoteAddr(r.Context()); addr != "" {
+		return addr
+	}
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return addr
+}
+
+// requestPath returns the path of r before any prefix was stripped
+// (see http.StripPrefix).
+func requestPath(r *http.Request) string {
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		return u.Path
+	}
+	return r.URL.Path
+}
+
+// emitCertVerifyFailed reports the failed certificate (or signature)
+// verification of device request r.
+func emitCertVerifyFailed(r *http.Request, cert *x509.Certificate, err error) {
+	ev := &secevent.Event{
+		Type:   secevent.CertVerifyFailed,
+		Source: clientAddr(r),
+		Path:   requestPath(r),
+		Reason: err.Error(),
+	}
+	if cert != nil {
+		hash := sha256.Sum256(cert.Raw)
+		ev.CertHash = hex.EncodeToString(hash[:])
+	}
+	secevent.Emit(r.Context(), ev)
+}
+
+// emitAPIAuthFailed reports the failed authentication of API request r.
+func emitAPIAuthFailed(r *http.Request, reason string) {
+	secevent.Emit(r.Context(), &secevent.Event{
+		Type:   secevent.APIAuthFailed,
+		Source: clientAddr(r),
+		Path:   requestPath(r),
+		Reason: reason,
+	})
+}
+
+// emitEnqueued reports the command enqueued to ids with API request r
+// if its request type is destructive.
+func emitEnqueued(r *http.Request, ids []string, requestType, commandUUID string) {
+	if len(ids) < 1 || !secevent.DestructiveRequestTypes[requestType] {
+		return
+	}
+	secevent.Emit(r.Context(), &secevent.Event{
+		Type:        secevent.DestructiveCommand,
+		Source:      clientAddr(r),
+		Path:        requestPath(r),
+		IDs:         ids,
+		RequestType: requestType,
+		CommandUUID: commandUUID,
+	})
+}
@@ -0,0 +1,62 @@
+package http
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/jessepeterson/nanomdm/log"
+)
+
+// ResponseSigner creates detached signatures of response bodies.
+type ResponseSigner interface {
+	// SignDetached returns a DER-encoded detached PKCS #7 (CMS)
+	// signature of content.
+	SignDetached(content []byte) ([]byte, error)
+}
+
+// bufferedResponseWriter buffers the status and body of a response.
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+// SignResponseMiddleware signs the bodies of successful (HTTP 200)
+// responses of next with signer. The base64-encoded detached signature
+// is sent in the Mdm-Signature header, mirroring the header devices
+// sign their requests with. Responses that can't be signed are replaced
+// with an HTTP 500 rather than sent unsigned.
+func SignResponseMiddleware(next http.Handler, signer ResponseSigner, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bw := &bufferedResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(bw, r)
+		if bw.status == 0 {
+			bw.status = http.StatusOK
+		}
+		if bw.status == http.StatusOK && bw.body.Len() > 0 {
+			sig, err := signer.SignDetached(bw.body.Bytes())
+			if err != nil {
+				logger.Info("msg", "signing response", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Mdm-Signature", base64.StdEncoding.EncodeToString(sig))
+		}
+		w.WriteHeader(bw.status)
+		w.Write(bw.body.Bytes())
+	}
+}
@@ -0,0 +1,142 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/service/inventory"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// errSmartGroupsUnsupported is returned when targeting a smart group
+// without smart group storage.
+var errSmartGroupsUnsupported = errors.New("smart groups not supported by storage")
+
+// SmartGroupStore stores smart groups and the inventory their filters
+// are evaluated against.
+type SmartGroupStore interface {
+	storage.SmartGroupStore
+	storage.InventoryStore
+}
+
+// smartGroupMembers evaluates the filter of smart group name against
+// the current inventory. It returns ErrNotFound if the smart group does
+// not exist.
+func smartGroupMembers(ctx context.Context, store SmartGroupStore, name string) (*storage.SmartGroup, []string, error) {
+	groups, err := store.RetrieveSmartGroups(ctx, []string{name})
+	if err != nil {
+		return nil, nil, fmt.Errorf("retrieving smart group: %w", err)
+	}
+	if len(groups) < 1 {
+		return nil, nil, fmt.Errorf("%w: smart group %s", storage.ErrNotFound, name)
+	}
+	filter, err := inventory.ParseFilter(groups[0].Filter)
+	if err != nil {
+		return nil, nil, fmt.Errorf("smart group %s: %w", name, err)
+	}
+	invs, err := store.RetrieveInventory(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("retrieving inventory: %w", err)
+	}
+	return groups[0], filter.Members(invs), nil
+}
+
+// smartGroupTargets returns the members of smart group name for
+// targeting. A smart group without members is reported as not found
+// like a group without members.
+func smartGroupTargets(ctx context.Context, store SmartGroupStore, name string) ([]string, error) {
+	_, ids, err := smartGroupMembers(ctx, store, name)
+	if err == nil && len(ids) < 1 {
+		err = fmt.Errorf("%w: smart group %s has no members", storage.ErrNotFound, name)
+	}
+	return ids, err
+}
+
+// SmartGroupsHandlerFunc manages smart groups: named inventory filter
+// expressions (see inventory.ParseFilter) whose members are evaluated
+// when used. The URL path is the smart group name:
+//
+//	GET           lists the smart groups
+//	GET    <name> returns the smart group and its current members
+//	PUT    <name> stores the filter expression of the request body
+//	DELETE <name> deletes the smart group
+//
+// The push and enqueue APIs target the members of a smart group with
+// the "smart_group" query parameter.
+//
+// Note the whole URL path is used as the smart group name. This
+// probably necessitates stripping the URL prefix before using.
+func SmartGroupsHandlerFunc(store SmartGroupStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Path
+		if name == "" {
+			if r.Method != http.MethodGet {
+				http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+				return
+			}
+			groups, err := store.RetrieveSmartGroups(r.Context(), nil)
+			if err != nil {
+				logger.Info("msg", "listing smart groups", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, &struct {
+				SmartGroups []*storage.SmartGroup `json:"smart_groups"`
+			}{groups}, logger)
+			return
+		}
+		if err := validGroupName(name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger := logger.With("smart_group", name)
+		var err error
+		switch r.Method {
+		case http.MethodGet:
+			var group *storage.SmartGroup
+			var members []string
+			if group, members, err = smartGroupMembers(r.Context(), store, name); err == nil {
+				writeJSON(w, &struct {
+					*storage.SmartGroup
+					EnrollmentIDs []string `json:"enrollment_ids"`
+				}{group, members}, logger)
+				return
+			}
+		case http.MethodPut:
+			var b []byte
+			if b, err = ioutil.ReadAll(r.Body); err != nil {
+				logger.Info("msg", "reading body", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			filter := strings.TrimSpace(string(b))
+			if _, err = inventory.ParseFilter(filter); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err = store.StoreSmartGroup(r.Context(), &storage.SmartGroup{Name: name, Filter: filter}); err == nil {
+				logger.Info("msg", "stored smart group", "filter", filter)
+			}
+		case http.MethodDelete:
+			if err = store.DeleteSmartGroup(r.Context(), name); err == nil {
+				logger.Info("msg", "deleted smart group")
+			}
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else if err != nil {
+			logger.Info("msg", "smart group", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
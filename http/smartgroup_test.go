@@ -0,0 +1,103 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// memSmartGroups is an in-memory SmartGroupStore.
+type memSmartGroups struct {
+	groups map[string]*storage.SmartGroup
+	invs   []*storage.DeviceInventory
+}
+
+func (m *memSmartGroups) StoreSmartGroup(_ context.Context, g *storage.SmartGroup) error {
+	m.groups[g.Name] = g
+	return nil
+}
+
+func (m *memSmartGroups) RetrieveSmartGroups(_ context.Context, names []string) ([]*storage.SmartGroup, error) {
+	var groups []*storage.SmartGroup
+	for _, name := range names {
+		if g, ok := m.groups[name]; ok {
+			groups = append(groups, g)
+		}
+	}
+	return groups, nil
+}
+
+func (m *memSmartGroups) DeleteSmartGroup(_ context.Context, name string) error {
+	if _, ok := m.groups[name]; !ok {
+		return storage.ErrNotFound
+	}
+	delete(m.groups, name)
+	return nil
+}
+
+func (m *memSmartGroups) StoreInventory(context.Context, *storage.DeviceInventory) error {
+	return nil
+}
+
+func (m *memSmartGroups) RetrieveInventory(context.Context, []string) ([]*storage.DeviceInventory, error) {
+	return m.invs, nil
+}
+
+func TestSmartGroups(t *testing.T) {
+	store := &memSmartGroups{
+		groups: make(map[string]*storage.SmartGroup),
+		invs: []*storage.DeviceInventory{
+			{ID: "A", OSVersion: "14.3"},
+			{ID: "B", OSVersion: "14.4"},
+		},
+	}
+	handler := SmartGroupsHandlerFunc(store, log.NopLogger)
+	for _, test := range []struct {
+		method, path, body string
+		want               int
+	}{
+		{"PUT", "old", "os_version < 14.4", http.StatusNoContent},
+		{"PUT", "none", "os_version > 15", http.StatusNoContent},
+		{"PUT", "bad", "os_version <", http.StatusBadRequest},
+		{"GET", "old", "", http.StatusOK},
+		{"GET", "bad", "", http.StatusNotFound},
+		{"POST", "old", "", http.StatusMethodNotAllowed},
+		{"DELETE", "bad", "", http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(test.method, "/", strings.NewReader(test.body))
+		r.URL.Path = test.path
+		handler(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s %s: have %d, want %d", test.method, test.path, w.Code, test.want)
+		}
+	}
+
+	pusher := new(recordingPusher)
+	push := PushHandlerFunc(pusher, log.NopLogger, WithPushSmartGroups(store))
+	for _, test := range []struct {
+		query string
+		want  int
+	}{
+		{"smart_group=old", http.StatusOK},
+		{"smart_group=none", http.StatusNotFound},
+		{"smart_group=other", http.StatusNotFound},
+		{"smart_group=old&group=lab", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/?"+test.query, nil)
+		r.URL.Path = ""
+		push(w, r)
+		if w.Code != test.want {
+			t.Errorf("push %s: have %d, want %d", test.query, w.Code, test.want)
+		}
+	}
+	if have, want := strings.Join(*pusher, ","), "A"; have != want {
+		t.Errorf("pushed to %s, want %s", have, want)
+	}
+}
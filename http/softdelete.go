@@ -0,0 +1,72 @@
+package http
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// DeletedEnrollmentsHandlerFunc lists and restores soft-deleted
+// enrollments. A GET returns the deleted enrollments (or all deleted
+// enrollments if none are given) as JSON. A POST restores the pending
+// commands and sub-enrollments of deleted enrollments that have since
+// re-enrolled.
+//
+// Note the whole URL path is used as the (comma-separated) enrollment
+// identifiers. This probably necessitates stripping the URL prefix
+// before using.
+func DeletedEnrollmentsHandlerFunc(store storage.SoftDeleteStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ids []string
+		if r.URL.Path != "" {
+			ids = strings.Split(r.URL.Path, ",")
+		}
+		switch r.Method {
+		case http.MethodGet:
+			output := &struct {
+				Deleted []*storage.DeletedEnrollment `json:"deleted"`
+				Error   string                       `json:"error,omitempty"`
+			}{Deleted: []*storage.DeletedEnrollment{}}
+			deleted, err := store.RetrieveDeletedEnrollments(r.Context(), ids)
+			if err != nil {
+				logger.Info("msg", "retrieving deleted enrollments", "err", err)
+				output.Error = err.Error()
+				w.WriteHeader(http.StatusInternalServerError)
+			} else if deleted != nil {
+				output.Deleted = deleted
+			}
+			writeJSON(w, output, logger)
+		case http.MethodPost:
+			if len(ids) < 1 {
+				http.Error(w, "no enrollment IDs", http.StatusBadRequest)
+				return
+			}
+			addr, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				addr = r.RemoteAddr
+			}
+			type restoreResult struct {
+				Restored int    `json:"restored"`
+				Error    string `json:"error,omitempty"`
+			}
+			output := make(map[string]*restoreResult)
+			for _, id := range ids {
+				res := new(restoreResult)
+				res.Restored, err = store.RestoreEnrollment(r.Context(), id)
+				logs := []interface{}{"msg", "restore enrollment", "id", id, "restored", res.Restored, "addr", addr}
+				if err != nil {
+					logs = append(logs, "err", err)
+					res.Error = err.Error()
+				}
+				logger.Info(logs...)
+				output[id] = res
+			}
+			writeJSON(w, output, logger)
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+const (
+	// DefaultStatsPeriod is the default period of the command counts.
+	DefaultStatsPeriod = 24 * time.Hour
+	// DefaultStatsInterval is the default width of the command count
+	// buckets.
+	DefaultStatsInterval = time.Hour
+
+	// maxStatsBuckets limits the number of command count buckets.
+	maxStatsBuckets = 1000
+)
+
+// StatsStore counts enrollments and commands.
+type StatsStore interface {
+	storage.EnrollmentCountStore
+	storage.CommandCountStore
+}
+
+// PushCounts are the push counts of an APNs topic.
+type PushCounts struct {
+	Topic    string `json:"topic"`
+	Pushes   uint64 `json:"pushes"`
+	Failures uint64 `json:"failures"`
+}
+
+// pushSummary is the aggregate of the push counts of all topics.
+type pushSummary struct {
+	Pushes   uint64 `json:"pushes"`
+	Failures uint64 `json:"failures"`
+	// SuccessRate is the fraction of successful pushes (if any).
+	SuccessRate *float64     `json:"success_rate,omitempty"`
+	Topics      []PushCounts `json:"topics"`
+}
+
+// summarizePushCounts aggregates the push counts of topics.
+func summarizePushCounts(topics []PushCounts) *pushSummary {
+	sum := &pushSummary{Topics: topics}
+	if sum.Topics == nil {
+		sum.Topics = []PushCounts{}
+	}
+	for _, t := range topics {
+		sum.Pushes += t.Pushes
+		sum.Failures += t.Failures
+	}
+	if sum.Pushes > 0 {
+		rate := float64(sum.Pushes-sum.Failures) / float64(sum.Pushes)
+		sum.SuccessRate = &rate
+	}
+	return sum
+}
+
+// commandSummary are the command counts over time.
+type commandSummary struct {
+	Since           time.Time                `json:"since"`
+	IntervalSeconds float64                  `json:"interval_seconds"`
+	Buckets         []*storage.CommandCounts `json:"buckets"`
+	// Total is the sum of the buckets (Start is Since).
+	Total *storage.CommandCounts `json:"total"`
+}
+
+// statsDuration parses the duration query parameter name of r.
+func statsDuration(r *http.Request, name string, def time.Duration) (time.Duration, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err == nil && d <= 0 {
+		err = fmt.Errorf("must be positive")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return d, nil
+}
+
+// StatsHandlerFunc returns enrollment counts by status and type, the
+// command counts (enqueued, acknowledged, errored, and NotNow) in time
+// buckets, and the push counts and success rate of pushCounts (if not
+// nil) as JSON for dashboards. The "since" query parameter is the
+// period of the command counts (DefaultStatsPeriod) and "interval" the
+// width of their buckets (DefaultStatsInterval), both Go durations
+// (e.g. "168h" and "24h"). Buckets are aligned to multiples of the
+// interval.
+func StatsHandlerFunc(store StatsStore, pushCounts func() []PushCounts, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		period, err := statsDuration(r, "since", DefaultStatsPeriod)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		interval, err := statsDuration(r, "interval", DefaultStatsInterval)
+		if err == nil && interval < time.Second {
+			err = fmt.Errorf("invalid interval: less than one second")
+		} else if err == nil && period/interval > maxStatsBuckets {
+			err = fmt.Errorf("too many buckets: more than %d", maxStatsBuckets)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		output := &struct {
+			Enrollments *storage.EnrollmentCounts `json:"enrollments,omitempty"`
+			Commands    *commandSummary           `json:"commands,omitempty"`
+			Push        *pushSummary              `json:"push,omitempty"`
+			Error       string                    `json:"error,omitempty"`
+		}{}
+		output.Enrollments, err = store.CountEnrollments(r.Context())
+		if err != nil {
+			logger.Info("msg", "counting enrollments", "err", err)
+			output.Error = err.Error()
+		}
+
+		since := time.Now().Add(-period).Truncate(interval)
+		buckets, err := store.CountCommands(r.Context(), since, interval)
+		if err != nil {
+			logger.Info("msg", "counting commands", "err", err)
+			output.Error = err.Error()
+		} else {
+			output.Commands = &commandSummary{
+				Since:           since,
+				IntervalSeconds: interval.Seconds(),
+				Buckets:         buckets,
+				Total:           &storage.CommandCounts{Start: since},
+			}
+			if output.Commands.Buckets == nil {
+				output.Commands.Buckets = []*storage.CommandCounts{}
+			}
+			for _, b := range buckets {
+				output.Commands.Total.Enqueued += b.Enqueued
+				output.Commands.Total.Acknowledged += b.Acknowledged
+				output.Commands.Total.Errored += b.Errored
+				output.Commands.Total.NotNow += b.NotNow
+			}
+		}
+
+		if pushCounts != nil {
+			output.Push = summarizePushCounts(pushCounts())
+		}
+		writeJSON(w, output, logger)
+	}
+}
@@ -0,0 +1,93 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
+	"github.com/jessepeterson/nanomdm/storage/memqueue"
+)
+
+// statsStore counts the enrollments of a list and the commands of a
+// memory queue.
+type statsStore struct {
+	*memqueue.MemQueue
+	enrollments []*storage.Enrollment
+}
+
+func (s *statsStore) CountEnrollments(context.Context) (*storage.EnrollmentCounts, error) {
+	counts := new(storage.EnrollmentCounts)
+	for _, e := range s.enrollments {
+		counts.Add(e, 1)
+	}
+	return counts, nil
+}
+
+func TestStats(t *testing.T) {
+	ctx := context.Background()
+	store := &statsStore{
+		MemQueue: memqueue.New(),
+		enrollments: []*storage.Enrollment{
+			{ID: "A", Type: "Device", Enabled: true, AwaitingConfiguration: true},
+			{ID: "A:U", Type: "User", Enabled: true},
+			{ID: "B", Type: "Device"},
+		},
+	}
+	for _, uuid := range []string{"1", "2", "3"} {
+		cmd := &mdm.Command{CommandUUID: uuid, Raw: []byte("<?xml")}
+		if _, err := store.EnqueueCommand(ctx, []string{"A"}, cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := &mdm.Request{Context: ctx, EnrollID: &mdm.EnrollID{ID: "A"}}
+	for uuid, status := range map[string]string{"1": "Acknowledged", "2": "Error", "3": "NotNow"} {
+		if err := store.StoreCommandReport(r, &mdm.CommandResults{CommandUUID: uuid, Status: status, Raw: []byte("<?xml")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pushCounts := func() []PushCounts {
+		return []PushCounts{{Topic: "a", Pushes: 3, Failures: 1}, {Topic: "b", Pushes: 1}}
+	}
+	handler := StatsHandlerFunc(store, pushCounts, log.NopLogger)
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("GET", "/v1/stats?since=3h", nil))
+	output := new(struct {
+		Enrollments *storage.EnrollmentCounts
+		Commands    *commandSummary
+		Push        *pushSummary
+		Error       string
+	})
+	if err := json.Unmarshal(rec.Body.Bytes(), output); err != nil {
+		t.Fatal(err)
+	}
+	if output.Error != "" {
+		t.Fatal(output.Error)
+	}
+	if e := output.Enrollments; e.Total != 3 || e.Enabled != 2 || e.Disabled != 1 || e.AwaitingConfiguration != 1 || e.ByType["Device"] != 1 || e.ByType["User"] != 1 {
+		t.Errorf("unexpected enrollment counts: %+v", e)
+	}
+	if have, want := len(output.Commands.Buckets), 4; have < want-1 || have > want {
+		t.Errorf("buckets: have %d, want about %d", have, want)
+	}
+	want := storage.CommandCounts{Enqueued: 3, Acknowledged: 1, Errored: 1, NotNow: 1}
+	total := *output.Commands.Total
+	total.Start = time.Time{}
+	if total != want {
+		t.Errorf("total: have %+v, want %+v", total, want)
+	}
+	if p := output.Push; p.Pushes != 4 || p.Failures != 1 || p.SuccessRate == nil || *p.SuccessRate != 0.75 {
+		t.Errorf("unexpected push summary: %+v", p)
+	}
+
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("GET", "/v1/stats?since=720h&interval=1m", nil))
+	if rec.Code != 400 {
+		t.Errorf("too many buckets: have status %d", rec.Code)
+	}
+}
@@ -0,0 +1,38 @@
+package http
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/service/stuck"
+)
+
+// StuckAnalyzer finds stuck enrollments.
+type StuckAnalyzer interface {
+	Analyze(ctx context.Context) ([]*stuck.Device, error)
+}
+
+// StuckHandlerFunc returns the enrollments that have pending commands
+// but have not checked-in within the stuck threshold as JSON.
+func StuckHandlerFunc(analyzer StuckAnalyzer, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		output := struct {
+			Stuck []*stuck.Device `json:"stuck"`
+			Error string          `json:"error,omitempty"`
+		}{Stuck: []*stuck.Device{}}
+		devices, err := analyzer.Analyze(r.Context())
+		if err != nil {
+			logger.Info("msg", "analyzing stuck enrollments", "err", err)
+			output.Error = err.Error()
+			w.WriteHeader(http.StatusInternalServerError)
+		} else if devices != nil {
+			output.Stuck = devices
+		}
+		writeJSON(w, &output, logger)
+	}
+}
@@ -0,0 +1,23 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// TimeoutMiddleware sets a deadline of timeout on the context of
+// requests to next. Device request handlers (and the services and
+// storage they call) derive their contexts from the request context so
+// this bounds the time a slow storage backend can hold a device
+// connection open. A timeout of zero or less sets no deadline.
+func TimeoutMiddleware(next http.Handler, timeout time.Duration) http.HandlerFunc {
+	if timeout <= 0 {
+		return next.ServeHTTP
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
@@ -0,0 +1,79 @@
+package http
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/jessepeterson/nanomdm/cmdplist"
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/push"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// UnlockTokenEscrow retrieves escrowed device Unlock Tokens.
+type UnlockTokenEscrow interface {
+	// Retrieve returns a nil token if none is escrowed for id.
+	Retrieve(ctx context.Context, id string) ([]byte, error)
+}
+
+// ClearPasscodeHandlerFunc enqueues (and pushes) ClearPasscode commands
+// with the escrowed Unlock Tokens of enrollments. Each enrollment gets
+// its own command as the Unlock Token is device-specific. Enrollments
+// without an escrowed Unlock Token are skipped. All requests are logged
+// for auditing.
+//
+// Note the whole URL path is used as the (comma-separated) enrollment
+// identifiers. This probably necessitates stripping the URL prefix
+// before using.
+func ClearPasscodeHandlerFunc(escrow UnlockTokenEscrow, enqueuer storage.CommandEnqueuer, pusher push.Pusher, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		addr, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			addr = r.RemoteAddr
+		}
+		var ids []string
+		if r.URL.Path != "" {
+			ids = strings.Split(r.URL.Path, ",")
+		}
+		output := &struct {
+			Status       enrolledAPIResults `json:"status,omitempty"`
+			CommandUUIDs map[string]string  `json:"command_uuids,omitempty"`
+			CommandError string             `json:"command_error,omitempty"`
+		}{Status: make(enrolledAPIResults), CommandUUIDs: make(map[string]string)}
+		if len(ids) < 1 {
+			output.CommandError = "no enrollment IDs"
+			logger.Info("msg", "clear passcode", "err", output.CommandError, "addr", addr)
+			writeJSON(w, output, logger)
+			return
+		}
+		for _, id := range ids {
+			logs := []interface{}{"msg", "clear passcode", "id", id, "addr", addr}
+			token, err := escrow.Retrieve(r.Context(), id)
+			if err == nil && token == nil {
+				output.Status[id] = &enrolledAPIResult{CommandError: "no unlock token escrowed"}
+				logger.Info(append(logs, "err", "no unlock token escrowed")...)
+				continue
+			}
+			if err != nil {
+				output.Status[id] = &enrolledAPIResult{CommandError: err.Error()}
+				logger.Info(append(logs, "err", err)...)
+				continue
+			}
+			cmd := cmdplist.New("ClearPasscode").Set("UnlockToken", token)
+			output.CommandUUIDs[id] = cmd.CommandUUID
+			logs = append(logs, "command_uuid", cmd.CommandUUID)
+			if err = enqueueAndPush(r.Context(), enqueuer, pusher, []string{id}, cmd, output.Status); err != nil {
+				output.Status[id] = &enrolledAPIResult{CommandError: err.Error()}
+				logs = append(logs, "err", err)
+			}
+			logger.Info(logs...)
+		}
+		writeJSON(w, output, logger)
+	}
+}
@@ -0,0 +1,64 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/storage/memqueue"
+)
+
+// memUnlockTokens is an in-memory UnlockTokenEscrow.
+type memUnlockTokens map[string][]byte
+
+func (e memUnlockTokens) Retrieve(_ context.Context, id string) ([]byte, error) {
+	if id == "broken" {
+		return nil, errors.New("opening token")
+	}
+	return e[id], nil
+}
+
+func TestClearPasscode(t *testing.T) {
+	queue := memqueue.New()
+	pusher := new(recordingPusher)
+	handler := ClearPasscodeHandlerFunc(memUnlockTokens{"A": []byte("token")}, queue, pusher, log.NopLogger)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.URL.Path = "A"
+	handler(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: have %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/", nil)
+	r.URL.Path = "A,B,broken"
+	handler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST: have %d, want %d", w.Code, http.StatusOK)
+	}
+	var output struct {
+		Status       enrolledAPIResults `json:"status"`
+		CommandUUIDs map[string]string  `json:"command_uuids"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&output); err != nil {
+		t.Fatal(err)
+	}
+	if len(output.CommandUUIDs) != 1 || output.CommandUUIDs["A"] == "" {
+		t.Errorf("command UUIDs: have %v, want only A", output.CommandUUIDs)
+	}
+	if res := output.Status["B"]; res == nil || res.CommandError != "no unlock token escrowed" {
+		t.Errorf("B: have %+v, want no unlock token error", res)
+	}
+	if res := output.Status["broken"]; res == nil || res.CommandError == "" {
+		t.Errorf("broken: have %+v, want error", res)
+	}
+	if len(*pusher) != 1 || (*pusher)[0] != "A" {
+		t.Errorf("pushed: have %v, want [A]", *pusher)
+	}
+}
@@ -0,0 +1,95 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// WorkflowRunner runs workflows (command sequences) for enrollments.
+type WorkflowRunner interface {
+	Names() []string
+	List(ctx context.Context, ids []string) ([]*storage.WorkflowRun, error)
+	// Start returns command UUIDs and errors by enrollment ID.
+	Start(ctx context.Context, name string, ids []string) (map[string]string, map[string]error, error)
+}
+
+// WorkflowsHandlerFunc starts and reports on workflow runs. The URL
+// path is (comma-separated) enrollment IDs for a GET which returns the
+// configured workflows and the runs of the enrollments (or all runs).
+// A POST starts the runs of the workflow named by the URL path
+// followed by a slash and comma-separated enrollment IDs.
+//
+// Note the whole URL path is used as the workflow name and
+// identifiers. This probably necessitates stripping the URL prefix
+// before using.
+func WorkflowsHandlerFunc(runner WorkflowRunner, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			var ids []string
+			if r.URL.Path != "" {
+				ids = strings.Split(r.URL.Path, ",")
+			}
+			output := &struct {
+				Workflows []string               `json:"workflows"`
+				Runs      []*storage.WorkflowRun `json:"runs"`
+				Error     string                 `json:"error,omitempty"`
+			}{Workflows: runner.Names()}
+			runs, err := runner.List(r.Context(), ids)
+			if err != nil {
+				logger.Info("msg", "retrieving workflow runs", "err", err)
+				output.Error = err.Error()
+			}
+			output.Runs = runs
+			if output.Runs == nil {
+				output.Runs = []*storage.WorkflowRun{}
+			}
+			writeJSON(w, output, logger)
+		case http.MethodPost:
+			addr, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				addr = r.RemoteAddr
+			}
+			output := &struct {
+				CommandUUIDs map[string]string `json:"command_uuids,omitempty"`
+				Errors       map[string]string `json:"errors,omitempty"`
+				Error        string            `json:"error,omitempty"`
+			}{}
+			name, idList := r.URL.Path, ""
+			if i := strings.Index(name, "/"); i >= 0 {
+				name, idList = name[:i], name[i+1:]
+			}
+			var idErrs map[string]error
+			var ids []string
+			if name == "" || idList == "" {
+				err = errors.New("no workflow name or enrollment IDs")
+			} else {
+				ids = strings.Split(idList, ",")
+				output.CommandUUIDs, idErrs, err = runner.Start(r.Context(), name, ids)
+			}
+			if len(idErrs) > 0 {
+				output.Errors = make(map[string]string)
+				for id, idErr := range idErrs {
+					output.Errors[id] = idErr.Error()
+				}
+			}
+			logs := []interface{}{"msg", "start workflow", "workflow", name, "id_count", len(ids), "addr", addr}
+			if err != nil {
+				logs = append(logs, "err", err)
+				output.Error = err.Error()
+			} else {
+				logs = append(logs, "sent", len(output.CommandUUIDs))
+			}
+			logger.Info(logs...)
+			writeJSON(w, output, logger)
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package mdm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var errMalformedBinaryPlist = errors.New("malformed binary plist")
+
+const (
+	// bplistMaxDepth limits the nesting of binary plist containers.
+	bplistMaxDepth = 256
+
+	// bplistMaxExpanded limits the decoded size of a binary plist.
+	// Binary plists may reference the same object many times so a small
+	// binary plist can decode to a very large value.
+	bplistMaxExpanded = 256 << 20
+
+	// bplistObjectSize approximates the decoded size of an object
+	// (excluding its content).
+	bplistObjectSize = 64
+)
+
+// checkBinaryPlist checks that the structure of the binary plist raw is
+// safe to decode. The plist package trusts the offsets, counts, and
+// object references of binary plists: out of range values panic or
+// allocate without bound and cyclic references recurse forever.
+func checkBinaryPlist(raw []byte) error {
+	if len(raw) < 8+32 {
+		return fmt.Errorf("%w: too short", errMalformedBinaryPlist)
+	}
+	trailer := raw[len(raw)-32:]
+	offsetSize, refSize := int(trailer[6]), int(trailer[7])
+	numObjects := binary.BigEndian.Uint64(trailer[8:])
+	root := binary.BigEndian.Uint64(trailer[16:])
+	tableOffset := binary.BigEndian.Uint64(trailer[24:])
+	body := uint64(len(raw) - 32)
+	switch {
+	case offsetSize < 1 || offsetSize > 8 || refSize < 1 || refSize > 8:
+		return fmt.Errorf("%w: invalid int size", errMalformedBinaryPlist)
+	case numObjects < 1 || numObjects > body:
+		return fmt.Errorf("%w: invalid object count", errMalformedBinaryPlist)
+	case root >= numObjects:
+		return fmt.Errorf("%w: invalid root object", errMalformedBinaryPlist)
+	case tableOffset > body || numObjects*uint64(offsetSize) > body-tableOffset:
+		return fmt.Errorf("%w: invalid offset table", errMalformedBinaryPlist)
+	}
+	c := &bplistChecker{
+		raw:        raw[:body],
+		offsets:    raw[tableOffset:],
+		offsetSize: offsetSize,
+		refSize:    refSize,
+		numObjects: numObjects,
+		expanded:   make(map[uint64]uint64),
+	}
+	_, err := c.check(root, 0)
+	return err
+}
+
+// bplistChecker walks the objects of a binary plist.
+type bplistChecker struct {
+	raw        []byte
+	offsets    []byte
+	offsetSize int
+	refSize    int
+	numObjects uint64
+
+	// expanded is the decoded size of checked objects. Objects being
+	// checked have a size of zero.
+	expanded map[uint64]uint64
+}
+
+// bigEndian decodes the big-endian unsigned integer b.
+func bigEndian(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// readUint reads the big-endian unsigned integer of size bytes at off.
+func (c *bplistChecker) readUint(off uint64, size int) (uint64, bool) {
+	if off > uint64(len(c.raw)) || uint64(size) > uint64(len(c.raw))-off {
+		return 0, false
+	}
+	return bigEndian(c.raw[off : off+uint64(size)]), true
+}
+
+// count reads the count of the object with marker at off. It returns
+// the count and the offset of the object content.
+func (c *bplistChecker) count(marker byte, off uint64) (uint64, uint64, bool) {
+	if marker&0xf != 0xf {
+		return uint64(marker & 0xf), off + 1, true
+	}
+	if off+1 >= uint64(len(c.raw)) {
+		return 0, 0, false
+	}
+	size := 1 << (c.raw[off+1] & 0xf)
+	if size > 8 {
+		return 0, 0, false
+	}
+	n, ok := c.readUint(off+2, size)
+	return n, off + 2 + uint64(size), ok
+}
+
+// check checks object ref and returns its decoded size.
+func (c *bplistChecker) check(ref uint64, depth int) (uint64, error) {
+	if ref >= c.numObjects {
+		return 0, fmt.Errorf("%w: object reference out of range", errMalformedBinaryPlist)
+	}
+	if size, ok := c.expanded[ref]; ok {
+		if size == 0 {
+			return 0, fmt.Errorf("%w: cyclic object reference", errMalformedBinaryPlist)
+		}
+		return size, nil
+	}
+	if depth > bplistMaxDepth {
+		return 0, fmt.Errorf("%w: nested too deeply", errMalformedBinaryPlist)
+	}
+	c.expanded[ref] = 0
+	off := bigEndian(c.offsets[ref*uint64(c.offsetSize):][:c.offsetSize])
+	if off >= uint64(len(c.raw)) {
+		return 0, fmt.Errorf("%w: object offset out of range", errMalformedBinaryPlist)
+	}
+	marker := c.raw[off]
+	size := uint64(bplistObjectSize)
+	switch marker >> 4 {
+	case 0x4, 0x5, 0x6, 0xa, 0xd: // data, strings, arrays, and dictionaries
+		n, content, ok := c.count(marker, off)
+		if !ok {
+			return 0, fmt.Errorf("%w: invalid count", errMalformedBinaryPlist)
+		}
+		var width uint64
+		switch marker >> 4 {
+		case 0x4, 0x5:
+			width = 1
+		case 0x6:
+			width = 2
+		case 0xa:
+			width = uint64(c.refSize)
+		case 0xd:
+			width = 2 * uint64(c.refSize)
+		}
+		if content > uint64(len(c.raw)) || n > (uint64(len(c.raw))-content)/width {
+			return 0, fmt.Errorf("%w: count out of range", errMalformedBinaryPlist)
+		}
+		if marker>>4 < 0xa {
+			size += n * width
+			break
+		}
+		for i := uint64(0); i < n*width/uint64(c.refSize); i++ {
+			child, _ := c.readUint(content+i*uint64(c.refSize), c.refSize)
+			childSize, err := c.check(child, depth+1)
+			if err != nil {
+				return 0, err
+			}
+			if size += childSize; size > bplistMaxExpanded {
+				return 0, fmt.Errorf("%w: decoded size too large", errMalformedBinaryPlist)
+			}
+		}
+	}
+	c.expanded[ref] = size
+	return size, nil
+}
@@ -34,7 +34,13 @@ type TokenUpdate struct {
 	MessageType
 	Push
 	UnlockToken []byte `plist:",omitempty"`
-	Raw         []byte // Original TokenUpdate XML plist
+	// AwaitingConfiguration is true if the device is in Setup Assistant
+	// waiting for a DeviceConfigured command.
+	AwaitingConfiguration bool `plist:",omitempty"`
+	// NotOnConsole is true if the user channel is not the console user
+	// (e.g. a macOS network user).
+	NotOnConsole bool   `plist:",omitempty"`
+	Raw          []byte // Original TokenUpdate XML plist
 }
 
 // CheckOut is a representation of a "CheckOut" check-in message type.
@@ -76,11 +82,27 @@ func (w *checkinUnmarshaller) UnmarshalPlist(f func(interface{}) error) error {
 	if w.message == nil {
 		return fmt.Errorf("%w: %q", ErrUnrecognizedMessageType, onlyType.MessageType)
 	}
-	return f(w.message)
+	if err = f(w.message); err != nil {
+		return err
+	}
+	var e *Enrollment
+	switch m := w.message.(type) {
+	case *Authenticate:
+		e = &m.Enrollment
+	case *TokenUpdate:
+		e = &m.Enrollment
+	case *CheckOut:
+		e = &m.Enrollment
+	}
+	e.Normalize()
+	return e.Validate()
 }
 
 // DecodeCheckin unmarshals rawMessage into a specific check-in struct in message.
 func DecodeCheckin(rawMessage []byte) (message interface{}, err error) {
+	if rawMessage, err = NormalizePlist(rawMessage); err != nil {
+		return
+	}
 	w := &checkinUnmarshaller{raw: rawMessage}
 	err = plist.Unmarshal(rawMessage, w)
 	message = w.message
@@ -1,10 +1,6 @@
 package mdm
 
-import (
-	"errors"
-
-	"github.com/groob/plist"
-)
+import "errors"
 
 var (
 	ErrInvalidCommandResult = errors.New("invalid command result")
@@ -33,14 +29,17 @@ type CommandResults struct {
 // DecodeCheckin unmarshals rawMessage into results
 func DecodeCommandResults(rawResults []byte) (results *CommandResults, err error) {
 	results = new(CommandResults)
-	err = plist.Unmarshal(rawResults, results)
+	raw, err := unmarshalPlist(rawResults, results)
 	if err != nil {
 		return
 	}
-	results.Raw = rawResults
+	results.Raw = raw
 	if results.Status == "" {
 		err = ErrInvalidCommandResult
+		return
 	}
+	results.Enrollment.Normalize()
+	err = results.Enrollment.Validate()
 	return
 }
 
@@ -56,11 +55,11 @@ type Command struct {
 // DecodeCommand unmarshals rawCommand into command
 func DecodeCommand(rawCommand []byte) (command *Command, err error) {
 	command = new(Command)
-	err = plist.Unmarshal(rawCommand, command)
+	raw, err := unmarshalPlist(rawCommand, command)
 	if err != nil {
 		return
 	}
-	command.Raw = rawCommand
+	command.Raw = raw
 	if command.CommandUUID == "" || command.Command.RequestType == "" {
 		err = ErrInvalidCommand
 	}
@@ -0,0 +1,105 @@
+//go:build go1.18
+// +build go1.18
+
+package mdm
+
+import (
+	"io/ioutil"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/groob/plist"
+)
+
+// FuzzNormalizePlist checks that NormalizePlist does not panic (or hang)
+// and that valid normalized plists normalize to themselves.
+func FuzzNormalizePlist(f *testing.F) {
+	addTestdata(f, "Authenticate.1.plist", "Authenticate.1.bplist", "TokenUpdate.1.plist")
+	f.Add([]byte("\xff\xfe<\x00?\x00"))
+	f.Add([]byte(`<?xml version="1.0" encoding="ISO-8859-1"?>`))
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		normalized, err := NormalizePlist(raw)
+		if err != nil || !utf8.Valid(raw) {
+			return
+		}
+		var v interface{}
+		if plist.Unmarshal(normalized, &v) != nil {
+			return
+		}
+		again, err := NormalizePlist(normalized)
+		if err != nil {
+			t.Fatalf("normalizing normalized plist: %v", err)
+		}
+		if string(again) != string(normalized) {
+			t.Errorf("normalizing is not idempotent: %q != %q", again, normalized)
+		}
+	})
+}
+
+// addTestdata adds the testdata files to the seed corpus of f.
+func addTestdata(f *testing.F, names ...string) {
+	for _, name := range names {
+		raw, err := ioutil.ReadFile("testdata/" + name)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(raw)
+	}
+}
+
+// FuzzDecodeCheckin checks that decoding and strictly validating
+// check-ins does not panic and that the raw plist of decoded check-ins
+// decodes again.
+func FuzzDecodeCheckin(f *testing.F) {
+	addTestdata(f, "Authenticate.1.plist", "Authenticate.1.bplist", "Authenticate.2.plist", "TokenUpdate.1.plist", "TokenUpdate.2.plist")
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		ValidateCheckin(raw)
+		m, err := DecodeCheckin(raw)
+		if err != nil {
+			return
+		}
+		var normalized []byte
+		switch m := m.(type) {
+		case *Authenticate:
+			normalized = m.Raw
+		case *TokenUpdate:
+			normalized = m.Raw
+		case *CheckOut:
+			normalized = m.Raw
+		default:
+			t.Fatalf("unexpected check-in type %T", m)
+		}
+		if _, err = DecodeCheckin(normalized); err != nil {
+			t.Fatalf("decoding decoded check-in: %v", err)
+		}
+	})
+}
+
+// FuzzDecodeCommandResults checks that decoding and strictly validating
+// command results does not panic and that the raw plist of decoded
+// command results decodes again.
+func FuzzDecodeCommandResults(f *testing.F) {
+	addTestdata(f, "DeviceInformation.1.plist")
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		ValidateCommandResults(raw)
+		results, err := DecodeCommandResults(raw)
+		if err != nil {
+			return
+		}
+		if _, err = DecodeCommandResults(results.Raw); err != nil {
+			t.Fatalf("decoding decoded command results: %v", err)
+		}
+		DecodeMachineInfo(raw)
+		DecodeOSUpdateResults(raw)
+		DecodeDeviceLocationResults(raw)
+	})
+}
+
+// FuzzDecodeCommand checks that decoding commands does not panic.
+func FuzzDecodeCommand(f *testing.F) {
+	f.Add([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0"><dict><key>CommandUUID</key><string>a</string><key>Command</key><dict><key>RequestType</key><string>DeviceInformation</string></dict></dict></plist>`))
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		DecodeCommand(raw)
+	})
+}
@@ -0,0 +1,66 @@
+package mdm
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var ErrInvalidIdentifier = errors.New("invalid identifier")
+
+// maxIdentifierLength is the maximum length of any enrollment identifier.
+// This fits within the storage backends' key sizes.
+const maxIdentifierLength = 255
+
+var (
+	// UDIDs are hex strings with optional hyphens. For example the
+	// older 40 hex digit form, the 8-16 form, and the GUID form.
+	udidRe = regexp.MustCompile(`^[0-9A-Fa-f]+(-[0-9A-Fa-f]+)*$`)
+	guidRe = regexp.MustCompile(`^[0-9A-Fa-f]{8}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{12}$`)
+	// User Enrollment IDs are opaque but well-behaved.
+	opaqueIDRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:-]*$`)
+)
+
+// validName checks a user name that may be used as an identifier (such
+// as a Shared iPad's Managed Apple ID). It must not contain control
+// characters or path separators.
+func validName(s string) bool {
+	return !strings.ContainsAny(s, `/\`) && s != "." && s != ".." && strings.IndexFunc(s, unicode.IsControl) == -1
+}
+
+// Normalize trims surrounding whitespace from the identifiers in e.
+func (e *Enrollment) Normalize() {
+	for _, s := range []*string{&e.UDID, &e.UserID, &e.UserShortName, &e.UserLongName, &e.EnrollmentID, &e.EnrollmentUserID} {
+		*s = strings.TrimSpace(*s)
+	}
+}
+
+// Validate checks the format of the (non-empty) identifiers in e.
+// Errors wrap ErrInvalidIdentifier.
+func (e *Enrollment) Validate() error {
+	for _, f := range []struct {
+		name  string
+		value string
+		valid func(string) bool
+	}{
+		{"UDID", e.UDID, udidRe.MatchString},
+		{"UserID", e.UserID, guidRe.MatchString},
+		{"UserShortName", e.UserShortName, validName},
+		{"UserLongName", e.UserLongName, func(s string) bool { return strings.IndexFunc(s, unicode.IsControl) == -1 }},
+		{"EnrollmentID", e.EnrollmentID, opaqueIDRe.MatchString},
+		{"EnrollmentUserID", e.EnrollmentUserID, opaqueIDRe.MatchString},
+	} {
+		if f.value == "" {
+			continue
+		}
+		if len(f.value) > maxIdentifierLength {
+			return fmt.Errorf("%w: %s: too long (%d characters)", ErrInvalidIdentifier, f.name, len(f.value))
+		}
+		if !f.valid(f.value) {
+			return fmt.Errorf("%w: %s: %q", ErrInvalidIdentifier, f.name, f.value)
+		}
+	}
+	return nil
+}
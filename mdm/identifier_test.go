@@ -0,0 +1,49 @@
+package mdm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEnrollmentValidate(t *testing.T) {
+	for _, test := range []struct {
+		e     Enrollment
+		valid bool
+	}{
+		{Enrollment{UDID: "66ADE930-5FDF-5EC4-8429-15640684C489"}, true},
+		{Enrollment{UDID: "663b07bb783e9ade1dae4fbb92ea12afc0ce5b69"}, true},
+		{Enrollment{UDID: "00008030-001A2B3C4D5E6F70"}, true},
+		{Enrollment{UDID: "../../etc"}, false},
+		{Enrollment{UDID: "66ADE930-", UserID: ""}, false},
+		{Enrollment{UDID: strings.Repeat("A", 256)}, false},
+		{Enrollment{UDID: "663b07bb", UserID: "not-a-guid"}, false},
+		{Enrollment{UDID: "663b07bb", UserID: SharediPadUserID, UserShortName: "user@example.com"}, true},
+		{Enrollment{UDID: "663b07bb", UserID: SharediPadUserID, UserShortName: "a/b"}, false},
+		{Enrollment{EnrollmentID: "3A1B.ab_c-d:e"}, true},
+		{Enrollment{EnrollmentID: "has space"}, false},
+		{Enrollment{EnrollmentID: "x", EnrollmentUserID: "line\nbreak"}, false},
+	} {
+		err := test.e.Validate()
+		if test.valid && err != nil {
+			t.Errorf("%+v: unexpected error: %v", test.e, err)
+		} else if !test.valid && !errors.Is(err, ErrInvalidIdentifier) {
+			t.Errorf("%+v: expected ErrInvalidIdentifier, have %v", test.e, err)
+		}
+	}
+}
+
+func TestDecodeCheckinNormalize(t *testing.T) {
+	msg, err := DecodeCheckin([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0"><dict>
+	<key>MessageType</key><string>CheckOut</string>
+	<key>UDID</key><string> 663b07bb783e9ade1dae4fbb92ea12afc0ce5b69
+	</string>
+</dict></plist>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := msg.(*CheckOut).UDID, "663b07bb783e9ade1dae4fbb92ea12afc0ce5b69"; have != want {
+		t.Errorf("have %q, want %q", have, want)
+	}
+}
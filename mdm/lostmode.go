@@ -0,0 +1,39 @@
+package mdm
+
+import "time"
+
+// DeviceLocationResults are the results of the DeviceLocation command.
+// See https://developer.apple.com/documentation/devicemanagement/devicelocationresponse
+type DeviceLocationResults struct {
+	CommandResults
+	Latitude           float64
+	Longitude          float64
+	HorizontalAccuracy float64
+	VerticalAccuracy   float64
+	Altitude           float64
+	Speed              float64
+	Course             float64
+	// Timestamp is the ISO 8601 time of the location.
+	Timestamp string
+}
+
+// Time parses the timestamp of the location. It returns the zero time
+// if the timestamp is missing or invalid.
+func (r *DeviceLocationResults) Time() time.Time {
+	t, _ := time.Parse(time.RFC3339, r.Timestamp)
+	return t
+}
+
+// DecodeDeviceLocationResults unmarshals rawResults into DeviceLocation results.
+func DecodeDeviceLocationResults(rawResults []byte) (results *DeviceLocationResults, err error) {
+	results = new(DeviceLocationResults)
+	raw, err := unmarshalPlist(rawResults, results)
+	if err != nil {
+		return
+	}
+	results.Raw = raw
+	if results.Status == "" {
+		err = ErrInvalidCommandResult
+	}
+	return
+}
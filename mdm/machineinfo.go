@@ -0,0 +1,28 @@
+package mdm
+
+// MachineInfo is the device information a device sends when requesting
+// an enrollment profile (in the signed body of Automated Device
+// Enrollment requests or the x-apple-aspen-deviceinfo header of
+// web-based enrollments).
+// See https://developer.apple.com/documentation/devicemanagement/machineinfo
+type MachineInfo struct {
+	UDID                       string `plist:"UDID"`
+	SerialNumber               string `plist:"SERIAL"`
+	Product                    string `plist:"PRODUCT"`
+	Version                    string `plist:"VERSION"`
+	OSVersion                  string `plist:"OS_VERSION,omitempty"`
+	Language                   string `plist:"LANGUAGE,omitempty"`
+	IMEI                       string `plist:"IMEI,omitempty"`
+	MEID                       string `plist:"MEID,omitempty"`
+	SoftwareUpdateDeviceID     string `plist:"SOFTWARE_UPDATE_DEVICE_ID,omitempty"`
+	SupplementalBuildVersion   string `plist:"SUPPLEMENTAL_BUILD_VERSION,omitempty"`
+	SupplementalOSVersionExtra string `plist:"SUPPLEMENTAL_OS_VERSION_EXTRA,omitempty"`
+}
+
+// DecodeMachineInfo decodes the MachineInfo plist (the content of the
+// signed data).
+func DecodeMachineInfo(rawInfo []byte) (info *MachineInfo, err error) {
+	info = new(MachineInfo)
+	_, err = unmarshalPlist(rawInfo, info)
+	return
+}
@@ -0,0 +1,66 @@
+package mdm
+
+// AvailableOSUpdate is an OS update available to a device.
+// See https://developer.apple.com/documentation/devicemanagement/availableosupdatesresponse/availableosupdate
+type AvailableOSUpdate struct {
+	ProductKey               string
+	HumanReadableName        string
+	ProductName              string `plist:",omitempty"`
+	Version                  string
+	Build                    string `plist:",omitempty"`
+	DownloadSize             int64  `plist:",omitempty"`
+	InstallSize              int64  `plist:",omitempty"`
+	IsCritical               bool   `plist:",omitempty"`
+	IsConfigDataUpdate       bool   `plist:",omitempty"`
+	IsFirmwareUpdate         bool   `plist:",omitempty"`
+	IsMajorOSUpdate          bool   `plist:",omitempty"`
+	RestartRequired          bool   `plist:",omitempty"`
+	AllowsInstallLater       bool   `plist:",omitempty"`
+	AppIdentifiersToClose    []string
+	SupplementalBuildVersion string `plist:",omitempty"`
+}
+
+// OSUpdateStatusItem is the status of an OS update on a device.
+// See https://developer.apple.com/documentation/devicemanagement/osupdatestatusresponse/osupdatestatus
+type OSUpdateStatusItem struct {
+	ProductKey              string
+	IsDownloaded            bool
+	DownloadPercentComplete float64
+	// Status is one of Idle, Downloading, or Installing.
+	Status string
+}
+
+// OSUpdateResult is the result of scheduling an OS update.
+// See https://developer.apple.com/documentation/devicemanagement/scheduleosupdateresponse/updateresults
+type OSUpdateResult struct {
+	ProductKey    string
+	InstallAction string
+	// Status is the update status (e.g. Idle, Downloading,
+	// DownloadFailed, Installing, InstallFailed, etc.)
+	Status     string
+	ErrorChain []ErrorChain `plist:",omitempty"`
+}
+
+// OSUpdateResults are the results of the AvailableOSUpdates,
+// OSUpdateStatus, and ScheduleOSUpdate commands. Only the field of the
+// respective command will be populated.
+type OSUpdateResults struct {
+	CommandResults
+	AvailableOSUpdates []AvailableOSUpdate
+	OSUpdateStatus     []OSUpdateStatusItem
+	UpdateResults      []OSUpdateResult
+}
+
+// DecodeOSUpdateResults unmarshals rawResults into OS update results.
+func DecodeOSUpdateResults(rawResults []byte) (results *OSUpdateResults, err error) {
+	results = new(OSUpdateResults)
+	raw, err := unmarshalPlist(rawResults, results)
+	if err != nil {
+		return
+	}
+	results.Raw = raw
+	if results.Status == "" {
+		err = ErrInvalidCommandResult
+	}
+	return
+}
@@ -0,0 +1,119 @@
+package mdm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/groob/plist"
+)
+
+var ErrUnsupportedEncoding = errors.New("unsupported plist encoding")
+
+var (
+	bomUTF8    = []byte{0xef, 0xbb, 0xbf}
+	bomUTF16BE = []byte{0xfe, 0xff}
+	bomUTF16LE = []byte{0xff, 0xfe}
+)
+
+// xmlEncodingDecl matches the encoding of an XML declaration.
+var xmlEncodingDecl = regexp.MustCompile(`^(<\?xml[^>]*?encoding\s*=\s*["'])([A-Za-z0-9._-]+)(["'])`)
+
+// NormalizePlist returns raw as a UTF-8 XML plist. Devices (and other
+// clients) occasionally send valid plists in other forms: binary
+// plists are converted to XML, UTF-16 (with or without a byte order
+// mark) and Latin-1 (or ASCII) XML is converted to UTF-8, and a UTF-8
+// byte order mark and leading whitespace are removed. Other raw plists
+// are returned as-is (even if not a valid plist).
+func NormalizePlist(raw []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(raw, []byte("bplist0")):
+		return binaryToXML(raw)
+	case bytes.HasPrefix(raw, bomUTF16BE):
+		return decodeUTF16(raw[2:], binary.BigEndian)
+	case bytes.HasPrefix(raw, bomUTF16LE):
+		return decodeUTF16(raw[2:], binary.LittleEndian)
+	case bytes.HasPrefix(raw, []byte("\x00<")):
+		// UTF-16 without a BOM starts with "<"
+		return decodeUTF16(raw, binary.BigEndian)
+	case bytes.HasPrefix(raw, []byte("<\x00")):
+		return decodeUTF16(raw, binary.LittleEndian)
+	}
+	trimmed := bytes.TrimLeft(bytes.TrimPrefix(raw, bomUTF8), " \t\r\n")
+	m := xmlEncodingDecl.FindSubmatchIndex(trimmed)
+	if m == nil {
+		return trimmed, nil
+	}
+	switch strings.ToUpper(string(trimmed[m[4]:m[5]])) {
+	case "UTF-8", "UTF8":
+		return trimmed, nil
+	case "US-ASCII", "ASCII", "ISO-8859-1", "ISO8859-1", "LATIN1":
+		// ASCII is a subset of Latin-1 whose code points are those of
+		// the runes it encodes
+		var buf bytes.Buffer
+		buf.Grow(len(trimmed))
+		for _, b := range trimmed {
+			buf.WriteRune(rune(b))
+		}
+		return setUTF8Decl(buf.Bytes()), nil
+	case "UTF-16", "UTF-16BE", "UTF-16LE":
+		// already decoded (the declaration does not match the bytes)
+		return setUTF8Decl(trimmed), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedEncoding, trimmed[m[4]:m[5]])
+	}
+}
+
+// binaryToXML converts the binary plist raw to an XML plist. The plist
+// package does not validate binary plists (see checkBinaryPlist) and
+// panics on some values it cannot encode (e.g. null objects) so a panic
+// is returned as an error instead.
+func binaryToXML(raw []byte) (xml []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			xml, err = nil, fmt.Errorf("%w: %v", errMalformedBinaryPlist, r)
+		}
+	}()
+	if err = checkBinaryPlist(raw); err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err = plist.Unmarshal(raw, &v); err != nil {
+		return nil, fmt.Errorf("binary plist: %w", err)
+	}
+	return plist.MarshalIndent(v, "\t")
+}
+
+// decodeUTF16 converts the UTF-16 raw plist of order to UTF-8.
+func decodeUTF16(raw []byte, order binary.ByteOrder) ([]byte, error) {
+	if len(raw)%2 != 0 {
+		return nil, fmt.Errorf("%w: odd UTF-16 length", ErrUnsupportedEncoding)
+	}
+	units := make([]uint16, len(raw)/2)
+	for i := range units {
+		units[i] = order.Uint16(raw[i*2:])
+	}
+	utf8Raw := []byte(string(utf16.Decode(units)))
+	utf8Raw = bytes.TrimLeft(bytes.TrimPrefix(utf8Raw, bomUTF8), " \t\r\n")
+	return setUTF8Decl(utf8Raw), nil
+}
+
+// setUTF8Decl replaces the encoding of the XML declaration of raw (if
+// any) with UTF-8.
+func setUTF8Decl(raw []byte) []byte {
+	return xmlEncodingDecl.ReplaceAll(raw, []byte("${1}UTF-8${3}"))
+}
+
+// unmarshalPlist normalizes raw (see NormalizePlist) and unmarshals it
+// into v. It returns the normalized raw plist.
+func unmarshalPlist(raw []byte, v interface{}) ([]byte, error) {
+	raw, err := NormalizePlist(raw)
+	if err != nil {
+		return nil, err
+	}
+	return raw, plist.Unmarshal(raw, v)
+}
@@ -0,0 +1,83 @@
+package mdm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// encodeUTF16 encodes s as UTF-16 of order with an optional BOM.
+func encodeUTF16(s string, order binary.ByteOrder, bom bool) []byte {
+	var buf bytes.Buffer
+	units := utf16.Encode([]rune(s))
+	if bom {
+		units = append([]uint16{0xfeff}, units...)
+	}
+	for _, u := range units {
+		b := make([]byte, 2)
+		order.PutUint16(b, u)
+		buf.Write(b)
+	}
+	return buf.Bytes()
+}
+
+func TestNormalizePlist(t *testing.T) {
+	xml, err := ioutil.ReadFile("testdata/Authenticate.1.plist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin, err := ioutil.ReadFile("testdata/Authenticate.1.bplist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name string
+		raw  []byte
+	}{
+		{"xml", xml},
+		{"binary", bin},
+		{"utf-8 bom", append([]byte("\xef\xbb\xbf"), xml...)},
+		{"whitespace", append([]byte("\r\n "), xml...)},
+		{"utf-16le bom", encodeUTF16(strings.Replace(string(xml), "UTF-8", "UTF-16", 1), binary.LittleEndian, true)},
+		{"utf-16be bom", encodeUTF16(strings.Replace(string(xml), "UTF-8", "UTF-16", 1), binary.BigEndian, true)},
+		{"utf-16le", encodeUTF16(string(xml), binary.LittleEndian, false)},
+		{"latin-1", []byte(strings.Replace(string(xml), "UTF-8", "ISO-8859-1", 1))},
+		{"ascii", []byte(strings.Replace(string(xml), "UTF-8", "us-ascii", 1))},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := DecodeCheckin(test.raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a, ok := m.(*Authenticate)
+			if !ok {
+				t.Fatalf("incorrect type: %T", m)
+			}
+			if have, want := a.UDID, "663b07bb783e9ade1dae4fbb92ea12afc0ce5b69"; have != want {
+				t.Errorf("UDID: have %q, want %q", have, want)
+			}
+			if !bytes.HasPrefix(a.Raw, []byte("<?xml")) || !bytes.Contains(a.Raw[:40], []byte("UTF-8")) {
+				t.Errorf("raw is not a UTF-8 XML plist: %.40q", a.Raw)
+			}
+		})
+	}
+
+	if _, err = DecodeCheckin([]byte(strings.Replace(string(xml), "UTF-8", "Shift_JIS", 1))); !errors.Is(err, ErrUnsupportedEncoding) {
+		t.Errorf("have %v, want %v", err, ErrUnsupportedEncoding)
+	}
+}
+
+func TestNormalizeLatin1(t *testing.T) {
+	raw := []byte("<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><plist><string>caf\xe9</string></plist>")
+	have, err := NormalizePlist(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `<?xml version="1.0" encoding="UTF-8"?><plist><string>café</string></plist>`; string(have) != want {
+		t.Errorf("have %q, want %q", have, want)
+	}
+}
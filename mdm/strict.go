@@ -0,0 +1,264 @@
+package mdm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/groob/plist"
+)
+
+var ErrStrictValidation = errors.New("strict validation failed")
+
+// FieldError is a problem with a key of a plist.
+type FieldError struct {
+	Key     string // e.g. "ErrorChain[0].ErrorCode"
+	Problem string // e.g. "missing" or "expected integer, got string"
+}
+
+// ValidationError lists the problems strict validation found in a
+// check-in message or command result. It wraps ErrStrictValidation.
+type ValidationError struct {
+	// Message is the check-in MessageType or "CommandResults".
+	Message string
+	Errors  []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, len(e.Errors))
+	for i, f := range e.Errors {
+		problems[i] = f.Key + ": " + f.Problem
+	}
+	return fmt.Sprintf("%s: %s: %s", ErrStrictValidation, e.Message, strings.Join(problems, "; "))
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrStrictValidation
+}
+
+// plist value kinds of strict validation.
+const (
+	kindString  = "string"
+	kindData    = "data"
+	kindBool    = "boolean"
+	kindInteger = "integer"
+	kindArray   = "array"
+	kindDict    = "dictionary"
+)
+
+// kindOf returns the plist kind of a value unmarshaled into an
+// interface{}.
+func kindOf(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return kindString
+	case []byte:
+		return kindData
+	case bool:
+		return kindBool
+	case uint64, int64:
+		return kindInteger
+	case float32, float64:
+		return "real"
+	case time.Time:
+		return "date"
+	case []interface{}:
+		return kindArray
+	case map[string]interface{}:
+		return kindDict
+	default:
+		return "unknown"
+	}
+}
+
+// keySpec is the expected kind of a key and whether it is required.
+type keySpec struct {
+	key      string
+	kind     string
+	required bool
+}
+
+// enrollmentKeys are the enrollment identifiers of check-ins and
+// command results.
+var enrollmentKeys = []keySpec{
+	{"UDID", kindString, false},
+	{"UserID", kindString, false},
+	{"UserShortName", kindString, false},
+	{"UserLongName", kindString, false},
+	{"EnrollmentID", kindString, false},
+	{"EnrollmentUserID", kindString, false},
+}
+
+// checkinKeys are the keys of check-in messages by MessageType.
+// See https://developer.apple.com/documentation/devicemanagement/check-in
+var checkinKeys = map[string][]keySpec{
+	"Authenticate": {
+		{"Topic", kindString, true},
+		{"BuildVersion", kindString, false},
+		{"DeviceName", kindString, false},
+		{"IMEI", kindString, false},
+		{"MEID", kindString, false},
+		{"Model", kindString, false},
+		{"ModelName", kindString, false},
+		{"OSVersion", kindString, false},
+		{"ProductName", kindString, false},
+		{"SerialNumber", kindString, false},
+	},
+	"TokenUpdate": {
+		{"Topic", kindString, true},
+		{"PushMagic", kindString, true},
+		{"Token", kindData, true},
+		{"UnlockToken", kindData, false},
+		{"AwaitingConfiguration", kindBool, false},
+		{"NotOnConsole", kindBool, false},
+	},
+	"CheckOut": {
+		{"Topic", kindString, false},
+	},
+}
+
+// commandResultsKeys are the keys of command results.
+var commandResultsKeys = []keySpec{
+	{"Status", kindString, true},
+	{"CommandUUID", kindString, false},
+	{"ErrorChain", kindArray, false},
+	{"RequestType", kindString, false},
+}
+
+// errorChainKeys are the keys of ErrorChain items.
+var errorChainKeys = []keySpec{
+	{"ErrorCode", kindInteger, true},
+	{"ErrorDomain", kindString, true},
+	{"LocalizedDescription", kindString, false},
+	{"USEnglishDescription", kindString, false},
+}
+
+// commandStatuses are the valid Status values of command results.
+var commandStatuses = map[string]bool{
+	"Acknowledged":       true,
+	"Error":              true,
+	"CommandFormatError": true,
+	"Idle":               true,
+	"NotNow":             true,
+}
+
+// validator accumulates the problems of a plist dictionary.
+type validator struct {
+	errs []FieldError
+}
+
+func (v *validator) add(key, format string, args ...interface{}) {
+	v.errs = append(v.errs, FieldError{Key: key, Problem: fmt.Sprintf(format, args...)})
+}
+
+// keys checks the keys of dict (at path prefix) against specs.
+func (v *validator) keys(prefix string, dict map[string]interface{}, specs []keySpec) {
+	for _, spec := range specs {
+		value, ok := dict[spec.key]
+		if !ok {
+			if spec.required {
+				v.add(prefix+spec.key, "missing")
+			}
+			continue
+		}
+		if kind := kindOf(value); kind != spec.kind {
+			v.add(prefix+spec.key, "expected %s, got %s", spec.kind, kind)
+		}
+	}
+}
+
+// enrollment checks the enrollment identifiers of dict.
+func (v *validator) enrollment(dict map[string]interface{}) {
+	v.keys("", dict, enrollmentKeys)
+	_, udid := dict["UDID"]
+	_, enrollmentID := dict["EnrollmentID"]
+	if !udid && !enrollmentID {
+		v.add("UDID", "missing (or EnrollmentID)")
+	}
+}
+
+func (v *validator) err(message string) error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Message: message, Errors: v.errs}
+}
+
+// unmarshalDict unmarshals the raw plist dictionary.
+func unmarshalDict(raw []byte, message string) (map[string]interface{}, error) {
+	raw, err := NormalizePlist(raw)
+	if err != nil {
+		return nil, err
+	}
+	var dict map[string]interface{}
+	if err = plist.Unmarshal(raw, &dict); err != nil {
+		return nil, &ValidationError{
+			Message: message,
+			Errors:  []FieldError{{Key: "(root)", Problem: "not a dictionary: " + err.Error()}},
+		}
+	}
+	return dict, nil
+}
+
+// ValidateCheckin strictly validates the raw check-in message: its
+// MessageType must be known, its required keys present, and all known
+// keys of the expected types. Unknown keys are allowed. Problems are
+// reported in a *ValidationError.
+func ValidateCheckin(raw []byte) error {
+	dict, err := unmarshalDict(raw, "check-in")
+	if err != nil {
+		return err
+	}
+	v := new(validator)
+	messageType, ok := dict["MessageType"].(string)
+	specs, known := checkinKeys[messageType]
+	switch {
+	case !ok:
+		v.keys("", dict, []keySpec{{"MessageType", kindString, true}})
+		return v.err("check-in")
+	case !known:
+		v.add("MessageType", "unrecognized: %q", messageType)
+		return v.err("check-in")
+	}
+	v.enrollment(dict)
+	v.keys("", dict, specs)
+	if token, ok := dict["Token"].([]byte); ok && len(token) == 0 {
+		v.add("Token", "empty")
+	}
+	return v.err(messageType)
+}
+
+// ValidateCommandResults strictly validates the raw command results:
+// their Status must be valid, CommandUUID present (except when Idle),
+// ErrorChain items well-formed, and all known keys of the expected
+// types. Unknown keys (such as command-specific results) are allowed.
+// Problems are reported in a *ValidationError.
+func ValidateCommandResults(raw []byte) error {
+	const message = "CommandResults"
+	dict, err := unmarshalDict(raw, message)
+	if err != nil {
+		return err
+	}
+	v := new(validator)
+	v.enrollment(dict)
+	v.keys("", dict, commandResultsKeys)
+	status, ok := dict["Status"].(string)
+	if ok && !commandStatuses[status] {
+		v.add("Status", "unrecognized: %q", status)
+	}
+	if _, ok := dict["CommandUUID"]; !ok && status != "Idle" {
+		v.add("CommandUUID", "missing")
+	}
+	chain, _ := dict["ErrorChain"].([]interface{})
+	for i, item := range chain {
+		prefix := fmt.Sprintf("ErrorChain[%d]", i)
+		itemDict, ok := item.(map[string]interface{})
+		if !ok {
+			v.add(prefix, "expected %s, got %s", kindDict, kindOf(item))
+			continue
+		}
+		v.keys(prefix+".", itemDict, errorChainKeys)
+	}
+	return v.err(message)
+}
@@ -0,0 +1,144 @@
+package mdm
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestValidateCheckinTestdata(t *testing.T) {
+	for _, name := range []string{
+		"Authenticate.1.plist",
+		"Authenticate.1.bplist",
+		"Authenticate.2.plist",
+		"TokenUpdate.1.plist",
+		"TokenUpdate.2.plist",
+	} {
+		raw, err := ioutil.ReadFile("testdata/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = ValidateCheckin(raw); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	raw, err := ioutil.ReadFile("testdata/DeviceInformation.1.plist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ValidateCommandResults(raw); err != nil {
+		t.Errorf("DeviceInformation.1.plist: %v", err)
+	}
+}
+
+const strictPlistFormat = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+%s</dict>
+</plist>
+`
+
+func strictPlist(body string) []byte {
+	return []byte(fmt.Sprintf(strictPlistFormat, body))
+}
+
+func TestValidate(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		validate func([]byte) error
+		body     string
+		want     []FieldError
+	}{
+		{
+			"valid token update",
+			ValidateCheckin,
+			`<key>MessageType</key><string>TokenUpdate</string>
+<key>UDID</key><string>A</string>
+<key>Topic</key><string>com.apple.mgmt.External.x</string>
+<key>PushMagic</key><string>magic</string>
+<key>Token</key><data>AQID</data>
+<key>Unknown</key><integer>1</integer>
+`,
+			nil,
+		},
+		{
+			"token update problems",
+			ValidateCheckin,
+			`<key>MessageType</key><string>TokenUpdate</string>
+<key>Topic</key><integer>1</integer>
+<key>Token</key><data></data>
+<key>AwaitingConfiguration</key><string>true</string>
+`,
+			[]FieldError{
+				{"UDID", "missing (or EnrollmentID)"},
+				{"Topic", "expected string, got integer"},
+				{"PushMagic", "missing"},
+				{"AwaitingConfiguration", "expected boolean, got string"},
+				{"Token", "empty"},
+			},
+		},
+		{
+			"no message type",
+			ValidateCheckin,
+			`<key>UDID</key><string>A</string>
+`,
+			[]FieldError{{"MessageType", "missing"}},
+		},
+		{
+			"unknown message type",
+			ValidateCheckin,
+			`<key>MessageType</key><string>DeclarativeManagement</string>
+`,
+			[]FieldError{{"MessageType", `unrecognized: "DeclarativeManagement"`}},
+		},
+		{
+			"idle",
+			ValidateCommandResults,
+			`<key>Status</key><string>Idle</string>
+<key>EnrollmentID</key><string>B</string>
+`,
+			nil,
+		},
+		{
+			"command results problems",
+			ValidateCommandResults,
+			`<key>Status</key><string>Done</string>
+<key>UDID</key><string>A</string>
+<key>ErrorChain</key><array>
+	<dict><key>ErrorCode</key><string>12</string></dict>
+	<string>oops</string>
+</array>
+`,
+			[]FieldError{
+				{"Status", `unrecognized: "Done"`},
+				{"CommandUUID", "missing"},
+				{"ErrorChain[0].ErrorCode", "expected integer, got string"},
+				{"ErrorChain[0].ErrorDomain", "missing"},
+				{"ErrorChain[1]", "expected dictionary, got string"},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.validate(strictPlist(test.body))
+			if test.want == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrStrictValidation) {
+				t.Fatalf("have %v, want %v", err, ErrStrictValidation)
+			}
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("not a *ValidationError: %v", err)
+			}
+			if !reflect.DeepEqual(validationErr.Errors, test.want) {
+				t.Errorf("have %v, want %v", validationErr.Errors, test.want)
+			}
+		})
+	}
+}
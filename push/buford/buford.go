@@ -5,6 +5,8 @@ package buford
 import (
 	"crypto/tls"
 	"errors"
+	"net/http"
+	"net/url"
 	"time"
 
 	bufordpush "github.com/RobotsAndPencils/buford/push"
@@ -16,13 +18,37 @@ import (
 type bufordFactory struct {
 	workers    uint
 	expiration time.Time
+	timeout    time.Duration
+	proxy      func(*http.Request) (*url.URL, error)
+}
+
+type Option func(*bufordFactory)
+
+// WithTimeout sets the timeout of the APNs HTTP requests of providers.
+func WithTimeout(timeout time.Duration) Option {
+	return func(f *bufordFactory) {
+		f.timeout = timeout
+	}
+}
+
+// WithProxy connects to APNs through the HTTP(S) proxy at proxy rather
+// than the proxy of the HTTPS_PROXY (and NO_PROXY) environment variables.
+func WithProxy(proxy *url.URL) Option {
+	return func(f *bufordFactory) {
+		f.proxy = http.ProxyURL(proxy)
+	}
 }
 
 // NewPushProviderFactory creates a new instance that can spawn buford Services
-func NewPushProviderFactory() *bufordFactory {
-	return &bufordFactory{
+func NewPushProviderFactory(opts ...Option) *bufordFactory {
+	f := &bufordFactory{
 		workers: 5,
+		proxy:   http.ProxyFromEnvironment,
 	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
 }
 
 // NewPushProvider generates a new PushProvider given a tls keypair
@@ -31,6 +57,11 @@ func (f *bufordFactory) NewPushProvider(cert *tls.Certificate) (push.PushProvide
 	if err != nil {
 		return nil, err
 	}
+	client.Timeout = f.timeout
+	if transport, ok := client.Transport.(*http.Transport); ok {
+		// the buford transport does not use a proxy by default
+		transport.Proxy = f.proxy
+	}
 	prov := &bufordPushProvider{
 		service: bufordpush.NewService(client, bufordpush.Production),
 		workers: f.workers,
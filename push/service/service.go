@@ -6,8 +6,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/jessepeterson/nanomdm/breaker"
 	"github.com/jessepeterson/nanomdm/log"
 	"github.com/jessepeterson/nanomdm/mdm"
 	"github.com/jessepeterson/nanomdm/push"
@@ -17,6 +21,13 @@ import (
 type provider struct {
 	provider   push.PushProvider
 	staleToken string
+
+	// guarded by the providers mutex
+	createdAt  time.Time
+	lastPushAt time.Time
+	pushes     uint64
+	failures   uint64
+	lastErr    error
 }
 
 // PushService uses PushStore to retrieve Push details for MDM enrollments
@@ -28,17 +39,57 @@ type PushService struct {
 	providersMu     sync.RWMutex
 	logger          log.Logger
 	providerFactory push.PushProviderFactory
+	timeout         time.Duration
+	breaker         *breaker.Breaker
+	deferrer        Deferrer
+}
+
+// Deferrer defers pushes to enrollments (e.g. outside of their delivery
+// windows).
+type Deferrer interface {
+	// Defer returns the IDs among ids whose pushes are deferred.
+	Defer(ctx context.Context, ids []string) map[string]bool
+}
+
+type Option func(*PushService)
+
+// WithTimeout bounds each Push call (retrieving push info and push
+// certificates and sending the pushes to APNs) to timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(s *PushService) {
+		s.timeout = timeout
+	}
+}
+
+// WithBreaker fails pushes fast while b is open. Pushes that do not
+// reach APNs (network errors and timeouts) count as failures.
+func WithBreaker(b *breaker.Breaker) Option {
+	return func(s *PushService) {
+		s.breaker = b
+	}
+}
+
+// WithDeferrer skips pushing enrollments deferred by d. Their responses
+// have the ErrDeferred error.
+func WithDeferrer(d Deferrer) Option {
+	return func(s *PushService) {
+		s.deferrer = d
+	}
 }
 
 // NewPushService creates a new PushService.
-func New(store storage.PushStore, certStore storage.PushCertStore, providerFactory push.PushProviderFactory, logger log.Logger) *PushService {
-	return &PushService{
+func New(store storage.PushStore, certStore storage.PushCertStore, providerFactory push.PushProviderFactory, logger log.Logger, opts ...Option) *PushService {
+	s := &PushService{
 		logger:          logger,
 		store:           store,
 		certStore:       certStore,
 		providers:       make(map[string]*provider),
 		providerFactory: providerFactory,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // getProvider returns a PushProvider if it exists and is not stale.
@@ -72,6 +123,7 @@ func (s *PushService) getProvider(ctx context.Context, topic string) (push.PushP
 	prov = &provider{
 		provider:   newProvider,
 		staleToken: staleToken,
+		createdAt:  time.Now(),
 	}
 	s.providersMu.Lock()
 	s.providers[topic] = prov
@@ -86,6 +138,9 @@ type pushFeedback struct {
 
 var ErrIdNotFound = errors.New("push data missing for id")
 
+// ErrDeferred is the error of the responses of deferred pushes.
+var ErrDeferred = errors.New("push deferred")
+
 // push sends Push notifications to a push provider sychronously.
 // pushInfos are mapped by push topic. The return maps push tokens
 // (not IDs) to responses.
@@ -97,7 +152,87 @@ func (s *PushService) pushSingle(ctx context.Context, pushInfo *mdm.Push) (map[s
 	if err != nil {
 		return nil, err
 	}
-	return prov.Push([]*mdm.Push{pushInfo})
+	return s.providerPush(ctx, pushInfo.Topic, prov, []*mdm.Push{pushInfo})
+}
+
+// providerPush sends pushInfos of topic with prov and records the
+// outcome. Push providers do not take a context so if ctx is done
+// first the push is abandoned (it is still recorded once it finishes).
+func (s *PushService) providerPush(ctx context.Context, topic string, prov push.PushProvider, pushInfos []*mdm.Push) (map[string]*push.Response, error) {
+	if ctx.Done() == nil {
+		responses, err := prov.Push(pushInfos)
+		s.record(topic, responses, err)
+		return responses, err
+	}
+	done := make(chan pushFeedback, 1)
+	go func() {
+		responses, err := prov.Push(pushInfos)
+		s.record(topic, responses, err)
+		done <- pushFeedback{Responses: responses, Err: err}
+	}()
+	select {
+	case feedback := <-done:
+		return feedback.Responses, feedback.Err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("pushing to topic %q: %w", topic, ctx.Err())
+	}
+}
+
+// record records the outcome of pushes to topic for Providers.
+func (s *PushService) record(topic string, responses map[string]*push.Response, err error) {
+	s.providersMu.Lock()
+	defer s.providersMu.Unlock()
+	prov := s.providers[topic]
+	if prov == nil {
+		return
+	}
+	prov.lastPushAt = time.Now()
+	prov.lastErr = err
+	for _, resp := range responses {
+		prov.pushes++
+		if resp != nil && resp.Err != nil {
+			prov.failures++
+			prov.lastErr = resp.Err
+		}
+	}
+}
+
+// ProviderStatus is the state of the push provider (APNs connection)
+// of a topic.
+type ProviderStatus struct {
+	Topic      string     `json:"topic"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastPushAt *time.Time `json:"last_push_at,omitempty"`
+	Pushes     uint64     `json:"pushes"`
+	Failures   uint64     `json:"failures"`
+	// LastError is the error of the last push (if it failed).
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Providers returns the state of the push providers created so far
+// sorted by topic. Providers are created on the first push to a topic.
+func (s *PushService) Providers() []ProviderStatus {
+	s.providersMu.RLock()
+	defer s.providersMu.RUnlock()
+	statuses := make([]ProviderStatus, 0, len(s.providers))
+	for topic, prov := range s.providers {
+		status := ProviderStatus{
+			Topic:     topic,
+			CreatedAt: prov.createdAt,
+			Pushes:    prov.pushes,
+			Failures:  prov.failures,
+		}
+		if !prov.lastPushAt.IsZero() {
+			lastPushAt := prov.lastPushAt
+			status.LastPushAt = &lastPushAt
+		}
+		if prov.lastErr != nil {
+			status.LastError = prov.lastErr.Error()
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Topic < statuses[j].Topic })
+	return statuses
 }
 
 // pushMulti sends pushes to (potentially) multiple push providers
@@ -119,17 +254,20 @@ func (s *PushService) pushMulti(ctx context.Context, pushInfos []*mdm.Push) (map
 			continue
 		}
 		topicPushCt += 1
-		go func(prov push.PushProvider, pushInfos []*mdm.Push, feedback chan<- pushFeedback) {
-			resp, err := prov.Push(pushInfos)
+		go func(topic string, prov push.PushProvider, pushInfos []*mdm.Push, feedback chan<- pushFeedback) {
+			resp, err := s.providerPush(ctx, topic, prov, pushInfos)
 			feedback <- pushFeedback{
 				Responses: resp,
 				Err:       err,
 			}
-		}(prov, pushInfos, feedbackChan)
+		}(topic, prov, pushInfos, feedbackChan)
 	}
 	responses := make(map[string]*push.Response)
 	for i := 0; i < topicPushCt; i++ {
 		feedback := <-feedbackChan
+		if finalErr == nil && feedback.Err != nil {
+			finalErr = feedback.Err
+		}
 		// merge feedback responses into main responses map
 		for token, pushResp := range feedback.Responses {
 			if finalErr == nil && pushResp.Err != nil {
@@ -142,13 +280,51 @@ func (s *PushService) pushMulti(ctx context.Context, pushInfos []*mdm.Push) (map
 	return responses, finalErr
 }
 
+// transportError returns the error of pushes that did not reach APNs:
+// a network error or timeout of err or of one of the responses. Other
+// errors (such as APNs rejecting a push to an invalid token or a
+// missing push certificate) are not.
+func transportError(responses map[string]*push.Response, err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	for _, resp := range responses {
+		if resp != nil && errors.As(resp.Err, &netErr) {
+			return resp.Err
+		}
+	}
+	return nil
+}
+
 // Push sends an APNs push notification to MDM enrollment id
 func (s *PushService) Push(ctx context.Context, ids []string) (map[string]*push.Response, error) {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	idToResponse := make(map[string]*push.Response)
+	if s.deferrer != nil {
+		if deferred := s.deferrer.Defer(ctx, ids); len(deferred) > 0 {
+			pushIDs := make([]string, 0, len(ids))
+			for _, id := range ids {
+				if deferred[id] {
+					idToResponse[id] = &push.Response{Err: ErrDeferred}
+				} else {
+					pushIDs = append(pushIDs, id)
+				}
+			}
+			if len(pushIDs) < 1 {
+				return idToResponse, nil
+			}
+			ids = pushIDs
+		}
+	}
 	idToPushInfo, err := s.store.RetrievePushInfo(ctx, ids)
 	if err != nil {
 		return nil, fmt.Errorf("push storage: %w", err)
 	}
-	idToResponse := make(map[string]*push.Response)
 
 	// create mappings between tokens and enrollment IDs. Push providers
 	// don't know about IDs and instead deal with Tokens as identifiers.
@@ -173,18 +349,23 @@ func (s *PushService) Push(ctx context.Context, ids []string) (map[string]*push.
 
 	// perform actual pushes. we're dealing with maps keyed by token.
 	var tokenToResponse map[string]*push.Response
+	if len(pushInfos) > 0 {
+		if err = s.breaker.Allow(); err != nil {
+			return nil, err
+		}
+	}
 	if len(pushInfos) == 1 {
 		// some environments may heavily utilize individual pushes.
 		// this justifies the special case and optimizes for it.
 		tokenToResponse, err = s.pushSingle(ctx, pushInfos[0])
-		if err != nil {
-			return nil, err
-		}
 	} else if len(pushInfos) > 1 {
 		tokenToResponse, err = s.pushMulti(ctx, pushInfos)
-		if err != nil {
-			return nil, err
-		}
+	}
+	if len(pushInfos) > 0 {
+		s.breaker.Record(transportError(tokenToResponse, err))
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	// re-associate token responses with ids
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/push"
+)
+
+// fakeStore stores the push info of two topics.
+type fakeStore struct{}
+
+func (fakeStore) RetrievePushInfo(_ context.Context, ids []string) (map[string]*mdm.Push, error) {
+	infos := make(map[string]*mdm.Push)
+	for i, id := range ids {
+		infos[id] = &mdm.Push{Topic: id, PushMagic: "magic", Token: []byte{byte(i)}}
+	}
+	return infos, nil
+}
+
+func (fakeStore) IsPushCertStale(context.Context, string, string) (bool, error) {
+	return false, nil
+}
+
+func (fakeStore) RetrievePushCert(context.Context, string) (*tls.Certificate, string, error) {
+	return new(tls.Certificate), "", nil
+}
+
+func (fakeStore) StorePushCert(context.Context, []byte, []byte) error {
+	return nil
+}
+
+// blockingProvider pushes once released.
+type blockingProvider chan struct{}
+
+func (p blockingProvider) NewPushProvider(*tls.Certificate) (push.PushProvider, error) {
+	return p, nil
+}
+
+func (p blockingProvider) Push(pushes []*mdm.Push) (map[string]*push.Response, error) {
+	<-p
+	responses := make(map[string]*push.Response)
+	for _, pushInfo := range pushes {
+		responses[pushInfo.Token.String()] = &push.Response{Id: "apns-id"}
+	}
+	return responses, nil
+}
+
+func TestPushTimeout(t *testing.T) {
+	provider := make(blockingProvider)
+	defer close(provider)
+	s := New(fakeStore{}, fakeStore{}, provider, log.NopLogger, WithTimeout(10*time.Millisecond))
+	for _, ids := range [][]string{{"a"}, {"a", "b"}} {
+		start := time.Now()
+		if _, err := s.Push(context.Background(), ids); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%v: have %v, want %v", ids, err, context.DeadlineExceeded)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%v: push returned after %s", ids, elapsed)
+		}
+	}
+}
+
+func TestPushNoTimeout(t *testing.T) {
+	provider := make(blockingProvider)
+	close(provider)
+	s := New(fakeStore{}, fakeStore{}, provider, log.NopLogger)
+	responses, err := s.Push(context.Background(), []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 2 || responses["a"] == nil || responses["a"].Id != "apns-id" {
+		t.Errorf("unexpected responses: %v", responses)
+	}
+}
+
+// deferrer defers the pushes of its IDs.
+type deferrer map[string]bool
+
+func (d deferrer) Defer(_ context.Context, ids []string) map[string]bool {
+	deferred := make(map[string]bool)
+	for _, id := range ids {
+		if d[id] {
+			deferred[id] = true
+		}
+	}
+	return deferred
+}
+
+func TestPushDeferred(t *testing.T) {
+	provider := make(blockingProvider)
+	close(provider)
+	s := New(fakeStore{}, fakeStore{}, provider, log.NopLogger, WithDeferrer(deferrer{"b": true}))
+	responses, err := s.Push(context.Background(), []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 2 || responses["a"] == nil || responses["a"].Id != "apns-id" {
+		t.Errorf("unexpected responses: %v", responses)
+	}
+	if responses["b"] == nil || !errors.Is(responses["b"].Err, ErrDeferred) {
+		t.Errorf("unexpected response for b: %v", responses["b"])
+	}
+}
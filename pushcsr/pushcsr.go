@@ -0,0 +1,148 @@
+// Package pushcsr creates MDM vendor-signed APNs push certificate
+// requests.
+//
+// A customer creates a private key and certificate signing request
+// (CSR) for their push certificate. An MDM vendor signs the CSR with
+// the private key of their MDM vendor certificate. The resulting
+// request is uploaded to https://identity.apple.com to obtain (or
+// renew) the push certificate.
+package pushcsr
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+
+	"github.com/groob/plist"
+	"github.com/jessepeterson/nanomdm/cryptoutil"
+)
+
+// KeySize is the size of generated push certificate RSA keys.
+const KeySize = 2048
+
+// NewKeyAndCSR generates a new push certificate private key and a
+// DER-encoded CSR with the common name cn and email address email.
+func NewKeyAndCSR(cn, email string) (*rsa.PrivateKey, []byte, error) {
+	key, err := rsa.GenerateKey(rand.Reader, KeySize)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := &x509.CertificateRequest{
+		Subject:            pkix.Name{CommonName: cn},
+		SignatureAlgorithm: x509.SHA256WithRSA,
+	}
+	if email != "" {
+		tmpl.EmailAddresses = []string{email}
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, tmpl, key)
+	return key, csr, err
+}
+
+// request is the plist structure of a push certificate request.
+type request struct {
+	PushCertRequestCSR       string
+	PushCertCertificateChain string
+	PushCertSignature        string
+}
+
+// Sign signs the DER-encoded CSR with the MDM vendor private key and
+// returns the base64-encoded push certificate request to upload to
+// Apple. The vendor certificate chain must start with the MDM vendor
+// certificate followed by its intermediate and root certificates.
+func Sign(csr []byte, vendorKey crypto.Signer, vendorChain []*x509.Certificate) ([]byte, error) {
+	if _, err := x509.ParseCertificateRequest(csr); err != nil {
+		return nil, err
+	}
+	if len(vendorChain) < 1 {
+		return nil, errors.New("empty vendor certificate chain")
+	}
+	if _, ok := vendorKey.Public().(*rsa.PublicKey); !ok {
+		return nil, errors.New("vendor key is not an RSA key")
+	}
+	pub, ok := vendorChain[0].PublicKey.(*rsa.PublicKey)
+	if !ok || pub.N.Cmp(vendorKey.Public().(*rsa.PublicKey).N) != 0 {
+		return nil, errors.New("vendor key does not match vendor certificate")
+	}
+	digest := sha256.Sum256(csr)
+	sig, err := vendorKey.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	var chain []byte
+	for _, cert := range vendorChain {
+		chain = append(chain, cryptoutil.PEMCertificate(cert.Raw)...)
+	}
+	b, err := plist.MarshalIndent(&request{
+		PushCertRequestCSR:       base64.StdEncoding.EncodeToString(csr),
+		PushCertCertificateChain: string(chain),
+		PushCertSignature:        base64.StdEncoding.EncodeToString(sig),
+	}, "\t")
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, base64.StdEncoding.EncodedLen(len(b)))
+	base64.StdEncoding.Encode(out, b)
+	return out, nil
+}
+
+// ParseCertificates returns the certificates in PEM-encoded data.
+func ParseCertificates(pemData []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, pemData = pem.Decode(pemData)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) < 1 {
+		return nil, errors.New("no certificates found")
+	}
+	return certs, nil
+}
+
+// ParseCSR returns the DER bytes of a PEM or DER-encoded CSR.
+func ParseCSR(data []byte) ([]byte, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST" {
+			return nil, errors.New("PEM block is not a certificate request")
+		}
+		data = block.Bytes
+	}
+	_, err := x509.ParseCertificateRequest(data)
+	return data, err
+}
+
+// ParsePrivateKey returns the RSA private key in PEM-encoded data.
+func ParsePrivateKey(pemData []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, errors.New("no PEM private key found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
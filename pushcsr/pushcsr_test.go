@@ -0,0 +1,74 @@
+package pushcsr
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/groob/plist"
+)
+
+func TestSign(t *testing.T) {
+	vendorKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "MDM Vendor: Test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &vendorKey.PublicKey, vendorKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vendorCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, csr, err := NewKeyAndCSR("test", "test@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64, err := Sign(csr, vendorKey, []*x509.Certificate{vendorCert})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := base64.StdEncoding.DecodeString(string(b64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := new(request)
+	if err = plist.Unmarshal(b, req); err != nil {
+		t.Fatal(err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(req.PushCertSignature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(csr)
+	if err = rsa.VerifyPKCS1v15(&vendorKey.PublicKey, crypto.SHA256, digest[:], sig); err != nil {
+		t.Error(err)
+	}
+	if req.PushCertRequestCSR != base64.StdEncoding.EncodeToString(csr) {
+		t.Error("CSR mismatch")
+	}
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Sign(csr, otherKey, []*x509.Certificate{vendorCert}); err == nil {
+		t.Error("expected vendor key mismatch error")
+	}
+}
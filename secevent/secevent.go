@@ -0,0 +1,140 @@
+// Package secevent emits structured security events (such as failed
+// authentications or destructive commands) as a distinct stream for
+// SIEM ingestion.
+//
+// Emitters travel in request contexts: HTTP middleware installs an
+// Emitter with NewContext (or Middleware) and the handlers and services
+// processing the request report events with Emit. Events of requests
+// without an Emitter are dropped.
+package secevent
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	// CertVerifyFailed is a device request whose identity certificate
+	// or Mdm-Signature failed verification (or could not be decoded).
+	CertVerifyFailed = "CertVerifyFailed"
+	// CertAuthMismatch is a device request whose identity certificate
+	// is not associated with (or already associated with another)
+	// enrollment.
+	CertAuthMismatch = "CertAuthMismatch"
+	// APIAuthFailed is an API request that failed authentication or
+	// was not allowed by the network policy.
+	APIAuthFailed = "APIAuthFailed"
+	// DestructiveCommand is a destructive command (see
+	// DestructiveRequestTypes) enqueued with the API.
+	DestructiveCommand = "DestructiveCommand"
+	// ClientBlocked is a client address or enrollment blocked after
+	// repeated authentication failures.
+	ClientBlocked = "ClientBlocked"
+	// UntrustedProxyHeader is a request refused for supplying a
+	// reverse proxy header (such as the certificate header) from a
+	// client that is not a trusted proxy.
+	UntrustedProxyHeader = "UntrustedProxyHeader"
+	// QueueCleared is the command queue of an enrollment (and its user
+	// channel enrollments) cleared with the API.
+	QueueCleared = "QueueCleared"
+)
+
+// DestructiveRequestTypes are the command request types reported as
+// DestructiveCommand events.
+var DestructiveRequestTypes = map[string]bool{
+	"ClearPasscode":             true,
+	"ClearRestrictionsPassword": true,
+	"DeleteUser":                true,
+	"DeviceLock":                true,
+	"EraseDevice":               true,
+	"RemoveApplication":         true,
+	"RemoveProfile":             true,
+}
+
+// Event is a security event.
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// Source is the client address of the request.
+	Source string `json:"source,omitempty"`
+	// Path is the HTTP request path.
+	Path string `json:"path,omitempty"`
+	// IDs are the enrollment IDs the event is about.
+	IDs         []string `json:"ids,omitempty"`
+	CertHash    string   `json:"cert_hash,omitempty"`
+	RequestType string   `json:"request_type,omitempty"`
+	CommandUUID string   `json:"command_uuid,omitempty"`
+	Reason      string   `json:"reason,omitempty"`
+}
+
+// Emitter emits security events.
+type Emitter interface {
+	Emit(ctx context.Context, ev *Event)
+}
+
+type contextKeyEmitter struct{}
+
+// NewContext returns a copy of ctx with the emitter e.
+func NewContext(ctx context.Context, e Emitter) context.Context {
+	return context.WithValue(ctx, contextKeyEmitter{}, e)
+}
+
+// FromContext returns the emitter of ctx or nil if there is none.
+func FromContext(ctx context.Context) Emitter {
+	if ctx == nil {
+		return nil
+	}
+	e, _ := ctx.Value(contextKeyEmitter{}).(Emitter)
+	return e
+}
+
+// Emit emits ev with the emitter of ctx, if any. The event time is set
+// if it is zero.
+func Emit(ctx context.Context, ev *Event) {
+	e := FromContext(ctx)
+	if e == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	e.Emit(ctx, ev)
+}
+
+// Middleware installs e in the context of requests to next.
+func Middleware(next http.Handler, e Emitter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), e)))
+	}
+}
+
+// JSONWriter is an Emitter that writes events as JSON lines.
+type JSONWriter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	err func(error)
+}
+
+// NewJSONWriter creates a new JSONWriter writing to w. Write errors
+// are passed to errFn (if not nil).
+func NewJSONWriter(w io.Writer, errFn func(error)) *JSONWriter {
+	return &JSONWriter{w: w, err: errFn}
+}
+
+// Emit writes ev as a line of JSON.
+func (j *JSONWriter) Emit(_ context.Context, ev *Event) {
+	b, err := json.Marshal(ev)
+	if err == nil {
+		j.mu.Lock()
+		_, err = j.w.Write(append(b, '\n'))
+		j.mu.Unlock()
+	}
+	if err != nil && j.err != nil {
+		j.err(err)
+	}
+}
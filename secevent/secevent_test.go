@@ -0,0 +1,43 @@
+package secevent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJSONWriter(t *testing.T) {
+	var buf bytes.Buffer
+	j := NewJSONWriter(&buf, func(err error) { t.Error(err) })
+
+	// no emitter in context: dropped
+	Emit(context.Background(), &Event{Type: APIAuthFailed})
+
+	h := Middleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		Emit(r.Context(), &Event{Type: APIAuthFailed, Source: "192.0.2.1"})
+		Emit(r.Context(), &Event{Type: DestructiveCommand, IDs: []string{"a", "b"}, RequestType: "EraseDevice"})
+	}), j)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/v1/enqueue/a,b", nil))
+
+	dec := json.NewDecoder(&buf)
+	var events []*Event
+	for dec.More() {
+		ev := new(Event)
+		if err := dec.Decode(ev); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, ev)
+	}
+	if len(events) != 2 {
+		t.Fatalf("events: have %d, want 2", len(events))
+	}
+	if events[0].Type != APIAuthFailed || events[0].Source != "192.0.2.1" || events[0].Time.IsZero() {
+		t.Errorf("unexpected event: %+v", events[0])
+	}
+	if events[1].Type != DestructiveCommand || len(events[1].IDs) != 2 {
+		t.Errorf("unexpected event: %+v", events[1])
+	}
+}
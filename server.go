@@ -0,0 +1,1766 @@
+// Package nanomdm assembles the NanoMDM services, middleware, push,
+// and HTTP handlers into a complete, embeddable MDM server.
+package nanomdm
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/blob"
+	"github.com/jessepeterson/nanomdm/breaker"
+	"github.com/jessepeterson/nanomdm/bus"
+	"github.com/jessepeterson/nanomdm/cryptoutil"
+	mdmhttp "github.com/jessepeterson/nanomdm/http"
+	"github.com/jessepeterson/nanomdm/http/debug"
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/push"
+	"github.com/jessepeterson/nanomdm/push/buford"
+	pushsvc "github.com/jessepeterson/nanomdm/push/service"
+	"github.com/jessepeterson/nanomdm/secevent"
+	"github.com/jessepeterson/nanomdm/service"
+	"github.com/jessepeterson/nanomdm/service/appinstall"
+	"github.com/jessepeterson/nanomdm/service/appinventory"
+	"github.com/jessepeterson/nanomdm/service/bypasscode"
+	"github.com/jessepeterson/nanomdm/service/callback"
+	"github.com/jessepeterson/nanomdm/service/certauth"
+	"github.com/jessepeterson/nanomdm/service/compliance"
+	"github.com/jessepeterson/nanomdm/service/decommission"
+	"github.com/jessepeterson/nanomdm/service/deliverywindow"
+	"github.com/jessepeterson/nanomdm/service/devicepassword"
+	"github.com/jessepeterson/nanomdm/service/dump"
+	"github.com/jessepeterson/nanomdm/service/enrollstatus"
+	"github.com/jessepeterson/nanomdm/service/guard"
+	"github.com/jessepeterson/nanomdm/service/identityrotation"
+	"github.com/jessepeterson/nanomdm/service/inventory"
+	"github.com/jessepeterson/nanomdm/service/lostmode"
+	"github.com/jessepeterson/nanomdm/service/microwebhook"
+	"github.com/jessepeterson/nanomdm/service/multi"
+	nanosvc "github.com/jessepeterson/nanomdm/service/nanomdm"
+	"github.com/jessepeterson/nanomdm/service/osupdate"
+	"github.com/jessepeterson/nanomdm/service/pushrepair"
+	"github.com/jessepeterson/nanomdm/service/queuegc"
+	"github.com/jessepeterson/nanomdm/service/quota"
+	"github.com/jessepeterson/nanomdm/service/reject"
+	"github.com/jessepeterson/nanomdm/service/replay"
+	"github.com/jessepeterson/nanomdm/service/setup"
+	"github.com/jessepeterson/nanomdm/service/strict"
+	"github.com/jessepeterson/nanomdm/service/stuck"
+	"github.com/jessepeterson/nanomdm/service/topic"
+	"github.com/jessepeterson/nanomdm/service/unlocktoken"
+	"github.com/jessepeterson/nanomdm/service/workflow"
+	"github.com/jessepeterson/nanomdm/storage"
+	"github.com/jessepeterson/nanomdm/tokenauth"
+)
+
+// APIUsername is the HTTP Basic username for the API endpoints.
+const APIUsername = "nanomdm"
+
+// MaxDecompressedSize is the maximum size of a compressed MDM request
+// body once decoded.
+const MaxDecompressedSize = 64 << 20
+
+// Server is a complete NanoMDM server. It wires together storage, the
+// core MDM service, any additional services and middleware, the push
+// service, and the HTTP handlers.
+type Server struct {
+	logger   log.Logger
+	store    storage.AllStorage
+	verifier mdmhttp.CertVerifier
+
+	paths      mdmhttp.Paths
+	version    string
+	disableMDM bool
+	checkin    bool
+	certHeader string
+	dumpFile   *os.File
+	migration  bool
+	apiKey     string
+	apiKeyFunc func() string
+	compress   bool
+	inventory  bool
+	osUpdates  bool
+	appInstall bool
+
+	// public base URL of hosted app manifests
+	manifestBaseURL string
+
+	complianceRules   []*compliance.Rule
+	complianceWebhook string
+
+	// options (e.g. authentication) of the webhooks of services
+	webhookOpts []microwebhook.Option
+
+	// store large command results in blob storage
+	offloadStore     blob.Store
+	offloadThreshold int
+
+	// release devices awaiting configuration
+	setupRelease  bool
+	setupCommands []*setup.Command
+	setupApproval string
+
+	// sealing key of escrowed bypass codes
+	bypassCodeKey []byte
+	bypassCode    *bypasscode.Escrow
+
+	// sealing key of escrowed Unlock Tokens
+	unlockTokenKey []byte
+	unlockToken    *unlocktoken.Escrow
+
+	// sealing key and rotation interval of device passwords
+	devicePasswordKey      []byte
+	devicePasswordRotation time.Duration
+	devicePassword         *devicepassword.DevicePassword
+
+	// profile and timeout of identity rotations
+	rotationProfile  []byte
+	decommissionID   string
+	decommission     *decommission.Decommission
+	rotationTimeout  time.Duration
+	identityRotation *identityrotation.IdentityRotation
+
+	// workflow definitions and the workflow service running them
+	workflowDefs    []*workflow.Workflow
+	workflowWebhook string
+	workflows       *workflow.Workflows
+
+	// dedicated API key of the Lost Mode API
+	lostModeAPIKey string
+
+	appInventoryInterval time.Duration
+	appInventoryWebhook  string
+	appInventory         *appinventory.AppInventory
+
+	queueGCInterval  time.Duration
+	queueGCRetention time.Duration
+	queueGCArchive   string
+	queueGC          *queuegc.Collector
+
+	softDelete bool
+
+	queuePolicy  nanosvc.QueuePolicy
+	queueWindow  int
+	commandLimit int
+
+	// per-enrollment command delivery rate limit
+	rateLimit          int
+	rateLimitPeriod    time.Duration
+	rateLimitOverrides []string
+	rateLimitPolicy    *nanosvc.RateLimitPolicy
+
+	deliveryWindowConfig *deliverywindow.Config
+	deliveryWindows      *deliverywindow.Windows
+
+	followUps     []*nanosvc.FollowUp
+	followUpDepth int
+
+	stuckThreshold time.Duration
+	stuckWebhook   string
+	stuck          *stuck.Analyzer
+
+	pushRepairStale time.Duration
+	pushRepair      *pushrepair.PushRepair
+
+	replayWindow time.Duration
+
+	strictPlist       bool
+	strictPlistReject bool
+
+	// block clients after repeated authentication failures
+	guardLimit    int
+	guardWindow   time.Duration
+	guardMaxBlock time.Duration
+	guardWebhook  string
+	guard         *guard.Guard
+
+	// structured security event stream
+	secEvents secevent.Emitter
+
+	// sign device responses and profiles
+	responseSigner *cryptoutil.CMSSigner
+
+	// maintenance mode switch
+	maintenance *mdmhttp.Maintenance
+
+	// serve the runtime diagnostics API
+	debug bool
+
+	// operator-defined response headers
+	deviceHeaders http.Header
+	apiHeaders    http.Header
+
+	// validate (and optionally reject) unknown enrollment topics
+	topicCheck     bool
+	topicReject    bool
+	clientIPHeader string
+
+	checkinHooks []reject.Hook
+
+	// authenticate account-driven User Enrollments with bearer tokens
+	tokenProvider tokenauth.IdentityProvider
+
+	enrollProfile    []byte
+	enrollAuthURL    string
+	enrollAuthorizer mdmhttp.EnrollAuthorizer
+
+	quotaLimit       int
+	quotaTopicLimits map[string]int
+	quotaWebhook     string
+
+	enrollStatusResponses map[string]int
+	enrollStatusOpts      []enrollstatus.Option
+
+	// reverse proxies trusted to supply proxy headers
+	trustedProxies []*net.IPNet
+
+	apiNetworks     []*net.IPNet
+	apiCertVerifier mdmhttp.CertVerifier
+
+	certAuthOpts        []certauth.Option
+	services            []service.CheckinAndCommandService
+	serviceMiddleware   []func(service.CheckinAndCommandService) service.CheckinAndCommandService
+	pushProviderFactory push.PushProviderFactory
+	enrollIDResolver    service.EnrollIDResolver
+
+	storageTimeout time.Duration
+	pushTimeout    time.Duration
+	pushProxy      *url.URL
+	callbackProxy  *url.URL
+
+	storageBreaker *breaker.Breaker
+	pushBreaker    *breaker.Breaker
+	breakers       []*breaker.Breaker
+
+	nano        *nanosvc.Service
+	pushService *pushsvc.PushService
+	mdmService  service.CheckinAndCommandService
+	multi       *multi.MultiService
+	bus         bus.Bus
+	handlers    mdmhttp.Handlers
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithLogger sets the logger.
+func WithLogger(logger log.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// WithPaths sets the URL paths of the HTTP handlers.
+func WithPaths(paths mdmhttp.Paths) Option {
+	return func(s *Server) {
+		s.paths = paths
+	}
+}
+
+// WithVersion sets the version reported by the version endpoint.
+func WithVersion(version string) Option {
+	return func(s *Server) {
+		s.version = version
+	}
+}
+
+// WithoutMDM disables the MDM (device-facing) HTTP handlers.
+func WithoutMDM() Option {
+	return func(s *Server) {
+		s.disableMDM = true
+	}
+}
+
+// WithSeparateCheckin uses a separate HTTP handler for check-in
+// messages rather than a combined check-in and command handler.
+func WithSeparateCheckin() Option {
+	return func(s *Server) {
+		s.checkin = true
+	}
+}
+
+// WithCertHeader extracts the MDM identity certificate from the
+// URL-escaped PEM in HTTP header rather than the Mdm-Signature header.
+func WithCertHeader(header string) Option {
+	return func(s *Server) {
+		s.certHeader = header
+	}
+}
+
+// WithDump dumps raw MDM requests and responses to file.
+func WithDump(file *os.File) Option {
+	return func(s *Server) {
+		s.dumpFile = file
+	}
+}
+
+// WithCertAuthOptions passes options to the certificate authorization service.
+func WithCertAuthOptions(opts ...certauth.Option) Option {
+	return func(s *Server) {
+		s.certAuthOpts = append(s.certAuthOpts, opts...)
+	}
+}
+
+// WithServices adds services that are run after the core NanoMDM
+// service (such as webhooks). Their results are logged and ignored.
+func WithServices(svcs ...service.CheckinAndCommandService) Option {
+	return func(s *Server) {
+		s.services = append(s.services, svcs...)
+	}
+}
+
+// WithServiceMiddleware wraps the MDM service (after certificate
+// authorization) with mw. Middleware are applied in the order given so
+// the last one added is the outermost.
+func WithServiceMiddleware(mw func(service.CheckinAndCommandService) service.CheckinAndCommandService) Option {
+	return func(s *Server) {
+		s.serviceMiddleware = append(s.serviceMiddleware, mw)
+	}
+}
+
+// WithEnrollIDResolver resolves enrollment IDs with resolver rather
+// than by the default UDID/EnrollmentID convention. It is used by both
+// the core NanoMDM service and certificate authorization.
+func WithEnrollIDResolver(resolver service.EnrollIDResolver) Option {
+	return func(s *Server) {
+		s.enrollIDResolver = resolver
+	}
+}
+
+// WithPushProviderFactory sets the APNs push provider factory.
+func WithPushProviderFactory(factory push.PushProviderFactory) Option {
+	return func(s *Server) {
+		s.pushProviderFactory = factory
+	}
+}
+
+// WithStorageTimeout sets a deadline of timeout on device requests so
+// that the storage calls (and any other calls) made while handling a
+// check-in or command report can't hold the device connection open
+// longer. Storage backends that honor contexts (such as MySQL) cancel
+// their queries once the deadline passes.
+func WithStorageTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.storageTimeout = timeout
+	}
+}
+
+// WithPushTimeout bounds each push (retrieving push info and sending
+// the APNs requests) to timeout. It also sets the APNs request timeout
+// of the default push provider factory.
+func WithPushTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.pushTimeout = timeout
+	}
+}
+
+// WithPushProxy connects the default push provider factory to APNs
+// through the HTTP(S) proxy at proxy rather than the proxy of the
+// HTTPS_PROXY (and NO_PROXY) environment variables.
+func WithPushProxy(proxy *url.URL) Option {
+	return func(s *Server) {
+		s.pushProxy = proxy
+	}
+}
+
+// WithCallbackProxy posts command results to callback URLs through the
+// HTTP(S) proxy at proxy rather than the proxy of the HTTPS_PROXY (and
+// NO_PROXY) environment variables.
+func WithCallbackProxy(proxy *url.URL) Option {
+	return func(s *Server) {
+		s.callbackProxy = proxy
+	}
+}
+
+// WithCircuitBreakers fails device requests with HTTP 503 while the
+// storage breaker is open and skips pushes while the push breaker is
+// open. The states of these and the other breakers (e.g. of webhooks)
+// are exposed in the metrics. Nil breakers are ignored.
+func WithCircuitBreakers(storage, push *breaker.Breaker, others ...*breaker.Breaker) Option {
+	return func(s *Server) {
+		s.storageBreaker = storage
+		s.pushBreaker = push
+		for _, b := range append([]*breaker.Breaker{storage, push}, others...) {
+			if b != nil {
+				s.breakers = append(s.breakers, b)
+			}
+		}
+	}
+}
+
+// WithAPIKey enables the API handlers protected by HTTP Basic
+// authentication using key as the password.
+func WithAPIKey(key string) Option {
+	return func(s *Server) {
+		s.apiKey = key
+	}
+}
+
+// WithAPIKeyFunc enables the API handlers protected by HTTP Basic
+// authentication using the key returned by key for each request as the
+// password. This allows for rotating the API key without restarting.
+func WithAPIKeyFunc(key func() string) Option {
+	return func(s *Server) {
+		s.apiKeyFunc = key
+		s.apiKey = key()
+	}
+}
+
+// WithCompression gzip-compresses MDM responses to clients that
+// accept it. Compressed requests are always decoded.
+func WithCompression() Option {
+	return func(s *Server) {
+		s.compress = true
+	}
+}
+
+// WithReplayProtection rejects Authenticate and TokenUpdate messages
+// identical to one processed within window.
+func WithReplayProtection(window time.Duration) Option {
+	return func(s *Server) {
+		s.replayWindow = window
+	}
+}
+
+// WithStrictPlistValidation logs check-in messages and command results
+// that fail strict plist validation (missing required keys or keys of
+// the wrong type). If reject is true these are also rejected with HTTP
+// 400.
+func WithStrictPlistValidation(reject bool) Option {
+	return func(s *Server) {
+		s.strictPlist = true
+		s.strictPlistReject = reject
+	}
+}
+
+// WithAuthFailureBlocking blocks client addresses for doubling
+// durations (up to maxBlock) after limit signature verification or
+// certificate association failures within window.
+// Blocks are sent to the webhook at webhookURL (if not empty).
+func WithAuthFailureBlocking(limit int, window, maxBlock time.Duration, webhookURL string) Option {
+	return func(s *Server) {
+		s.guardLimit = limit
+		s.guardWindow = window
+		s.guardMaxBlock = maxBlock
+		s.guardWebhook = webhookURL
+	}
+}
+
+// WithSecurityEvents emits security events (see secevent) of the device
+// and API endpoints to e.
+func WithSecurityEvents(e secevent.Emitter) Option {
+	return func(s *Server) {
+		s.secEvents = e
+	}
+}
+
+// WithResponseSigning signs the responses of the MDM (and check-in)
+// endpoints with a detached signature in the Mdm-Signature header.
+// Unsigned enrollment and identity rotation profiles are served signed.
+func WithResponseSigning(signer *cryptoutil.CMSSigner) Option {
+	return func(s *Server) {
+		s.responseSigner = signer
+	}
+}
+
+// WithResponseHeaders sets the headers device on the responses of the
+// device endpoints (MDM, check-in, enrollment, and app manifests) and
+// api on those of the API endpoints (e.g. Strict-Transport-Security or
+// Cache-Control).
+func WithResponseHeaders(device, api http.Header) Option {
+	return func(s *Server) {
+		s.deviceHeaders = device
+		s.apiHeaders = api
+	}
+}
+
+// WithMaintenance starts the server in maintenance mode. See
+// Server.Maintenance.
+func WithMaintenance() Option {
+	return func(s *Server) {
+		s.maintenance.SetEnabled(true)
+	}
+}
+
+// WithDebug serves the runtime diagnostics (pprof profiles, expvar
+// variables, and a status summary) at the (authenticated) debug API
+// endpoint.
+func WithDebug() Option {
+	return func(s *Server) {
+		s.debug = true
+	}
+}
+
+// WithTopicValidation logs Authenticate and TokenUpdate messages whose
+// APNs topic has no stored push certificate. If reject is true these
+// messages are also rejected.
+func WithTopicValidation(reject bool) Option {
+	return func(s *Server) {
+		s.topicCheck = true
+		s.topicReject = reject
+	}
+}
+
+// WithCheckinHook allows hook to reject Authenticate and TokenUpdate
+// check-ins before they are processed. Hooks are checked in the order
+// given.
+func WithCheckinHook(hook reject.Hook) Option {
+	return func(s *Server) {
+		s.checkinHooks = append(s.checkinHooks, hook)
+	}
+}
+
+// WithTokenAuth authenticates MDM requests of account-driven User
+// Enrollments that have no client certificate with the bearer tokens
+// identified by provider. Enrollments are bound to the token identity
+// they enrolled with in place of a certificate.
+func WithTokenAuth(provider tokenauth.IdentityProvider) Option {
+	return func(s *Server) {
+		s.tokenProvider = provider
+	}
+}
+
+// WithEnrollProfile serves the enrollment profile to devices. If
+// authURL is not empty devices must authenticate the user at authURL
+// (with bearer tokens of the WithTokenAuth identity provider) first.
+func WithEnrollProfile(profile []byte, authURL string) Option {
+	return func(s *Server) {
+		s.enrollProfile = profile
+		s.enrollAuthURL = authURL
+	}
+}
+
+// WithEnrollAuthorizer requires authorizer to authorize enrollments
+// before the enrollment profile is served. The device information it
+// is passed is unauthenticated.
+func WithEnrollAuthorizer(authorizer mdmhttp.EnrollAuthorizer) Option {
+	return func(s *Server) {
+		s.enrollAuthorizer = authorizer
+	}
+}
+
+// WithEnrollmentQuota rejects the Authenticate of new devices once the
+// number of enabled device enrollments reaches limit (if not 0) or the
+// limit of their APNs topic in topicLimits. Rejections are sent to the
+// webhook at webhookURL (if not empty).
+func WithEnrollmentQuota(limit int, topicLimits map[string]int, webhookURL string) Option {
+	return func(s *Server) {
+		s.quotaLimit = limit
+		s.quotaTopicLimits = topicLimits
+		s.quotaWebhook = webhookURL
+	}
+}
+
+// WithEnrollmentStatusResponses responds to Connect and CheckOut
+// requests of unknown or disabled enrollments with HTTP statuses rather
+// than processing them as usual. Keys of responses are an enrollment
+// state ("unknown" or "disabled") optionally followed by a dot and a
+// request type ("Connect" or "CheckOut") to override the state's
+// status for that request type. For example {"unknown": 401} has
+// devices the server does not know unenroll themselves.
+func WithEnrollmentStatusResponses(responses map[string]int) Option {
+	return func(s *Server) {
+		s.enrollStatusResponses = responses
+	}
+}
+
+// WithClientIPHeader uses the HTTP header (set by a reverse proxy) as
+// the client address of MDM requests rather than the connection's
+// remote address. The rightmost address of the header not in the
+// trusted proxy networks (see WithTrustedProxies) is used.
+func WithClientIPHeader(header string) Option {
+	return func(s *Server) {
+		s.clientIPHeader = header
+	}
+}
+
+// WithTrustedProxies only accepts reverse proxy headers (the
+// certificate header, the client address header, X-Forwarded-For,
+// X-Request-Id, etc.) from clients connecting from networks. Requests
+// of other clients supplying the certificate header are refused and the
+// other headers are removed. By default all clients are trusted.
+func WithTrustedProxies(networks []*net.IPNet) Option {
+	return func(s *Server) {
+		s.trustedProxies = networks
+	}
+}
+
+// WithAPINetworks restricts the API handlers to clients connecting
+// from networks.
+func WithAPINetworks(networks []*net.IPNet) Option {
+	return func(s *Server) {
+		s.apiNetworks = networks
+	}
+}
+
+// WithAPIClientCertVerifier requires API clients to present a TLS
+// client certificate verified by verifier.
+func WithAPIClientCertVerifier(verifier mdmhttp.CertVerifier) Option {
+	return func(s *Server) {
+		s.apiCertVerifier = verifier
+	}
+}
+
+// WithInventory collects device attributes from DeviceInformation and
+// SecurityInfo command results into the inventory store.
+func WithInventory() Option {
+	return func(s *Server) {
+		s.inventory = true
+	}
+}
+
+// WithOSUpdates tracks the per-enrollment state of OS updates from
+// ScheduleOSUpdate and OSUpdateStatus command results and enables the
+// OS update rollout API.
+func WithOSUpdates() Option {
+	return func(s *Server) {
+		s.osUpdates = true
+	}
+}
+
+// WithAppInstalls tracks the results of application install commands
+// and enables the application install and manifest hosting APIs. Hosted
+// manifests are referenced by devices at baseURL (e.g.
+// "https://mdm.example.com") joined with the manifest path. Hosted
+// manifests can not be referenced if baseURL is empty.
+func WithAppInstalls(baseURL string) Option {
+	return func(s *Server) {
+		s.appInstall = true
+		s.manifestBaseURL = strings.TrimRight(baseURL, "/")
+	}
+}
+
+// WithCompliance evaluates compliance rules against check-ins and
+// command results. Rule match events are sent to webhookURL if not empty.
+func WithCompliance(rules []*compliance.Rule, webhookURL string) Option {
+	return func(s *Server) {
+		s.complianceRules = rules
+		s.complianceWebhook = webhookURL
+	}
+}
+
+// WithWebhookOptions sets the options (e.g. the token source to
+// authenticate with) of the webhooks of the compliance, app inventory,
+// stuck detection, and setup release services.
+func WithWebhookOptions(opts ...microwebhook.Option) Option {
+	return func(s *Server) {
+		s.webhookOpts = opts
+	}
+}
+
+// WithResultOffload stores the raw command results larger than
+// threshold bytes in store and stores a reference to them instead (see
+// nanosvc.WithResultOffload). If store can open blobs (e.g. a
+// blob.Dir) the blob API serves them.
+func WithResultOffload(store blob.Store, threshold int) Option {
+	return func(s *Server) {
+		s.offloadStore = store
+		s.offloadThreshold = threshold
+	}
+}
+
+// WithSetupRelease releases devices awaiting configuration by enqueuing
+// commands followed by a DeviceConfigured command. If approvalURL is
+// not empty then releases must be approved by that webhook.
+func WithSetupRelease(commands []*setup.Command, approvalURL string) Option {
+	return func(s *Server) {
+		s.setupRelease = true
+		s.setupCommands = commands
+		s.setupApproval = approvalURL
+	}
+}
+
+// WithAppInventory polls enrollments for their installed applications
+// every interval and stores the app inventory. App inventory change
+// events are sent to webhookURL if not empty. Polling starts with Start.
+func WithAppInventory(interval time.Duration, webhookURL string) Option {
+	return func(s *Server) {
+		s.appInventoryInterval = interval
+		s.appInventoryWebhook = webhookURL
+	}
+}
+
+// WithQueueGC purges the command queues of disabled enrollments (and of
+// enrollments not seen within retention, if not zero) every interval.
+// Purged commands are appended to the file at archivePath if not empty.
+// Collection starts with Start.
+func WithQueueGC(interval, retention time.Duration, archivePath string) Option {
+	return func(s *Server) {
+		s.queueGCInterval = interval
+		s.queueGCRetention = retention
+		s.queueGCArchive = archivePath
+	}
+}
+
+// WithSoftDelete soft-deletes enrollments on CheckOut and enables the
+// deleted enrollments API to restore the pending commands and
+// sub-enrollments of devices that re-enroll.
+func WithSoftDelete() Option {
+	return func(s *Server) {
+		s.softDelete = true
+	}
+}
+
+// WithQueuePolicy delivers the command chosen by policy among up to
+// window of the next queued commands of an enrollment (see
+// nanosvc.FIFOPolicy, nanosvc.NewPriorityPolicy, and
+// nanosvc.NewTypeGroupedPolicy).
+func WithQueuePolicy(policy nanosvc.QueuePolicy, window int) Option {
+	return func(s *Server) {
+		s.queuePolicy = policy
+		s.queueWindow = window
+	}
+}
+
+// WithCommandLimit delivers at most limit commands per Connect session
+// of a device. The remaining commands are delivered in later sessions.
+func WithCommandLimit(limit int) Option {
+	return func(s *Server) {
+		s.commandLimit = limit
+	}
+}
+
+// WithCommandRateLimit delivers at most limit commands per period to
+// each enrollment except commands of the override request types. The
+// limit applies on top of the queue policy (see WithQueuePolicy) or
+// nanosvc.FIFOPolicy. Enrollments held back by the limit are pushed
+// once they may receive commands again (see Start).
+func WithCommandRateLimit(limit int, period time.Duration, overrides []string) Option {
+	return func(s *Server) {
+		s.rateLimit = limit
+		s.rateLimitPeriod = period
+		s.rateLimitOverrides = overrides
+	}
+}
+
+// WithDeliveryWindows only delivers (and pushes for) the urgent
+// commands of config outside of the delivery windows of enrollments.
+// The windows apply on top of the queue policy (see WithQueuePolicy) or
+// nanosvc.FIFOPolicy. Deferred enrollments are pushed once their
+// windows open (see Start).
+func WithDeliveryWindows(config *deliverywindow.Config) Option {
+	return func(s *Server) {
+		s.deliveryWindowConfig = config
+	}
+}
+
+// WithBus publishes command results to (and reads them from) b for the
+// APIs waiting for command results. Defaults to an in-process bus.
+// Use a shared bus (like bus.Redis) when running multiple instances.
+func WithBus(b bus.Bus) Option {
+	return func(s *Server) {
+		s.bus = b
+	}
+}
+
+// WithFollowUps enqueues the follow-up commands of rules after
+// matching command reports. Chains of follow-ups to follow-up commands
+// end after maxDepth commands (nanomdm.DefaultFollowUpDepth if zero).
+func WithFollowUps(rules []*nanosvc.FollowUp, maxDepth int) Option {
+	return func(s *Server) {
+		s.followUps = rules
+		s.followUpDepth = maxDepth
+	}
+}
+
+// WithStuckDetection periodically flags enrollments with pending
+// commands that have not checked-in within threshold and enables the
+// stuck enrollments API. Stuck (and recovered) enrollment events are
+// sent to webhookURL if not empty. Analysis starts with Start.
+func WithStuckDetection(threshold time.Duration, webhookURL string) Option {
+	return func(s *Server) {
+		s.stuckThreshold = threshold
+		s.stuckWebhook = webhookURL
+	}
+}
+
+// WithPushRepairStaleAfter reports enrollments not seen within d as
+// having stale push info in the push info repair API.
+func WithPushRepairStaleAfter(d time.Duration) Option {
+	return func(s *Server) {
+		s.pushRepairStale = d
+	}
+}
+
+// WithLostMode tracks Lost Mode states and enables the Lost Mode API.
+// Given the privacy sensitivity of device locations the Lost Mode API
+// requires its own API key rather than the general API key.
+func WithLostMode(apiKey string) Option {
+	return func(s *Server) {
+		s.lostModeAPIKey = apiKey
+	}
+}
+
+// WithBypassCodeEscrow escrows Activation Lock bypass codes from
+// ActivationLockBypassCode command results and enables the bypass code
+// API. Codes are sealed with the 32 byte key before storage.
+func WithBypassCodeEscrow(key []byte) Option {
+	return func(s *Server) {
+		s.bypassCodeKey = key
+	}
+}
+
+// WithUnlockTokenEscrow escrows the Unlock Tokens of TokenUpdate
+// check-ins and enables the clear passcode API. Tokens are sealed with
+// the 32 byte key before storage.
+func WithUnlockTokenEscrow(key []byte) Option {
+	return func(s *Server) {
+		s.unlockTokenKey = key
+	}
+}
+
+// WithDevicePasswords manages recovery lock and firmware passwords and
+// enables the device password API. Passwords are sealed with the 32
+// byte key before storage. Set passwords are rotated when older than
+// rotation (if not zero). Rotation starts with Start.
+func WithDevicePasswords(key []byte, rotation time.Duration) Option {
+	return func(s *Server) {
+		s.devicePasswordKey = key
+		s.devicePasswordRotation = rotation
+	}
+}
+
+// WithDecommission enables the decommission API which removes the MDM
+// enrollment profile with the PayloadIdentifier identifier.
+func WithDecommission(identifier string) Option {
+	return func(s *Server) {
+		s.decommissionID = identifier
+	}
+}
+
+// WithIdentityRotation rotates MDM identities by installing profile
+// (e.g. an enrollment profile with a SCEP payload) and enables the
+// identity rotation API. Rotations not completed within timeout (or
+// identityrotation.DefaultTimeout if zero) fail.
+func WithIdentityRotation(profile []byte, timeout time.Duration) Option {
+	return func(s *Server) {
+		s.rotationProfile = profile
+		s.rotationTimeout = timeout
+	}
+}
+
+// WithWorkflows runs workflows (command sequences) started with the
+// workflows API. Completed and failed run events are sent to
+// webhookURL if not empty.
+func WithWorkflows(workflows []*workflow.Workflow, webhookURL string) Option {
+	return func(s *Server) {
+		s.workflowDefs = workflows
+		s.workflowWebhook = webhookURL
+	}
+}
+
+// WithMigration enables the migration API handler.
+func WithMigration() Option {
+	return func(s *Server) {
+		s.migration = true
+	}
+}
+
+// New assembles a new Server using storage store and MDM identity
+// certificate verifier.
+func New(store storage.AllStorage, verifier mdmhttp.CertVerifier, opts ...Option) (*Server, error) {
+	s := &Server{
+		logger:   log.NopLogger,
+		store:    store,
+		verifier: verifier,
+		paths:    mdmhttp.DefaultPaths,
+		version:  "unknown",
+
+		maintenance: new(mdmhttp.Maintenance),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if store == nil {
+		return nil, errors.New("missing storage")
+	}
+	if s.disableMDM && s.apiKey == "" && s.lostModeAPIKey == "" {
+		return nil, errors.New("nothing for server to do")
+	}
+	if !s.disableMDM && verifier == nil {
+		return nil, errors.New("missing certificate verifier")
+	}
+	if s.enrollAuthURL != "" && s.tokenProvider == nil {
+		return nil, errors.New("enrollment authentication requires a token identity provider")
+	}
+	for k, status := range s.enrollStatusResponses {
+		state, requestType, err := enrollstatus.ParseKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("enrollment status responses: %w", err)
+		}
+		if status != 0 && (status < 100 || status > 599) {
+			return nil, fmt.Errorf("enrollment status responses: invalid HTTP status for %s: %d", k, status)
+		}
+		s.enrollStatusOpts = append(s.enrollStatusOpts, enrollstatus.WithResponse(state, requestType, status))
+	}
+	if s.pushProviderFactory == nil {
+		bufordOpts := []buford.Option{buford.WithTimeout(s.pushTimeout)}
+		if s.pushProxy != nil {
+			bufordOpts = append(bufordOpts, buford.WithProxy(s.pushProxy))
+		}
+		s.pushProviderFactory = buford.NewPushProviderFactory(bufordOpts...)
+	}
+
+	if s.bus == nil {
+		s.bus = bus.NewLocal()
+	}
+
+	// create 'core' MDM service
+	nanoOpts := []nanosvc.Option{nanosvc.WithBus(s.bus)}
+	if s.enrollIDResolver != nil {
+		nanoOpts = append(nanoOpts, nanosvc.WithEnrollIDResolver(s.enrollIDResolver))
+		s.certAuthOpts = append(s.certAuthOpts, certauth.WithEnrollIDResolver(s.enrollIDResolver))
+	}
+	if s.softDelete {
+		nanoOpts = append(nanoOpts, nanosvc.WithSoftDelete(store))
+	}
+	if s.rateLimit > 0 {
+		if s.queuePolicy == nil {
+			s.queuePolicy, s.queueWindow = nanosvc.FIFOPolicy, nanosvc.DefaultQueueWindow
+		}
+		s.rateLimitPolicy = nanosvc.NewRateLimitPolicy(s.queuePolicy, s.rateLimit, s.rateLimitPeriod, s.rateLimitOverrides)
+		s.queuePolicy = s.rateLimitPolicy
+	}
+	if s.deliveryWindowConfig != nil {
+		if s.queuePolicy == nil {
+			s.queuePolicy, s.queueWindow = nanosvc.FIFOPolicy, nanosvc.DefaultQueueWindow
+		}
+		s.deliveryWindows = deliverywindow.New(s.deliveryWindowConfig, store,
+			deliverywindow.WithLogger(s.logger.With("service", "deliverywindow")),
+		)
+		s.queuePolicy = s.deliveryWindows.Policy(s.queuePolicy)
+	}
+	if s.queuePolicy != nil {
+		nanoOpts = append(nanoOpts, nanosvc.WithQueuePolicy(store, s.queuePolicy, s.queueWindow))
+	}
+	if s.commandLimit > 0 {
+		nanoOpts = append(nanoOpts, nanosvc.WithCommandLimit(s.commandLimit))
+	}
+	if len(s.followUps) > 0 {
+		nanoOpts = append(nanoOpts, nanosvc.WithFollowUps(s.followUps, store, store, s.followUpDepth))
+	}
+	if s.offloadStore != nil {
+		nanoOpts = append(nanoOpts, nanosvc.WithResultOffload(blob.NewOffloader(s.offloadStore, s.offloadThreshold)))
+	}
+	s.nano = nanosvc.New(store, s.logger.With("service", "nanomdm"), nanoOpts...)
+
+	// create our push service
+	pushOpts := []pushsvc.Option{
+		pushsvc.WithTimeout(s.pushTimeout),
+		pushsvc.WithBreaker(s.pushBreaker),
+	}
+	if s.deliveryWindows != nil {
+		pushOpts = append(pushOpts, pushsvc.WithDeferrer(s.deliveryWindows))
+	}
+	s.pushService = pushsvc.New(store, store, s.pushProviderFactory, s.logger.With("service", "push"), pushOpts...)
+
+	if len(s.bypassCodeKey) > 0 {
+		if len(s.bypassCodeKey) != 32 {
+			return nil, cryptoutil.ErrSealKeySize
+		}
+		s.bypassCode = bypasscode.NewEscrow(store, s.bypassCodeKey)
+	}
+
+	if len(s.unlockTokenKey) > 0 {
+		if len(s.unlockTokenKey) != 32 {
+			return nil, cryptoutil.ErrSealKeySize
+		}
+		s.unlockToken = unlocktoken.NewEscrow(store, s.unlockTokenKey)
+	}
+
+	if len(s.devicePasswordKey) > 0 {
+		if len(s.devicePasswordKey) != 32 {
+			return nil, cryptoutil.ErrSealKeySize
+		}
+		s.devicePassword = devicepassword.New(
+			store,
+			s.devicePasswordKey,
+			devicepassword.WithLogger(s.logger.With("service", "devicepassword")),
+			devicepassword.WithPusher(s.pushService),
+			devicepassword.WithRotation(s.devicePasswordRotation),
+		)
+	}
+
+	if s.responseSigner != nil {
+		var err error
+		if s.enrollProfile, err = signProfile(s.responseSigner, s.enrollProfile); err != nil {
+			return nil, fmt.Errorf("signing enrollment profile: %w", err)
+		}
+		if s.rotationProfile, err = signProfile(s.responseSigner, s.rotationProfile); err != nil {
+			return nil, fmt.Errorf("signing identity rotation profile: %w", err)
+		}
+	}
+
+	if len(s.rotationProfile) > 0 {
+		opts := []identityrotation.Option{
+			identityrotation.WithLogger(s.logger.With("service", "identityrotation")),
+			identityrotation.WithPusher(s.pushService),
+		}
+		if s.rotationTimeout > 0 {
+			opts = append(opts, identityrotation.WithTimeout(s.rotationTimeout))
+		}
+		s.identityRotation = identityrotation.New(store, s.rotationProfile, opts...)
+		s.certAuthOpts = append(s.certAuthOpts, certauth.WithRotator(s.identityRotation))
+	}
+
+	if len(s.workflowDefs) > 0 {
+		opts := []workflow.Option{
+			workflow.WithLogger(s.logger.With("service", "workflow")),
+			workflow.WithPusher(s.pushService),
+		}
+		if s.workflowWebhook != "" {
+			opts = append(opts, workflow.WithWebhook(s.workflowWebhook, s.webhookOpts...))
+		}
+		s.workflows = workflow.New(s.workflowDefs, store, opts...)
+	}
+
+	if s.decommissionID != "" {
+		s.decommission = decommission.New(store, s.decommissionID,
+			decommission.WithLogger(s.logger.With("service", "decommission")),
+			decommission.WithPusher(s.pushService),
+		)
+	}
+
+	if s.queueGCInterval > 0 {
+		opts := []queuegc.Option{
+			queuegc.WithLogger(s.logger.With("service", "queuegc")),
+			queuegc.WithInterval(s.queueGCInterval),
+			queuegc.WithRetention(s.queueGCRetention),
+		}
+		if s.queueGCArchive != "" {
+			opts = append(opts, queuegc.WithArchive(s.queueGCArchive))
+		}
+		s.queueGC = queuegc.New(store, opts...)
+	}
+
+	if s.stuckThreshold > 0 {
+		opts := []stuck.Option{stuck.WithLogger(s.logger.With("service", "stuck"))}
+		if s.stuckWebhook != "" {
+			opts = append(opts, stuck.WithWebhook(s.stuckWebhook, s.webhookOpts...))
+		}
+		s.stuck = stuck.New(store, s.stuckThreshold, opts...)
+	}
+
+	prOpts := []pushrepair.Option{
+		pushrepair.WithLogger(s.logger.With("service", "pushrepair")),
+		pushrepair.WithStaleAfter(s.pushRepairStale),
+	}
+	if s.identityRotation != nil {
+		// re-installing the MDM enrollment sends new push info
+		prOpts = append(prOpts, pushrepair.WithRefresher(s.identityRotation))
+	}
+	s.pushRepair = pushrepair.New(store, prOpts...)
+
+	if !s.disableMDM {
+		s.setupMDM()
+	}
+	if s.apiKey != "" {
+		s.setupAPI()
+	}
+	if s.lostModeAPIKey != "" {
+		// API handler for Lost Mode.
+		// the path prefix is stripped to use the path as ids.
+		s.handlers.LostMode = s.apiAuthKey(
+			mdmhttp.LostModeHandlerFunc(s.store, s.store, s.pushService, s.logger.With("handler", "lostmode")),
+			s.lostModeAPIKey,
+		)
+	}
+
+	// refuse API writes in maintenance mode (except to switch it)
+	s.handlers.Wrap(nil, func(next http.Handler) http.Handler {
+		return mdmhttp.MaintenanceAPIMiddleware(next, s.maintenance)
+	})
+	if s.apiKey != "" {
+		s.handlers.Maintenance = s.apiAuth(mdmhttp.MaintenanceHandlerFunc(s.maintenance, s.logger.With("handler", "maintenance")))
+	}
+
+	version := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"version":"` + s.version + `"}`))
+	})
+	s.handlers.Version = version
+
+	s.handlers.Wrap(headerMiddleware(s.deviceHeaders), headerMiddleware(s.apiHeaders))
+	if len(s.trustedProxies) > 0 {
+		s.handlers.Wrap(s.proxyTrust, s.proxyTrust)
+	}
+	return s, nil
+}
+
+// proxyTrust wraps next to guard the reverse proxy headers of clients
+// that are not trusted proxies.
+func (s *Server) proxyTrust(next http.Handler) http.Handler {
+	strip := append([]string{s.clientIPHeader}, mdmhttp.ProxyHeaders...)
+	next = mdmhttp.TrustedProxyMiddleware(next, s.trustedProxies, strip, []string{s.certHeader}, s.logger.With("handler", "proxy-trust"))
+	return s.securityEvents(next)
+}
+
+// headerMiddleware returns middleware setting headers or nil if there
+// are none.
+func headerMiddleware(headers http.Header) func(http.Handler) http.Handler {
+	if len(headers) < 1 {
+		return nil
+	}
+	return func(next http.Handler) http.Handler {
+		return mdmhttp.HeaderMiddleware(next, headers)
+	}
+}
+
+// signProfile signs profile with signer unless it is empty or already
+// signed (i.e. not a plist).
+func signProfile(signer *cryptoutil.CMSSigner, profile []byte) ([]byte, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(profile), []byte("<")) {
+		return profile, nil
+	}
+	return signer.Sign(profile)
+}
+
+// signResponses wraps next to sign its responses if enabled.
+func (s *Server) signResponses(next http.Handler) http.Handler {
+	if s.responseSigner == nil {
+		return next
+	}
+	return mdmhttp.SignResponseMiddleware(next, s.responseSigner, s.logger.With("handler", "sign-response"))
+}
+
+// certExtract wraps next with the configured certificate extraction middleware.
+func (s *Server) certExtract(next http.Handler) http.Handler {
+	logger := s.logger.With("handler", "cert-extract")
+	if s.certHeader != "" {
+		return mdmhttp.CertExtractPEMHeaderMiddleware(next, s.certHeader, logger)
+	}
+	return mdmhttp.CertExtractMdmSignatureMiddleware(next, logger)
+}
+
+// tokenAuth wraps next with the bearer token authentication middleware
+// if enabled.
+func (s *Server) tokenAuth(next http.Handler) http.Handler {
+	if s.tokenProvider == nil {
+		return next
+	}
+	return mdmhttp.TokenAuthMiddleware(next, s.tokenProvider, s.logger.With("handler", "token-auth"))
+}
+
+// authGuard wraps next with the authentication failure blocking
+// middleware if enabled.
+func (s *Server) authGuard(next http.Handler) http.Handler {
+	if s.guard == nil {
+		return next
+	}
+	return s.guard.Middleware(next)
+}
+
+// deviceEncoding wraps next with the client address, request decoding,
+// and (optional) response compression middleware for device endpoints.
+func (s *Server) deviceEncoding(next http.Handler) http.Handler {
+	logger := s.logger.With("handler", "compress")
+	if s.compress {
+		next = mdmhttp.CompressMiddleware(next, logger)
+	}
+	next = mdmhttp.DecompressMiddleware(next, MaxDecompressedSize, logger)
+	return mdmhttp.RemoteAddrMiddleware(s.securityEvents(next), s.clientIPHeader, s.trustedProxies)
+}
+
+// maintenanceMode wraps next to accept device requests without
+// processing them in maintenance mode.
+func (s *Server) maintenanceMode(next http.Handler) http.Handler {
+	return mdmhttp.MaintenanceDeviceMiddleware(next, s.maintenance, s.logger.With("handler", "maintenance"))
+}
+
+// securityEvents wraps next to emit security events if enabled.
+func (s *Server) securityEvents(next http.Handler) http.Handler {
+	if s.secEvents == nil {
+		return next
+	}
+	return secevent.Middleware(next, s.secEvents)
+}
+
+// pushCounts returns the push counts of the push providers.
+func (s *Server) pushCounts() []mdmhttp.PushCounts {
+	var counts []mdmhttp.PushCounts
+	for _, p := range s.pushService.Providers() {
+		counts = append(counts, mdmhttp.PushCounts{Topic: p.Topic, Pushes: p.Pushes, Failures: p.Failures})
+	}
+	return counts
+}
+
+func (s *Server) setupMDM() {
+	var mdmService service.CheckinAndCommandService = s.nano
+	svcs := s.services
+	// post the results of commands enqueued with a callback URL
+	cbOpts := []callback.Option{callback.WithLogger(s.logger.With("service", "callback"))}
+	if s.callbackProxy != nil {
+		cbOpts = append(cbOpts, callback.WithClient(&http.Client{
+			Timeout:   callback.DefaultTimeout,
+			Transport: microwebhook.ProxyTransport(nil, s.callbackProxy),
+		}))
+	}
+	svcs = append(svcs, callback.New(s.store, cbOpts...))
+	if s.inventory {
+		svcs = append(svcs, inventory.New(s.store, s.logger.With("service", "inventory")))
+	}
+	if s.appInstall {
+		svcs = append(svcs, appinstall.New(s.store, s.logger.With("service", "appinstall")))
+	}
+	if s.lostModeAPIKey != "" {
+		svcs = append(svcs, lostmode.New(s.store, s.logger.With("service", "lostmode")))
+	}
+	if s.osUpdates {
+		svcs = append(svcs, osupdate.New(s.store, s.logger.With("service", "osupdate")))
+	}
+	if s.devicePassword != nil {
+		svcs = append(svcs, s.devicePassword)
+	}
+	if s.identityRotation != nil {
+		svcs = append(svcs, s.identityRotation)
+	}
+	if s.workflows != nil {
+		svcs = append(svcs, s.workflows)
+	}
+	if s.decommission != nil {
+		svcs = append(svcs, s.decommission)
+	}
+	if s.appInventoryInterval > 0 {
+		opts := []appinventory.Option{
+			appinventory.WithLogger(s.logger.With("service", "appinventory")),
+			appinventory.WithPusher(s.pushService),
+			appinventory.WithInterval(s.appInventoryInterval),
+		}
+		if s.appInventoryWebhook != "" {
+			opts = append(opts, appinventory.WithWebhook(s.appInventoryWebhook, s.webhookOpts...))
+		}
+		s.appInventory = appinventory.New(s.store, opts...)
+		svcs = append(svcs, s.appInventory)
+	}
+	if len(s.complianceRules) > 0 {
+		opts := []compliance.Option{
+			compliance.WithLogger(s.logger.With("service", "compliance")),
+			compliance.WithPusher(s.pushService),
+		}
+		if s.complianceWebhook != "" {
+			opts = append(opts, compliance.WithWebhook(s.complianceWebhook, s.webhookOpts...))
+		}
+		svcs = append(svcs, compliance.New(s.complianceRules, s.store, opts...))
+	}
+	if s.setupRelease {
+		opts := []setup.Option{
+			setup.WithLogger(s.logger.With("service", "setup")),
+			setup.WithPusher(s.pushService),
+		}
+		if s.setupApproval != "" {
+			// approvals must never be filtered out (which would approve)
+			hookOpts := append(s.webhookOpts[:len(s.webhookOpts):len(s.webhookOpts)], microwebhook.WithFilter(nil))
+			opts = append(opts, setup.WithApprovalWebhook(s.setupApproval, hookOpts...))
+		}
+		svcs = append(svcs, setup.New(s.setupCommands, s.store, opts...))
+	}
+	if len(svcs) > 0 {
+		svcs = append([]service.CheckinAndCommandService{mdmService}, svcs...)
+		s.multi = multi.New(s.logger.With("service", "multi"), svcs...)
+		mdmService = s.multi
+	}
+	certAuthOpts := append([]certauth.Option{certauth.WithLogger(s.logger.With("service", "certauth"))}, s.certAuthOpts...)
+	mdmService = certauth.New(mdmService, s.store, certAuthOpts...)
+	if len(s.enrollStatusOpts) > 0 {
+		// outside of certauth which errors for unknown enrollments
+		opts := append([]enrollstatus.Option{enrollstatus.WithLogger(s.logger.With("service", "enrollstatus"))}, s.enrollStatusOpts...)
+		if s.enrollIDResolver != nil {
+			opts = append(opts, enrollstatus.WithEnrollIDResolver(s.enrollIDResolver))
+		}
+		mdmService = enrollstatus.New(mdmService, s.store, opts...)
+	}
+	if s.guardLimit > 0 {
+		opts := []guard.Option{guard.WithLogger(s.logger.With("service", "guard"))}
+		if s.guardWindow > 0 {
+			opts = append(opts, guard.WithWindow(s.guardWindow))
+		}
+		if s.guardMaxBlock > 0 {
+			opts = append(opts, guard.WithBlock(guard.DefaultBlock, s.guardMaxBlock))
+		}
+		if s.enrollIDResolver != nil {
+			opts = append(opts, guard.WithEnrollIDResolver(s.enrollIDResolver))
+		}
+		if s.guardWebhook != "" {
+			opts = append(opts, guard.WithWebhook(s.guardWebhook, s.webhookOpts...))
+		}
+		s.guard = guard.New(mdmService, s.guardLimit, opts...)
+		mdmService = s.guard
+	}
+	for _, mw := range s.serviceMiddleware {
+		mdmService = mw(mdmService)
+	}
+	if s.topicCheck {
+		opts := []topic.Option{topic.WithLogger(s.logger.With("service", "topic"))}
+		if s.topicReject {
+			opts = append(opts, topic.WithReject())
+		}
+		mdmService = topic.New(mdmService, s.store, opts...)
+	}
+	hooks := s.checkinHooks
+	if s.quotaLimit > 0 || len(s.quotaTopicLimits) > 0 {
+		opts := []quota.Option{
+			quota.WithLogger(s.logger.With("service", "quota")),
+			quota.WithLimit(s.quotaLimit),
+		}
+		for topic, limit := range s.quotaTopicLimits {
+			opts = append(opts, quota.WithTopicLimit(topic, limit))
+		}
+		if s.enrollIDResolver != nil {
+			opts = append(opts, quota.WithEnrollIDResolver(s.enrollIDResolver))
+		}
+		if s.quotaWebhook != "" {
+			opts = append(opts, quota.WithWebhook(s.quotaWebhook, s.webhookOpts...))
+		}
+		hooks = append(hooks, quota.New(s.store, opts...))
+	}
+	// wrap in reverse so that the hooks are checked in order
+	for i := len(hooks) - 1; i >= 0; i-- {
+		mdmService = reject.New(mdmService, hooks[i], reject.WithLogger(s.logger.With("service", "reject")))
+	}
+	if s.replayWindow > 0 {
+		mdmService = replay.New(
+			mdmService,
+			replay.WithWindow(s.replayWindow),
+			replay.WithLogger(s.logger.With("service", "replay")),
+		)
+	}
+	if s.dumpFile != nil {
+		mdmService = dump.New(mdmService, s.dumpFile)
+	}
+	if s.bypassCode != nil {
+		// redact bypass codes before any other service sees them
+		mdmService = bypasscode.New(
+			mdmService,
+			s.bypassCode,
+			bypasscode.WithLogger(s.logger.With("service", "bypasscode")),
+		)
+	}
+	if s.unlockToken != nil {
+		// remove Unlock Tokens before any other service sees them
+		mdmService = unlocktoken.New(
+			mdmService,
+			s.unlockToken,
+			unlocktoken.WithLogger(s.logger.With("service", "unlocktoken")),
+		)
+	}
+	if s.storageBreaker != nil {
+		// fail fast while storage is failing
+		mdmService = breaker.NewService(mdmService, s.storageBreaker)
+	}
+	if s.strictPlist {
+		// validate messages before any other service processes them
+		opts := []strict.Option{strict.WithLogger(s.logger.With("service", "strict"))}
+		if !s.strictPlistReject {
+			opts = append(opts, strict.WithLogOnly())
+		}
+		mdmService = strict.New(mdmService, opts...)
+	}
+	s.mdmService = mdmService
+
+	// 'core' MDM HTTP handler
+	var mdmHandler http.Handler
+	if s.checkin {
+		// if we use the check-in handler then only handle commands
+		mdmHandler = mdmhttp.CommandAndReportResultsHandlerFunc(mdmService, s.logger.With("handler", "command"))
+	} else {
+		// if we don't use a check-in handler then do both
+		mdmHandler = mdmhttp.CheckinAndCommandHandlerFunc(mdmService, s.logger.With("handler", "checkin-command"))
+	}
+	mdmHandler = mdmhttp.TimeoutMiddleware(mdmHandler, s.storageTimeout)
+	mdmHandler = s.signResponses(mdmHandler)
+	mdmHandler = mdmhttp.CertVerifyMiddleware(mdmHandler, s.verifier, s.logger.With("handler", "cert-verify"))
+	s.handlers.MDM = s.deviceEncoding(s.maintenanceMode(s.authGuard(s.certExtract(s.tokenAuth(mdmHandler)))))
+
+	if s.checkin {
+		// if we specified a separate check-in handler, set it up
+		var checkinHandler http.Handler
+		checkinHandler = mdmhttp.CheckinHandlerFunc(mdmService, s.logger.With("handler", "checkin"))
+		checkinHandler = mdmhttp.TimeoutMiddleware(checkinHandler, s.storageTimeout)
+		checkinHandler = s.signResponses(checkinHandler)
+		checkinHandler = mdmhttp.CertVerifyMiddleware(checkinHandler, s.verifier, s.logger.With("handler", "cert-verify"))
+		s.handlers.Checkin = s.deviceEncoding(s.maintenanceMode(s.authGuard(s.certExtract(s.tokenAuth(checkinHandler)))))
+	}
+
+	if len(s.enrollProfile) > 0 {
+		var provider tokenauth.IdentityProvider
+		if s.enrollAuthURL != "" {
+			provider = s.tokenProvider
+		}
+		s.handlers.Enroll = mdmhttp.EnrollHandlerFunc(s.enrollProfile, provider, s.enrollAuthURL, s.enrollAuthorizer, s.logger.With("handler", "enroll"))
+	}
+
+	if s.appInstall {
+		// devices fetch hosted manifests without MDM credentials
+		s.handlers.Manifest = mdmhttp.AppManifestHandlerFunc(s.store, s.logger.With("handler", "manifest"))
+	}
+}
+
+// apiAuth wraps next with the API authentication and network policy middleware.
+func (s *Server) apiAuth(next http.Handler) http.Handler {
+	if s.apiKeyFunc != nil {
+		return s.apiAuthFunc(next, s.apiKeyFunc)
+	}
+	return s.apiAuthKey(next, s.apiKey)
+}
+
+// apiAuthKey wraps next with the network policy middleware and
+// authentication using apiKey.
+func (s *Server) apiAuthKey(next http.Handler, apiKey string) http.Handler {
+	return s.apiAuthFunc(next, func() string { return apiKey })
+}
+
+// apiAuthFunc wraps next with the network policy middleware and
+// authentication using the API key returned by apiKey.
+func (s *Server) apiAuthFunc(next http.Handler, apiKey func() string) http.Handler {
+	next = mdmhttp.BasicAuthFuncMiddleware(next, APIUsername, apiKey, "nanomdm")
+	if s.apiCertVerifier != nil {
+		next = mdmhttp.ClientCertMiddleware(next, s.apiCertVerifier, s.logger.With("handler", "api-client-cert"))
+	}
+	if len(s.apiNetworks) > 0 {
+		next = mdmhttp.IPAllowMiddleware(next, s.apiNetworks, s.logger.With("handler", "api-allow"))
+	}
+	return s.securityEvents(next)
+}
+
+func (s *Server) setupAPI() {
+	// API handler for push cert storage/upload.
+	s.handlers.PushCert = s.apiAuth(mdmhttp.StorePushCertHandlerFunc(s.store, s.logger.With("handler", "store-cert")))
+
+	// API handler for listing and deleting push certs.
+	// the path prefix is stripped to use the path as the topic.
+	s.handlers.PushCerts = s.apiAuth(mdmhttp.PushCertsHandlerFunc(s.store, s.logger.With("handler", "push-certs")))
+
+	// API handler for reconciling enrollment topics and push certs.
+	s.handlers.TopicReport = s.apiAuth(mdmhttp.TopicReportHandlerFunc(s.store, s.logger.With("handler", "topic-report")))
+
+	// API handler for detecting and repairing missing or stale push info.
+	s.handlers.PushRepair = s.apiAuth(mdmhttp.PushRepairHandlerFunc(s.pushRepair, s.logger.With("handler", "push-repair")))
+
+	// API handler for push notifications.
+	// the path prefix is stripped to use the path as an id.
+	s.handlers.Push = s.apiAuth(mdmhttp.PushHandlerFunc(s.pushService, s.logger.With("handler", "push"), mdmhttp.WithPushGroups(s.store), mdmhttp.WithPushSmartGroups(s.store)))
+
+	// API handler for new command queueing.
+	// the path prefix is stripped to use the path as an id.
+	s.handlers.Enqueue = s.apiAuth(mdmhttp.RawCommandEnqueueHandler(s.store, s.pushService, s.logger.With("handler", "enqueue"), mdmhttp.WithResultBus(s.bus)))
+
+	// API handler for listing enrollments.
+	s.handlers.Enrollments = s.apiAuth(mdmhttp.ListEnrollmentsHandlerFunc(s.store, s.logger.With("handler", "enrollments")))
+
+	// API handler for exporting enrollments (with inventory) as CSV or
+	// ND-JSON.
+	s.handlers.Export = s.apiAuth(mdmhttp.ExportEnrollmentsHandlerFunc(s.store, s.store, s.logger.With("handler", "enrollmentsexport")))
+
+	// API handler for clearing the command queue of an enrollment.
+	// the path prefix is stripped to use the path as an id.
+	s.handlers.EnrollmentQueue = s.apiAuth(mdmhttp.ClearQueueHandlerFunc(s.store, s.logger.With("handler", "clearqueue")))
+
+	// API handler for enrollment groups.
+	// the path prefix is stripped to use the path as the group name.
+	s.handlers.Groups = s.apiAuth(mdmhttp.GroupsHandlerFunc(s.store, s.logger.With("handler", "groups")))
+
+	// API handler for smart groups.
+	// the path prefix is stripped to use the path as the smart group name.
+	s.handlers.SmartGroups = s.apiAuth(mdmhttp.SmartGroupsHandlerFunc(s.store, s.logger.With("handler", "smart-groups")))
+
+	// API handler for command delivery audit trails.
+	// the path prefix is stripped to use the path as an id.
+	s.handlers.Queue = s.apiAuth(mdmhttp.CommandDeliveriesHandlerFunc(s.store, s.logger.With("handler", "queue")))
+
+	// versioned JSON API.
+	// the path prefix is stripped to use the path as the route.
+	s.handlers.APIv1 = s.apiAuth(mdmhttp.APIv1Handler(s.store, s.pushService, s.bus, s.logger.With("handler", "apiv1")))
+
+	// API handler for long-polling command results.
+	// the path prefix is stripped to use the path as a command UUID.
+	s.handlers.Results = s.apiAuth(mdmhttp.ResultWaitHandlerFunc(s.bus, s.store, s.logger.With("handler", "results")))
+
+	if opener, ok := s.offloadStore.(mdmhttp.BlobOpener); ok {
+		// API handler for offloaded command results.
+		// the path prefix is stripped to use the path as the key.
+		s.handlers.Blobs = s.apiAuth(mdmhttp.BlobHandlerFunc(opener, s.logger.With("handler", "blobs")))
+	}
+
+	// API handler for command queue statistics.
+	// the path prefix is stripped to use the path as ids.
+	s.handlers.QueueStats = s.apiAuth(mdmhttp.QueueStatsHandlerFunc(s.store, s.logger.With("handler", "queuestats")))
+
+	// API handler for enrollment, command, and push statistics.
+	s.handlers.Stats = s.apiAuth(mdmhttp.StatsHandlerFunc(s.store, s.pushCounts, s.logger.With("handler", "stats")))
+
+	if s.softDelete {
+		// API handler for soft-deleted enrollments.
+		// the path prefix is stripped to use the path as ids.
+		s.handlers.Deleted = s.apiAuth(mdmhttp.DeletedEnrollmentsHandlerFunc(s.store, s.logger.With("handler", "deleted")))
+	}
+
+	if s.stuck != nil {
+		// API handler for stuck enrollments.
+		s.handlers.Stuck = s.apiAuth(mdmhttp.StuckHandlerFunc(s.stuck, s.logger.With("handler", "stuck")))
+	}
+
+	// Prometheus metrics handler.
+	counters := []mdmhttp.MetricsCounter{{
+		Name:  "nanomdm_duplicate_command_reports_total",
+		Help:  "Number of duplicate (re-sent) command reports ignored.",
+		Value: s.nano.DuplicateReports,
+	}}
+	if ms, ok := s.store.(interface{ Mismatches() uint64 }); ok {
+		counters = append(counters, mdmhttp.MetricsCounter{
+			Name:  "nanomdm_storage_mismatches_total",
+			Help:  "Number of dual-write storage results that differed from the primary storage.",
+			Value: ms.Mismatches,
+		})
+	}
+	if hs, ok := s.store.(interface {
+		Healthy() bool
+		HealthChecks() uint64
+		HealthCheckFailures() uint64
+		PoolResets() uint64
+	}); ok {
+		counters = append(counters, mdmhttp.MetricsCounter{
+			Name: "nanomdm_storage_healthy",
+			Help: "Whether storage health checks have not failed persistently (1 healthy, 0 unhealthy).",
+			Value: func() uint64 {
+				if hs.Healthy() {
+					return 1
+				}
+				return 0
+			},
+			Type: "gauge",
+		}, mdmhttp.MetricsCounter{
+			Name:  "nanomdm_storage_health_checks_total",
+			Help:  "Number of storage health checks.",
+			Value: hs.HealthChecks,
+		}, mdmhttp.MetricsCounter{
+			Name:  "nanomdm_storage_health_check_failures_total",
+			Help:  "Number of failed storage health checks.",
+			Value: hs.HealthCheckFailures,
+		}, mdmhttp.MetricsCounter{
+			Name:  "nanomdm_storage_pool_resets_total",
+			Help:  "Number of storage connection pool resets after persistently failed health checks.",
+			Value: hs.PoolResets,
+		})
+	}
+	for _, b := range s.breakers {
+		b := b
+		name := "nanomdm_circuit_breaker_" + b.Name()
+		counters = append(counters, mdmhttp.MetricsCounter{
+			Name:  name + "_state",
+			Help:  "State of the " + b.Name() + " circuit breaker (0 closed, 1 half-open, 2 open).",
+			Value: func() uint64 { return uint64(b.State()) },
+			Type:  "gauge",
+		}, mdmhttp.MetricsCounter{
+			Name:  name + "_opened_total",
+			Help:  "Number of times the " + b.Name() + " circuit breaker opened.",
+			Value: b.Opened,
+		}, mdmhttp.MetricsCounter{
+			Name:  name + "_rejected_total",
+			Help:  "Number of calls failed by the open " + b.Name() + " circuit breaker.",
+			Value: b.Rejected,
+		})
+	}
+	s.handlers.Metrics = s.apiAuth(mdmhttp.MetricsHandlerFunc(s.store, s.logger.With("handler", "metrics"), counters...))
+
+	if s.debug {
+		// API handler for runtime diagnostics.
+		// the path prefix is stripped to use the path as the route.
+		s.handlers.Debug = s.apiAuth(debug.Handler(s.debugStatus(), s.logger.With("handler", "debug")))
+	}
+
+	// API handler for device inventory.
+	// the path prefix is stripped to use the path as ids.
+	s.handlers.Inventory = s.apiAuth(mdmhttp.InventoryHandlerFunc(s.store, s.logger.With("handler", "inventory")))
+
+	// API handler for app inventory.
+	// the path prefix is stripped to use the path as ids.
+	s.handlers.AppInventory = s.apiAuth(mdmhttp.AppInventoryHandlerFunc(s.store, s.logger.With("handler", "app-inventory")))
+
+	if s.osUpdates {
+		// API handler for OS update rollouts.
+		// the path prefix is stripped to use the path as ids.
+		s.handlers.OSUpdate = s.apiAuth(mdmhttp.OSUpdateHandlerFunc(s.store, s.store, s.pushService, s.logger.With("handler", "osupdate")))
+	}
+
+	if s.bypassCode != nil {
+		// API handler for escrowed bypass codes.
+		// the path prefix is stripped to use the path as ids.
+		s.handlers.BypassCode = s.apiAuth(mdmhttp.BypassCodeHandlerFunc(s.bypassCode, s.store, s.pushService, s.logger.With("handler", "bypasscode")))
+	}
+
+	if s.unlockToken != nil {
+		// API handler for clearing passcodes with escrowed Unlock Tokens.
+		// the path prefix is stripped to use the path as ids.
+		s.handlers.ClearPasscode = s.apiAuth(mdmhttp.ClearPasscodeHandlerFunc(s.unlockToken, s.store, s.pushService, s.logger.With("handler", "clearpasscode")))
+	}
+
+	if s.devicePassword != nil {
+		// API handler for recovery lock and firmware passwords.
+		// the path prefix is stripped to use the path as ids.
+		s.handlers.DevicePasswords = s.apiAuth(mdmhttp.DevicePasswordHandlerFunc(s.devicePassword, s.logger.With("handler", "devicepasswords")))
+	}
+
+	if s.identityRotation != nil {
+		// API handler for identity rotations.
+		// the path prefix is stripped to use the path as ids.
+		s.handlers.IdentityRotation = s.apiAuth(mdmhttp.IdentityRotationHandlerFunc(s.identityRotation, s.logger.With("handler", "identityrotation")))
+	}
+
+	if s.workflows != nil {
+		// API handler for workflow runs.
+		// the path prefix is stripped to use the path as the workflow name and ids.
+		s.handlers.Workflows = s.apiAuth(mdmhttp.WorkflowsHandlerFunc(s.workflows, s.logger.With("handler", "workflows")))
+	}
+
+	if s.decommission != nil {
+		// API handler for decommissioning devices.
+		// the path prefix is stripped to use the path as ids.
+		s.handlers.Decommission = s.apiAuth(mdmhttp.DecommissionHandlerFunc(s.decommission, s.logger.With("handler", "decommission")))
+	}
+
+	if s.appInstall {
+		// API handler for application installs.
+		// the path prefix is stripped to use the path as ids.
+		var manifestURL string
+		if s.manifestBaseURL != "" {
+			manifestURL = s.manifestBaseURL + s.paths.Manifest
+		}
+		s.handlers.Apps = s.apiAuth(mdmhttp.AppInstallHandlerFunc(s.store, s.store, s.pushService, manifestURL, s.logger.With("handler", "apps")))
+
+		// API handler for uploading application manifests.
+		// the path prefix is stripped to use the path as the name.
+		s.handlers.Manifests = s.apiAuth(mdmhttp.StoreAppManifestHandlerFunc(s.store, s.logger.With("handler", "store-manifest")))
+	}
+
+	if s.migration {
+		// setup a "migration" handler that takes Check-In messages
+		// without bothering with certificate auth or other
+		// middleware.
+		//
+		// if the source MDM can put together enough of an
+		// authenticate and tokenupdate message to effectively
+		// generate "enrollments" then this effively allows us to
+		// migrate MDM enrollments between servers.
+		s.handlers.Migration = s.apiAuth(mdmhttp.CheckinHandlerFunc(s.nano, s.logger.With("handler", "migration")))
+	}
+}
+
+// debugStatus returns the sections of the debug status summary.
+func (s *Server) debugStatus() map[string]debug.StatusFunc {
+	sections := map[string]debug.StatusFunc{
+		"push_providers": func() interface{} { return s.pushService.Providers() },
+		"maintenance":    func() interface{} { return s.maintenance.Enabled() },
+	}
+	if ds, ok := s.store.(interface{ DBStats() sql.DBStats }); ok {
+		sections["storage_pool"] = func() interface{} { return ds.DBStats() }
+	}
+	if qs, ok := s.store.(interface {
+		QueryStats() map[string]storage.QueryStats
+	}); ok {
+		sections["storage_queries"] = func() interface{} { return qs.QueryStats() }
+	}
+	if s.multi != nil {
+		// webhooks (and the other services) run after responding
+		sections["pending_service_calls"] = func() interface{} { return s.multi.Pending() }
+	}
+	return sections
+}
+
+// Paths returns the URL paths of the HTTP handlers.
+func (s *Server) Paths() mdmhttp.Paths {
+	return s.paths
+}
+
+// Start starts the background tasks of the server (e.g. app inventory
+// polling) which run until ctx is done.
+func (s *Server) Start(ctx context.Context) {
+	if s.appInventory != nil {
+		go s.appInventory.Run(ctx)
+	}
+	if s.devicePassword != nil {
+		go s.devicePassword.Run(ctx)
+	}
+	if s.identityRotation != nil {
+		go s.identityRotation.Run(ctx)
+	}
+	if s.workflows != nil {
+		go s.workflows.Run(ctx)
+	}
+	if s.rateLimitPolicy != nil {
+		go s.rateLimitPolicy.Run(ctx, s.pushService, time.Minute, s.logger.With("service", "ratelimit"))
+	}
+	if s.deliveryWindows != nil {
+		go s.deliveryWindows.Run(ctx, s.pushService, time.Minute)
+	}
+	if s.stuck != nil {
+		go s.stuck.Run(ctx)
+	}
+	if s.queueGC != nil {
+		go s.queueGC.Run(ctx)
+	}
+}
+
+// MDMHandlers returns the device-facing MDM HTTP handlers (and version).
+func (s *Server) MDMHandlers() mdmhttp.Handlers {
+	return mdmhttp.Handlers{
+		MDM:      s.handlers.MDM,
+		Checkin:  s.handlers.Checkin,
+		Manifest: s.handlers.Manifest,
+		Enroll:   s.handlers.Enroll,
+		Version:  s.handlers.Version,
+	}
+}
+
+// APIHandlers returns the API HTTP handlers (and version).
+func (s *Server) APIHandlers() mdmhttp.Handlers {
+	h := s.handlers
+	h.MDM = nil
+	h.Checkin = nil
+	h.Manifest = nil
+	h.Enroll = nil
+	return h
+}
+
+// Register registers all HTTP handlers on mux.
+func (s *Server) Register(mux mdmhttp.Mux) {
+	s.handlers.Register(mux, s.paths)
+}
+
+// Handler returns a new HTTP handler serving all endpoints.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	s.Register(mux)
+	return mux
+}
+
+// Service returns the MDM service including all configured middleware.
+// It is nil if the MDM handlers are disabled.
+func (s *Server) Service() service.CheckinAndCommandService {
+	return s.mdmService
+}
+
+// Pusher returns the APNs push service.
+func (s *Server) Pusher() push.Pusher {
+	return s.pushService
+}
+
+// Maintenance returns the maintenance mode switch. In maintenance mode
+// device requests are accepted with empty responses but not processed
+// (neither storing anything nor delivering commands) and API requests
+// other than GETs are refused.
+func (s *Server) Maintenance() *mdmhttp.Maintenance {
+	return s.maintenance
+}
+
+// Storage returns the server storage.
+func (s *Server) Storage() storage.AllStorage {
+	return s.store
+}
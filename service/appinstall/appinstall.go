@@ -0,0 +1,94 @@
+// Package appinstall is a NanoMDM service that tracks the results of
+// application install commands.
+package appinstall
+
+import (
+	"time"
+
+	"github.com/groob/plist"
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// AppInstall is a service that updates tracked application installs
+// from InstallApplication and InstallEnterpriseApplication command
+// results. It is intended to run alongside the core NanoMDM service
+// (i.e. with the multi service) so that enrollment IDs are resolved.
+type AppInstall struct {
+	logger log.Logger
+	store  storage.AppInstallStore
+}
+
+// New creates a new application install tracking service.
+func New(store storage.AppInstallStore, logger log.Logger) *AppInstall {
+	return &AppInstall{store: store, logger: logger}
+}
+
+// results contains the command result fields we track.
+type results struct {
+	mdm.CommandResults
+	Identifier string
+	State      string
+}
+
+// Update updates inst from raw command results.
+func Update(inst *storage.AppInstall, raw []byte) error {
+	res := new(results)
+	if err := plist.Unmarshal(raw, res); err != nil {
+		return err
+	}
+	inst.Status = res.Status
+	if res.Identifier != "" {
+		inst.Identifier = res.Identifier
+	}
+	if res.State != "" {
+		inst.State = res.State
+	}
+	if len(res.ErrorChain) > 0 {
+		inst.Error = res.ErrorChain[0].USEnglishDescription
+	} else if res.Status == "Acknowledged" {
+		inst.Error = ""
+	}
+	return nil
+}
+
+func (s *AppInstall) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	return nil
+}
+
+func (s *AppInstall) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	return nil
+}
+
+func (s *AppInstall) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	return nil
+}
+
+func (s *AppInstall) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	if results.Status == "Idle" || r.EnrollID == nil {
+		return nil, nil
+	}
+	insts, err := s.store.RetrieveAppInstalls(r.Context, []string{r.ID})
+	if err != nil {
+		return nil, err
+	}
+	for _, inst := range insts {
+		if inst.CommandUUID != results.CommandUUID {
+			continue
+		}
+		if err = Update(inst, results.Raw); err != nil {
+			return nil, err
+		}
+		inst.UpdatedAt = time.Now()
+		s.logger.Debug(
+			"msg", "app install",
+			"id", r.ID,
+			"command_uuid", results.CommandUUID,
+			"status", inst.Status,
+			"state", inst.State,
+		)
+		return nil, s.store.StoreAppInstall(r.Context, inst)
+	}
+	return nil, nil
+}
@@ -0,0 +1,325 @@
+// Package appinventory is a NanoMDM service that periodically polls
+// enrollments for their installed applications and reports changes.
+package appinventory
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/groob/plist"
+	"github.com/jessepeterson/nanomdm/cmdplist"
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/push"
+	"github.com/jessepeterson/nanomdm/service/microwebhook"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// DefaultInterval is the default polling interval.
+const DefaultInterval = 24 * time.Hour
+
+// commandUUIDPrefix identifies the commands enqueued by the poller. Only
+// their results update the app inventory as other (e.g. filtered)
+// application lists are not complete.
+const commandUUIDPrefix = "AppInventory."
+
+// Store is the storage required by the app inventory service.
+type Store interface {
+	storage.AppInventoryStore
+	storage.CommandEnqueuer
+	storage.EnrollmentLister
+}
+
+// AppInventory is a service that parses InstalledApplicationList and
+// ManagedApplicationList command results into the app inventory and
+// sends webhook events for changes. It is intended to run alongside the
+// core NanoMDM service (i.e. with the multi service) so that enrollment
+// IDs are resolved. Polling is started with Run.
+type AppInventory struct {
+	store    Store
+	pusher   push.Pusher
+	webhook  *microwebhook.MicroWebhook
+	logger   log.Logger
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending map[string]time.Time // enrollment ID to last poll
+}
+
+type Option func(*AppInventory)
+
+func WithLogger(logger log.Logger) Option {
+	return func(s *AppInventory) {
+		s.logger = logger
+	}
+}
+
+// WithPusher sends APNs pushes after enqueuing polling commands.
+func WithPusher(pusher push.Pusher) Option {
+	return func(s *AppInventory) {
+		s.pusher = pusher
+	}
+}
+
+// WithWebhook sends webhook events for app inventory changes to url.
+func WithWebhook(url string, opts ...microwebhook.Option) Option {
+	return func(s *AppInventory) {
+		s.webhook = microwebhook.New(url, opts...)
+	}
+}
+
+// WithInterval sets the interval at which each enrollment is polled.
+func WithInterval(interval time.Duration) Option {
+	return func(s *AppInventory) {
+		s.interval = interval
+	}
+}
+
+// New creates a new app inventory service.
+func New(store Store, opts ...Option) *AppInventory {
+	s := &AppInventory{
+		store:    store,
+		logger:   log.NopLogger,
+		interval: DefaultInterval,
+		pending:  make(map[string]time.Time),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Run polls enrollments every interval until ctx is done.
+func (s *AppInventory) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Poll(ctx); err != nil {
+			s.logger.Info("msg", "polling app inventory", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll enqueues InstalledApplicationList and ManagedApplicationList
+// commands to the enabled device channel enrollments that have not been
+// polled (without a response) within the interval.
+func (s *AppInventory) Poll(ctx context.Context) error {
+	enrollments, err := s.store.ListEnrollments(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var ids []string
+	s.mu.Lock()
+	for _, e := range enrollments {
+		if !e.Enabled {
+			continue
+		}
+		if e.Type != mdm.EnrollType(mdm.Device).String() && e.Type != mdm.EnrollType(mdm.UserEnrollmentDevice).String() {
+			continue
+		}
+		if last, ok := s.pending[e.ID]; ok && now.Sub(last) < s.interval {
+			continue
+		}
+		s.pending[e.ID] = now
+		ids = append(ids, e.ID)
+	}
+	s.mu.Unlock()
+	if len(ids) < 1 {
+		return nil
+	}
+	for _, requestType := range []string{"InstalledApplicationList", "ManagedApplicationList"} {
+		cmd := cmdplist.New(requestType)
+		cmd.CommandUUID = commandUUIDPrefix + requestType + "." + cmd.CommandUUID
+		mdmCmd, err := cmd.MDMCommand()
+		if err != nil {
+			return err
+		}
+		if _, err = s.store.EnqueueCommand(ctx, ids, mdmCmd); err != nil {
+			return err
+		}
+	}
+	s.logger.Debug("msg", "enqueued app inventory", "count", len(ids))
+	if s.pusher != nil {
+		if _, err = s.pusher.Push(ctx, ids); err != nil {
+			s.logger.Info("msg", "push", "err", err)
+		}
+	}
+	return nil
+}
+
+// results contains the command result fields we collect.
+type results struct {
+	InstalledApplicationList []struct {
+		Identifier   string
+		Name         string
+		Version      string
+		ShortVersion string
+	}
+	ManagedApplicationList map[string]struct {
+		Status string
+	}
+}
+
+// update returns the new app inventory from the raw results of a
+// requestType command and the existing inventory.
+func update(requestType string, raw []byte, existing []*storage.InstalledApp) ([]*storage.InstalledApp, error) {
+	res := new(results)
+	if err := plist.Unmarshal(raw, res); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var apps []*storage.InstalledApp
+	switch requestType {
+	case "InstalledApplicationList":
+		status := make(map[string]string)
+		for _, app := range existing {
+			status[app.Identifier] = app.ManagedStatus
+		}
+		for _, a := range res.InstalledApplicationList {
+			if a.Identifier == "" {
+				continue
+			}
+			apps = append(apps, &storage.InstalledApp{
+				Identifier:    a.Identifier,
+				Name:          a.Name,
+				Version:       a.Version,
+				ShortVersion:  a.ShortVersion,
+				ManagedStatus: status[a.Identifier],
+				UpdatedAt:     now,
+			})
+		}
+	case "ManagedApplicationList":
+		seen := make(map[string]bool)
+		for _, app := range existing {
+			cp := *app
+			cp.ManagedStatus = res.ManagedApplicationList[app.Identifier].Status
+			if cp.ManagedStatus != app.ManagedStatus {
+				cp.UpdatedAt = now
+			}
+			seen[app.Identifier] = true
+			apps = append(apps, &cp)
+		}
+		// managed apps not (yet) installed
+		for identifier, a := range res.ManagedApplicationList {
+			if !seen[identifier] {
+				apps = append(apps, &storage.InstalledApp{
+					Identifier:    identifier,
+					ManagedStatus: a.Status,
+					UpdatedAt:     now,
+				})
+			}
+		}
+	}
+	sort.Slice(apps, func(i, j int) bool { return apps[i].Identifier < apps[j].Identifier })
+	return apps, nil
+}
+
+// Diff returns the changes from the prev to the next app inventory.
+func Diff(prev, next []*storage.InstalledApp) *microwebhook.AppInventoryEvent {
+	ev := new(microwebhook.AppInventoryEvent)
+	prevApps := make(map[string]*storage.InstalledApp)
+	for _, app := range prev {
+		prevApps[app.Identifier] = app
+	}
+	for _, app := range next {
+		change := microwebhook.AppChange{
+			Identifier:    app.Identifier,
+			Name:          app.Name,
+			Version:       app.Version,
+			ShortVersion:  app.ShortVersion,
+			ManagedStatus: app.ManagedStatus,
+		}
+		p, ok := prevApps[app.Identifier]
+		delete(prevApps, app.Identifier)
+		if !ok {
+			ev.Added = append(ev.Added, change)
+		} else if p.Version != app.Version || p.ShortVersion != app.ShortVersion || p.ManagedStatus != app.ManagedStatus {
+			change.PreviousVersion = p.Version
+			change.PreviousShortVersion = p.ShortVersion
+			change.PreviousManagedStatus = p.ManagedStatus
+			ev.Changed = append(ev.Changed, change)
+		}
+	}
+	for _, app := range prev {
+		if _, ok := prevApps[app.Identifier]; ok {
+			ev.Removed = append(ev.Removed, microwebhook.AppChange{
+				Identifier:    app.Identifier,
+				Name:          app.Name,
+				Version:       app.Version,
+				ShortVersion:  app.ShortVersion,
+				ManagedStatus: app.ManagedStatus,
+			})
+		}
+	}
+	return ev
+}
+
+func (s *AppInventory) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	return nil
+}
+
+func (s *AppInventory) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	return nil
+}
+
+func (s *AppInventory) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	return nil
+}
+
+func (s *AppInventory) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	if !strings.HasPrefix(results.CommandUUID, commandUUIDPrefix) || r.EnrollID == nil {
+		return nil, nil
+	}
+	if results.Status == "Acknowledged" || results.Status == "Error" {
+		// allow the next poll
+		s.mu.Lock()
+		delete(s.pending, r.ID)
+		s.mu.Unlock()
+	}
+	if results.Status != "Acknowledged" {
+		return nil, nil
+	}
+	requestType := strings.SplitN(strings.TrimPrefix(results.CommandUUID, commandUUIDPrefix), ".", 2)[0]
+	prev, err := s.store.RetrieveAppInventory(r.Context, []string{r.ID})
+	if err != nil {
+		return nil, err
+	}
+	next, err := update(requestType, results.Raw, prev)
+	if err != nil {
+		return nil, err
+	}
+	for _, app := range next {
+		app.ID = r.ID
+	}
+	if err = s.store.StoreAppInventory(r.Context, r.ID, next); err != nil {
+		return nil, err
+	}
+	ev := Diff(prev, next)
+	s.logger.Debug(
+		"msg", "stored app inventory",
+		"id", r.ID,
+		"request_type", requestType,
+		"added", len(ev.Added),
+		"removed", len(ev.Removed),
+		"changed", len(ev.Changed),
+	)
+	if s.webhook == nil || len(prev) < 1 || len(ev.Added)+len(ev.Removed)+len(ev.Changed) < 1 {
+		// the first inventory of an enrollment is not a change
+		return nil, nil
+	}
+	ev.ID = r.ID
+	return nil, s.webhook.PostEvent(r.Context, &microwebhook.Event{
+		Topic:             "mdm.AppInventory",
+		CreatedAt:         time.Now(),
+		AppInventoryEvent: ev,
+	})
+}
@@ -0,0 +1,56 @@
+package appinventory
+
+import (
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+func TestUpdateAndDiff(t *testing.T) {
+	prev := []*storage.InstalledApp{
+		{Identifier: "com.example.a", Version: "1"},
+		{Identifier: "com.example.b", Version: "1"},
+	}
+	next, err := update("InstalledApplicationList", []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>InstalledApplicationList</key>
+	<array>
+		<dict><key>Identifier</key><string>com.example.a</string><key>Version</key><string>2</string></dict>
+		<dict><key>Identifier</key><string>com.example.c</string><key>Version</key><string>1</string></dict>
+	</array>
+	<key>Status</key><string>Acknowledged</string>
+</dict>
+</plist>`), prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := Diff(prev, next)
+	if len(ev.Added) != 1 || ev.Added[0].Identifier != "com.example.c" {
+		t.Errorf("unexpected added: %+v", ev.Added)
+	}
+	if len(ev.Removed) != 1 || ev.Removed[0].Identifier != "com.example.b" {
+		t.Errorf("unexpected removed: %+v", ev.Removed)
+	}
+	if len(ev.Changed) != 1 || ev.Changed[0].Version != "2" || ev.Changed[0].PreviousVersion != "1" {
+		t.Errorf("unexpected changed: %+v", ev.Changed)
+	}
+
+	managed, err := update("ManagedApplicationList", []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>ManagedApplicationList</key>
+	<dict>
+		<key>com.example.a</key><dict><key>Status</key><string>Managed</string></dict>
+	</dict>
+	<key>Status</key><string>Acknowledged</string>
+</dict>
+</plist>`), next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev = Diff(next, managed)
+	if len(ev.Added)+len(ev.Removed) != 0 || len(ev.Changed) != 1 || ev.Changed[0].ManagedStatus != "Managed" {
+		t.Errorf("unexpected managed diff: %+v", ev)
+	}
+}
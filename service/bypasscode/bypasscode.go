@@ -0,0 +1,130 @@
+// Package bypasscode escrows Activation Lock bypass codes from command
+// results.
+package bypasscode
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/groob/plist"
+	"github.com/jessepeterson/nanomdm/cryptoutil"
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/service"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// Redacted replaces bypass codes in command results passed on to the
+// next service.
+const Redacted = "REDACTED"
+
+// Escrow seals (encrypts) and stores bypass codes.
+type Escrow struct {
+	store storage.BypassCodeStore
+	key   []byte
+}
+
+// NewEscrow creates a new bypass code escrow. Codes are sealed with
+// the 32 byte key.
+func NewEscrow(store storage.BypassCodeStore, key []byte) *Escrow {
+	return &Escrow{store: store, key: key}
+}
+
+// Escrow seals and stores the bypass code of enrollment id. The code is
+// bound to id so sealed codes can not be swapped between enrollments.
+func (e *Escrow) Escrow(ctx context.Context, id, code string) error {
+	sealed, err := cryptoutil.Seal(e.key, []byte(code), []byte(id))
+	if err != nil {
+		return err
+	}
+	return e.store.StoreBypassCode(ctx, id, sealed)
+}
+
+// Retrieve retrieves and opens the bypass code of enrollment id. An
+// empty code is returned if none is escrowed.
+func (e *Escrow) Retrieve(ctx context.Context, id string) (string, error) {
+	sealed, err := e.store.RetrieveBypassCode(ctx, id)
+	if err != nil || sealed == nil {
+		return "", err
+	}
+	code, err := cryptoutil.Open(e.key, sealed, []byte(id))
+	return string(code), err
+}
+
+// BypassCode is a service middleware that escrows the bypass codes of
+// acknowledged ActivationLockBypassCode command results. The code is
+// redacted from the results before they are passed to the next service
+// so that it is never stored (or sent to webhooks) in the clear. The
+// code is escrowed after the next service resolves the enrollment ID.
+type BypassCode struct {
+	next   service.CheckinAndCommandService
+	escrow *Escrow
+	logger log.Logger
+}
+
+type Option func(*BypassCode)
+
+func WithLogger(logger log.Logger) Option {
+	return func(s *BypassCode) {
+		s.logger = logger
+	}
+}
+
+// New creates a new bypass code escrow service middleware.
+func New(next service.CheckinAndCommandService, escrow *Escrow, opts ...Option) *BypassCode {
+	s := &BypassCode{next: next, escrow: escrow, logger: log.NopLogger}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// redact returns the bypass code in raw results and the results with
+// the code redacted. An empty code is returned if none is present.
+func redact(raw []byte) (string, []byte, error) {
+	if !bytes.Contains(raw, []byte("ActivationLockBypassCode")) {
+		return "", raw, nil
+	}
+	res := new(struct{ ActivationLockBypassCode string })
+	if err := plist.Unmarshal(raw, res); err != nil || res.ActivationLockBypassCode == "" {
+		return "", raw, err
+	}
+	code := res.ActivationLockBypassCode
+	return code, bytes.ReplaceAll(raw, []byte(code), []byte(Redacted)), nil
+}
+
+func (s *BypassCode) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	return s.next.Authenticate(r, m)
+}
+
+func (s *BypassCode) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	return s.next.TokenUpdate(r, m)
+}
+
+func (s *BypassCode) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	return s.next.CheckOut(r, m)
+}
+
+func (s *BypassCode) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	if results.Status != "Acknowledged" {
+		return s.next.CommandAndReportResults(r, results)
+	}
+	code, raw, err := redact(results.Raw)
+	if err != nil || code == "" {
+		return s.next.CommandAndReportResults(r, results)
+	}
+	results.Raw = raw
+	cmd, err := s.next.CommandAndReportResults(r, results)
+	if err != nil {
+		return cmd, err
+	}
+	if r.EnrollID == nil || r.ParentID != "" {
+		// bypass codes are only reported on device channels
+		return cmd, nil
+	}
+	if err = s.escrow.Escrow(r.Context, r.ID, code); err != nil {
+		return cmd, err
+	}
+	s.logger.Info("msg", "escrowed bypass code", "id", r.ID, "command_uuid", results.CommandUUID)
+	return cmd, nil
+}
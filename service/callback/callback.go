@@ -0,0 +1,162 @@
+// Package callback is a NanoMDM service that posts command results to
+// the callback URLs of their commands.
+package callback
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/groob/plist"
+	"github.com/jessepeterson/nanomdm/bus"
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// Result is the JSON body posted to a command's callback URL.
+type Result struct {
+	CommandUUID  string                 `json:"command_uuid"`
+	ID           string                 `json:"id"`
+	UDID         string                 `json:"udid,omitempty"`
+	EnrollmentID string                 `json:"enrollment_id,omitempty"`
+	Status       string                 `json:"status"`
+	ErrorChain   []mdm.ErrorChain       `json:"error_chain,omitempty"`
+	RespondedAt  time.Time              `json:"responded_at"`
+	Result       map[string]interface{} `json:"result,omitempty"`
+}
+
+// NewResult creates a new Result from the command results of the
+// enrollment in r.
+func NewResult(r *mdm.Request, results *mdm.CommandResults) (*Result, error) {
+	result := &Result{
+		CommandUUID:  results.CommandUUID,
+		ID:           r.ID,
+		UDID:         results.UDID,
+		EnrollmentID: results.EnrollmentID,
+		Status:       results.Status,
+		ErrorChain:   results.ErrorChain,
+		RespondedAt:  time.Now(),
+	}
+	if err := plist.Unmarshal(results.Raw, &result.Result); err != nil {
+		return nil, fmt.Errorf("parsing command results: %w", err)
+	}
+	return result, nil
+}
+
+// DecodeResult decodes a Result from the JSON of a bus.CommandResult.
+func DecodeResult(payload []byte) (*Result, error) {
+	cr := new(bus.CommandResult)
+	if err := json.Unmarshal(payload, cr); err != nil {
+		return nil, err
+	}
+	results, err := mdm.DecodeCommandResults(cr.Raw)
+	if err != nil {
+		return nil, err
+	}
+	result, err := NewResult(&mdm.Request{EnrollID: &mdm.EnrollID{ID: cr.ID}}, results)
+	if err != nil {
+		return nil, err
+	}
+	result.RespondedAt = cr.ReceivedAt
+	return result, nil
+}
+
+// Callback is a service that posts the results of commands with a
+// callback URL to that URL. NotNow results are not posted as the
+// command is delivered again later. It is intended to run alongside
+// the core NanoMDM service (i.e. with the multi service) so that
+// enrollment IDs are resolved.
+type Callback struct {
+	store  storage.CommandCallbackStore
+	client *http.Client
+	logger log.Logger
+}
+
+// DefaultTimeout is the default timeout of posting a result.
+const DefaultTimeout = 30 * time.Second
+
+type Option func(*Callback)
+
+func WithLogger(logger log.Logger) Option {
+	return func(c *Callback) {
+		c.logger = logger
+	}
+}
+
+// WithClient uses client to post results.
+func WithClient(client *http.Client) Option {
+	return func(c *Callback) {
+		c.client = client
+	}
+}
+
+// New creates a new callback service.
+func New(store storage.CommandCallbackStore, opts ...Option) *Callback {
+	c := &Callback{
+		store:  store,
+		client: &http.Client{Timeout: DefaultTimeout},
+		logger: log.NopLogger,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *Callback) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	return nil
+}
+
+func (c *Callback) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	return nil
+}
+
+func (c *Callback) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	return nil
+}
+
+func (c *Callback) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	if results.Status == "Idle" || results.Status == "NotNow" || r.EnrollID == nil {
+		return nil, nil
+	}
+	ctx := r.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	url, err := c.store.RetrieveCommandCallback(ctx, r.ID, results.CommandUUID)
+	if err != nil || url == "" {
+		return nil, err
+	}
+	result, err := NewResult(r, results)
+	if err != nil {
+		return nil, err
+	}
+	c.logger.Debug("msg", "posting callback", "id", r.ID, "command_uuid", results.CommandUUID)
+	return nil, c.post(ctx, url, result)
+}
+
+// post POSTs result as JSON to url.
+func (c *Callback) post(ctx context.Context, url string, result *Result) error {
+	body, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("posting callback: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("posting callback: unexpected HTTP status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package callback
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+)
+
+type store map[string]string
+
+func (s store) StoreCommandCallback(_ context.Context, _ []string, commandUUID, url string) error {
+	s[commandUUID] = url
+	return nil
+}
+
+func (s store) RetrieveCommandCallback(_ context.Context, _, commandUUID string) (string, error) {
+	return s[commandUUID], nil
+}
+
+func TestCallback(t *testing.T) {
+	var results []*Result
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result := new(Result)
+		if err := json.NewDecoder(r.Body).Decode(result); err != nil {
+			t.Error(err)
+		}
+		results = append(results, result)
+	}))
+	defer srv.Close()
+	c := New(store{"CMD": srv.URL})
+	r := &mdm.Request{EnrollID: &mdm.EnrollID{ID: "UDID"}}
+	raw := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0"><dict>
+<key>CommandUUID</key><string>CMD</string>
+<key>Status</key><string>Acknowledged</string>
+<key>QueryResponses</key><dict><key>OSVersion</key><string>14.0</string></dict>
+</dict></plist>`)
+	for _, test := range []struct {
+		uuid, status string
+	}{
+		{"CMD", "NotNow"},
+		{"OTHER", "Acknowledged"},
+		{"CMD", "Acknowledged"},
+	} {
+		_, err := c.CommandAndReportResults(r, &mdm.CommandResults{CommandUUID: test.uuid, Status: test.status, Raw: raw})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(results) != 1 {
+		t.Fatalf("have %d callbacks, want 1", len(results))
+	}
+	q, _ := results[0].Result["QueryResponses"].(map[string]interface{})
+	if results[0].ID != "UDID" || q["OSVersion"] != "14.0" {
+		t.Errorf("unexpected callback: %+v", results[0])
+	}
+}
@@ -2,15 +2,19 @@
 package certauth
 
 import (
+	"context"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/hex"
 	"errors"
 
+	mdmhttp "github.com/jessepeterson/nanomdm/http"
 	"github.com/jessepeterson/nanomdm/log"
 	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/secevent"
 	"github.com/jessepeterson/nanomdm/service"
 	"github.com/jessepeterson/nanomdm/storage"
+	"github.com/jessepeterson/nanomdm/tokenauth"
 )
 
 var (
@@ -35,7 +39,7 @@ func normalize(e *mdm.Enrollment) *mdm.EnrollID {
 type CertAuth struct {
 	next       service.CheckinAndCommandService
 	logger     log.Logger
-	normalizer func(e *mdm.Enrollment) *mdm.EnrollID
+	normalizer func(ctx context.Context, e *mdm.Enrollment) (*mdm.EnrollID, error)
 	storage    storage.CertAuthStore
 
 	// allowDup potentially allows duplicate certificates to be used
@@ -61,6 +65,20 @@ type CertAuth struct {
 	//
 	// WARNING: This allows MDM clients to spoof other MDM clients.
 	warnOnly bool
+
+	rotator Rotator
+}
+
+// Rotator tracks MDM identity rotations. Enrollments with a rotation in
+// progress may present a new, never before seen, certificate which then
+// replaces the association of the enrollment.
+type Rotator interface {
+	// RotateIdentity replaces the certificate association of the
+	// enrollment of r with hash, the hash of the never before seen
+	// certificate of r, if the enrollment is rotating its identity to
+	// that certificate. It reports whether the association was
+	// replaced.
+	RotateIdentity(r *mdm.Request, hash string) (bool, error)
 }
 
 type Option func(*CertAuth)
@@ -71,20 +89,48 @@ func WithLogger(logger log.Logger) Option {
 	}
 }
 
+// WithEnrollIDResolver uses resolver to resolve the (device channel)
+// enrollment IDs that certificates are associated with. This should be
+// the same resolver used by the core NanoMDM service.
+func WithEnrollIDResolver(resolver service.EnrollIDResolver) Option {
+	return func(certAuth *CertAuth) {
+		certAuth.normalizer = func(ctx context.Context, e *mdm.Enrollment) (*mdm.EnrollID, error) {
+			eid, err := resolver.ResolveEnrollID(ctx, e)
+			if err != nil || eid == nil {
+				return eid, err
+			}
+			if eid.ParentID != "" {
+				return &mdm.EnrollID{ID: eid.ParentID, Type: eid.Type}, nil
+			}
+			return eid, nil
+		}
+	}
+}
+
 func WithAllowRetroactive() Option {
 	return func(certAuth *CertAuth) {
 		certAuth.allowRetroactive = true
 	}
 }
 
+// WithRotator allows enrollments with identity rotations pending in
+// rotator to be associated with new certificates.
+func WithRotator(rotator Rotator) Option {
+	return func(certAuth *CertAuth) {
+		certAuth.rotator = rotator
+	}
+}
+
 // New creates a new certificate authorization middleware service. It
 // will forward requests to next or return errors for failing authentication.
 func New(next service.CheckinAndCommandService, storage storage.CertAuthStore, opts ...Option) *CertAuth {
 	certAuth := &CertAuth{
-		next:       next,
-		logger:     log.NopLogger,
-		normalizer: normalize,
-		storage:    storage,
+		next:   next,
+		logger: log.NopLogger,
+		normalizer: func(_ context.Context, e *mdm.Enrollment) (*mdm.EnrollID, error) {
+			return normalize(e), nil
+		},
+		storage: storage,
 	}
 	for _, opt := range opts {
 		opt(certAuth)
@@ -102,14 +148,43 @@ func hashCert(cert *x509.Certificate) string {
 	return hex.EncodeToString(b)
 }
 
+// authHash returns the hash of the certificate of r or, for User
+// Enrollments authenticated with a bearer token instead, of the token
+// identity and the enrollment (see tokenauth.Identity.Hash).
+func authHash(r *mdm.Request) (string, error) {
+	if r.Certificate != nil {
+		return hashCert(r.Certificate), nil
+	}
+	id := tokenauth.FromContext(r.Context)
+	if id != nil && r.EnrollID != nil && (r.Type == mdm.UserEnrollmentDevice || r.Type == mdm.UserEnrollment) {
+		return id.Hash(r.ID), nil
+	}
+	return "", ErrMissingCert
+}
+
+// emitMismatch reports the certificate association mismatch err of
+// hash for r as a security event.
+func emitMismatch(r *mdm.Request, hash string, err error) {
+	if r.Context == nil {
+		return
+	}
+	secevent.Emit(r.Context, &secevent.Event{
+		Type:     secevent.CertAuthMismatch,
+		Source:   mdmhttp.GetRemoteAddr(r.Context),
+		IDs:      []string{r.ID},
+		CertHash: hash,
+		Reason:   err.Error(),
+	})
+}
+
 func (s *CertAuth) associateNewEnrollment(r *mdm.Request) error {
-	if r.Certificate == nil {
-		return ErrMissingCert
+	hash, err := authHash(r)
+	if err != nil {
+		return err
 	}
 	if err := r.EnrollID.Validate(); err != nil {
 		return err
 	}
-	hash := hashCert(r.Certificate)
 	if hasHash, err := s.storage.HasCertHash(r, hash); err != nil {
 		return err
 	} else if hasHash {
@@ -129,10 +204,16 @@ func (s *CertAuth) associateNewEnrollment(r *mdm.Request) error {
 				"id", r.ID,
 				"hash", hash,
 			)
+			emitMismatch(r, hash, ErrNoCertReuse)
 			if !s.warnOnly {
 				return ErrNoCertReuse
 			}
 		}
+	} else if s.rotator != nil && !s.warnOnly {
+		// an identity rotation may re-Authenticate with its new identity
+		if rotated, err := s.rotateIdentity(r, hash); err != nil || rotated {
+			return err
+		}
 	}
 	if err := s.storage.AssociateCertHash(r, hash); err != nil {
 		return err
@@ -147,18 +228,23 @@ func (s *CertAuth) associateNewEnrollment(r *mdm.Request) error {
 }
 
 func (s *CertAuth) validateAssociateExistingEnrollment(r *mdm.Request) error {
-	if r.Certificate == nil {
-		return ErrMissingCert
+	hash, err := authHash(r)
+	if err != nil {
+		return err
 	}
 	if err := r.EnrollID.Validate(); err != nil {
 		return err
 	}
-	hash := hashCert(r.Certificate)
 	if isAssoc, err := s.storage.IsCertHashAssociated(r, hash); err != nil {
 		return err
 	} else if isAssoc {
 		return nil
 	}
+	if s.rotator != nil && !s.warnOnly {
+		if rotated, err := s.rotateIdentity(r, hash); err != nil || rotated {
+			return err
+		}
+	}
 	if !s.allowRetroactive {
 		s.logger.Info(
 			"msg", "no cert association",
@@ -166,6 +252,7 @@ func (s *CertAuth) validateAssociateExistingEnrollment(r *mdm.Request) error {
 			"id", r.ID,
 			"hash", hash,
 		)
+		emitMismatch(r, hash, ErrNoCertAssoc)
 		if !s.warnOnly {
 			return ErrNoCertAssoc
 		}
@@ -182,6 +269,7 @@ func (s *CertAuth) validateAssociateExistingEnrollment(r *mdm.Request) error {
 			"enrollment", "existing",
 			"id", r.ID,
 		)
+		emitMismatch(r, hash, ErrNoCertReuse)
 		if !s.warnOnly {
 			return ErrNoCertReuse
 		}
@@ -200,6 +288,7 @@ func (s *CertAuth) validateAssociateExistingEnrollment(r *mdm.Request) error {
 			"id", r.ID,
 			"hash", hash,
 		)
+		emitMismatch(r, hash, ErrNoCertReuse)
 		if !s.warnOnly {
 			return ErrNoCertReuse
 		}
@@ -218,3 +307,23 @@ func (s *CertAuth) validateAssociateExistingEnrollment(r *mdm.Request) error {
 	)
 	return nil
 }
+
+// rotateIdentity replaces the association of the existing enrollment
+// of r with hash if it is rotating its identity and no enrollment has
+// used the certificate before.
+func (s *CertAuth) rotateIdentity(r *mdm.Request, hash string) (bool, error) {
+	if hasHash, err := s.storage.HasCertHash(r, hash); err != nil || hasHash {
+		return false, err
+	}
+	rotated, err := s.rotator.RotateIdentity(r, hash)
+	if err != nil || !rotated {
+		return false, err
+	}
+	s.logger.Info(
+		"msg", "cert associated",
+		"enrollment", "rotation",
+		"id", r.ID,
+		"hash", hash,
+	)
+	return true, nil
+}
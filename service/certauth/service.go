@@ -8,7 +8,10 @@ import (
 
 func (s *CertAuth) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
 	req := r.Clone()
-	req.EnrollID = s.normalizer(&m.Enrollment)
+	var err error
+	if req.EnrollID, err = s.normalizer(r.Context, &m.Enrollment); err != nil {
+		return fmt.Errorf("cert auth: resolving enrollment id: %w", err)
+	}
 	if err := s.associateNewEnrollment(req); err != nil {
 		return fmt.Errorf("cert auth: new enrollment: %w", err)
 	}
@@ -17,8 +20,11 @@ func (s *CertAuth) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
 
 func (s *CertAuth) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
 	req := r.Clone()
-	req.EnrollID = s.normalizer(&m.Enrollment)
-	err := s.validateAssociateExistingEnrollment(req)
+	var err error
+	if req.EnrollID, err = s.normalizer(r.Context, &m.Enrollment); err != nil {
+		return fmt.Errorf("cert auth: resolving enrollment id: %w", err)
+	}
+	err = s.validateAssociateExistingEnrollment(req)
 	if err != nil {
 		return fmt.Errorf("cert auth: existing enrollment: %w", err)
 	}
@@ -27,8 +33,11 @@ func (s *CertAuth) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
 
 func (s *CertAuth) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
 	req := r.Clone()
-	req.EnrollID = s.normalizer(&m.Enrollment)
-	err := s.validateAssociateExistingEnrollment(req)
+	var err error
+	if req.EnrollID, err = s.normalizer(r.Context, &m.Enrollment); err != nil {
+		return fmt.Errorf("cert auth: resolving enrollment id: %w", err)
+	}
+	err = s.validateAssociateExistingEnrollment(req)
 	if err != nil {
 		return fmt.Errorf("cert auth: existing enrollment: %w", err)
 	}
@@ -37,7 +46,10 @@ func (s *CertAuth) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
 
 func (s *CertAuth) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
 	req := r.Clone()
-	req.EnrollID = s.normalizer(&results.Enrollment)
+	var err error
+	if req.EnrollID, err = s.normalizer(r.Context, &results.Enrollment); err != nil {
+		return nil, fmt.Errorf("cert auth: resolving enrollment id: %w", err)
+	}
 	if err := s.validateAssociateExistingEnrollment(req); err != nil {
 		return nil, fmt.Errorf("cert auth: existing enrollment: %w", err)
 	}
@@ -0,0 +1,188 @@
+// Package compliance is a NanoMDM service that evaluates compliance
+// rules against check-in messages and command results and takes
+// remediation actions.
+package compliance
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/groob/plist"
+	"github.com/jessepeterson/nanomdm/cmdplist"
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/push"
+	"github.com/jessepeterson/nanomdm/service/microwebhook"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// Compliance is a service that evaluates rules against MDM messages.
+// Matching rules enqueue remediation commands (and push) and send a
+// webhook event. It is intended to run alongside the core NanoMDM
+// service (i.e. with the multi service) so that enrollment IDs are
+// resolved.
+type Compliance struct {
+	rules    []*Rule
+	enqueuer storage.CommandEnqueuer
+	pusher   push.Pusher
+	webhook  *microwebhook.MicroWebhook
+	logger   log.Logger
+
+	mu      sync.Mutex
+	matched map[string]time.Time // rule name and enrollment ID to last match
+}
+
+type Option func(*Compliance)
+
+func WithLogger(logger log.Logger) Option {
+	return func(c *Compliance) {
+		c.logger = logger
+	}
+}
+
+// WithPusher sends APNs pushes after enqueuing remediation commands.
+func WithPusher(pusher push.Pusher) Option {
+	return func(c *Compliance) {
+		c.pusher = pusher
+	}
+}
+
+// WithWebhook sends webhook events for rule matches to url.
+func WithWebhook(url string, opts ...microwebhook.Option) Option {
+	return func(c *Compliance) {
+		c.webhook = microwebhook.New(url, opts...)
+	}
+}
+
+// New creates a new compliance service evaluating rules.
+func New(rules []*Rule, enqueuer storage.CommandEnqueuer, opts ...Option) *Compliance {
+	c := &Compliance{
+		rules:    rules,
+		enqueuer: enqueuer,
+		logger:   log.NopLogger,
+		matched:  make(map[string]time.Time),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// cooledDown reports whether rule may act on enrollment id and, if so,
+// records the match.
+func (c *Compliance) cooledDown(rule *Rule, id string) bool {
+	if rule.Cooldown <= 0 {
+		return true
+	}
+	key := rule.Name + "\x00" + id
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if last, ok := c.matched[key]; ok && now.Sub(last) < rule.Cooldown {
+		return false
+	}
+	c.matched[key] = now
+	return true
+}
+
+// act performs the actions of rule for the enrollment in r.
+func (c *Compliance) act(r *mdm.Request, rule *Rule, e *mdm.Enrollment, raw []byte) error {
+	ctx := r.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	logger := c.logger.With("rule", rule.Name, "id", r.ID)
+	var uuids []string
+	for _, action := range rule.Enqueue {
+		cmd, err := cmdplist.Lookup(action.Command).Build(action.Args, nil)
+		if err != nil {
+			return err
+		}
+		mdmCmd, err := cmd.MDMCommand()
+		if err != nil {
+			return err
+		}
+		if _, err = c.enqueuer.EnqueueCommand(ctx, []string{r.ID}, mdmCmd); err != nil {
+			return err
+		}
+		uuids = append(uuids, mdmCmd.CommandUUID)
+		logger.Info("msg", "enqueued remediation", "command_uuid", mdmCmd.CommandUUID, "request_type", cmd.RequestType())
+	}
+	if len(uuids) > 0 && c.pusher != nil {
+		if _, err := c.pusher.Push(ctx, []string{r.ID}); err != nil {
+			logger.Info("msg", "push", "err", err)
+		}
+	}
+	if c.webhook != nil {
+		ev := &microwebhook.Event{
+			Topic:     rule.Topic,
+			CreatedAt: time.Now(),
+			ComplianceEvent: &microwebhook.ComplianceEvent{
+				Rule:         rule.Name,
+				ID:           r.ID,
+				UDID:         e.UDID,
+				EnrollmentID: e.EnrollmentID,
+				CommandUUIDs: uuids,
+				RawPayload:   raw,
+			},
+		}
+		if err := c.webhook.PostEvent(ctx, ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// evaluate evaluates the rules for message type (and status) against
+// the raw message plist.
+func (c *Compliance) evaluate(r *mdm.Request, messageType, status string, e *mdm.Enrollment, raw []byte) error {
+	if r.EnrollID == nil || r.ID == "" {
+		return nil
+	}
+	var m map[string]interface{}
+	for _, rule := range c.rules {
+		if rule.Message != messageType || (messageType == "Results" && rule.Status != status) {
+			continue
+		}
+		if m == nil {
+			if err := plist.Unmarshal(raw, &m); err != nil {
+				return err
+			}
+		}
+		matched := true
+		for i := range rule.Conditions {
+			if !rule.Conditions[i].Match(m) {
+				matched = false
+				break
+			}
+		}
+		if !matched || !c.cooledDown(rule, r.ID) {
+			continue
+		}
+		c.logger.Debug("msg", "rule matched", "rule", rule.Name, "id", r.ID)
+		if err := c.act(r, rule, e, raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Compliance) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	return c.evaluate(r, "Authenticate", "", &m.Enrollment, m.Raw)
+}
+
+func (c *Compliance) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	return c.evaluate(r, "TokenUpdate", "", &m.Enrollment, m.Raw)
+}
+
+func (c *Compliance) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	return c.evaluate(r, "CheckOut", "", &m.Enrollment, m.Raw)
+}
+
+func (c *Compliance) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	if results.Status == "Idle" {
+		return nil, nil
+	}
+	return nil, c.evaluate(r, "Results", results.Status, &results.Enrollment, results.Raw)
+}
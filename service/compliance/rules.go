@@ -0,0 +1,213 @@
+package compliance
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/cmdplist"
+	"gopkg.in/yaml.v3"
+)
+
+// Condition compares the value at a dotted key path of an MDM message
+// (e.g. "QueryResponses.OSVersion") against Value.
+//
+// Op is one of: eq, ne, lt, le, gt, ge, exists, missing, or matches.
+// The ordering operators compare dotted version strings numerically
+// (so "9.1" is less than "10.0") and fall back to string comparison.
+// The matches operator uses Value as a regular expression.
+type Condition struct {
+	Key   string `yaml:"key"`
+	Op    string `yaml:"op"`
+	Value string `yaml:"value"`
+
+	re *regexp.Regexp
+}
+
+// Action is a command to enqueue when a rule matches. Command is a
+// command name from the cmdplist catalog and Args its arguments.
+type Action struct {
+	Command string            `yaml:"command"`
+	Args    map[string]string `yaml:"args"`
+}
+
+// Rule is a compliance rule. When a message of type Message (and,
+// for command results, of Status) matches all Conditions then the
+// Enqueue commands are enqueued and a webhook event is sent with Topic.
+type Rule struct {
+	Name string `yaml:"name"`
+	// Message is "Authenticate", "TokenUpdate", "CheckOut", or
+	// "Results" (the default) for command results.
+	Message string `yaml:"message"`
+	// Status is the command result status to evaluate. Defaults to
+	// "Acknowledged".
+	Status     string      `yaml:"status"`
+	Conditions []Condition `yaml:"conditions"`
+	Enqueue    []Action    `yaml:"enqueue"`
+	// Topic is the webhook event topic. Defaults to "mdm.Compliance".
+	Topic string `yaml:"topic"`
+	// Cooldown suppresses actions for an enrollment that already
+	// matched this rule within the duration.
+	Cooldown time.Duration `yaml:"cooldown"`
+}
+
+// Compile checks condition c and compiles its regular expression. It
+// must be called before Match.
+func (c *Condition) Compile() error {
+	switch c.Op {
+	case "eq", "ne", "lt", "le", "gt", "ge", "exists", "missing":
+	case "matches":
+		var err error
+		if c.re, err = regexp.Compile(c.Value); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid op: %s", c.Op)
+	}
+	if c.Key == "" {
+		return errors.New("condition missing key")
+	}
+	return nil
+}
+
+// validate checks and fills in defaults of rule r.
+func (r *Rule) validate() error {
+	if r.Name == "" {
+		return errors.New("missing rule name")
+	}
+	switch r.Message {
+	case "":
+		r.Message = "Results"
+	case "Results", "Authenticate", "TokenUpdate", "CheckOut":
+	default:
+		return fmt.Errorf("rule %s: invalid message: %s", r.Name, r.Message)
+	}
+	if r.Status == "" {
+		r.Status = "Acknowledged"
+	}
+	if r.Topic == "" {
+		r.Topic = "mdm.Compliance"
+	}
+	if len(r.Conditions) < 1 {
+		return fmt.Errorf("rule %s: no conditions", r.Name)
+	}
+	for i := range r.Conditions {
+		if err := r.Conditions[i].Compile(); err != nil {
+			return fmt.Errorf("rule %s: %w", r.Name, err)
+		}
+	}
+	for _, a := range r.Enqueue {
+		spec := cmdplist.Lookup(a.Command)
+		if spec == nil {
+			return fmt.Errorf("rule %s: unknown command: %s", r.Name, a.Command)
+		}
+		if _, err := spec.Build(a.Args, nil); err != nil {
+			return fmt.Errorf("rule %s: %w", r.Name, err)
+		}
+	}
+	return nil
+}
+
+// LoadRules reads and validates YAML compliance rules from path. The
+// file contains a top-level "rules" list.
+func LoadRules(path string) ([]*Rule, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		Rules []*Rule `yaml:"rules"`
+	}
+	if err := yaml.Unmarshal(b, &config); err != nil {
+		return nil, fmt.Errorf("parsing rules: %w", err)
+	}
+	for _, r := range config.Rules {
+		if err := r.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return config.Rules, nil
+}
+
+// lookup returns the value at the dotted key path in m.
+func lookup(m map[string]interface{}, key string) (interface{}, bool) {
+	var v interface{} = m
+	for _, k := range strings.Split(key, ".") {
+		d, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = d[k]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// compareVersions compares dotted numeric version strings a and b.
+// It returns false for ok if either is not a dotted numeric version.
+func compareVersions(a, b string) (cmp int, ok bool) {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var an, bn int
+		var err error
+		if i < len(as) {
+			if an, err = strconv.Atoi(as[i]); err != nil {
+				return 0, false
+			}
+		}
+		if i < len(bs) {
+			if bn, err = strconv.Atoi(bs[i]); err != nil {
+				return 0, false
+			}
+		}
+		if an != bn {
+			if an < bn {
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, true
+}
+
+// Match evaluates condition c against message m.
+func (c *Condition) Match(m map[string]interface{}) bool {
+	v, ok := lookup(m, c.Key)
+	switch c.Op {
+	case "exists":
+		return ok
+	case "missing":
+		return !ok
+	}
+	if !ok {
+		return false
+	}
+	s := fmt.Sprint(v)
+	if c.Op == "matches" {
+		return c.re.MatchString(s)
+	}
+	cmp, ok := compareVersions(s, c.Value)
+	if !ok {
+		cmp = strings.Compare(s, c.Value)
+	}
+	switch c.Op {
+	case "eq":
+		return cmp == 0
+	case "ne":
+		return cmp != 0
+	case "lt":
+		return cmp < 0
+	case "le":
+		return cmp <= 0
+	case "gt":
+		return cmp > 0
+	case "ge":
+		return cmp >= 0
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package compliance
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConditionMatch(t *testing.T) {
+	m := map[string]interface{}{
+		"QueryResponses": map[string]interface{}{
+			"OSVersion":   "9.1.2",
+			"ProductName": "MacBookPro18,1",
+		},
+		"SecurityInfo": map[string]interface{}{
+			"FDE_Enabled": false,
+		},
+	}
+	for _, test := range []struct {
+		c     Condition
+		match bool
+	}{
+		{Condition{Key: "QueryResponses.OSVersion", Op: "lt", Value: "10.0"}, true},
+		{Condition{Key: "QueryResponses.OSVersion", Op: "ge", Value: "9.1"}, true},
+		{Condition{Key: "QueryResponses.OSVersion", Op: "eq", Value: "9.1.2"}, true},
+		{Condition{Key: "QueryResponses.OSVersion", Op: "gt", Value: "9.1.2"}, false},
+		{Condition{Key: "SecurityInfo.FDE_Enabled", Op: "eq", Value: "false"}, true},
+		{Condition{Key: "QueryResponses.Missing", Op: "missing"}, true},
+		{Condition{Key: "QueryResponses.Missing", Op: "ne", Value: "x"}, false},
+		{Condition{Key: "QueryResponses", Op: "exists"}, true},
+	} {
+		if have := test.c.Match(m); have != test.match {
+			t.Errorf("%+v: have %v, want %v", test.c, have, test.match)
+		}
+	}
+}
+
+func TestLoadExampleRules(t *testing.T) {
+	rules, err := LoadRules(filepath.Join("..", "..", "docs", "compliance.example.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 3 {
+		t.Fatalf("have %d rules, want 3", len(rules))
+	}
+	if rules[0].Message != "Results" || rules[0].Status != "Acknowledged" || rules[1].Topic != "mdm.Compliance" {
+		t.Errorf("defaults not applied: %+v", rules[0])
+	}
+	if rules[0].Cooldown.Hours() != 24 {
+		t.Errorf("cooldown: have %v", rules[0].Cooldown)
+	}
+
+	bad := filepath.Join(t.TempDir(), "bad.yaml")
+	os.WriteFile(bad, []byte("rules:\n  - name: x\n    conditions: [{key: a, op: nope}]\n"), 0644)
+	if _, err := LoadRules(bad); err == nil {
+		t.Error("expected error for invalid op")
+	}
+}
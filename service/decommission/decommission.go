@@ -0,0 +1,160 @@
+// Package decommission is a NanoMDM service that decommissions
+// devices: it replaces their command queue with a final command that
+// removes the MDM enrollment profile and disables the enrollment once
+// the device acknowledges it.
+package decommission
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jessepeterson/nanomdm/cmdplist"
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/push"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// commandUUIDPrefix identifies the RemoveProfile commands enqueued by
+// the service. Only their acknowledgements disable enrollments.
+const commandUUIDPrefix = "Decommission."
+
+var ErrNoProfileIdentifier = errors.New("no MDM profile identifier")
+
+// Store is the storage required by the decommission service.
+type Store interface {
+	storage.CommandEnqueuer
+	storage.CommandAndReportResultsStore
+	storage.CheckinStore
+}
+
+// Decommission is a service that decommissions devices. See Start.
+//
+// It is intended to run alongside the core NanoMDM service (i.e. with
+// the multi service) so that enrollment IDs are resolved.
+type Decommission struct {
+	store      Store
+	identifier string
+	pusher     push.Pusher
+	logger     log.Logger
+}
+
+type Option func(*Decommission)
+
+func WithLogger(logger log.Logger) Option {
+	return func(s *Decommission) {
+		s.logger = logger
+	}
+}
+
+// WithPusher sends APNs pushes after enqueuing commands.
+func WithPusher(pusher push.Pusher) Option {
+	return func(s *Decommission) {
+		s.pusher = pusher
+	}
+}
+
+// New creates a new decommission service which removes the MDM
+// enrollment profile with the PayloadIdentifier identifier.
+func New(store Store, identifier string, opts ...Option) *Decommission {
+	s := &Decommission{store: store, identifier: identifier, logger: log.NopLogger}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// IsCommand reports whether uuid is a command UUID of the service.
+func IsCommand(uuid string) bool {
+	return strings.HasPrefix(uuid, commandUUIDPrefix)
+}
+
+// Start decommissions the device channel enrollments ids: their queues
+// (and those of their user channels) are cleared, a RemoveProfile
+// command of the MDM enrollment profile is enqueued as the only
+// command, and the devices are pushed. If storage implements
+// storage.QueueReplaceStore the queue is cleared and the command is
+// enqueued atomically. The enrollments are disabled when the command
+// is acknowledged. It returns the command UUIDs and errors by
+// enrollment ID.
+func (s *Decommission) Start(ctx context.Context, ids []string) (map[string]string, map[string]error, error) {
+	if s.identifier == "" {
+		return nil, nil, ErrNoProfileIdentifier
+	}
+	uuids := make(map[string]string)
+	idErrs := make(map[string]error)
+	var sent []string
+	for _, id := range ids {
+		cmd := cmdplist.New("RemoveProfile").Set("Identifier", s.identifier)
+		cmd.CommandUUID = commandUUIDPrefix + cmd.CommandUUID
+		mdmCmd, err := cmd.MDMCommand()
+		if err != nil {
+			return uuids, idErrs, err
+		}
+		if err = s.replaceQueue(ctx, id, mdmCmd); err != nil {
+			idErrs[id] = err
+			continue
+		}
+		s.logger.Info("msg", "decommissioning", "id", id, "command_uuid", cmd.CommandUUID)
+		uuids[id] = cmd.CommandUUID
+		sent = append(sent, id)
+	}
+	if len(sent) > 0 && s.pusher != nil {
+		if _, err := s.pusher.Push(ctx, sent); err != nil {
+			s.logger.Info("msg", "push", "err", err)
+		}
+	}
+	return uuids, idErrs, nil
+}
+
+// replaceQueue clears the queue of id and enqueues cmd.
+func (s *Decommission) replaceQueue(ctx context.Context, id string, cmd *mdm.Command) error {
+	r := &mdm.Request{EnrollID: &mdm.EnrollID{ID: id, Type: mdm.Device}, Context: ctx}
+	if rs, ok := s.store.(storage.QueueReplaceStore); ok {
+		return rs.ReplaceQueue(r, cmd)
+	}
+	if err := s.store.ClearQueue(r); err != nil {
+		return fmt.Errorf("clearing queue: %w", err)
+	}
+	idErrs, err := s.store.EnqueueCommand(ctx, []string{id}, cmd)
+	if err == nil {
+		err = idErrs[id]
+	}
+	return err
+}
+
+func (s *Decommission) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	return nil
+}
+
+func (s *Decommission) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	return nil
+}
+
+func (s *Decommission) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	return nil
+}
+
+// CommandAndReportResults disables the enrollment of r when it
+// acknowledges the RemoveProfile command of its decommissioning.
+func (s *Decommission) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	if !IsCommand(results.CommandUUID) || r.EnrollID == nil || r.ParentID != "" {
+		return nil, nil
+	}
+	logs := []interface{}{"msg", "decommissioning", "id", r.ID, "command_uuid", results.CommandUUID, "status", results.Status}
+	switch results.Status {
+	case "Acknowledged":
+		if err := s.store.Disable(r); err != nil {
+			return nil, fmt.Errorf("disabling: %w", err)
+		}
+		s.logger.Info(append(logs, "disabled", true)...)
+	case "Error":
+		if len(results.ErrorChain) > 0 {
+			logs = append(logs, "err", results.ErrorChain[0].USEnglishDescription)
+		}
+		s.logger.Info(logs...)
+	}
+	return nil, nil
+}
@@ -0,0 +1,70 @@
+package decommission
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/cmdplist"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage/file"
+	"github.com/jessepeterson/nanomdm/storage/storagetest"
+)
+
+func TestDecommission(t *testing.T) {
+	ctx := context.Background()
+	store, err := file.New(t.TempDir(), file.WithSyncInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const id = "DEC0"
+	r, err := storagetest.Enroll(ctx, store, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued, err := cmdplist.New("DeviceInformation").MDMCommand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.EnqueueCommand(ctx, []string{id}, queued); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err = New(store, "").Start(ctx, []string{id}); err != ErrNoProfileIdentifier {
+		t.Fatalf("have %v, want %v", err, ErrNoProfileIdentifier)
+	}
+
+	s := New(store, "com.example.mdm")
+	uuids, idErrs, err := s.Start(ctx, []string{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idErrs) > 0 {
+		t.Fatal(idErrs)
+	}
+
+	// the queue holds only the RemoveProfile command
+	cmd, err := store.RetrieveNextCommand(r, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd == nil || cmd.CommandUUID != uuids[id] || cmd.Command.RequestType != "RemoveProfile" {
+		t.Fatalf("unexpected next command: %v", cmd)
+	}
+
+	// other commands do not disable
+	results := &mdm.CommandResults{CommandUUID: queued.CommandUUID, Status: "Acknowledged"}
+	if _, err = s.CommandAndReportResults(r, results); err != nil {
+		t.Fatal(err)
+	}
+	if enabled, err := store.EnrollmentEnabled(ctx, id); err != nil || !enabled {
+		t.Fatalf("enabled: have %v (err %v), want true", enabled, err)
+	}
+
+	results.CommandUUID = cmd.CommandUUID
+	if _, err = s.CommandAndReportResults(r, results); err != nil {
+		t.Fatal(err)
+	}
+	if enabled, err := store.EnrollmentEnabled(ctx, id); err != nil || enabled {
+		t.Fatalf("enabled: have %v (err %v), want false", enabled, err)
+	}
+}
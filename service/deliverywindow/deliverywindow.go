@@ -0,0 +1,418 @@
+// Package deliverywindow restricts the delivery of non-urgent commands
+// (and the pushes for them) to enrollments to configured, timezone-aware
+// windows of time. This keeps user-disruptive commands (like restarts)
+// out of working hours.
+package deliverywindow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/push"
+	nanosvc "github.com/jessepeterson/nanomdm/service/nanomdm"
+	"github.com/jessepeterson/nanomdm/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultMembersTTL is the default time group members of windows are
+// cached for.
+const DefaultMembersTTL = time.Minute
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Window is a recurring window of time during which non-urgent commands
+// are delivered to the enrollments and members of the groups of the
+// window.
+type Window struct {
+	Name string `yaml:"name"`
+	// IANA time zone name of Start and End (default UTC)
+	Timezone string `yaml:"timezone"`
+	// days of the week (e.g. mon or monday) the window starts on
+	// (default every day)
+	Days []string `yaml:"days"`
+	// HH:MM times of day. The window ends the next day if End is before
+	// Start and lasts all day if they are equal.
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+
+	Enrollments []string `yaml:"enrollments"`
+	Groups      []string `yaml:"groups"`
+
+	loc        *time.Location
+	days       map[time.Weekday]bool
+	start, end int // minutes after midnight
+}
+
+// parseClock parses a HH:MM time of day into minutes after midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day: %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (w *Window) validate() error {
+	if w.Name == "" {
+		return errors.New("window missing name")
+	}
+	if len(w.Enrollments) < 1 && len(w.Groups) < 1 {
+		return fmt.Errorf("window %s: no enrollments or groups", w.Name)
+	}
+	var err error
+	if w.loc, err = time.LoadLocation(w.Timezone); err != nil {
+		return fmt.Errorf("window %s: %w", w.Name, err)
+	}
+	if w.start, err = parseClock(w.Start); err != nil {
+		return fmt.Errorf("window %s: start: %w", w.Name, err)
+	}
+	if w.end, err = parseClock(w.End); err != nil {
+		return fmt.Errorf("window %s: end: %w", w.Name, err)
+	}
+	if len(w.Days) > 0 {
+		w.days = make(map[time.Weekday]bool)
+	}
+	for _, day := range w.Days {
+		lower := strings.ToLower(day)
+		d, ok := weekdays[lower]
+		if !ok && len(lower) > 3 {
+			// full day names
+			d, ok = weekdays[lower[:3]]
+			ok = ok && lower == strings.ToLower(d.String())
+		}
+		if !ok {
+			return fmt.Errorf("window %s: invalid day: %q", w.Name, day)
+		}
+		w.days[d] = true
+	}
+	return nil
+}
+
+// startsOn reports whether the window starts on day.
+func (w *Window) startsOn(day time.Weekday) bool {
+	return w.days == nil || w.days[day]
+}
+
+// Open reports whether the window is open at t.
+func (w *Window) Open(t time.Time) bool {
+	t = t.In(w.loc)
+	m := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	switch {
+	case w.start == w.end:
+		return w.startsOn(day)
+	case w.start < w.end:
+		return w.startsOn(day) && m >= w.start && m < w.end
+	case m >= w.start:
+		return w.startsOn(day)
+	case m < w.end:
+		// the window started the day before
+		return w.startsOn((day + 6) % 7)
+	}
+	return false
+}
+
+// Config configures delivery windows.
+type Config struct {
+	// request types delivered (and pushed for) outside of windows
+	Urgent  []string  `yaml:"urgent"`
+	Windows []*Window `yaml:"windows"`
+}
+
+// Load reads and validates the YAML delivery windows config at path.
+func Load(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := new(Config)
+	if err := yaml.Unmarshal(b, config); err != nil {
+		return nil, fmt.Errorf("parsing delivery windows: %w", err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (c *Config) validate() error {
+	names := make(map[string]bool)
+	for _, w := range c.Windows {
+		if err := w.validate(); err != nil {
+			return err
+		}
+		if names[w.Name] {
+			return fmt.Errorf("duplicate window: %s", w.Name)
+		}
+		names[w.Name] = true
+	}
+	return nil
+}
+
+// Store is the storage required by delivery windows.
+type Store interface {
+	storage.GroupStore
+	storage.NextCommandsStore
+}
+
+// Windows decides whether commands are delivered to (and pushes sent
+// to) enrollments based on their delivery windows. Enrollments in no
+// window always receive commands. Enrollments in several windows
+// receive commands when any of them is open.
+type Windows struct {
+	store      Store
+	logger     log.Logger
+	urgent     map[string]bool
+	windows    []*Window
+	byID       map[string][]*Window // windows of enrollments by ID
+	membersTTL time.Duration
+	now        func() time.Time
+
+	mu        sync.Mutex
+	members   map[string][]*Window // windows of group members by ID
+	membersAt time.Time
+	deferred  map[string]bool // enrollments to push once open
+}
+
+type Option func(*Windows)
+
+// WithLogger sets the logger.
+func WithLogger(logger log.Logger) Option {
+	return func(w *Windows) {
+		w.logger = logger
+	}
+}
+
+// WithMembersTTL sets how long group members of windows are cached for.
+func WithMembersTTL(ttl time.Duration) Option {
+	return func(w *Windows) {
+		w.membersTTL = ttl
+	}
+}
+
+// New creates delivery windows from a validated config (see Load).
+func New(config *Config, store Store, opts ...Option) *Windows {
+	w := &Windows{
+		store:      store,
+		logger:     log.NopLogger,
+		urgent:     make(map[string]bool),
+		windows:    config.Windows,
+		byID:       make(map[string][]*Window),
+		membersTTL: DefaultMembersTTL,
+		now:        time.Now,
+		deferred:   make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	for _, requestType := range config.Urgent {
+		w.urgent[requestType] = true
+	}
+	for _, window := range config.Windows {
+		for _, id := range window.Enrollments {
+			w.byID[id] = append(w.byID[id], window)
+		}
+	}
+	return w
+}
+
+// groupMembers returns the windows of group members by ID, retrieving
+// them if the cache is stale. w.mu must be held.
+func (w *Windows) groupMembers(ctx context.Context) (map[string][]*Window, error) {
+	if w.members != nil && w.now().Sub(w.membersAt) < w.membersTTL {
+		return w.members, nil
+	}
+	members := make(map[string][]*Window)
+	for _, window := range w.windows {
+		for _, group := range window.Groups {
+			ids, err := w.store.RetrieveGroupMembers(ctx, group)
+			if errors.Is(err, storage.ErrNotFound) {
+				continue
+			} else if err != nil {
+				return nil, fmt.Errorf("retrieving members of group %s: %w", group, err)
+			}
+			for _, id := range ids {
+				members[id] = append(members[id], window)
+			}
+		}
+	}
+	w.members, w.membersAt = members, w.now()
+	return members, nil
+}
+
+// open reports whether enrollment id is in no window or any of its
+// windows is open. w.mu must be held.
+func (w *Windows) open(ctx context.Context, id string) (bool, error) {
+	members, err := w.groupMembers(ctx)
+	if err != nil {
+		return false, err
+	}
+	if len(w.byID[id]) < 1 && len(members[id]) < 1 {
+		return true, nil
+	}
+	now := w.now()
+	for _, windows := range [][]*Window{w.byID[id], members[id]} {
+		for _, window := range windows {
+			if window.Open(now) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// Open reports whether non-urgent commands may be delivered to
+// enrollment id now.
+func (w *Windows) Open(ctx context.Context, id string) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.open(ctx, id)
+}
+
+// policy restricts the commands chosen by a wrapped policy to urgent
+// commands outside of delivery windows.
+type policy struct {
+	w    *Windows
+	next nanosvc.QueuePolicy
+}
+
+// Policy returns a queue policy choosing among the next commands with
+// next. Outside of the delivery windows of the enrollment only urgent
+// commands are considered and the enrollment is pushed once a window
+// opens (see Run). Errors determining the windows are logged and the
+// commands are delivered.
+func (w *Windows) Policy(next nanosvc.QueuePolicy) nanosvc.QueuePolicy {
+	return &policy{w: w, next: next}
+}
+
+func (p *policy) SelectCommand(r *mdm.Request, cmds []*mdm.Command) *mdm.Command {
+	ctx := r.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	p.w.mu.Lock()
+	defer p.w.mu.Unlock()
+	open, err := p.w.open(ctx, r.ID)
+	if err != nil {
+		p.w.logger.Info("msg", "delivery window", "id", r.ID, "err", err)
+		open = true
+	}
+	if open {
+		return p.next.SelectCommand(r, cmds)
+	}
+	var urgent []*mdm.Command
+	for _, cmd := range cmds {
+		if p.w.urgent[cmd.Command.RequestType] {
+			urgent = append(urgent, cmd)
+		}
+	}
+	if len(urgent) < 1 {
+		p.w.deferred[r.ID] = true
+		return nil
+	}
+	return p.next.SelectCommand(r, urgent)
+}
+
+// urgentQueued reports whether any of the next queued commands of
+// enrollment id are urgent.
+func (w *Windows) urgentQueued(ctx context.Context, id string) (bool, error) {
+	if len(w.urgent) < 1 {
+		return false, nil
+	}
+	r := &mdm.Request{Context: ctx, EnrollID: &mdm.EnrollID{ID: id}}
+	cmds, err := w.store.RetrieveNextCommands(r, false, nanosvc.DefaultQueueWindow)
+	if err != nil {
+		return false, err
+	}
+	for _, cmd := range cmds {
+		if w.urgent[cmd.Command.RequestType] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Defer returns the IDs of enrollments outside of their delivery
+// windows without urgent commands queued. Their pushes are deferred
+// until a window opens (see Run). Errors are logged and do not defer.
+func (w *Windows) Defer(ctx context.Context, ids []string) map[string]bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var deferred map[string]bool
+	for _, id := range ids {
+		open, err := w.open(ctx, id)
+		if err == nil && !open {
+			var urgent bool
+			urgent, err = w.urgentQueued(ctx, id)
+			open = urgent
+		}
+		if err != nil {
+			w.logger.Info("msg", "delivery window", "id", id, "err", err)
+			continue
+		}
+		if open {
+			continue
+		}
+		if deferred == nil {
+			deferred = make(map[string]bool)
+		}
+		deferred[id] = true
+		w.deferred[id] = true
+	}
+	return deferred
+}
+
+// Released returns the sorted IDs of deferred enrollments (by Defer or
+// the queue policy) whose delivery windows are now open. They are only
+// returned once.
+func (w *Windows) Released(ctx context.Context) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var ids []string
+	for id := range w.deferred {
+		open, err := w.open(ctx, id)
+		if err != nil {
+			w.logger.Info("msg", "delivery window", "id", id, "err", err)
+			return nil
+		}
+		if open {
+			ids = append(ids, id)
+			delete(w.deferred, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Run pushes released enrollments (see Released) every interval until
+// ctx is done so that they connect for their queued commands.
+func (w *Windows) Run(ctx context.Context, pusher push.Pusher, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		ids := w.Released(ctx)
+		if len(ids) < 1 {
+			continue
+		}
+		w.logger.Debug("msg", "pushing enrollments in open delivery windows", "id_count", len(ids))
+		if _, err := pusher.Push(ctx, ids); err != nil {
+			w.logger.Info("msg", "push", "err", err)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package deliverywindow
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+	nanosvc "github.com/jessepeterson/nanomdm/service/nanomdm"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+func TestLoadExample(t *testing.T) {
+	config, err := Load(filepath.Join("..", "..", "docs", "deliverywindows.example.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Windows) != 2 || len(config.Urgent) < 1 {
+		t.Errorf("unexpected config: %+v", config)
+	}
+}
+
+func TestWindowOpen(t *testing.T) {
+	for _, test := range []struct {
+		window *Window
+		time   string
+		want   bool
+	}{
+		// Tuesday 20:30 UTC is 22:30 in Berlin (CEST)
+		{&Window{Timezone: "Europe/Berlin", Start: "22:00", End: "06:00"}, "2024-06-04T20:30:00Z", true},
+		{&Window{Timezone: "Europe/Berlin", Start: "22:00", End: "06:00"}, "2024-06-04T19:30:00Z", false},
+		{&Window{Timezone: "Europe/Berlin", Start: "22:00", End: "06:00"}, "2024-06-05T03:59:00Z", true},
+		{&Window{Timezone: "Europe/Berlin", Start: "22:00", End: "06:00"}, "2024-06-05T04:00:00Z", false},
+		// started Tuesday night
+		{&Window{Start: "22:00", End: "06:00", Days: []string{"tue"}}, "2024-06-05T01:00:00Z", true},
+		{&Window{Start: "22:00", End: "06:00", Days: []string{"tue"}}, "2024-06-04T01:00:00Z", false},
+		{&Window{Start: "09:00", End: "17:00", Days: []string{"Saturday", "sun"}}, "2024-06-08T12:00:00Z", true},
+		{&Window{Start: "09:00", End: "17:00", Days: []string{"Saturday", "sun"}}, "2024-06-07T12:00:00Z", false},
+		{&Window{Start: "00:00", End: "00:00", Days: []string{"wed"}}, "2024-06-05T23:59:00Z", true},
+		{&Window{Start: "00:00", End: "00:00", Days: []string{"wed"}}, "2024-06-06T00:00:00Z", false},
+	} {
+		test.window.Name, test.window.Enrollments = "test", []string{"UDID"}
+		if err := test.window.validate(); err != nil {
+			t.Fatal(err)
+		}
+		now, err := time.Parse(time.RFC3339, test.time)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have := test.window.Open(now); have != test.want {
+			t.Errorf("%s-%s %v at %s: have %v, want %v", test.window.Start, test.window.End, test.window.Days, test.time, have, test.want)
+		}
+	}
+}
+
+func TestWindowInvalid(t *testing.T) {
+	for _, w := range []*Window{
+		{Name: "no-targets", Start: "22:00", End: "06:00"},
+		{Name: "timezone", Timezone: "Mars/Olympus", Start: "22:00", End: "06:00", Groups: []string{"g"}},
+		{Name: "start", Start: "25:00", End: "06:00", Groups: []string{"g"}},
+		{Name: "day", Start: "22:00", End: "06:00", Days: []string{"monkey"}, Groups: []string{"g"}},
+	} {
+		if err := w.validate(); err == nil {
+			t.Errorf("%s: expected error", w.Name)
+		}
+	}
+}
+
+// fakeStore has a group and queued commands.
+type fakeStore struct {
+	storage.GroupStore
+	storage.NextCommandsStore
+	members []string
+	queued  map[string][]*mdm.Command
+}
+
+func (s *fakeStore) RetrieveGroupMembers(_ context.Context, name string) ([]string, error) {
+	if name != "lab" {
+		return nil, storage.ErrNotFound
+	}
+	return s.members, nil
+}
+
+func (s *fakeStore) RetrieveNextCommands(r *mdm.Request, _ bool, _ int) ([]*mdm.Command, error) {
+	return s.queued[r.ID], nil
+}
+
+func command(uuid, requestType string) *mdm.Command {
+	cmd := &mdm.Command{CommandUUID: uuid}
+	cmd.Command.RequestType = requestType
+	return cmd
+}
+
+func TestWindows(t *testing.T) {
+	config := &Config{
+		Urgent: []string{"DeviceLock"},
+		Windows: []*Window{
+			{Name: "night", Start: "22:00", End: "06:00", Enrollments: []string{"A"}, Groups: []string{"lab", "missing"}},
+		},
+	}
+	if err := config.validate(); err != nil {
+		t.Fatal(err)
+	}
+	restart, lock := command("1", "RestartDevice"), command("2", "DeviceLock")
+	store := &fakeStore{
+		members: []string{"B"},
+		queued:  map[string][]*mdm.Command{"A": {restart, lock}, "B": {restart}, "C": {restart}},
+	}
+	w := New(config, store)
+	now := time.Date(2024, 6, 4, 12, 0, 0, 0, time.UTC)
+	w.now = func() time.Time { return now }
+	policy := w.Policy(nanosvc.FIFOPolicy)
+	ctx := context.Background()
+
+	selected := func(id string) string {
+		r := &mdm.Request{Context: ctx, EnrollID: &mdm.EnrollID{ID: id}}
+		if cmd := policy.SelectCommand(r, store.queued[id]); cmd != nil {
+			return cmd.CommandUUID
+		}
+		return ""
+	}
+
+	// closed: only urgent commands
+	if have, want := selected("A"), "2"; have != want {
+		t.Errorf("A: have %q, want %q", have, want)
+	}
+	if have, want := selected("B"), ""; have != want {
+		t.Errorf("B: have %q, want %q", have, want)
+	}
+	if have, want := selected("C"), "1"; have != want {
+		t.Errorf("C (no window): have %q, want %q", have, want)
+	}
+
+	// A has an urgent command queued
+	deferred := w.Defer(ctx, []string{"A", "B", "C"})
+	if len(deferred) != 1 || !deferred["B"] {
+		t.Errorf("unexpected deferred: %v", deferred)
+	}
+	if ids := w.Released(ctx); len(ids) > 0 {
+		t.Errorf("released while closed: %v", ids)
+	}
+
+	// open
+	now = time.Date(2024, 6, 4, 23, 0, 0, 0, time.UTC)
+	if have, want := selected("A"), "1"; have != want {
+		t.Errorf("A: have %q, want %q", have, want)
+	}
+	if ids := w.Released(ctx); len(ids) != 1 || ids[0] != "B" {
+		t.Errorf("unexpected released: %v", ids)
+	}
+	if ids := w.Released(ctx); len(ids) > 0 {
+		t.Errorf("released twice: %v", ids)
+	}
+}
@@ -0,0 +1,410 @@
+// Package devicepassword is a NanoMDM service that manages (sets,
+// verifies, and rotates) recovery lock and firmware passwords.
+package devicepassword
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/groob/plist"
+	"github.com/jessepeterson/nanomdm/cmdplist"
+	"github.com/jessepeterson/nanomdm/cryptoutil"
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/push"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// Kinds of device passwords.
+const (
+	KindRecoveryLock = "recovery_lock"
+	KindFirmware     = "firmware"
+)
+
+// Statuses of device passwords.
+const (
+	StatusPending = "pending"
+	StatusSet     = "set"
+	StatusCleared = "cleared"
+	StatusFailed  = "failed"
+)
+
+var (
+	ErrInvalidKind = errors.New("invalid device password kind")
+	ErrPending     = errors.New("device password change pending")
+	ErrNoPassword  = errors.New("no device password set")
+)
+
+// passwordChars are the characters of generated passwords. Both
+// recovery lock and firmware passwords are limited to ASCII.
+const passwordChars = "ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz23456789"
+
+// passwordLength is the length of generated passwords.
+const passwordLength = 20
+
+// Store is the storage required by the device password service.
+type Store interface {
+	storage.DevicePasswordStore
+	storage.CommandEnqueuer
+}
+
+// DevicePassword is a service that tracks the results of the set and
+// verify commands it enqueues. Passwords are generated randomly and
+// sealed (encrypted) before storage. It is intended to run alongside
+// the core NanoMDM service (i.e. with the multi service) so that
+// enrollment IDs are resolved. Scheduled rotation is started with Run.
+//
+// Note the passwords are necessarily in the clear in the enqueued
+// commands.
+type DevicePassword struct {
+	store    Store
+	key      []byte
+	pusher   push.Pusher
+	logger   log.Logger
+	rotation time.Duration
+}
+
+type Option func(*DevicePassword)
+
+func WithLogger(logger log.Logger) Option {
+	return func(s *DevicePassword) {
+		s.logger = logger
+	}
+}
+
+// WithPusher sends APNs pushes after enqueuing commands.
+func WithPusher(pusher push.Pusher) Option {
+	return func(s *DevicePassword) {
+		s.pusher = pusher
+	}
+}
+
+// WithRotation rotates set passwords older than interval.
+func WithRotation(interval time.Duration) Option {
+	return func(s *DevicePassword) {
+		s.rotation = interval
+	}
+}
+
+// New creates a new device password service. Passwords are sealed
+// with the 32 byte key.
+func New(store Store, key []byte, opts ...Option) *DevicePassword {
+	s := &DevicePassword{store: store, key: key, logger: log.NopLogger}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// GeneratePassword returns a new random password.
+func GeneratePassword() (string, error) {
+	b := make([]byte, passwordLength)
+	max := big.NewInt(int64(len(passwordChars)))
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = passwordChars[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// additionalData binds sealed passwords to their enrollment and kind.
+func additionalData(id, kind string) []byte {
+	return []byte(id + "\x00" + kind)
+}
+
+func (s *DevicePassword) seal(id, kind, password string) ([]byte, error) {
+	return cryptoutil.Seal(s.key, []byte(password), additionalData(id, kind))
+}
+
+func (s *DevicePassword) open(pw *storage.DevicePassword) (string, error) {
+	if len(pw.SealedPassword) < 1 {
+		return "", nil
+	}
+	b, err := cryptoutil.Open(s.key, pw.SealedPassword, additionalData(pw.ID, pw.Kind))
+	return string(b), err
+}
+
+func validKind(kind string) bool {
+	return kind == KindRecoveryLock || kind == KindFirmware
+}
+
+// List retrieves the device passwords of ids of kind.
+func (s *DevicePassword) List(ctx context.Context, ids []string, kind string) ([]*storage.DevicePassword, error) {
+	return s.store.RetrieveDevicePasswords(ctx, ids, kind)
+}
+
+// Reveal retrieves and opens the device password of id of kind.
+func (s *DevicePassword) Reveal(ctx context.Context, id, kind string) (string, error) {
+	if !validKind(kind) {
+		return "", ErrInvalidKind
+	}
+	pws, err := s.store.RetrieveDevicePasswords(ctx, []string{id}, kind)
+	if err != nil {
+		return "", err
+	}
+	if len(pws) < 1 || len(pws[0].SealedPassword) < 1 {
+		return "", ErrNoPassword
+	}
+	return s.open(pws[0])
+}
+
+// existing retrieves the device passwords of ids of kind by id.
+func (s *DevicePassword) existing(ctx context.Context, ids []string, kind string) (map[string]*storage.DevicePassword, error) {
+	pws, err := s.store.RetrieveDevicePasswords(ctx, ids, kind)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]*storage.DevicePassword)
+	for _, pw := range pws {
+		m[pw.ID] = pw
+	}
+	return m, nil
+}
+
+// send enqueues the command built by build for each of ids, stores the
+// updated device password, and pushes to the enrollments. It returns
+// the command UUIDs and errors by enrollment ID.
+func (s *DevicePassword) send(ctx context.Context, ids []string, kind string, build func(pw *storage.DevicePassword) (*cmdplist.Command, error)) (map[string]string, map[string]error, error) {
+	existing, err := s.existing(ctx, ids, kind)
+	if err != nil {
+		return nil, nil, err
+	}
+	uuids := make(map[string]string)
+	idErrs := make(map[string]error)
+	var sent []string
+	for _, id := range ids {
+		pw := existing[id]
+		if pw == nil {
+			pw = &storage.DevicePassword{ID: id, Kind: kind}
+		}
+		if pw.Status == StatusPending {
+			idErrs[id] = ErrPending
+			continue
+		}
+		cmd, err := build(pw)
+		if err != nil {
+			idErrs[id] = err
+			continue
+		}
+		mdmCmd, err := cmd.MDMCommand()
+		if err != nil {
+			return uuids, idErrs, err
+		}
+		if _, err = s.store.EnqueueCommand(ctx, []string{id}, mdmCmd); err != nil {
+			idErrs[id] = err
+			continue
+		}
+		pw.CommandUUID = cmd.CommandUUID
+		pw.Error = ""
+		pw.UpdatedAt = time.Now()
+		if err = s.store.StoreDevicePassword(ctx, pw); err != nil {
+			return uuids, idErrs, err
+		}
+		uuids[id] = cmd.CommandUUID
+		sent = append(sent, id)
+	}
+	if len(sent) > 0 && s.pusher != nil {
+		if _, err = s.pusher.Push(ctx, sent); err != nil {
+			s.logger.Info("msg", "push", "err", err)
+		}
+	}
+	return uuids, idErrs, nil
+}
+
+// Set sets a new random device password of kind on ids (or clears the
+// device password if clear is true).
+func (s *DevicePassword) Set(ctx context.Context, ids []string, kind string, clear bool) (map[string]string, map[string]error, error) {
+	if !validKind(kind) {
+		return nil, nil, ErrInvalidKind
+	}
+	return s.send(ctx, ids, kind, func(pw *storage.DevicePassword) (*cmdplist.Command, error) {
+		current, err := s.open(pw)
+		if err != nil {
+			return nil, err
+		}
+		var password string
+		pw.SealedPending = nil
+		if !clear {
+			if password, err = GeneratePassword(); err != nil {
+				return nil, err
+			}
+			if pw.SealedPending, err = s.seal(pw.ID, kind, password); err != nil {
+				return nil, err
+			}
+		}
+		pw.Status = StatusPending
+		pw.Verified = nil
+		if kind == KindFirmware {
+			return cmdplist.NewSetFirmwarePassword(current, password, false), nil
+		}
+		return cmdplist.NewSetRecoveryLock(current, password), nil
+	})
+}
+
+// Verify verifies the device password of kind on ids.
+func (s *DevicePassword) Verify(ctx context.Context, ids []string, kind string) (map[string]string, map[string]error, error) {
+	if !validKind(kind) {
+		return nil, nil, ErrInvalidKind
+	}
+	return s.send(ctx, ids, kind, func(pw *storage.DevicePassword) (*cmdplist.Command, error) {
+		current, err := s.open(pw)
+		if err != nil {
+			return nil, err
+		} else if current == "" {
+			return nil, ErrNoPassword
+		}
+		if kind == KindFirmware {
+			return cmdplist.NewVerifyFirmwarePassword(current), nil
+		}
+		return cmdplist.NewVerifyRecoveryLock(current), nil
+	})
+}
+
+// Rotate sets new passwords for set (or failed) device passwords that
+// were last rotated before the rotation interval.
+func (s *DevicePassword) Rotate(ctx context.Context) error {
+	pws, err := s.store.RetrieveDevicePasswords(ctx, nil, "")
+	if err != nil {
+		return err
+	}
+	due := make(map[string][]string)
+	for _, pw := range pws {
+		if pw.Status != StatusSet && pw.Status != StatusFailed {
+			continue
+		}
+		if len(pw.SealedPassword) < 1 || (pw.RotatedAt != nil && time.Since(*pw.RotatedAt) < s.rotation) {
+			continue
+		}
+		due[pw.Kind] = append(due[pw.Kind], pw.ID)
+	}
+	for kind, ids := range due {
+		_, idErrs, err := s.Set(ctx, ids, kind, false)
+		if err != nil {
+			return err
+		}
+		for id, err := range idErrs {
+			s.logger.Info("msg", "rotating device password", "id", id, "kind", kind, "err", err)
+		}
+		s.logger.Debug("msg", "rotated device passwords", "kind", kind, "count", len(ids)-len(idErrs))
+	}
+	return nil
+}
+
+// Run rotates device passwords until ctx is done. Nothing is rotated
+// if no rotation interval is set.
+func (s *DevicePassword) Run(ctx context.Context) {
+	if s.rotation <= 0 {
+		return
+	}
+	check := time.Hour
+	if s.rotation < check {
+		check = s.rotation
+	}
+	ticker := time.NewTicker(check)
+	defer ticker.Stop()
+	for {
+		if err := s.Rotate(ctx); err != nil {
+			s.logger.Info("msg", "rotating device passwords", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// passwordResults contains the command result fields we track.
+type passwordResults struct {
+	PasswordVerified    *bool
+	SetFirmwarePassword *struct {
+		PasswordChanged bool
+	}
+}
+
+// Apply updates pw from the raw results of its last command.
+func Apply(pw *storage.DevicePassword, results *mdm.CommandResults) error {
+	res := new(passwordResults)
+	if len(results.Raw) > 0 {
+		if err := plist.Unmarshal(results.Raw, res); err != nil {
+			return err
+		}
+	}
+	pending := pw.Status == StatusPending
+	switch {
+	case results.Status == "Error":
+		pw.Error = "command error"
+		if len(results.ErrorChain) > 0 {
+			pw.Error = results.ErrorChain[0].USEnglishDescription
+		}
+		if pending {
+			pw.Status = StatusFailed
+			pw.SealedPending = nil
+		}
+	case results.Status != "Acknowledged":
+		return nil
+	case pending && res.SetFirmwarePassword != nil && !res.SetFirmwarePassword.PasswordChanged:
+		pw.Status = StatusFailed
+		pw.Error = "password not changed"
+		pw.SealedPending = nil
+	case pending:
+		now := time.Now()
+		pw.SealedPassword = pw.SealedPending
+		pw.SealedPending = nil
+		pw.Status = StatusSet
+		if len(pw.SealedPassword) < 1 {
+			pw.Status = StatusCleared
+		}
+		pw.RotatedAt = &now
+	default:
+		pw.Verified = res.PasswordVerified
+	}
+	return nil
+}
+
+func (s *DevicePassword) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	return nil
+}
+
+func (s *DevicePassword) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	return nil
+}
+
+func (s *DevicePassword) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	return nil
+}
+
+func (s *DevicePassword) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	if results.Status == "Idle" || results.Status == "NotNow" || r.EnrollID == nil || r.ParentID != "" {
+		return nil, nil
+	}
+	pws, err := s.store.RetrieveDevicePasswords(r.Context, []string{r.ID}, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, pw := range pws {
+		if pw.CommandUUID != results.CommandUUID {
+			continue
+		}
+		if err = Apply(pw, results); err != nil {
+			return nil, fmt.Errorf("device password results: %w", err)
+		}
+		pw.UpdatedAt = time.Now()
+		s.logger.Info(
+			"msg", "device password",
+			"id", r.ID,
+			"kind", pw.Kind,
+			"command_uuid", results.CommandUUID,
+			"status", pw.Status,
+		)
+		return nil, s.store.StoreDevicePassword(r.Context, pw)
+	}
+	return nil, nil
+}
@@ -0,0 +1,71 @@
+package devicepassword
+
+import (
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+func TestApply(t *testing.T) {
+	pw := &storage.DevicePassword{
+		Kind:           KindFirmware,
+		Status:         StatusPending,
+		SealedPassword: []byte("old"),
+		SealedPending:  []byte("new"),
+	}
+	err := Apply(pw, &mdm.CommandResults{Status: "Acknowledged", Raw: []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>SetFirmwarePassword</key>
+	<dict><key>PasswordChanged</key><false/></dict>
+	<key>Status</key><string>Acknowledged</string>
+</dict>
+</plist>`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pw.Status != StatusFailed || string(pw.SealedPassword) != "old" || pw.SealedPending != nil {
+		t.Errorf("unexpected unchanged password: %+v", pw)
+	}
+
+	pw.Kind, pw.Status, pw.SealedPending = KindRecoveryLock, StatusPending, []byte("new")
+	if err = Apply(pw, &mdm.CommandResults{Status: "Acknowledged"}); err != nil {
+		t.Fatal(err)
+	}
+	if pw.Status != StatusSet || string(pw.SealedPassword) != "new" || pw.RotatedAt == nil {
+		t.Errorf("unexpected set password: %+v", pw)
+	}
+
+	err = Apply(pw, &mdm.CommandResults{Status: "Acknowledged", Raw: []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>PasswordVerified</key><true/>
+	<key>Status</key><string>Acknowledged</string>
+</dict>
+</plist>`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pw.Verified == nil || !*pw.Verified || pw.Status != StatusSet {
+		t.Errorf("unexpected verified password: %+v", pw)
+	}
+
+	pw.Status, pw.SealedPending = StatusPending, nil
+	if err = Apply(pw, &mdm.CommandResults{Status: "Acknowledged"}); err != nil {
+		t.Fatal(err)
+	}
+	if pw.Status != StatusCleared || pw.SealedPassword != nil {
+		t.Errorf("unexpected cleared password: %+v", pw)
+	}
+}
+
+func TestGeneratePassword(t *testing.T) {
+	pw, err := GeneratePassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pw) != passwordLength {
+		t.Errorf("length: have %d, want %d", len(pw), passwordLength)
+	}
+}
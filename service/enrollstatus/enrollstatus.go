@@ -0,0 +1,184 @@
+// Package enrollstatus is a NanoMDM service middleware that responds to
+// requests of unknown or disabled enrollments with configured HTTP
+// statuses.
+package enrollstatus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/service"
+	"github.com/jessepeterson/nanomdm/service/nanomdm"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// Enrollment states with a configurable response.
+const (
+	// Unknown enrollments have never enrolled (or were removed).
+	Unknown = "unknown"
+	// Disabled enrollments have checked out or been disabled.
+	Disabled = "disabled"
+)
+
+// Request types with a configurable response. Authenticate and
+// TokenUpdate check-ins are always passed on as they (re-)enable
+// enrollments.
+const (
+	// Connect is the command report and next-command request.
+	Connect  = "Connect"
+	CheckOut = "CheckOut"
+)
+
+// EnrollStatus is a service middleware that responds to Connect and
+// CheckOut requests of unknown or disabled enrollments with an HTTP
+// status. For example a 401 Unauthorized causes devices to unenroll
+// themselves. Cases without a configured status are passed on as
+// usual.
+type EnrollStatus struct {
+	next      service.CheckinAndCommandService
+	store     storage.EnrollmentStatusStore
+	logger    log.Logger
+	resolver  service.EnrollIDResolver
+	responses map[string]int
+}
+
+type Option func(*EnrollStatus)
+
+func WithLogger(logger log.Logger) Option {
+	return func(s *EnrollStatus) {
+		s.logger = logger
+	}
+}
+
+// WithResponse responds to requests of enrollments in state with HTTP
+// status. If requestType is not empty the response only applies to
+// that request type and overrides any response for all request types.
+func WithResponse(state, requestType string, status int) Option {
+	return func(s *EnrollStatus) {
+		s.responses[key(state, requestType)] = status
+	}
+}
+
+// WithEnrollIDResolver resolves enrollment IDs with resolver. Defaults
+// to the NanoMDM convention (see nanomdm.DefaultEnrollIDResolver).
+func WithEnrollIDResolver(resolver service.EnrollIDResolver) Option {
+	return func(s *EnrollStatus) {
+		s.resolver = resolver
+	}
+}
+
+func key(state, requestType string) string {
+	if requestType == "" {
+		return state
+	}
+	return state + "." + requestType
+}
+
+// ParseKey parses a response key of the form "<state>" or
+// "<state>.<requestType>" (e.g. "disabled.CheckOut").
+func ParseKey(k string) (state, requestType string, err error) {
+	state = k
+	i := strings.IndexByte(k, '.')
+	if i >= 0 {
+		state, requestType = k[:i], k[i+1:]
+	}
+	if state != Unknown && state != Disabled {
+		return "", "", fmt.Errorf("invalid enrollment state: %q", state)
+	}
+	if i >= 0 && requestType != Connect && requestType != CheckOut {
+		return "", "", fmt.Errorf("invalid request type: %q", requestType)
+	}
+	return state, requestType, nil
+}
+
+// New creates a new enrollment status service middleware.
+func New(next service.CheckinAndCommandService, store storage.EnrollmentStatusStore, opts ...Option) *EnrollStatus {
+	s := &EnrollStatus{
+		next:      next,
+		store:     store,
+		logger:    log.NopLogger,
+		resolver:  nanomdm.DefaultEnrollIDResolver,
+		responses: make(map[string]int),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// response returns the configured status for state and requestType.
+func (s *EnrollStatus) response(state, requestType string) int {
+	if status, ok := s.responses[key(state, requestType)]; ok {
+		return status
+	}
+	return s.responses[state]
+}
+
+// check returns a service.RejectError if the enrollment of e is
+// unknown or disabled and a response is configured for requestType.
+func (s *EnrollStatus) check(r *mdm.Request, e *mdm.Enrollment, requestType string) error {
+	if s.response(Unknown, requestType) == 0 && s.response(Disabled, requestType) == 0 {
+		return nil
+	}
+	ctx := r.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	id, err := s.resolver.ResolveEnrollID(ctx, e)
+	if err != nil {
+		return fmt.Errorf("enrollstatus: resolving enrollment id: %w", err)
+	}
+	if id == nil || id.ID == "" {
+		return nil
+	}
+	state := Disabled
+	enabled, err := s.store.EnrollmentEnabled(ctx, id.ID)
+	if errors.Is(err, storage.ErrNotFound) {
+		state = Unknown
+	} else if err != nil {
+		return fmt.Errorf("enrollstatus: retrieving enrollment status: %w", err)
+	} else if enabled {
+		return nil
+	}
+	status := s.response(state, requestType)
+	if status == 0 {
+		return nil
+	}
+	s.logger.Info(
+		"msg", "responding to enrollment",
+		"id", id.ID,
+		"state", state,
+		"request_type", requestType,
+		"status", status,
+	)
+	return &service.RejectError{
+		StatusCode: status,
+		Reason:     fmt.Sprintf("%s enrollment %s", state, id.ID),
+	}
+}
+
+func (s *EnrollStatus) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	return s.next.Authenticate(r, m)
+}
+
+func (s *EnrollStatus) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	return s.next.TokenUpdate(r, m)
+}
+
+func (s *EnrollStatus) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	if err := s.check(r, &m.Enrollment, CheckOut); err != nil {
+		return err
+	}
+	return s.next.CheckOut(r, m)
+}
+
+func (s *EnrollStatus) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	if err := s.check(r, &results.Enrollment, Connect); err != nil {
+		return nil, err
+	}
+	return s.next.CommandAndReportResults(r, results)
+}
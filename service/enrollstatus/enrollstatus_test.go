@@ -0,0 +1,67 @@
+package enrollstatus
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/service"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+type statusStore map[string]bool
+
+func (s statusStore) EnrollmentEnabled(_ context.Context, id string) (bool, error) {
+	enabled, ok := s[id]
+	if !ok {
+		return false, storage.ErrNotFound
+	}
+	return enabled, nil
+}
+
+func TestCheck(t *testing.T) {
+	store := statusStore{"enabled": true, "disabled": false}
+	r := &mdm.Request{Context: context.Background()}
+
+	s := New(nil, store,
+		WithResponse(Unknown, "", 401),
+		WithResponse(Disabled, CheckOut, 410),
+		WithResponse(Unknown, CheckOut, 0),
+	)
+	for _, test := range []struct {
+		udid        string
+		requestType string
+		status      int
+	}{
+		{"enabled", Connect, 0},
+		{"enabled", CheckOut, 0},
+		{"unknown", Connect, 401},
+		{"unknown", CheckOut, 0}, // overridden
+		{"disabled", Connect, 0},
+		{"disabled", CheckOut, 410},
+	} {
+		err := s.check(r, &mdm.Enrollment{UDID: test.udid}, test.requestType)
+		var rejectErr *service.RejectError
+		if test.status == 0 {
+			if err != nil {
+				t.Errorf("%s %s: unexpected error: %v", test.udid, test.requestType, err)
+			}
+		} else if !errors.As(err, &rejectErr) || rejectErr.StatusCode != test.status {
+			t.Errorf("%s %s: have %v, want status %d", test.udid, test.requestType, err, test.status)
+		}
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	for _, k := range []string{"unknown", "disabled.Connect", "unknown.CheckOut"} {
+		if _, _, err := ParseKey(k); err != nil {
+			t.Errorf("%s: %v", k, err)
+		}
+	}
+	for _, k := range []string{"", "enabled", "disabled.TokenUpdate", "unknown."} {
+		if _, _, err := ParseKey(k); err == nil {
+			t.Errorf("%s: expected error", k)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+// Package forward is a NanoMDM service that forwards copies of
+// check-in messages and command reports to another (e.g. legacy) MDM
+// server in the manner of mdmproxy. During a phased migration devices
+// are served by NanoMDM while workflows that still depend on the old
+// server keep receiving the messages they need.
+package forward
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/cryptoutil"
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// Message names (besides command request types) that select the
+// messages to forward.
+const (
+	Authenticate = "Authenticate"
+	TokenUpdate  = "TokenUpdate"
+	CheckOut     = "CheckOut"
+	// Idle are command reports without a command result.
+	Idle = "Idle"
+)
+
+// Forward is a service that PUTs the raw plists of check-in messages
+// to the check-in URL and of command reports to the server URL of
+// another MDM server. The other server's responses (such as its
+// commands) are discarded. It is intended to run alongside the core
+// NanoMDM service (i.e. with the multi service) so that forwarding
+// never delays or fails device requests.
+type Forward struct {
+	serverURL  string
+	checkinURL string
+	headers    http.Header
+	certHeader string
+	messages   map[string]bool
+	deliveries storage.CommandDeliveryStore
+	client     *http.Client
+	logger     log.Logger
+}
+
+type Option func(*Forward)
+
+func WithLogger(logger log.Logger) Option {
+	return func(f *Forward) {
+		f.logger = logger
+	}
+}
+
+// WithClient uses client to forward messages.
+func WithClient(client *http.Client) Option {
+	return func(f *Forward) {
+		f.client = client
+	}
+}
+
+// WithCheckinURL forwards check-in messages to checkinURL rather than
+// to the server URL.
+func WithCheckinURL(checkinURL string) Option {
+	return func(f *Forward) {
+		f.checkinURL = checkinURL
+	}
+}
+
+// WithHeaders adds headers (e.g. authentication for the other server)
+// to forwarded requests.
+func WithHeaders(headers http.Header) Option {
+	return func(f *Forward) {
+		f.headers = headers
+	}
+}
+
+// WithCertHeader sends the device identity certificate of forwarded
+// messages as a URL-escaped PEM certificate in header (e.g. for a
+// server that extracts certificates from a reverse proxy header).
+func WithCertHeader(header string) Option {
+	return func(f *Forward) {
+		f.certHeader = header
+	}
+}
+
+// WithMessages only forwards the messages named by messages: the
+// check-in message types Authenticate, TokenUpdate, and CheckOut, Idle
+// command reports, and the command reports of commands of a request
+// type (e.g. DeviceInformation). All messages are forwarded by default.
+//
+// Command reports are matched by their request types looked up in the
+// command delivery audit trails of store.
+func WithMessages(store storage.CommandDeliveryStore, messages ...string) Option {
+	return func(f *Forward) {
+		f.deliveries = store
+		f.messages = make(map[string]bool)
+		for _, m := range messages {
+			f.messages[m] = true
+		}
+	}
+}
+
+// New creates a new forwarding service to the other server's MDM
+// server URL.
+func New(serverURL string, opts ...Option) *Forward {
+	f := &Forward{
+		serverURL: serverURL,
+		client:    &http.Client{Timeout: 30 * time.Second},
+		logger:    log.NopLogger,
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if f.checkinURL == "" {
+		f.checkinURL = f.serverURL
+	}
+	return f
+}
+
+func (f *Forward) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	return f.forwardCheckin(r, Authenticate, m.Raw)
+}
+
+func (f *Forward) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	return f.forwardCheckin(r, TokenUpdate, m.Raw)
+}
+
+func (f *Forward) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	return f.forwardCheckin(r, CheckOut, m.Raw)
+}
+
+func (f *Forward) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	if !f.match(r, results) {
+		return nil, nil
+	}
+	return nil, f.forward(r, f.serverURL, "", results.Raw)
+}
+
+// forwardCheckin forwards the check-in message of messageType if it is
+// selected.
+func (f *Forward) forwardCheckin(r *mdm.Request, messageType string, raw []byte) error {
+	if f.messages != nil && !f.messages[messageType] {
+		return nil
+	}
+	return f.forward(r, f.checkinURL, "application/x-apple-aspen-mdm-checkin", raw)
+}
+
+// match reports whether the command report results is selected.
+func (f *Forward) match(r *mdm.Request, results *mdm.CommandResults) bool {
+	if f.messages == nil {
+		return true
+	}
+	if results.Status == "Idle" {
+		return f.messages[Idle]
+	}
+	if f.deliveries == nil || r.EnrollID == nil {
+		return false
+	}
+	deliveries, err := f.deliveries.RetrieveCommandDeliveries(context.Background(), r.ID)
+	if err != nil {
+		f.logger.Info("msg", "retrieving command deliveries", "id", r.ID, "err", err)
+		return false
+	}
+	for _, d := range deliveries {
+		if d.CommandUUID == results.CommandUUID {
+			return f.messages[d.RequestType]
+		}
+	}
+	return false
+}
+
+// forward PUTs raw to target like the device did.
+func (f *Forward) forward(r *mdm.Request, target, contentType string, raw []byte) error {
+	ctx := r.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	for name, values := range f.headers {
+		req.Header[name] = values
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if f.certHeader != "" && r.Certificate != nil {
+		req.Header.Set(f.certHeader, escapeCert(r.Certificate.Raw))
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("forwarding: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("forwarding: unexpected HTTP status %s", resp.Status)
+	}
+	f.logger.Debug("msg", "forwarded", "url", target, "status", resp.StatusCode)
+	return nil
+}
+
+// escapeCert URL-escapes the PEM certificate of der like Nginx'
+// $ssl_client_escaped_cert.
+func escapeCert(der []byte) string {
+	return url.QueryEscape(string(cryptoutil.PEMCertificate(der)))
+}
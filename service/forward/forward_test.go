@@ -0,0 +1,55 @@
+package forward
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+type deliveries []*storage.CommandDelivery
+
+func (d deliveries) RetrieveCommandDeliveries(context.Context, string) ([]*storage.CommandDelivery, error) {
+	return d, nil
+}
+
+func TestForward(t *testing.T) {
+	var forwarded []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Method != http.MethodPut || r.Header.Get("Authorization") != "Basic abc" {
+			t.Errorf("unexpected request: %s %v", r.Method, r.Header)
+		}
+		forwarded = append(forwarded, r.URL.Path+" "+string(body))
+	}))
+	defer srv.Close()
+	f := New(
+		srv.URL+"/mdm",
+		WithCheckinURL(srv.URL+"/checkin"),
+		WithHeaders(http.Header{"Authorization": {"Basic abc"}}),
+		WithMessages(deliveries{{CommandUUID: "A", RequestType: "DeviceInformation"}, {CommandUUID: "B", RequestType: "ProfileList"}}, TokenUpdate, "DeviceInformation"),
+	)
+	r := &mdm.Request{EnrollID: &mdm.EnrollID{ID: "UDID"}}
+	if err := f.Authenticate(r, &mdm.Authenticate{Raw: []byte("auth")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.TokenUpdate(r, &mdm.TokenUpdate{Raw: []byte("token")}); err != nil {
+		t.Fatal(err)
+	}
+	for _, results := range []*mdm.CommandResults{
+		{Status: "Idle", Raw: []byte("idle")},
+		{CommandUUID: "A", Status: "Acknowledged", Raw: []byte("a")},
+		{CommandUUID: "B", Status: "Acknowledged", Raw: []byte("b")},
+	} {
+		if _, err := f.CommandAndReportResults(r, results); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(forwarded) != 2 || forwarded[0] != "/checkin token" || forwarded[1] != "/mdm a" {
+		t.Errorf("unexpected forwarded messages: %q", forwarded)
+	}
+}
@@ -0,0 +1,370 @@
+// Package guard is a NanoMDM service and HTTP middleware that blocks
+// clients after repeated authentication failures on the device
+// endpoints.
+package guard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	mdmhttp "github.com/jessepeterson/nanomdm/http"
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/secevent"
+	"github.com/jessepeterson/nanomdm/service"
+	"github.com/jessepeterson/nanomdm/service/certauth"
+	"github.com/jessepeterson/nanomdm/service/microwebhook"
+	"github.com/jessepeterson/nanomdm/service/nanomdm"
+)
+
+const (
+	// DefaultWindow is the default duration after which failures
+	// (or the end of a block) are forgotten.
+	DefaultWindow = 10 * time.Minute
+	// DefaultBlock is the default duration of the first block.
+	DefaultBlock = time.Minute
+	// DefaultMaxBlock is the default maximum duration of a block.
+	DefaultMaxBlock = time.Hour
+	// notifyTimeout is the deadline of sending a block to the webhook.
+	notifyTimeout = 30 * time.Second
+)
+
+// Failure kinds.
+const (
+	// KindSignature is a failed signature or certificate verification.
+	KindSignature = "signature"
+	// KindCertAuth is a certificate to enrollment association mismatch.
+	KindCertAuth = "certauth"
+)
+
+type offender struct {
+	failures     int
+	last         time.Time
+	blockedUntil time.Time
+}
+
+// Guard tracks authentication failures per client address. Once an
+// address reaches the failure limit its requests are rejected with
+// HTTP 429 for a block duration that doubles with every further
+// failure (up to a maximum). Failures are forgotten once none occurred
+// (and no block ended) within a time window.
+//
+// As HTTP middleware (see Middleware) it counts requests that failed
+// signature or certificate verification. As service middleware
+// wrapping certauth it counts certificate association mismatches.
+// Enrollment IDs are only reported, never blocked: anyone can present
+// a mismatched certificate for a known enrollment ID and would
+// otherwise lock out the genuine device.
+//
+// Note devices behind a shared address (e.g. NAT) are blocked together
+// so the limit should be well above the failures of genuine devices.
+// Failures are kept in memory. Each instance of a horizontally scaled
+// deployment therefore tracks its own.
+//
+// The client address is retrieved from the request context with
+// mdmhttp.GetRemoteAddr and so requires mdmhttp.RemoteAddrMiddleware.
+type Guard struct {
+	next     service.CheckinAndCommandService
+	logger   log.Logger
+	resolver service.EnrollIDResolver
+	webhook  *microwebhook.MicroWebhook
+	limit    int
+	window   time.Duration
+	block    time.Duration
+	maxBlock time.Duration
+	now      func() time.Time
+
+	mu        sync.Mutex
+	offenders map[string]*offender
+	swept     time.Time
+}
+
+type Option func(*Guard)
+
+func WithLogger(logger log.Logger) Option {
+	return func(g *Guard) {
+		g.logger = logger
+	}
+}
+
+// WithWindow sets the duration after which failures are forgotten.
+func WithWindow(window time.Duration) Option {
+	return func(g *Guard) {
+		g.window = window
+	}
+}
+
+// WithBlock sets the duration of the first block and the maximum
+// duration of the doubling blocks after further failures.
+func WithBlock(block, maxBlock time.Duration) Option {
+	return func(g *Guard) {
+		g.block = block
+		g.maxBlock = maxBlock
+	}
+}
+
+// WithEnrollIDResolver resolves enrollment IDs with resolver. Defaults
+// to the NanoMDM convention (see nanomdm.DefaultEnrollIDResolver).
+func WithEnrollIDResolver(resolver service.EnrollIDResolver) Option {
+	return func(g *Guard) {
+		g.resolver = resolver
+	}
+}
+
+// WithWebhook sends mdm.SecurityAnomaly events of blocked addresses to
+// the webhook at url.
+func WithWebhook(url string, opts ...microwebhook.Option) Option {
+	return func(g *Guard) {
+		g.webhook = microwebhook.New(url, opts...)
+	}
+}
+
+// New creates a new guard blocking after limit failures.
+func New(next service.CheckinAndCommandService, limit int, opts ...Option) *Guard {
+	g := &Guard{
+		next:      next,
+		logger:    log.NopLogger,
+		resolver:  nanomdm.DefaultEnrollIDResolver,
+		limit:     limit,
+		window:    DefaultWindow,
+		block:     DefaultBlock,
+		maxBlock:  DefaultMaxBlock,
+		now:       time.Now,
+		offenders: make(map[string]*offender),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+// expired reports whether o's failures are forgotten at now.
+func (g *Guard) expired(o *offender, now time.Time) bool {
+	since := o.last
+	if o.blockedUntil.After(since) {
+		since = o.blockedUntil
+	}
+	return now.Sub(since) > g.window
+}
+
+// blocked returns the remaining block duration of source, if blocked.
+func (g *Guard) blocked(source string) (time.Duration, bool) {
+	now := g.now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if o, ok := g.offenders[source]; ok && o.blockedUntil.After(now) {
+		return o.blockedUntil.Sub(now), true
+	}
+	return 0, false
+}
+
+// fail records a failure of kind from source and blocks it once it
+// reaches the limit. The enrollment id (if not empty) is only reported.
+func (g *Guard) fail(ctx context.Context, kind, source, id, reason string) {
+	if source == "" {
+		return
+	}
+	now := g.now()
+	g.mu.Lock()
+	if now.Sub(g.swept) > g.window {
+		for k, o := range g.offenders {
+			if g.expired(o, now) {
+				delete(g.offenders, k)
+			}
+		}
+		g.swept = now
+	}
+	o, ok := g.offenders[source]
+	if !ok || g.expired(o, now) {
+		o = new(offender)
+		g.offenders[source] = o
+	}
+	o.failures++
+	o.last = now
+	if o.failures < g.limit {
+		g.mu.Unlock()
+		return
+	}
+	block := g.maxBlock
+	if n := o.failures - g.limit; n < 30 && g.block<<n < g.maxBlock {
+		block = g.block << n
+	}
+	o.blockedUntil = now.Add(block)
+	ev := &microwebhook.SecurityEvent{
+		Kind:         kind,
+		Source:       source,
+		ID:           id,
+		Failures:     o.failures,
+		BlockedUntil: o.blockedUntil,
+		Reason:       reason,
+	}
+	g.mu.Unlock()
+	g.logger.Info(
+		"msg", "blocking after authentication failures",
+		"kind", ev.Kind,
+		"source", ev.Source,
+		"id", ev.ID,
+		"failures", ev.Failures,
+		"blocked_until", ev.BlockedUntil,
+		"reason", ev.Reason,
+	)
+	if g.webhook != nil {
+		go g.notify(ev)
+	}
+	secevent.Emit(ctx, &secevent.Event{
+		Type:   secevent.ClientBlocked,
+		Time:   g.now(),
+		Source: ev.Source,
+		IDs:    ids(ev.ID),
+		Reason: fmt.Sprintf("%d %s failures, blocked until %s", ev.Failures, ev.Kind, ev.BlockedUntil.Format(time.RFC3339)),
+	})
+}
+
+// ids returns id as a list of IDs, if not empty.
+func ids(id string) []string {
+	if id == "" {
+		return nil
+	}
+	return []string{id}
+}
+
+// notify sends ev to the webhook. It is run in its own goroutine so
+// that blocking does not wait on the webhook.
+func (g *Guard) notify(ev *microwebhook.SecurityEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	err := g.webhook.PostEvent(ctx, &microwebhook.Event{
+		Topic:         "mdm.SecurityAnomaly",
+		CreatedAt:     time.Now(),
+		SecurityEvent: ev,
+	})
+	if err != nil {
+		g.logger.Info("msg", "sending security webhook", "source", ev.Source, "id", ev.ID, "err", err)
+	}
+}
+
+// retryAfter responds with HTTP 429 and the remaining block duration.
+func retryAfter(w http.ResponseWriter, remaining time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int((remaining+time.Second-1)/time.Second)))
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
+
+// failureRecorder is a secevent.Emitter that records whether a
+// request failed signature or certificate verification and passes the
+// events on to next (if not nil).
+type failureRecorder struct {
+	next   secevent.Emitter
+	failed bool
+}
+
+func (f *failureRecorder) Emit(ctx context.Context, ev *secevent.Event) {
+	if ev.Type == secevent.CertVerifyFailed {
+		f.failed = true
+	}
+	if f.next != nil {
+		f.next.Emit(ctx, ev)
+	}
+}
+
+// Middleware rejects requests of blocked client addresses and counts
+// the requests of next that fail signature or certificate verification
+// (those reporting a secevent.CertVerifyFailed event) as signature
+// failures. Other rejected requests (such as invalid plists) are not
+// counted. Next should therefore include the certificate extraction
+// and verification middleware.
+func (g *Guard) Middleware(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		source := mdmhttp.GetRemoteAddr(r.Context())
+		if remaining, ok := g.blocked(source); ok {
+			g.logger.Debug("msg", "blocked request", "source", source)
+			retryAfter(w, remaining)
+			return
+		}
+		rec := &failureRecorder{next: secevent.FromContext(r.Context())}
+		next.ServeHTTP(w, r.WithContext(secevent.NewContext(r.Context(), rec)))
+		if rec.failed {
+			g.fail(r.Context(), KindSignature, source, "", "verification failed")
+		}
+	}
+}
+
+// source returns the client address and enrollment ID of the request.
+func (g *Guard) source(r *mdm.Request, e *mdm.Enrollment) (string, string) {
+	ctx := r.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var id string
+	if eid, err := g.resolver.ResolveEnrollID(ctx, e); err == nil && eid != nil {
+		id = eid.ID
+	}
+	return mdmhttp.GetRemoteAddr(ctx), id
+}
+
+// check rejects the request if its client address is blocked.
+func (g *Guard) check(source string) error {
+	if remaining, ok := g.blocked(source); ok {
+		return &service.RejectError{
+			StatusCode: http.StatusTooManyRequests,
+			Reason:     "blocked for " + remaining.Round(time.Second).String(),
+		}
+	}
+	return nil
+}
+
+// record counts err as a failure if it is a certificate association
+// mismatch.
+func (g *Guard) record(r *mdm.Request, source, id string, err error) {
+	if errors.Is(err, certauth.ErrNoCertReuse) || errors.Is(err, certauth.ErrNoCertAssoc) {
+		ctx := r.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		g.fail(ctx, KindCertAuth, source, id, err.Error())
+	}
+}
+
+func (g *Guard) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	source, id := g.source(r, &m.Enrollment)
+	if err := g.check(source); err != nil {
+		return err
+	}
+	err := g.next.Authenticate(r, m)
+	g.record(r, source, id, err)
+	return err
+}
+
+func (g *Guard) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	source, id := g.source(r, &m.Enrollment)
+	if err := g.check(source); err != nil {
+		return err
+	}
+	err := g.next.TokenUpdate(r, m)
+	g.record(r, source, id, err)
+	return err
+}
+
+func (g *Guard) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	source, id := g.source(r, &m.Enrollment)
+	if err := g.check(source); err != nil {
+		return err
+	}
+	err := g.next.CheckOut(r, m)
+	g.record(r, source, id, err)
+	return err
+}
+
+func (g *Guard) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	source, id := g.source(r, &results.Enrollment)
+	if err := g.check(source); err != nil {
+		return nil, err
+	}
+	cmd, err := g.next.CommandAndReportResults(r, results)
+	g.record(r, source, id, err)
+	return cmd, err
+}
@@ -0,0 +1,83 @@
+package guard
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mdmhttp "github.com/jessepeterson/nanomdm/http"
+	"github.com/jessepeterson/nanomdm/secevent"
+)
+
+func TestBackoff(t *testing.T) {
+	now := time.Now()
+	g := New(nil, 2, WithWindow(time.Minute), WithBlock(time.Second, 3*time.Second))
+	g.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for _, test := range []struct {
+		advance time.Duration
+		blocked time.Duration
+	}{
+		{0, 0},
+		{0, time.Second},
+		{2 * time.Second, 2 * time.Second},
+		{3 * time.Second, 3 * time.Second},
+		{4 * time.Second, 3 * time.Second}, // capped
+	} {
+		now = now.Add(test.advance)
+		g.fail(ctx, KindCertAuth, "192.0.2.1", "id", "test")
+		if remaining, _ := g.blocked("192.0.2.1"); remaining != test.blocked {
+			t.Errorf("have %v, want %v", remaining, test.blocked)
+		}
+	}
+	if _, ok := g.blocked("192.0.2.2"); ok {
+		t.Error("unexpected block")
+	}
+	// the enrollment ID of the failures is not blocked
+	if err := g.check("192.0.2.2"); err != nil {
+		t.Errorf("genuine device of blocked enrollment ID: %v", err)
+	}
+
+	// failures are forgotten a window after the block ends
+	now = now.Add(3*time.Second + 2*time.Minute)
+	g.fail(ctx, KindSignature, "192.0.2.1", "", "test")
+	if _, ok := g.blocked("192.0.2.1"); ok {
+		t.Error("unexpected block after window")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	g := New(nil, 2)
+	verifyFailed := false
+	h := mdmhttp.RemoteAddrMiddleware(g.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if verifyFailed {
+			secevent.Emit(r.Context(), &secevent.Event{Type: secevent.CertVerifyFailed})
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	})), "", nil)
+	serve := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/mdm", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// other bad requests (e.g. invalid plists) are not counted
+	for i := 0; i < 3; i++ {
+		if w := serve(); w.Code != http.StatusBadRequest {
+			t.Errorf("bad request %d: have %d, want %d", i, w.Code, http.StatusBadRequest)
+		}
+	}
+	verifyFailed = true
+	for i, want := range []int{http.StatusBadRequest, http.StatusBadRequest, http.StatusTooManyRequests} {
+		if w := serve(); w.Code != want {
+			t.Errorf("request %d: have %d, want %d", i, w.Code, want)
+		} else if want == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "60" {
+			t.Errorf("Retry-After: have %q, want 60", w.Header().Get("Retry-After"))
+		}
+	}
+}
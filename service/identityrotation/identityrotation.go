@@ -0,0 +1,389 @@
+// Package identityrotation is a NanoMDM service that rotates the MDM
+// identity (certificate) of enrollments.
+package identityrotation
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/cmdplist"
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/push"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// States of identity rotations.
+const (
+	StatePending   = "pending"
+	StateInstalled = "installed"
+	// StatePresented is a rotation whose new certificate was presented
+	// before the installation of the profile was acknowledged.
+	StatePresented = "presented"
+	StateRotated   = "rotated"
+	StateFailed    = "failed"
+)
+
+// DefaultTimeout is the default time a rotation may take before it fails.
+const DefaultTimeout = 24 * time.Hour
+
+// clockSkew is the tolerated difference between the start of the
+// validity of new certificates and the start of their rotation.
+const clockSkew = 5 * time.Minute
+
+// maxUpdateAttempts is the number of times a rotation update is
+// attempted when the rotation changed concurrently.
+const maxUpdateAttempts = 3
+
+var (
+	ErrPending  = errors.New("identity rotation pending")
+	errConflict = errors.New("identity rotation changed concurrently")
+)
+
+// Store is the storage required by the identity rotation service.
+type Store interface {
+	storage.IdentityRotationStore
+	storage.CommandEnqueuer
+}
+
+// IdentityRotation is a service that rotates identities by enqueuing
+// an InstallProfile command of a profile containing the new identity
+// (e.g. a SCEP payload) to enrollments. While the rotation is in
+// progress the certauth service (see WithRotator) replaces the
+// association of the enrollment with the first new certificate it
+// presents that was issued after the rotation started. The rotation
+// completes once the certificate was presented and the profile
+// installation acknowledged.
+//
+// Rotations fail, keeping the existing association, if the profile
+// fails to install or no new certificate is seen before the timeout.
+// If the new certificate was already presented the previous
+// association is restored (rolled back). Stale rotations are failed
+// with Run.
+//
+// Rotation state changes are compare-and-set updates in storage so
+// that concurrent check-ins and command results do not overwrite each
+// other.
+//
+// It is intended to run alongside the core NanoMDM service (i.e. with
+// the multi service) so that enrollment IDs are resolved.
+type IdentityRotation struct {
+	store   Store
+	profile []byte
+	pusher  push.Pusher
+	logger  log.Logger
+	timeout time.Duration
+}
+
+type Option func(*IdentityRotation)
+
+func WithLogger(logger log.Logger) Option {
+	return func(s *IdentityRotation) {
+		s.logger = logger
+	}
+}
+
+// WithPusher sends APNs pushes after enqueuing commands.
+func WithPusher(pusher push.Pusher) Option {
+	return func(s *IdentityRotation) {
+		s.pusher = pusher
+	}
+}
+
+// WithTimeout fails rotations not completed within timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(s *IdentityRotation) {
+		s.timeout = timeout
+	}
+}
+
+// New creates a new identity rotation service which installs profile
+// (usually a signed enrollment profile) to rotate identities.
+func New(store Store, profile []byte, opts ...Option) *IdentityRotation {
+	s := &IdentityRotation{
+		store:   store,
+		profile: profile,
+		logger:  log.NopLogger,
+		timeout: DefaultTimeout,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// active reports whether rot has not completed or failed (yet).
+func active(rot *storage.IdentityRotation) bool {
+	return rot.State == StatePending || rot.State == StateInstalled || rot.State == StatePresented
+}
+
+// inProgress reports whether rot is active and not timed out.
+func (s *IdentityRotation) inProgress(rot *storage.IdentityRotation) bool {
+	return active(rot) && time.Since(rot.StartedAt) < s.timeout
+}
+
+// List retrieves the identity rotations of ids.
+func (s *IdentityRotation) List(ctx context.Context, ids []string) ([]*storage.IdentityRotation, error) {
+	return s.store.RetrieveIdentityRotations(ctx, ids)
+}
+
+// Rotate starts identity rotations of ids by enqueuing (and pushing)
+// the InstallProfile command. It returns the command UUIDs and errors
+// by enrollment ID.
+func (s *IdentityRotation) Rotate(ctx context.Context, ids []string) (map[string]string, map[string]error, error) {
+	rots, err := s.store.RetrieveIdentityRotations(ctx, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	existing := make(map[string]*storage.IdentityRotation)
+	for _, rot := range rots {
+		existing[rot.ID] = rot
+	}
+	uuids := make(map[string]string)
+	idErrs := make(map[string]error)
+	var sent []string
+	for _, id := range ids {
+		if rot := existing[id]; rot != nil && s.inProgress(rot) {
+			idErrs[id] = ErrPending
+			continue
+		}
+		prevHash, err := s.store.RetrieveCertHash(ctx, id)
+		if err != nil {
+			return uuids, idErrs, err
+		}
+		cmd := cmdplist.New("InstallProfile").Set("Payload", s.profile)
+		mdmCmd, err := cmd.MDMCommand()
+		if err != nil {
+			return uuids, idErrs, err
+		}
+		if _, err = s.store.EnqueueCommand(ctx, []string{id}, mdmCmd); err != nil {
+			idErrs[id] = err
+			continue
+		}
+		now := time.Now()
+		rot := &storage.IdentityRotation{
+			ID:               id,
+			State:            StatePending,
+			CommandUUID:      cmd.CommandUUID,
+			PreviousCertHash: prevHash,
+			StartedAt:        now,
+			UpdatedAt:        now,
+		}
+		if err = s.store.StoreIdentityRotation(ctx, rot); err != nil {
+			return uuids, idErrs, err
+		}
+		uuids[id] = cmd.CommandUUID
+		sent = append(sent, id)
+	}
+	if len(sent) > 0 && s.pusher != nil {
+		if _, err = s.pusher.Push(ctx, sent); err != nil {
+			s.logger.Info("msg", "push", "err", err)
+		}
+	}
+	return uuids, idErrs, nil
+}
+
+// Expire fails rotations that did not complete within the timeout.
+// Rotations whose new certificate was already presented are rolled
+// back to the previous association.
+func (s *IdentityRotation) Expire(ctx context.Context) error {
+	rots, err := s.store.RetrieveIdentityRotations(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, rot := range rots {
+		if !active(rot) || s.inProgress(rot) {
+			continue
+		}
+		rot, err = s.update(ctx, rot.ID, func(rot *storage.IdentityRotation) (string, bool) {
+			if !active(rot) || s.inProgress(rot) {
+				return "", false
+			}
+			return fail(rot, "timed out"), true
+		})
+		if err != nil {
+			return err
+		} else if rot != nil {
+			s.logger.Info("msg", "identity rotation", "id", rot.ID, "state", rot.State, "err", rot.Error)
+		}
+	}
+	return nil
+}
+
+// Run fails stale rotations until ctx is done.
+func (s *IdentityRotation) Run(ctx context.Context) {
+	check := time.Hour
+	if s.timeout < check {
+		check = s.timeout
+	}
+	ticker := time.NewTicker(check)
+	defer ticker.Stop()
+	for {
+		if err := s.Expire(ctx); err != nil {
+			s.logger.Info("msg", "expiring identity rotations", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// retrieve retrieves the identity rotation of id. It returns nil if
+// there is none.
+func (s *IdentityRotation) retrieve(ctx context.Context, id string) (*storage.IdentityRotation, error) {
+	rots, err := s.store.RetrieveIdentityRotations(ctx, []string{id})
+	if err != nil || len(rots) < 1 {
+		return nil, err
+	}
+	return rots[0], nil
+}
+
+// update retrieves the rotation of id and, if f returns true, stores
+// the rotation as modified by f if it did not change in the meantime
+// and replaces the certificate associations of the enrollment with the
+// hash returned by f (if not empty). The update is retried if the
+// rotation changed. It returns the updated rotation or nil if there is
+// none or f returned false.
+func (s *IdentityRotation) update(ctx context.Context, id string, f func(*storage.IdentityRotation) (string, bool)) (*storage.IdentityRotation, error) {
+	for i := 0; i < maxUpdateAttempts; i++ {
+		rot, err := s.retrieve(ctx, id)
+		if err != nil || rot == nil {
+			return nil, err
+		}
+		state := rot.State
+		hash, ok := f(rot)
+		if !ok {
+			return nil, nil
+		}
+		rot.UpdatedAt = time.Now()
+		if updated, err := s.store.UpdateIdentityRotation(ctx, rot, state, hash); err != nil {
+			return nil, err
+		} else if updated {
+			return rot, nil
+		}
+	}
+	return nil, errConflict
+}
+
+// RotateIdentity replaces the certificate association of the
+// enrollment of r with hash (the hash of the certificate of r) if it
+// has a rotation in progress whose new certificate was not yet
+// presented and the certificate was issued after the rotation started.
+// See certauth.Rotator.
+func (s *IdentityRotation) RotateIdentity(r *mdm.Request, hash string) (bool, error) {
+	if r.EnrollID == nil || r.Certificate == nil {
+		return false, nil
+	}
+	rot, err := s.update(r.Context, r.ID, func(rot *storage.IdentityRotation) (string, bool) {
+		if !s.inProgress(rot) || rot.State == StatePresented {
+			return "", false
+		}
+		if r.Certificate.NotBefore.Before(rot.StartedAt.Add(-clockSkew)) {
+			s.logger.Info(
+				"msg", "certificate issued before identity rotation",
+				"id", r.ID,
+				"hash", hash,
+				"not_before", r.Certificate.NotBefore,
+			)
+			return "", false
+		}
+		if rot.State == StateInstalled {
+			rot.State = StateRotated
+		} else {
+			rot.State = StatePresented
+		}
+		rot.CertHash = hash
+		rot.Error = ""
+		return hash, true
+	})
+	if err != nil || rot == nil {
+		return false, err
+	}
+	s.logger.Info("msg", "identity rotation", "id", r.ID, "state", rot.State, "hash", hash)
+	return true, nil
+}
+
+// fail fails rot with the error msg. It returns the certificate hash
+// to associate again (i.e. to roll back to) if the new certificate of
+// rot was already presented.
+func fail(rot *storage.IdentityRotation, msg string) string {
+	presented := rot.State == StatePresented
+	rot.State = StateFailed
+	rot.Error = msg
+	if !presented {
+		return ""
+	}
+	if rot.PreviousCertHash != "" {
+		rot.Error += "; rolled back"
+	}
+	return rot.PreviousCertHash
+}
+
+// Apply updates rot from the results of its InstallProfile command. It
+// returns the certificate hash to associate again if rot is rolled back
+// (see fail).
+func Apply(rot *storage.IdentityRotation, results *mdm.CommandResults) string {
+	switch results.Status {
+	case "Error":
+		if rot.State == StateRotated || rot.State == StateFailed {
+			return ""
+		}
+		msg := "command error"
+		if len(results.ErrorChain) > 0 {
+			msg = results.ErrorChain[0].USEnglishDescription
+		}
+		return fail(rot, msg)
+	case "Acknowledged":
+		switch rot.State {
+		case StatePending:
+			rot.State = StateInstalled
+		case StatePresented:
+			rot.State = StateRotated
+		}
+	}
+	return ""
+}
+
+func (s *IdentityRotation) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	return nil
+}
+
+func (s *IdentityRotation) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	return nil
+}
+
+func (s *IdentityRotation) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	return nil
+}
+
+func (s *IdentityRotation) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	if results.Status == "Idle" || results.Status == "NotNow" || r.EnrollID == nil || r.ParentID != "" {
+		return nil, nil
+	}
+	rot, err := s.update(r.Context, r.ID, func(rot *storage.IdentityRotation) (string, bool) {
+		if rot.CommandUUID != results.CommandUUID {
+			return "", false
+		}
+		state := rot.State
+		hash := Apply(rot, results)
+		return hash, rot.State != state
+	})
+	if err != nil || rot == nil {
+		return nil, err
+	}
+	logs := []interface{}{
+		"msg", "identity rotation",
+		"id", r.ID,
+		"command_uuid", results.CommandUUID,
+		"status", results.Status,
+		"state", rot.State,
+	}
+	if rot.Error != "" {
+		logs = append(logs, "err", rot.Error)
+	}
+	s.logger.Info(logs...)
+	return nil, nil
+}
@@ -0,0 +1,118 @@
+package identityrotation
+
+import (
+	"context"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
+	"github.com/jessepeterson/nanomdm/storage/file"
+)
+
+func TestApply(t *testing.T) {
+	rot := &storage.IdentityRotation{State: StatePending}
+	Apply(rot, &mdm.CommandResults{Status: "Acknowledged"})
+	if rot.State != StateInstalled {
+		t.Errorf("state: have %q, want %q", rot.State, StateInstalled)
+	}
+
+	// the new certificate may be seen before the acknowledgement
+	rot.State = StatePresented
+	Apply(rot, &mdm.CommandResults{Status: "Acknowledged"})
+	if rot.State != StateRotated {
+		t.Errorf("state: have %q, want %q", rot.State, StateRotated)
+	}
+
+	rot.State = StatePending
+	errResults := &mdm.CommandResults{Status: "Error", ErrorChain: []mdm.ErrorChain{{USEnglishDescription: "failed"}}}
+	if hash := Apply(rot, errResults); rot.State != StateFailed || rot.Error != "failed" || hash != "" {
+		t.Errorf("unexpected error state: %q (%q) %q", rot.State, rot.Error, hash)
+	}
+
+	// roll back to the previous certificate
+	rot = &storage.IdentityRotation{State: StatePresented, PreviousCertHash: "old"}
+	if hash := Apply(rot, errResults); rot.State != StateFailed || hash != "old" {
+		t.Errorf("unexpected rollback: %q (%q) %q", rot.State, rot.Error, hash)
+	}
+}
+
+func TestRotateIdentity(t *testing.T) {
+	store, err := file.New(t.TempDir(), file.WithSyncInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	started := time.Now()
+	r := &mdm.Request{Context: ctx, EnrollID: &mdm.EnrollID{ID: "dev", Type: mdm.Device}}
+	if err = store.AssociateCertHash(r, "old"); err != nil {
+		t.Fatal(err)
+	}
+	rot := &storage.IdentityRotation{ID: "dev", State: StatePending, CommandUUID: "cmd", PreviousCertHash: "old", StartedAt: started}
+	if err = store.StoreIdentityRotation(ctx, rot); err != nil {
+		t.Fatal(err)
+	}
+	s := New(store, nil)
+
+	// certificates issued before the rotation are not accepted
+	r.Certificate = &x509.Certificate{NotBefore: started.Add(-time.Hour)}
+	if rotated, err := s.RotateIdentity(r, "stale"); err != nil || rotated {
+		t.Errorf("stale certificate: have %v (%v), want false", rotated, err)
+	}
+
+	r.Certificate = &x509.Certificate{NotBefore: started}
+	if rotated, err := s.RotateIdentity(r, "new"); err != nil || !rotated {
+		t.Fatalf("new certificate: have %v (%v), want true", rotated, err)
+	}
+	if assoc, _ := store.IsCertHashAssociated(r, "new"); !assoc {
+		t.Error("new certificate not associated")
+	}
+	if assoc, _ := store.IsCertHashAssociated(r, "old"); assoc {
+		t.Error("old certificate still associated")
+	}
+
+	// only one new certificate per rotation
+	if rotated, err := s.RotateIdentity(r, "other"); err != nil || rotated {
+		t.Errorf("other certificate: have %v (%v), want false", rotated, err)
+	}
+
+	// a failed install rolls back to the old certificate
+	_, err = s.CommandAndReportResults(r, &mdm.CommandResults{CommandUUID: "cmd", Status: "Error"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rot, _ = s.retrieve(ctx, "dev"); rot.State != StateFailed {
+		t.Errorf("state: have %q, want %q", rot.State, StateFailed)
+	}
+	if assoc, _ := store.IsCertHashAssociated(r, "old"); !assoc {
+		t.Error("old certificate not associated again")
+	}
+
+	// stale updates do not overwrite newer states
+	rot.State = StatePending
+	if updated, err := store.UpdateIdentityRotation(ctx, rot, StatePresented, ""); err != nil || updated {
+		t.Errorf("compare-and-set: have %v (%v), want false", updated, err)
+	}
+}
+
+func TestInProgress(t *testing.T) {
+	s := New(nil, nil, WithTimeout(time.Hour))
+	for _, test := range []struct {
+		state   string
+		started time.Duration
+		want    bool
+	}{
+		{StatePending, time.Minute, true},
+		{StateInstalled, time.Minute, true},
+		{StatePresented, time.Minute, true},
+		{StateInstalled, 2 * time.Hour, false},
+		{StateRotated, time.Minute, false},
+		{StateFailed, time.Minute, false},
+	} {
+		rot := &storage.IdentityRotation{State: test.state, StartedAt: time.Now().Add(-test.started)}
+		if have := s.inProgress(rot); have != test.want {
+			t.Errorf("%s started %s ago: have %v, want %v", test.state, test.started, have, test.want)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// ErrInvalidFilter is returned (wrapped) for filter expressions that
+// fail to parse.
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// attributes are the inventory attributes of filters by name.
+var attributes = map[string]func(*storage.DeviceInventory) (string, bool){
+	"serial_number": stringAttr(func(inv *storage.DeviceInventory) string { return inv.SerialNumber }),
+	"model":         stringAttr(func(inv *storage.DeviceInventory) string { return inv.Model }),
+	"model_name":    stringAttr(func(inv *storage.DeviceInventory) string { return inv.ModelName }),
+	"product_name":  stringAttr(func(inv *storage.DeviceInventory) string { return inv.ProductName }),
+	"device_name":   stringAttr(func(inv *storage.DeviceInventory) string { return inv.DeviceName }),
+	"os_version":    stringAttr(func(inv *storage.DeviceInventory) string { return inv.OSVersion }),
+	"build_version": stringAttr(func(inv *storage.DeviceInventory) string { return inv.BuildVersion }),
+	"filevault_enabled": func(inv *storage.DeviceInventory) (string, bool) {
+		if inv.FileVaultEnabled == nil {
+			return "", false
+		}
+		return strconv.FormatBool(*inv.FileVaultEnabled), true
+	},
+}
+
+// stringAttr returns the attribute function of a string attribute that
+// is unknown when empty.
+func stringAttr(f func(*storage.DeviceInventory) string) func(*storage.DeviceInventory) (string, bool) {
+	return func(inv *storage.DeviceInventory) (string, bool) {
+		v := f(inv)
+		return v, v != ""
+	}
+}
+
+// operators are the comparison operators of filters.
+var operators = map[string]bool{"=": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true, "~": true}
+
+// clause compares an inventory attribute against a value.
+type clause struct {
+	attr  string
+	op    string
+	value string
+}
+
+// Filter is a parsed inventory filter expression. See ParseFilter.
+type Filter struct {
+	// any of the all of the clauses must match
+	terms [][]clause
+}
+
+// ParseFilter parses the inventory filter expression expr. Expressions
+// are comparisons of inventory attributes (the JSON names of
+// storage.DeviceInventory, e.g. os_version or filevault_enabled)
+// joined by "and" and "or" (with "and" binding tighter) such as:
+//
+//	os_version < 14.4 and model_name ~ mac
+//	filevault_enabled = false or serial_number = "C02 ABC"
+//
+// The operators are =, !=, <, <=, >, >=, and ~ (contains, ignoring
+// case). The ordering operators compare dotted version strings
+// numerically (so "9.1" is less than "10.0") and fall back to string
+// comparison. Values containing spaces or operators are double-quoted.
+// Boolean attributes compare against true or false (or on or off).
+// Enrollments without a (non-empty) attribute never match comparisons
+// of it.
+func ParseFilter(expr string) (*Filter, error) {
+	tokens, err := scan(expr)
+	if err != nil {
+		return nil, err
+	}
+	f := &Filter{terms: [][]clause{nil}}
+	for len(tokens) > 0 {
+		if len(tokens) < 3 {
+			return nil, fmt.Errorf("%w: incomplete comparison: %s", ErrInvalidFilter, strings.Join(tokens, " "))
+		}
+		c := clause{attr: strings.ToLower(tokens[0]), op: tokens[1], value: tokens[2]}
+		if _, ok := attributes[c.attr]; !ok {
+			return nil, fmt.Errorf("%w: unknown attribute: %s", ErrInvalidFilter, tokens[0])
+		}
+		if !operators[c.op] {
+			return nil, fmt.Errorf("%w: unknown operator: %s", ErrInvalidFilter, c.op)
+		}
+		if c.attr == "filevault_enabled" {
+			switch strings.ToLower(c.value) {
+			case "true", "on":
+				c.value = "true"
+			case "false", "off":
+				c.value = "false"
+			default:
+				return nil, fmt.Errorf("%w: not a boolean: %s", ErrInvalidFilter, c.value)
+			}
+		}
+		last := len(f.terms) - 1
+		f.terms[last] = append(f.terms[last], c)
+		tokens = tokens[3:]
+		if len(tokens) < 1 {
+			break
+		}
+		switch strings.ToLower(tokens[0]) {
+		case "and":
+		case "or":
+			f.terms = append(f.terms, nil)
+		default:
+			return nil, fmt.Errorf("%w: expected and or or: %s", ErrInvalidFilter, tokens[0])
+		}
+		if tokens = tokens[1:]; len(tokens) < 1 {
+			return nil, fmt.Errorf("%w: trailing and or or", ErrInvalidFilter)
+		}
+	}
+	if len(f.terms[0]) < 1 {
+		return nil, fmt.Errorf("%w: empty", ErrInvalidFilter)
+	}
+	return f, nil
+}
+
+// scan splits expr into words, operators, and (unquoted) quoted
+// strings.
+func scan(expr string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		switch c := expr[i]; {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '"':
+			end := i + 1
+			for ; end < len(expr) && expr[end] != '"'; end++ {
+				if expr[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(expr) {
+				return nil, fmt.Errorf("%w: unterminated string", ErrInvalidFilter)
+			}
+			s, err := strconv.Unquote(expr[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidFilter, err)
+			}
+			tokens = append(tokens, s)
+			i = end + 1
+		default:
+			isOp := strings.IndexByte("=!<>~", c) >= 0
+			end := i
+			for end < len(expr) && !strings.ContainsRune(" \t\n\"", rune(expr[end])) &&
+				(strings.IndexByte("=!<>~", expr[end]) >= 0) == isOp {
+				end++
+			}
+			tokens = append(tokens, expr[i:end])
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+// compareVersions compares dotted numeric version strings a and b.
+// It returns false for ok if either is not a dotted numeric version.
+func compareVersions(a, b string) (cmp int, ok bool) {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var an, bn int
+		var err error
+		if i < len(as) {
+			if an, err = strconv.Atoi(as[i]); err != nil {
+				return 0, false
+			}
+		}
+		if i < len(bs) {
+			if bn, err = strconv.Atoi(bs[i]); err != nil {
+				return 0, false
+			}
+		}
+		if an != bn {
+			if an < bn {
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, true
+}
+
+// match evaluates clause c against inv.
+func (c *clause) match(inv *storage.DeviceInventory) bool {
+	v, ok := attributes[c.attr](inv)
+	if !ok {
+		return false
+	}
+	if c.op == "~" {
+		return strings.Contains(strings.ToLower(v), strings.ToLower(c.value))
+	}
+	cmp, ok := compareVersions(v, c.value)
+	if !ok {
+		cmp = strings.Compare(v, c.value)
+	}
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// Match reports whether inv matches f.
+func (f *Filter) Match(inv *storage.DeviceInventory) bool {
+	for _, term := range f.terms {
+		matched := true
+		for i := range term {
+			if !term[i].match(inv) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Members returns the sorted enrollment IDs of the inventories that
+// match f.
+func (f *Filter) Members(invs []*storage.DeviceInventory) []string {
+	var ids []string
+	for _, inv := range invs {
+		if f.Match(inv) {
+			ids = append(ids, inv.ID)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
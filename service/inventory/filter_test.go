@@ -0,0 +1,57 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+func TestFilter(t *testing.T) {
+	on, off := true, false
+	invs := []*storage.DeviceInventory{
+		{ID: "A", OSVersion: "14.3.1", ModelName: "MacBook Pro", FileVaultEnabled: &on},
+		{ID: "B", OSVersion: "14.4", ModelName: "MacBook Air", FileVaultEnabled: &off},
+		{ID: "C", OSVersion: "9.1", ModelName: "Mac mini"},
+		{ID: "D", DeviceName: "Lab 1"},
+	}
+	for _, test := range []struct {
+		expr string
+		want string
+	}{
+		{"os_version < 14.4", "[A C]"},
+		{"os_version >= 10", "[A B]"},
+		{"filevault_enabled = off", "[B]"},
+		{"filevault_enabled != true", "[B]"},
+		{"model_name ~ macbook and os_version <= 14.4", "[A B]"},
+		{"model_name ~ macbook and os_version < 14.4 or device_name = \"Lab 1\"", "[A D]"},
+		{"MODEL_NAME = \"Mac mini\" OR serial_number = X", "[C]"},
+		{"os_version>14", "[A B]"},
+	} {
+		f, err := ParseFilter(test.expr)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		if have := fmt.Sprint(f.Members(invs)); have != test.want {
+			t.Errorf("%s: have %s, want %s", test.expr, have, test.want)
+		}
+	}
+
+	for _, expr := range []string{
+		"",
+		"os_version",
+		"os_version <",
+		"color = red",
+		"os_version == 14",
+		"filevault_enabled = maybe",
+		"os_version < 14 and",
+		"os_version < 14 os_version > 12",
+		"device_name = \"Lab",
+	} {
+		if _, err := ParseFilter(expr); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("%q: have %v, want %v", expr, err, ErrInvalidFilter)
+		}
+	}
+}
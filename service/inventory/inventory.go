@@ -0,0 +1,94 @@
+// Package inventory is a NanoMDM service that collects device
+// attributes from command results.
+package inventory
+
+import (
+	"time"
+
+	"github.com/groob/plist"
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// Inventory is a service that parses acknowledged DeviceInformation
+// and SecurityInfo command results and stores well-known device
+// attributes. It is intended to run alongside the core NanoMDM service
+// (i.e. with the multi service) so that enrollment IDs are resolved.
+type Inventory struct {
+	logger log.Logger
+	store  storage.InventoryStore
+}
+
+// New creates a new inventory service.
+func New(store storage.InventoryStore, logger log.Logger) *Inventory {
+	return &Inventory{store: store, logger: logger}
+}
+
+// results contains the command result fields we collect.
+type results struct {
+	QueryResponses *struct {
+		SerialNumber string
+		Model        string
+		ModelName    string
+		ProductName  string
+		DeviceName   string
+		OSVersion    string
+		BuildVersion string
+	}
+	SecurityInfo *struct {
+		FDE_Enabled *bool
+	}
+}
+
+// parse extracts device inventory from raw command results. It returns
+// nil if no inventory is present.
+func parse(raw []byte) (*storage.DeviceInventory, error) {
+	res := new(results)
+	if err := plist.Unmarshal(raw, res); err != nil {
+		return nil, err
+	}
+	if res.QueryResponses == nil && (res.SecurityInfo == nil || res.SecurityInfo.FDE_Enabled == nil) {
+		return nil, nil
+	}
+	inv := &storage.DeviceInventory{UpdatedAt: time.Now()}
+	if q := res.QueryResponses; q != nil {
+		inv.SerialNumber = q.SerialNumber
+		inv.Model = q.Model
+		inv.ModelName = q.ModelName
+		inv.ProductName = q.ProductName
+		inv.DeviceName = q.DeviceName
+		inv.OSVersion = q.OSVersion
+		inv.BuildVersion = q.BuildVersion
+	}
+	if res.SecurityInfo != nil {
+		inv.FileVaultEnabled = res.SecurityInfo.FDE_Enabled
+	}
+	return inv, nil
+}
+
+func (s *Inventory) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	return nil
+}
+
+func (s *Inventory) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	return nil
+}
+
+func (s *Inventory) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	return nil
+}
+
+func (s *Inventory) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	if results.Status != "Acknowledged" || r.EnrollID == nil || r.ParentID != "" {
+		// only collect from device channels
+		return nil, nil
+	}
+	inv, err := parse(results.Raw)
+	if err != nil || inv == nil {
+		return nil, err
+	}
+	inv.ID = r.ID
+	s.logger.Debug("msg", "storing inventory", "id", r.ID, "command_uuid", results.CommandUUID)
+	return nil, s.store.StoreInventory(r.Context, inv)
+}
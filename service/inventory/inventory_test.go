@@ -0,0 +1,47 @@
+package inventory
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	inv, err := parse([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>CommandUUID</key><string>a</string>
+	<key>QueryResponses</key>
+	<dict>
+		<key>SerialNumber</key><string>C02ABC</string>
+		<key>OSVersion</key><string>13.1</string>
+	</dict>
+	<key>Status</key><string>Acknowledged</string>
+</dict>
+</plist>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv == nil || inv.SerialNumber != "C02ABC" || inv.OSVersion != "13.1" || inv.FileVaultEnabled != nil {
+		t.Errorf("unexpected inventory: %+v", inv)
+	}
+
+	inv, err = parse([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>SecurityInfo</key>
+	<dict>
+		<key>FDE_Enabled</key><true/>
+	</dict>
+	<key>Status</key><string>Acknowledged</string>
+</dict>
+</plist>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv == nil || inv.FileVaultEnabled == nil || !*inv.FileVaultEnabled {
+		t.Errorf("unexpected inventory: %+v", inv)
+	}
+
+	inv, err = parse([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0"><dict><key>Status</key><string>Acknowledged</string></dict></plist>`))
+	if err != nil || inv != nil {
+		t.Errorf("expected no inventory: %+v, %v", inv, err)
+	}
+}
@@ -0,0 +1,112 @@
+// Package lostmode is a NanoMDM service that tracks the Lost Mode state
+// and location of enrollments from command results.
+package lostmode
+
+import (
+	"time"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// Lost Mode states of an enrollment.
+const (
+	StateEnabling  = "enabling"
+	StateEnabled   = "enabled"
+	StateDisabling = "disabling"
+	StateDisabled  = "disabled"
+)
+
+// LostMode is a service that updates Lost Mode states from
+// EnableLostMode, DisableLostMode, and DeviceLocation command results.
+// It is intended to run alongside the core NanoMDM service (i.e. with
+// the multi service) so that enrollment IDs are resolved.
+type LostMode struct {
+	logger log.Logger
+	store  storage.LostModeStore
+}
+
+// New creates a new Lost Mode service.
+func New(store storage.LostModeStore, logger log.Logger) *LostMode {
+	return &LostMode{store: store, logger: logger}
+}
+
+// Apply updates st from the raw results of its last Lost Mode command.
+func Apply(st *storage.LostModeState, results *mdm.CommandResults) error {
+	if results.Status == "Error" {
+		st.Error = "command error"
+		if len(results.ErrorChain) > 0 {
+			st.Error = results.ErrorChain[0].USEnglishDescription
+		}
+		// the device did not change its Lost Mode
+		switch st.State {
+		case StateEnabling:
+			st.State = StateDisabled
+		case StateDisabling:
+			st.State = StateEnabled
+		}
+		return nil
+	}
+	if results.Status != "Acknowledged" {
+		return nil
+	}
+	st.Error = ""
+	switch st.RequestType {
+	case "EnableLostMode":
+		st.State = StateEnabled
+	case "DisableLostMode":
+		st.State = StateDisabled
+	case "DeviceLocation":
+		loc, err := mdm.DecodeDeviceLocationResults(results.Raw)
+		if err != nil {
+			return err
+		}
+		st.Latitude = &loc.Latitude
+		st.Longitude = &loc.Longitude
+		st.HorizontalAccuracy = &loc.HorizontalAccuracy
+		locatedAt := loc.Time()
+		if locatedAt.IsZero() {
+			locatedAt = time.Now()
+		}
+		st.LocatedAt = &locatedAt
+	}
+	return nil
+}
+
+func (s *LostMode) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	return nil
+}
+
+func (s *LostMode) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	return nil
+}
+
+func (s *LostMode) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	return nil
+}
+
+func (s *LostMode) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	if results.Status == "Idle" || results.Status == "NotNow" || r.EnrollID == nil || r.ParentID != "" {
+		return nil, nil
+	}
+	states, err := s.store.RetrieveLostMode(r.Context, []string{r.ID})
+	if err != nil || len(states) < 1 || states[0].CommandUUID != results.CommandUUID {
+		return nil, err
+	}
+	st := states[0]
+	if err = Apply(st, results); err != nil {
+		return nil, err
+	}
+	st.UpdatedAt = time.Now()
+	// locations are deliberately not logged
+	s.logger.Info(
+		"msg", "lost mode",
+		"id", r.ID,
+		"command_uuid", results.CommandUUID,
+		"request_type", st.RequestType,
+		"status", results.Status,
+		"state", st.State,
+	)
+	return nil, s.store.StoreLostMode(r.Context, st)
+}
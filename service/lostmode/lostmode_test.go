@@ -0,0 +1,48 @@
+package lostmode
+
+import (
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+func TestApply(t *testing.T) {
+	st := &storage.LostModeState{State: StateEnabling, RequestType: "EnableLostMode"}
+	if err := Apply(st, &mdm.CommandResults{Status: "Acknowledged"}); err != nil {
+		t.Fatal(err)
+	}
+	if st.State != StateEnabled {
+		t.Errorf("state: have %q, want %q", st.State, StateEnabled)
+	}
+
+	st.RequestType = "DeviceLocation"
+	err := Apply(st, &mdm.CommandResults{Status: "Acknowledged", Raw: []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>Latitude</key><real>37.33</real>
+	<key>Longitude</key><real>-122.03</real>
+	<key>HorizontalAccuracy</key><real>10</real>
+	<key>Timestamp</key><string>2023-01-02T03:04:05Z</string>
+	<key>Status</key><string>Acknowledged</string>
+</dict>
+</plist>`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Latitude == nil || *st.Latitude != 37.33 || st.LocatedAt == nil || st.LocatedAt.Year() != 2023 {
+		t.Errorf("unexpected location: %+v", st)
+	}
+	if st.State != StateEnabled {
+		t.Errorf("state: have %q, want %q", st.State, StateEnabled)
+	}
+
+	st.State, st.RequestType = StateDisabling, "DisableLostMode"
+	err = Apply(st, &mdm.CommandResults{Status: "Error", ErrorChain: []mdm.ErrorChain{{USEnglishDescription: "failed"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.State != StateEnabled || st.Error != "failed" {
+		t.Errorf("unexpected error state: %q (%q)", st.State, st.Error)
+	}
+}
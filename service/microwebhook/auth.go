@@ -0,0 +1,139 @@
+package microwebhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenSource provides bearer tokens to authenticate webhook requests.
+type TokenSource interface {
+	// Token returns a (possibly cached) bearer token.
+	Token(ctx context.Context) (string, error)
+	// Invalidate discards a cached token (e.g. after the receiver
+	// rejected it) so the next Token fetches a new one.
+	Invalidate()
+}
+
+// StaticToken is a fixed bearer token.
+type StaticToken string
+
+// Token returns t.
+func (t StaticToken) Token(_ context.Context) (string, error) {
+	return string(t), nil
+}
+
+// Invalidate does nothing as static tokens can not be refreshed.
+func (t StaticToken) Invalidate() {}
+
+// expirySkew is how long before expiry a cached token is refreshed.
+const expirySkew = 30 * time.Second
+
+// tokenCache caches the bearer tokens fetched by fetch until shortly
+// before they expire.
+type tokenCache struct {
+	fetch func(ctx context.Context) (token string, expiresIn int64, err error)
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// Token returns the cached token or fetches a new one.
+func (c *tokenCache) Token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && (c.expires.IsZero() || time.Now().Before(c.expires)) {
+		return c.token, nil
+	}
+	token, expiresIn, err := c.fetch(ctx)
+	if err != nil {
+		return "", fmt.Errorf("fetching webhook token: %w", err)
+	}
+	c.token, c.expires = token, time.Time{}
+	if expiresIn > 0 {
+		c.expires = time.Now().Add(time.Duration(expiresIn)*time.Second - expirySkew)
+	}
+	return c.token, nil
+}
+
+// Invalidate discards the cached token.
+func (c *tokenCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = ""
+}
+
+// ClientCredentials fetches bearer tokens from an OAuth 2.0 token
+// endpoint using the client credentials grant (RFC 6749 section 4.4).
+// Tokens are cached until shortly before they expire.
+type ClientCredentials struct {
+	tokenCache
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	client       *http.Client
+}
+
+// NewClientCredentials creates a new client credentials token source.
+func NewClientCredentials(tokenURL, clientID, clientSecret string, scopes []string) *ClientCredentials {
+	c := &ClientCredentials{
+		tokenURL:     tokenURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		scopes:       scopes,
+		client:       http.DefaultClient,
+	}
+	c.fetch = c.fetchToken
+	return c
+}
+
+// fetchToken requests a new token from the token endpoint.
+func (c *ClientCredentials) fetchToken(ctx context.Context) (string, int64, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(c.scopes) > 0 {
+		form.Set("scope", strings.Join(c.scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(c.clientID), url.QueryEscape(c.clientSecret))
+	return requestToken(c.client, req)
+}
+
+// requestToken performs the token request req and decodes the bearer
+// token (and its lifetime in seconds) from the JSON response.
+func requestToken(client *http.Client, req *http.Request) (string, int64, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", 0, fmt.Errorf("unexpected HTTP status %d %s", resp.StatusCode, resp.Status)
+	}
+	tok := new(struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	})
+	if err = json.NewDecoder(resp.Body).Decode(tok); err != nil {
+		return "", 0, err
+	}
+	if tok.AccessToken == "" {
+		return "", 0, errors.New("no access token")
+	}
+	if tok.TokenType != "" && !strings.EqualFold(tok.TokenType, "bearer") {
+		return "", 0, fmt.Errorf("unsupported token type: %s", tok.TokenType)
+	}
+	return tok.AccessToken, tok.ExpiresIn, nil
+}
@@ -0,0 +1,43 @@
+package microwebhook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientCredentials(t *testing.T) {
+	var issued int
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "id" || secret != "secret" || r.FormValue("grant_type") != "client_credentials" {
+			http.Error(w, "bad client", http.StatusUnauthorized)
+			return
+		}
+		issued++
+		fmt.Fprintf(w, `{"access_token": "token%d", "token_type": "Bearer", "expires_in": 3600}`, issued)
+	}))
+	defer tokenSrv.Close()
+
+	// the receiver only accepts the second token to force a refresh
+	var auths []string
+	hookSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auths = append(auths, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") != "Bearer token2" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer hookSrv.Close()
+
+	w := New(hookSrv.URL, WithTokenSource(NewClientCredentials(tokenSrv.URL, "id", "secret", nil)))
+	for i := 0; i < 2; i++ {
+		if err := w.PostEvent(context.Background(), &Event{Topic: "test"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the second post uses the cached token
+	if want := []string{"Bearer token1", "Bearer token2", "Bearer token2"}; fmt.Sprint(auths) != fmt.Sprint(want) {
+		t.Errorf("have %v, want %v", auths, want)
+	}
+}
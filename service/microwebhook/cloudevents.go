@@ -0,0 +1,108 @@
+package microwebhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/cmdplist"
+)
+
+// SchemaVersion is the version of the event payload schemas. It is
+// incremented for incompatible changes to the payload of any event
+// type and is part of the CloudEvents dataschema.
+const SchemaVersion = 1
+
+// CloudEvent is a CNCF CloudEvents 1.0 event in the JSON (structured
+// mode) format.
+type CloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`
+	Subject         string      `json:"subject,omitempty"`
+	Time            time.Time   `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	DataSchema      string      `json:"dataschema"`
+	Data            interface{} `json:"data"`
+}
+
+// payload returns the payload kind (its JSON key, e.g. "checkin_event"),
+// the payload, and the enrollment it is about.
+func (ev *Event) payload() (string, interface{}, string) {
+	pick := func(ids ...string) string {
+		for _, id := range ids {
+			if id != "" {
+				return id
+			}
+		}
+		return ""
+	}
+	switch {
+	case ev.AcknowledgeEvent != nil:
+		e := ev.AcknowledgeEvent
+		return "acknowledge_event", e, pick(e.EnrollmentID, e.UDID)
+	case ev.CheckinEvent != nil:
+		e := ev.CheckinEvent
+		return "checkin_event", e, pick(e.EnrollmentID, e.UDID)
+	case ev.ComplianceEvent != nil:
+		return "compliance_event", ev.ComplianceEvent, ev.ComplianceEvent.ID
+	case ev.AppInventoryEvent != nil:
+		return "app_inventory_event", ev.AppInventoryEvent, ev.AppInventoryEvent.ID
+	case ev.StuckEvent != nil:
+		return "stuck_event", ev.StuckEvent, ev.StuckEvent.ID
+	case ev.SetupEvent != nil:
+		return "setup_event", ev.SetupEvent, ev.SetupEvent.ID
+	case ev.QuotaEvent != nil:
+		return "quota_event", ev.QuotaEvent, ev.QuotaEvent.ID
+	case ev.SecurityEvent != nil:
+		return "security_event", ev.SecurityEvent, ev.SecurityEvent.ID
+	default:
+		return "event", nil, ""
+	}
+}
+
+// NewCloudEvent wraps the payload of ev in a CloudEvent from source.
+// The event type is the event topic (e.g. "mdm.Authenticate") and the
+// dataschema names the payload kind and SchemaVersion (e.g.
+// "urn:nanomdm:schema:checkin_event:1").
+func NewCloudEvent(source string, ev *Event) *CloudEvent {
+	kind, data, subject := ev.payload()
+	id := ev.EventID
+	if id == "" {
+		id = cmdplist.NewUUID()
+	}
+	created := ev.CreatedAt
+	if created.IsZero() {
+		created = time.Now()
+	}
+	return &CloudEvent{
+		SpecVersion:     "1.0",
+		ID:              id,
+		Source:          source,
+		Type:            ev.Topic,
+		Subject:         subject,
+		Time:            created,
+		DataContentType: "application/json",
+		DataSchema:      fmt.Sprintf("urn:nanomdm:schema:%s:%d", kind, SchemaVersion),
+		Data:            data,
+	}
+}
+
+// encoder encodes events for publishing. It returns the encoded event
+// and its content type.
+type encoder func(ev *Event) ([]byte, string, error)
+
+// encodeMicroMDM encodes events in the MicroMDM-compatible format.
+func encodeMicroMDM(ev *Event) ([]byte, string, error) {
+	b, err := json.MarshalIndent(ev, "", "\t")
+	return b, "application/json; charset=utf-8", err
+}
+
+// cloudEventsEncoder encodes events as CloudEvents from source.
+func cloudEventsEncoder(source string) encoder {
+	return func(ev *Event) ([]byte, string, error) {
+		b, err := json.Marshal(NewCloudEvent(source, ev))
+		return b, "application/cloudevents+json; charset=utf-8", err
+	}
+}
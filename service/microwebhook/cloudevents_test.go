@@ -0,0 +1,45 @@
+package microwebhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCloudEvents(t *testing.T) {
+	var contentType string
+	ce := new(struct {
+		CloudEvent
+		Data *CheckinEvent `json:"data"`
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		json.NewDecoder(r.Body).Decode(ce)
+	}))
+	defer srv.Close()
+
+	w := New(srv.URL, WithCloudEvents("/nanomdm"))
+	ev := &Event{
+		Topic:        "mdm.TokenUpdate",
+		CreatedAt:    time.Now(),
+		CheckinEvent: &CheckinEvent{UDID: "UDID", RawPayload: []byte("raw")},
+	}
+	if err := w.PostEvent(context.Background(), ev); err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "application/cloudevents+json; charset=utf-8" {
+		t.Errorf("unexpected content type: %s", contentType)
+	}
+	if ce.SpecVersion != "1.0" || ce.ID == "" || ce.Source != "/nanomdm" || ce.Type != "mdm.TokenUpdate" || ce.Subject != "UDID" {
+		t.Errorf("unexpected attributes: %+v", ce.CloudEvent)
+	}
+	if ce.DataSchema != "urn:nanomdm:schema:checkin_event:1" {
+		t.Errorf("unexpected dataschema: %s", ce.DataSchema)
+	}
+	if ce.Data == nil || string(ce.Data.RawPayload) != "raw" {
+		t.Errorf("unexpected data: %+v", ce.Data)
+	}
+}
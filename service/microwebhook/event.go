@@ -1,6 +1,15 @@
 package microwebhook
 
-import "time"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// IdempotencyKeyHeader is the HTTP webhook request header that carries
+// the EventID so receivers can discard redelivered events.
+const IdempotencyKeyHeader = "Idempotency-Key"
 
 type Event struct {
 	Topic     string    `json:"topic"`
@@ -9,6 +18,14 @@ type Event struct {
 
 	AcknowledgeEvent *AcknowledgeEvent `json:"acknowledge_event,omitempty"`
 	CheckinEvent     *CheckinEvent     `json:"checkin_event,omitempty"`
+	ComplianceEvent  *ComplianceEvent  `json:"compliance_event,omitempty"`
+
+	AppInventoryEvent *AppInventoryEvent `json:"app_inventory_event,omitempty"`
+	StuckEvent        *StuckEvent        `json:"stuck_event,omitempty"`
+	SetupEvent        *SetupEvent        `json:"setup_event,omitempty"`
+	QuotaEvent        *QuotaEvent        `json:"quota_event,omitempty"`
+	SecurityEvent     *SecurityEvent     `json:"security_event,omitempty"`
+	WorkflowEvent     *WorkflowEvent     `json:"workflow_event,omitempty"`
 }
 
 type AcknowledgeEvent struct {
@@ -18,6 +35,11 @@ type AcknowledgeEvent struct {
 	CommandUUID  string            `json:"command_uuid,omitempty"`
 	Params       map[string]string `json:"url_params,omitempty"`
 	RawPayload   []byte            `json:"raw_payload"`
+	// RawPayloadURL is the URL of the raw payload if it was offloaded
+	// to blob storage for its size. RawPayload is then empty (or
+	// truncated, see WithPayloadLimit).
+	RawPayloadURL       string `json:"raw_payload_url,omitempty"`
+	RawPayloadTruncated bool   `json:"raw_payload_truncated,omitempty"`
 }
 
 type CheckinEvent struct {
@@ -25,4 +47,129 @@ type CheckinEvent struct {
 	EnrollmentID string            `json:"enrollment_id,omitempty"`
 	Params       map[string]string `json:"url_params"`
 	RawPayload   []byte            `json:"raw_payload"`
+	// See AcknowledgeEvent and WithPayloadLimit.
+	RawPayloadURL       string `json:"raw_payload_url,omitempty"`
+	RawPayloadTruncated bool   `json:"raw_payload_truncated,omitempty"`
+}
+
+// ComplianceEvent is sent when an enrollment matches a compliance rule.
+type ComplianceEvent struct {
+	Rule         string   `json:"rule"`
+	ID           string   `json:"id"`
+	UDID         string   `json:"udid,omitempty"`
+	EnrollmentID string   `json:"enrollment_id,omitempty"`
+	CommandUUIDs []string `json:"command_uuids,omitempty"`
+	RawPayload   []byte   `json:"raw_payload"`
+	// See AcknowledgeEvent and WithPayloadLimit.
+	RawPayloadURL       string `json:"raw_payload_url,omitempty"`
+	RawPayloadTruncated bool   `json:"raw_payload_truncated,omitempty"`
+}
+
+// AppInventoryEvent is sent when the applications installed on an
+// enrollment change.
+type AppInventoryEvent struct {
+	ID      string      `json:"id"`
+	Added   []AppChange `json:"added,omitempty"`
+	Removed []AppChange `json:"removed,omitempty"`
+	Changed []AppChange `json:"changed,omitempty"`
+}
+
+// AppChange is a single application in an AppInventoryEvent. Previous
+// values are set for changed applications.
+type AppChange struct {
+	Identifier            string `json:"identifier"`
+	Name                  string `json:"name,omitempty"`
+	Version               string `json:"version,omitempty"`
+	ShortVersion          string `json:"short_version,omitempty"`
+	ManagedStatus         string `json:"managed_status,omitempty"`
+	PreviousVersion       string `json:"previous_version,omitempty"`
+	PreviousShortVersion  string `json:"previous_short_version,omitempty"`
+	PreviousManagedStatus string `json:"previous_managed_status,omitempty"`
+}
+
+// StuckEvent is sent when an enrollment becomes stuck (has pending
+// commands but has not been seen within a threshold) or, with Stuck
+// false, when it is no longer stuck.
+type StuckEvent struct {
+	ID               string     `json:"id"`
+	DeviceID         string     `json:"device_id,omitempty"`
+	Stuck            bool       `json:"stuck"`
+	Pending          int        `json:"pending"`
+	OldestEnqueuedAt *time.Time `json:"oldest_enqueued_at,omitempty"`
+	LastSeenAt       *time.Time `json:"last_seen_at,omitempty"`
+}
+
+// SetupEvent is sent to approve the release of a device awaiting
+// configuration.
+type SetupEvent struct {
+	ID           string `json:"id"`
+	UDID         string `json:"udid,omitempty"`
+	EnrollmentID string `json:"enrollment_id,omitempty"`
+	RawPayload   []byte `json:"raw_payload"`
+	// See AcknowledgeEvent and WithPayloadLimit.
+	RawPayloadURL       string `json:"raw_payload_url,omitempty"`
+	RawPayloadTruncated bool   `json:"raw_payload_truncated,omitempty"`
+}
+
+// QuotaEvent is sent when an Authenticate is rejected because the
+// enrollment quota (global or of the APNs topic) is reached.
+type QuotaEvent struct {
+	ID           string `json:"id"`
+	UDID         string `json:"udid,omitempty"`
+	EnrollmentID string `json:"enrollment_id,omitempty"`
+	// Topic is set if the quota of the APNs topic is reached.
+	Topic       string `json:"topic,omitempty"`
+	Limit       int    `json:"limit"`
+	Enrollments int    `json:"enrollments"`
+}
+
+// SecurityEvent is sent when a client address or enrollment is blocked
+// after repeated authentication failures on the device endpoints.
+type SecurityEvent struct {
+	// Kind is the kind of the last failure: "signature" for failed
+	// signature or certificate verification and "certauth" for
+	// certificate to enrollment association mismatches.
+	Kind string `json:"kind"`
+	// Source is the client address of the last failure.
+	Source string `json:"source,omitempty"`
+	// ID is the enrollment ID blocked (if any).
+	ID           string    `json:"id,omitempty"`
+	Failures     int       `json:"failures"`
+	BlockedUntil time.Time `json:"blocked_until"`
+	Reason       string    `json:"reason,omitempty"`
+}
+
+// StableEventID returns the deduplication key of ev about the
+// enrollment id (or, if empty, the enrollment of the payload): a hash
+// of the enrollment ID, the event topic, and the event payload.
+// Unlike the CreatedAt time it is the same for every delivery of the
+// same event so that receivers of at-least-once deliveries can
+// process each event once. Identical payloads (e.g. repeated Idle
+// command reports) of an enrollment share an event ID.
+func StableEventID(id string, ev *Event) string {
+	_, payload, subject := ev.payload()
+	if id == "" {
+		id = subject
+	}
+	b, _ := json.Marshal(payload)
+	h := sha256.New()
+	h.Write([]byte(id))
+	h.Write([]byte{0})
+	h.Write([]byte(ev.Topic))
+	h.Write([]byte{0})
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// WorkflowEvent is sent when a workflow run of an enrollment completes
+// or fails.
+type WorkflowEvent struct {
+	ID       string `json:"id"`
+	Workflow string `json:"workflow"`
+	// State is completed or failed.
+	State string `json:"state"`
+	// Step is the name of the last step run.
+	Step      string    `json:"step"`
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"started_at"`
 }
@@ -0,0 +1,208 @@
+package microwebhook
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"unicode"
+)
+
+// Filter is a parsed webhook event filter expression. An expression is
+// made of comparisons of event fields with values combined with and,
+// or, not, and parentheses. For example:
+//
+//	topic != mdm.Connect or (status != Idle and status != Acknowledged)
+//
+// Comparisons are field = value or field != value. Values may be quoted
+// and may contain glob patterns (e.g. request_type = Install*). The
+// fields are:
+//
+//	topic         the event topic (e.g. mdm.Authenticate)
+//	type          the enrollment type (e.g. Device or User)
+//	request_type  the RequestType of the command of a command report
+//	status        the status of a command report (e.g. Idle)
+//
+// Fields that are unknown for an event (e.g. the status of a check-in)
+// are empty.
+type Filter struct {
+	root   node
+	fields map[string]bool
+}
+
+// filterFields are the fields of filter expressions.
+var filterFields = map[string]bool{"topic": true, "type": true, "request_type": true, "status": true}
+
+// node is a node of a filter expression tree.
+type node interface {
+	match(vars map[string]string) bool
+}
+
+type notNode struct{ n node }
+
+func (n notNode) match(vars map[string]string) bool { return !n.n.match(vars) }
+
+type andNode struct{ l, r node }
+
+func (n andNode) match(vars map[string]string) bool { return n.l.match(vars) && n.r.match(vars) }
+
+type orNode struct{ l, r node }
+
+func (n orNode) match(vars map[string]string) bool { return n.l.match(vars) || n.r.match(vars) }
+
+type cmpNode struct {
+	field, pattern string
+	negate         bool
+}
+
+func (n cmpNode) match(vars map[string]string) bool {
+	ok, _ := path.Match(n.pattern, vars[n.field])
+	return ok != n.negate
+}
+
+// ParseFilter parses the filter expression expr.
+func ParseFilter(expr string) (*Filter, error) {
+	toks, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{toks: toks, fields: make(map[string]bool)}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("filter: unexpected %q", p.toks[p.pos])
+	}
+	return &Filter{root: root, fields: p.fields}, nil
+}
+
+// Match reports whether the event fields vars match f.
+func (f *Filter) Match(vars map[string]string) bool {
+	return f.root.match(vars)
+}
+
+// Uses reports whether the expression of f compares field.
+func (f *Filter) Uses(field string) bool {
+	return f.fields[field]
+}
+
+// tokenize splits expr into parentheses, operators, and (unquoted)
+// words.
+func tokenize(expr string) ([]string, error) {
+	var toks []string
+	r := []rune(expr)
+	for i := 0; i < len(r); {
+		switch c := r[i]; {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(' || c == ')' || c == '=':
+			toks = append(toks, string(c))
+			i++
+		case c == '!':
+			if i+1 >= len(r) || r[i+1] != '=' {
+				return nil, errors.New("filter: expected != operator")
+			}
+			toks = append(toks, "!=")
+			i += 2
+		case c == '"':
+			j := i + 1
+			for j < len(r) && r[j] != '"' {
+				j++
+			}
+			if j >= len(r) {
+				return nil, errors.New("filter: unterminated quote")
+			}
+			// keep the opening quote to tell values from keywords
+			toks = append(toks, string(r[i:j]))
+			i = j + 1
+		default:
+			j := i
+			for j < len(r) && !unicode.IsSpace(r[j]) && !strings.ContainsRune(`()=!"`, r[j]) {
+				j++
+			}
+			toks = append(toks, string(r[i:j]))
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+// filterParser is a recursive descent parser of filter expressions.
+type filterParser struct {
+	toks   []string
+	pos    int
+	fields map[string]bool
+}
+
+func (p *filterParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *filterParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *filterParser) or() (node, error) {
+	l, err := p.and()
+	for err == nil && p.peek() == "or" {
+		p.next()
+		var r node
+		if r, err = p.and(); err == nil {
+			l = orNode{l, r}
+		}
+	}
+	return l, err
+}
+
+func (p *filterParser) and() (node, error) {
+	l, err := p.unary()
+	for err == nil && p.peek() == "and" {
+		p.next()
+		var r node
+		if r, err = p.unary(); err == nil {
+			l = andNode{l, r}
+		}
+	}
+	return l, err
+}
+
+func (p *filterParser) unary() (node, error) {
+	switch t := p.next(); t {
+	case "not":
+		n, err := p.unary()
+		return notNode{n}, err
+	case "(":
+		n, err := p.or()
+		if err == nil && p.next() != ")" {
+			err = errors.New("filter: expected )")
+		}
+		return n, err
+	case "":
+		return nil, errors.New("filter: unexpected end of expression")
+	default:
+		if !filterFields[t] {
+			return nil, fmt.Errorf("filter: unknown field %q", t)
+		}
+		op := p.next()
+		if op != "=" && op != "!=" {
+			return nil, fmt.Errorf("filter: expected = or != after %s", t)
+		}
+		v := p.next()
+		switch v {
+		case "", "(", ")", "=", "!=":
+			return nil, fmt.Errorf("filter: expected value after %s %s", t, op)
+		}
+		v = strings.TrimPrefix(v, `"`)
+		if _, err := path.Match(v, ""); err != nil {
+			return nil, fmt.Errorf("filter: invalid pattern %q: %w", v, err)
+		}
+		p.fields[t] = true
+		return cmpNode{field: t, pattern: v, negate: op == "!="}, nil
+	}
+}
@@ -0,0 +1,34 @@
+package microwebhook
+
+import "testing"
+
+func TestFilter(t *testing.T) {
+	idle := map[string]string{"topic": "mdm.Connect", "type": "Device", "status": "Idle"}
+	ack := map[string]string{"topic": "mdm.Connect", "type": "User", "status": "Acknowledged", "request_type": "InstallProfile"}
+	checkin := map[string]string{"topic": "mdm.TokenUpdate", "type": "Device"}
+	for _, test := range []struct {
+		expr  string
+		match []bool // idle, ack, checkin
+	}{
+		{"status != Idle", []bool{false, true, true}},
+		{"topic != mdm.Connect or (status != Idle and status != Acknowledged)", []bool{false, false, true}},
+		{`not (type = User) and topic = "mdm.*"`, []bool{true, false, true}},
+		{"request_type = Install* or topic = mdm.TokenUpdate", []bool{false, true, true}},
+		{"status = Idle or status = Acknowledged and type = Device", []bool{true, false, false}},
+	} {
+		f, err := ParseFilter(test.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", test.expr, err)
+		}
+		for i, vars := range []map[string]string{idle, ack, checkin} {
+			if have := f.Match(vars); have != test.match[i] {
+				t.Errorf("%s: event %d: have %v, want %v", test.expr, i, have, test.match[i])
+			}
+		}
+	}
+	for _, expr := range []string{"", "status", "status =", "bogus = x", "(status = Idle", "status = Idle)", `topic = "x`, "status ! Idle", "status = [", "status = Idle and"} {
+		if _, err := ParseFilter(expr); err == nil {
+			t.Errorf("%q: expected error", expr)
+		}
+	}
+}
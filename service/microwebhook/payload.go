@@ -0,0 +1,83 @@
+package microwebhook
+
+import (
+	"context"
+	"fmt"
+)
+
+// PayloadPolicy is what is sent of raw payloads over the payload limit.
+type PayloadPolicy string
+
+const (
+	// PayloadOmit omits raw payloads over the limit.
+	PayloadOmit PayloadPolicy = "omit"
+	// PayloadTruncate truncates raw payloads to the limit.
+	PayloadTruncate PayloadPolicy = "truncate"
+)
+
+// WithPayloadLimit omits (or, with PayloadTruncate, truncates) the raw
+// payloads of events larger than limit bytes and flags them with
+// raw_payload_truncated, as very large events break some receivers.
+// With WithOffload the full raw payload is stored in blob storage
+// first and its URL sent as raw_payload_url (e.g. to fetch it from the
+// blob API).
+func WithPayloadLimit(limit int, policy PayloadPolicy) Option {
+	return func(w *MicroWebhook) {
+		w.payloadLimit = limit
+		w.payloadPolicy = policy
+	}
+}
+
+// rawPayload points to the raw payload fields of an event.
+type rawPayload struct {
+	raw       *[]byte
+	url       *string
+	truncated *bool
+}
+
+// rawPayload returns the raw payload fields of ev or nil if its kind of
+// event has no raw payload.
+func (ev *Event) rawPayload() *rawPayload {
+	switch {
+	case ev.AcknowledgeEvent != nil:
+		e := ev.AcknowledgeEvent
+		return &rawPayload{&e.RawPayload, &e.RawPayloadURL, &e.RawPayloadTruncated}
+	case ev.CheckinEvent != nil:
+		e := ev.CheckinEvent
+		return &rawPayload{&e.RawPayload, &e.RawPayloadURL, &e.RawPayloadTruncated}
+	case ev.ComplianceEvent != nil:
+		e := ev.ComplianceEvent
+		return &rawPayload{&e.RawPayload, &e.RawPayloadURL, &e.RawPayloadTruncated}
+	case ev.SetupEvent != nil:
+		e := ev.SetupEvent
+		return &rawPayload{&e.RawPayload, &e.RawPayloadURL, &e.RawPayloadTruncated}
+	default:
+		return nil
+	}
+}
+
+// limitPayload applies the payload limit to the raw payload of ev.
+// The raw payload is re-sliced, not modified.
+func (w *MicroWebhook) limitPayload(ctx context.Context, ev *Event) error {
+	if w.payloadLimit < 1 {
+		return nil
+	}
+	p := ev.rawPayload()
+	if p == nil || len(*p.raw) <= w.payloadLimit {
+		return nil
+	}
+	if w.offloader != nil && *p.url == "" {
+		url, err := w.offloader.Put(ctx, *p.raw)
+		if err != nil {
+			return fmt.Errorf("offloading raw payload: %w", err)
+		}
+		*p.url = url
+	}
+	if w.payloadPolicy == PayloadTruncate {
+		*p.raw = (*p.raw)[:w.payloadLimit]
+	} else {
+		*p.raw = nil
+	}
+	*p.truncated = true
+	return nil
+}
@@ -0,0 +1,54 @@
+package microwebhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/blob"
+)
+
+func TestPayloadLimit(t *testing.T) {
+	var ev *Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ev = new(Event)
+		json.NewDecoder(r.Body).Decode(ev)
+	}))
+	defer srv.Close()
+	dir, err := blob.NewDir(t.TempDir(), "/v1/blobs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := []byte("<?xml 0123456789")
+
+	for _, test := range []struct {
+		opts      []Option
+		raw       string
+		url       string
+		truncated bool
+	}{
+		{nil, string(raw), "", false},
+		{[]Option{WithPayloadLimit(len(raw), PayloadOmit)}, string(raw), "", false},
+		{[]Option{WithPayloadLimit(5, PayloadOmit)}, "", "", true},
+		{[]Option{WithPayloadLimit(5, PayloadTruncate)}, "<?xml", "", true},
+		// the offload threshold is above the payload limit
+		{[]Option{WithPayloadLimit(5, PayloadOmit), WithOffload(blob.NewOffloader(dir, 100))}, "", "/v1/blobs/" + blob.Key(raw), true},
+	} {
+		w := New(srv.URL, test.opts...)
+		if err := w.PostEvent(context.Background(), &Event{Topic: "mdm.Test", CheckinEvent: &CheckinEvent{RawPayload: raw}}); err != nil {
+			t.Fatal(err)
+		}
+		if ev == nil || ev.CheckinEvent == nil {
+			t.Fatal("no event")
+		}
+		e := ev.CheckinEvent
+		if string(e.RawPayload) != test.raw || e.RawPayloadURL != test.url || e.RawPayloadTruncated != test.truncated {
+			t.Errorf("have %q %q %v, want %q %q %v", e.RawPayload, e.RawPayloadURL, e.RawPayloadTruncated, test.raw, test.url, test.truncated)
+		}
+	}
+	if string(raw) != "<?xml 0123456789" {
+		t.Errorf("raw payload modified: %q", raw)
+	}
+}
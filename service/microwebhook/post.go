@@ -3,7 +3,6 @@ package microwebhook
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"net/http"
 )
@@ -11,19 +10,20 @@ import (
 func postWebhookEvent(
 	ctx context.Context,
 	client *http.Client,
+	tokens TokenSource,
+	secret []byte,
 	url string,
-	event *Event,
+	body []byte,
+	contentType string,
+	eventID string,
 ) error {
-	jsonBytes, err := json.MarshalIndent(event, "", "\t")
-	if err != nil {
-		return err
-	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(jsonBytes))
-	if err != nil {
-		return err
+	resp, err := post(ctx, client, tokens, secret, url, body, contentType, eventID)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized && tokens != nil {
+		// the token may have been revoked or expired early: retry once
+		// with a new token
+		tokens.Invalidate()
+		resp, err = post(ctx, client, tokens, secret, url, body, contentType, eventID)
 	}
-	req.Header.Set("Content-Type", "application/json; charset=utf-8")
-	resp, err := client.Do(req)
 	if err != nil {
 		return err
 	}
@@ -32,3 +32,33 @@ func postWebhookEvent(
 	}
 	return nil
 }
+
+// post POSTs body to url with a bearer token from tokens (if not nil),
+// signed with secret (if not empty), and with eventID as the idempotency
+// key (if not empty). The response body is closed.
+func post(ctx context.Context, client *http.Client, tokens TokenSource, secret []byte, url string, body []byte, contentType string, eventID string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if eventID != "" {
+		req.Header.Set(IdempotencyKeyHeader, eventID)
+	}
+	if len(secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(secret, body))
+	}
+	if tokens != nil {
+		token, err := tokens.Token(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
@@ -0,0 +1,31 @@
+package microwebhook
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// WithProxy sends webhook requests through the HTTP(S) proxy at proxy
+// rather than the proxy of the HTTPS_PROXY (and NO_PROXY) environment
+// variables. It applies to the client of WithClient, too.
+func WithProxy(proxy *url.URL) Option {
+	return func(w *MicroWebhook) {
+		w.proxy = proxy
+	}
+}
+
+// ProxyTransport returns a copy of transport (http.DefaultTransport if
+// nil) that connects through the HTTP(S) proxy at proxy. Transports
+// other than *http.Transport are returned unchanged.
+func ProxyTransport(transport http.RoundTripper, proxy *url.URL) http.RoundTripper {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	t, ok := transport.(*http.Transport)
+	if !ok {
+		return transport
+	}
+	t = t.Clone()
+	t.Proxy = http.ProxyURL(proxy)
+	return t
+}
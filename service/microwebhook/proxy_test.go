@@ -0,0 +1,29 @@
+package microwebhook
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// proxied requests have the absolute URL of the receiver
+		proxied = r.URL.String()
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := New("http://receiver.invalid/hook", WithProxy(proxyURL), WithClient(&http.Client{}))
+	if err = w.PostEvent(context.Background(), &Event{Topic: "mdm.Test"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "http://receiver.invalid/hook"; proxied != want {
+		t.Errorf("proxied %q, want %q", proxied, want)
+	}
+}
@@ -0,0 +1,59 @@
+package microwebhook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Publisher publishes webhook events to a receiver.
+type Publisher interface {
+	Publish(ctx context.Context, ev *Event) error
+}
+
+// httpPublisher POSTs events as JSON to a webhook URL.
+type httpPublisher struct {
+	client *http.Client
+	tokens TokenSource
+	secret []byte
+	url    string
+	encode encoder
+}
+
+func (p *httpPublisher) Publish(ctx context.Context, ev *Event) error {
+	body, contentType, err := p.encode(ev)
+	if err != nil {
+		return err
+	}
+	return postWebhookEvent(ctx, p.client, p.tokens, p.secret, p.url, body, contentType, ev.EventID)
+}
+
+// errPublisher fails to publish every event with err.
+type errPublisher struct{ err error }
+
+func (p errPublisher) Publish(_ context.Context, _ *Event) error {
+	return p.err
+}
+
+// newPublisher creates a publisher for the scheme of rawURL:
+//
+//	http(s)://host/path             an HTTP webhook (signed with secret)
+//	eventhubs://[key:secret@]ns/hub Azure Event Hubs (see newEventHubs)
+//	pubsub://project/topic          Google Cloud Pub/Sub (see newPubSub)
+func newPublisher(rawURL string, client *http.Client, tokens TokenSource, secret []byte, encode encoder) (Publisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return &httpPublisher{client: client, tokens: tokens, secret: secret, url: rawURL, encode: encode}, nil
+	case "eventhubs":
+		return newEventHubs(u, client, tokens, encode)
+	case "pubsub":
+		return newPubSub(u, client, tokens, encode)
+	default:
+		return nil, fmt.Errorf("unsupported webhook URL scheme: %q", u.Scheme)
+	}
+}
@@ -0,0 +1,222 @@
+package microwebhook
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// pubSubScope is the OAuth 2.0 scope of Pub/Sub tokens.
+const pubSubScope = "https://www.googleapis.com/auth/pubsub"
+
+// metadataTokenURL is the token endpoint of the GCE metadata server.
+const metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// pubSub publishes events to a Google Cloud Pub/Sub topic using its
+// REST API.
+type pubSub struct {
+	client *http.Client
+	tokens TokenSource // nil when using the emulator
+	url    string
+	encode encoder
+}
+
+// newPubSub creates a Pub/Sub publisher from a URL like
+// pubsub://project/topic. Requests are authenticated with the bearer
+// tokens of tokens if not nil, otherwise with the service account key
+// file in GOOGLE_APPLICATION_CREDENTIALS or, if unset, the metadata
+// server's default service account. If PUBSUB_EMULATOR_HOST is set the
+// emulator is used without authentication.
+func newPubSub(u *url.URL, client *http.Client, tokens TokenSource, encode encoder) (*pubSub, error) {
+	topic := strings.Trim(u.Path, "/")
+	if u.Host == "" || topic == "" {
+		return nil, errors.New("pub/sub URL requires a project host and topic path")
+	}
+	p := &pubSub{client: client, tokens: tokens, encode: encode}
+	path := "/v1/projects/" + u.Host + "/topics/" + topic + ":publish"
+	if host := os.Getenv("PUBSUB_EMULATOR_HOST"); host != "" {
+		p.url = "http://" + host + path
+		p.tokens = nil
+		return p, nil
+	}
+	p.url = "https://pubsub.googleapis.com" + path
+	if p.tokens != nil {
+		return p, nil
+	}
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		var err error
+		if p.tokens, err = newServiceAccount(path, client, pubSubScope); err != nil {
+			return nil, err
+		}
+	} else {
+		p.tokens = newMetadataToken(client)
+	}
+	return p, nil
+}
+
+func (p *pubSub) Publish(ctx context.Context, ev *Event) error {
+	data, contentType, err := p.encode(ev)
+	if err != nil {
+		return err
+	}
+	type message struct {
+		Data       []byte            `json:"data"`
+		Attributes map[string]string `json:"attributes,omitempty"`
+	}
+	body, err := json.Marshal(&struct {
+		Messages []message `json:"messages"`
+	}{[]message{{Data: data, Attributes: map[string]string{"topic": ev.Topic, "content-type": contentType, "event_id": ev.EventID}}}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if p.tokens != nil {
+		token, err := p.tokens.Token(ctx)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized && p.tokens != nil {
+		p.tokens.Invalidate()
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("unexpected HTTP status %d %s", resp.StatusCode, resp.Status)
+	}
+	return nil
+}
+
+// serviceAccount fetches bearer tokens for a Google service account
+// with the JWT bearer grant (RFC 7523).
+type serviceAccount struct {
+	tokenCache
+	client   *http.Client
+	email    string
+	tokenURI string
+	scope    string
+	key      *rsa.PrivateKey
+}
+
+// newServiceAccount creates a token source from the service account
+// key file at path.
+func newServiceAccount(path string, client *http.Client, scope string) (*serviceAccount, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	creds := new(struct {
+		Type        string `json:"type"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	})
+	if err = json.Unmarshal(b, creds); err != nil {
+		return nil, fmt.Errorf("parsing service account key: %w", err)
+	}
+	if creds.Type != "service_account" {
+		return nil, fmt.Errorf("unsupported credentials type: %q", creds.Type)
+	}
+	block, _ := pem.Decode([]byte(creds.PrivateKey))
+	if block == nil {
+		return nil, errors.New("service account private key not found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return nil, err
+		}
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("service account private key is not RSA")
+	}
+	sa := &serviceAccount{
+		client:   client,
+		email:    creds.ClientEmail,
+		tokenURI: creds.TokenURI,
+		scope:    scope,
+		key:      rsaKey,
+	}
+	if sa.tokenURI == "" {
+		sa.tokenURI = "https://oauth2.googleapis.com/token"
+	}
+	sa.fetch = sa.fetchToken
+	return sa, nil
+}
+
+// assertion returns a signed JWT asserting the service account.
+func (sa *serviceAccount) assertion(now time.Time) (string, error) {
+	enc := base64.RawURLEncoding
+	header := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   sa.email,
+		"scope": sa.scope,
+		"aud":   sa.tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	signed := header + "." + enc.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, sa.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + enc.EncodeToString(sig), nil
+}
+
+func (sa *serviceAccount) fetchToken(ctx context.Context) (string, int64, error) {
+	assertion, err := sa.assertion(time.Now())
+	if err != nil {
+		return "", 0, err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sa.tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return requestToken(sa.client, req)
+}
+
+// newMetadataToken creates a token source for the default service
+// account of the GCE metadata server.
+func newMetadataToken(client *http.Client) *tokenCache {
+	return &tokenCache{fetch: func(ctx context.Context) (string, int64, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataTokenURL, nil)
+		if err != nil {
+			return "", 0, err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+		return requestToken(client, req)
+	}}
+}
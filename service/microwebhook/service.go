@@ -2,22 +2,190 @@
 package microwebhook
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"net/url"
 	"time"
 
+	"github.com/jessepeterson/nanomdm/blob"
+	"github.com/jessepeterson/nanomdm/breaker"
 	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
 )
 
+// MicroWebhook sends check-in and command result events to a webhook.
+// The URL scheme selects the publisher: an HTTP webhook (http or https),
+// Azure Event Hubs (eventhubs), or Google Cloud Pub/Sub (pubsub).
 type MicroWebhook struct {
-	url    string
 	client *http.Client
+	tokens TokenSource
+	secret []byte
+	encode encoder
+	pub    Publisher
+	err    error
+
+	filter       *Filter
+	requestTypes storage.CommandDeliveryStore
+	offloader    *blob.Offloader
+	timeout      time.Duration
+	breaker      *breaker.Breaker
+
+	payloadLimit  int
+	payloadPolicy PayloadPolicy
+	proxy         *url.URL
+}
+
+type Option func(*MicroWebhook)
+
+// WithCloudEvents sends events in the CloudEvents format from source
+// (a URI-reference, e.g. "/nanomdm") instead of the MicroMDM-compatible
+// format. See NewCloudEvent.
+func WithCloudEvents(source string) Option {
+	return func(w *MicroWebhook) {
+		w.encode = cloudEventsEncoder(source)
+	}
+}
+
+// WithFilter only sends the events that match filter. A nil filter
+// sends all events.
+func WithFilter(filter *Filter) Option {
+	return func(w *MicroWebhook) {
+		w.filter = filter
+	}
+}
+
+// WithRequestTypes looks up the RequestType of the commands of command
+// reports in the command delivery audit trails of store for filters
+// that use the request_type field.
+func WithRequestTypes(store storage.CommandDeliveryStore) Option {
+	return func(w *MicroWebhook) {
+		w.requestTypes = store
+	}
+}
+
+// WithOffload sends the raw payloads of command results larger than the
+// offloader threshold by URL (see AcknowledgeEvent) after storing them
+// in its blob store.
+func WithOffload(offloader *blob.Offloader) Option {
+	return func(w *MicroWebhook) {
+		w.offloader = offloader
+	}
+}
+
+// WithTimeout bounds sending each event (including retrieving a bearer
+// token and any retry) to timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(w *MicroWebhook) {
+		w.timeout = timeout
+	}
+}
+
+// WithBreaker skips sending events while b is open (e.g. while the
+// webhook receiver is down) rather than waiting for each to time out.
+// Skipped events are lost. Failed sends count as failures.
+func WithBreaker(b *breaker.Breaker) Option {
+	return func(w *MicroWebhook) {
+		w.breaker = b
+	}
+}
+
+// WithTokenSource authenticates webhook requests with bearer tokens
+// from tokens.
+func WithTokenSource(tokens TokenSource) Option {
+	return func(w *MicroWebhook) {
+		w.tokens = tokens
+	}
 }
 
-func New(url string) *MicroWebhook {
-	return &MicroWebhook{
-		url:    url,
+// WithSigningSecret signs the body of HTTP webhook requests with
+// secret using HMAC-SHA256 in the SignatureHeader. See VerifySignature.
+func WithSigningSecret(secret string) Option {
+	return func(w *MicroWebhook) {
+		w.secret = []byte(secret)
+	}
+}
+
+func New(url string, opts ...Option) *MicroWebhook {
+	w := &MicroWebhook{
 		client: http.DefaultClient,
+		encode: encodeMicroMDM,
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if w.proxy != nil {
+		client := *w.client
+		client.Transport = ProxyTransport(client.Transport, w.proxy)
+		w.client = &client
+	}
+	if w.pub, w.err = newPublisher(url, w.client, w.tokens, w.secret, w.encode); w.err != nil {
+		w.pub = errPublisher{err: w.err}
+	}
+	return w
+}
+
+// Err returns the error (e.g. an unsupported URL scheme or missing
+// credentials) that prevents events from being sent, if any.
+func (w *MicroWebhook) Err() error {
+	return w.err
+}
+
+// PostEvent sends an arbitrary event to the webhook URL.
+func (w *MicroWebhook) PostEvent(ctx context.Context, ev *Event) error {
+	return w.publish(ctx, nil, ev)
+}
+
+// publish sends ev (of request r, if not nil) if it matches the filter.
+// Events without an EventID are assigned their StableEventID (of the
+// full raw payload).
+func (w *MicroWebhook) publish(ctx context.Context, r *mdm.Request, ev *Event) error {
+	if w.filter != nil && !w.filter.Match(w.filterVars(ctx, r, ev)) {
+		return nil
+	}
+	if ev.EventID == "" {
+		var id string
+		if r != nil && r.EnrollID != nil {
+			id = r.ID
+		}
+		ev.EventID = StableEventID(id, ev)
+	}
+	if w.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.timeout)
+		defer cancel()
+	}
+	if err := w.limitPayload(ctx, ev); err != nil {
+		return err
+	}
+	return w.breaker.Do(func() error { return w.pub.Publish(ctx, ev) })
+}
+
+// filterVars returns the filter fields of ev (of request r, if not nil).
+func (w *MicroWebhook) filterVars(ctx context.Context, r *mdm.Request, ev *Event) map[string]string {
+	vars := map[string]string{"topic": ev.Topic}
+	if r != nil && r.EnrollID != nil {
+		vars["type"] = r.Type.String()
+	}
+	ack := ev.AcknowledgeEvent
+	if ack == nil {
+		return vars
+	}
+	vars["status"] = ack.Status
+	if ack.CommandUUID == "" || r == nil || r.EnrollID == nil || w.requestTypes == nil || !w.filter.Uses("request_type") {
+		return vars
 	}
+	deliveries, err := w.requestTypes.RetrieveCommandDeliveries(ctx, r.ID)
+	if err != nil {
+		return vars
+	}
+	for _, d := range deliveries {
+		if d.CommandUUID == ack.CommandUUID {
+			vars["request_type"] = d.RequestType
+			break
+		}
+	}
+	return vars
 }
 
 func (w *MicroWebhook) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
@@ -30,7 +198,7 @@ func (w *MicroWebhook) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
 			RawPayload:   m.Raw,
 		},
 	}
-	return postWebhookEvent(r.Context, w.client, w.url, ev)
+	return w.publish(r.Context, r, ev)
 }
 
 func (w *MicroWebhook) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
@@ -43,7 +211,7 @@ func (w *MicroWebhook) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
 			RawPayload:   m.Raw,
 		},
 	}
-	return postWebhookEvent(r.Context, w.client, w.url, ev)
+	return w.publish(r.Context, r, ev)
 }
 
 func (w *MicroWebhook) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
@@ -56,7 +224,7 @@ func (w *MicroWebhook) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
 			RawPayload:   m.Raw,
 		},
 	}
-	return postWebhookEvent(r.Context, w.client, w.url, ev)
+	return w.publish(r.Context, r, ev)
 }
 
 func (w *MicroWebhook) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
@@ -71,5 +239,18 @@ func (w *MicroWebhook) CommandAndReportResults(r *mdm.Request, results *mdm.Comm
 			RawPayload:   results.Raw,
 		},
 	}
-	return nil, postWebhookEvent(r.Context, w.client, w.url, ev)
+	if results.Status != "Idle" {
+		ctx := r.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		url, err := w.offloader.Offload(ctx, results.Raw)
+		if err != nil {
+			return nil, fmt.Errorf("offloading raw payload: %w", err)
+		} else if url != "" {
+			ev.AcknowledgeEvent.RawPayload = nil
+			ev.AcknowledgeEvent.RawPayloadURL = url
+		}
+	}
+	return nil, w.publish(r.Context, r, ev)
 }
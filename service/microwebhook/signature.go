@@ -0,0 +1,29 @@
+package microwebhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// SignatureHeader is the HTTP header of the HMAC signature of signed
+// webhook requests.
+const SignatureHeader = "X-Nanomdm-Signature"
+
+// Sign returns the signature of body with secret in the form
+// "sha256=<hex HMAC-SHA256>".
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature reports whether signature (the SignatureHeader of a
+// request) is the signature of body with secret.
+func VerifySignature(secret, body []byte, signature string) bool {
+	if !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
@@ -0,0 +1,50 @@
+package microwebhook
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// WithClient uses client for webhook requests (e.g. one from
+// NewTLSClient).
+func WithClient(client *http.Client) Option {
+	return func(w *MicroWebhook) {
+		w.client = client
+	}
+}
+
+// NewTLSClient returns an HTTP client for webhook receivers that
+// require mutual TLS. It presents the PEM client certificate and key of
+// certFile and keyFile (if not empty) and, if caFile is not empty,
+// verifies receivers with the PEM CA certificates of caFile instead of
+// the system roots.
+func NewTLSClient(certFile, keyFile, caFile string) (*http.Client, error) {
+	config := new(tls.Config)
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("client certificate and key must be set together")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no CA certificates in %s", caFile)
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &http.Client{Transport: transport}, nil
+}
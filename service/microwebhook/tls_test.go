@@ -0,0 +1,95 @@
+package microwebhook
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeClientCert writes a self-signed client certificate and its key
+// to dir and returns the certificate.
+func writeClientCert(t *testing.T, dir string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "nanomdm"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, block := range map[string]*pem.Block{
+		"client.pem": {Type: "CERTIFICATE", Bytes: der},
+		"client.key": {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	clientCert := writeClientCert(t, dir)
+	var posted bool
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted = true
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: x509.NewCertPool()}
+	srv.TLS.ClientCAs.AddCert(clientCert)
+	srv.StartTLS()
+	defer srv.Close()
+	ca := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// without a client certificate
+	client, err := NewTLSClient("", "", ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = New(srv.URL, WithClient(client)).PostEvent(ctx, &Event{Topic: "mdm.Test"}); err == nil || posted {
+		t.Error("expected error without client certificate")
+	}
+
+	client, err = NewTLSClient(filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key"), ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = New(srv.URL, WithClient(client)).PostEvent(ctx, &Event{Topic: "mdm.Test"}); err != nil || !posted {
+		t.Errorf("posting with client certificate: %v", err)
+	}
+
+	if _, err = NewTLSClient(filepath.Join(dir, "client.pem"), "", ""); err == nil {
+		t.Error("expected error for certificate without key")
+	}
+}
@@ -3,6 +3,7 @@ package multi
 
 import (
 	"context"
+	"sync/atomic"
 
 	"github.com/jessepeterson/nanomdm/log"
 	"github.com/jessepeterson/nanomdm/mdm"
@@ -15,6 +16,8 @@ import (
 // Enrollment ID) by waiting for it to finish then we run the remaining
 // services' calls in parallel.
 type MultiService struct {
+	pending int64 // must be first for 64-bit alignment
+
 	logger log.Logger
 	svcs   []service.CheckinAndCommandService
 }
@@ -26,6 +29,26 @@ func New(logger log.Logger, svcs ...service.CheckinAndCommandService) *MultiServ
 	return &MultiService{logger: logger, svcs: svcs}
 }
 
+// Pending returns the number of calls of the remaining services (such
+// as webhooks) that are still running.
+func (ms *MultiService) Pending() int64 {
+	return atomic.LoadInt64(&ms.pending)
+}
+
+// runOthers runs call for each of the remaining services in parallel.
+func (ms *MultiService) runOthers(call func(service.CheckinAndCommandService) error) {
+	for i, svc := range ms.svcs[1:] {
+		atomic.AddInt64(&ms.pending, 1)
+		go func(n int, svc service.CheckinAndCommandService) {
+			defer atomic.AddInt64(&ms.pending, -1)
+			err := call(svc)
+			if err != nil {
+				ms.logger.Info("msg", "multi service", "service", n, "err", err)
+			}
+		}(i+1, svc)
+	}
+}
+
 // RequestWithContext returns a clone of r and sets its context to ctx.
 func RequestWithContext(r *mdm.Request, ctx context.Context) *mdm.Request {
 	r2 := r.Clone()
@@ -36,55 +59,36 @@ func RequestWithContext(r *mdm.Request, ctx context.Context) *mdm.Request {
 func (ms *MultiService) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
 	err := ms.svcs[0].Authenticate(r, m)
 	rc := RequestWithContext(r, context.Background())
-	for i, svc := range ms.svcs[1:] {
-		go func(n int, svc service.CheckinAndCommandService) {
-			err := svc.Authenticate(rc, m)
-			if err != nil {
-				ms.logger.Info("msg", "multi service", "service", n, "err", err)
-			}
-		}(i+1, svc)
-	}
+	ms.runOthers(func(svc service.CheckinAndCommandService) error {
+		return svc.Authenticate(rc, m)
+	})
 	return err
 }
 
 func (ms *MultiService) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
 	err := ms.svcs[0].TokenUpdate(r, m)
 	rc := RequestWithContext(r, context.Background())
-	for i, svc := range ms.svcs[1:] {
-		go func(n int, svc service.CheckinAndCommandService) {
-			err := svc.TokenUpdate(rc, m)
-			if err != nil {
-				ms.logger.Info("msg", "multi service", "service", n, "err", err)
-			}
-		}(i+1, svc)
-	}
+	ms.runOthers(func(svc service.CheckinAndCommandService) error {
+		return svc.TokenUpdate(rc, m)
+	})
 	return err
 }
 
 func (ms *MultiService) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
 	err := ms.svcs[0].CheckOut(r, m)
 	rc := RequestWithContext(r, context.Background())
-	for i, svc := range ms.svcs[1:] {
-		go func(n int, svc service.CheckinAndCommandService) {
-			err := svc.CheckOut(rc, m)
-			if err != nil {
-				ms.logger.Info("msg", "multi service", "service", n, "err", err)
-			}
-		}(i+1, svc)
-	}
+	ms.runOthers(func(svc service.CheckinAndCommandService) error {
+		return svc.CheckOut(rc, m)
+	})
 	return err
 }
 
 func (ms *MultiService) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
 	cmd, err := ms.svcs[0].CommandAndReportResults(r, results)
 	rc := RequestWithContext(r, context.Background())
-	for i, svc := range ms.svcs[1:] {
-		go func(n int, svc service.CheckinAndCommandService) {
-			_, err := svc.CommandAndReportResults(rc, results)
-			if err != nil {
-				ms.logger.Info("msg", "multi service", "service", n, "err", err)
-			}
-		}(i+1, svc)
-	}
+	ms.runOthers(func(svc service.CheckinAndCommandService) error {
+		_, err := svc.CommandAndReportResults(rc, results)
+		return err
+	})
 	return cmd, err
 }
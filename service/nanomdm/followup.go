@@ -0,0 +1,218 @@
+package nanomdm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/cmdplist"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultFollowUpDepth is the default maximum length of a chain of
+// follow-up commands enqueued in response to follow-up commands.
+const DefaultFollowUpDepth = 3
+
+// followUpExpiry is how long the chain depth of a follow-up command is
+// kept if the command is never reported (e.g. cleared from the queue).
+const followUpExpiry = 7 * 24 * time.Hour
+
+// FollowUpCommand is a command to enqueue as a follow-up. Command is a
+// command name from the cmdplist catalog and Args its arguments.
+type FollowUpCommand struct {
+	Command string            `yaml:"command"`
+	Args    map[string]string `yaml:"args"`
+}
+
+// FollowUp enqueues the Enqueue commands when a command of RequestType
+// reports Status (which defaults to "Acknowledged").
+type FollowUp struct {
+	RequestType string            `yaml:"request_type"`
+	Status      string            `yaml:"status"`
+	Enqueue     []FollowUpCommand `yaml:"enqueue"`
+}
+
+// validate checks and fills in defaults of follow-up f.
+func (f *FollowUp) validate() error {
+	if f.RequestType == "" {
+		return errors.New("follow-up missing request type")
+	}
+	switch f.Status {
+	case "":
+		f.Status = "Acknowledged"
+	case "Idle":
+		return fmt.Errorf("follow-up %s: invalid status: %s", f.RequestType, f.Status)
+	}
+	if len(f.Enqueue) < 1 {
+		return fmt.Errorf("follow-up %s: no commands", f.RequestType)
+	}
+	for _, c := range f.Enqueue {
+		spec := cmdplist.Lookup(c.Command)
+		if spec == nil {
+			return fmt.Errorf("follow-up %s: unknown command: %s", f.RequestType, c.Command)
+		}
+		if _, err := spec.Build(c.Args, nil); err != nil {
+			return fmt.Errorf("follow-up %s: %w", f.RequestType, err)
+		}
+	}
+	return nil
+}
+
+// LoadFollowUps reads and validates YAML follow-up rules from path.
+// The file contains a top-level "follow_ups" list.
+func LoadFollowUps(path string) ([]*FollowUp, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		FollowUps []*FollowUp `yaml:"follow_ups"`
+	}
+	if err := yaml.Unmarshal(b, &config); err != nil {
+		return nil, fmt.Errorf("parsing follow-ups: %w", err)
+	}
+	for _, f := range config.FollowUps {
+		if err := f.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return config.FollowUps, nil
+}
+
+// followUps enqueues follow-up commands for command reports.
+type followUps struct {
+	rules      []*FollowUp
+	enqueuer   storage.CommandEnqueuer
+	deliveries storage.CommandDeliveryStore
+	maxDepth   int
+
+	// chain depth of the follow-up commands awaiting a report
+	mu     sync.Mutex
+	depths map[followUpKey]followUpDepth
+	now    func() time.Time
+}
+
+// followUpKey is a follow-up command of an enrollment.
+type followUpKey struct {
+	id          string
+	commandUUID string
+}
+
+type followUpDepth struct {
+	depth      int
+	enqueuedAt time.Time
+}
+
+// match returns the rules matching the command report in results.
+// The request type of the reported command is looked up in the
+// delivery audit trail only if a rule matches the status.
+func (f *followUps) match(r *mdm.Request, results *mdm.CommandResults) ([]*FollowUp, error) {
+	var statusMatch bool
+	for _, rule := range f.rules {
+		if rule.Status == results.Status {
+			statusMatch = true
+			break
+		}
+	}
+	if !statusMatch {
+		return nil, nil
+	}
+	ctx := r.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	deliveries, err := f.deliveries.RetrieveCommandDeliveries(ctx, r.ID)
+	if err != nil {
+		return nil, err
+	}
+	var requestType string
+	for _, d := range deliveries {
+		if d.CommandUUID == results.CommandUUID {
+			requestType = d.RequestType
+			break
+		}
+	}
+	var rules []*FollowUp
+	for _, rule := range f.rules {
+		if rule.Status == results.Status && rule.RequestType == requestType {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+// depth returns the chain depth of the reported command, forgetting
+// it once the command is no longer pending.
+func (f *followUps) depth(r *mdm.Request, results *mdm.CommandResults) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := followUpKey{id: r.ID, commandUUID: results.CommandUUID}
+	depth := f.depths[key].depth
+	if results.Status != "NotNow" {
+		delete(f.depths, key)
+	}
+	return depth
+}
+
+// forget forgets the chain depths of the follow-up commands of the
+// enrollment id.
+func (f *followUps) forget(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for key := range f.depths {
+		if key.id == id {
+			delete(f.depths, key)
+		}
+	}
+}
+
+// expire forgets the chain depths of the follow-up commands enqueued
+// longer than followUpExpiry ago. f.mu must be held.
+func (f *followUps) expire(now time.Time) {
+	for key, d := range f.depths {
+		if now.Sub(d.enqueuedAt) > followUpExpiry {
+			delete(f.depths, key)
+		}
+	}
+}
+
+// enqueue enqueues the commands of rules for the enrollment as the
+// next link after depth in a chain of follow-ups.
+func (f *followUps) enqueue(r *mdm.Request, rules []*FollowUp, depth int) ([]string, error) {
+	ctx := r.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var uuids []string
+	for _, rule := range rules {
+		for _, c := range rule.Enqueue {
+			cmd, err := cmdplist.Lookup(c.Command).Build(c.Args, nil)
+			if err != nil {
+				return uuids, err
+			}
+			mdmCmd, err := cmd.MDMCommand()
+			if err != nil {
+				return uuids, err
+			}
+			key := followUpKey{id: r.ID, commandUUID: mdmCmd.CommandUUID}
+			f.mu.Lock()
+			now := f.now()
+			f.expire(now)
+			f.depths[key] = followUpDepth{depth: depth + 1, enqueuedAt: now}
+			f.mu.Unlock()
+			if _, err = f.enqueuer.EnqueueCommand(ctx, []string{r.ID}, mdmCmd); err != nil {
+				f.mu.Lock()
+				delete(f.depths, key)
+				f.mu.Unlock()
+				return uuids, err
+			}
+			uuids = append(uuids, mdmCmd.CommandUUID)
+		}
+	}
+	return uuids, nil
+}
@@ -0,0 +1,96 @@
+package nanomdm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+type followUpStore struct {
+	deliveries []*storage.CommandDelivery
+}
+
+func (s *followUpStore) EnqueueCommand(_ context.Context, id []string, cmd *mdm.Command) (map[string]error, error) {
+	s.deliveries = append(s.deliveries, &storage.CommandDelivery{
+		ID:          id[0],
+		CommandUUID: cmd.CommandUUID,
+		RequestType: cmd.Command.RequestType,
+	})
+	return nil, nil
+}
+
+func (s *followUpStore) RetrieveCommandDeliveries(_ context.Context, _ string) ([]*storage.CommandDelivery, error) {
+	return s.deliveries, nil
+}
+
+func TestFollowUpDepth(t *testing.T) {
+	rules, err := LoadFollowUps("../../docs/followup.example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &followUpStore{deliveries: []*storage.CommandDelivery{
+		{CommandUUID: "A", RequestType: "CertificateList"},
+	}}
+	s := &Service{logger: log.NopLogger}
+	WithFollowUps(rules, store, store, 2)(s)
+	r := &mdm.Request{EnrollID: &mdm.EnrollID{ID: "UDID"}}
+	// an erroring CertificateList follows up with another: that
+	// chain ends at the maximum depth
+	uuid := "A"
+	for i := 0; i < 3; i++ {
+		results := &mdm.CommandResults{CommandUUID: uuid, Status: "Error"}
+		rules, err := s.followUps.match(r, results)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rules) != 1 {
+			t.Fatalf("have %d rules, want 1", len(rules))
+		}
+		n := len(store.deliveries)
+		s.enqueueFollowUps(r, results, rules)
+		if i < 2 && len(store.deliveries) != n+1 {
+			t.Fatalf("depth %d: no follow-up enqueued", i)
+		} else if i == 2 && len(store.deliveries) != n {
+			t.Fatal("follow-up enqueued beyond maximum depth")
+		}
+		uuid = store.deliveries[len(store.deliveries)-1].CommandUUID
+	}
+}
+
+func TestFollowUpExpiry(t *testing.T) {
+	rules, err := LoadFollowUps("../../docs/followup.example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &followUpStore{deliveries: []*storage.CommandDelivery{
+		{CommandUUID: "A", RequestType: "CertificateList"},
+	}}
+	s := &Service{logger: log.NopLogger}
+	WithFollowUps(rules, store, store, 2)(s)
+	now := time.Now()
+	s.followUps.now = func() time.Time { return now }
+	r := &mdm.Request{EnrollID: &mdm.EnrollID{ID: "UDID"}}
+	results := &mdm.CommandResults{CommandUUID: "A", Status: "Error"}
+	rules, err = s.followUps.match(r, results)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// follow-ups that are never reported expire
+	s.enqueueFollowUps(r, results, rules)
+	now = now.Add(followUpExpiry + time.Second)
+	s.enqueueFollowUps(r, results, rules)
+	if n := len(s.followUps.depths); n != 1 {
+		t.Errorf("have %d follow-up depths, want 1", n)
+	}
+
+	// and are forgotten with the enrollment
+	s.forget(r.ID)
+	if n := len(s.followUps.depths); n != 0 {
+		t.Errorf("have %d follow-up depths after forgetting, want 0", n)
+	}
+}
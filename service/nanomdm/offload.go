@@ -0,0 +1,75 @@
+package nanomdm
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/groob/plist"
+	"github.com/jessepeterson/nanomdm/blob"
+	"github.com/jessepeterson/nanomdm/mdm"
+)
+
+// Keys of the stored command report of offloaded command results.
+const (
+	ResultURLKey  = "NanoMDMResultURL"
+	ResultSizeKey = "NanoMDMResultSize"
+)
+
+// offloadedReport is the stored command report of offloaded command
+// results. It keeps the fields that identify the report.
+type offloadedReport struct {
+	UDID             string           `plist:",omitempty"`
+	UserID           string           `plist:",omitempty"`
+	EnrollmentID     string           `plist:",omitempty"`
+	EnrollmentUserID string           `plist:",omitempty"`
+	CommandUUID      string           `plist:",omitempty"`
+	Status           string           `plist:",omitempty"`
+	ErrorChain       []mdm.ErrorChain `plist:",omitempty"`
+	ResultURL        string           `plist:"NanoMDMResultURL"`
+	ResultSize       int              `plist:"NanoMDMResultSize"`
+}
+
+// WithResultOffload stores the raw command results larger than the
+// offloader threshold in its blob store. The stored command report
+// (and the results published to the bus) then only contains the
+// identifying fields of the report, the URL of the results (the
+// ResultURLKey), and their size (the ResultSizeKey).
+func WithResultOffload(offloader *blob.Offloader) Option {
+	return func(s *Service) {
+		s.offloader = offloader
+	}
+}
+
+// offload returns the command results to store for results: results
+// or, if offloaded, a copy with the raw results replaced.
+func (s *Service) offload(r *mdm.Request, results *mdm.CommandResults) (*mdm.CommandResults, error) {
+	if s.offloader == nil || results.Status == "Idle" {
+		return results, nil
+	}
+	ctx := r.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	url, err := s.offloader.Offload(ctx, results.Raw)
+	if err != nil || url == "" {
+		return results, err
+	}
+	raw, err := plist.MarshalIndent(&offloadedReport{
+		UDID:             results.UDID,
+		UserID:           results.UserID,
+		EnrollmentID:     results.EnrollmentID,
+		EnrollmentUserID: results.EnrollmentUserID,
+		CommandUUID:      results.CommandUUID,
+		Status:           results.Status,
+		ErrorChain:       results.ErrorChain,
+		ResultURL:        url,
+		ResultSize:       len(results.Raw),
+	}, "\t")
+	if err != nil {
+		return nil, fmt.Errorf("marshal offloaded report: %w", err)
+	}
+	s.logger.Debug("msg", "offloaded command results", "id", r.ID, "command_uuid", results.CommandUUID, "size", len(results.Raw), "url", url)
+	stored := *results
+	stored.Raw = raw
+	return &stored, nil
+}
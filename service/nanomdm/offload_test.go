@@ -0,0 +1,46 @@
+package nanomdm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/blob"
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+)
+
+func TestOffload(t *testing.T) {
+	dir, err := blob.NewDir(t.TempDir(), "/v1/blobs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Service{logger: log.NopLogger}
+	WithResultOffload(blob.NewOffloader(dir, 100))(s)
+	r := &mdm.Request{EnrollID: &mdm.EnrollID{ID: "UDID"}}
+
+	small := &mdm.CommandResults{CommandUUID: "A", Status: "Acknowledged", Raw: []byte("small")}
+	if stored, err := s.offload(r, small); err != nil || stored != small {
+		t.Fatalf("small results offloaded: %v (err %v)", stored, err)
+	}
+
+	raw := bytes.Repeat([]byte("x"), 101)
+	large := &mdm.CommandResults{CommandUUID: "B", Status: "Acknowledged", Raw: raw}
+	stored, err := s.offload(r, large)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(large.Raw, raw) {
+		t.Error("results modified")
+	}
+	decoded, err := mdm.DecodeCommandResults(stored.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.CommandUUID != "B" || decoded.Status != "Acknowledged" {
+		t.Errorf("unexpected stored report: %+v", decoded)
+	}
+	if !strings.Contains(string(stored.Raw), "/v1/blobs/"+blob.Key(raw)) {
+		t.Errorf("stored report does not reference the results: %s", stored.Raw)
+	}
+}
@@ -0,0 +1,124 @@
+package nanomdm
+
+import (
+	"sync"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+)
+
+// DefaultQueueWindow is the default number of next queued commands a
+// queue policy chooses among.
+const DefaultQueueWindow = 10
+
+// QueuePolicy chooses which of the next queued commands of an
+// enrollment to deliver. The commands are in queue order and there is
+// at least one. Returning nil delivers no command.
+type QueuePolicy interface {
+	SelectCommand(r *mdm.Request, cmds []*mdm.Command) *mdm.Command
+}
+
+// QueuePolicyFunc is an adapter to allow ordinary functions to be used
+// as queue policies.
+type QueuePolicyFunc func(*mdm.Request, []*mdm.Command) *mdm.Command
+
+// SelectCommand calls f(r, cmds).
+func (f QueuePolicyFunc) SelectCommand(r *mdm.Request, cmds []*mdm.Command) *mdm.Command {
+	return f(r, cmds)
+}
+
+// forgetter is implemented by queue policies that keep per-enrollment
+// state. It is forgotten once the enrollment has no queued commands or
+// checks out.
+type forgetter interface {
+	forget(id string)
+}
+
+// FIFOPolicy delivers commands in queue order.
+var FIFOPolicy = QueuePolicyFunc(func(_ *mdm.Request, cmds []*mdm.Command) *mdm.Command {
+	return cmds[0]
+})
+
+// NewPriorityPolicy delivers the command with the highest request type
+// priority first. Request types missing from priorities have priority
+// zero. Commands of the same priority are delivered in queue order.
+func NewPriorityPolicy(priorities map[string]int) QueuePolicy {
+	return QueuePolicyFunc(func(_ *mdm.Request, cmds []*mdm.Command) *mdm.Command {
+		sel := cmds[0]
+		for _, cmd := range cmds[1:] {
+			if priorities[cmd.Command.RequestType] > priorities[sel.Command.RequestType] {
+				sel = cmd
+			}
+		}
+		return sel
+	})
+}
+
+// typeGroupedPolicy delivers commands of the same request type
+// together.
+type typeGroupedPolicy struct {
+	mu   sync.Mutex
+	last map[string]string // enrollment ID to last request type
+}
+
+// NewTypeGroupedPolicy delivers commands of the request type last
+// delivered to an enrollment before other commands (e.g. to deliver
+// many InstallApplication commands together). Otherwise commands are
+// delivered in queue order.
+func NewTypeGroupedPolicy() QueuePolicy {
+	return &typeGroupedPolicy{last: make(map[string]string)}
+}
+
+// SelectCommand selects the first command of the last request type
+// delivered to the enrollment or the first command.
+func (p *typeGroupedPolicy) SelectCommand(r *mdm.Request, cmds []*mdm.Command) *mdm.Command {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sel := cmds[0]
+	for _, cmd := range cmds {
+		if cmd.Command.RequestType == p.last[r.ID] {
+			sel = cmd
+			break
+		}
+	}
+	p.last[r.ID] = sel.Command.RequestType
+	return sel
+}
+
+func (p *typeGroupedPolicy) forget(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.last, id)
+}
+
+// sessions counts the commands delivered to enrollments in their
+// current Connect session: from an Idle report until no command is
+// delivered.
+type sessions struct {
+	mu        sync.Mutex
+	delivered map[string]int
+}
+
+// reached reports whether limit commands were delivered to the
+// enrollment id in its session. An Idle report starts a new session.
+func (ss *sessions) reached(id string, idle bool, limit int) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if idle {
+		delete(ss.delivered, id)
+	}
+	return ss.delivered[id] >= limit
+}
+
+// deliver counts a command delivered to the enrollment id.
+func (ss *sessions) deliver(id string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.delivered[id]++
+}
+
+// end ends the session of the enrollment id.
+func (ss *sessions) end(id string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	delete(ss.delivered, id)
+}
@@ -0,0 +1,76 @@
+package nanomdm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+)
+
+func TestQueuePolicies(t *testing.T) {
+	var cmds []*mdm.Command
+	for _, c := range []struct{ uuid, requestType string }{
+		{"A", "DeviceInformation"},
+		{"B", "InstallApplication"},
+		{"C", "DeviceLock"},
+		{"D", "InstallApplication"},
+	} {
+		cmd := &mdm.Command{CommandUUID: c.uuid}
+		cmd.Command.RequestType = c.requestType
+		cmds = append(cmds, cmd)
+	}
+	r := &mdm.Request{EnrollID: &mdm.EnrollID{ID: "UDID"}}
+	typed := NewTypeGroupedPolicy()
+	for _, test := range []struct {
+		policy QueuePolicy
+		cmds   []*mdm.Command
+		uuid   string
+	}{
+		{FIFOPolicy, cmds, "A"},
+		{NewPriorityPolicy(map[string]int{"DeviceLock": 10, "InstallApplication": 5}), cmds, "C"},
+		{NewPriorityPolicy(map[string]int{"InstallApplication": 5}), cmds, "B"},
+		{typed, cmds[1:], "B"},
+		// the last delivered request type is preferred
+		{typed, []*mdm.Command{cmds[0], cmds[2], cmds[3]}, "D"},
+	} {
+		if have := test.policy.SelectCommand(r, test.cmds); have.CommandUUID != test.uuid {
+			t.Errorf("have %s, want %s", have.CommandUUID, test.uuid)
+		}
+	}
+}
+
+func TestQueuePolicyForget(t *testing.T) {
+	var cmds []*mdm.Command
+	for _, c := range []struct{ uuid, requestType string }{
+		{"A", "DeviceInformation"},
+		{"B", "InstallApplication"},
+	} {
+		cmd := &mdm.Command{CommandUUID: c.uuid}
+		cmd.Command.RequestType = c.requestType
+		cmds = append(cmds, cmd)
+	}
+	r := &mdm.Request{EnrollID: &mdm.EnrollID{ID: "UDID"}}
+	typed := NewTypeGroupedPolicy()
+	policy := NewRateLimitPolicy(typed, 10, time.Hour, nil)
+	policy.SelectCommand(r, cmds[1:])
+	if have := policy.SelectCommand(r, cmds); have.CommandUUID != "B" {
+		t.Errorf("have %s, want B", have.CommandUUID)
+	}
+	policy.forget(r.ID)
+	if n := len(typed.(*typeGroupedPolicy).last); n != 0 {
+		t.Errorf("have %d remembered enrollments, want 0", n)
+	}
+	if have := policy.SelectCommand(r, cmds); have.CommandUUID != "A" {
+		t.Errorf("after forgetting: have %s, want A", have.CommandUUID)
+	}
+
+	ss := &sessions{delivered: make(map[string]int)}
+	ss.deliver(r.ID)
+	if !ss.reached(r.ID, false, 1) {
+		t.Error("expected limit reached")
+	}
+	ss.end(r.ID)
+	if len(ss.delivered) != 0 {
+		t.Errorf("have %d sessions, want 0", len(ss.delivered))
+	}
+}
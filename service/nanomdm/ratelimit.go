@@ -0,0 +1,153 @@
+package nanomdm
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/push"
+)
+
+// RateLimitPolicy limits the commands delivered to each enrollment
+// within a period (e.g. per hour) to protect devices from automation
+// that enqueues thousands of commands. Commands of override request
+// types (e.g. DeviceLock) are delivered despite the limit. Otherwise
+// the choice among the next queued commands is made by the wrapped
+// policy.
+//
+// Deliveries are counted in memory per instance. The commands of
+// limited enrollments stay queued; Run pushes the enrollments once the
+// limit allows delivering to them again.
+type RateLimitPolicy struct {
+	next      QueuePolicy
+	limit     int
+	period    time.Duration
+	overrides map[string]bool
+	now       func() time.Time
+
+	mu        sync.Mutex
+	delivered map[string][]time.Time // enrollment ID to delivery times within the period
+	held      map[string]time.Time   // enrollment ID to when it may be delivered to again
+}
+
+// NewRateLimitPolicy delivers at most limit commands per period to an
+// enrollment (except commands of the override request types) choosing
+// among the next commands with next.
+func NewRateLimitPolicy(next QueuePolicy, limit int, period time.Duration, overrides []string) *RateLimitPolicy {
+	p := &RateLimitPolicy{
+		next:      next,
+		limit:     limit,
+		period:    period,
+		overrides: make(map[string]bool),
+		now:       time.Now,
+		delivered: make(map[string][]time.Time),
+		held:      make(map[string]time.Time),
+	}
+	for _, requestType := range overrides {
+		p.overrides[requestType] = true
+	}
+	return p
+}
+
+// recent returns the delivery times of id within the period ending at
+// now. p.mu must be held.
+func (p *RateLimitPolicy) recent(id string, now time.Time) []time.Time {
+	times := p.delivered[id]
+	i := 0
+	for i < len(times) && !times[i].After(now.Add(-p.period)) {
+		i++
+	}
+	times = times[i:]
+	if len(times) < 1 {
+		delete(p.delivered, id)
+	} else {
+		p.delivered[id] = times
+	}
+	return times
+}
+
+// SelectCommand selects a command with the wrapped policy if fewer
+// than the limit of commands were delivered to the enrollment within
+// the period. Otherwise it selects the first command of an override
+// request type (or none).
+func (p *RateLimitPolicy) SelectCommand(r *mdm.Request, cmds []*mdm.Command) *mdm.Command {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	times := p.recent(r.ID, now)
+	var sel *mdm.Command
+	if len(times) < p.limit {
+		sel = p.next.SelectCommand(r, cmds)
+	} else {
+		for _, cmd := range cmds {
+			if p.overrides[cmd.Command.RequestType] {
+				sel = cmd
+				break
+			}
+		}
+		if sel == nil {
+			// the oldest delivery leaving the period frees a slot
+			p.held[r.ID] = times[len(times)-p.limit].Add(p.period)
+			return nil
+		}
+	}
+	if sel != nil {
+		p.delivered[r.ID] = append(times, now)
+		delete(p.held, r.ID)
+	}
+	return sel
+}
+
+// forget forgets the state of the wrapped policy for id. Deliveries are
+// kept as they count until they leave the period.
+func (p *RateLimitPolicy) forget(id string) {
+	if f, ok := p.next.(forgetter); ok {
+		f.forget(id)
+	}
+}
+
+// Released returns the sorted IDs of enrollments whose commands were
+// held back by the limit and may be delivered to again. They are only
+// returned once. Deliveries outside the period are forgotten.
+func (p *RateLimitPolicy) Released() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	for id := range p.delivered {
+		p.recent(id, now)
+	}
+	var ids []string
+	for id, until := range p.held {
+		if !now.Before(until) {
+			ids = append(ids, id)
+			delete(p.held, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Run pushes released enrollments (see Released) every interval until
+// ctx is done so that they connect for their held back commands.
+func (p *RateLimitPolicy) Run(ctx context.Context, pusher push.Pusher, interval time.Duration, logger log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		ids := p.Released()
+		if len(ids) < 1 {
+			continue
+		}
+		logger.Debug("msg", "pushing rate limited enrollments", "id_count", len(ids))
+		if _, err := pusher.Push(ctx, ids); err != nil {
+			logger.Info("msg", "push", "err", err)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package nanomdm
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+)
+
+func TestRateLimitPolicy(t *testing.T) {
+	var cmds []*mdm.Command
+	for _, c := range []struct{ uuid, requestType string }{
+		{"A", "InstallApplication"},
+		{"B", "DeviceLock"},
+	} {
+		cmd := &mdm.Command{CommandUUID: c.uuid}
+		cmd.Command.RequestType = c.requestType
+		cmds = append(cmds, cmd)
+	}
+	now := time.Unix(0, 0)
+	p := NewRateLimitPolicy(FIFOPolicy, 2, time.Hour, []string{"DeviceLock"})
+	p.now = func() time.Time { return now }
+	r := &mdm.Request{EnrollID: &mdm.EnrollID{ID: "UDID"}}
+	other := &mdm.Request{EnrollID: &mdm.EnrollID{ID: "OTHER"}}
+
+	selected := func(r *mdm.Request, cmds []*mdm.Command) string {
+		if cmd := p.SelectCommand(r, cmds); cmd != nil {
+			return cmd.CommandUUID
+		}
+		return ""
+	}
+	for _, test := range []struct {
+		r       *mdm.Request
+		cmds    []*mdm.Command
+		advance time.Duration
+		want    string
+	}{
+		{r, cmds, 0, "A"},
+		{r, cmds, 10 * time.Minute, "A"},
+		// limited: only overrides are delivered
+		{r, cmds, 0, "B"},
+		{r, cmds[:1], 0, ""},
+		// override deliveries count too
+		{r, cmds[:1], 50 * time.Minute, ""},
+		// other enrollments are limited separately
+		{other, cmds, 0, "A"},
+		// the deliveries left the period
+		{r, cmds[:1], 10 * time.Minute, "A"},
+		{r, cmds[:1], 0, "A"},
+		{r, cmds[:1], 0, ""},
+	} {
+		now = now.Add(test.advance)
+		if have := selected(test.r, test.cmds); have != test.want {
+			t.Errorf("%s at %s: have %q, want %q", test.r.ID, now.Sub(time.Unix(0, 0)), have, test.want)
+		}
+	}
+
+	// held back enrollments are released when a delivery leaves the period
+	if have := p.Released(); len(have) != 0 {
+		t.Errorf("released early: %v", have)
+	}
+	now = now.Add(time.Hour)
+	if have, want := fmt.Sprint(p.Released()), "[UDID]"; have != want {
+		t.Errorf("released: have %s, want %s", have, want)
+	}
+	if have := p.Released(); len(have) != 0 {
+		t.Errorf("released again: %v", have)
+	}
+}
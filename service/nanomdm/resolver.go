@@ -0,0 +1,61 @@
+package nanomdm
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/service"
+)
+
+// DeviceIDMapper is an enrollment ID resolver that remaps the device
+// channel ID resolved by another resolver using a lookup function (for
+// example a query against an external inventory system). User channel
+// enrollment IDs are remapped to match their device channel.
+//
+// This can be used, for instance, to identify BYOD User Enrollments
+// and device enrollments with the same ID scheme.
+type DeviceIDMapper struct {
+	next   service.EnrollIDResolver
+	lookup func(ctx context.Context, id string) (string, error)
+}
+
+// NewDeviceIDMapper creates a new DeviceIDMapper using lookup to map
+// device channel IDs resolved by next. If lookup returns an empty ID
+// the device channel ID is unchanged.
+func NewDeviceIDMapper(next service.EnrollIDResolver, lookup func(ctx context.Context, id string) (string, error)) *DeviceIDMapper {
+	if next == nil {
+		next = DefaultEnrollIDResolver
+	}
+	return &DeviceIDMapper{next: next, lookup: lookup}
+}
+
+// ResolveEnrollID resolves e using the next resolver then remaps its device channel ID.
+func (m *DeviceIDMapper) ResolveEnrollID(ctx context.Context, e *mdm.Enrollment) (*mdm.EnrollID, error) {
+	eid, err := m.next.ResolveEnrollID(ctx, e)
+	if err != nil || eid == nil {
+		return eid, err
+	}
+	deviceID := eid.ID
+	if eid.ParentID != "" {
+		deviceID = eid.ParentID
+	}
+	mapped, err := m.lookup(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if mapped == "" || mapped == deviceID {
+		return eid, nil
+	}
+	if eid.ParentID == "" {
+		eid.ID = mapped
+		return eid, nil
+	}
+	if !strings.HasPrefix(eid.ID, eid.ParentID) {
+		return nil, errors.New("user channel id not derived from device channel id")
+	}
+	eid.ID = mapped + strings.TrimPrefix(eid.ID, eid.ParentID)
+	eid.ParentID = mapped
+	return eid, nil
+}
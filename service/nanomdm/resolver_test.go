@@ -0,0 +1,34 @@
+package nanomdm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+)
+
+func TestDeviceIDMapper(t *testing.T) {
+	m := NewDeviceIDMapper(nil, func(_ context.Context, id string) (string, error) {
+		if id == "ENROLLMENT-ID" {
+			return "ASSET-1", nil
+		}
+		return "", nil
+	})
+	for _, test := range []struct {
+		e        mdm.Enrollment
+		id       string
+		parentID string
+	}{
+		{mdm.Enrollment{EnrollmentID: "ENROLLMENT-ID"}, "ASSET-1", ""},
+		{mdm.Enrollment{EnrollmentID: "ENROLLMENT-ID", EnrollmentUserID: "USER"}, "ASSET-1:USER", "ASSET-1"},
+		{mdm.Enrollment{UDID: "UDID"}, "UDID", ""},
+	} {
+		eid, err := m.ResolveEnrollID(context.Background(), &test.e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if eid.ID != test.id || eid.ParentID != test.parentID {
+			t.Errorf("have %q (parent %q), want %q (parent %q)", eid.ID, eid.ParentID, test.id, test.parentID)
+		}
+	}
+}
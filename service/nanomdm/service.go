@@ -2,18 +2,55 @@
 package nanomdm
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"sync/atomic"
+	"time"
 
+	"github.com/jessepeterson/nanomdm/blob"
+	"github.com/jessepeterson/nanomdm/bus"
 	"github.com/jessepeterson/nanomdm/log"
 	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/service"
 	"github.com/jessepeterson/nanomdm/storage"
 )
 
 // Service is the main NanoMDM service which dispatches to storage.
 type Service struct {
-	logger     log.Logger
-	normalizer func(e *mdm.Enrollment) *mdm.EnrollID
-	store      storage.ServiceStore
+	// count of duplicate command reports. first for 64-bit alignment
+	// of the atomic operations.
+	duplicateReports uint64
+
+	logger   log.Logger
+	resolver service.EnrollIDResolver
+	store    storage.ServiceStore
+
+	// set if store can store reports and retrieve the next command
+	// in one transaction
+	reportAndNext storage.CommandReportAndNextStore
+
+	// soft-delete enrollments on CheckOut
+	softDelete storage.SoftDeleteStore
+
+	// choose the next command among several queued commands
+	nextCommands storage.NextCommandsStore
+	queuePolicy  QueuePolicy
+	queueWindow  int
+
+	// limit the commands delivered per Connect session
+	commandLimit int
+	sessions     *sessions
+
+	// enqueue follow-up commands for command reports
+	followUps *followUps
+
+	// publish command results
+	bus bus.Bus
+
+	// store large command results in blob storage
+	offloader *blob.Offloader
 }
 
 // normalize generates enrollment IDs that are used by other
@@ -43,20 +80,114 @@ func normalize(e *mdm.Enrollment) *mdm.EnrollID {
 	return eid
 }
 
+// DefaultEnrollIDResolver resolves enrollment IDs by the NanoMDM
+// convention: the UDID (or EnrollmentID for User Enrollments) with any
+// user channel ID appended after a colon.
+var DefaultEnrollIDResolver = service.EnrollIDResolverFunc(
+	func(_ context.Context, e *mdm.Enrollment) (*mdm.EnrollID, error) {
+		return normalize(e), nil
+	},
+)
+
+type Option func(*Service)
+
+// WithEnrollIDResolver uses resolver to resolve enrollment IDs rather
+// than DefaultEnrollIDResolver.
+func WithEnrollIDResolver(resolver service.EnrollIDResolver) Option {
+	return func(s *Service) {
+		s.resolver = resolver
+	}
+}
+
+// WithSoftDelete soft-deletes device channel enrollments in store on
+// CheckOut (before disabling them) so that their pending commands and
+// sub-enrollments can be restored if the device re-enrolls.
+func WithSoftDelete(store storage.SoftDeleteStore) Option {
+	return func(s *Service) {
+		s.softDelete = store
+	}
+}
+
+// WithQueuePolicy delivers the command chosen by policy among up to
+// window of the next queued commands in store rather than the first
+// queued command. Note the command report is then not stored in the
+// same storage transaction as retrieving the next command.
+func WithQueuePolicy(store storage.NextCommandsStore, policy QueuePolicy, window int) Option {
+	return func(s *Service) {
+		s.nextCommands = store
+		s.queuePolicy = policy
+		s.queueWindow = window
+	}
+}
+
+// WithCommandLimit delivers at most limit commands per Connect session
+// (that starts with an Idle report). Once reached no command is
+// delivered so the device ends the session; the remaining commands are
+// delivered in the next session.
+func WithCommandLimit(limit int) Option {
+	return func(s *Service) {
+		s.commandLimit = limit
+	}
+}
+
+// WithFollowUps enqueues the follow-up commands of rules to enqueuer
+// when a matching command report is stored. The request types of
+// reported commands are looked up in deliveries. Follow-ups are
+// delivered in the same session. To prevent loops a chain of
+// follow-ups to follow-up commands ends after maxDepth commands.
+func WithFollowUps(rules []*FollowUp, enqueuer storage.CommandEnqueuer, deliveries storage.CommandDeliveryStore, maxDepth int) Option {
+	return func(s *Service) {
+		if maxDepth < 1 {
+			maxDepth = DefaultFollowUpDepth
+		}
+		s.followUps = &followUps{
+			rules:      rules,
+			enqueuer:   enqueuer,
+			deliveries: deliveries,
+			maxDepth:   maxDepth,
+			depths:     make(map[followUpKey]followUpDepth),
+			now:        time.Now,
+		}
+	}
+}
+
+// WithBus publishes stored command results (except NotNow) to b on
+// their bus.CommandResultTopic.
+func WithBus(b bus.Bus) Option {
+	return func(s *Service) {
+		s.bus = b
+	}
+}
+
 // New returns a new NanoMDM main service.
-func New(store storage.ServiceStore, logger log.Logger) *Service {
-	return &Service{
-		store:      store,
-		logger:     logger,
-		normalizer: normalize,
+func New(store storage.ServiceStore, logger log.Logger, opts ...Option) *Service {
+	s := &Service{
+		store:    store,
+		logger:   logger,
+		resolver: DefaultEnrollIDResolver,
 	}
+	s.reportAndNext, _ = store.(storage.CommandReportAndNextStore)
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.queueWindow < 1 {
+		s.queueWindow = 1
+	}
+	if s.commandLimit > 0 {
+		s.sessions = &sessions{delivered: make(map[string]int)}
+	}
+	return s
 }
 
 func (s *Service) updateEnrollID(r *mdm.Request, e *mdm.Enrollment) error {
 	if r.EnrollID != nil && r.ID != "" {
 		s.logger.Debug("msg", "overwriting enrollment id")
 	}
-	r.EnrollID = s.normalizer(e)
+	var err error
+	r.EnrollID, err = s.resolver.ResolveEnrollID(r.Context, e)
+	if err != nil {
+		return fmt.Errorf("resolving enrollment id: %w", err)
+	}
 	return r.EnrollID.Validate()
 }
 
@@ -83,6 +214,7 @@ func (s *Service) Authenticate(r *mdm.Request, message *mdm.Authenticate) error
 	if err := s.store.ClearQueue(r); err != nil {
 		return err
 	}
+	s.forget(r.ID)
 	// then, disable the enrollment or any sub-enrollment (because an
 	// enrollment is only valid after a tokenupdate)
 	return s.store.Disable(r)
@@ -111,9 +243,29 @@ func (s *Service) CheckOut(r *mdm.Request, message *mdm.CheckOut) error {
 		"id", r.ID,
 		"type", r.Type,
 	)
+	if s.softDelete != nil && r.ParentID == "" {
+		if err := s.softDelete.SoftDelete(r); err != nil {
+			return fmt.Errorf("soft-deleting: %w", err)
+		}
+	}
+	s.forget(r.ID)
 	return s.store.Disable(r)
 }
 
+// forget drops the in-memory delivery state of the enrollment id, e.g.
+// when its queue is cleared or it checks out.
+func (s *Service) forget(id string) {
+	if s.sessions != nil {
+		s.sessions.end(id)
+	}
+	if f, ok := s.queuePolicy.(forgetter); ok {
+		f.forget(id)
+	}
+	if s.followUps != nil {
+		s.followUps.forget(id)
+	}
+}
+
 // CommandAndReportResults command report and next-command request implementation.
 func (s *Service) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
 	if err := s.updateEnrollID(r, &results.Enrollment); err != nil {
@@ -128,15 +280,14 @@ func (s *Service) CommandAndReportResults(r *mdm.Request, results *mdm.CommandRe
 		logs = append(logs, "command_uuid", results.CommandUUID)
 	}
 	s.logger.Info(logs...)
-	err := s.store.StoreCommandReport(r, results)
+	cmd, err := s.storeReportAndRetrieveNext(r, results)
 	if err != nil {
-		return nil, fmt.Errorf("storing command report: %w", err)
-	}
-	cmd, err := s.store.RetrieveNextCommand(r, results.Status == "NotNow")
-	if err != nil {
-		return nil, fmt.Errorf("retrieving next command: %w", err)
+		return nil, err
 	}
 	if cmd != nil {
+		if s.sessions != nil {
+			s.sessions.deliver(r.ID)
+		}
 		s.logger.Debug(
 			"msg", "command retrieved",
 			"id", r.ID,
@@ -144,9 +295,160 @@ func (s *Service) CommandAndReportResults(r *mdm.Request, results *mdm.CommandRe
 		)
 		return cmd, nil
 	}
+	if s.sessions != nil {
+		// no command ends the session
+		s.sessions.end(r.ID)
+	}
 	s.logger.Debug(
 		"msg", "no command retrieved",
 		"id", r.ID,
 	)
 	return nil, nil
 }
+
+// storeReportAndRetrieveNext stores the command report and retrieves
+// the next command, in one transaction if the store supports it.
+func (s *Service) storeReportAndRetrieveNext(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	skipNotNow := results.Status == "NotNow"
+	limited := s.sessions != nil && s.sessions.reached(r.ID, results.Status == "Idle", s.commandLimit)
+	followUp := s.followUps != nil && results.Status != "Idle"
+	var rules []*FollowUp
+	if followUp {
+		var err error
+		if rules, err = s.followUps.match(r, results); err != nil {
+			return nil, fmt.Errorf("matching follow-ups: %w", err)
+		}
+	}
+	stored, err := s.offload(r, results)
+	if err != nil {
+		return nil, fmt.Errorf("offloading command results: %w", err)
+	}
+	if s.reportAndNext != nil && s.queuePolicy == nil && !limited && len(rules) < 1 {
+		cmd, err := s.reportAndNext.StoreCommandReportAndRetrieveNext(r, stored, skipNotNow)
+		if errors.Is(err, storage.ErrDuplicateReport) {
+			s.duplicateReport(r, results)
+		} else if err != nil {
+			return nil, fmt.Errorf("storing command report and retrieving next command: %w", err)
+		} else {
+			if followUp {
+				s.followUps.depth(r, results)
+			}
+			s.publishResult(r, stored)
+		}
+		return cmd, nil
+	}
+	err = s.store.StoreCommandReport(r, stored)
+	if errors.Is(err, storage.ErrDuplicateReport) {
+		s.duplicateReport(r, results)
+	} else if err != nil {
+		return nil, fmt.Errorf("storing command report: %w", err)
+	} else {
+		if followUp {
+			s.enqueueFollowUps(r, results, rules)
+		}
+		s.publishResult(r, stored)
+	}
+	if limited {
+		s.logger.Debug(
+			"msg", "command limit reached",
+			"id", r.ID,
+			"limit", s.commandLimit,
+		)
+		return nil, nil
+	}
+	if s.queuePolicy != nil {
+		return s.selectNextCommand(r, skipNotNow)
+	}
+	cmd, err := s.store.RetrieveNextCommand(r, skipNotNow)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving next command: %w", err)
+	}
+	return cmd, nil
+}
+
+// publishResult publishes the stored command results to the bus.
+// Errors are logged as the report is already stored.
+func (s *Service) publishResult(r *mdm.Request, results *mdm.CommandResults) {
+	if s.bus == nil || results.Status == "Idle" || results.Status == "NotNow" {
+		return
+	}
+	payload, err := json.Marshal(&bus.CommandResult{
+		ID:          r.ID,
+		CommandUUID: results.CommandUUID,
+		Status:      results.Status,
+		ReceivedAt:  time.Now(),
+		Raw:         results.Raw,
+	})
+	if err == nil {
+		ctx := r.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		err = s.bus.Publish(ctx, bus.CommandResultTopic(results.CommandUUID), payload)
+	}
+	if err != nil {
+		s.logger.Info("msg", "publishing command result", "id", r.ID, "command_uuid", results.CommandUUID, "err", err)
+	}
+}
+
+// enqueueFollowUps enqueues the follow-up commands of rules for the
+// reported command unless its chain of follow-ups is at the maximum
+// depth. Errors are logged as the report is already stored.
+func (s *Service) enqueueFollowUps(r *mdm.Request, results *mdm.CommandResults, rules []*FollowUp) {
+	depth := s.followUps.depth(r, results)
+	if len(rules) < 1 {
+		return
+	}
+	logger := s.logger.With("id", r.ID, "command_uuid", results.CommandUUID)
+	if depth >= s.followUps.maxDepth {
+		logger.Info("msg", "follow-up depth reached", "depth", depth)
+		return
+	}
+	uuids, err := s.followUps.enqueue(r, rules, depth)
+	for _, uuid := range uuids {
+		logger.Info("msg", "enqueued follow-up", "follow_up_uuid", uuid)
+	}
+	if err != nil {
+		logger.Info("msg", "enqueuing follow-up", "err", err)
+	}
+}
+
+// selectNextCommand delivers the command chosen by the queue policy.
+func (s *Service) selectNextCommand(r *mdm.Request, skipNotNow bool) (*mdm.Command, error) {
+	cmds, err := s.nextCommands.RetrieveNextCommands(r, skipNotNow, s.queueWindow)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving next commands: %w", err)
+	}
+	if len(cmds) < 1 {
+		if f, ok := s.queuePolicy.(forgetter); ok {
+			f.forget(r.ID)
+		}
+		return nil, nil
+	}
+	cmd := s.queuePolicy.SelectCommand(r, cmds)
+	if cmd == nil {
+		return nil, nil
+	}
+	if err = s.nextCommands.StoreCommandDelivered(r, cmd.CommandUUID); err != nil {
+		return nil, fmt.Errorf("storing command delivery: %w", err)
+	}
+	return cmd, nil
+}
+
+// duplicateReport logs and counts a re-sent command report that
+// storage ignored.
+func (s *Service) duplicateReport(r *mdm.Request, results *mdm.CommandResults) {
+	atomic.AddUint64(&s.duplicateReports, 1)
+	s.logger.Info(
+		"msg", "duplicate command report",
+		"id", r.ID,
+		"command_uuid", results.CommandUUID,
+		"status", results.Status,
+	)
+}
+
+// DuplicateReports returns the number of duplicate command reports
+// received (e.g. re-sent by devices after network failures).
+func (s *Service) DuplicateReports() uint64 {
+	return atomic.LoadUint64(&s.duplicateReports)
+}
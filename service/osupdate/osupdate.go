@@ -0,0 +1,167 @@
+// Package osupdate is a NanoMDM service that tracks the per-enrollment
+// state of OS update rollouts from command results.
+package osupdate
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// OS update states of an enrollment.
+const (
+	StateScheduled   = "scheduled"
+	StateDownloading = "downloading"
+	StateDownloaded  = "downloaded"
+	StateInstalling  = "installing"
+	StateInstalled   = "installed"
+	// StateBlocked is an update the device can not currently proceed
+	// with (e.g. insufficient space or power). It may resume later.
+	StateBlocked = "blocked"
+	StateFailed  = "failed"
+)
+
+// OSUpdate is a service that advances the OS update state of
+// enrollments from ScheduleOSUpdate and OSUpdateStatus command results.
+// It is intended to run alongside the core NanoMDM service (i.e. with
+// the multi service) so that enrollment IDs are resolved.
+type OSUpdate struct {
+	logger log.Logger
+	store  storage.OSUpdateStore
+}
+
+// New creates a new OS update service.
+func New(store storage.OSUpdateStore, logger log.Logger) *OSUpdate {
+	return &OSUpdate{store: store, logger: logger}
+}
+
+// Done reports whether state is a terminal state.
+func Done(state string) bool {
+	return state == StateInstalled || state == StateFailed
+}
+
+// stateOf maps a device update status to a state. An empty state is
+// returned for statuses that do not change the state.
+func stateOf(status string, downloaded bool) string {
+	switch {
+	case status == "Downloading":
+		return StateDownloading
+	case status == "Installing":
+		return StateInstalling
+	case status == "Idle" && downloaded:
+		return StateDownloaded
+	case strings.HasSuffix(status, "Failed"):
+		return StateFailed
+	case strings.HasPrefix(status, "Insufficient"),
+		strings.HasPrefix(status, "Requires"),
+		status == "InstallPhoneCallInProgress":
+		return StateBlocked
+	}
+	return ""
+}
+
+// Advance updates st from the OS update command results. It reports
+// whether st was changed.
+func Advance(st *storage.OSUpdateState, results *mdm.OSUpdateResults) bool {
+	if Done(st.State) {
+		return false
+	}
+	if results.CommandUUID == st.CommandUUID && results.Status == "Error" {
+		st.State = StateFailed
+		st.Error = "command error"
+		if len(results.ErrorChain) > 0 {
+			st.Error = results.ErrorChain[0].USEnglishDescription
+		}
+		return true
+	}
+	if results.Status != "Acknowledged" {
+		return false
+	}
+	if results.CommandUUID == st.CommandUUID {
+		for _, r := range results.UpdateResults {
+			if st.ProductKey != "" && r.ProductKey != st.ProductKey {
+				continue
+			}
+			if st.ProductKey == "" {
+				// product version updates learn their product key
+				st.ProductKey = r.ProductKey
+			}
+			st.DeviceStatus = r.Status
+			if state := stateOf(r.Status, false); state != "" {
+				st.State = state
+			}
+			if len(r.ErrorChain) > 0 {
+				st.Error = r.ErrorChain[0].USEnglishDescription
+			}
+			return true
+		}
+		return false
+	}
+	if results.OSUpdateStatus == nil || st.ProductKey == "" {
+		return false
+	}
+	for _, s := range results.OSUpdateStatus {
+		if s.ProductKey != st.ProductKey {
+			continue
+		}
+		st.DeviceStatus = s.Status
+		st.PercentComplete = s.DownloadPercentComplete
+		if state := stateOf(s.Status, s.IsDownloaded); state != "" {
+			st.State = state
+		}
+		return true
+	}
+	if st.State == StateInstalling {
+		// an installing update no longer reported has been installed
+		st.State = StateInstalled
+		st.DeviceStatus = ""
+		return true
+	}
+	return false
+}
+
+func (s *OSUpdate) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	return nil
+}
+
+func (s *OSUpdate) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	return nil
+}
+
+func (s *OSUpdate) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	return nil
+}
+
+func (s *OSUpdate) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	if results.Status == "Idle" || r.EnrollID == nil || r.ParentID != "" {
+		return nil, nil
+	}
+	states, err := s.store.RetrieveOSUpdateStates(r.Context, []string{r.ID}, "")
+	if err != nil || len(states) < 1 {
+		return nil, err
+	}
+	st := states[0]
+	if Done(st.State) {
+		return nil, nil
+	}
+	updResults, err := mdm.DecodeOSUpdateResults(results.Raw)
+	if err != nil {
+		return nil, err
+	}
+	prev := st.State
+	if !Advance(st, updResults) {
+		return nil, nil
+	}
+	st.UpdatedAt = time.Now()
+	s.logger.Debug(
+		"msg", "OS update state",
+		"id", r.ID,
+		"command_uuid", results.CommandUUID,
+		"from", prev,
+		"to", st.State,
+	)
+	return nil, s.store.StoreOSUpdateState(r.Context, st)
+}
@@ -0,0 +1,73 @@
+package osupdate
+
+import (
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+func TestAdvance(t *testing.T) {
+	st := &storage.OSUpdateState{ProductVersion: "14.4.1", CommandUUID: "a", State: StateScheduled}
+	ack := func(uuid string) mdm.CommandResults {
+		return mdm.CommandResults{CommandUUID: uuid, Status: "Acknowledged"}
+	}
+
+	for _, step := range []struct {
+		results *mdm.OSUpdateResults
+		changed bool
+		state   string
+	}{
+		// unrelated results
+		{&mdm.OSUpdateResults{CommandResults: ack("b")}, false, StateScheduled},
+		{&mdm.OSUpdateResults{
+			CommandResults: ack("a"),
+			UpdateResults:  []mdm.OSUpdateResult{{ProductKey: "MSU_UPDATE_X", Status: "Idle"}},
+		}, true, StateScheduled},
+		{&mdm.OSUpdateResults{
+			CommandResults: ack("c"),
+			OSUpdateStatus: []mdm.OSUpdateStatusItem{{ProductKey: "MSU_UPDATE_X", Status: "Downloading", DownloadPercentComplete: 0.5}},
+		}, true, StateDownloading},
+		{&mdm.OSUpdateResults{
+			CommandResults: ack("d"),
+			OSUpdateStatus: []mdm.OSUpdateStatusItem{{ProductKey: "MSU_UPDATE_X", Status: "Idle", IsDownloaded: true}},
+		}, true, StateDownloaded},
+		{&mdm.OSUpdateResults{
+			CommandResults: ack("e"),
+			OSUpdateStatus: []mdm.OSUpdateStatusItem{{ProductKey: "MSU_UPDATE_X", Status: "Installing"}},
+		}, true, StateInstalling},
+		// no longer reported
+		{&mdm.OSUpdateResults{CommandResults: ack("f"), OSUpdateStatus: []mdm.OSUpdateStatusItem{}}, true, StateInstalled},
+		// terminal
+		{&mdm.OSUpdateResults{
+			CommandResults: ack("g"),
+			OSUpdateStatus: []mdm.OSUpdateStatusItem{{ProductKey: "MSU_UPDATE_X", Status: "Downloading"}},
+		}, false, StateInstalled},
+	} {
+		if have, want := Advance(st, step.results), step.changed; have != want {
+			t.Errorf("%s: changed: have %v, want %v", step.results.CommandUUID, have, want)
+		}
+		if have, want := st.State, step.state; have != want {
+			t.Errorf("%s: state: have %q, want %q", step.results.CommandUUID, have, want)
+		}
+	}
+	if st.ProductKey != "MSU_UPDATE_X" {
+		t.Errorf("product key not learned: %q", st.ProductKey)
+	}
+}
+
+func TestAdvanceFailed(t *testing.T) {
+	st := &storage.OSUpdateState{ProductKey: "MSU_UPDATE_X", CommandUUID: "a", State: StateScheduled}
+	results := &mdm.OSUpdateResults{
+		CommandResults: mdm.CommandResults{CommandUUID: "a", Status: "Acknowledged"},
+		UpdateResults:  []mdm.OSUpdateResult{{ProductKey: "MSU_UPDATE_X", Status: "InsufficientSpaceForDownload"}},
+	}
+	if !Advance(st, results) || st.State != StateBlocked {
+		t.Errorf("expected blocked, have %q", st.State)
+	}
+	results.Status = "Error"
+	results.ErrorChain = []mdm.ErrorChain{{USEnglishDescription: "no such update"}}
+	if !Advance(st, results) || st.State != StateFailed || st.Error != "no such update" {
+		t.Errorf("expected failed, have %q (%q)", st.State, st.Error)
+	}
+}
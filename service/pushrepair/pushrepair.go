@@ -0,0 +1,199 @@
+// Package pushrepair finds enrollments that the server can't (or
+// likely can't) push to because their push info (the push token and
+// PushMagic of their last TokenUpdate) is missing or stale, for
+// example after a partial migration, and repairs them.
+package pushrepair
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// Problems of enrollments.
+const (
+	ProblemNoPushInfo  = "no push info"
+	ProblemNoToken     = "no push token"
+	ProblemNoPushMagic = "no push magic"
+	ProblemStale       = "stale"
+)
+
+var (
+	ErrNoRefresher = errors.New("push info refresh not configured")
+	ErrUserChannel = errors.New("not a device channel enrollment")
+)
+
+// Enrollment is an enabled enrollment with a push info problem.
+type Enrollment struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	Topic      string     `json:"topic,omitempty"`
+	Problem    string     `json:"problem"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+}
+
+// Refresher makes enrollments send new push info. For example the
+// identity rotation service re-installs the MDM enrollment which sends
+// new Authenticate and TokenUpdate messages.
+type Refresher interface {
+	// Rotate returns command UUIDs and errors by enrollment ID.
+	Rotate(ctx context.Context, ids []string) (map[string]string, map[string]error, error)
+}
+
+// Store is the storage required by PushRepair.
+type Store interface {
+	storage.EnrollmentLister
+	storage.PushStore
+	storage.CheckinStore
+}
+
+// PushRepair detects and repairs enrollments with missing or stale
+// push info.
+type PushRepair struct {
+	store      Store
+	refresher  Refresher
+	staleAfter time.Duration
+	logger     log.Logger
+}
+
+type Option func(*PushRepair)
+
+func WithLogger(logger log.Logger) Option {
+	return func(p *PushRepair) {
+		p.logger = logger
+	}
+}
+
+// WithStaleAfter detects enrollments not seen for d as stale: their
+// push token may have changed without the server being told (e.g.
+// TokenUpdates sent to another server during a migration).
+func WithStaleAfter(d time.Duration) Option {
+	return func(p *PushRepair) {
+		p.staleAfter = d
+	}
+}
+
+// WithRefresher refreshes push info with refresher.
+func WithRefresher(refresher Refresher) Option {
+	return func(p *PushRepair) {
+		p.refresher = refresher
+	}
+}
+
+// New creates a new push info repair service.
+func New(store Store, opts ...Option) *PushRepair {
+	p := &PushRepair{store: store, logger: log.NopLogger}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Detect returns the enabled enrollments with push info problems
+// sorted by ID.
+func (p *PushRepair) Detect(ctx context.Context) ([]*Enrollment, error) {
+	enrollments, err := p.store.ListEnrollments(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing enrollments: %w", err)
+	}
+	var ids []string
+	for _, e := range enrollments {
+		if e.Enabled {
+			ids = append(ids, e.ID)
+		}
+	}
+	found := []*Enrollment{}
+	if len(ids) < 1 {
+		return found, nil
+	}
+	pushInfos, err := p.store.RetrievePushInfo(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving push info: %w", err)
+	}
+	staleBefore := time.Now().Add(-p.staleAfter)
+	for _, e := range enrollments {
+		if !e.Enabled {
+			continue
+		}
+		var problem string
+		pushInfo := pushInfos[e.ID]
+		switch {
+		case pushInfo == nil:
+			problem = ProblemNoPushInfo
+		case len(pushInfo.Token) < 1:
+			problem = ProblemNoToken
+		case pushInfo.PushMagic == "":
+			problem = ProblemNoPushMagic
+		case p.staleAfter > 0 && (e.LastSeenAt == nil || e.LastSeenAt.Before(staleBefore)):
+			problem = ProblemStale
+		default:
+			continue
+		}
+		found = append(found, &Enrollment{
+			ID:         e.ID,
+			Type:       e.Type,
+			Topic:      e.Topic,
+			Problem:    problem,
+			LastSeenAt: e.LastSeenAt,
+		})
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].ID < found[j].ID })
+	return found, nil
+}
+
+// Refresh starts push info refreshes of ids with the refresher. It
+// returns errors by enrollment ID. Enrollments without usable push
+// info can't be pushed to: they receive the refresh when they next
+// check-in on their own.
+func (p *PushRepair) Refresh(ctx context.Context, ids []string) (map[string]error, error) {
+	if p.refresher == nil {
+		return nil, ErrNoRefresher
+	}
+	_, idErrs, err := p.refresher.Rotate(ctx, ids)
+	p.logger.Info("msg", "refreshing push info", "id_count", len(ids), "errors", len(idErrs), "err", err)
+	return idErrs, err
+}
+
+// Reenroll marks the device channel enrollments ids (and their user
+// channel enrollments) as unenrolled as if they had checked out. The
+// devices must re-enroll (e.g. by re-installing the enrollment profile
+// or with Automated Device Enrollment) which stores new push info. It
+// returns errors by enrollment ID.
+func (p *PushRepair) Reenroll(ctx context.Context, ids []string) (map[string]error, error) {
+	enrollments, err := p.store.ListEnrollments(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing enrollments: %w", err)
+	}
+	byID := make(map[string]*storage.Enrollment)
+	for _, e := range enrollments {
+		byID[e.ID] = e
+	}
+	idErrs := make(map[string]error)
+	for _, id := range ids {
+		e := byID[id]
+		if e == nil {
+			idErrs[id] = storage.ErrNotFound
+			continue
+		}
+		if e.ParentID != "" {
+			idErrs[id] = ErrUserChannel
+			continue
+		}
+		r := &mdm.Request{
+			EnrollID: &mdm.EnrollID{ID: id, Type: mdm.Device},
+			Context:  ctx,
+		}
+		if err = p.store.Disable(r); err != nil {
+			idErrs[id] = err
+			continue
+		}
+		p.logger.Info("msg", "marked for re-enrollment", "id", id)
+	}
+	return idErrs, nil
+}
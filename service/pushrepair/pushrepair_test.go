@@ -0,0 +1,128 @@
+package pushrepair
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+type repairStore struct {
+	storage.CheckinStore
+	enrollments []*storage.Enrollment
+	pushInfos   map[string]*mdm.Push
+	disabled    []string
+}
+
+func (s *repairStore) ListEnrollments(context.Context) ([]*storage.Enrollment, error) {
+	return s.enrollments, nil
+}
+
+func (s *repairStore) RetrievePushInfo(_ context.Context, ids []string) (map[string]*mdm.Push, error) {
+	pushInfos := make(map[string]*mdm.Push)
+	for _, id := range ids {
+		if push, ok := s.pushInfos[id]; ok {
+			pushInfos[id] = push
+		}
+	}
+	return pushInfos, nil
+}
+
+func (s *repairStore) Disable(r *mdm.Request) error {
+	s.disabled = append(s.disabled, r.ID)
+	return nil
+}
+
+type refresher struct{ ids []string }
+
+func (r *refresher) Rotate(_ context.Context, ids []string) (map[string]string, map[string]error, error) {
+	r.ids = ids
+	return nil, nil, nil
+}
+
+func newStore() *repairStore {
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+	push := func(token, magic string) *mdm.Push {
+		return &mdm.Push{Token: []byte(token), PushMagic: magic, Topic: "com.apple.mgmt.test"}
+	}
+	return &repairStore{
+		enrollments: []*storage.Enrollment{
+			{ID: "A", Type: "Device", Enabled: true, LastSeenAt: &now},
+			{ID: "B", Type: "Device", Enabled: true, LastSeenAt: &now},
+			{ID: "C", Type: "Device", Enabled: true, LastSeenAt: &now},
+			{ID: "D", Type: "Device", Enabled: true, LastSeenAt: &now},
+			{ID: "E", Type: "Device", Enabled: true, LastSeenAt: &old},
+			{ID: "F", Type: "Device", Enabled: false},
+			{ID: "G", Type: "User", Enabled: true, ParentID: "A"},
+		},
+		pushInfos: map[string]*mdm.Push{
+			"A": push("token", "magic"),
+			"C": push("", "magic"),
+			"D": push("token", ""),
+			"E": push("token", "magic"),
+			"G": push("token", "magic"),
+		},
+	}
+}
+
+func TestDetect(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		staleAfter time.Duration
+		want       []string
+	}{
+		{"missing", 0, []string{"B " + ProblemNoPushInfo, "C " + ProblemNoToken, "D " + ProblemNoPushMagic}},
+		{"stale", 24 * time.Hour, []string{"B " + ProblemNoPushInfo, "C " + ProblemNoToken, "D " + ProblemNoPushMagic, "E " + ProblemStale, "G " + ProblemStale}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			p := New(newStore(), WithStaleAfter(test.staleAfter))
+			found, err := p.Detect(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var have []string
+			for _, e := range found {
+				have = append(have, e.ID+" "+e.Problem)
+			}
+			if !reflect.DeepEqual(have, test.want) {
+				t.Errorf("have %v, want %v", have, test.want)
+			}
+		})
+	}
+}
+
+func TestRepair(t *testing.T) {
+	store := newStore()
+	p := New(store)
+	if _, err := p.Refresh(context.Background(), []string{"B"}); !errors.Is(err, ErrNoRefresher) {
+		t.Errorf("have %v, want %v", err, ErrNoRefresher)
+	}
+
+	r := &refresher{}
+	p = New(store, WithRefresher(r))
+	if _, err := p.Refresh(context.Background(), []string{"B", "C"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"B", "C"}; !reflect.DeepEqual(r.ids, want) {
+		t.Errorf("refreshed %v, want %v", r.ids, want)
+	}
+
+	idErrs, err := p.Reenroll(context.Background(), []string{"B", "G", "Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"B"}; !reflect.DeepEqual(store.disabled, want) {
+		t.Errorf("disabled %v, want %v", store.disabled, want)
+	}
+	if !errors.Is(idErrs["G"], ErrUserChannel) {
+		t.Errorf("have %v, want %v", idErrs["G"], ErrUserChannel)
+	}
+	if !errors.Is(idErrs["Z"], storage.ErrNotFound) {
+		t.Errorf("have %v, want %v", idErrs["Z"], storage.ErrNotFound)
+	}
+}
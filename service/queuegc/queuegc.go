@@ -0,0 +1,143 @@
+// Package queuegc periodically purges the command queues of disabled
+// and idle enrollments.
+package queuegc
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// DefaultInterval is the default collection interval.
+const DefaultInterval = 24 * time.Hour
+
+// Collector purges the queued commands, command results, and command
+// delivery audit trails of disabled (e.g. checked-out) enrollments and
+// of enrollments idle beyond a retention window so that queue storage
+// does not grow unbounded. Purged commands are optionally archived.
+// Collection is started with Run.
+type Collector struct {
+	store     storage.QueuePurgeStore
+	logger    log.Logger
+	interval  time.Duration
+	retention time.Duration
+	archive   string
+
+	// serializes writes to the archive file
+	mu sync.Mutex
+}
+
+type Option func(*Collector)
+
+func WithLogger(logger log.Logger) Option {
+	return func(c *Collector) {
+		c.logger = logger
+	}
+}
+
+// WithInterval sets the interval at which queues are collected.
+func WithInterval(interval time.Duration) Option {
+	return func(c *Collector) {
+		c.interval = interval
+	}
+}
+
+// WithRetention also purges the queues of enrollments not seen within
+// retention. By default only the queues of disabled enrollments are
+// purged.
+func WithRetention(retention time.Duration) Option {
+	return func(c *Collector) {
+		c.retention = retention
+	}
+}
+
+// WithArchive appends purged commands to the file at path as JSON
+// lines before they are deleted.
+func WithArchive(path string) Option {
+	return func(c *Collector) {
+		c.archive = path
+	}
+}
+
+// New creates a new queue garbage collector.
+func New(store storage.QueuePurgeStore, opts ...Option) *Collector {
+	c := &Collector{
+		store:    store,
+		logger:   log.NopLogger,
+		interval: DefaultInterval,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Run collects queues every interval until ctx is done.
+func (c *Collector) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		if n, err := c.Collect(ctx); err != nil {
+			c.logger.Info("msg", "collecting queues", "purged", n, "err", err)
+		} else if n > 0 {
+			c.logger.Info("msg", "collected queues", "purged", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// archivedCommand is an archived command as a JSON line.
+type archivedCommand struct {
+	ID          string    `json:"id"`
+	CommandUUID string    `json:"command_uuid"`
+	RequestType string    `json:"request_type,omitempty"`
+	Status      string    `json:"status,omitempty"`
+	Command     string    `json:"command"`
+	Result      string    `json:"result,omitempty"`
+	PurgedAt    time.Time `json:"purged_at"`
+}
+
+// Collect purges the queues of disabled and idle enrollments once and
+// returns the number of purged commands.
+func (c *Collector) Collect(ctx context.Context) (int, error) {
+	var idleBefore time.Time
+	if c.retention > 0 {
+		idleBefore = time.Now().Add(-c.retention)
+	}
+	if c.archive == "" {
+		return c.store.PurgeQueues(ctx, idleBefore, nil)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, err := os.OpenFile(c.archive, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetEscapeHTML(false)
+	now := time.Now()
+	n, err := c.store.PurgeQueues(ctx, idleBefore, func(p *storage.PurgedCommand) error {
+		return enc.Encode(&archivedCommand{
+			ID:          p.ID,
+			CommandUUID: p.CommandUUID,
+			RequestType: p.RequestType,
+			Status:      p.Status,
+			Command:     string(p.Command),
+			Result:      string(p.Result),
+			PurgedAt:    now,
+		})
+	})
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
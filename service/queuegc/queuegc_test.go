@@ -0,0 +1,61 @@
+package queuegc
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+type purgeStore struct {
+	idleBefore time.Time
+}
+
+func (s *purgeStore) PurgeQueues(_ context.Context, idleBefore time.Time, archive func(*storage.PurgedCommand) error) (int, error) {
+	s.idleBefore = idleBefore
+	if archive != nil {
+		err := archive(&storage.PurgedCommand{ID: "id1", CommandUUID: "uuid1", Command: []byte("<?xml>")})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return 1, nil
+}
+
+func TestCollect(t *testing.T) {
+	store := new(purgeStore)
+	archive := filepath.Join(t.TempDir(), "archive.jsonl")
+
+	n, err := New(store, WithArchive(archive)).Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("purged: have %d, want %d", n, 1)
+	}
+	if !store.idleBefore.IsZero() {
+		t.Error("expected zero idle time without retention")
+	}
+	b, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c archivedCommand
+	if err := json.Unmarshal(b, &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.CommandUUID != "uuid1" || c.Command != "<?xml>" {
+		t.Errorf("unexpected archived command: %+v", c)
+	}
+
+	if _, err = New(store, WithRetention(time.Hour)).Collect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if age := time.Since(store.idleBefore); age < time.Hour || age > 2*time.Hour {
+		t.Errorf("unexpected idle time age: %s", age)
+	}
+}
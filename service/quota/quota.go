@@ -0,0 +1,177 @@
+// Package quota limits the number of device enrollments (in total and
+// per APNs topic) for environments with license-based device caps.
+package quota
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/service"
+	"github.com/jessepeterson/nanomdm/service/microwebhook"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// Quota is a check-in hook (see reject.Hook) that rejects the
+// Authenticate of new devices once the number of enabled device
+// channel enrollments reaches a limit. Devices that are already
+// enrolled may always re-enroll. Enrollments are counted once they
+// have sent a TokenUpdate so concurrent enrollments may exceed a limit
+// slightly.
+type Quota struct {
+	store       storage.EnrollmentLister
+	resolver    service.EnrollIDResolver
+	limit       int
+	topicLimits map[string]int
+	webhook     *microwebhook.MicroWebhook
+	logger      log.Logger
+}
+
+type Option func(*Quota)
+
+func WithLogger(logger log.Logger) Option {
+	return func(q *Quota) {
+		q.logger = logger
+	}
+}
+
+// WithLimit limits the number of enabled device enrollments of all
+// topics. A limit of 0 is unlimited.
+func WithLimit(limit int) Option {
+	return func(q *Quota) {
+		q.limit = limit
+	}
+}
+
+// WithTopicLimit limits the number of enabled device enrollments of
+// the APNs topic (e.g. of a tenant's push certificate).
+func WithTopicLimit(topic string, limit int) Option {
+	return func(q *Quota) {
+		q.topicLimits[topic] = limit
+	}
+}
+
+// WithEnrollIDResolver resolves enrollment IDs with resolver to find
+// enrollments that are already enrolled. Defaults to the NanoMDM
+// convention (see nanomdm.DefaultEnrollIDResolver).
+func WithEnrollIDResolver(resolver service.EnrollIDResolver) Option {
+	return func(q *Quota) {
+		q.resolver = resolver
+	}
+}
+
+// WithWebhook sends mdm.QuotaExceeded events of rejected enrollments
+// to the webhook at url.
+func WithWebhook(url string, opts ...microwebhook.Option) Option {
+	return func(q *Quota) {
+		q.webhook = microwebhook.New(url, opts...)
+	}
+}
+
+// New creates a new enrollment quota hook counting the enrollments of
+// store.
+func New(store storage.EnrollmentLister, opts ...Option) *Quota {
+	q := &Quota{
+		store:       store,
+		topicLimits: make(map[string]int),
+		logger:      log.NopLogger,
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	return q
+}
+
+// resolve returns the enrollment ID of e.
+func (q *Quota) resolve(ctx context.Context, e *mdm.Enrollment) (string, error) {
+	if q.resolver != nil {
+		id, err := q.resolver.ResolveEnrollID(ctx, e)
+		if err != nil || id == nil {
+			return "", err
+		}
+		return id.ID, nil
+	}
+	if r := e.Resolved(); r != nil {
+		return r.DeviceChannelID, nil
+	}
+	return "", nil
+}
+
+// CheckAuthenticate rejects new devices over the quota with HTTP 403.
+func (q *Quota) CheckAuthenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	ctx := r.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	id, err := q.resolve(ctx, &m.Enrollment)
+	if err != nil {
+		return fmt.Errorf("quota: resolving enrollment id: %w", err)
+	}
+	enrollments, err := q.store.ListEnrollments(ctx)
+	if err != nil {
+		return fmt.Errorf("quota: listing enrollments: %w", err)
+	}
+	var total, topic int
+	for _, e := range enrollments {
+		if !e.Enabled || e.ParentID != "" {
+			continue
+		}
+		if e.ID == id {
+			// re-enrollment of an enrolled device
+			return nil
+		}
+		total++
+		if e.Topic == m.Topic {
+			topic++
+		}
+	}
+	ev := &microwebhook.QuotaEvent{ID: id, UDID: m.UDID, EnrollmentID: m.EnrollmentID}
+	if limit, ok := q.topicLimits[m.Topic]; ok && topic >= limit {
+		ev.Topic, ev.Limit, ev.Enrollments = m.Topic, limit, topic
+	} else if q.limit > 0 && total >= q.limit {
+		ev.Limit, ev.Enrollments = q.limit, total
+	} else {
+		return nil
+	}
+	quota := "global"
+	if ev.Topic != "" {
+		quota = "topic"
+	}
+	q.logger.Info(
+		"msg", "enrollment quota reached",
+		"id", id,
+		"topic", m.Topic,
+		"quota", quota,
+		"limit", ev.Limit,
+		"enrollments", ev.Enrollments,
+	)
+	q.notify(ctx, ev)
+	return &service.RejectError{
+		StatusCode: http.StatusForbidden,
+		Reason:     fmt.Sprintf("enrollment quota of %d reached", ev.Limit),
+	}
+}
+
+// CheckTokenUpdate accepts all TokenUpdates: only new enrollments
+// (which start with an Authenticate) count against the quota.
+func (q *Quota) CheckTokenUpdate(_ *mdm.Request, _ *mdm.TokenUpdate) error {
+	return nil
+}
+
+// notify sends ev to the webhook, if any.
+func (q *Quota) notify(ctx context.Context, ev *microwebhook.QuotaEvent) {
+	if q.webhook == nil {
+		return
+	}
+	err := q.webhook.PostEvent(ctx, &microwebhook.Event{
+		Topic:      "mdm.QuotaExceeded",
+		CreatedAt:  time.Now(),
+		QuotaEvent: ev,
+	})
+	if err != nil {
+		q.logger.Info("msg", "sending quota webhook", "id", ev.ID, "err", err)
+	}
+}
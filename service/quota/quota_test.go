@@ -0,0 +1,53 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/service"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+type lister []*storage.Enrollment
+
+func (l lister) ListEnrollments(context.Context) ([]*storage.Enrollment, error) {
+	return l, nil
+}
+
+func authenticate(udid, topic string) *mdm.Authenticate {
+	return &mdm.Authenticate{
+		Enrollment: mdm.Enrollment{UDID: udid},
+		Topic:      topic,
+	}
+}
+
+func TestCheckAuthenticate(t *testing.T) {
+	store := lister{
+		{ID: "A", DeviceID: "A", Topic: "t1", Enabled: true},
+		{ID: "A:U", DeviceID: "A", ParentID: "A", Topic: "t1", Enabled: true},
+		{ID: "B", DeviceID: "B", Topic: "t2", Enabled: true},
+		{ID: "C", DeviceID: "C", Topic: "t1", Enabled: false},
+	}
+	r := &mdm.Request{Context: context.Background()}
+	for _, test := range []struct {
+		name   string
+		q      *Quota
+		m      *mdm.Authenticate
+		reject bool
+	}{
+		{"under limit", New(store, WithLimit(3)), authenticate("D", "t1"), false},
+		{"at limit", New(store, WithLimit(2)), authenticate("D", "t1"), true},
+		{"re-enrollment", New(store, WithLimit(2)), authenticate("A", "t1"), false},
+		{"disabled re-enrollment", New(store, WithLimit(2)), authenticate("C", "t1"), true},
+		{"topic at limit", New(store, WithTopicLimit("t1", 1)), authenticate("D", "t1"), true},
+		{"other topic", New(store, WithTopicLimit("t1", 1)), authenticate("D", "t3"), false},
+	} {
+		err := test.q.CheckAuthenticate(r, test.m)
+		var rejectErr *service.RejectError
+		if rejected := errors.As(err, &rejectErr); rejected != test.reject {
+			t.Errorf("%s: rejected: have %v, want %v (err: %v)", test.name, rejected, test.reject, err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Package reject is a NanoMDM service middleware that allows hooks to
+// veto Authenticate and TokenUpdate check-ins (e.g. of devices whose
+// serial number is not in an asset database).
+package reject
+
+import (
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/service"
+)
+
+// Hook checks check-in messages before they are processed. Returning a
+// *service.RejectError rejects the check-in with its HTTP status and
+// body. Any other error fails the check-in with an internal server
+// error (which devices retry).
+type Hook interface {
+	CheckAuthenticate(*mdm.Request, *mdm.Authenticate) error
+	CheckTokenUpdate(*mdm.Request, *mdm.TokenUpdate) error
+}
+
+// Reject is a service middleware that rejects the check-ins its hook
+// rejects. Rejected check-ins are not passed to the next service.
+type Reject struct {
+	next   service.CheckinAndCommandService
+	hook   Hook
+	logger log.Logger
+}
+
+type Option func(*Reject)
+
+func WithLogger(logger log.Logger) Option {
+	return func(r *Reject) {
+		r.logger = logger
+	}
+}
+
+// New creates a new check-in rejection service middleware.
+func New(next service.CheckinAndCommandService, hook Hook, opts ...Option) *Reject {
+	r := &Reject{
+		next:   next,
+		hook:   hook,
+		logger: log.NopLogger,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+func (rj *Reject) log(e mdm.Enrollment, messageType string, err error) {
+	rj.logger.Info(
+		"msg", "check-in hook",
+		"message_type", messageType,
+		"udid", e.UDID,
+		"enrollment_id", e.EnrollmentID,
+		"user_id", e.UserID,
+		"err", err,
+	)
+}
+
+func (rj *Reject) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	if err := rj.hook.CheckAuthenticate(r, m); err != nil {
+		rj.log(m.Enrollment, m.MessageType.MessageType, err)
+		return err
+	}
+	return rj.next.Authenticate(r, m)
+}
+
+func (rj *Reject) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	if err := rj.hook.CheckTokenUpdate(r, m); err != nil {
+		rj.log(m.Enrollment, m.MessageType.MessageType, err)
+		return err
+	}
+	return rj.next.TokenUpdate(r, m)
+}
+
+func (rj *Reject) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	return rj.next.CheckOut(r, m)
+}
+
+func (rj *Reject) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	return rj.next.CommandAndReportResults(r, results)
+}
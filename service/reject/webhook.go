@@ -0,0 +1,126 @@
+package reject
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/service"
+	"github.com/jessepeterson/nanomdm/service/microwebhook"
+)
+
+// maxBodySize is the maximum size of a rejection body.
+const maxBodySize = 64 << 10
+
+// Webhook is a Hook that POSTs check-ins as MicroMDM-compatible webhook
+// events (e.g. mdm.Authenticate) to a URL. A 2xx response accepts the
+// check-in and a 4xx response rejects it with the same status, content
+// type, and body (e.g. a plist error). Other responses and errors fail
+// the check-in so that devices retry.
+type Webhook struct {
+	url    string
+	client *http.Client
+	secret []byte
+}
+
+type WebhookOption func(*Webhook)
+
+// WithClient uses client for webhook requests.
+func WithClient(client *http.Client) WebhookOption {
+	return func(w *Webhook) {
+		w.client = client
+	}
+}
+
+// WithSigningSecret signs webhook requests like microwebhook does.
+// See microwebhook.WithSigningSecret.
+func WithSigningSecret(secret string) WebhookOption {
+	return func(w *Webhook) {
+		w.secret = []byte(secret)
+	}
+}
+
+// NewWebhook creates a new check-in rejection webhook hook.
+func NewWebhook(url string, opts ...WebhookOption) *Webhook {
+	w := &Webhook{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+func (w *Webhook) CheckAuthenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	return w.check(r.Context, &microwebhook.Event{
+		Topic:     "mdm.Authenticate",
+		CreatedAt: time.Now(),
+		CheckinEvent: &microwebhook.CheckinEvent{
+			UDID:         m.UDID,
+			EnrollmentID: m.EnrollmentID,
+			RawPayload:   m.Raw,
+		},
+	})
+}
+
+func (w *Webhook) CheckTokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	return w.check(r.Context, &microwebhook.Event{
+		Topic:     "mdm.TokenUpdate",
+		CreatedAt: time.Now(),
+		CheckinEvent: &microwebhook.CheckinEvent{
+			UDID:         m.UDID,
+			EnrollmentID: m.EnrollmentID,
+			RawPayload:   m.Raw,
+		},
+	})
+}
+
+// check posts ev to the webhook and interprets the response.
+func (w *Webhook) check(ctx context.Context, ev *microwebhook.Event) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ev.EventID = microwebhook.StableEventID("", ev)
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(microwebhook.IdempotencyKeyHeader, ev.EventID)
+	if len(w.secret) > 0 {
+		req.Header.Set(microwebhook.SignatureHeader, microwebhook.Sign(w.secret, body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("check-in hook: %w", err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+		if err != nil {
+			return fmt.Errorf("check-in hook: reading rejection: %w", err)
+		}
+		return &service.RejectError{
+			StatusCode:  resp.StatusCode,
+			ContentType: resp.Header.Get("Content-Type"),
+			Body:        respBody,
+			Reason:      "check-in hook rejected " + ev.Topic,
+		}
+	default:
+		return fmt.Errorf("check-in hook: unexpected HTTP status %d %s", resp.StatusCode, resp.Status)
+	}
+}
@@ -0,0 +1,57 @@
+package reject
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/service"
+	"github.com/jessepeterson/nanomdm/service/microwebhook"
+)
+
+func TestWebhook(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ev := new(microwebhook.Event)
+		if err := json.NewDecoder(r.Body).Decode(ev); err != nil || ev.CheckinEvent == nil {
+			http.Error(w, "bad event", http.StatusInternalServerError)
+			return
+		}
+		switch ev.CheckinEvent.UDID {
+		case "known":
+		case "error":
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		default:
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("<plist/>"))
+		}
+	}))
+	defer srv.Close()
+
+	hook := NewWebhook(srv.URL)
+	r := &mdm.Request{Context: context.Background()}
+	m := &mdm.Authenticate{Enrollment: mdm.Enrollment{UDID: "known"}}
+	if err := hook.CheckAuthenticate(r, m); err != nil {
+		t.Errorf("known device rejected: %v", err)
+	}
+
+	m.UDID = "unknown"
+	err := hook.CheckAuthenticate(r, m)
+	var rejectErr *service.RejectError
+	if !errors.As(err, &rejectErr) {
+		t.Fatalf("expected rejection, have: %v", err)
+	}
+	if rejectErr.StatusCode != http.StatusForbidden || rejectErr.ContentType != "application/xml" || string(rejectErr.Body) != "<plist/>" {
+		t.Errorf("unexpected rejection: %+v", rejectErr)
+	}
+
+	// server errors fail rather than reject
+	err = hook.CheckTokenUpdate(r, &mdm.TokenUpdate{Enrollment: mdm.Enrollment{UDID: "error"}})
+	if err == nil || errors.As(err, &rejectErr) {
+		t.Errorf("expected non-rejection error, have: %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+// Package replay is a NanoMDM service middleware that rejects replayed
+// check-in messages.
+package replay
+
+import (
+	"crypto/sha256"
+	"errors"
+	"sync"
+	"time"
+
+	mdmhttp "github.com/jessepeterson/nanomdm/http"
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/service"
+)
+
+var ErrReplay = errors.New("replayed check-in message")
+
+// DefaultWindow is the default duration for which check-in messages are remembered.
+const DefaultWindow = 10 * time.Minute
+
+type seen struct {
+	source string
+	at     time.Time
+}
+
+// Replay is a service middleware that rejects Authenticate and
+// TokenUpdate check-in messages whose identical raw body was already
+// seen within a time window, regardless of the identity certificate
+// (a captured request replays the certificate, too). Messages are only
+// remembered once they were processed successfully so that a device
+// retrying a failed message is not rejected.
+//
+// Seen messages are kept in memory. Each instance of a horizontally
+// scaled deployment therefore has its own cache.
+//
+// The source (client address) is only logged. It is retrieved from the
+// request context with mdmhttp.GetRemoteAddr and so requires
+// mdmhttp.RemoteAddrMiddleware.
+type Replay struct {
+	next   service.CheckinAndCommandService
+	logger log.Logger
+	window time.Duration
+	now    func() time.Time
+
+	mu    sync.Mutex
+	seen  map[[sha256.Size]byte]seen
+	swept time.Time
+}
+
+type Option func(*Replay)
+
+func WithLogger(logger log.Logger) Option {
+	return func(r *Replay) {
+		r.logger = logger
+	}
+}
+
+// WithWindow sets the duration for which check-in messages are remembered.
+func WithWindow(window time.Duration) Option {
+	return func(r *Replay) {
+		r.window = window
+	}
+}
+
+// New creates a new replay protection service middleware.
+func New(next service.CheckinAndCommandService, opts ...Option) *Replay {
+	r := &Replay{
+		next:   next,
+		logger: log.NopLogger,
+		window: DefaultWindow,
+		now:    time.Now,
+		seen:   make(map[[sha256.Size]byte]seen),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// check records raw as seen and returns ErrReplay if it was already
+// seen within the window. The returned function forgets raw again if
+// processing the message failed.
+func (s *Replay) check(r *mdm.Request, raw []byte) (func(error), error) {
+	var source string
+	if r.Context != nil {
+		source = mdmhttp.GetRemoteAddr(r.Context)
+	}
+	digest := sha256.Sum256(raw)
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.swept) > s.window {
+		for k, v := range s.seen {
+			if now.Sub(v.at) > s.window {
+				delete(s.seen, k)
+			}
+		}
+		s.swept = now
+	}
+	if prev, ok := s.seen[digest]; ok && now.Sub(prev.at) <= s.window {
+		s.logger.Info("msg", "replayed check-in", "source", source, "first_source", prev.source)
+		return nil, ErrReplay
+	}
+	s.seen[digest] = seen{source: source, at: now}
+	return func(err error) {
+		if err == nil {
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.seen[digest].at.Equal(now) {
+			delete(s.seen, digest)
+		}
+	}, nil
+}
+
+func (s *Replay) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	done, err := s.check(r, m.Raw)
+	if err != nil {
+		return err
+	}
+	err = s.next.Authenticate(r, m)
+	done(err)
+	return err
+}
+
+func (s *Replay) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	done, err := s.check(r, m.Raw)
+	if err != nil {
+		return err
+	}
+	err = s.next.TokenUpdate(r, m)
+	done(err)
+	return err
+}
+
+func (s *Replay) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	return s.next.CheckOut(r, m)
+}
+
+func (s *Replay) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	return s.next.CommandAndReportResults(r, results)
+}
@@ -0,0 +1,90 @@
+package replay
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mdmhttp "github.com/jessepeterson/nanomdm/http"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/service"
+)
+
+// sourceRequest creates an MDM request with source as the client address
+// and an identity certificate of identity (none if empty).
+func sourceRequest(source, identity string) *mdm.Request {
+	var ctx context.Context
+	r := httptest.NewRequest("POST", "/mdm", nil)
+	r.RemoteAddr = source + ":1234"
+	mdmhttp.RemoteAddrMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		ctx = r.Context()
+	}), "", nil).ServeHTTP(nil, r)
+	req := &mdm.Request{Context: ctx}
+	if identity != "" {
+		req.Certificate = &x509.Certificate{Raw: []byte(identity)}
+	}
+	return req
+}
+
+// failingService fails check-ins while err is set.
+type failingService struct {
+	service.CheckinAndCommandService
+	err error
+}
+
+func (s *failingService) Authenticate(*mdm.Request, *mdm.Authenticate) error {
+	return s.err
+}
+
+func TestReplay(t *testing.T) {
+	now := time.Now()
+	s := New(nil, WithWindow(time.Minute))
+	s.now = func() time.Time { return now }
+	raw := []byte("authenticate")
+
+	for _, test := range []struct {
+		source   string
+		identity string
+		advance  time.Duration
+		err      error
+	}{
+		{"192.0.2.1", "device", 0, nil},
+		{"192.0.2.1", "device", time.Second, ErrReplay}, // identical request
+		{"192.0.2.2", "device", time.Second, ErrReplay},
+		{"192.0.2.3", "other", time.Second, ErrReplay},
+		{"192.0.2.1", "", time.Second, ErrReplay},
+		{"192.0.2.1", "device", 2 * time.Minute, nil}, // outside window
+		{"192.0.2.1", "device", time.Second, ErrReplay},
+	} {
+		now = now.Add(test.advance)
+		if _, err := s.check(sourceRequest(test.source, test.identity), raw); !errors.Is(err, test.err) {
+			t.Errorf("source %s identity %q: have %v, want %v", test.source, test.identity, err, test.err)
+		}
+	}
+	if _, err := s.check(sourceRequest("192.0.2.1", "device"), []byte("other")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReplayRetry(t *testing.T) {
+	next := &failingService{err: errors.New("storage unavailable")}
+	s := New(next)
+	m := &mdm.Authenticate{Raw: []byte("authenticate")}
+
+	// a failed message may be retried
+	if err := s.Authenticate(sourceRequest("192.0.2.1", "device"), m); !errors.Is(err, next.err) {
+		t.Fatalf("have %v, want %v", err, next.err)
+	}
+	next.err = nil
+	if err := s.Authenticate(sourceRequest("192.0.2.1", "device"), m); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	// but not replayed once processed
+	if err := s.Authenticate(sourceRequest("192.0.2.1", "device"), m); !errors.Is(err, ErrReplay) {
+		t.Errorf("replay: have %v, want %v", err, ErrReplay)
+	}
+}
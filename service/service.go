@@ -2,6 +2,9 @@
 package service
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/jessepeterson/nanomdm/mdm"
 )
 
@@ -24,3 +27,33 @@ type CheckinAndCommandService interface {
 	Checkin
 	CommandAndReportResults
 }
+
+// EnrollIDResolver resolves the enrollment IDs used by services and
+// storage (see mdm.EnrollID) from the enrollment data of a request.
+// Resolved IDs must be consistent across the lifetime of an enrollment.
+type EnrollIDResolver interface {
+	ResolveEnrollID(ctx context.Context, e *mdm.Enrollment) (*mdm.EnrollID, error)
+}
+
+// EnrollIDResolverFunc adapts a function to an EnrollIDResolver.
+type EnrollIDResolverFunc func(ctx context.Context, e *mdm.Enrollment) (*mdm.EnrollID, error)
+
+// ResolveEnrollID calls f(ctx, e).
+func (f EnrollIDResolverFunc) ResolveEnrollID(ctx context.Context, e *mdm.Enrollment) (*mdm.EnrollID, error) {
+	return f(ctx, e)
+}
+
+// RejectError rejects an MDM request. HTTP handlers respond to the
+// device with StatusCode and, if not empty, Body of ContentType rather
+// than an internal server error.
+type RejectError struct {
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	// Reason is logged but not sent to the device.
+	Reason string
+}
+
+func (e *RejectError) Error() string {
+	return fmt.Sprintf("rejected with HTTP status %d: %s", e.StatusCode, e.Reason)
+}
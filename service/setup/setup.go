@@ -0,0 +1,243 @@
+// Package setup is a NanoMDM service that releases devices awaiting
+// configuration (i.e. held in Setup Assistant by Automated Device
+// Enrollment).
+package setup
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/cmdplist"
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/push"
+	"github.com/jessepeterson/nanomdm/service/microwebhook"
+	"github.com/jessepeterson/nanomdm/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// Command is a setup command to enqueue. Command is a command name from
+// the cmdplist catalog and Args its arguments. Files are the paths of
+// the data arguments (e.g. the Payload of InstallProfile) keyed by
+// argument key.
+type Command struct {
+	Command string            `yaml:"command"`
+	Args    map[string]string `yaml:"args"`
+	Files   map[string]string `yaml:"files"`
+
+	spec *cmdplist.Spec
+	data map[string][]byte
+}
+
+// build assembles a new command (with a new CommandUUID).
+func (c *Command) build() (*cmdplist.Command, error) {
+	return c.spec.Build(c.Args, c.data)
+}
+
+// LoadCommands reads and validates YAML setup commands from path. The
+// file contains a top-level "commands" list. The data argument files
+// are read once at load.
+func LoadCommands(path string) ([]*Command, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		Commands []*Command `yaml:"commands"`
+	}
+	if err := yaml.Unmarshal(b, &config); err != nil {
+		return nil, fmt.Errorf("parsing setup commands: %w", err)
+	}
+	for _, c := range config.Commands {
+		if c.spec = cmdplist.Lookup(c.Command); c.spec == nil {
+			return nil, fmt.Errorf("unknown command: %s", c.Command)
+		}
+		c.data = make(map[string][]byte)
+		for key, path := range c.Files {
+			if c.data[key], err = ioutil.ReadFile(path); err != nil {
+				return nil, err
+			}
+		}
+		if _, err = c.build(); err != nil {
+			return nil, fmt.Errorf("command %s: %w", c.Command, err)
+		}
+	}
+	return config.Commands, nil
+}
+
+// Release is a service that enqueues the setup commands (followed by a
+// DeviceConfigured command) to devices whose TokenUpdate reports they
+// are awaiting configuration. If an approval webhook is configured the
+// devices are only released once the webhook approves (responds with
+// HTTP 200) the release. It is intended to run alongside the core
+// NanoMDM service (i.e. with the multi service) so that enrollment IDs
+// are resolved.
+type Release struct {
+	commands []*Command
+	enqueuer storage.CommandEnqueuer
+	pusher   push.Pusher
+	approval *microwebhook.MicroWebhook
+	logger   log.Logger
+
+	mu       sync.Mutex
+	released map[string]bool
+}
+
+type Option func(*Release)
+
+func WithLogger(logger log.Logger) Option {
+	return func(s *Release) {
+		s.logger = logger
+	}
+}
+
+// WithPusher sends APNs pushes after enqueuing the setup commands.
+func WithPusher(pusher push.Pusher) Option {
+	return func(s *Release) {
+		s.pusher = pusher
+	}
+}
+
+// WithApprovalWebhook requires the webhook at url to approve releases.
+// Devices that are not approved are held awaiting configuration.
+func WithApprovalWebhook(url string, opts ...microwebhook.Option) Option {
+	return func(s *Release) {
+		s.approval = microwebhook.New(url, opts...)
+	}
+}
+
+// New creates a new release service enqueuing commands. A
+// DeviceConfigured command is added if commands does not end with one.
+func New(commands []*Command, enqueuer storage.CommandEnqueuer, opts ...Option) *Release {
+	if len(commands) < 1 || commands[len(commands)-1].spec.RequestType != "DeviceConfigured" {
+		commands = append(commands, &Command{
+			Command: "DeviceConfigured",
+			spec:    cmdplist.Lookup("DeviceConfigured"),
+		})
+	}
+	s := &Release{
+		commands: commands,
+		enqueuer: enqueuer,
+		logger:   log.NopLogger,
+		released: make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// claim reports whether enrollment id has not yet been released and,
+// if so, records it as released.
+func (s *Release) claim(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.released[id] {
+		return false
+	}
+	s.released[id] = true
+	return true
+}
+
+// forget allows enrollment id to be released again.
+func (s *Release) forget(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.released, id)
+}
+
+// approve asks the approval webhook (if any) to approve the release.
+func (s *Release) approve(ctx context.Context, r *mdm.Request, m *mdm.TokenUpdate) error {
+	if s.approval == nil {
+		return nil
+	}
+	ev := &microwebhook.Event{
+		Topic:     "mdm.AwaitingConfiguration",
+		CreatedAt: time.Now(),
+		SetupEvent: &microwebhook.SetupEvent{
+			ID:           r.ID,
+			UDID:         m.UDID,
+			EnrollmentID: m.EnrollmentID,
+			RawPayload:   m.Raw,
+		},
+	}
+	return s.approval.PostEvent(ctx, ev)
+}
+
+// release enqueues the setup commands to the enrollment in r.
+func (s *Release) release(ctx context.Context, r *mdm.Request) error {
+	var cmds []*mdm.Command
+	for _, c := range s.commands {
+		cmd, err := c.build()
+		if err != nil {
+			return err
+		}
+		mdmCmd, err := cmd.MDMCommand()
+		if err != nil {
+			return err
+		}
+		cmds = append(cmds, mdmCmd)
+	}
+	for _, cmd := range cmds {
+		idErrs, err := s.enqueuer.EnqueueCommand(ctx, []string{r.ID}, cmd)
+		if err == nil {
+			err = idErrs[r.ID]
+		}
+		if err != nil {
+			return err
+		}
+		s.logger.Info("msg", "enqueued setup command", "id", r.ID, "command_uuid", cmd.CommandUUID, "request_type", cmd.Command.RequestType)
+	}
+	if s.pusher != nil {
+		if _, err := s.pusher.Push(ctx, []string{r.ID}); err != nil {
+			s.logger.Info("msg", "push", "id", r.ID, "err", err)
+		}
+	}
+	return nil
+}
+
+func (s *Release) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	if r.EnrollID != nil {
+		// a (re-)enrollment may be awaiting configuration again
+		s.forget(r.ID)
+	}
+	return nil
+}
+
+func (s *Release) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	if !m.AwaitingConfiguration || r.EnrollID == nil || r.ParentID != "" {
+		return nil
+	}
+	if !s.claim(r.ID) {
+		return nil
+	}
+	ctx := r.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := s.approve(ctx, r, m); err != nil {
+		s.forget(r.ID)
+		s.logger.Info("msg", "release not approved", "id", r.ID, "err", err)
+		return nil
+	}
+	if err := s.release(ctx, r); err != nil {
+		s.forget(r.ID)
+		return fmt.Errorf("releasing %s: %w", r.ID, err)
+	}
+	s.logger.Info("msg", "released device awaiting configuration", "id", r.ID)
+	return nil
+}
+
+func (s *Release) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	if r.EnrollID != nil {
+		s.forget(r.ID)
+	}
+	return nil
+}
+
+func (s *Release) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	return nil, nil
+}
@@ -0,0 +1,43 @@
+package setup
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+)
+
+type enqueuer struct {
+	requestTypes []string
+}
+
+func (e *enqueuer) EnqueueCommand(_ context.Context, _ []string, cmd *mdm.Command) (map[string]error, error) {
+	e.requestTypes = append(e.requestTypes, cmd.Command.RequestType)
+	return nil, nil
+}
+
+func TestRelease(t *testing.T) {
+	e := new(enqueuer)
+	s := New(nil, e)
+	r := &mdm.Request{Context: context.Background(), EnrollID: &mdm.EnrollID{ID: "UDID"}}
+	m := &mdm.TokenUpdate{AwaitingConfiguration: true}
+	for i := 0; i < 2; i++ {
+		if err := s.TokenUpdate(r, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// released only once
+	if len(e.requestTypes) != 1 || e.requestTypes[0] != "DeviceConfigured" {
+		t.Fatalf("unexpected commands: %v", e.requestTypes)
+	}
+	// re-enrollment releases again
+	if err := s.Authenticate(r, &mdm.Authenticate{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.TokenUpdate(r, m); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.requestTypes) != 2 {
+		t.Errorf("unexpected commands: %v", e.requestTypes)
+	}
+}
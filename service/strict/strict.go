@@ -0,0 +1,98 @@
+// Package strict is a NanoMDM service middleware that rejects check-in
+// messages and command results that fail strict plist validation.
+package strict
+
+import (
+	"net/http"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/service"
+)
+
+// Strict is a service middleware that strictly validates the raw plists
+// of check-in messages and command results (see mdm.ValidateCheckin and
+// mdm.ValidateCommandResults). Invalid messages are rejected with HTTP
+// 400 and their problems logged. Rejected messages are not passed to
+// the next service.
+type Strict struct {
+	next    service.CheckinAndCommandService
+	logger  log.Logger
+	logOnly bool
+}
+
+type Option func(*Strict)
+
+func WithLogger(logger log.Logger) Option {
+	return func(s *Strict) {
+		s.logger = logger
+	}
+}
+
+// WithLogOnly only logs the problems of invalid messages rather than
+// rejecting them, e.g. to try strict validation with a fleet.
+func WithLogOnly() Option {
+	return func(s *Strict) {
+		s.logOnly = true
+	}
+}
+
+// New creates a new strict validation service middleware.
+func New(next service.CheckinAndCommandService, opts ...Option) *Strict {
+	s := &Strict{
+		next:   next,
+		logger: log.NopLogger,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// check returns a rejection for err, the strict validation error of
+// the message of enrollment e, or nil if err is nil or only logged.
+func (s *Strict) check(e mdm.Enrollment, err error) error {
+	if err == nil {
+		return nil
+	}
+	s.logger.Info(
+		"msg", "strict validation",
+		"udid", e.UDID,
+		"enrollment_id", e.EnrollmentID,
+		"user_id", e.UserID,
+		"log_only", s.logOnly,
+		"err", err,
+	)
+	if s.logOnly {
+		return nil
+	}
+	return &service.RejectError{StatusCode: http.StatusBadRequest, Reason: err.Error()}
+}
+
+func (s *Strict) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	if err := s.check(m.Enrollment, mdm.ValidateCheckin(m.Raw)); err != nil {
+		return err
+	}
+	return s.next.Authenticate(r, m)
+}
+
+func (s *Strict) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	if err := s.check(m.Enrollment, mdm.ValidateCheckin(m.Raw)); err != nil {
+		return err
+	}
+	return s.next.TokenUpdate(r, m)
+}
+
+func (s *Strict) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	if err := s.check(m.Enrollment, mdm.ValidateCheckin(m.Raw)); err != nil {
+		return err
+	}
+	return s.next.CheckOut(r, m)
+}
+
+func (s *Strict) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	if err := s.check(results.Enrollment, mdm.ValidateCommandResults(results.Raw)); err != nil {
+		return nil, err
+	}
+	return s.next.CommandAndReportResults(r, results)
+}
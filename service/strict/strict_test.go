@@ -0,0 +1,45 @@
+package strict
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/service"
+)
+
+// countService counts the command results it receives.
+type countService struct {
+	service.CheckinAndCommandService
+	results int
+}
+
+func (s *countService) CommandAndReportResults(*mdm.Request, *mdm.CommandResults) (*mdm.Command, error) {
+	s.results++
+	return nil, nil
+}
+
+const invalidResults = `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0"><dict><key>UDID</key><string>A</string><key>Status</key><string>Done</string></dict></plist>`
+
+func TestStrict(t *testing.T) {
+	next := new(countService)
+	results := &mdm.CommandResults{Raw: []byte(invalidResults)}
+
+	_, err := New(next).CommandAndReportResults(new(mdm.Request), results)
+	var rejectErr *service.RejectError
+	if !errors.As(err, &rejectErr) || rejectErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("have %v, want HTTP 400 rejection", err)
+	}
+	if next.results != 0 {
+		t.Error("rejected results passed to next service")
+	}
+
+	if _, err = New(next, WithLogOnly()).CommandAndReportResults(new(mdm.Request), results); err != nil {
+		t.Fatal(err)
+	}
+	if next.results != 1 {
+		t.Error("results not passed to next service in log only mode")
+	}
+}
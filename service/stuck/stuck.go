@@ -0,0 +1,203 @@
+// Package stuck detects enrollments that appear to be unreachable.
+package stuck
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/service/microwebhook"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// DefaultInterval is the default analysis interval.
+const DefaultInterval = 15 * time.Minute
+
+// Store is the storage required by the analyzer.
+type Store interface {
+	storage.EnrollmentLister
+	storage.QueueStatsStore
+}
+
+// Device is an enrollment that is considered stuck.
+type Device struct {
+	ID               string     `json:"id"`
+	DeviceID         string     `json:"device_id"`
+	Type             string     `json:"type"`
+	Topic            string     `json:"topic,omitempty"`
+	Pending          int        `json:"pending"`
+	OldestEnqueuedAt *time.Time `json:"oldest_enqueued_at,omitempty"`
+	LastSeenAt       *time.Time `json:"last_seen_at,omitempty"`
+}
+
+// Analyzer flags enrollments as stuck: those that are enabled (i.e.
+// have valid push info), have pending commands, and have not been seen
+// (checked-in) within a threshold. Such devices are likely unreachable
+// (e.g. have lost network access, have an invalid push token, or were
+// wiped without checking out). Analysis runs periodically with Run and
+// webhook events are sent when enrollments become (or stop being)
+// stuck.
+type Analyzer struct {
+	store     Store
+	logger    log.Logger
+	webhook   *microwebhook.MicroWebhook
+	threshold time.Duration
+	interval  time.Duration
+
+	mu    sync.Mutex
+	stuck map[string]*Device
+}
+
+type Option func(*Analyzer)
+
+func WithLogger(logger log.Logger) Option {
+	return func(a *Analyzer) {
+		a.logger = logger
+	}
+}
+
+// WithWebhook sends webhook events for stuck enrollments to url.
+func WithWebhook(url string, opts ...microwebhook.Option) Option {
+	return func(a *Analyzer) {
+		a.webhook = microwebhook.New(url, opts...)
+	}
+}
+
+// WithInterval sets the interval at which enrollments are analyzed.
+func WithInterval(interval time.Duration) Option {
+	return func(a *Analyzer) {
+		a.interval = interval
+	}
+}
+
+// New creates a new analyzer that considers enrollments not seen
+// within threshold as stuck.
+func New(store Store, threshold time.Duration, opts ...Option) *Analyzer {
+	a := &Analyzer{
+		store:     store,
+		logger:    log.NopLogger,
+		threshold: threshold,
+		interval:  DefaultInterval,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Analyze returns the stuck enrollments.
+func (a *Analyzer) Analyze(ctx context.Context) ([]*Device, error) {
+	stats, err := a.store.RetrieveQueueStats(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	pending := make(map[string]*storage.QueueStats)
+	for _, st := range stats {
+		if st.Pending > 0 {
+			pending[st.ID] = st
+		}
+	}
+	if len(pending) < 1 {
+		return nil, nil
+	}
+	enrollments, err := a.store.ListEnrollments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var devices []*Device
+	for _, e := range enrollments {
+		st := pending[e.ID]
+		if st == nil || !e.Enabled || e.Topic == "" {
+			continue
+		}
+		if e.LastSeenAt != nil && now.Sub(*e.LastSeenAt) < a.threshold {
+			continue
+		}
+		// never seen (e.g. before last seen was tracked) so use the
+		// age of the pending commands instead
+		if e.LastSeenAt == nil && (st.OldestEnqueuedAt == nil || now.Sub(*st.OldestEnqueuedAt) < a.threshold) {
+			continue
+		}
+		devices = append(devices, &Device{
+			ID:               e.ID,
+			DeviceID:         e.DeviceID,
+			Type:             e.Type,
+			Topic:            e.Topic,
+			Pending:          st.Pending,
+			OldestEnqueuedAt: st.OldestEnqueuedAt,
+			LastSeenAt:       e.LastSeenAt,
+		})
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+	return devices, nil
+}
+
+// Run analyzes enrollments every interval until ctx is done.
+func (a *Analyzer) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		if err := a.update(ctx); err != nil {
+			a.logger.Info("msg", "analyzing stuck enrollments", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// update analyzes enrollments and sends webhook events for enrollments
+// that became (or stopped being) stuck since the last analysis.
+func (a *Analyzer) update(ctx context.Context) error {
+	devices, err := a.Analyze(ctx)
+	if err != nil {
+		return err
+	}
+	stuck := make(map[string]*Device)
+	for _, d := range devices {
+		stuck[d.ID] = d
+	}
+	a.mu.Lock()
+	prev := a.stuck
+	a.stuck = stuck
+	a.mu.Unlock()
+	for id, d := range stuck {
+		if _, ok := prev[id]; !ok {
+			a.logger.Info("msg", "enrollment stuck", "id", id, "pending", d.Pending)
+			a.post(ctx, d, true)
+		}
+	}
+	for id, d := range prev {
+		if _, ok := stuck[id]; !ok {
+			a.logger.Info("msg", "enrollment no longer stuck", "id", id)
+			a.post(ctx, d, false)
+		}
+	}
+	return nil
+}
+
+func (a *Analyzer) post(ctx context.Context, d *Device, stuck bool) {
+	if a.webhook == nil {
+		return
+	}
+	err := a.webhook.PostEvent(ctx, &microwebhook.Event{
+		Topic:     "mdm.Stuck",
+		CreatedAt: time.Now(),
+		StuckEvent: &microwebhook.StuckEvent{
+			ID:               d.ID,
+			DeviceID:         d.DeviceID,
+			Stuck:            stuck,
+			Pending:          d.Pending,
+			OldestEnqueuedAt: d.OldestEnqueuedAt,
+			LastSeenAt:       d.LastSeenAt,
+		},
+	})
+	if err != nil {
+		a.logger.Info("msg", "posting stuck webhook", "id", d.ID, "err", err)
+	}
+}
@@ -0,0 +1,62 @@
+package stuck
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+type testStore struct {
+	enrollments []*storage.Enrollment
+	stats       []*storage.QueueStats
+}
+
+func (s *testStore) ListEnrollments(_ context.Context) ([]*storage.Enrollment, error) {
+	return s.enrollments, nil
+}
+
+func (s *testStore) RetrieveQueueStats(_ context.Context, _ []string) ([]*storage.QueueStats, error) {
+	return s.stats, nil
+}
+
+func TestAnalyze(t *testing.T) {
+	now := time.Now()
+	recent := now.Add(-time.Hour)
+	old := now.Add(-48 * time.Hour)
+	store := &testStore{
+		enrollments: []*storage.Enrollment{
+			{ID: "seen", Topic: "t", Enabled: true, LastSeenAt: &recent},
+			{ID: "stuck", Topic: "t", Enabled: true, LastSeenAt: &old},
+			{ID: "disabled", Topic: "t", Enabled: false, LastSeenAt: &old},
+			{ID: "idle", Topic: "t", Enabled: true, LastSeenAt: &old},
+			{ID: "neverseen", Topic: "t", Enabled: true},
+			{ID: "neverseen-new", Topic: "t", Enabled: true},
+		},
+		stats: []*storage.QueueStats{
+			{ID: "seen", Pending: 1, OldestEnqueuedAt: &old},
+			{ID: "stuck", Pending: 2, OldestEnqueuedAt: &old},
+			{ID: "disabled", Pending: 1, OldestEnqueuedAt: &old},
+			{ID: "idle", Pending: 0},
+			{ID: "neverseen", Pending: 1, OldestEnqueuedAt: &old},
+			{ID: "neverseen-new", Pending: 1, OldestEnqueuedAt: &recent},
+		},
+	}
+	devices, err := New(store, 24*time.Hour).Analyze(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(devices), 2; have != want {
+		t.Fatalf("stuck devices: have %d, want %d", have, want)
+	}
+	if have, want := devices[0].ID, "neverseen"; have != want {
+		t.Errorf("id: have %q, want %q", have, want)
+	}
+	if have, want := devices[1].ID, "stuck"; have != want {
+		t.Errorf("id: have %q, want %q", have, want)
+	}
+	if have, want := devices[1].Pending, 2; have != want {
+		t.Errorf("pending: have %d, want %d", have, want)
+	}
+}
@@ -0,0 +1,104 @@
+package topic
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/cryptoutil"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// Problems of topics (and their enrollments) in a Report.
+const (
+	ProblemNoPushCert      = "no push certificate"
+	ProblemExpiredPushCert = "push certificate expired"
+	ProblemInvalidPushCert = "invalid push certificate"
+	ProblemUnused          = "no enrollments"
+)
+
+// TopicSummary is the reconciliation of an APNs topic.
+type TopicSummary struct {
+	Topic string `json:"topic"`
+	// Enrollments is the number of enabled enrollments of the topic.
+	Enrollments int                      `json:"enrollments"`
+	PushCert    *cryptoutil.PushCertInfo `json:"push_cert,omitempty"`
+	Problem     string                   `json:"problem,omitempty"`
+}
+
+// Orphan is an enabled enrollment whose topic has no usable push
+// certificate. The server can't push to it.
+type Orphan struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Topic   string `json:"topic"`
+	Problem string `json:"problem"`
+}
+
+// Report cross-references the topics of enrollments with the stored
+// push certificates.
+type Report struct {
+	Topics []*TopicSummary `json:"topics"`
+	// Orphaned are the enabled enrollments without a usable push
+	// certificate.
+	Orphaned []*Orphan `json:"orphaned_enrollments"`
+	// Unused are the topics of push certificates without enabled
+	// enrollments.
+	Unused []string `json:"unused_push_certs"`
+}
+
+// Reconcile creates a Report of the enabled enrollments of lister and
+// the push certificates of certs. Push certificates expired at now
+// are not usable.
+func Reconcile(ctx context.Context, lister storage.EnrollmentLister, certs storage.PushCertListStore, now time.Time) (*Report, error) {
+	enrollments, err := lister.ListEnrollments(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing enrollments: %w", err)
+	}
+	pemCerts, err := certs.ListPushCerts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing push certs: %w", err)
+	}
+
+	topics := make(map[string]*TopicSummary)
+	for topic, pemCert := range pemCerts {
+		summary := &TopicSummary{Topic: topic}
+		if summary.PushCert, err = cryptoutil.PushCertInfoFromPEM(pemCert); err != nil {
+			summary.Problem = ProblemInvalidPushCert + ": " + err.Error()
+		} else if now.After(summary.PushCert.NotAfter) {
+			summary.Problem = ProblemExpiredPushCert
+		}
+		topics[topic] = summary
+	}
+
+	report := &Report{Topics: []*TopicSummary{}, Orphaned: []*Orphan{}, Unused: []string{}}
+	for _, e := range enrollments {
+		if !e.Enabled {
+			continue
+		}
+		summary, ok := topics[e.Topic]
+		if !ok {
+			summary = &TopicSummary{Topic: e.Topic, Problem: ProblemNoPushCert}
+			topics[e.Topic] = summary
+		}
+		summary.Enrollments++
+		if summary.Problem != "" {
+			report.Orphaned = append(report.Orphaned, &Orphan{ID: e.ID, Type: e.Type, Topic: e.Topic, Problem: summary.Problem})
+		}
+	}
+
+	for _, summary := range topics {
+		if summary.Enrollments < 1 {
+			if summary.Problem == "" {
+				summary.Problem = ProblemUnused
+			}
+			report.Unused = append(report.Unused, summary.Topic)
+		}
+		report.Topics = append(report.Topics, summary)
+	}
+	sort.Slice(report.Topics, func(i, j int) bool { return report.Topics[i].Topic < report.Topics[j].Topic })
+	sort.Slice(report.Orphaned, func(i, j int) bool { return report.Orphaned[i].ID < report.Orphaned[j].ID })
+	sort.Strings(report.Unused)
+	return report, nil
+}
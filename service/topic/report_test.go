@@ -0,0 +1,96 @@
+package topic
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/cryptoutil"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+type reportStore struct {
+	enrollments []*storage.Enrollment
+	certs       map[string][]byte
+}
+
+func (s *reportStore) ListEnrollments(context.Context) ([]*storage.Enrollment, error) {
+	return s.enrollments, nil
+}
+
+func (s *reportStore) ListPushCerts(context.Context) (map[string][]byte, error) {
+	return s.certs, nil
+}
+
+func (s *reportStore) DeletePushCert(context.Context, string) error {
+	return nil
+}
+
+func pushCertPEM(t *testing.T, topic string, notAfter time.Time) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			ExtraNames: []pkix.AttributeTypeAndValue{{Type: asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 1}, Value: topic}},
+		},
+		NotBefore: notAfter.Add(-time.Hour),
+		NotAfter:  notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cryptoutil.PEMCertificate(der)
+}
+
+func TestReconcile(t *testing.T) {
+	const good, expired, missing, unused = "com.apple.mgmt.good", "com.apple.mgmt.expired", "com.apple.mgmt.missing", "com.apple.mgmt.unused"
+	now := time.Now()
+	store := &reportStore{
+		enrollments: []*storage.Enrollment{
+			{ID: "A", Topic: good, Enabled: true},
+			{ID: "B", Topic: expired, Enabled: true},
+			{ID: "C", Topic: missing, Enabled: true},
+			{ID: "D", Topic: unused, Enabled: false},
+		},
+		certs: map[string][]byte{
+			good:    pushCertPEM(t, good, now.Add(time.Hour)),
+			expired: pushCertPEM(t, expired, now.Add(-time.Minute)),
+			unused:  pushCertPEM(t, unused, now.Add(time.Hour)),
+		},
+	}
+	report, err := Reconcile(context.Background(), store, store, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var orphans []string
+	for _, o := range report.Orphaned {
+		orphans = append(orphans, o.ID+" "+o.Problem)
+	}
+	if want := []string{"B " + ProblemExpiredPushCert, "C " + ProblemNoPushCert}; !reflect.DeepEqual(orphans, want) {
+		t.Errorf("orphans: have %v, want %v", orphans, want)
+	}
+	if want := []string{unused}; !reflect.DeepEqual(report.Unused, want) {
+		t.Errorf("unused: have %v, want %v", report.Unused, want)
+	}
+	if len(report.Topics) != 4 {
+		t.Fatalf("topics: have %d, want 4", len(report.Topics))
+	}
+	for _, summary := range report.Topics {
+		if summary.Topic == good && (summary.Problem != "" || summary.Enrollments != 1 || summary.PushCert == nil) {
+			t.Errorf("good topic: have %+v", summary)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Package topic is a NanoMDM service middleware that validates the
+// APNs topic of enrollments against the push certificates the server
+// holds.
+package topic
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/service"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+var ErrUnknownTopic = errors.New("no push certificate for topic")
+
+// DefaultCacheTTL is the default duration for which topics with a push
+// certificate are remembered.
+const DefaultCacheTTL = time.Minute
+
+// Topic is a service middleware that checks that the APNs topic in
+// Authenticate and TokenUpdate check-in messages has a push
+// certificate in the push cert store. Enrollments with a topic the
+// server can't push to are otherwise unreachable once enrolled.
+// Mismatches are logged and, optionally, rejected.
+//
+// Topics with a push certificate are cached in memory for a short
+// time. Topics without one are always re-checked so a newly uploaded
+// push certificate takes effect immediately.
+type Topic struct {
+	next   service.CheckinAndCommandService
+	store  storage.PushCertStore
+	logger log.Logger
+	reject bool
+	ttl    time.Duration
+
+	mu    sync.Mutex
+	known map[string]time.Time
+}
+
+type Option func(*Topic)
+
+func WithLogger(logger log.Logger) Option {
+	return func(t *Topic) {
+		t.logger = logger
+	}
+}
+
+// WithReject rejects check-in messages with an unknown topic rather
+// than only logging them.
+func WithReject() Option {
+	return func(t *Topic) {
+		t.reject = true
+	}
+}
+
+// WithCacheTTL sets the duration for which topics with a push
+// certificate are remembered.
+func WithCacheTTL(ttl time.Duration) Option {
+	return func(t *Topic) {
+		t.ttl = ttl
+	}
+}
+
+// New creates a new topic validation service middleware.
+func New(next service.CheckinAndCommandService, store storage.PushCertStore, opts ...Option) *Topic {
+	t := &Topic{
+		next:   next,
+		store:  store,
+		logger: log.NopLogger,
+		ttl:    DefaultCacheTTL,
+		known:  make(map[string]time.Time),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// held returns true if the store has a push certificate for topic.
+func (t *Topic) held(ctx context.Context, topic string) bool {
+	t.mu.Lock()
+	at, ok := t.known[topic]
+	t.mu.Unlock()
+	if ok && time.Since(at) < t.ttl {
+		return true
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	cert, _, err := t.store.RetrievePushCert(ctx, topic)
+	if err != nil || cert == nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			t.logger.Debug("msg", "retrieving push cert", "topic", topic, "err", err)
+		} else if err != nil {
+			t.logger.Info("msg", "retrieving push cert", "topic", topic, "err", err)
+		}
+		return false
+	}
+	t.mu.Lock()
+	t.known[topic] = time.Now()
+	t.mu.Unlock()
+	return true
+}
+
+// check validates topic and returns ErrUnknownTopic if rejecting.
+func (t *Topic) check(r *mdm.Request, e mdm.Enrollment, messageType, topic string) error {
+	if t.held(r.Context, topic) {
+		return nil
+	}
+	t.logger.Info(
+		"msg", "topic mismatch",
+		"message_type", messageType,
+		"topic", topic,
+		"udid", e.UDID,
+		"enrollment_id", e.EnrollmentID,
+		"user_id", e.UserID,
+		"rejected", t.reject,
+	)
+	if t.reject {
+		return ErrUnknownTopic
+	}
+	return nil
+}
+
+func (t *Topic) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	if err := t.check(r, m.Enrollment, m.MessageType.MessageType, m.Topic); err != nil {
+		return err
+	}
+	return t.next.Authenticate(r, m)
+}
+
+func (t *Topic) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	if err := t.check(r, m.Enrollment, m.MessageType.MessageType, m.Topic); err != nil {
+		return err
+	}
+	return t.next.TokenUpdate(r, m)
+}
+
+func (t *Topic) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	return t.next.CheckOut(r, m)
+}
+
+func (t *Topic) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	return t.next.CommandAndReportResults(r, results)
+}
@@ -0,0 +1,56 @@
+package topic
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+)
+
+type pushCertStore struct {
+	topic     string
+	retrieved int
+}
+
+func (s *pushCertStore) IsPushCertStale(context.Context, string, string) (bool, error) {
+	return false, nil
+}
+
+func (s *pushCertStore) RetrievePushCert(_ context.Context, topic string) (*tls.Certificate, string, error) {
+	s.retrieved++
+	if topic != s.topic {
+		return nil, "", errors.New("not found")
+	}
+	return &tls.Certificate{}, "", nil
+}
+
+func (s *pushCertStore) StorePushCert(context.Context, []byte, []byte) error {
+	return nil
+}
+
+func TestCheck(t *testing.T) {
+	store := &pushCertStore{topic: "com.apple.mgmt.good"}
+	r := &mdm.Request{Context: context.Background()}
+
+	s := New(nil, store)
+	if err := s.check(r, mdm.Enrollment{}, "Authenticate", "com.apple.mgmt.bad"); err != nil {
+		t.Errorf("unexpected error when not rejecting: %v", err)
+	}
+
+	s = New(nil, store, WithReject())
+	for i := 0; i < 2; i++ {
+		if err := s.check(r, mdm.Enrollment{}, "TokenUpdate", "com.apple.mgmt.good"); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := s.check(r, mdm.Enrollment{}, "Authenticate", "com.apple.mgmt.bad"); !errors.Is(err, ErrUnknownTopic) {
+		t.Errorf("have %v, want %v", err, ErrUnknownTopic)
+	}
+	// one retrieval for the first (non-rejecting) check, one for the
+	// cached good topic, one for the bad topic
+	if store.retrieved != 3 {
+		t.Errorf("retrievals: have %d, want 3", store.retrieved)
+	}
+}
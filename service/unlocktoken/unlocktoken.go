@@ -0,0 +1,117 @@
+// Package unlocktoken escrows device Unlock Tokens from TokenUpdate
+// check-ins.
+package unlocktoken
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/jessepeterson/nanomdm/cryptoutil"
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/service"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// Escrow seals (encrypts) and stores Unlock Tokens.
+type Escrow struct {
+	store storage.UnlockTokenStore
+	key   []byte
+}
+
+// NewEscrow creates a new Unlock Token escrow. Tokens are sealed with
+// the 32 byte key.
+func NewEscrow(store storage.UnlockTokenStore, key []byte) *Escrow {
+	return &Escrow{store: store, key: key}
+}
+
+// Escrow seals and stores the Unlock Token of enrollment id. The token
+// is bound to id so sealed tokens can not be swapped between
+// enrollments.
+func (e *Escrow) Escrow(ctx context.Context, id string, token []byte) error {
+	sealed, err := cryptoutil.Seal(e.key, token, []byte(id))
+	if err != nil {
+		return err
+	}
+	return e.store.StoreUnlockToken(ctx, id, sealed)
+}
+
+// Retrieve retrieves and opens the Unlock Token of enrollment id. A nil
+// token is returned if none is escrowed.
+func (e *Escrow) Retrieve(ctx context.Context, id string) ([]byte, error) {
+	sealed, err := e.store.RetrieveUnlockToken(ctx, id)
+	if err != nil || sealed == nil {
+		return nil, err
+	}
+	return cryptoutil.Open(e.key, sealed, []byte(id))
+}
+
+// UnlockToken is a service middleware that escrows the Unlock Tokens of
+// device channel TokenUpdate check-ins. The token is removed from the
+// check-in before it is passed to the next service so that it is never
+// stored (or sent to webhooks) in the clear. The token is escrowed
+// after the next service resolves the enrollment ID.
+type UnlockToken struct {
+	next   service.CheckinAndCommandService
+	escrow *Escrow
+	logger log.Logger
+}
+
+type Option func(*UnlockToken)
+
+func WithLogger(logger log.Logger) Option {
+	return func(s *UnlockToken) {
+		s.logger = logger
+	}
+}
+
+// New creates a new Unlock Token escrow service middleware.
+func New(next service.CheckinAndCommandService, escrow *Escrow, opts ...Option) *UnlockToken {
+	s := &UnlockToken{next: next, escrow: escrow, logger: log.NopLogger}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+var unlockTokenRe = regexp.MustCompile(`(?s)\s*<key>UnlockToken</key>\s*<data>.*?</data>`)
+
+// redact removes the UnlockToken key and value from a raw (XML)
+// check-in plist.
+func redact(raw []byte) []byte {
+	return unlockTokenRe.ReplaceAll(raw, nil)
+}
+
+func (s *UnlockToken) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	return s.next.Authenticate(r, m)
+}
+
+func (s *UnlockToken) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	token := m.UnlockToken
+	if len(token) < 1 {
+		return s.next.TokenUpdate(r, m)
+	}
+	m.UnlockToken = nil
+	m.Raw = redact(m.Raw)
+	if err := s.next.TokenUpdate(r, m); err != nil {
+		return err
+	}
+	if r.EnrollID == nil || r.ParentID != "" {
+		// Unlock Tokens are only sent on device channels
+		s.logger.Info("msg", "Unlock Token on user channel not escrowed")
+		return nil
+	}
+	if err := s.escrow.Escrow(r.Context, r.ID, token); err != nil {
+		return err
+	}
+	s.logger.Info("msg", "escrowed unlock token", "id", r.ID)
+	return nil
+}
+
+func (s *UnlockToken) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	return s.next.CheckOut(r, m)
+}
+
+func (s *UnlockToken) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	return s.next.CommandAndReportResults(r, results)
+}
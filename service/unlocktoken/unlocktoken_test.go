@@ -0,0 +1,108 @@
+package unlocktoken
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/service"
+	"github.com/jessepeterson/nanomdm/storage/file"
+)
+
+// recordingService records the TokenUpdate check-in it is passed.
+type recordingService struct {
+	service.CheckinAndCommandService
+	tokenUpdate *mdm.TokenUpdate
+}
+
+func (s *recordingService) TokenUpdate(_ *mdm.Request, m *mdm.TokenUpdate) error {
+	s.tokenUpdate = m
+	return nil
+}
+
+func TestEscrow(t *testing.T) {
+	ctx := context.Background()
+	store, err := file.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	escrow := NewEscrow(store, bytes.Repeat([]byte{1}, 32))
+
+	token, err := escrow.Retrieve(ctx, "A")
+	if err != nil || token != nil {
+		t.Fatalf("not escrowed: have %v (%v), want nil", token, err)
+	}
+
+	if err = escrow.Escrow(ctx, "A", []byte("token")); err != nil {
+		t.Fatal(err)
+	}
+	if token, err = escrow.Retrieve(ctx, "A"); err != nil {
+		t.Fatal(err)
+	} else if string(token) != "token" {
+		t.Errorf("token: have %q, want %q", token, "token")
+	}
+	sealed, err := store.RetrieveUnlockToken(ctx, "A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("token")) {
+		t.Error("unlock token stored in the clear")
+	}
+
+	// sealed tokens are bound to their enrollment
+	if err = store.StoreUnlockToken(ctx, "B", sealed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = escrow.Retrieve(ctx, "B"); err == nil {
+		t.Error("expected error opening token of another enrollment")
+	}
+	if _, err = NewEscrow(store, bytes.Repeat([]byte{2}, 32)).Retrieve(ctx, "A"); err == nil {
+		t.Error("expected error opening token with another key")
+	}
+}
+
+func TestUnlockToken(t *testing.T) {
+	ctx := context.Background()
+	store, err := file.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	escrow := NewEscrow(store, bytes.Repeat([]byte{1}, 32))
+	next := new(recordingService)
+	s := New(next, escrow)
+
+	raw := []byte(`<plist version="1.0">
+<dict>
+	<key>MessageType</key>
+	<string>TokenUpdate</string>
+	<key>UnlockToken</key>
+	<data>dG9rZW4=</data>
+</dict>
+</plist>`)
+	for _, test := range []struct {
+		id, parentID string
+		escrowed     bool
+	}{
+		{"A", "", true},
+		{"A:U", "A", false},
+	} {
+		r := &mdm.Request{Context: ctx, EnrollID: &mdm.EnrollID{ID: test.id, ParentID: test.parentID}}
+		m := &mdm.TokenUpdate{UnlockToken: []byte("token"), Raw: raw}
+		if err = s.TokenUpdate(r, m); err != nil {
+			t.Fatal(err)
+		}
+		if next.tokenUpdate.UnlockToken != nil || bytes.Contains(next.tokenUpdate.Raw, []byte("UnlockToken")) {
+			t.Errorf("%s: unlock token passed to next service", test.id)
+		}
+		token, err := escrow.Retrieve(ctx, test.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have := token != nil; have != test.escrowed {
+			t.Errorf("%s: escrowed: have %v, want %v", test.id, have, test.escrowed)
+		}
+	}
+}
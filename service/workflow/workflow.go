@@ -0,0 +1,490 @@
+// Package workflow is a NanoMDM service that runs workflows: named
+// sequences of commands sent to enrollments one step at a time.
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/groob/plist"
+	"github.com/jessepeterson/nanomdm/cmdplist"
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/push"
+	"github.com/jessepeterson/nanomdm/service/compliance"
+	"github.com/jessepeterson/nanomdm/service/microwebhook"
+	"github.com/jessepeterson/nanomdm/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// States of workflow runs.
+const (
+	StateRunning   = "running"
+	StateCompleted = "completed"
+	StateFailed    = "failed"
+)
+
+// DefaultTimeout is the default time a workflow run may take before it
+// fails.
+const DefaultTimeout = 24 * time.Hour
+
+var (
+	ErrRunning         = errors.New("workflow running")
+	ErrUnknownWorkflow = errors.New("unknown workflow")
+)
+
+// Step is a command of a workflow. Command is a command name from the
+// cmdplist catalog, Args its arguments, and Files the paths of its
+// data arguments (e.g. the Payload of InstallProfile) relative to the
+// workflows file.
+//
+// A step succeeds when its command is acknowledged and the results
+// match all Verify conditions (see compliance.Condition). Failed steps
+// are enqueued again up to Retries times and then fail the run unless
+// ContinueOnError is set.
+type Step struct {
+	Name            string                 `yaml:"name"`
+	Command         string                 `yaml:"command"`
+	Args            map[string]string      `yaml:"args"`
+	Files           map[string]string      `yaml:"files"`
+	Verify          []compliance.Condition `yaml:"verify"`
+	Retries         int                    `yaml:"retries"`
+	ContinueOnError bool                   `yaml:"continue_on_error"`
+
+	data map[string][]byte
+}
+
+// Workflow is a named sequence of steps. Each step is only enqueued
+// once the previous step succeeded (or failed with ContinueOnError).
+type Workflow struct {
+	Name  string `yaml:"name"`
+	Steps []Step `yaml:"steps"`
+	// Timeout fails runs not completed within the duration. Defaults
+	// to DefaultTimeout.
+	Timeout time.Duration `yaml:"timeout"`
+	// Topic is the webhook event topic of completed and failed runs.
+	// Defaults to "mdm.Workflow".
+	Topic string `yaml:"topic"`
+}
+
+// validate checks, reads the files of, and fills in defaults of
+// workflow w. Relative file paths are relative to dir.
+func (w *Workflow) validate(dir string) error {
+	if w.Name == "" {
+		return errors.New("missing workflow name")
+	}
+	if len(w.Steps) < 1 {
+		return fmt.Errorf("workflow %s: no steps", w.Name)
+	}
+	if w.Timeout <= 0 {
+		w.Timeout = DefaultTimeout
+	}
+	if w.Topic == "" {
+		w.Topic = "mdm.Workflow"
+	}
+	for i := range w.Steps {
+		step := &w.Steps[i]
+		if step.Name == "" {
+			step.Name = fmt.Sprintf("%d", i+1)
+		}
+		spec := cmdplist.Lookup(step.Command)
+		if spec == nil {
+			return fmt.Errorf("workflow %s step %s: unknown command: %s", w.Name, step.Name, step.Command)
+		}
+		step.data = make(map[string][]byte)
+		for key, path := range step.Files {
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("workflow %s step %s: %w", w.Name, step.Name, err)
+			}
+			step.data[key] = b
+		}
+		if _, err := spec.Build(step.Args, step.data); err != nil {
+			return fmt.Errorf("workflow %s step %s: %w", w.Name, step.Name, err)
+		}
+		for j := range step.Verify {
+			if err := step.Verify[j].Compile(); err != nil {
+				return fmt.Errorf("workflow %s step %s: %w", w.Name, step.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// LoadWorkflows reads and validates YAML workflows from path. The file
+// contains a top-level "workflows" list.
+func LoadWorkflows(path string) ([]*Workflow, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		Workflows []*Workflow `yaml:"workflows"`
+	}
+	if err := yaml.Unmarshal(b, &config); err != nil {
+		return nil, fmt.Errorf("parsing workflows: %w", err)
+	}
+	names := make(map[string]bool)
+	for _, w := range config.Workflows {
+		if err := w.validate(filepath.Dir(path)); err != nil {
+			return nil, err
+		}
+		if names[w.Name] {
+			return nil, fmt.Errorf("duplicate workflow: %s", w.Name)
+		}
+		names[w.Name] = true
+	}
+	return config.Workflows, nil
+}
+
+// Store is the storage required by the workflow service.
+type Store interface {
+	storage.WorkflowStore
+	storage.CommandEnqueuer
+}
+
+// Workflows is a service that runs workflows. Started runs enqueue
+// (and push) the command of their first step. As the results of each
+// step's command are reported the run moves on to the next step,
+// retries the step, or fails. Completed and failed runs are reported
+// as webhook events. Runs exceeding their workflow's timeout are
+// failed with Run.
+//
+// It is intended to run alongside the core NanoMDM service (i.e. with
+// the multi service) so that enrollment IDs are resolved.
+type Workflows struct {
+	store     Store
+	workflows map[string]*Workflow
+	pusher    push.Pusher
+	webhook   *microwebhook.MicroWebhook
+	logger    log.Logger
+}
+
+type Option func(*Workflows)
+
+func WithLogger(logger log.Logger) Option {
+	return func(s *Workflows) {
+		s.logger = logger
+	}
+}
+
+// WithPusher sends APNs pushes after enqueuing step commands.
+func WithPusher(pusher push.Pusher) Option {
+	return func(s *Workflows) {
+		s.pusher = pusher
+	}
+}
+
+// WithWebhook sends webhook events for completed and failed runs to url.
+func WithWebhook(url string, opts ...microwebhook.Option) Option {
+	return func(s *Workflows) {
+		s.webhook = microwebhook.New(url, opts...)
+	}
+}
+
+// New creates a new workflow service running workflows.
+func New(workflows []*Workflow, store Store, opts ...Option) *Workflows {
+	s := &Workflows{
+		store:     store,
+		workflows: make(map[string]*Workflow),
+		logger:    log.NopLogger,
+	}
+	for _, w := range workflows {
+		s.workflows[w.Name] = w
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Names returns the sorted names of the workflows.
+func (s *Workflows) Names() []string {
+	var names []string
+	for name := range s.workflows {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// List retrieves the workflow runs of ids.
+func (s *Workflows) List(ctx context.Context, ids []string) ([]*storage.WorkflowRun, error) {
+	return s.store.RetrieveWorkflowRuns(ctx, ids)
+}
+
+// inProgress reports whether run is running and not timed out.
+func (s *Workflows) inProgress(run *storage.WorkflowRun) bool {
+	w := s.workflows[run.Workflow]
+	return run.State == StateRunning && w != nil && time.Since(run.StartedAt) < w.Timeout
+}
+
+// enqueue enqueues the command of the current step of run.
+func (s *Workflows) enqueue(ctx context.Context, w *Workflow, run *storage.WorkflowRun) error {
+	step := &w.Steps[run.Step]
+	cmd, err := cmdplist.Lookup(step.Command).Build(step.Args, step.data)
+	if err != nil {
+		return err
+	}
+	mdmCmd, err := cmd.MDMCommand()
+	if err != nil {
+		return err
+	}
+	idErrs, err := s.store.EnqueueCommand(ctx, []string{run.ID}, mdmCmd)
+	if err != nil {
+		return err
+	} else if idErrs[run.ID] != nil {
+		return idErrs[run.ID]
+	}
+	run.CommandUUID = mdmCmd.CommandUUID
+	run.Attempts++
+	s.logger.Info(
+		"msg", "enqueued workflow step",
+		"id", run.ID,
+		"workflow", w.Name,
+		"step", step.Name,
+		"attempt", run.Attempts,
+		"command_uuid", run.CommandUUID,
+	)
+	return nil
+}
+
+// push pushes to ids logging errors.
+func (s *Workflows) push(ctx context.Context, ids []string) {
+	if len(ids) < 1 || s.pusher == nil {
+		return
+	}
+	if _, err := s.pusher.Push(ctx, ids); err != nil {
+		s.logger.Info("msg", "push", "err", err)
+	}
+}
+
+// Start starts runs of workflow name for ids by enqueuing (and
+// pushing) the command of the first step. Runs replace earlier
+// completed or failed runs of the workflow. It returns the command
+// UUIDs and errors by enrollment ID.
+func (s *Workflows) Start(ctx context.Context, name string, ids []string) (map[string]string, map[string]error, error) {
+	w := s.workflows[name]
+	if w == nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnknownWorkflow, name)
+	}
+	runs, err := s.store.RetrieveWorkflowRuns(ctx, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	running := make(map[string]bool)
+	for _, run := range runs {
+		if run.Workflow == name && s.inProgress(run) {
+			running[run.ID] = true
+		}
+	}
+	uuids := make(map[string]string)
+	idErrs := make(map[string]error)
+	var sent []string
+	for _, id := range ids {
+		if running[id] {
+			idErrs[id] = ErrRunning
+			continue
+		}
+		now := time.Now()
+		run := &storage.WorkflowRun{
+			ID:        id,
+			Workflow:  name,
+			State:     StateRunning,
+			StartedAt: now,
+			UpdatedAt: now,
+		}
+		if err = s.enqueue(ctx, w, run); err != nil {
+			idErrs[id] = err
+			continue
+		}
+		if err = s.store.StoreWorkflowRun(ctx, run); err != nil {
+			return uuids, idErrs, err
+		}
+		uuids[id] = run.CommandUUID
+		sent = append(sent, id)
+	}
+	s.push(ctx, sent)
+	return uuids, idErrs, nil
+}
+
+// verify reports whether results satisfy step.
+func verify(step *Step, results *mdm.CommandResults) (bool, error) {
+	if results.Status != "Acknowledged" {
+		return false, nil
+	}
+	if len(step.Verify) < 1 {
+		return true, nil
+	}
+	var m map[string]interface{}
+	if err := plist.Unmarshal(results.Raw, &m); err != nil {
+		return false, err
+	}
+	for i := range step.Verify {
+		if !step.Verify[i].Match(m) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// stepError describes why the results of step failed it.
+func stepError(step *Step, results *mdm.CommandResults) string {
+	if results.Status == "Acknowledged" {
+		return fmt.Sprintf("step %s: verification failed", step.Name)
+	}
+	msg := fmt.Sprintf("step %s: %s", step.Name, results.Status)
+	if len(results.ErrorChain) > 0 {
+		msg += ": " + results.ErrorChain[0].USEnglishDescription
+	}
+	return msg
+}
+
+// Advance moves run of w forward from the results of its current
+// step's command: to the next step, the same step again (if it failed
+// and has retries left), or to the completed or failed state. It
+// returns true if the (new) current step's command needs enqueuing.
+func Advance(w *Workflow, run *storage.WorkflowRun, succeeded bool, errMsg string) bool {
+	step := &w.Steps[run.Step]
+	if !succeeded {
+		run.Error = errMsg
+		if run.Attempts <= step.Retries {
+			return true
+		}
+		if !step.ContinueOnError {
+			run.State = StateFailed
+			return false
+		}
+	}
+	if run.Step+1 >= len(w.Steps) {
+		run.State = StateCompleted
+		return false
+	}
+	run.Step++
+	run.Attempts = 0
+	return true
+}
+
+// report sends the webhook event of the finished run.
+func (s *Workflows) report(ctx context.Context, w *Workflow, run *storage.WorkflowRun) error {
+	s.logger.Info("msg", "workflow run", "id", run.ID, "workflow", run.Workflow, "state", run.State, "err", run.Error)
+	if s.webhook == nil {
+		return nil
+	}
+	return s.webhook.PostEvent(ctx, &microwebhook.Event{
+		Topic:     w.Topic,
+		CreatedAt: time.Now(),
+		WorkflowEvent: &microwebhook.WorkflowEvent{
+			ID:        run.ID,
+			Workflow:  run.Workflow,
+			State:     run.State,
+			Step:      w.Steps[run.Step].Name,
+			Error:     run.Error,
+			StartedAt: run.StartedAt,
+		},
+	})
+}
+
+// Expire fails runs that did not complete within their workflow's
+// timeout.
+func (s *Workflows) Expire(ctx context.Context) error {
+	runs, err := s.store.RetrieveWorkflowRuns(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, run := range runs {
+		w := s.workflows[run.Workflow]
+		if run.State != StateRunning || w == nil || s.inProgress(run) {
+			continue
+		}
+		run.State = StateFailed
+		run.Error = "timed out"
+		run.UpdatedAt = time.Now()
+		if err = s.store.StoreWorkflowRun(ctx, run); err != nil {
+			return err
+		}
+		if err = s.report(ctx, w, run); err != nil {
+			s.logger.Info("msg", "reporting workflow run", "id", run.ID, "err", err)
+		}
+	}
+	return nil
+}
+
+// Run fails stale runs until ctx is done.
+func (s *Workflows) Run(ctx context.Context) {
+	check := time.Hour
+	for _, w := range s.workflows {
+		if w.Timeout < check {
+			check = w.Timeout
+		}
+	}
+	ticker := time.NewTicker(check)
+	defer ticker.Stop()
+	for {
+		if err := s.Expire(ctx); err != nil {
+			s.logger.Info("msg", "expiring workflow runs", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Workflows) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	return nil
+}
+
+func (s *Workflows) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	return nil
+}
+
+func (s *Workflows) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	return nil
+}
+
+func (s *Workflows) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	if results.Status == "Idle" || results.Status == "NotNow" || r.EnrollID == nil || r.ID == "" {
+		return nil, nil
+	}
+	runs, err := s.store.RetrieveWorkflowRuns(r.Context, []string{r.ID})
+	if err != nil {
+		return nil, err
+	}
+	for _, run := range runs {
+		w := s.workflows[run.Workflow]
+		if run.State != StateRunning || run.CommandUUID != results.CommandUUID || w == nil || run.Step >= len(w.Steps) {
+			continue
+		}
+		succeeded, err := verify(&w.Steps[run.Step], results)
+		if err != nil {
+			return nil, fmt.Errorf("verifying workflow step: %w", err)
+		}
+		if Advance(w, run, succeeded, stepError(&w.Steps[run.Step], results)) {
+			if err = s.enqueue(r.Context, w, run); err != nil {
+				run.State = StateFailed
+				run.Error = fmt.Sprintf("enqueuing step %s: %v", w.Steps[run.Step].Name, err)
+			}
+		}
+		run.UpdatedAt = time.Now()
+		if err = s.store.StoreWorkflowRun(r.Context, run); err != nil {
+			return nil, err
+		}
+		if run.State == StateRunning {
+			s.push(r.Context, []string{r.ID})
+		} else if err = s.report(r.Context, w, run); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+	return nil, nil
+}
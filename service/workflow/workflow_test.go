@@ -0,0 +1,148 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/groob/plist"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/service/compliance"
+	"github.com/jessepeterson/nanomdm/storage"
+	"github.com/jessepeterson/nanomdm/storage/file"
+	"github.com/jessepeterson/nanomdm/storage/storagetest"
+)
+
+func TestLoadExampleWorkflows(t *testing.T) {
+	// the example reads its profile relative to itself
+	dir := t.TempDir()
+	b, err := os.ReadFile(filepath.Join("..", "..", "docs", "workflows.example.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "workflows.yaml")
+	os.WriteFile(path, b, 0644)
+	if _, err = LoadWorkflows(path); err == nil {
+		t.Error("expected error for missing profile")
+	}
+	os.WriteFile(filepath.Join(dir, "wifi.mobileconfig"), []byte("profile"), 0644)
+	workflows, err := LoadWorkflows(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(workflows) != 2 || len(workflows[0].Steps) != 3 {
+		t.Fatalf("unexpected workflows: %v", workflows)
+	}
+	if have, want := string(workflows[0].Steps[0].data["Payload"]), "profile"; have != want {
+		t.Errorf("payload: have %q, want %q", have, want)
+	}
+	if workflows[1].Topic != "mdm.Workflow.Inventory" || workflows[0].Topic != "mdm.Workflow" || workflows[1].Steps[2].Name != "3" {
+		t.Errorf("defaults not applied: %+v", workflows[1])
+	}
+}
+
+func TestAdvance(t *testing.T) {
+	w := &Workflow{Steps: []Step{{Retries: 1}, {ContinueOnError: true}, {}}}
+	run := &storage.WorkflowRun{State: StateRunning, Attempts: 1}
+	for _, test := range []struct {
+		succeeded   bool
+		wantEnqueue bool
+		wantStep    int
+		wantState   string
+	}{
+		{false, true, 0, StateRunning}, // retried
+		{true, true, 1, StateRunning},
+		{false, true, 2, StateRunning}, // continued
+		{false, false, 2, StateFailed},
+	} {
+		if have := Advance(w, run, test.succeeded, "failed"); have != test.wantEnqueue {
+			t.Errorf("enqueue: have %v, want %v", have, test.wantEnqueue)
+		}
+		if have := run.Step; have != test.wantStep {
+			t.Errorf("step: have %d, want %d", have, test.wantStep)
+		}
+		if have := run.State; have != test.wantState {
+			t.Errorf("state: have %q, want %q", have, test.wantState)
+		}
+		if test.wantEnqueue {
+			run.Attempts++
+		}
+	}
+}
+
+func TestWorkflow(t *testing.T) {
+	ctx := context.Background()
+	store, err := file.New(t.TempDir(), file.WithSyncInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	r, err := storagetest.Enroll(ctx, store, "AAAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &Workflow{Name: "verify", Steps: []Step{
+		{Command: "SecurityInfo"},
+		{Command: "ProfileList", Verify: []compliance.Condition{{Key: "ProfileList", Op: "matches", Value: "com.example"}}},
+	}}
+	if err = w.validate(""); err != nil {
+		t.Fatal(err)
+	}
+	s := New([]*Workflow{w}, store)
+	if _, _, err = s.Start(ctx, "other", []string{r.ID}); !errors.Is(err, ErrUnknownWorkflow) {
+		t.Errorf("have %v, want %v", err, ErrUnknownWorkflow)
+	}
+	uuids, idErrs, err := s.Start(ctx, "verify", []string{r.ID})
+	if err != nil || len(idErrs) > 0 {
+		t.Fatal(err, idErrs)
+	}
+	if _, idErrs, _ = s.Start(ctx, "verify", []string{r.ID}); !errors.Is(idErrs[r.ID], ErrRunning) {
+		t.Errorf("have %v, want %v", idErrs[r.ID], ErrRunning)
+	}
+
+	// report the results of the current step and return the run
+	report := func(uuid, status string, raw map[string]interface{}) *storage.WorkflowRun {
+		t.Helper()
+		b, err := plist.Marshal(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = s.CommandAndReportResults(r, &mdm.CommandResults{CommandUUID: uuid, Status: status, Raw: b}); err != nil {
+			t.Fatal(err)
+		}
+		runs, err := s.List(ctx, []string{r.ID})
+		if err != nil || len(runs) != 1 {
+			t.Fatalf("runs: %v (%v)", runs, err)
+		}
+		return runs[0]
+	}
+
+	run := report(uuids[r.ID], "Acknowledged", nil)
+	if run.Step != 1 || run.State != StateRunning || run.CommandUUID == uuids[r.ID] {
+		t.Fatalf("unexpected run after first step: %+v", run)
+	}
+	// unrelated results do not advance the run
+	if have := report("unrelated", "Acknowledged", nil); have.CommandUUID != run.CommandUUID {
+		t.Errorf("unrelated results changed run: %+v", have)
+	}
+	run = report(run.CommandUUID, "Acknowledged", map[string]interface{}{
+		"ProfileList": []interface{}{map[string]interface{}{"PayloadIdentifier": "com.other"}},
+	})
+	if run.State != StateFailed || run.Error == "" {
+		t.Errorf("unverified results: have %+v, want failed", run)
+	}
+
+	// failed runs may be started again
+	if uuids, _, err = s.Start(ctx, "verify", []string{r.ID}); err != nil {
+		t.Fatal(err)
+	}
+	run = report(uuids[r.ID], "Acknowledged", nil)
+	run = report(run.CommandUUID, "Acknowledged", map[string]interface{}{
+		"ProfileList": []interface{}{map[string]interface{}{"PayloadIdentifier": "com.example.wifi"}},
+	})
+	if run.State != StateCompleted || run.Error != "" {
+		t.Errorf("verified results: have %+v, want completed", run)
+	}
+}
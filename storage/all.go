@@ -3,8 +3,33 @@ package storage
 // AllStorage represents all required storage by NanoMDM
 type AllStorage interface {
 	ServiceStore
+	NextCommandsStore
 	PushStore
 	PushCertStore
+	PushCertListStore
 	CommandEnqueuer
 	CertAuthStore
+	EnrollmentLister
+	EnrollmentStatusStore
+	UserChannelLister
+	CommandDeliveryStore
+	QueueStatsStore
+	EnrollmentCountStore
+	CommandCountStore
+	QueuePurgeStore
+	SoftDeleteStore
+	InventoryStore
+	OSUpdateStore
+	AppInstallStore
+	AppManifestStore
+	AppInventoryStore
+	LostModeStore
+	BypassCodeStore
+	UnlockTokenStore
+	CommandCallbackStore
+	DevicePasswordStore
+	IdentityRotationStore
+	GroupStore
+	SmartGroupStore
+	WorkflowStore
 }
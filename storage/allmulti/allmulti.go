@@ -10,8 +10,15 @@ import (
 // It returns results and errors from the first store and simply
 // logs errors, if any, for the remaining.
 type MultiAllStorage struct {
+	// count of result mismatches. first for 64-bit alignment of the
+	// atomic operations.
+	mismatches uint64
+
 	logger log.Logger
 	stores []storage.AllStorage
+
+	// compare read results (see NewDualWrite)
+	compare bool
 }
 
 // New creates a new MultiAllStorage dispatcher.
@@ -0,0 +1,51 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) StoreAppInstall(ctx context.Context, inst *storage.AppInstall) error {
+	finalErr := ms.stores[0].StoreAppInstall(ctx, inst)
+	for n, storage := range ms.stores[1:] {
+		if err := storage.StoreAppInstall(ctx, inst); err != nil {
+			ms.logger.Info("method", "StoreAppInstall", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalErr
+}
+
+func (ms *MultiAllStorage) RetrieveAppInstalls(ctx context.Context, ids []string) ([]*storage.AppInstall, error) {
+	finalList, finalErr := ms.stores[0].RetrieveAppInstalls(ctx, ids)
+	for n, storage := range ms.stores[1:] {
+		if _, err := storage.RetrieveAppInstalls(ctx, ids); err != nil {
+			ms.logger.Info("method", "RetrieveAppInstalls", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalList, finalErr
+}
+
+func (ms *MultiAllStorage) StoreAppManifest(ctx context.Context, name string, manifest []byte) error {
+	finalErr := ms.stores[0].StoreAppManifest(ctx, name, manifest)
+	for n, storage := range ms.stores[1:] {
+		if err := storage.StoreAppManifest(ctx, name, manifest); err != nil {
+			ms.logger.Info("method", "StoreAppManifest", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalErr
+}
+
+func (ms *MultiAllStorage) RetrieveAppManifest(ctx context.Context, name string) ([]byte, error) {
+	finalManifest, finalErr := ms.stores[0].RetrieveAppManifest(ctx, name)
+	for n, storage := range ms.stores[1:] {
+		if _, err := storage.RetrieveAppManifest(ctx, name); err != nil {
+			ms.logger.Info("method", "RetrieveAppManifest", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalManifest, finalErr
+}
@@ -0,0 +1,29 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) StoreAppInventory(ctx context.Context, id string, apps []*storage.InstalledApp) error {
+	finalErr := ms.stores[0].StoreAppInventory(ctx, id, apps)
+	for n, storage := range ms.stores[1:] {
+		if err := storage.StoreAppInventory(ctx, id, apps); err != nil {
+			ms.logger.Info("method", "StoreAppInventory", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalErr
+}
+
+func (ms *MultiAllStorage) RetrieveAppInventory(ctx context.Context, ids []string) ([]*storage.InstalledApp, error) {
+	finalList, finalErr := ms.stores[0].RetrieveAppInventory(ctx, ids)
+	for n, storage := range ms.stores[1:] {
+		if _, err := storage.RetrieveAppInventory(ctx, ids); err != nil {
+			ms.logger.Info("method", "RetrieveAppInventory", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalList, finalErr
+}
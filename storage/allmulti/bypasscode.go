@@ -0,0 +1,27 @@
+package allmulti
+
+import (
+	"context"
+)
+
+func (ms *MultiAllStorage) StoreBypassCode(ctx context.Context, id string, sealedCode []byte) error {
+	finalErr := ms.stores[0].StoreBypassCode(ctx, id, sealedCode)
+	for n, storage := range ms.stores[1:] {
+		if err := storage.StoreBypassCode(ctx, id, sealedCode); err != nil {
+			ms.logger.Info("method", "StoreBypassCode", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalErr
+}
+
+func (ms *MultiAllStorage) RetrieveBypassCode(ctx context.Context, id string) ([]byte, error) {
+	finalCode, finalErr := ms.stores[0].RetrieveBypassCode(ctx, id)
+	for n, storage := range ms.stores[1:] {
+		if _, err := storage.RetrieveBypassCode(ctx, id); err != nil {
+			ms.logger.Info("method", "RetrieveBypassCode", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalCode, finalErr
+}
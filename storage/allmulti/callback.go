@@ -0,0 +1,27 @@
+package allmulti
+
+import (
+	"context"
+)
+
+func (ms *MultiAllStorage) StoreCommandCallback(ctx context.Context, ids []string, commandUUID, url string) error {
+	finalErr := ms.stores[0].StoreCommandCallback(ctx, ids, commandUUID, url)
+	for n, storage := range ms.stores[1:] {
+		if err := storage.StoreCommandCallback(ctx, ids, commandUUID, url); err != nil {
+			ms.logger.Info("method", "StoreCommandCallback", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalErr
+}
+
+func (ms *MultiAllStorage) RetrieveCommandCallback(ctx context.Context, id, commandUUID string) (string, error) {
+	finalURL, finalErr := ms.stores[0].RetrieveCommandCallback(ctx, id, commandUUID)
+	for n, storage := range ms.stores[1:] {
+		if _, err := storage.RetrieveCommandCallback(ctx, id, commandUUID); err != nil {
+			ms.logger.Info("method", "RetrieveCommandCallback", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalURL, finalErr
+}
@@ -5,7 +5,9 @@ import "github.com/jessepeterson/nanomdm/mdm"
 func (ms *MultiAllStorage) HasCertHash(r *mdm.Request, hash string) (bool, error) {
 	hasFinal, finalErr := ms.stores[0].HasCertHash(r, hash)
 	for n, storage := range ms.stores[1:] {
-		if _, err := storage.HasCertHash(r, hash); err != nil {
+		has, err := storage.HasCertHash(r, hash)
+		ms.compareResult("HasCertHash", n+1, finalErr, err, hasFinal, has)
+		if err != nil {
 			ms.logger.Info("method", "HasCertHash", "storage", n+1, "err", err)
 			continue
 		}
@@ -16,7 +18,9 @@ func (ms *MultiAllStorage) HasCertHash(r *mdm.Request, hash string) (bool, error
 func (ms *MultiAllStorage) EnrollmentHasCertHash(r *mdm.Request, hash string) (bool, error) {
 	hasFinal, finalErr := ms.stores[0].EnrollmentHasCertHash(r, hash)
 	for n, storage := range ms.stores[1:] {
-		if _, err := storage.EnrollmentHasCertHash(r, hash); err != nil {
+		has, err := storage.EnrollmentHasCertHash(r, hash)
+		ms.compareResult("EnrollmentHasCertHash", n+1, finalErr, err, hasFinal, has)
+		if err != nil {
 			ms.logger.Info("method", "EnrollmentHasCertHash", "storage", n+1, "err", err)
 			continue
 		}
@@ -27,7 +31,9 @@ func (ms *MultiAllStorage) EnrollmentHasCertHash(r *mdm.Request, hash string) (b
 func (ms *MultiAllStorage) IsCertHashAssociated(r *mdm.Request, hash string) (bool, error) {
 	isAssocFinal, finalErr := ms.stores[0].IsCertHashAssociated(r, hash)
 	for n, storage := range ms.stores[1:] {
-		if _, err := storage.IsCertHashAssociated(r, hash); err != nil {
+		isAssoc, err := storage.IsCertHashAssociated(r, hash)
+		ms.compareResult("IsCertHashAssociated", n+1, finalErr, err, isAssocFinal, isAssoc)
+		if err != nil {
 			ms.logger.Info("method", "IsCertHashAssociated", "storage", n+1, "err", err)
 			continue
 		}
@@ -0,0 +1,123 @@
+package allmulti
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync/atomic"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// NewDualWrite creates a new MultiAllStorage dispatcher for migrating
+// from the primary to the shadow storage backend. Like New it writes
+// to both and returns the results of primary. It also compares the
+// results of reads (such as the next queued command, enrollment state,
+// certificate associations, and push info) and logs and counts
+// mismatches, verifying the shadow before it replaces the primary.
+func NewDualWrite(logger log.Logger, primary, shadow storage.AllStorage) *MultiAllStorage {
+	ms := New(logger, primary, shadow)
+	ms.compare = true
+	return ms
+}
+
+// Mismatches returns the number of results of the other storage
+// backends that differed from those of the first (including errors of
+// only the others) since creation with NewDualWrite.
+func (ms *MultiAllStorage) Mismatches() uint64 {
+	return atomic.LoadUint64(&ms.mismatches)
+}
+
+// compareResult records a mismatch of method if comparison is enabled,
+// the first storage succeeded, and storage n failed (with err) or its
+// result is not equal to the result of the first storage. Results
+// should be comparable summaries (such as command UUIDs) that are
+// printed when logged.
+func (ms *MultiAllStorage) compareResult(method string, n int, finalErr, err error, final, result interface{}) {
+	if !ms.compare || finalErr != nil {
+		return
+	}
+	if err != nil {
+		// the error itself is logged by the caller
+		ms.mismatch(method, n)
+		return
+	}
+	if !reflect.DeepEqual(final, result) {
+		ms.mismatch(method, n, "primary", fmt.Sprint(final), "result", fmt.Sprint(result))
+	}
+}
+
+// mismatch logs and counts a mismatch of method of storage n.
+func (ms *MultiAllStorage) mismatch(method string, n int, logs ...interface{}) {
+	atomic.AddUint64(&ms.mismatches, 1)
+	ms.logger.Info(append([]interface{}{"msg", "storage mismatch", "method", method, "storage", n}, logs...)...)
+}
+
+// commandUUID summarizes cmd for comparison.
+func commandUUID(cmd *mdm.Command) string {
+	if cmd == nil {
+		return ""
+	}
+	return cmd.CommandUUID
+}
+
+// pushTokens summarizes push info for comparison.
+func pushTokens(pushes map[string]*mdm.Push) map[string]string {
+	tokens := make(map[string]string)
+	for id, push := range pushes {
+		if push != nil {
+			tokens[id] = fmt.Sprintf("%s/%x/%s", push.Topic, []byte(push.Token), push.PushMagic)
+		}
+	}
+	return tokens
+}
+
+// compareEnrollments is compareResult for ListEnrollments comparing
+// the enabled enrollments. Only the differing IDs are logged.
+func (ms *MultiAllStorage) compareEnrollments(n int, finalErr, err error, final, enrollments []*storage.Enrollment) {
+	if !ms.compare || finalErr != nil {
+		return
+	}
+	if err != nil {
+		ms.mismatch("ListEnrollments", n)
+		return
+	}
+	enabled := make(map[string]bool)
+	for _, e := range final {
+		if e.Enabled {
+			enabled[e.ID] = true
+		}
+	}
+	var extra []string
+	for _, e := range enrollments {
+		if !e.Enabled {
+			continue
+		}
+		if !enabled[e.ID] {
+			extra = append(extra, e.ID)
+		}
+		delete(enabled, e.ID)
+	}
+	if len(enabled) < 1 && len(extra) < 1 {
+		return
+	}
+	missing := make([]string, 0, len(enabled))
+	for id := range enabled {
+		missing = append(missing, id)
+	}
+	sort.Strings(missing)
+	sort.Strings(extra)
+	ms.mismatch("ListEnrollments", n, "missing", fmt.Sprint(missing), "extra", fmt.Sprint(extra))
+}
+
+// errorIDs summarizes per-enrollment enqueue errors for comparison.
+func errorIDs(errs map[string]error) []string {
+	ids := []string{}
+	for id := range errs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,77 @@
+package allmulti
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage/file"
+)
+
+func TestDualWrite(t *testing.T) {
+	primary, err := file.New(t.TempDir(), file.WithSyncInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+	shadow, err := file.New(t.TempDir(), file.WithSyncInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shadow.Close()
+	b, err := os.ReadFile("../../mdm/testdata/TokenUpdate.1.plist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mdm.DecodeCheckin(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const id = "663b07bb783e9ade1dae4fbb92ea12afc0ce5b69"
+	r := &mdm.Request{
+		EnrollID: &mdm.EnrollID{ID: id, Type: mdm.Device},
+		Context:  context.Background(),
+	}
+	ms := NewDualWrite(log.NopLogger, primary, shadow)
+	if err = ms.StoreTokenUpdate(r, msg.(*mdm.TokenUpdate)); err != nil {
+		t.Fatal(err)
+	}
+	command := func(uuid string) *mdm.Command {
+		return &mdm.Command{CommandUUID: uuid, Raw: []byte(
+			`<?xml version="1.0" encoding="UTF-8"?><plist version="1.0"><dict><key>CommandUUID</key><string>` + uuid +
+				`</string><key>Command</key><dict><key>RequestType</key><string>DeviceInformation</string></dict></dict></plist>`,
+		)}
+	}
+	if _, err = ms.EnqueueCommand(r.Context, []string{id}, command("A")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ms.RetrieveNextCommand(r, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ms.ListEnrollments(r.Context); err != nil {
+		t.Fatal(err)
+	}
+	if n := ms.Mismatches(); n != 0 {
+		t.Fatalf("have %d mismatches, want 0", n)
+	}
+
+	// only enqueue to the primary
+	if _, err = primary.EnqueueCommand(r.Context, []string{id}, command("B")); err != nil {
+		t.Fatal(err)
+	}
+	if err = ms.StoreCommandReport(r, &mdm.CommandResults{CommandUUID: "A", Status: "Acknowledged"}); err != nil {
+		t.Fatal(err)
+	}
+	next, err := ms.RetrieveNextCommand(r, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next == nil || next.CommandUUID != "B" {
+		t.Errorf("unexpected next command: %v", next)
+	}
+	if n := ms.Mismatches(); n != 1 {
+		t.Errorf("have %d mismatches, want 1", n)
+	}
+}
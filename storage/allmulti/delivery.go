@@ -0,0 +1,54 @@
+package allmulti
+
+import (
+	"context"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) RetrieveCommandDeliveries(ctx context.Context, id string) ([]*storage.CommandDelivery, error) {
+	finalList, finalErr := ms.stores[0].RetrieveCommandDeliveries(ctx, id)
+	for n, storage := range ms.stores[1:] {
+		if _, err := storage.RetrieveCommandDeliveries(ctx, id); err != nil {
+			ms.logger.Info("method", "RetrieveCommandDeliveries", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalList, finalErr
+}
+
+func (ms *MultiAllStorage) RetrieveQueueStats(ctx context.Context, ids []string) ([]*storage.QueueStats, error) {
+	finalList, finalErr := ms.stores[0].RetrieveQueueStats(ctx, ids)
+	for n, storage := range ms.stores[1:] {
+		if _, err := storage.RetrieveQueueStats(ctx, ids); err != nil {
+			ms.logger.Info("method", "RetrieveQueueStats", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalList, finalErr
+}
+
+func (ms *MultiAllStorage) CountCommands(ctx context.Context, since time.Time, interval time.Duration) ([]*storage.CommandCounts, error) {
+	finalCounts, finalErr := ms.stores[0].CountCommands(ctx, since, interval)
+	for n, storage := range ms.stores[1:] {
+		if _, err := storage.CountCommands(ctx, since, interval); err != nil {
+			ms.logger.Info("method", "CountCommands", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalCounts, finalErr
+}
+
+// PurgeQueues purges the queues of all storage backends. Only the
+// commands of the first storage backend are archived.
+func (ms *MultiAllStorage) PurgeQueues(ctx context.Context, idleBefore time.Time, archive func(*storage.PurgedCommand) error) (int, error) {
+	finalCount, finalErr := ms.stores[0].PurgeQueues(ctx, idleBefore, archive)
+	for n, storage := range ms.stores[1:] {
+		if _, err := storage.PurgeQueues(ctx, idleBefore, nil); err != nil {
+			ms.logger.Info("method", "PurgeQueues", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalCount, finalErr
+}
@@ -0,0 +1,29 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) StoreDevicePassword(ctx context.Context, pw *storage.DevicePassword) error {
+	finalErr := ms.stores[0].StoreDevicePassword(ctx, pw)
+	for n, storage := range ms.stores[1:] {
+		if err := storage.StoreDevicePassword(ctx, pw); err != nil {
+			ms.logger.Info("method", "StoreDevicePassword", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalErr
+}
+
+func (ms *MultiAllStorage) RetrieveDevicePasswords(ctx context.Context, ids []string, kind string) ([]*storage.DevicePassword, error) {
+	finalList, finalErr := ms.stores[0].RetrieveDevicePasswords(ctx, ids, kind)
+	for n, storage := range ms.stores[1:] {
+		if _, err := storage.RetrieveDevicePasswords(ctx, ids, kind); err != nil {
+			ms.logger.Info("method", "RetrieveDevicePasswords", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalList, finalErr
+}
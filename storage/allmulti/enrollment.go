@@ -0,0 +1,55 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) ListEnrollments(ctx context.Context) ([]*storage.Enrollment, error) {
+	finalList, finalErr := ms.stores[0].ListEnrollments(ctx)
+	for n, storage := range ms.stores[1:] {
+		enrollments, err := storage.ListEnrollments(ctx)
+		ms.compareEnrollments(n+1, finalErr, err, finalList, enrollments)
+		if err != nil {
+			ms.logger.Info("method", "ListEnrollments", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalList, finalErr
+}
+
+func (ms *MultiAllStorage) EnrollmentEnabled(ctx context.Context, id string) (bool, error) {
+	finalEnabled, finalErr := ms.stores[0].EnrollmentEnabled(ctx, id)
+	for n, storage := range ms.stores[1:] {
+		enabled, err := storage.EnrollmentEnabled(ctx, id)
+		ms.compareResult("EnrollmentEnabled", n+1, finalErr, err, finalEnabled, enabled)
+		if err != nil {
+			ms.logger.Info("method", "EnrollmentEnabled", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalEnabled, finalErr
+}
+
+func (ms *MultiAllStorage) ListUserChannelIDs(ctx context.Context, deviceIDs []string) (map[string][]string, error) {
+	finalIDs, finalErr := ms.stores[0].ListUserChannelIDs(ctx, deviceIDs)
+	for n, storage := range ms.stores[1:] {
+		if _, err := storage.ListUserChannelIDs(ctx, deviceIDs); err != nil {
+			ms.logger.Info("method", "ListUserChannelIDs", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalIDs, finalErr
+}
+
+func (ms *MultiAllStorage) CountEnrollments(ctx context.Context) (*storage.EnrollmentCounts, error) {
+	finalCounts, finalErr := ms.stores[0].CountEnrollments(ctx)
+	for n, storage := range ms.stores[1:] {
+		if _, err := storage.CountEnrollments(ctx); err != nil {
+			ms.logger.Info("method", "CountEnrollments", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalCounts, finalErr
+}
@@ -0,0 +1,60 @@
+package allmulti
+
+import (
+	"context"
+)
+
+func (ms *MultiAllStorage) AddGroupMembers(ctx context.Context, name string, ids []string) error {
+	finalErr := ms.stores[0].AddGroupMembers(ctx, name, ids)
+	for n, storage := range ms.stores[1:] {
+		if err := storage.AddGroupMembers(ctx, name, ids); err != nil {
+			ms.logger.Info("method", "AddGroupMembers", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalErr
+}
+
+func (ms *MultiAllStorage) RemoveGroupMembers(ctx context.Context, name string, ids []string) error {
+	finalErr := ms.stores[0].RemoveGroupMembers(ctx, name, ids)
+	for n, storage := range ms.stores[1:] {
+		if err := storage.RemoveGroupMembers(ctx, name, ids); err != nil {
+			ms.logger.Info("method", "RemoveGroupMembers", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalErr
+}
+
+func (ms *MultiAllStorage) DeleteGroup(ctx context.Context, name string) error {
+	finalErr := ms.stores[0].DeleteGroup(ctx, name)
+	for n, storage := range ms.stores[1:] {
+		if err := storage.DeleteGroup(ctx, name); err != nil {
+			ms.logger.Info("method", "DeleteGroup", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalErr
+}
+
+func (ms *MultiAllStorage) RetrieveGroupMembers(ctx context.Context, name string) ([]string, error) {
+	finalIDs, finalErr := ms.stores[0].RetrieveGroupMembers(ctx, name)
+	for n, storage := range ms.stores[1:] {
+		if _, err := storage.RetrieveGroupMembers(ctx, name); err != nil {
+			ms.logger.Info("method", "RetrieveGroupMembers", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalIDs, finalErr
+}
+
+func (ms *MultiAllStorage) ListGroups(ctx context.Context) (map[string]int, error) {
+	finalCounts, finalErr := ms.stores[0].ListGroups(ctx)
+	for n, storage := range ms.stores[1:] {
+		if _, err := storage.ListGroups(ctx); err != nil {
+			ms.logger.Info("method", "ListGroups", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalCounts, finalErr
+}
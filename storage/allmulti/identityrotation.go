@@ -0,0 +1,53 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) StoreIdentityRotation(ctx context.Context, rot *storage.IdentityRotation) error {
+	finalErr := ms.stores[0].StoreIdentityRotation(ctx, rot)
+	for n, storage := range ms.stores[1:] {
+		if err := storage.StoreIdentityRotation(ctx, rot); err != nil {
+			ms.logger.Info("method", "StoreIdentityRotation", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalErr
+}
+
+func (ms *MultiAllStorage) RetrieveIdentityRotations(ctx context.Context, ids []string) ([]*storage.IdentityRotation, error) {
+	finalList, finalErr := ms.stores[0].RetrieveIdentityRotations(ctx, ids)
+	for n, storage := range ms.stores[1:] {
+		if _, err := storage.RetrieveIdentityRotations(ctx, ids); err != nil {
+			ms.logger.Info("method", "RetrieveIdentityRotations", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalList, finalErr
+}
+
+func (ms *MultiAllStorage) UpdateIdentityRotation(ctx context.Context, rot *storage.IdentityRotation, state, hash string) (bool, error) {
+	updatedFinal, finalErr := ms.stores[0].UpdateIdentityRotation(ctx, rot, state, hash)
+	for n, storage := range ms.stores[1:] {
+		if _, err := storage.UpdateIdentityRotation(ctx, rot, state, hash); err != nil {
+			ms.logger.Info("method", "UpdateIdentityRotation", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return updatedFinal, finalErr
+}
+
+func (ms *MultiAllStorage) RetrieveCertHash(ctx context.Context, id string) (string, error) {
+	hashFinal, finalErr := ms.stores[0].RetrieveCertHash(ctx, id)
+	for n, storage := range ms.stores[1:] {
+		hash, err := storage.RetrieveCertHash(ctx, id)
+		ms.compareResult("RetrieveCertHash", n+1, finalErr, err, hashFinal, hash)
+		if err != nil {
+			ms.logger.Info("method", "RetrieveCertHash", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return hashFinal, finalErr
+}
@@ -0,0 +1,29 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) StoreInventory(ctx context.Context, inv *storage.DeviceInventory) error {
+	finalErr := ms.stores[0].StoreInventory(ctx, inv)
+	for n, storage := range ms.stores[1:] {
+		if err := storage.StoreInventory(ctx, inv); err != nil {
+			ms.logger.Info("method", "StoreInventory", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalErr
+}
+
+func (ms *MultiAllStorage) RetrieveInventory(ctx context.Context, ids []string) ([]*storage.DeviceInventory, error) {
+	finalList, finalErr := ms.stores[0].RetrieveInventory(ctx, ids)
+	for n, storage := range ms.stores[1:] {
+		if _, err := storage.RetrieveInventory(ctx, ids); err != nil {
+			ms.logger.Info("method", "RetrieveInventory", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalList, finalErr
+}
@@ -0,0 +1,29 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) StoreLostMode(ctx context.Context, state *storage.LostModeState) error {
+	finalErr := ms.stores[0].StoreLostMode(ctx, state)
+	for n, storage := range ms.stores[1:] {
+		if err := storage.StoreLostMode(ctx, state); err != nil {
+			ms.logger.Info("method", "StoreLostMode", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalErr
+}
+
+func (ms *MultiAllStorage) RetrieveLostMode(ctx context.Context, ids []string) ([]*storage.LostModeState, error) {
+	finalList, finalErr := ms.stores[0].RetrieveLostMode(ctx, ids)
+	for n, storage := range ms.stores[1:] {
+		if _, err := storage.RetrieveLostMode(ctx, ids); err != nil {
+			ms.logger.Info("method", "RetrieveLostMode", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalList, finalErr
+}
@@ -0,0 +1,29 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) StoreOSUpdateState(ctx context.Context, state *storage.OSUpdateState) error {
+	finalErr := ms.stores[0].StoreOSUpdateState(ctx, state)
+	for n, storage := range ms.stores[1:] {
+		if err := storage.StoreOSUpdateState(ctx, state); err != nil {
+			ms.logger.Info("method", "StoreOSUpdateState", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalErr
+}
+
+func (ms *MultiAllStorage) RetrieveOSUpdateStates(ctx context.Context, ids []string, cohort string) ([]*storage.OSUpdateState, error) {
+	finalList, finalErr := ms.stores[0].RetrieveOSUpdateStates(ctx, ids, cohort)
+	for n, storage := range ms.stores[1:] {
+		if _, err := storage.RetrieveOSUpdateStates(ctx, ids, cohort); err != nil {
+			ms.logger.Info("method", "RetrieveOSUpdateStates", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalList, finalErr
+}
@@ -37,3 +37,25 @@ func (ms *MultiAllStorage) StorePushCert(ctx context.Context, pemCert, pemKey []
 	}
 	return finalErr
 }
+
+func (ms *MultiAllStorage) ListPushCerts(ctx context.Context) (map[string][]byte, error) {
+	finalCerts, finalErr := ms.stores[0].ListPushCerts(ctx)
+	for n, storage := range ms.stores[1:] {
+		if _, err := storage.ListPushCerts(ctx); err != nil {
+			ms.logger.Info("method", "ListPushCerts", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalCerts, finalErr
+}
+
+func (ms *MultiAllStorage) DeletePushCert(ctx context.Context, topic string) error {
+	finalErr := ms.stores[0].DeletePushCert(ctx, topic)
+	for n, storage := range ms.stores[1:] {
+		if err := storage.DeletePushCert(ctx, topic); err != nil {
+			ms.logger.Info("method", "DeletePushCert", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalErr
+}
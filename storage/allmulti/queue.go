@@ -2,14 +2,16 @@ package allmulti
 
 import (
 	"context"
+	"errors"
 
 	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
 )
 
 func (ms *MultiAllStorage) StoreCommandReport(r *mdm.Request, report *mdm.CommandResults) error {
 	finalErr := ms.stores[0].StoreCommandReport(r, report)
-	for n, storage := range ms.stores[1:] {
-		if err := storage.StoreCommandReport(r, report); err != nil {
+	for n, store := range ms.stores[1:] {
+		if err := store.StoreCommandReport(r, report); err != nil && !errors.Is(err, storage.ErrDuplicateReport) {
 			ms.logger.Info("method", "StoreCommandReport", "storage", n+1, "err", err)
 			continue
 		}
@@ -20,7 +22,9 @@ func (ms *MultiAllStorage) StoreCommandReport(r *mdm.Request, report *mdm.Comman
 func (ms *MultiAllStorage) RetrieveNextCommand(r *mdm.Request, skipNotNow bool) (*mdm.Command, error) {
 	skipFinal, finalErr := ms.stores[0].RetrieveNextCommand(r, skipNotNow)
 	for n, storage := range ms.stores[1:] {
-		if _, err := storage.RetrieveNextCommand(r, skipNotNow); err != nil {
+		cmd, err := storage.RetrieveNextCommand(r, skipNotNow)
+		ms.compareResult("RetrieveNextCommand", n+1, finalErr, err, commandUUID(skipFinal), commandUUID(cmd))
+		if err != nil {
 			ms.logger.Info("method", "RetrieveNextCommand", "storage", n+1, "err", err)
 			continue
 		}
@@ -28,6 +32,55 @@ func (ms *MultiAllStorage) RetrieveNextCommand(r *mdm.Request, skipNotNow bool)
 	return skipFinal, finalErr
 }
 
+// StoreCommandReportAndRetrieveNext stores the command report and
+// retrieves the next command from each store. Stores that support it
+// do so in one transaction.
+func (ms *MultiAllStorage) StoreCommandReportAndRetrieveNext(r *mdm.Request, report *mdm.CommandResults, skipNotNow bool) (*mdm.Command, error) {
+	finalCmd, finalErr := storeCommandReportAndRetrieveNext(ms.stores[0], r, report, skipNotNow)
+	for n, store := range ms.stores[1:] {
+		cmd, err := storeCommandReportAndRetrieveNext(store, r, report, skipNotNow)
+		if errors.Is(err, storage.ErrDuplicateReport) {
+			err = nil
+		}
+		ms.compareResult("StoreCommandReportAndRetrieveNext", n+1, finalErr, err, commandUUID(finalCmd), commandUUID(cmd))
+		if err != nil {
+			ms.logger.Info("method", "StoreCommandReportAndRetrieveNext", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalCmd, finalErr
+}
+
+func storeCommandReportAndRetrieveNext(store storage.ServiceStore, r *mdm.Request, report *mdm.CommandResults, skipNotNow bool) (*mdm.Command, error) {
+	if txStore, ok := store.(storage.CommandReportAndNextStore); ok {
+		return txStore.StoreCommandReportAndRetrieveNext(r, report, skipNotNow)
+	}
+	dupErr := store.StoreCommandReport(r, report)
+	if dupErr != nil && !errors.Is(dupErr, storage.ErrDuplicateReport) {
+		return nil, dupErr
+	}
+	cmd, err := store.RetrieveNextCommand(r, skipNotNow)
+	if err != nil {
+		return nil, err
+	}
+	return cmd, dupErr
+}
+
+func (ms *MultiAllStorage) RetrieveNextCommands(r *mdm.Request, skipNotNow bool, limit int) ([]*mdm.Command, error) {
+	return ms.stores[0].RetrieveNextCommands(r, skipNotNow, limit)
+}
+
+func (ms *MultiAllStorage) StoreCommandDelivered(r *mdm.Request, uuid string) error {
+	finalErr := ms.stores[0].StoreCommandDelivered(r, uuid)
+	for n, store := range ms.stores[1:] {
+		if err := store.StoreCommandDelivered(r, uuid); err != nil {
+			ms.logger.Info("method", "StoreCommandDelivered", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalErr
+}
+
 func (ms *MultiAllStorage) ClearQueue(r *mdm.Request) error {
 	finalErr := ms.stores[0].ClearQueue(r)
 	for n, storage := range ms.stores[1:] {
@@ -42,7 +95,9 @@ func (ms *MultiAllStorage) ClearQueue(r *mdm.Request) error {
 func (ms *MultiAllStorage) EnqueueCommand(ctx context.Context, id []string, cmd *mdm.Command) (map[string]error, error) {
 	finalMap, finalErr := ms.stores[0].EnqueueCommand(ctx, id, cmd)
 	for n, storage := range ms.stores[1:] {
-		if _, err := storage.EnqueueCommand(ctx, id, cmd); err != nil {
+		errs, err := storage.EnqueueCommand(ctx, id, cmd)
+		ms.compareResult("EnqueueCommand", n+1, finalErr, err, errorIDs(finalMap), errorIDs(errs))
+		if err != nil {
 			ms.logger.Info("method", "EnqueueCommand", "storage", n+1, "err", err)
 			continue
 		}
@@ -0,0 +1,40 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) StoreSmartGroup(ctx context.Context, g *storage.SmartGroup) error {
+	finalErr := ms.stores[0].StoreSmartGroup(ctx, g)
+	for n, storage := range ms.stores[1:] {
+		if err := storage.StoreSmartGroup(ctx, g); err != nil {
+			ms.logger.Info("method", "StoreSmartGroup", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalErr
+}
+
+func (ms *MultiAllStorage) RetrieveSmartGroups(ctx context.Context, names []string) ([]*storage.SmartGroup, error) {
+	finalGroups, finalErr := ms.stores[0].RetrieveSmartGroups(ctx, names)
+	for n, storage := range ms.stores[1:] {
+		if _, err := storage.RetrieveSmartGroups(ctx, names); err != nil {
+			ms.logger.Info("method", "RetrieveSmartGroups", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalGroups, finalErr
+}
+
+func (ms *MultiAllStorage) DeleteSmartGroup(ctx context.Context, name string) error {
+	finalErr := ms.stores[0].DeleteSmartGroup(ctx, name)
+	for n, storage := range ms.stores[1:] {
+		if err := storage.DeleteSmartGroup(ctx, name); err != nil {
+			ms.logger.Info("method", "DeleteSmartGroup", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalErr
+}
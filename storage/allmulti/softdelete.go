@@ -0,0 +1,41 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) SoftDelete(r *mdm.Request) error {
+	finalErr := ms.stores[0].SoftDelete(r)
+	for n, storage := range ms.stores[1:] {
+		if err := storage.SoftDelete(r); err != nil {
+			ms.logger.Info("method", "SoftDelete", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalErr
+}
+
+func (ms *MultiAllStorage) RetrieveDeletedEnrollments(ctx context.Context, ids []string) ([]*storage.DeletedEnrollment, error) {
+	finalList, finalErr := ms.stores[0].RetrieveDeletedEnrollments(ctx, ids)
+	for n, storage := range ms.stores[1:] {
+		if _, err := storage.RetrieveDeletedEnrollments(ctx, ids); err != nil {
+			ms.logger.Info("method", "RetrieveDeletedEnrollments", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalList, finalErr
+}
+
+func (ms *MultiAllStorage) RestoreEnrollment(ctx context.Context, id string) (int, error) {
+	finalCount, finalErr := ms.stores[0].RestoreEnrollment(ctx, id)
+	for n, storage := range ms.stores[1:] {
+		if _, err := storage.RestoreEnrollment(ctx, id); err != nil {
+			ms.logger.Info("method", "RestoreEnrollment", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalCount, finalErr
+}
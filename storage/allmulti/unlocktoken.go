@@ -0,0 +1,27 @@
+package allmulti
+
+import (
+	"context"
+)
+
+func (ms *MultiAllStorage) StoreUnlockToken(ctx context.Context, id string, sealedToken []byte) error {
+	finalErr := ms.stores[0].StoreUnlockToken(ctx, id, sealedToken)
+	for n, storage := range ms.stores[1:] {
+		if err := storage.StoreUnlockToken(ctx, id, sealedToken); err != nil {
+			ms.logger.Info("method", "StoreUnlockToken", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalErr
+}
+
+func (ms *MultiAllStorage) RetrieveUnlockToken(ctx context.Context, id string) ([]byte, error) {
+	finalToken, finalErr := ms.stores[0].RetrieveUnlockToken(ctx, id)
+	for n, storage := range ms.stores[1:] {
+		if _, err := storage.RetrieveUnlockToken(ctx, id); err != nil {
+			ms.logger.Info("method", "RetrieveUnlockToken", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalToken, finalErr
+}
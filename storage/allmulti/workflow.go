@@ -0,0 +1,29 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) StoreWorkflowRun(ctx context.Context, run *storage.WorkflowRun) error {
+	finalErr := ms.stores[0].StoreWorkflowRun(ctx, run)
+	for n, storage := range ms.stores[1:] {
+		if err := storage.StoreWorkflowRun(ctx, run); err != nil {
+			ms.logger.Info("method", "StoreWorkflowRun", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalErr
+}
+
+func (ms *MultiAllStorage) RetrieveWorkflowRuns(ctx context.Context, ids []string) ([]*storage.WorkflowRun, error) {
+	finalList, finalErr := ms.stores[0].RetrieveWorkflowRuns(ctx, ids)
+	for n, storage := range ms.stores[1:] {
+		if _, err := storage.RetrieveWorkflowRuns(ctx, ids); err != nil {
+			ms.logger.Info("method", "RetrieveWorkflowRuns", "storage", n+1, "err", err)
+			continue
+		}
+	}
+	return finalList, finalErr
+}
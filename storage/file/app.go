@@ -0,0 +1,106 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+const (
+	AppInstallsFilename = "AppInstalls.json"
+
+	// manifests are stored in the top-level directory (alongside push
+	// certificates) with this file extension.
+	appManifestExt = ".manifest.plist"
+)
+
+func (e *enrollment) readAppInstalls() ([]*storage.AppInstall, error) {
+	b, err := e.readFile(AppInstallsFilename)
+	if err != nil {
+		return nil, err
+	}
+	var insts []*storage.AppInstall
+	return insts, json.Unmarshal(b, &insts)
+}
+
+// StoreAppInstall replaces (or adds) inst in the enrollment's app installs file.
+func (s *FileStorage) StoreAppInstall(_ context.Context, inst *storage.AppInstall) error {
+	e := s.newEnrollment(inst.ID)
+	insts, err := e.readAppInstalls()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	found := false
+	for i, existing := range insts {
+		if existing.CommandUUID == inst.CommandUUID {
+			insts[i] = inst
+			found = true
+			break
+		}
+	}
+	if !found {
+		insts = append(insts, inst)
+	}
+	b, err := json.Marshal(insts)
+	if err != nil {
+		return err
+	}
+	return e.writeFile(AppInstallsFilename, b)
+}
+
+// RetrieveAppInstalls reads the app installs files of ids (or all enrollments).
+func (s *FileStorage) RetrieveAppInstalls(_ context.Context, ids []string) ([]*storage.AppInstall, error) {
+	if len(ids) < 1 {
+		var err error
+		if ids, err = s.enrollmentIDs(); err != nil {
+			return nil, err
+		}
+	}
+	var insts []*storage.AppInstall
+	for _, id := range ids {
+		idInsts, err := s.newEnrollment(id).readAppInstalls()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		insts = append(insts, idInsts...)
+	}
+	sort.SliceStable(insts, func(i, j int) bool { return insts[i].ID < insts[j].ID })
+	return insts, nil
+}
+
+func (s *FileStorage) appManifestPath(name string) (string, error) {
+	if err := checkName("manifest name", name); err != nil {
+		return "", err
+	}
+	return path.Join(s.path, name+appManifestExt), nil
+}
+
+// StoreAppManifest writes the named manifest file.
+func (s *FileStorage) StoreAppManifest(_ context.Context, name string, manifest []byte) error {
+	p, err := s.appManifestPath(name)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, manifest, 0644)
+}
+
+// RetrieveAppManifest reads the named manifest file.
+func (s *FileStorage) RetrieveAppManifest(_ context.Context, name string) ([]byte, error) {
+	p, err := s.appManifestPath(name)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return b, err
+}
@@ -0,0 +1,48 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+const AppInventoryFilename = "AppInventory.json"
+
+// StoreAppInventory writes the enrollment's app inventory file.
+func (s *FileStorage) StoreAppInventory(_ context.Context, id string, apps []*storage.InstalledApp) error {
+	b, err := json.Marshal(apps)
+	if err != nil {
+		return err
+	}
+	return s.newEnrollment(id).writeFile(AppInventoryFilename, b)
+}
+
+// RetrieveAppInventory reads the app inventory files of ids (or all enrollments).
+func (s *FileStorage) RetrieveAppInventory(_ context.Context, ids []string) ([]*storage.InstalledApp, error) {
+	if len(ids) < 1 {
+		var err error
+		if ids, err = s.enrollmentIDs(); err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(ids)
+	var apps []*storage.InstalledApp
+	for _, id := range ids {
+		b, err := s.newEnrollment(id).readFile(AppInventoryFilename)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		var idApps []*storage.InstalledApp
+		if err = json.Unmarshal(b, &idApps); err != nil {
+			return nil, err
+		}
+		apps = append(apps, idApps...)
+	}
+	return apps, nil
+}
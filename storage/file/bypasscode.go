@@ -0,0 +1,23 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"os"
+)
+
+const BypassCodeFilename = "BypassCode.sealed"
+
+// StoreBypassCode writes the enrollment's sealed bypass code file.
+func (s *FileStorage) StoreBypassCode(_ context.Context, id string, sealedCode []byte) error {
+	return s.newEnrollment(id).writeFile(BypassCodeFilename, sealedCode)
+}
+
+// RetrieveBypassCode reads the enrollment's sealed bypass code file.
+func (s *FileStorage) RetrieveBypassCode(_ context.Context, id string) ([]byte, error) {
+	b, err := s.newEnrollment(id).readFile(BypassCodeFilename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return b, err
+}
@@ -0,0 +1,54 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+)
+
+// Command callback URLs are stored as a file per command in this
+// directory under the enrollment's directory so that they are purged
+// with the enrollment's queue.
+const CallbackPathname = "QueueCallback"
+
+// callbackFilename returns the callback URL file of command uuid.
+func (e *enrollment) callbackFilename(uuid string) (string, error) {
+	if err := checkName("command UUID", uuid); err != nil {
+		return "", err
+	}
+	return path.Join(e.dirPrefix(CallbackPathname), uuid+".url"), nil
+}
+
+// StoreCommandCallback writes the command's callback URL file of each
+// enrollment in ids.
+func (s *FileStorage) StoreCommandCallback(_ context.Context, ids []string, commandUUID, url string) error {
+	for _, id := range ids {
+		e := s.newEnrollment(id)
+		name, err := e.callbackFilename(commandUUID)
+		if err != nil {
+			return err
+		}
+		if err = os.MkdirAll(e.dirPrefix(CallbackPathname), 0755); err != nil {
+			return err
+		}
+		if err = s.writeFile(name, []byte(url), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RetrieveCommandCallback reads the command's callback URL file of
+// enrollment id.
+func (s *FileStorage) RetrieveCommandCallback(_ context.Context, id, commandUUID string) (string, error) {
+	name, err := s.newEnrollment(id).callbackFilename(commandUUID)
+	if err != nil {
+		return "", err
+	}
+	b, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	return string(b), err
+}
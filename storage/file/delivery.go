@@ -0,0 +1,154 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// The command delivery audit trail is stored as a JSON file per
+// command in this directory under the enrollment's directory.
+const DeliveryPathname = "QueueDelivery"
+
+func (e *enrollment) deliveryFilename(uuid string) string {
+	return path.Join(e.dirPrefix(DeliveryPathname), uuid+".json")
+}
+
+func (e *enrollment) readDelivery(uuid string) (*storage.CommandDelivery, error) {
+	b, err := os.ReadFile(e.deliveryFilename(uuid))
+	if err != nil {
+		return nil, err
+	}
+	d := new(storage.CommandDelivery)
+	return d, json.Unmarshal(b, d)
+}
+
+func (e *enrollment) writeDelivery(d *storage.CommandDelivery) error {
+	if err := os.MkdirAll(e.dirPrefix(DeliveryPathname), 0755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return e.fs.writeFile(e.deliveryFilename(d.CommandUUID), b, 0644)
+}
+
+// updateDelivery reads, modifies with f, and writes the delivery audit
+// trail of command uuid. Commands enqueued before the audit trail
+// existed (or with a corrupt audit trail) are ignored.
+func (e *enrollment) updateDelivery(uuid string, f func(*storage.CommandDelivery)) error {
+	mu := e.fs.enrollmentLock(e.id)
+	mu.Lock()
+	defer mu.Unlock()
+	d, err := e.readDelivery(uuid)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if isCorrupt(err) {
+		// the audit trail is informational so don't fail the command
+		e.fs.logger.Info("msg", "skipping corrupt delivery", "id", e.id, "command_uuid", uuid, "err", err)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	f(d)
+	return e.writeDelivery(d)
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
+
+// RetrieveCommandDeliveries returns the delivery audit trail of commands queued for id.
+func (s *FileStorage) RetrieveCommandDeliveries(_ context.Context, id string) ([]*storage.CommandDelivery, error) {
+	if _, ok := s.index.get(id); !ok {
+		return nil, fmt.Errorf("%w: enrollment %s", storage.ErrNotFound, id)
+	}
+	e := s.newEnrollment(id)
+	entries, err := os.ReadDir(e.dirPrefix(DeliveryPathname))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	var deliveries []*storage.CommandDelivery
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		d, err := e.readDelivery(strings.TrimSuffix(entry.Name(), ".json"))
+		if isCorrupt(err) {
+			s.logger.Info("msg", "skipping corrupt delivery", "id", id, "name", entry.Name(), "err", err)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].EnqueuedAt.Before(deliveries[j].EnqueuedAt)
+	})
+	return deliveries, nil
+}
+
+// RetrieveQueueStats computes the queue statistics of ids (or all
+// enrollments with pending commands) from the delivery audit trails.
+func (s *FileStorage) RetrieveQueueStats(ctx context.Context, ids []string) ([]*storage.QueueStats, error) {
+	all := len(ids) < 1
+	if all {
+		var err error
+		if ids, err = s.enrollmentIDs(); err != nil {
+			return nil, err
+		}
+	}
+	var stats []*storage.QueueStats
+	for _, id := range ids {
+		deliveries, err := s.RetrieveCommandDeliveries(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		st := &storage.QueueStats{ID: id}
+		for _, d := range deliveries {
+			if !d.Active || d.ResolvedAt != nil {
+				continue
+			}
+			st.Pending++
+			if st.OldestEnqueuedAt == nil || d.EnqueuedAt.Before(*st.OldestEnqueuedAt) {
+				st.OldestEnqueuedAt = timePtr(d.EnqueuedAt)
+			}
+		}
+		if all && st.Pending < 1 {
+			continue
+		}
+		stats = append(stats, st)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ID < stats[j].ID })
+	return stats, nil
+}
+
+// CountCommands computes the command counts in buckets of width
+// interval from since until now from the delivery audit trails.
+func (s *FileStorage) CountCommands(ctx context.Context, since time.Time, interval time.Duration) ([]*storage.CommandCounts, error) {
+	ids, err := s.enrollmentIDs()
+	if err != nil {
+		return nil, err
+	}
+	buckets := storage.CommandCountBuckets(since, time.Now(), interval)
+	for _, id := range ids {
+		deliveries, err := s.RetrieveCommandDeliveries(ctx, id)
+		if errors.Is(err, storage.ErrNotFound) {
+			// not (yet) indexed
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		storage.CountDeliveries(buckets, interval, deliveries)
+	}
+	return buckets, nil
+}
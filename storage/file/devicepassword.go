@@ -0,0 +1,73 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+const DevicePasswordsFilename = "DevicePasswords.json"
+
+func (e *enrollment) readDevicePasswords() ([]*storage.DevicePassword, error) {
+	b, err := e.readFile(DevicePasswordsFilename)
+	if err != nil {
+		return nil, err
+	}
+	var pws []*storage.DevicePassword
+	return pws, json.Unmarshal(b, &pws)
+}
+
+// StoreDevicePassword replaces (or adds) pw in the enrollment's device passwords file.
+func (s *FileStorage) StoreDevicePassword(_ context.Context, pw *storage.DevicePassword) error {
+	e := s.newEnrollment(pw.ID)
+	pws, err := e.readDevicePasswords()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	found := false
+	for i, existing := range pws {
+		if existing.Kind == pw.Kind {
+			pws[i] = pw
+			found = true
+			break
+		}
+	}
+	if !found {
+		pws = append(pws, pw)
+	}
+	b, err := json.Marshal(pws)
+	if err != nil {
+		return err
+	}
+	return e.writeFile(DevicePasswordsFilename, b)
+}
+
+// RetrieveDevicePasswords reads the device passwords files of ids (or all enrollments).
+func (s *FileStorage) RetrieveDevicePasswords(_ context.Context, ids []string, kind string) ([]*storage.DevicePassword, error) {
+	if len(ids) < 1 {
+		var err error
+		if ids, err = s.enrollmentIDs(); err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(ids)
+	var pws []*storage.DevicePassword
+	for _, id := range ids {
+		idPws, err := s.newEnrollment(id).readDevicePasswords()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, pw := range idPws {
+			if kind == "" || pw.Kind == kind {
+				pws = append(pws, pw)
+			}
+		}
+	}
+	return pws, nil
+}
@@ -0,0 +1,112 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// ListEnrollments lists the enrollments that have sent a TokenUpdate
+// excluding soft-deleted enrollments that have not re-enrolled.
+func (s *FileStorage) ListEnrollments(_ context.Context) ([]*storage.Enrollment, error) {
+	var enrollments []*storage.Enrollment
+	for _, entry := range s.index.list() {
+		if entry.DeviceID == "" || entry.removed() {
+			continue
+		}
+		enrollments = append(enrollments, &storage.Enrollment{
+			ID:         entry.ID,
+			DeviceID:   entry.DeviceID,
+			Type:       entry.Type,
+			Topic:      entry.Topic,
+			Enabled:    !entry.Disabled,
+			LastSeenAt: entry.LastSeenAt,
+
+			ParentID:      entry.ParentID,
+			UserShortName: entry.UserShortName,
+			UserLongName:  entry.UserLongName,
+
+			HasPushMagic:          entry.PushMagic != "",
+			AwaitingConfiguration: entry.AwaitingConfiguration,
+			NotOnConsole:          entry.NotOnConsole,
+		})
+	}
+	storage.LinkUserChannels(enrollments)
+	return enrollments, nil
+}
+
+// CountEnrollments counts the enrollments that have sent a TokenUpdate
+// from the index.
+func (s *FileStorage) CountEnrollments(_ context.Context) (*storage.EnrollmentCounts, error) {
+	counts := &storage.EnrollmentCounts{ByType: make(map[string]int)}
+	for _, entry := range s.index.list() {
+		if entry.DeviceID == "" {
+			continue
+		}
+		counts.Add(&storage.Enrollment{
+			Type:                  entry.Type,
+			Enabled:               !entry.Disabled,
+			AwaitingConfiguration: entry.AwaitingConfiguration,
+		}, 1)
+	}
+	return counts, nil
+}
+
+// EnrollmentEnabled reports whether enrollment id is enabled.
+func (s *FileStorage) EnrollmentEnabled(_ context.Context, id string) (bool, error) {
+	entry, ok := s.index.get(id)
+	if !ok {
+		return false, storage.ErrNotFound
+	}
+	return !entry.Disabled, nil
+}
+
+// ListUserChannelIDs lists the enabled user channel enrollment IDs of
+// the device channel enrollments deviceIDs.
+func (s *FileStorage) ListUserChannelIDs(_ context.Context, deviceIDs []string) (map[string][]string, error) {
+	devices := make(map[string]bool)
+	for _, id := range deviceIDs {
+		devices[id] = true
+	}
+	ids := make(map[string][]string)
+	for _, entry := range s.index.list() {
+		if devices[entry.ParentID] && !entry.Disabled {
+			ids[entry.ParentID] = append(ids[entry.ParentID], entry.ID)
+		}
+	}
+	return ids, nil
+}
+
+// writeLastSeen records the current time as when the enrollment was
+// last seen in the enrollment index.
+func (e *enrollment) writeLastSeen() error {
+	now := time.Now()
+	return e.fs.index.update(e.id, func(entry *indexEntry) {
+		entry.LastSeenAt = &now
+	})
+}
+
+// readLastSeen returns when the enrollment was last seen (nil if never).
+func (e *enrollment) readLastSeen() (*time.Time, error) {
+	entry, _ := e.fs.index.get(e.id)
+	return entry.LastSeenAt, nil
+}
+
+// readLastSeenFile reads when the enrollment was last seen from the
+// last seen file written before the enrollment index existed.
+func (e *enrollment) readLastSeenFile() (*time.Time, error) {
+	b, err := e.readFile(LastSeenFilename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	t, err := time.Parse(time.RFC3339, string(b))
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
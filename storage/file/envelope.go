@@ -0,0 +1,56 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/jessepeterson/nanomdm/cryptoutil"
+)
+
+// WithEnvelope encrypts the raw plists (check-in messages, commands,
+// and command results), Unlock Tokens, and push certificate private
+// keys at rest using envelope encryption. Files written before
+// encryption was enabled are still read.
+func WithEnvelope(envelope *cryptoutil.Envelope) Option {
+	return func(s *FileStorage) {
+		s.envelope = envelope
+	}
+}
+
+// encryptedFile reports whether the file name is encrypted with an
+// envelope. The base name is used as the additional data as it does
+// not change when commands move between queues.
+func encryptedFile(name string) bool {
+	base := path.Base(name)
+	return base == UnlockTokenFilename || strings.HasSuffix(base, ".key") ||
+		strings.HasSuffix(base, ".plist") && !strings.HasSuffix(base, appManifestExt)
+}
+
+// encrypt encrypts data of the file name if an envelope is configured
+// and the file is encrypted.
+func encrypt(envelope *cryptoutil.Envelope, name string, data []byte) ([]byte, error) {
+	if envelope == nil || !encryptedFile(name) {
+		return data, nil
+	}
+	return envelope.Encrypt(context.Background(), data, []byte(path.Base(name)))
+}
+
+// decrypt decrypts data of the file name if an envelope is configured.
+// Unencrypted data is returned unchanged.
+func decrypt(envelope *cryptoutil.Envelope, name string, data []byte) ([]byte, error) {
+	if envelope == nil || !encryptedFile(name) {
+		return data, nil
+	}
+	return envelope.Decrypt(context.Background(), data, []byte(path.Base(name)))
+}
+
+// readFile reads (and decrypts) the file name.
+func (s *FileStorage) readFile(name string) ([]byte, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return decrypt(s.envelope, name, b)
+}
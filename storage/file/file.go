@@ -3,11 +3,15 @@ package file
 
 import (
 	"errors"
-	"io/ioutil"
+	"fmt"
 	"os"
 	"path"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/jessepeterson/nanomdm/cryptoutil"
+	"github.com/jessepeterson/nanomdm/log"
 	"github.com/jessepeterson/nanomdm/mdm"
 )
 
@@ -18,6 +22,7 @@ const (
 	SerialNumberFilename = "SerialNumber.txt"
 	IdentityCertFilename = "Identity.pem"
 	DisabledFilename     = "Disabled"
+	LastSeenFilename     = "LastSeen.txt"
 
 	CertAuthFilename             = "CertAuth.sha256.txt"
 	CertAuthAssociationsFilename = "CertAuth.txt"
@@ -29,17 +34,82 @@ const (
 )
 
 // FileStorage implements filesystem-based storage for MDM services
+//
+// The enrollment index and queue journals are cached in memory so only
+// a single FileStorage (and process) should use a given path.
 type FileStorage struct {
-	path string
+	path         string
+	logger       log.Logger
+	syncInterval time.Duration
+	syncer       *syncer
+	index        *index
+	lockFile     *os.File
+
+	queuesMu sync.Mutex
+	queues   map[string]*queueIndex // enrollment ID to queue index
+
+	locksMu sync.Mutex
+	locks   map[string]*sync.Mutex // enrollment ID to lock
+
+	groupsMu sync.Mutex // guards the groups and smart groups files
+
+	envelope *cryptoutil.Envelope
+}
+
+type Option func(*FileStorage)
+
+// WithLogger logs storage errors and recoveries to logger.
+func WithLogger(logger log.Logger) Option {
+	return func(s *FileStorage) {
+		s.logger = logger
+	}
+}
+
+// WithSyncInterval sets the interval at which written files are synced
+// to disk in batches. A zero interval disables syncing and leaves it to
+// the operating system.
+func WithSyncInterval(interval time.Duration) Option {
+	return func(s *FileStorage) {
+		s.syncInterval = interval
+	}
 }
 
 // New creates a new FileStorage backend
-func New(path string) (*FileStorage, error) {
+func New(path string, opts ...Option) (*FileStorage, error) {
 	err := os.Mkdir(path, 0755)
 	if err != nil && !errors.Is(err, os.ErrExist) {
 		return nil, err
 	}
-	return &FileStorage{path: path}, nil
+	s := &FileStorage{
+		path:         path,
+		logger:       log.NopLogger,
+		syncInterval: DefaultSyncInterval,
+		queues:       make(map[string]*queueIndex),
+		locks:        make(map[string]*sync.Mutex),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if err = s.lockDir(); err != nil {
+		return nil, err
+	}
+	s.removeTempFiles(s.path)
+	moved, err := MigrateLayout(s.path)
+	if err != nil {
+		s.lockFile.Close()
+		return nil, fmt.Errorf("migrating to sharded layout: %w", err)
+	} else if moved > 0 {
+		s.logger.Info("msg", "migrated enrollments to sharded layout", "count", moved)
+	}
+	if s.index, err = s.loadIndex(); err != nil {
+		s.lockFile.Close()
+		return nil, fmt.Errorf("loading index: %w", err)
+	}
+	if s.syncInterval > 0 {
+		s.syncer = newSyncer()
+		go s.syncer.run(s.syncInterval, s.logger)
+	}
+	return s, nil
 }
 
 type enrollment struct {
@@ -52,13 +122,23 @@ func (s *FileStorage) newEnrollment(id string) *enrollment {
 }
 
 func (e *enrollment) dir() string {
-	return path.Join(e.fs.path, e.id)
+	return path.Join(e.fs.path, shard(e.id), e.id)
 }
 
 func (e *enrollment) mkdir() error {
 	return os.MkdirAll(e.dir(), 0755)
 }
 
+// checkName returns an error if name (a kind of name) can't be used in
+// a file name as it is empty, contains a path separator, or would be
+// a hidden file.
+func checkName(kind, name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid %s: %q", kind, name)
+	}
+	return nil
+}
+
 func (e *enrollment) dirPrefix(name string) string {
 	return path.Join(e.dir(), name)
 }
@@ -70,14 +150,14 @@ func (e *enrollment) writeFile(name string, bytes []byte) error {
 	if err := e.mkdir(); err != nil {
 		return err
 	}
-	return ioutil.WriteFile(e.dirPrefix(name), bytes, 0644)
+	return e.fs.writeFile(e.dirPrefix(name), bytes, 0644)
 }
 
 func (e *enrollment) readFile(name string) ([]byte, error) {
 	if name == "" {
 		return nil, errors.New("write: empty name")
 	}
-	return ioutil.ReadFile(e.dirPrefix(name))
+	return e.fs.readFile(e.dirPrefix(name))
 }
 
 // assocSubEnrollment writes an empty file of the sub (user) enrollment for tracking.
@@ -148,8 +228,25 @@ func (s *FileStorage) StoreTokenUpdate(r *mdm.Request, msg *mdm.TokenUpdate) err
 	if err := e.writeFile(TokenUpdateFilename, []byte(msg.Raw)); err != nil {
 		return err
 	}
+	tu := new(indexEntry)
+	if err := tu.setTokenUpdate(msg); err != nil {
+		return err
+	}
+	now := time.Now()
+	err := s.index.update(r.ID, func(entry *indexEntry) {
+		entry.DeviceID, entry.Type = tu.DeviceID, tu.Type
+		entry.ParentID = r.ParentID
+		entry.UserShortName, entry.UserLongName = tu.UserShortName, tu.UserLongName
+		entry.Topic, entry.PushMagic, entry.Token = tu.Topic, tu.PushMagic, tu.Token
+		entry.AwaitingConfiguration, entry.NotOnConsole = tu.AwaitingConfiguration, tu.NotOnConsole
+		entry.Disabled = false
+		entry.LastSeenAt = &now
+	})
+	if err != nil {
+		return err
+	}
 	// delete the disabled flag to let signify this enrollment is enabled
-	if err := os.Remove(e.dirPrefix(DisabledFilename)); err != nil && !errors.Is(err, os.ErrExist) {
+	if err := os.Remove(e.dirPrefix(DisabledFilename)); err != nil && !errors.Is(err, os.ErrNotExist) {
 		return err
 	}
 	return nil
@@ -170,6 +267,12 @@ func (s *FileStorage) Disable(r *mdm.Request) error {
 		if err := e.writeFile(DisabledFilename, nil); err != nil {
 			return err
 		}
+		err := s.index.update(id, func(entry *indexEntry) {
+			entry.Disabled = true
+		})
+		if err != nil {
+			return err
+		}
 	}
 	return e.removeSubEnrollments()
 }
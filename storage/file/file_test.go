@@ -0,0 +1,802 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/cryptoutil"
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
+	"github.com/jessepeterson/nanomdm/storage/storagetest"
+)
+
+func TestIndexAndQueueJournal(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir, WithSyncInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile("../../mdm/testdata/TokenUpdate.1.plist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mdm.DecodeCheckin(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const id = "663b07bb783e9ade1dae4fbb92ea12afc0ce5b69"
+	r := &mdm.Request{
+		EnrollID: &mdm.EnrollID{ID: id, Type: mdm.Device},
+		Context:  context.Background(),
+	}
+	if err = s.StoreTokenUpdate(r, msg.(*mdm.TokenUpdate)); err != nil {
+		t.Fatal(err)
+	}
+	// enqueue in reverse lexical order to check queue ordering
+	for _, uuid := range []string{"C", "B", "A"} {
+		cmd := &mdm.Command{CommandUUID: uuid, Raw: []byte(
+			`<?xml version="1.0" encoding="UTF-8"?><plist version="1.0"><dict><key>CommandUUID</key><string>` + uuid +
+				`</string><key>Command</key><dict><key>RequestType</key><string>DeviceInformation</string></dict></dict></plist>`,
+		)}
+		if _, err = s.EnqueueCommand(r.Context, []string{id}, cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = s.StoreCommandReport(r, &mdm.CommandResults{CommandUUID: "C", Status: "NotNow"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = New(dir); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected locked error, have: %v", err)
+	}
+
+	// reopen to load the index and queue journal from disk
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s, err = New(dir, WithSyncInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enrollments, err := s.ListEnrollments(r.Context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(enrollments) != 1 || enrollments[0].ID != id || !enrollments[0].Enabled || enrollments[0].LastSeenAt == nil {
+		t.Fatalf("unexpected enrollments: %v", enrollments)
+	}
+	push, err := s.RetrievePushInfo(r.Context, []string{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := push[id].PushMagic, "CEFDF0BD-E342-4A27-8742-E930EA116B0A"; have != want {
+		t.Errorf("push magic: have %q, want %q", have, want)
+	}
+	for _, test := range []struct {
+		skipNotNow bool
+		uuid       string
+	}{
+		{false, "C"},
+		{true, "B"},
+	} {
+		cmd, err := s.RetrieveNextCommand(r, test.skipNotNow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cmd == nil || cmd.CommandUUID != test.uuid {
+			t.Fatalf("next command: have %v, want %s", cmd, test.uuid)
+		}
+	}
+	err = s.StoreCommandReport(r, &mdm.CommandResults{CommandUUID: "B", Status: "Acknowledged"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.StoreCommandReport(r, &mdm.CommandResults{CommandUUID: "B", Status: "Acknowledged"})
+	if !errors.Is(err, storage.ErrDuplicateReport) {
+		t.Errorf("re-sent report: have %v, want duplicate", err)
+	}
+	// C was re-delivered so a NotNow isn't a duplicate until re-sent
+	for _, want := range []error{nil, storage.ErrDuplicateReport} {
+		err = s.StoreCommandReport(r, &mdm.CommandResults{CommandUUID: "C", Status: "NotNow"})
+		if !errors.Is(err, want) {
+			t.Errorf("NotNow report: have %v, want %v", err, want)
+		}
+	}
+	cmd, err := s.RetrieveNextCommand(r, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd == nil || cmd.CommandUUID != "A" {
+		t.Errorf("next command: have %v, want A", cmd)
+	}
+
+	// corrupt the index and queue journal and check they're recovered
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, IndexFilename), []byte("[{garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, shard(id), id, QueueJournalFilename), []byte("Queue B\nQueue\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err = New(dir, WithSyncInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enrollments, err = s.ListEnrollments(r.Context); err != nil || len(enrollments) != 1 {
+		t.Fatalf("unexpected enrollments after recovery: %v (%v)", enrollments, err)
+	}
+	if cmd, err = s.RetrieveNextCommand(r, true); err != nil {
+		t.Fatal(err)
+	}
+	if cmd == nil || cmd.CommandUUID != "A" {
+		t.Errorf("next command after recovery: have %v, want A", cmd)
+	}
+}
+
+func TestEnqueueErrors(t *testing.T) {
+	s, err := New(t.TempDir(), WithSyncInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	b, err := os.ReadFile("../../mdm/testdata/TokenUpdate.1.plist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mdm.DecodeCheckin(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const id = "663b07bb783e9ade1dae4fbb92ea12afc0ce5b69"
+	r := &mdm.Request{
+		EnrollID: &mdm.EnrollID{ID: id, Type: mdm.Device},
+		Context:  context.Background(),
+	}
+	if err = s.StoreTokenUpdate(r, msg.(*mdm.TokenUpdate)); err != nil {
+		t.Fatal(err)
+	}
+	cmd := &mdm.Command{CommandUUID: "A", Raw: []byte("<plist/>")}
+	idErrs, err := s.EnqueueCommand(r.Context, []string{id, "unknown"}, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idErrs[id] != nil || !errors.Is(idErrs["unknown"], storage.ErrNotFound) {
+		t.Errorf("unexpected errors: %v", idErrs)
+	}
+	if idErrs, _ = s.EnqueueCommand(r.Context, []string{id}, cmd); !errors.Is(idErrs[id], storage.ErrConflict) {
+		t.Errorf("duplicate: have %v, want %v", idErrs[id], storage.ErrConflict)
+	}
+	if err = s.Disable(r); err != nil {
+		t.Fatal(err)
+	}
+	cmd.CommandUUID = "B"
+	if idErrs, _ = s.EnqueueCommand(r.Context, []string{id}, cmd); !errors.Is(idErrs[id], storage.ErrDisabledEnrollment) {
+		t.Errorf("disabled: have %v, want %v", idErrs[id], storage.ErrDisabledEnrollment)
+	}
+	if _, err = s.RetrieveCommandDeliveries(r.Context, "unknown"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("deliveries: have %v, want %v", err, storage.ErrNotFound)
+	}
+}
+
+func BenchmarkQueue(b *testing.B) {
+	s, err := New(b.TempDir())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer s.Close()
+	storagetest.BenchmarkQueue(b, s, "BE0C")
+}
+
+func TestGroups(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(t.TempDir(), WithSyncInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = s.AddGroupMembers(ctx, "lab", []string{"B", "A", "B"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.AddGroupMembers(ctx, "lab", []string{"C"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.RemoveGroupMembers(ctx, "lab", []string{"B"}); err != nil {
+		t.Fatal(err)
+	}
+	members, err := s.RetrieveGroupMembers(ctx, "lab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := fmt.Sprint(members), "[A C]"; have != want {
+		t.Errorf("members: have %s, want %s", have, want)
+	}
+	groups, err := s.ListGroups(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := groups["lab"], 2; len(groups) != 1 || have != want {
+		t.Errorf("groups: have %v, want lab with %d members", groups, want)
+	}
+
+	// groups without members no longer exist
+	if err = s.RemoveGroupMembers(ctx, "lab", []string{"A", "C"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.RetrieveGroupMembers(ctx, "lab"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("have %v, want %v", err, storage.ErrNotFound)
+	}
+	if err = s.DeleteGroup(ctx, "lab"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("have %v, want %v", err, storage.ErrNotFound)
+	}
+}
+
+func TestSmartGroups(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(t.TempDir(), WithSyncInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, g := range []*storage.SmartGroup{
+		{Name: "old-macos", Filter: "os_version < 13"},
+		{Name: "no-filevault", Filter: "filevault_enabled = false"},
+		{Name: "old-macos", Filter: "os_version < 14.4"},
+	} {
+		if err = s.StoreSmartGroup(ctx, g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	groups, err := s.RetrieveSmartGroups(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 || groups[0].Name != "no-filevault" || groups[1].Filter != "os_version < 14.4" || groups[1].UpdatedAt.IsZero() {
+		t.Errorf("unexpected smart groups: %v", groups)
+	}
+	if groups, err = s.RetrieveSmartGroups(ctx, []string{"old-macos", "other"}); err != nil {
+		t.Fatal(err)
+	} else if len(groups) != 1 {
+		t.Errorf("have %d smart groups, want 1", len(groups))
+	}
+	if err = s.DeleteSmartGroup(ctx, "old-macos"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.DeleteSmartGroup(ctx, "old-macos"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("have %v, want %v", err, storage.ErrNotFound)
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	envelope, err := cryptoutil.ParseLocalEnvelope(strings.Repeat("ab", 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	s, err := New(dir, WithSyncInterval(0), WithEnvelope(envelope))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile("../../mdm/testdata/TokenUpdate.1.plist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mdm.DecodeCheckin(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const id = "663b07bb783e9ade1dae4fbb92ea12afc0ce5b69"
+	r := &mdm.Request{
+		EnrollID: &mdm.EnrollID{ID: id, Type: mdm.Device},
+		Context:  context.Background(),
+	}
+	if err = s.StoreTokenUpdate(r, msg.(*mdm.TokenUpdate)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.EnqueueCommand(r.Context, []string{id}, storagetest.Command("A")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{TokenUpdateFilename, filepath.Join(subQueue, "A.plist")} {
+		raw, err := os.ReadFile(filepath.Join(dir, shard(id), id, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(raw, []byte("<plist")) {
+			t.Errorf("%s: not encrypted", name)
+		}
+	}
+
+	push, err := s.RetrievePushInfo(r.Context, []string{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := push[id].PushMagic, "CEFDF0BD-E342-4A27-8742-E930EA116B0A"; have != want {
+		t.Errorf("push magic: have %q, want %q", have, want)
+	}
+	cmd, err := s.RetrieveNextCommand(r, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd == nil || cmd.CommandUUID != "A" {
+		t.Fatalf("unexpected command: %v", cmd)
+	}
+
+	// files written without the envelope are still read
+	plain, err := New(t.TempDir(), WithSyncInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = plain.StoreTokenUpdate(r, msg.(*mdm.TokenUpdate)); err != nil {
+		t.Fatal(err)
+	}
+	plain.envelope = envelope
+	if push, err = plain.RetrievePushInfo(r.Context, []string{id}); err != nil || push[id] == nil {
+		t.Errorf("reading unencrypted file: %v %v", push, err)
+	}
+}
+
+func TestMigrateLayout(t *testing.T) {
+	dir := t.TempDir()
+	const id = "663b07bb783e9ade1dae4fbb92ea12afc0ce5b69"
+	b, err := os.ReadFile("../../mdm/testdata/TokenUpdate.1.plist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// unsharded layout
+	if err = os.Mkdir(filepath.Join(dir, id), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, id, TokenUpdateFilename), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// an unrelated directory (e.g. of offloaded blobs)
+	if err = os.MkdirAll(filepath.Join(dir, "blobs", "results"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(dir, WithSyncInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, shard(id), id, TokenUpdateFilename)); err != nil {
+		t.Errorf("not migrated: %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "blobs", "results")); err != nil {
+		t.Errorf("unrelated directory moved: %v", err)
+	}
+	push, err := s.RetrievePushInfo(context.Background(), []string{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if push[id] == nil {
+		t.Error("migrated enrollment not indexed")
+	}
+	if moved, err := MigrateLayout(dir); err != nil || moved != 0 {
+		t.Errorf("migrating again: moved %d: %v", moved, err)
+	}
+}
+
+func TestCounts(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(t.TempDir(), WithSyncInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := storagetest.Enroll(ctx, s, "A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, uuid := range []string{"1", "2"} {
+		if _, err = s.EnqueueCommand(ctx, []string{"A"}, storagetest.Command(uuid)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.StoreCommandReport(r, &mdm.CommandResults{CommandUUID: "1", Status: "Acknowledged"}); err != nil {
+		t.Fatal(err)
+	}
+
+	enrollments, err := s.CountEnrollments(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enrollments.Total != 1 || enrollments.Enabled != 1 || enrollments.ByType["Device"] != 1 {
+		t.Errorf("unexpected enrollment counts: %+v", enrollments)
+	}
+
+	buckets, err := s.CountCommands(ctx, time.Now().Add(-time.Hour), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) < 60 {
+		t.Fatalf("expected at least 60 buckets, have %d", len(buckets))
+	}
+	total := new(storage.CommandCounts)
+	for _, b := range buckets {
+		total.Enqueued += b.Enqueued
+		total.Acknowledged += b.Acknowledged
+		total.Errored += b.Errored
+	}
+	if total.Enqueued != 2 || total.Acknowledged != 1 || total.Errored != 0 {
+		t.Errorf("unexpected command counts: %+v", total)
+	}
+}
+
+func TestCommandDeliveries(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := New(dir, WithSyncInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	r, err := storagetest.Enroll(ctx, s, "A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.EnqueueCommand(ctx, []string{"A"}, storagetest.Command("1")); err != nil {
+		t.Fatal(err)
+	}
+	// delivered, NotNow'd, re-delivered, and acknowledged
+	for _, status := range []string{"NotNow", "Acknowledged"} {
+		cmd, err := s.RetrieveNextCommand(r, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cmd == nil || cmd.CommandUUID != "1" {
+			t.Fatalf("next command: have %v, want 1", cmd)
+		}
+		report := &mdm.CommandResults{CommandUUID: "1", Status: status, Raw: []byte(status)}
+		if err = s.StoreCommandReport(r, report); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cmd, err := s.RetrieveNextCommand(r, false); err != nil || cmd != nil {
+		t.Errorf("next command after acknowledgement: have %v (%v), want none", cmd, err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, shard("A"), "A", subDone, "1.result.plist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := string(b), "Acknowledged"; have != want {
+		t.Errorf("results: have %q, want %q", have, want)
+	}
+
+	deliveries, err := s.RetrieveCommandDeliveries(ctx, "A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("have %d deliveries, want 1", len(deliveries))
+	}
+	d := deliveries[0]
+	if d.Status != "Acknowledged" || !d.Active || d.DeliveryCount != 2 || d.NotNowCount != 1 {
+		t.Errorf("unexpected delivery: %+v", d)
+	}
+	if d.FirstDeliveredAt == nil || d.LastNotNowAt == nil || d.ResolvedAt == nil {
+		t.Errorf("missing delivery times: %+v", d)
+	}
+}
+
+func TestCommandCallbacks(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(t.TempDir(), WithSyncInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	r, err := storagetest.Enroll(ctx, s, "A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.StoreCommandCallback(ctx, []string{"A"}, "1", "https://example.com/cb"); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		id  string
+		url string
+	}{
+		{"A", "https://example.com/cb"},
+		{"B", ""},
+	} {
+		if url, err := s.RetrieveCommandCallback(ctx, test.id, "1"); err != nil || url != test.url {
+			t.Errorf("%s: have %q (%v), want %q", test.id, url, err, test.url)
+		}
+	}
+	if err = s.StoreCommandCallback(ctx, []string{"A"}, "../1", "https://example.com/cb"); err == nil {
+		t.Error("expected invalid command UUID error")
+	}
+
+	// callbacks are purged with the queue
+	if err = s.Disable(r); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.PurgeQueues(ctx, time.Time{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if url, err := s.RetrieveCommandCallback(ctx, "A", "1"); err != nil || url != "" {
+		t.Errorf("after purge: have %q (%v), want none", url, err)
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := New(dir, WithSyncInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { s.Close() }()
+	r, err := storagetest.Enroll(ctx, s, "A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, uuid := range []string{"1", "2"} {
+		if _, err = s.EnqueueCommand(ctx, []string{"A"}, storagetest.Command(uuid)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.SoftDelete(r); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Disable(r); err != nil {
+		t.Fatal(err)
+	}
+
+	// reload the index to check the deleted state is kept
+	s.Close()
+	if s, err = New(dir, WithSyncInterval(0)); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := s.RetrieveDeletedEnrollments(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0].ID != "A" || deleted[0].Pending != 2 || deleted[0].Enabled {
+		t.Fatalf("unexpected deleted enrollments: %v", deleted)
+	}
+
+	// deleted enrollments are excluded from queueing, pushes, and listings
+	idErrs, err := s.EnqueueCommand(ctx, []string{"A"}, storagetest.Command("3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(idErrs["A"], storage.ErrDisabledEnrollment) {
+		t.Errorf("enqueue: have %v, want %v", idErrs["A"], storage.ErrDisabledEnrollment)
+	}
+	if pushInfos, err := s.RetrievePushInfo(ctx, []string{"A"}); err != nil || len(pushInfos) != 0 {
+		t.Errorf("push info: have %v (%v), want none", pushInfos, err)
+	}
+	if enrollments, err := s.ListEnrollments(ctx); err != nil || len(enrollments) != 0 {
+		t.Errorf("enrollments: have %v (%v), want none", enrollments, err)
+	}
+	if _, err = s.RestoreEnrollment(ctx, "A"); !errors.Is(err, storage.ErrNotReEnrolled) {
+		t.Errorf("restore: have %v, want %v", err, storage.ErrNotReEnrolled)
+	}
+
+	// re-enrollment (which clears the queue) lists and pushes the
+	// enrollment again
+	if r, err = storagetest.Enroll(ctx, s, "A"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.ClearQueue(r); err != nil {
+		t.Fatal(err)
+	}
+	if pushInfos, err := s.RetrievePushInfo(ctx, []string{"A"}); err != nil || pushInfos["A"] == nil {
+		t.Errorf("push info after re-enrollment: have %v (%v), want A", pushInfos, err)
+	}
+	if enrollments, err := s.ListEnrollments(ctx); err != nil || len(enrollments) != 1 {
+		t.Errorf("enrollments after re-enrollment: have %v (%v), want A", enrollments, err)
+	}
+
+	count, err := s.RestoreEnrollment(ctx, "A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("restored commands: have %d, want 2", count)
+	}
+	if cmd, err := s.RetrieveNextCommand(r, false); err != nil || cmd == nil || cmd.CommandUUID != "1" {
+		t.Errorf("next command after restore: have %v (%v), want 1", cmd, err)
+	}
+	if deleted, err = s.RetrieveDeletedEnrollments(ctx, nil); err != nil || len(deleted) != 0 {
+		t.Errorf("deleted enrollments after restore: have %v (%v), want none", deleted, err)
+	}
+	if _, err = s.RestoreEnrollment(ctx, "A"); !errors.Is(err, storage.ErrNotDeleted) {
+		t.Errorf("second restore: have %v, want %v", err, storage.ErrNotDeleted)
+	}
+}
+
+func TestTokenUpdateFields(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := New(dir, WithSyncInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { s.Close() }()
+	r := &mdm.Request{Context: ctx, EnrollID: &mdm.EnrollID{ID: "A", Type: mdm.Device}}
+	tokenUpdate := func(awaiting bool) *mdm.TokenUpdate {
+		msg, err := mdm.DecodeCheckin([]byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>AwaitingConfiguration</key>
+	<%t/>
+	<key>MessageType</key>
+	<string>TokenUpdate</string>
+	<key>PushMagic</key>
+	<string>CEFDF0BD-E342-4A27-8742-E930EA116B0A</string>
+	<key>Token</key>
+	<data>R+juwGLC9ynsFwPBs+GPGXHYXwC+dkRdNAgLqnAbX1E=</data>
+	<key>Topic</key>
+	<string>com.apple.mgmt.External.test</string>
+	<key>UDID</key>
+	<string>A</string>
+</dict>
+</plist>`, awaiting)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg.(*mdm.TokenUpdate)
+	}
+	check := func(step string, awaiting bool) {
+		t.Helper()
+		enrollments, err := s.ListEnrollments(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(enrollments) != 1 {
+			t.Fatalf("%s: have %d enrollments, want 1", step, len(enrollments))
+		}
+		e := enrollments[0]
+		if e.AwaitingConfiguration != awaiting || !e.HasPushMagic || e.Topic != "com.apple.mgmt.External.test" {
+			t.Errorf("%s: unexpected enrollment: %+v", step, e)
+		}
+	}
+
+	if err = s.StoreTokenUpdate(r, tokenUpdate(true)); err != nil {
+		t.Fatal(err)
+	}
+	check("stored", true)
+
+	// the fields are rebuilt from the stored TokenUpdate
+	s.Close()
+	for _, name := range []string{IndexFilename, IndexJournalFilename} {
+		if err = os.Remove(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s, err = New(dir, WithSyncInterval(0)); err != nil {
+		t.Fatal(err)
+	}
+	check("rebuilt", true)
+
+	if err = s.StoreTokenUpdate(r, tokenUpdate(false)); err != nil {
+		t.Fatal(err)
+	}
+	check("configured", false)
+}
+
+// enrollUser stores the TokenUpdate of the user channel enrollment id
+// (of user userID) of device channel enrollment parentID.
+func enrollUser(t *testing.T, s *FileStorage, parentID, id, userID string) *mdm.Request {
+	t.Helper()
+	msg, err := mdm.DecodeCheckin([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>MessageType</key>
+	<string>TokenUpdate</string>
+	<key>PushMagic</key>
+	<string>CEFDF0BD-E342-4A27-8742-E930EA116B0A</string>
+	<key>Token</key>
+	<data>R+juwGLC9ynsFwPBs+GPGXHYXwC+dkRdNAgLqnAbX1E=</data>
+	<key>Topic</key>
+	<string>com.apple.mgmt.External.test</string>
+	<key>UDID</key>
+	<string>` + parentID + `</string>
+	<key>UserID</key>
+	<string>` + userID + `</string>
+	<key>UserShortName</key>
+	<string>user</string>
+</dict>
+</plist>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &mdm.Request{
+		Context:  context.Background(),
+		EnrollID: &mdm.EnrollID{ID: id, Type: mdm.User, ParentID: parentID},
+	}
+	if err = s.StoreTokenUpdate(r, msg.(*mdm.TokenUpdate)); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestListUserChannelIDs(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(t.TempDir(), WithSyncInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	r, err := storagetest.Enroll(ctx, s, "A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = storagetest.Enroll(ctx, s, "B"); err != nil {
+		t.Fatal(err)
+	}
+	const user1, user2 = "6A3E1F4C-1F2B-4C5D-8E9F-0A1B2C3D4E51", "6A3E1F4C-1F2B-4C5D-8E9F-0A1B2C3D4E52"
+	enrollUser(t, s, "A", "A:"+user1, user1)
+	enrollUser(t, s, "A", "A:"+user2, user2)
+
+	ids, err := s.ListUserChannelIDs(ctx, []string{"A", "B"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := fmt.Sprint(ids), fmt.Sprintf("map[A:[A:%s A:%s]]", user1, user2); have != want {
+		t.Errorf("user channels: have %s, want %s", have, want)
+	}
+
+	// disabled user channels are left out
+	if err = s.Disable(r); err != nil {
+		t.Fatal(err)
+	}
+	if ids, err = s.ListUserChannelIDs(ctx, []string{"A"}); err != nil || len(ids) != 0 {
+		t.Errorf("user channels of disabled device: have %v (%v), want none", ids, err)
+	}
+}
+
+func TestEnrollmentRelationships(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := New(dir, WithSyncInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { s.Close() }()
+	if _, err = storagetest.Enroll(ctx, s, "A"); err != nil {
+		t.Fatal(err)
+	}
+	const user = "6A3E1F4C-1F2B-4C5D-8E9F-0A1B2C3D4E51"
+	enrollUser(t, s, "A", "A:"+user, user)
+
+	for _, step := range []string{"stored", "rebuilt"} {
+		if step == "rebuilt" {
+			// parents are linked from the sub-enrollments when the
+			// index is rebuilt
+			s.Close()
+			for _, name := range []string{IndexFilename, IndexJournalFilename} {
+				if err = os.Remove(filepath.Join(dir, name)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if s, err = New(dir, WithSyncInterval(0)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		enrollments, err := s.ListEnrollments(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		byID := make(map[string]*storage.Enrollment)
+		for _, e := range enrollments {
+			byID[e.ID] = e
+		}
+		if d := byID["A"]; d == nil || len(d.UserChannels) != 1 || d.UserChannels[0] != "A:"+user {
+			t.Errorf("%s: unexpected device channel: %+v", step, d)
+		}
+		if u := byID["A:"+user]; u == nil || u.ParentID != "A" || u.UserShortName != "user" {
+			t.Errorf("%s: unexpected user channel: %+v", step, u)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+const GroupsFilename = "Groups.json"
+
+// readGroups reads the groups file. s.groupsMu must be held.
+func (s *FileStorage) readGroups() (map[string][]string, error) {
+	groups := make(map[string][]string)
+	b, err := ioutil.ReadFile(path.Join(s.path, GroupsFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return groups, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return groups, json.Unmarshal(b, &groups)
+}
+
+// updateGroups reads, updates with f, and writes the groups file.
+// Groups without members are removed.
+func (s *FileStorage) updateGroups(f func(map[string][]string) error) error {
+	s.groupsMu.Lock()
+	defer s.groupsMu.Unlock()
+	groups, err := s.readGroups()
+	if err != nil {
+		return err
+	}
+	if err = f(groups); err != nil {
+		return err
+	}
+	for name, ids := range groups {
+		if len(ids) < 1 {
+			delete(groups, name)
+		}
+	}
+	b, err := json.Marshal(groups)
+	if err != nil {
+		return err
+	}
+	return s.writeFile(path.Join(s.path, GroupsFilename), b, 0644)
+}
+
+// AddGroupMembers adds ids to group name.
+func (s *FileStorage) AddGroupMembers(_ context.Context, name string, ids []string) error {
+	return s.updateGroups(func(groups map[string][]string) error {
+		members := make(map[string]bool)
+		for _, id := range groups[name] {
+			members[id] = true
+		}
+		for _, id := range ids {
+			if !members[id] {
+				members[id] = true
+				groups[name] = append(groups[name], id)
+			}
+		}
+		sort.Strings(groups[name])
+		return nil
+	})
+}
+
+// RemoveGroupMembers removes ids from group name.
+func (s *FileStorage) RemoveGroupMembers(_ context.Context, name string, ids []string) error {
+	return s.updateGroups(func(groups map[string][]string) error {
+		remove := make(map[string]bool)
+		for _, id := range ids {
+			remove[id] = true
+		}
+		var members []string
+		for _, id := range groups[name] {
+			if !remove[id] {
+				members = append(members, id)
+			}
+		}
+		groups[name] = members
+		return nil
+	})
+}
+
+// DeleteGroup deletes group name.
+func (s *FileStorage) DeleteGroup(_ context.Context, name string) error {
+	return s.updateGroups(func(groups map[string][]string) error {
+		if _, ok := groups[name]; !ok {
+			return fmt.Errorf("%w: group %s", storage.ErrNotFound, name)
+		}
+		delete(groups, name)
+		return nil
+	})
+}
+
+// RetrieveGroupMembers reads the members of group name.
+func (s *FileStorage) RetrieveGroupMembers(_ context.Context, name string) ([]string, error) {
+	s.groupsMu.Lock()
+	defer s.groupsMu.Unlock()
+	groups, err := s.readGroups()
+	if err != nil {
+		return nil, err
+	}
+	members, ok := groups[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: group %s", storage.ErrNotFound, name)
+	}
+	return members, nil
+}
+
+// ListGroups reads the member counts of the groups.
+func (s *FileStorage) ListGroups(_ context.Context) (map[string]int, error) {
+	s.groupsMu.Lock()
+	defer s.groupsMu.Unlock()
+	groups, err := s.readGroups()
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for name, ids := range groups {
+		counts[name] = len(ids)
+	}
+	return counts, nil
+}
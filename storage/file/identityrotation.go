@@ -0,0 +1,96 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+const IdentityRotationFilename = "IdentityRotation.json"
+
+// StoreIdentityRotation writes the enrollment's identity rotation file.
+func (s *FileStorage) StoreIdentityRotation(_ context.Context, rot *storage.IdentityRotation) error {
+	mu := s.enrollmentLock(rot.ID)
+	mu.Lock()
+	defer mu.Unlock()
+	return s.writeIdentityRotation(rot)
+}
+
+func (s *FileStorage) writeIdentityRotation(rot *storage.IdentityRotation) error {
+	b, err := json.Marshal(rot)
+	if err != nil {
+		return err
+	}
+	return s.newEnrollment(rot.ID).writeFile(IdentityRotationFilename, b)
+}
+
+func (s *FileStorage) readIdentityRotation(id string) (*storage.IdentityRotation, error) {
+	b, err := s.newEnrollment(id).readFile(IdentityRotationFilename)
+	if err != nil {
+		return nil, err
+	}
+	rot := new(storage.IdentityRotation)
+	return rot, json.Unmarshal(b, rot)
+}
+
+// UpdateIdentityRotation rewrites the enrollment's identity rotation
+// file if the rotation is in state (and has the same command UUID),
+// associating hash (if not empty) first. The association replaces the
+// association of the enrollment (see IsCertHashAssociated).
+func (s *FileStorage) UpdateIdentityRotation(_ context.Context, rot *storage.IdentityRotation, state, hash string) (bool, error) {
+	mu := s.enrollmentLock(rot.ID)
+	mu.Lock()
+	defer mu.Unlock()
+	cur, err := s.readIdentityRotation(rot.ID)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if cur.State != state || cur.CommandUUID != rot.CommandUUID {
+		return false, nil
+	}
+	if hash != "" {
+		r := &mdm.Request{EnrollID: &mdm.EnrollID{ID: rot.ID}}
+		if err = s.AssociateCertHash(r, hash); err != nil {
+			return false, err
+		}
+	}
+	return true, s.writeIdentityRotation(rot)
+}
+
+// RetrieveIdentityRotations reads the identity rotation files of ids (or all enrollments).
+func (s *FileStorage) RetrieveIdentityRotations(_ context.Context, ids []string) ([]*storage.IdentityRotation, error) {
+	if len(ids) < 1 {
+		var err error
+		if ids, err = s.enrollmentIDs(); err != nil {
+			return nil, err
+		}
+	}
+	var rots []*storage.IdentityRotation
+	for _, id := range ids {
+		rot, err := s.readIdentityRotation(id)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		rots = append(rots, rot)
+	}
+	sort.Slice(rots, func(i, j int) bool { return rots[i].ID < rots[j].ID })
+	return rots, nil
+}
+
+// RetrieveCertHash reads the enrollment's certificate association file.
+func (s *FileStorage) RetrieveCertHash(_ context.Context, id string) (string, error) {
+	b, err := s.newEnrollment(id).readFile(CertAuthFilename)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	return string(b), err
+}
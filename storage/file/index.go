@@ -0,0 +1,299 @@
+package file
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+)
+
+const (
+	// IndexFilename is the enrollment index. It summarizes every
+	// enrollment so that listing enrollments and retrieving push info
+	// does not need to scan and decode every enrollment's files.
+	IndexFilename = "Index.json"
+	// IndexJournalFilename is the journal of enrollment index changes
+	// since the index was last written. Each line is a JSON index entry.
+	IndexJournalFilename = "Index.journal"
+)
+
+// indexEntry is the enrollment index entry of an enrollment.
+type indexEntry struct {
+	ID       string `json:"id"`
+	DeviceID string `json:"device_id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	// Push info from the last TokenUpdate.
+	Topic     string `json:"topic,omitempty"`
+	PushMagic string `json:"push_magic,omitempty"`
+	Token     string `json:"token,omitempty"`
+
+	Disabled bool `json:"disabled,omitempty"`
+	// Deleted is set for soft-deleted enrollments until restored.
+	Deleted    bool       `json:"deleted,omitempty"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+
+	// ParentID is the device channel enrollment of a user channel.
+	ParentID      string `json:"parent_id,omitempty"`
+	UserShortName string `json:"user_short_name,omitempty"`
+	UserLongName  string `json:"user_long_name,omitempty"`
+
+	AwaitingConfiguration bool `json:"awaiting_configuration,omitempty"`
+	NotOnConsole          bool `json:"not_on_console,omitempty"`
+}
+
+// index is the in-memory enrollment index backed by the index file and
+// its journal. Changes are appended to the journal which is compacted
+// into the index file once it grows large.
+type index struct {
+	fs      *FileStorage
+	mu      sync.RWMutex
+	entries map[string]*indexEntry
+	journal *os.File
+	// number of entries in the journal
+	journaled int
+}
+
+func (s *FileStorage) indexPath(name string) string {
+	return path.Join(s.path, name)
+}
+
+// loadIndex loads the enrollment index (and replays its journal). The
+// index is rebuilt from the enrollment files if it does not exist.
+func (s *FileStorage) loadIndex() (*index, error) {
+	idx := &index{fs: s, entries: make(map[string]*indexEntry)}
+	b, err := os.ReadFile(s.indexPath(IndexFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		if err = idx.rebuild(); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else {
+		var entries []*indexEntry
+		if err = json.Unmarshal(b, &entries); err != nil {
+			// the index is only ever replaced whole so this is
+			// corruption from outside; the enrollment files are
+			// authoritative so recover by rebuilding
+			s.logger.Info("msg", "rebuilding corrupt index", "err", err)
+			idx.entries = make(map[string]*indexEntry)
+			if err = idx.rebuild(); err != nil {
+				return nil, err
+			}
+			return idx, idx.compact()
+		}
+		for _, entry := range entries {
+			idx.entries[entry.ID] = entry
+		}
+		if err = idx.replay(); err != nil {
+			return nil, err
+		}
+		idx.linkParents()
+	}
+	// start with a fresh journal
+	return idx, idx.compact()
+}
+
+// replay applies the entries in the index journal.
+func (idx *index) replay() error {
+	f, err := os.Open(idx.fs.indexPath(IndexJournalFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	var skipped int
+	for scanner.Scan() {
+		entry := new(indexEntry)
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil || entry.ID == "" {
+			// e.g. a partially written last entry from a crash
+			skipped++
+			continue
+		}
+		idx.entries[entry.ID] = entry
+	}
+	if skipped > 0 {
+		idx.fs.logger.Info("msg", "skipped corrupt index journal entries", "count", skipped)
+	}
+	return scanner.Err()
+}
+
+// rebuild builds the index from the enrollment files.
+func (idx *index) rebuild() error {
+	ids, err := idx.fs.enrollmentIDs()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		e := idx.fs.newEnrollment(id)
+		entry := &indexEntry{ID: e.id}
+		tokenUpdate, err := e.readFile(TokenUpdateFilename)
+		if err == nil {
+			msg, err := mdm.DecodeCheckin(tokenUpdate)
+			if err != nil {
+				return err
+			}
+			message, ok := msg.(*mdm.TokenUpdate)
+			if !ok {
+				return errors.New("saved TokenUpdate is not a TokenUpdate")
+			}
+			if err = entry.setTokenUpdate(message); err != nil {
+				return err
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if entry.LastSeenAt, err = e.readLastSeenFile(); err != nil {
+			return err
+		}
+		_, err = os.Stat(e.dirPrefix(DisabledFilename))
+		entry.Disabled = err == nil
+		idx.entries[entry.ID] = entry
+	}
+	deleted, err := idx.fs.deletedIDs()
+	if err != nil {
+		return err
+	}
+	for id := range deleted {
+		if entry, ok := idx.entries[id]; ok {
+			entry.Deleted = true
+		}
+	}
+	idx.linkParents()
+	return nil
+}
+
+// linkParents sets the missing parent IDs of user channel enrollments
+// from the sub-enrollments of their (enabled) device channel
+// enrollments, e.g. for indexes created before parent IDs were.
+func (idx *index) linkParents() {
+	var missing bool
+	for _, entry := range idx.entries {
+		switch entry.Type {
+		case mdm.EnrollType(mdm.User).String(), mdm.EnrollType(mdm.UserEnrollment).String(), mdm.EnrollType(mdm.SharediPad).String():
+			missing = missing || entry.ParentID == ""
+		}
+	}
+	if !missing {
+		return
+	}
+	for _, entry := range idx.entries {
+		for _, id := range idx.fs.newEnrollment(entry.ID).listSubEnrollments() {
+			if sub, ok := idx.entries[id]; ok && sub.ParentID == "" {
+				sub.ParentID = entry.ID
+			}
+		}
+	}
+}
+
+// setTokenUpdate sets the enrollment and push info of a TokenUpdate.
+func (entry *indexEntry) setTokenUpdate(message *mdm.TokenUpdate) error {
+	resolved := message.Enrollment.Resolved()
+	if err := resolved.Validate(); err != nil {
+		return err
+	}
+	entry.DeviceID = resolved.DeviceChannelID
+	entry.Type = resolved.Type.String()
+	entry.UserShortName = message.UserShortName
+	entry.UserLongName = message.UserLongName
+	entry.Topic = message.Topic
+	entry.PushMagic = message.PushMagic
+	entry.Token = message.Token.String()
+	entry.AwaitingConfiguration = message.AwaitingConfiguration
+	entry.NotOnConsole = message.NotOnConsole
+	return nil
+}
+
+// compact writes the index file and truncates the journal.
+// The lock must be held (or the index not yet shared).
+func (idx *index) compact() error {
+	entries := make([]*indexEntry, 0, len(idx.entries))
+	for _, entry := range idx.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if err = idx.fs.writeFileSync(idx.fs.indexPath(IndexFilename), b, 0644); err != nil {
+		return err
+	}
+	if idx.journal != nil {
+		idx.journal.Close()
+	}
+	idx.journal, err = os.OpenFile(idx.fs.indexPath(IndexJournalFilename), os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0644)
+	idx.journaled = 0
+	return err
+}
+
+// get returns a copy of the index entry of id.
+func (idx *index) get(id string) (indexEntry, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	entry, ok := idx.entries[id]
+	if !ok {
+		return indexEntry{}, false
+	}
+	return *entry, true
+}
+
+// list returns copies of the index entries sorted by ID.
+func (idx *index) list() []indexEntry {
+	idx.mu.RLock()
+	entries := make([]indexEntry, 0, len(idx.entries))
+	for _, entry := range idx.entries {
+		entries = append(entries, *entry)
+	}
+	idx.mu.RUnlock()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+	return entries
+}
+
+// update modifies the index entry of id with f and journals it.
+func (idx *index) update(id string, f func(*indexEntry)) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	entry, ok := idx.entries[id]
+	if !ok {
+		entry = &indexEntry{ID: id}
+	}
+	updated := *entry
+	f(&updated)
+	b, err := json.Marshal(&updated)
+	if err != nil {
+		return err
+	}
+	if _, err = idx.journal.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	idx.fs.syncer.add(idx.journal.Name())
+	idx.entries[id] = &updated
+	idx.journaled++
+	if idx.journaled > 1024 && idx.journaled > 2*len(idx.entries) {
+		return idx.compact()
+	}
+	return nil
+}
+
+// removed reports whether the enrollment is soft-deleted and has not
+// re-enrolled.
+func (entry *indexEntry) removed() bool {
+	return entry.Disabled && entry.Deleted
+}
+
+// pushInfo returns the push info of the index entry (nil if none).
+func (entry *indexEntry) pushInfo() (*mdm.Push, error) {
+	if entry.Token == "" {
+		return nil, nil
+	}
+	push := &mdm.Push{PushMagic: entry.PushMagic, Topic: entry.Topic}
+	return push, push.SetTokenString(entry.Token)
+}
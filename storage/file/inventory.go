@@ -0,0 +1,61 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+const InventoryFilename = "Inventory.json"
+
+func (e *enrollment) readInventory() (*storage.DeviceInventory, error) {
+	b, err := e.readFile(InventoryFilename)
+	if err != nil {
+		return nil, err
+	}
+	inv := new(storage.DeviceInventory)
+	return inv, json.Unmarshal(b, inv)
+}
+
+// StoreInventory merges inv into the enrollment's inventory file.
+func (s *FileStorage) StoreInventory(_ context.Context, inv *storage.DeviceInventory) error {
+	e := s.newEnrollment(inv.ID)
+	existing, err := e.readInventory()
+	if errors.Is(err, os.ErrNotExist) {
+		existing = &storage.DeviceInventory{ID: inv.ID}
+	} else if err != nil {
+		return err
+	}
+	existing.Merge(inv)
+	b, err := json.Marshal(existing)
+	if err != nil {
+		return err
+	}
+	return e.writeFile(InventoryFilename, b)
+}
+
+// RetrieveInventory reads the inventory files of ids (or all enrollments).
+func (s *FileStorage) RetrieveInventory(_ context.Context, ids []string) ([]*storage.DeviceInventory, error) {
+	if len(ids) < 1 {
+		var err error
+		if ids, err = s.enrollmentIDs(); err != nil {
+			return nil, err
+		}
+	}
+	var invs []*storage.DeviceInventory
+	for _, id := range ids {
+		inv, err := s.newEnrollment(id).readInventory()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		invs = append(invs, inv)
+	}
+	sort.Slice(invs, func(i, j int) bool { return invs[i].ID < invs[j].ID })
+	return invs, nil
+}
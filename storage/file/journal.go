@@ -0,0 +1,266 @@
+package file
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QueueJournalFilename is the journal of an enrollment's queue under
+// the enrollment's directory. Each line is the queue subdirectory a
+// command was written or moved to and the command UUID. It records the
+// order of the queue so that retrieving the next command does not need
+// to scan the queue directories.
+const QueueJournalFilename = "Queue.journal"
+
+// queueIndex is the in-memory order of the commands in an enrollment's
+// queue and NotNow queue as replayed from its queue journal. Commands
+// in other queues (done or inactive) are not tracked.
+type queueIndex struct {
+	mu    sync.Mutex
+	order []string          // command UUIDs in queue order
+	subs  map[string]string // command UUID to queue subdirectory
+	// number of lines in the journal
+	journaled int
+}
+
+// tracked reports whether the queue subdirectory sub is tracked.
+func tracked(sub string) bool {
+	return sub == subQueue || sub == subNotNow
+}
+
+func (qi *queueIndex) set(uuid, sub string) {
+	_, ok := qi.subs[uuid]
+	if !tracked(sub) {
+		if ok {
+			delete(qi.subs, uuid)
+			for i := range qi.order {
+				if qi.order[i] == uuid {
+					qi.order = append(qi.order[:i], qi.order[i+1:]...)
+					break
+				}
+			}
+		}
+		return
+	}
+	if !ok {
+		qi.order = append(qi.order, uuid)
+	}
+	qi.subs[uuid] = sub
+}
+
+// queued returns the command UUIDs in queue subdirectory sub in order.
+func (qi *queueIndex) queued(sub string) []string {
+	var uuids []string
+	for _, uuid := range qi.order {
+		if qi.subs[uuid] == sub {
+			uuids = append(uuids, uuid)
+		}
+	}
+	return uuids
+}
+
+// queueIndex returns the (loaded) queue index of the enrollment locked.
+// It must be unlocked by the caller.
+func (e *enrollment) queueIndex() (*queueIndex, error) {
+	s := e.fs
+	s.queuesMu.Lock()
+	qi, ok := s.queues[e.id]
+	if !ok {
+		qi = &queueIndex{subs: make(map[string]string)}
+		s.queues[e.id] = qi
+	}
+	qi.mu.Lock()
+	s.queuesMu.Unlock()
+	if ok {
+		return qi, nil
+	}
+	if err := e.loadQueueIndex(qi); err != nil {
+		qi.mu.Unlock()
+		s.queuesMu.Lock()
+		delete(s.queues, e.id)
+		s.queuesMu.Unlock()
+		return nil, err
+	}
+	return qi, nil
+}
+
+// forgetQueueIndex drops the queue index of the enrollment (e.g. after
+// its queues were removed).
+func (e *enrollment) forgetQueueIndex() {
+	e.fs.queuesMu.Lock()
+	delete(e.fs.queues, e.id)
+	e.fs.queuesMu.Unlock()
+}
+
+// loadQueueIndex replays the queue journal into qi and reconciles it
+// with the queue directories. Commands missing from the journal (e.g.
+// from a crash between writing a command and journaling it, or queued
+// before journaling) are added in the order they were enqueued and
+// journaled commands that no longer exist are dropped.
+func (e *enrollment) loadQueueIndex(qi *queueIndex) error {
+	for _, dir := range []string{e.dir(), e.dirPrefix(subQueue), e.dirPrefix(subNotNow), e.dirPrefix(DeliveryPathname)} {
+		e.fs.removeTempFiles(dir)
+	}
+	journal := &queueIndex{subs: make(map[string]string)}
+	journalExists, err := e.replayQueueJournal(journal)
+	if err != nil {
+		return err
+	}
+	onDisk, err := e.scanQueues()
+	if err != nil {
+		return err
+	}
+	subs := make(map[string]string)
+	for _, c := range onDisk {
+		subs[c.uuid] = c.sub
+	}
+	var mismatched int
+	for _, uuid := range journal.order {
+		sub, ok := subs[uuid]
+		if !ok || sub != journal.subs[uuid] {
+			mismatched++
+		}
+		if ok {
+			qi.set(uuid, sub)
+		}
+	}
+	for _, c := range onDisk {
+		if _, ok := qi.subs[c.uuid]; !ok {
+			qi.set(c.uuid, c.sub)
+			mismatched++
+		}
+	}
+	qi.journaled = journal.journaled
+	if !journalExists || mismatched > 0 {
+		if journalExists {
+			e.fs.logger.Info("msg", "repairing queue journal", "id", e.id, "mismatched", mismatched)
+		}
+		return e.compactQueueJournal(qi)
+	}
+	return nil
+}
+
+// replayQueueJournal replays the queue journal into qi.
+func (e *enrollment) replayQueueJournal(qi *queueIndex) (bool, error) {
+	f, err := os.Open(e.dirPrefix(QueueJournalFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		qi.journaled++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			// e.g. a partially written last line from a crash
+			continue
+		}
+		qi.set(fields[1], fields[0])
+	}
+	return true, scanner.Err()
+}
+
+type queuedCommand struct {
+	uuid, sub string
+}
+
+// scanQueues returns the commands in the queue directories ordered by
+// when they were enqueued.
+func (e *enrollment) scanQueues() ([]queuedCommand, error) {
+	var commands []queuedCommand
+	var times []time.Time
+	for _, sub := range []string{subQueue, subNotNow} {
+		entries, err := os.ReadDir(e.dirPrefix(sub))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if !strings.HasSuffix(name, ".plist") || strings.HasSuffix(name, ".result.plist") {
+				continue
+			}
+			c := queuedCommand{uuid: strings.TrimSuffix(name, ".plist"), sub: sub}
+			var at time.Time
+			if d, err := e.readDelivery(c.uuid); err == nil {
+				at = d.EnqueuedAt
+			} else if fi, err := entry.Info(); err == nil {
+				at = fi.ModTime()
+			}
+			commands = append(commands, c)
+			times = append(times, at)
+		}
+	}
+	sort.Stable(byTime{commands, times})
+	return commands, nil
+}
+
+type byTime struct {
+	commands []queuedCommand
+	times    []time.Time
+}
+
+func (b byTime) Len() int           { return len(b.commands) }
+func (b byTime) Less(i, j int) bool { return b.times[i].Before(b.times[j]) }
+func (b byTime) Swap(i, j int) {
+	b.commands[i], b.commands[j] = b.commands[j], b.commands[i]
+	b.times[i], b.times[j] = b.times[j], b.times[i]
+}
+
+// compactQueueJournal rewrites the queue journal with only the commands
+// in qi.
+func (e *enrollment) compactQueueJournal(qi *queueIndex) error {
+	if len(qi.order) < 1 {
+		qi.journaled = 0
+		err := os.Remove(e.dirPrefix(QueueJournalFilename))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	var b strings.Builder
+	for _, uuid := range qi.order {
+		b.WriteString(qi.subs[uuid] + " " + uuid + "\n")
+	}
+	if err := e.mkdir(); err != nil {
+		return err
+	}
+	qi.journaled = len(qi.order)
+	return e.fs.writeFile(e.dirPrefix(QueueJournalFilename), []byte(b.String()), 0644)
+}
+
+// journalQueue records that command uuid was written or moved to queue
+// subdirectory sub. The lock of qi must be held.
+func (e *enrollment) journalQueue(qi *queueIndex, uuid, sub string) error {
+	qi.set(uuid, sub)
+	if len(qi.order) < 1 && qi.journaled > 0 {
+		// nothing queued so start over
+		return e.compactQueueJournal(qi)
+	}
+	name := e.dirPrefix(QueueJournalFilename)
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(sub + " " + uuid + "\n")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	e.fs.syncer.add(name)
+	qi.journaled++
+	if qi.journaled > 64 && qi.journaled > 4*len(qi.order) {
+		return e.compactQueueJournal(qi)
+	}
+	return nil
+}
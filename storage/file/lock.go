@@ -0,0 +1,100 @@
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// LockFilename is the lock file of the storage directory. It is locked
+// by the process using the directory and contains its process ID.
+const LockFilename = "LOCK"
+
+// ErrLocked is returned when the storage directory is locked by
+// another process.
+var ErrLocked = errors.New("storage directory locked by another process")
+
+// lockDir locks the storage directory for this process. As the
+// enrollment index and queue journals are cached in memory only one
+// process may use a storage directory at a time.
+func (s *FileStorage) lockDir() error {
+	name := path.Join(s.path, LockFilename)
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if err = lockFile(f); err != nil {
+		f.Close()
+		if errors.Is(err, ErrLocked) {
+			pid, _ := os.ReadFile(name)
+			return fmt.Errorf("%w: %s (pid %s)", ErrLocked, s.path, pid)
+		}
+		return err
+	}
+	if err = f.Truncate(0); err == nil {
+		_, err = f.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.lockFile = f
+	return nil
+}
+
+// Close syncs any unsynced files and unlocks the storage directory.
+func (s *FileStorage) Close() error {
+	err := s.syncer.stop()
+	if s.lockFile != nil {
+		if closeErr := s.lockFile.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// enrollmentLock returns the lock serializing the read-modify-write
+// file operations of enrollment id within this process.
+func (s *FileStorage) enrollmentLock(id string) *sync.Mutex {
+	s.locksMu.Lock()
+	defer s.locksMu.Unlock()
+	mu, ok := s.locks[id]
+	if !ok {
+		mu = new(sync.Mutex)
+		s.locks[id] = mu
+	}
+	return mu
+}
+
+// isCorrupt reports whether err is from decoding a corrupt JSON file.
+func isCorrupt(err error) bool {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	return errors.As(err, &syntaxErr) || errors.As(err, &typeErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// removeTempFiles removes the temporary files left in dir by writes
+// interrupted by a crash.
+func (s *FileStorage) removeTempFiles(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), tmpExt) {
+			continue
+		}
+		name := path.Join(dir, entry.Name())
+		if err := os.Remove(name); err != nil {
+			s.logger.Info("msg", "removing temporary file", "name", name, "err", err)
+		} else {
+			s.logger.Debug("msg", "removed temporary file", "name", name)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package file
+
+import "os"
+
+// lockFile is a no-op on platforms without flock. Care must be taken
+// to only run a single process per storage directory.
+func lockFile(_ *os.File) error {
+	return nil
+}
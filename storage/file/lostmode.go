@@ -0,0 +1,48 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+const LostModeFilename = "LostMode.json"
+
+// StoreLostMode writes the enrollment's Lost Mode state file.
+func (s *FileStorage) StoreLostMode(_ context.Context, state *storage.LostModeState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return s.newEnrollment(state.ID).writeFile(LostModeFilename, b)
+}
+
+// RetrieveLostMode reads the Lost Mode state files of ids (or all enrollments).
+func (s *FileStorage) RetrieveLostMode(_ context.Context, ids []string) ([]*storage.LostModeState, error) {
+	if len(ids) < 1 {
+		var err error
+		if ids, err = s.enrollmentIDs(); err != nil {
+			return nil, err
+		}
+	}
+	var states []*storage.LostModeState
+	for _, id := range ids {
+		b, err := s.newEnrollment(id).readFile(LostModeFilename)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		state := new(storage.LostModeState)
+		if err = json.Unmarshal(b, state); err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].ID < states[j].ID })
+	return states, nil
+}
@@ -0,0 +1,51 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+const OSUpdateFilename = "OSUpdate.json"
+
+// StoreOSUpdateState writes the enrollment's OS update state file.
+func (s *FileStorage) StoreOSUpdateState(_ context.Context, state *storage.OSUpdateState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return s.newEnrollment(state.ID).writeFile(OSUpdateFilename, b)
+}
+
+// RetrieveOSUpdateStates reads the OS update state files of ids (or all enrollments).
+func (s *FileStorage) RetrieveOSUpdateStates(_ context.Context, ids []string, cohort string) ([]*storage.OSUpdateState, error) {
+	if len(ids) < 1 {
+		var err error
+		if ids, err = s.enrollmentIDs(); err != nil {
+			return nil, err
+		}
+	}
+	var states []*storage.OSUpdateState
+	for _, id := range ids {
+		b, err := s.newEnrollment(id).readFile(OSUpdateFilename)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		state := new(storage.OSUpdateState)
+		if err = json.Unmarshal(b, state); err != nil {
+			return nil, err
+		}
+		if cohort != "" && state.Cohort != cohort {
+			continue
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].ID < states[j].ID })
+	return states, nil
+}
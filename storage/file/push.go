@@ -7,10 +7,24 @@ import (
 	"github.com/jessepeterson/nanomdm/mdm"
 )
 
-// RetrievePushInfo retrieves APNs-related data for push notifications
+// RetrievePushInfo retrieves APNs-related data for push notifications.
+// Soft-deleted enrollments that have not re-enrolled are skipped.
 func (s *FileStorage) RetrievePushInfo(_ context.Context, ids []string) (map[string]*mdm.Push, error) {
 	pushInfos := make(map[string]*mdm.Push)
 	for _, id := range ids {
+		if entry, ok := s.index.get(id); ok {
+			if entry.removed() {
+				continue
+			}
+			push, err := entry.pushInfo()
+			if err != nil {
+				return nil, err
+			}
+			if push != nil {
+				pushInfos[id] = push
+				continue
+			}
+		}
 		e := s.newEnrollment(id)
 		tokenUpdate, err := e.readFile(TokenUpdateFilename)
 		if err != nil {
@@ -4,11 +4,15 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 
 	"github.com/jessepeterson/nanomdm/cryptoutil"
+	"github.com/jessepeterson/nanomdm/storage"
 )
 
 // RetrievePushCert is passed through to a new PushCertFileStorage
@@ -16,6 +20,7 @@ func (s *FileStorage) RetrievePushCert(ctx context.Context, topic string) (*tls.
 	ps := &PushCertFileStorage{
 		certFilepath: path.Join(s.path, topic+".pem"),
 		keyFilepath:  path.Join(s.path, topic+".key"),
+		envelope:     s.envelope,
 	}
 	return ps.RetrievePushCert(ctx, topic)
 }
@@ -38,6 +43,7 @@ func (s *FileStorage) StorePushCert(ctx context.Context, pemCert, pemKey []byte)
 		certFilepath: path.Join(s.path, topic+".pem"),
 		keyFilepath:  path.Join(s.path, topic+".key"),
 		allowStore:   true,
+		envelope:     s.envelope,
 	}
 	return ps.StorePushCert(ctx, pemCert, pemKey)
 }
@@ -47,6 +53,7 @@ type PushCertFileStorage struct {
 	certFilepath string
 	keyFilepath  string
 	allowStore   bool
+	envelope     *cryptoutil.Envelope // encrypts the private key if set
 }
 
 func NewPushCertFileStorage(certPath, keyPath string) *PushCertFileStorage {
@@ -62,9 +69,11 @@ func (s *PushCertFileStorage) getPushCertStaleToken(filename string) (string, er
 }
 
 // RetrievePushCert reads the Push Certificate from disk
-func (s *PushCertFileStorage) RetrievePushCert(_ context.Context, topic string) (*tls.Certificate, string, error) {
+func (s *PushCertFileStorage) RetrievePushCert(ctx context.Context, topic string) (*tls.Certificate, string, error) {
 	pemCert, err := ioutil.ReadFile(s.certFilepath)
-	if err != nil {
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, "", fmt.Errorf("%w: push cert of topic %s", storage.ErrNotFound, topic)
+	} else if err != nil {
 		return nil, "", err
 	}
 	certTopic, err := cryptoutil.TopicFromPEMCert(pemCert)
@@ -78,7 +87,10 @@ func (s *PushCertFileStorage) RetrievePushCert(_ context.Context, topic string)
 	if err != nil {
 		return nil, "", err
 	}
-	cert, err := tls.X509KeyPair(pemCert, pemKey)
+	if pemKey, err = decrypt(s.envelope, s.keyFilepath, pemKey); err != nil {
+		return nil, "", fmt.Errorf("decrypting push key: %w", err)
+	}
+	cert, err := cryptoutil.X509KeyPair(ctx, pemCert, pemKey)
 	if err != nil {
 		return nil, "", err
 	}
@@ -100,9 +112,53 @@ func (s *PushCertFileStorage) StorePushCert(_ context.Context, pemCert, pemKey [
 	if !s.allowStore {
 		return errors.New("store push cert: not permitted")
 	}
-	err := ioutil.WriteFile(s.certFilepath, pemCert, 0644)
+	pemKey, err := encrypt(s.envelope, s.keyFilepath, pemKey)
 	if err != nil {
+		return fmt.Errorf("encrypting push key: %w", err)
+	}
+	if err = ioutil.WriteFile(s.certFilepath, pemCert, 0644); err != nil {
 		return err
 	}
 	return ioutil.WriteFile(s.keyFilepath, pemKey, 0600)
 }
+
+// ListPushCerts reads the push certificates of all topics from disk.
+func (s *FileStorage) ListPushCerts(_ context.Context) (map[string][]byte, error) {
+	matches, err := filepath.Glob(filepath.Join(s.path, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	certs := make(map[string][]byte)
+	for _, name := range matches {
+		pemCert, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		// skip any other PEM files that aren't push certificates of
+		// their filename's topic
+		topic, err := cryptoutil.TopicFromPEMCert(pemCert)
+		if err != nil || topic+".pem" != filepath.Base(name) {
+			continue
+		}
+		certs[topic] = pemCert
+	}
+	return certs, nil
+}
+
+// DeletePushCert removes the push certificate and key of topic from disk.
+func (s *FileStorage) DeletePushCert(_ context.Context, topic string) error {
+	if topic == "" || strings.ContainsAny(topic, `/\`) {
+		return fmt.Errorf("invalid topic: %q", topic)
+	}
+	err := os.Remove(path.Join(s.path, topic+".pem"))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: push cert of topic %s", storage.ErrNotFound, topic)
+	} else if err != nil {
+		return err
+	}
+	err = os.Remove(path.Join(s.path, topic+".key"))
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	return err
+}
@@ -1,13 +1,17 @@
 package file
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
 )
 
 const (
@@ -38,62 +42,144 @@ func (q *queue) mkdir() error {
 }
 
 func (q *queue) enqueue(uuid string, raw []byte) error {
-	err := q.mkdir()
+	qi, err := q.e.queueIndex()
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(
+	defer qi.mu.Unlock()
+	if err = q.mkdir(); err != nil {
+		return err
+	}
+	err = q.e.fs.writeFile(
 		path.Join(q.dir(), uuid+".plist"),
 		raw,
 		0755,
 	)
+	if err != nil {
+		return err
+	}
+	return q.e.journalQueue(qi, uuid, q.sub)
 }
 
 func (q *queue) move(uuid string, dest *queue) error {
-	err := dest.mkdir()
+	qi, err := q.e.queueIndex()
 	if err != nil {
 		return err
 	}
-	return os.Rename(
-		path.Join(q.dir(), uuid+".plist"),
-		path.Join(dest.dir(), uuid+".plist"),
-	)
+	defer qi.mu.Unlock()
+	if err = dest.mkdir(); err != nil {
+		return err
+	}
+	src, dst := path.Join(q.dir(), uuid+".plist"), path.Join(dest.dir(), uuid+".plist")
+	if err = os.Rename(src, dst); err != nil {
+		return err
+	}
+	q.e.fs.syncer.add(src)
+	q.e.fs.syncer.add(dst)
+	return q.e.journalQueue(qi, uuid, dest.sub)
+}
+
+func (q *queue) exists(uuid string) bool {
+	_, err := os.Stat(path.Join(q.dir(), uuid+".plist"))
+	return err == nil
+}
+
+// hasCommand reports whether command uuid is in any of the queues of e.
+func (e *enrollment) hasCommand(uuid string) bool {
+	for _, q := range []*queue{e.newQueue(subQueue), e.newQueue(subNotNow), e.newQueue(subDone), e.newQueue(subInactive)} {
+		if q.exists(uuid) {
+			return true
+		}
+	}
+	return false
+}
+
+// resultsFilename returns the filename of the command uuid's results.
+func (q *queue) resultsFilename(uuid string) string {
+	return path.Join(q.dir(), uuid+".result.plist")
 }
 
 func (q *queue) writeResults(uuid string, raw []byte) error {
-	return os.WriteFile(
-		path.Join(q.dir(), uuid+".result.plist"),
+	return q.e.fs.writeFile(
+		q.resultsFilename(uuid),
 		raw,
 		0755,
 	)
 }
 
+// getNext returns the first command in the queue from the queue journal.
 func (q *queue) getNext() (*mdm.Command, error) {
-	entries, err := os.ReadDir(q.dir())
-	if err != nil && !errors.Is(err, os.ErrNotExist) {
+	cmds, err := q.getNextN(1)
+	if err != nil || len(cmds) < 1 {
+		return nil, err
+	}
+	return cmds[0], nil
+}
+
+// getNextN returns up to n of the first commands in the queue from the
+// queue journal.
+func (q *queue) getNextN(n int) ([]*mdm.Command, error) {
+	qi, err := q.e.queueIndex()
+	if err != nil {
 		return nil, err
 	}
-	for _, entry := range entries {
-		if !strings.HasSuffix(entry.Name(), ".plist") {
+	defer qi.mu.Unlock()
+	var cmds []*mdm.Command
+	var missing []string
+	for _, uuid := range qi.queued(q.sub) {
+		if len(cmds) >= n {
+			break
+		}
+		raw, err := q.e.fs.readFile(path.Join(q.dir(), uuid+".plist"))
+		if errors.Is(err, os.ErrNotExist) {
+			// removed from outside the journal
+			missing = append(missing, uuid)
 			continue
+		} else if err != nil {
+			return nil, err
 		}
-		raw, err := os.ReadFile(path.Join(q.dir(), entry.Name()))
+		cmd, err := mdm.DecodeCommand(raw)
 		if err != nil {
 			return nil, err
 		}
-		return mdm.DecodeCommand(raw)
+		cmds = append(cmds, cmd)
 	}
-	return nil, nil
+	for _, uuid := range missing {
+		qi.set(uuid, "")
+	}
+	return cmds, nil
 }
 
 // EnqueueCommand writes the command to disk in the queue directory
 func (s *FileStorage) EnqueueCommand(_ context.Context, ids []string, command *mdm.Command) (map[string]error, error) {
 	idErrs := make(map[string]error)
 	for _, id := range ids {
+		if entry, ok := s.index.get(id); !ok {
+			idErrs[id] = fmt.Errorf("%w: enrollment %s", storage.ErrNotFound, id)
+			continue
+		} else if entry.Disabled {
+			idErrs[id] = fmt.Errorf("%w: %s", storage.ErrDisabledEnrollment, id)
+			continue
+		}
 		e := s.newEnrollment(id)
+		if e.hasCommand(command.CommandUUID) {
+			idErrs[id] = fmt.Errorf("%w: duplicate command UUID: %s", storage.ErrConflict, command.CommandUUID)
+			continue
+		}
 		q := e.newQueue(subQueue)
 		if err := q.enqueue(command.CommandUUID, command.Raw); err != nil {
 			idErrs[id] = err
+			continue
+		}
+		err := e.writeDelivery(&storage.CommandDelivery{
+			ID:          id,
+			CommandUUID: command.CommandUUID,
+			RequestType: command.Command.RequestType,
+			Active:      true,
+			EnqueuedAt:  time.Now(),
+		})
+		if err != nil {
+			idErrs[id] = err
 		}
 	}
 	return idErrs, nil
@@ -101,38 +187,113 @@ func (s *FileStorage) EnqueueCommand(_ context.Context, ids []string, command *m
 
 // StoreCommandReport moves commands to different queues (like NotNow)
 func (s *FileStorage) StoreCommandReport(r *mdm.Request, report *mdm.CommandResults) error {
-	if report.Status == "Idle" {
-		return nil
-	}
 	e := s.newEnrollment(r.ID)
-	q := e.newQueue(subQueue)
+	if err := e.writeLastSeen(); err != nil || report.Status == "Idle" {
+		return err
+	}
+	if dup, err := e.duplicateReport(report); err != nil {
+		return err
+	} else if dup {
+		return fmt.Errorf("%w: %s", storage.ErrDuplicateReport, report.CommandUUID)
+	}
 	dest := e.newQueue(subDone)
 	if report.Status == "NotNow" {
+		dest = e.newQueue(subNotNow)
+	}
+	// the command is in the NotNow queue if it was previously NotNow'd
+	q := e.newQueue(subQueue)
+	if !q.exists(report.CommandUUID) {
 		q = e.newQueue(subNotNow)
 	}
-	err := q.move(report.CommandUUID, dest)
-	if err != nil {
-		return err
+	if q.sub != dest.sub {
+		if err := q.move(report.CommandUUID, dest); err != nil {
+			return err
+		}
+	}
+	// results in the NotNow queue would be mistaken for commands
+	if dest.sub == subDone {
+		if err := dest.writeResults(report.CommandUUID, report.Raw); err != nil {
+			return err
+		}
+	}
+	return e.updateDelivery(report.CommandUUID, func(d *storage.CommandDelivery) {
+		d.Status = report.Status
+		if report.Status == "NotNow" {
+			d.LastNotNowAt = timePtr(time.Now())
+			d.NotNowCount += 1
+		} else if d.ResolvedAt == nil {
+			d.ResolvedAt = timePtr(time.Now())
+		}
+	})
+}
+
+// duplicateReport reports whether report was already stored. A NotNow
+// is only a duplicate if the command was not re-delivered since (i.e.
+// the device may NotNow it again).
+func (e *enrollment) duplicateReport(report *mdm.CommandResults) (bool, error) {
+	if report.Status == "NotNow" {
+		if !e.newQueue(subNotNow).exists(report.CommandUUID) {
+			return false, nil
+		}
+		d, err := e.readDelivery(report.CommandUUID)
+		if errors.Is(err, os.ErrNotExist) || isCorrupt(err) {
+			// without the audit trail re-deliveries can't be told apart
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		return d.Status == "NotNow" && d.LastNotNowAt != nil &&
+			(d.LastDeliveredAt == nil || !d.LastNotNowAt.Before(*d.LastDeliveredAt)), nil
+	}
+	b, err := e.fs.readFile(e.newQueue(subDone).resultsFilename(report.CommandUUID))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
 	}
-	return dest.writeResults(report.CommandUUID, report.Raw)
+	return bytes.Equal(b, report.Raw), nil
 }
 
-// RetrieveNextCommand gets the next command from the queue while minding NotNow status
+// RetrieveNextCommand gets the next command from the queue while minding
+// NotNow status and records its delivery.
 func (s *FileStorage) RetrieveNextCommand(r *mdm.Request, skipNotNow bool) (*mdm.Command, error) {
+	cmds, err := s.RetrieveNextCommands(r, skipNotNow, 1)
+	if err != nil || len(cmds) < 1 {
+		return nil, err
+	}
+	return cmds[0], s.StoreCommandDelivered(r, cmds[0].CommandUUID)
+}
+
+// RetrieveNextCommands gets up to limit of the next commands from the
+// NotNow queue (unless skipNotNow) and then the queue.
+func (s *FileStorage) RetrieveNextCommands(r *mdm.Request, skipNotNow bool, limit int) ([]*mdm.Command, error) {
 	e := s.newEnrollment(r.ID)
-	var q *queue
+	var cmds []*mdm.Command
 	if !skipNotNow {
-		q = e.newQueue(subNotNow)
-		raw, err := q.getNext()
-		if err != nil {
-			return raw, err
-		}
-		if raw != nil {
-			return raw, nil
+		var err error
+		cmds, err = e.newQueue(subNotNow).getNextN(limit)
+		if err != nil || len(cmds) >= limit {
+			return cmds, err
 		}
 	}
-	q = e.newQueue(subQueue)
-	return q.getNext()
+	queued, err := e.newQueue(subQueue).getNextN(limit - len(cmds))
+	if err != nil {
+		return nil, err
+	}
+	return append(cmds, queued...), nil
+}
+
+// StoreCommandDelivered records the delivery of command uuid.
+func (s *FileStorage) StoreCommandDelivered(r *mdm.Request, uuid string) error {
+	e := s.newEnrollment(r.ID)
+	return e.updateDelivery(uuid, func(d *storage.CommandDelivery) {
+		now := time.Now()
+		if d.FirstDeliveredAt == nil {
+			d.FirstDeliveredAt = &now
+		}
+		d.LastDeliveredAt = &now
+		d.DeliveryCount += 1
+	})
 }
 
 func (s *FileStorage) ClearQueue(r *mdm.Request) error {
@@ -154,6 +315,12 @@ func (s *FileStorage) ClearQueue(r *mdm.Request) error {
 				if err != nil {
 					return err
 				}
+				err = e.updateDelivery(raw.CommandUUID, func(d *storage.CommandDelivery) {
+					d.Active = false
+				})
+				if err != nil {
+					return err
+				}
 				raw, err = q.getNext()
 			}
 			if err != nil {
@@ -163,3 +330,119 @@ func (s *FileStorage) ClearQueue(r *mdm.Request) error {
 	}
 	return nil
 }
+
+// PurgeQueues deletes the queues of disabled or idle enrollments.
+func (s *FileStorage) PurgeQueues(_ context.Context, idleBefore time.Time, archive func(*storage.PurgedCommand) error) (int, error) {
+	ids, err := s.enrollmentIDs()
+	if err != nil {
+		return 0, err
+	}
+	// the queues of soft-deleted enrollments are kept for restoring
+	deleted, err := s.deletedIDs()
+	if err != nil {
+		return 0, err
+	}
+	var total int
+	for _, id := range ids {
+		e := s.newEnrollment(id)
+		_, isDeleted := deleted[e.id]
+		purge, err := e.purgeable(idleBefore, isDeleted)
+		if err != nil {
+			return total, err
+		}
+		if !purge {
+			continue
+		}
+		n, err := e.purgeQueues(archive)
+		if err != nil {
+			return total, fmt.Errorf("purging queue of %s: %w", e.id, err)
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// purgeable reports whether the enrollment is disabled (and not
+// soft-deleted) or, if idleBefore is not zero, was last seen before
+// idleBefore.
+func (e *enrollment) purgeable(idleBefore time.Time, deleted bool) (bool, error) {
+	_, err := os.Stat(e.dirPrefix(DisabledFilename))
+	if err == nil && !deleted {
+		return true, nil
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	if idleBefore.IsZero() {
+		return false, nil
+	}
+	lastSeen, err := e.readLastSeen()
+	if err != nil {
+		return false, err
+	}
+	if lastSeen == nil {
+		// fallback to the TokenUpdate for enrollments never seen since
+		// last seen tracking was added
+		fi, err := os.Stat(e.dirPrefix(TokenUpdateFilename))
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		lastSeen = timePtr(fi.ModTime())
+	}
+	return lastSeen.Before(idleBefore), nil
+}
+
+// purgeQueues archives and deletes all of the enrollment's queues,
+// command delivery audit trails, and command callbacks.
+func (e *enrollment) purgeQueues(archive func(*storage.PurgedCommand) error) (int, error) {
+	var count int
+	for _, q := range []*queue{e.newQueue(subQueue), e.newQueue(subNotNow), e.newQueue(subDone), e.newQueue(subInactive)} {
+		entries, err := os.ReadDir(q.dir())
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if !strings.HasSuffix(name, ".plist") || strings.HasSuffix(name, ".result.plist") {
+				continue
+			}
+			count++
+			if archive == nil {
+				continue
+			}
+			uuid := strings.TrimSuffix(name, ".plist")
+			c := &storage.PurgedCommand{ID: e.id, CommandUUID: uuid}
+			if c.Command, err = e.fs.readFile(path.Join(q.dir(), name)); err != nil {
+				return 0, err
+			}
+			if cmd, err := mdm.DecodeCommand(c.Command); err == nil {
+				c.RequestType = cmd.Command.RequestType
+			}
+			c.Result, err = e.fs.readFile(q.resultsFilename(uuid))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return 0, err
+			}
+			if d, err := e.readDelivery(uuid); err == nil {
+				c.Status = d.Status
+			}
+			if err = archive(c); err != nil {
+				return 0, err
+			}
+		}
+	}
+	qi, err := e.queueIndex()
+	if err != nil {
+		return 0, err
+	}
+	defer qi.mu.Unlock()
+	defer e.forgetQueueIndex()
+	for _, dir := range []string{subQueue, subNotNow, subDone, subInactive, DeliveryPathname, CallbackPathname, QueueJournalFilename} {
+		if err := os.RemoveAll(e.dirPrefix(dir)); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,98 @@
+package file
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+)
+
+// Enrollment directories are sharded into 256 subdirectories of the
+// storage path (e.g. "3f/<id>/") as tens of thousands of entries in a
+// single directory perform badly on common filesystems.
+
+// shard returns the name of the shard directory of enrollment id: the
+// first byte of the SHA-256 hash of id in hex.
+func shard(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:1])
+}
+
+// isShard reports whether name is the name of a shard directory.
+// Enrollment IDs are never this short.
+func isShard(name string) bool {
+	if len(name) != 2 {
+		return false
+	}
+	b, err := hex.DecodeString(name)
+	return err == nil && hex.EncodeToString(b) == name
+}
+
+// enrollmentIDs returns the IDs of the enrollment directories.
+func (s *FileStorage) enrollmentIDs() ([]string, error) {
+	shards, err := os.ReadDir(s.path)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, sh := range shards {
+		if !sh.IsDir() || !isShard(sh.Name()) {
+			continue
+		}
+		entries, err := os.ReadDir(path.Join(s.path, sh.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				ids = append(ids, entry.Name())
+			}
+		}
+	}
+	return ids, nil
+}
+
+// isEnrollmentDir reports whether dir is an enrollment directory, that
+// is whether it has an Authenticate or TokenUpdate check-in.
+func isEnrollmentDir(dir string) bool {
+	for _, name := range []string{AuthenticateFilename, TokenUpdateFilename} {
+		if fi, err := os.Stat(path.Join(dir, name)); err == nil && fi.Mode().IsRegular() {
+			return true
+		}
+	}
+	return false
+}
+
+// MigrateLayout moves the enrollment directories of the unsharded
+// layout (directly in the storage path dir) into their shard
+// directories. Other directories (such as those an operator keeps in
+// the storage path) are left alone. It returns the number of
+// directories moved. It is run by New so is only needed to migrate
+// storage ahead of time.
+func MigrateLayout(dir string) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	var moved int
+	for _, entry := range entries {
+		id := entry.Name()
+		if !entry.IsDir() || isShard(id) || !isEnrollmentDir(path.Join(dir, id)) {
+			continue
+		}
+		shardDir := path.Join(dir, shard(id))
+		if err = os.MkdirAll(shardDir, 0755); err != nil {
+			return moved, err
+		}
+		dst := path.Join(shardDir, id)
+		if _, err = os.Stat(dst); err == nil {
+			return moved, fmt.Errorf("migrating enrollment %s: %s exists", id, dst)
+		}
+		if err = os.Rename(path.Join(dir, id), dst); err != nil {
+			return moved, err
+		}
+		moved++
+	}
+	return moved, nil
+}
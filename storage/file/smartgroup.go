@@ -0,0 +1,92 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+const SmartGroupsFilename = "SmartGroups.json"
+
+// readSmartGroups reads the smart groups file. s.groupsMu must be held.
+func (s *FileStorage) readSmartGroups() (map[string]*storage.SmartGroup, error) {
+	groups := make(map[string]*storage.SmartGroup)
+	b, err := ioutil.ReadFile(path.Join(s.path, SmartGroupsFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return groups, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return groups, json.Unmarshal(b, &groups)
+}
+
+// updateSmartGroups reads, updates with f, and writes the smart groups
+// file.
+func (s *FileStorage) updateSmartGroups(f func(map[string]*storage.SmartGroup) error) error {
+	s.groupsMu.Lock()
+	defer s.groupsMu.Unlock()
+	groups, err := s.readSmartGroups()
+	if err != nil {
+		return err
+	}
+	if err = f(groups); err != nil {
+		return err
+	}
+	b, err := json.Marshal(groups)
+	if err != nil {
+		return err
+	}
+	return s.writeFile(path.Join(s.path, SmartGroupsFilename), b, 0644)
+}
+
+// StoreSmartGroup writes g to the smart groups file.
+func (s *FileStorage) StoreSmartGroup(_ context.Context, g *storage.SmartGroup) error {
+	return s.updateSmartGroups(func(groups map[string]*storage.SmartGroup) error {
+		stored := *g
+		stored.UpdatedAt = time.Now().UTC()
+		groups[g.Name] = &stored
+		return nil
+	})
+}
+
+// RetrieveSmartGroups reads the smart groups of names (or all).
+func (s *FileStorage) RetrieveSmartGroups(_ context.Context, names []string) ([]*storage.SmartGroup, error) {
+	s.groupsMu.Lock()
+	defer s.groupsMu.Unlock()
+	groups, err := s.readSmartGroups()
+	if err != nil {
+		return nil, err
+	}
+	if len(names) < 1 {
+		for name := range groups {
+			names = append(names, name)
+		}
+	}
+	var retrieved []*storage.SmartGroup
+	for _, name := range names {
+		if g, ok := groups[name]; ok {
+			retrieved = append(retrieved, g)
+		}
+	}
+	sort.Slice(retrieved, func(i, j int) bool { return retrieved[i].Name < retrieved[j].Name })
+	return retrieved, nil
+}
+
+// DeleteSmartGroup removes smart group name from the smart groups file.
+func (s *FileStorage) DeleteSmartGroup(_ context.Context, name string) error {
+	return s.updateSmartGroups(func(groups map[string]*storage.SmartGroup) error {
+		if _, ok := groups[name]; !ok {
+			return fmt.Errorf("%w: smart group %s", storage.ErrNotFound, name)
+		}
+		delete(groups, name)
+		return nil
+	})
+}
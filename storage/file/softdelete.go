@@ -0,0 +1,218 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// DeletedFilename is the soft-deletion record of a device channel
+// enrollment stored as JSON under the enrollment's directory.
+const DeletedFilename = "Deleted.json"
+
+type deletion struct {
+	DeletedAt      time.Time `json:"deleted_at"`
+	SubEnrollments []string  `json:"sub_enrollments,omitempty"`
+	// Pending maps enrollment IDs to the UUIDs of their pending commands.
+	Pending map[string][]string `json:"pending,omitempty"`
+}
+
+func (e *enrollment) readDeletion() (*deletion, error) {
+	b, err := e.readFile(DeletedFilename)
+	if err != nil {
+		return nil, err
+	}
+	d := new(deletion)
+	return d, json.Unmarshal(b, d)
+}
+
+// pendingCommands returns the UUIDs of the commands in the enrollment's
+// queue and NotNow queue.
+func (e *enrollment) pendingCommands() ([]string, error) {
+	var uuids []string
+	for _, q := range []*queue{e.newQueue(subQueue), e.newQueue(subNotNow)} {
+		entries, err := os.ReadDir(q.dir())
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if strings.HasSuffix(name, ".plist") && !strings.HasSuffix(name, ".result.plist") {
+				uuids = append(uuids, strings.TrimSuffix(name, ".plist"))
+			}
+		}
+	}
+	return uuids, nil
+}
+
+// SoftDelete records the device channel enrollment, its sub-enrollments,
+// and their pending commands as deleted. Records of a previous deletion
+// that was not restored are merged.
+func (s *FileStorage) SoftDelete(r *mdm.Request) error {
+	if r.ParentID != "" {
+		return errors.New("can only delete a device channel")
+	}
+	mu := s.enrollmentLock(r.ID)
+	mu.Lock()
+	defer mu.Unlock()
+	e := s.newEnrollment(r.ID)
+	d, err := e.readDeletion()
+	if errors.Is(err, os.ErrNotExist) {
+		d = &deletion{Pending: make(map[string][]string)}
+	} else if err != nil {
+		return err
+	}
+	d.DeletedAt = time.Now()
+	subIDs := e.listSubEnrollments()
+	d.SubEnrollments = mergeIDs(d.SubEnrollments, subIDs)
+	for _, id := range append(subIDs, r.ID) {
+		uuids, err := s.newEnrollment(id).pendingCommands()
+		if err != nil {
+			return err
+		}
+		if merged := mergeIDs(d.Pending[id], uuids); len(merged) > 0 {
+			d.Pending[id] = merged
+		}
+	}
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	if err = e.writeFile(DeletedFilename, b); err != nil {
+		return err
+	}
+	return s.setDeleted(append(d.SubEnrollments, r.ID), true)
+}
+
+// setDeleted sets the soft-deleted state of the index entries of ids.
+func (s *FileStorage) setDeleted(ids []string, deleted bool) error {
+	for _, id := range ids {
+		err := s.index.update(id, func(entry *indexEntry) {
+			entry.Deleted = deleted
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeIDs returns the sorted union of a and b.
+func mergeIDs(a, b []string) []string {
+	set := make(map[string]struct{})
+	for _, id := range append(a, b...) {
+		set[id] = struct{}{}
+	}
+	var ids []string
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// deletedIDs returns the set of the enrollment IDs (including
+// sub-enrollments) of all deleted enrollments.
+func (s *FileStorage) deletedIDs() (map[string]struct{}, error) {
+	deleted, err := s.RetrieveDeletedEnrollments(context.Background(), nil)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]struct{})
+	for _, d := range deleted {
+		ids[d.ID] = struct{}{}
+		for _, id := range d.SubEnrollments {
+			ids[id] = struct{}{}
+		}
+	}
+	return ids, nil
+}
+
+// RetrieveDeletedEnrollments returns the deleted enrollments of ids or
+// of all enrollments if none are given.
+func (s *FileStorage) RetrieveDeletedEnrollments(_ context.Context, ids []string) ([]*storage.DeletedEnrollment, error) {
+	if len(ids) < 1 {
+		var err error
+		if ids, err = s.enrollmentIDs(); err != nil {
+			return nil, err
+		}
+	}
+	var deleted []*storage.DeletedEnrollment
+	for _, id := range ids {
+		e := s.newEnrollment(id)
+		d, err := e.readDeletion()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		de := &storage.DeletedEnrollment{
+			ID:             id,
+			DeletedAt:      d.DeletedAt,
+			SubEnrollments: d.SubEnrollments,
+		}
+		for _, uuids := range d.Pending {
+			de.Pending += len(uuids)
+		}
+		_, err = os.Stat(e.dirPrefix(DisabledFilename))
+		de.Enabled = errors.Is(err, os.ErrNotExist)
+		deleted = append(deleted, de)
+	}
+	return deleted, nil
+}
+
+// RestoreEnrollment moves the commands pending at deletion from the
+// inactive queue back to the queue and re-associates sub-enrollments.
+func (s *FileStorage) RestoreEnrollment(_ context.Context, id string) (int, error) {
+	e := s.newEnrollment(id)
+	d, err := e.readDeletion()
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, storage.ErrNotDeleted
+	} else if err != nil {
+		return 0, err
+	}
+	if _, err = os.Stat(e.dirPrefix(DisabledFilename)); err == nil {
+		return 0, storage.ErrNotReEnrolled
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+	var count int
+	for pendingID, uuids := range d.Pending {
+		pe := s.newEnrollment(pendingID)
+		inactive := pe.newQueue(subInactive)
+		for _, uuid := range uuids {
+			if !inactive.exists(uuid) {
+				// resolved, purged, or re-enqueued since
+				continue
+			}
+			if err := inactive.move(uuid, pe.newQueue(subQueue)); err != nil {
+				return count, err
+			}
+			err := pe.updateDelivery(uuid, func(d *storage.CommandDelivery) {
+				d.Active = true
+			})
+			if err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+	for _, subID := range d.SubEnrollments {
+		if err := e.assocSubEnrollment(subID); err != nil {
+			return count, err
+		}
+	}
+	if err = os.Remove(e.dirPrefix(DeletedFilename)); err != nil {
+		return count, err
+	}
+	return count, s.setDeleted(append(d.SubEnrollments, id), false)
+}
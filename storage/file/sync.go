@@ -0,0 +1,154 @@
+package file
+
+import (
+	"errors"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/log"
+)
+
+// tmpExt is the extension of temporary files renamed into place.
+const tmpExt = ".tmp"
+
+// DefaultSyncInterval is the default interval at which written files
+// are synced to disk.
+const DefaultSyncInterval = time.Second
+
+// syncer batches the syncing (fsync) of written files and their
+// directories. Rather than syncing every write (which would limit the
+// number of check-ins per second to the number of fsyncs per second)
+// written files are synced together at an interval. Writes made within
+// the interval before a crash may be lost.
+type syncer struct {
+	mu    sync.Mutex
+	dirty map[string]struct{}
+	done  chan struct{}
+}
+
+func newSyncer() *syncer {
+	return &syncer{
+		dirty: make(map[string]struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// add marks the file name and its directory to be synced.
+func (s *syncer) add(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.dirty[name] = struct{}{}
+	s.dirty[path.Dir(name)] = struct{}{}
+	s.mu.Unlock()
+}
+
+// flush syncs the files marked to be synced.
+func (s *syncer) flush() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	dirty := s.dirty
+	s.dirty = make(map[string]struct{})
+	s.mu.Unlock()
+	var firstErr error
+	for name := range dirty {
+		if err := syncFile(name); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// run flushes every interval until stopped.
+func (s *syncer) run(interval time.Duration, logger log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		if err := s.flush(); err != nil {
+			logger.Info("msg", "syncing files", "err", err)
+		}
+	}
+}
+
+// stop stops running and flushes.
+func (s *syncer) stop() error {
+	if s == nil {
+		return nil
+	}
+	close(s.done)
+	return s.flush()
+}
+
+func syncFile(name string) error {
+	f, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		// removed or renamed since written
+		return nil
+	} else if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Sync syncs any written files that have not yet been synced to disk.
+func (s *FileStorage) Sync() error {
+	return s.syncer.flush()
+}
+
+// writeFile atomically replaces the file name by renaming a temporary
+// file so that readers (and concurrent writers) never see a partially
+// written file. The file is synced with the next batch.
+func (s *FileStorage) writeFile(name string, data []byte, perm os.FileMode) error {
+	return s.replaceFile(name, data, perm, false)
+}
+
+// writeFileSync is like writeFile but syncs the file before renaming
+// it. It is used for files that are expensive to recover.
+func (s *FileStorage) writeFileSync(name string, data []byte, perm os.FileMode) error {
+	return s.replaceFile(name, data, perm, true)
+}
+
+func (s *FileStorage) replaceFile(name string, data []byte, perm os.FileMode, sync bool) error {
+	data, err := encrypt(s.envelope, name, data)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(path.Dir(name), path.Base(name)+".*"+tmpExt)
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	err = f.Chmod(perm)
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if err == nil && sync {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	s.syncer.add(name)
+	return nil
+}
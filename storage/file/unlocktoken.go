@@ -0,0 +1,23 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"os"
+)
+
+const SealedUnlockTokenFilename = "UnlockToken.sealed"
+
+// StoreUnlockToken writes the enrollment's sealed Unlock Token file.
+func (s *FileStorage) StoreUnlockToken(_ context.Context, id string, sealedToken []byte) error {
+	return s.newEnrollment(id).writeFile(SealedUnlockTokenFilename, sealedToken)
+}
+
+// RetrieveUnlockToken reads the enrollment's sealed Unlock Token file.
+func (s *FileStorage) RetrieveUnlockToken(_ context.Context, id string) ([]byte, error) {
+	b, err := s.newEnrollment(id).readFile(SealedUnlockTokenFilename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return b, err
+}
@@ -0,0 +1,73 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+const WorkflowsFilename = "Workflows.json"
+
+// readWorkflowRuns reads the workflow runs file of e by workflow name.
+func (e *enrollment) readWorkflowRuns() (map[string]*storage.WorkflowRun, error) {
+	runs := make(map[string]*storage.WorkflowRun)
+	b, err := e.readFile(WorkflowsFilename)
+	if errors.Is(err, os.ErrNotExist) {
+		return runs, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return runs, json.Unmarshal(b, &runs)
+}
+
+// StoreWorkflowRun writes run to the enrollment's workflow runs file.
+func (s *FileStorage) StoreWorkflowRun(_ context.Context, run *storage.WorkflowRun) error {
+	mu := s.enrollmentLock(run.ID)
+	mu.Lock()
+	defer mu.Unlock()
+	e := s.newEnrollment(run.ID)
+	runs, err := e.readWorkflowRuns()
+	if err != nil {
+		return err
+	}
+	runs[run.Workflow] = run
+	b, err := json.Marshal(runs)
+	if err != nil {
+		return err
+	}
+	return e.writeFile(WorkflowsFilename, b)
+}
+
+// RetrieveWorkflowRuns reads the workflow runs files of ids (or all enrollments).
+func (s *FileStorage) RetrieveWorkflowRuns(_ context.Context, ids []string) ([]*storage.WorkflowRun, error) {
+	if len(ids) < 1 {
+		var err error
+		if ids, err = s.enrollmentIDs(); err != nil {
+			return nil, err
+		}
+	}
+	var runs []*storage.WorkflowRun
+	for _, id := range ids {
+		mu := s.enrollmentLock(id)
+		mu.Lock()
+		idRuns, err := s.newEnrollment(id).readWorkflowRuns()
+		mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		for _, run := range idRuns {
+			runs = append(runs, run)
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		if runs[i].ID != runs[j].ID {
+			return runs[i].ID < runs[j].ID
+		}
+		return runs[i].Workflow < runs[j].Workflow
+	})
+	return runs, nil
+}
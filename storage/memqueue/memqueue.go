@@ -0,0 +1,278 @@
+// Package memqueue implements an in-memory command queue storage.
+//
+// Its queues are lost on restart so it is suited to testing and to
+// deployments that re-enqueue their commands. It is also the reference
+// implementation of storage.QueueStore for other queue backends.
+package memqueue
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// entry is a command queued for an enrollment.
+type entry struct {
+	raw      []byte
+	delivery storage.CommandDelivery
+	// result is the raw command result of a final report.
+	result []byte
+}
+
+// pending reports whether the command is active and not resolved.
+func (e *entry) pending() bool {
+	return e.delivery.Active && e.delivery.ResolvedAt == nil
+}
+
+// notNow reports whether the command was last reported as NotNow.
+func (e *entry) notNow() bool {
+	return e.delivery.Status == "NotNow"
+}
+
+// MemQueue is an in-memory command queue storage.
+type MemQueue struct {
+	mu     sync.Mutex
+	queues map[string][]*entry
+	now    func() time.Time
+}
+
+// New creates a new empty in-memory command queue storage.
+func New() *MemQueue {
+	return &MemQueue{queues: make(map[string][]*entry), now: time.Now}
+}
+
+// find returns the command uuid queued for id. q.mu must be held.
+func (q *MemQueue) find(id, uuid string) *entry {
+	for _, e := range q.queues[id] {
+		if e.delivery.CommandUUID == uuid {
+			return e
+		}
+	}
+	return nil
+}
+
+// EnqueueCommand enqueues command to ids.
+func (q *MemQueue) EnqueueCommand(_ context.Context, ids []string, command *mdm.Command) (map[string]error, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	idErrs := make(map[string]error)
+	for _, id := range ids {
+		if q.find(id, command.CommandUUID) != nil {
+			idErrs[id] = fmt.Errorf("%w: duplicate command UUID: %s", storage.ErrConflict, command.CommandUUID)
+			continue
+		}
+		q.queues[id] = append(q.queues[id], &entry{
+			raw: command.Raw,
+			delivery: storage.CommandDelivery{
+				ID:          id,
+				CommandUUID: command.CommandUUID,
+				RequestType: command.Command.RequestType,
+				Active:      true,
+				EnqueuedAt:  q.now(),
+			},
+		})
+	}
+	return idErrs, nil
+}
+
+// StoreCommandReport records report in the delivery audit trail of its
+// command. Reports of unknown commands are ignored.
+func (q *MemQueue) StoreCommandReport(r *mdm.Request, report *mdm.CommandResults) error {
+	if report.Status == "Idle" {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e := q.find(r.ID, report.CommandUUID)
+	if e == nil {
+		return nil
+	}
+	d := &e.delivery
+	if duplicateReport(e, report) {
+		return fmt.Errorf("%w: %s", storage.ErrDuplicateReport, report.CommandUUID)
+	}
+	now := q.now()
+	d.Status = report.Status
+	if report.Status == "NotNow" {
+		d.LastNotNowAt = &now
+		d.NotNowCount += 1
+		return nil
+	}
+	if d.ResolvedAt == nil {
+		d.ResolvedAt = &now
+	}
+	e.result = report.Raw
+	return nil
+}
+
+// duplicateReport reports whether report was already stored. A NotNow
+// is only a duplicate if the command was not re-delivered since.
+func duplicateReport(e *entry, report *mdm.CommandResults) bool {
+	d := &e.delivery
+	if report.Status == "NotNow" {
+		return d.ResolvedAt == nil && e.notNow() && d.LastNotNowAt != nil &&
+			(d.LastDeliveredAt == nil || !d.LastNotNowAt.Before(*d.LastDeliveredAt))
+	}
+	return e.result != nil && bytes.Equal(e.result, report.Raw)
+}
+
+// RetrieveNextCommand retrieves the next command and records its
+// delivery.
+func (q *MemQueue) RetrieveNextCommand(r *mdm.Request, skipNotNow bool) (*mdm.Command, error) {
+	cmds, err := q.RetrieveNextCommands(r, skipNotNow, 1)
+	if err != nil || len(cmds) < 1 {
+		return nil, err
+	}
+	return cmds[0], q.StoreCommandDelivered(r, cmds[0].CommandUUID)
+}
+
+// RetrieveNextCommands retrieves up to limit of the next NotNow'd
+// commands (unless skipNotNow) and then queued commands.
+func (q *MemQueue) RetrieveNextCommands(r *mdm.Request, skipNotNow bool, limit int) ([]*mdm.Command, error) {
+	q.mu.Lock()
+	var raws [][]byte
+	for _, notNow := range []bool{true, false} {
+		if notNow && skipNotNow {
+			continue
+		}
+		for _, e := range q.queues[r.ID] {
+			if len(raws) >= limit {
+				break
+			}
+			if e.pending() && e.notNow() == notNow {
+				raws = append(raws, e.raw)
+			}
+		}
+	}
+	q.mu.Unlock()
+	cmds := make([]*mdm.Command, 0, len(raws))
+	for _, raw := range raws {
+		cmd, err := mdm.DecodeCommand(raw)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, cmd)
+	}
+	return cmds, nil
+}
+
+// StoreCommandDelivered records the delivery of command uuid.
+func (q *MemQueue) StoreCommandDelivered(r *mdm.Request, uuid string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e := q.find(r.ID, uuid)
+	if e == nil {
+		return nil
+	}
+	now := q.now()
+	if e.delivery.FirstDeliveredAt == nil {
+		e.delivery.FirstDeliveredAt = &now
+	}
+	e.delivery.LastDeliveredAt = &now
+	e.delivery.DeliveryCount += 1
+	return nil
+}
+
+// ClearQueue deactivates the pending commands of r.ID.
+func (q *MemQueue) ClearQueue(r *mdm.Request) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, e := range q.queues[r.ID] {
+		if e.pending() {
+			e.delivery.Active = false
+		}
+	}
+	return nil
+}
+
+// RetrieveCommandDeliveries returns the delivery audit trail of the
+// commands queued for id in queue order.
+func (q *MemQueue) RetrieveCommandDeliveries(_ context.Context, id string) ([]*storage.CommandDelivery, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	deliveries := make([]*storage.CommandDelivery, 0, len(q.queues[id]))
+	for _, e := range q.queues[id] {
+		d := e.delivery
+		deliveries = append(deliveries, &d)
+	}
+	return deliveries, nil
+}
+
+// RetrieveQueueStats returns the queue statistics of ids (or all
+// enrollments with pending commands).
+func (q *MemQueue) RetrieveQueueStats(_ context.Context, ids []string) ([]*storage.QueueStats, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	all := len(ids) < 1
+	if all {
+		for id := range q.queues {
+			ids = append(ids, id)
+		}
+	}
+	var stats []*storage.QueueStats
+	for _, id := range ids {
+		st := &storage.QueueStats{ID: id}
+		for _, e := range q.queues[id] {
+			if !e.pending() {
+				continue
+			}
+			st.Pending++
+			if st.OldestEnqueuedAt == nil || e.delivery.EnqueuedAt.Before(*st.OldestEnqueuedAt) {
+				enqueuedAt := e.delivery.EnqueuedAt
+				st.OldestEnqueuedAt = &enqueuedAt
+			}
+		}
+		if all && st.Pending < 1 {
+			continue
+		}
+		stats = append(stats, st)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ID < stats[j].ID })
+	return stats, nil
+}
+
+// CountCommands returns the command counts in buckets of width
+// interval from since until now.
+func (q *MemQueue) CountCommands(_ context.Context, since time.Time, interval time.Duration) ([]*storage.CommandCounts, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	buckets := storage.CommandCountBuckets(since, q.now(), interval)
+	for _, entries := range q.queues {
+		deliveries := make([]*storage.CommandDelivery, 0, len(entries))
+		for _, e := range entries {
+			deliveries = append(deliveries, &e.delivery)
+		}
+		storage.CountDeliveries(buckets, interval, deliveries)
+	}
+	return buckets, nil
+}
+
+// PurgeQueue archives and deletes the queue of id.
+func (q *MemQueue) PurgeQueue(_ context.Context, id string, archive func(*storage.PurgedCommand) error) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entries := q.queues[id]
+	if archive != nil {
+		for _, e := range entries {
+			err := archive(&storage.PurgedCommand{
+				ID:          id,
+				CommandUUID: e.delivery.CommandUUID,
+				RequestType: e.delivery.RequestType,
+				Status:      e.delivery.Status,
+				Command:     e.raw,
+				Result:      e.result,
+			})
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	delete(q.queues, id)
+	return len(entries), nil
+}
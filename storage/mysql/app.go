@@ -0,0 +1,117 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// StoreAppInstall upserts an application install of an enrollment.
+func (s *MySQLStorage) StoreAppInstall(ctx context.Context, inst *storage.AppInstall) error {
+	var storeID sql.NullInt64
+	if inst.ITunesStoreID != 0 {
+		storeID = sql.NullInt64{Int64: inst.ITunesStoreID, Valid: true}
+	}
+	_, err := s.db.ExecContext(
+		ctx, `
+INSERT INTO app_installs
+    (id, command_uuid, request_type, identifier, itunes_store_id, manifest_url, status, state, error)
+VALUES
+    (?, ?, ?, ?, ?, ?, ?, ?, ?) AS new
+ON DUPLICATE KEY
+UPDATE
+    identifier = new.identifier,
+    itunes_store_id = new.itunes_store_id,
+    manifest_url = new.manifest_url,
+    status = new.status,
+    state = new.state,
+    error = new.error;`,
+		inst.ID,
+		inst.CommandUUID,
+		inst.RequestType,
+		nullEmptyString(inst.Identifier),
+		storeID,
+		nullEmptyString(inst.ManifestURL),
+		nullEmptyString(inst.Status),
+		nullEmptyString(inst.State),
+		nullEmptyString(inst.Error),
+	)
+	return err
+}
+
+// RetrieveAppInstalls retrieves the application installs of ids (or all enrollments).
+func (s *MySQLStorage) RetrieveAppInstalls(ctx context.Context, ids []string) ([]*storage.AppInstall, error) {
+	query := `
+SELECT
+    id, command_uuid, request_type, identifier, itunes_store_id, manifest_url,
+    status, state, error, UNIX_TIMESTAMP(created_at), UNIX_TIMESTAMP(updated_at)
+FROM
+    app_installs`
+	var args []interface{}
+	if len(ids) > 0 {
+		query += ` WHERE id IN (?` + strings.Repeat(`, ?`, len(ids)-1) + `)`
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+	rows, err := s.db.QueryContext(ctx, query+` ORDER BY id, created_at;`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var insts []*storage.AppInstall
+	for rows.Next() {
+		inst := new(storage.AppInstall)
+		var identifier, manifestURL, status, state, instErr sql.NullString
+		var storeID sql.NullInt64
+		var created, updated int64
+		err := rows.Scan(
+			&inst.ID, &inst.CommandUUID, &inst.RequestType, &identifier, &storeID, &manifestURL,
+			&status, &state, &instErr, &created, &updated,
+		)
+		if err != nil {
+			return nil, err
+		}
+		inst.Identifier = identifier.String
+		inst.ITunesStoreID = storeID.Int64
+		inst.ManifestURL = manifestURL.String
+		inst.Status = status.String
+		inst.State = state.String
+		inst.Error = instErr.String
+		inst.CreatedAt = time.Unix(created, 0)
+		inst.UpdatedAt = time.Unix(updated, 0)
+		insts = append(insts, inst)
+	}
+	return insts, rows.Err()
+}
+
+// StoreAppManifest upserts the named application manifest.
+func (s *MySQLStorage) StoreAppManifest(ctx context.Context, name string, manifest []byte) error {
+	_, err := s.db.ExecContext(
+		ctx, `
+INSERT INTO app_manifests
+    (name, manifest)
+VALUES
+    (?, ?) AS new
+ON DUPLICATE KEY
+UPDATE
+    manifest = new.manifest;`,
+		name,
+		manifest,
+	)
+	return err
+}
+
+// RetrieveAppManifest retrieves the named application manifest.
+func (s *MySQLStorage) RetrieveAppManifest(ctx context.Context, name string) ([]byte, error) {
+	var manifest []byte
+	err := s.db.QueryRowContext(ctx, `SELECT manifest FROM app_manifests WHERE name = ?;`, name).Scan(&manifest)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return manifest, err
+}
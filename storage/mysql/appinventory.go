@@ -0,0 +1,89 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// StoreAppInventory replaces the installed applications of id.
+func (s *MySQLStorage) StoreAppInventory(ctx context.Context, id string, apps []*storage.InstalledApp) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = storeAppInventory(ctx, tx, id, apps); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("rollback error: %w; while trying to handle error: %v", rbErr, err)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+func storeAppInventory(ctx context.Context, tx *sql.Tx, id string, apps []*storage.InstalledApp) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM app_inventory WHERE id = ?;`, id)
+	if err != nil || len(apps) < 1 {
+		return err
+	}
+	query := `
+INSERT INTO app_inventory
+    (id, identifier, name, version, short_version, managed_status)
+VALUES
+    (?, ?, ?, ?, ?, ?)` + strings.Repeat(`, (?, ?, ?, ?, ?, ?)`, len(apps)-1)
+	var args []interface{}
+	for _, app := range apps {
+		args = append(
+			args,
+			id,
+			app.Identifier,
+			nullEmptyString(app.Name),
+			nullEmptyString(app.Version),
+			nullEmptyString(app.ShortVersion),
+			nullEmptyString(app.ManagedStatus),
+		)
+	}
+	_, err = tx.ExecContext(ctx, query+`;`, args...)
+	return err
+}
+
+// RetrieveAppInventory retrieves the installed applications of ids (or all enrollments).
+func (s *MySQLStorage) RetrieveAppInventory(ctx context.Context, ids []string) ([]*storage.InstalledApp, error) {
+	query := `
+SELECT
+    id, identifier, name, version, short_version, managed_status, UNIX_TIMESTAMP(updated_at)
+FROM
+    app_inventory`
+	var args []interface{}
+	if len(ids) > 0 {
+		query += ` WHERE id IN (?` + strings.Repeat(`, ?`, len(ids)-1) + `)`
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+	rows, err := s.db.QueryContext(ctx, query+` ORDER BY id, identifier;`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var apps []*storage.InstalledApp
+	for rows.Next() {
+		app := new(storage.InstalledApp)
+		var name, version, shortVersion, managedStatus sql.NullString
+		var updated int64
+		if err := rows.Scan(&app.ID, &app.Identifier, &name, &version, &shortVersion, &managedStatus, &updated); err != nil {
+			return nil, err
+		}
+		app.Name = name.String
+		app.Version = version.String
+		app.ShortVersion = shortVersion.String
+		app.ManagedStatus = managedStatus.String
+		app.UpdatedAt = time.Unix(updated, 0)
+		apps = append(apps, app)
+	}
+	return apps, rows.Err()
+}
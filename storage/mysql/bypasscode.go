@@ -0,0 +1,34 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// StoreBypassCode upserts the sealed bypass code of an enrollment.
+func (s *MySQLStorage) StoreBypassCode(ctx context.Context, id string, sealedCode []byte) error {
+	_, err := s.db.ExecContext(
+		ctx, `
+INSERT INTO bypass_codes
+    (id, sealed_code)
+VALUES
+    (?, ?) AS new
+ON DUPLICATE KEY
+UPDATE
+    sealed_code = new.sealed_code;`,
+		id,
+		sealedCode,
+	)
+	return err
+}
+
+// RetrieveBypassCode retrieves the sealed bypass code of an enrollment.
+func (s *MySQLStorage) RetrieveBypassCode(ctx context.Context, id string) ([]byte, error) {
+	var sealedCode []byte
+	err := s.db.QueryRowContext(ctx, `SELECT sealed_code FROM bypass_codes WHERE id = ?;`, id).Scan(&sealedCode)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return sealedCode, err
+}
@@ -0,0 +1,28 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// StoreCommandCallback sets the callback URL of a queued command. The
+// URL is stored with the command so is the same for all enrollments.
+func (s *MySQLStorage) StoreCommandCallback(ctx context.Context, _ []string, commandUUID, url string) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`UPDATE commands SET callback_url = ? WHERE command_uuid = ? LIMIT 1;`,
+		url, commandUUID,
+	)
+	return err
+}
+
+// RetrieveCommandCallback retrieves the callback URL of a command.
+func (s *MySQLStorage) RetrieveCommandCallback(ctx context.Context, _, commandUUID string) (string, error) {
+	var url sql.NullString
+	err := s.db.QueryRowContext(ctx, `SELECT callback_url FROM commands WHERE command_uuid = ?;`, commandUUID).Scan(&url)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return url.String, err
+}
@@ -2,40 +2,29 @@ package mysql
 
 import (
 	"context"
+	"database/sql"
 	"strings"
 
 	"github.com/jessepeterson/nanomdm/mdm"
 )
 
-// Executes SQL statements that return a single COUNT(*) of rows.
-func (s *MySQLStorage) queryRowContextRowExists(ctx context.Context, query string, args ...interface{}) (bool, error) {
+// Executes prepared statements that return a single COUNT(*) of rows.
+func queryRowContextRowExists(ctx context.Context, stmt *sql.Stmt, args ...interface{}) (bool, error) {
 	var ct int
-	err := s.db.QueryRowContext(ctx, query, args...).Scan(&ct)
+	err := stmt.QueryRowContext(ctx, args...).Scan(&ct)
 	return ct > 0, err
 }
 
 func (s *MySQLStorage) EnrollmentHasCertHash(r *mdm.Request, _ string) (bool, error) {
-	return s.queryRowContextRowExists(
-		r.Context,
-		`SELECT COUNT(*) FROM cert_auth_associations WHERE id = ?;`,
-		r.ID,
-	)
+	return queryRowContextRowExists(r.Context, s.stmts.enrollmentHasCertHash, r.ID)
 }
 
 func (s *MySQLStorage) HasCertHash(r *mdm.Request, hash string) (bool, error) {
-	return s.queryRowContextRowExists(
-		r.Context,
-		`SELECT COUNT(*) FROM cert_auth_associations WHERE sha256 = ?;`,
-		strings.ToLower(hash),
-	)
+	return queryRowContextRowExists(r.Context, s.stmts.hasCertHash, strings.ToLower(hash))
 }
 
 func (s *MySQLStorage) IsCertHashAssociated(r *mdm.Request, hash string) (bool, error) {
-	return s.queryRowContextRowExists(
-		r.Context,
-		`SELECT COUNT(*) FROM cert_auth_associations WHERE id = ? AND sha256 = ?;`,
-		r.ID, strings.ToLower(hash),
-	)
+	return queryRowContextRowExists(r.Context, s.stmts.isCertHashAssociated, r.ID, strings.ToLower(hash))
 }
 
 func (s *MySQLStorage) AssociateCertHash(r *mdm.Request, hash string) error {
@@ -0,0 +1,216 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// unixTime converts a nullable UNIX timestamp to a time.
+func unixTime(t sql.NullInt64) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	ut := time.Unix(t.Int64, 0)
+	return &ut
+}
+
+// RetrieveCommandDeliveries returns the delivery audit trail of commands queued for id.
+func (s *MySQLStorage) RetrieveCommandDeliveries(ctx context.Context, id string) ([]*storage.CommandDelivery, error) {
+	rows, err := s.db.QueryContext(
+		ctx, `
+SELECT
+    q.id,
+    q.command_uuid,
+    c.request_type,
+    r.status,
+    q.active,
+    UNIX_TIMESTAMP(q.created_at),
+    UNIX_TIMESTAMP(q.first_delivered_at),
+    UNIX_TIMESTAMP(q.last_delivered_at),
+    q.delivery_count,
+    UNIX_TIMESTAMP(q.last_not_now_at),
+    q.not_now_count,
+    UNIX_TIMESTAMP(q.resolved_at)
+FROM
+    enrollment_queue AS q
+        INNER JOIN commands AS c
+        ON q.command_uuid = c.command_uuid
+        LEFT JOIN command_results r
+        ON r.command_uuid = q.command_uuid AND r.id = q.id
+WHERE
+    q.id = ?
+ORDER BY
+    q.priority DESC,
+    q.created_at;`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var deliveries []*storage.CommandDelivery
+	for rows.Next() {
+		d := new(storage.CommandDelivery)
+		var status sql.NullString
+		var enqueued int64
+		var firstDelivered, lastDelivered, lastNotNow, resolved sql.NullInt64
+		err := rows.Scan(
+			&d.ID, &d.CommandUUID, &d.RequestType, &status, &d.Active,
+			&enqueued, &firstDelivered, &lastDelivered, &d.DeliveryCount,
+			&lastNotNow, &d.NotNowCount, &resolved,
+		)
+		if err != nil {
+			return nil, err
+		}
+		d.Status = status.String
+		d.EnqueuedAt = time.Unix(enqueued, 0)
+		d.FirstDeliveredAt = unixTime(firstDelivered)
+		d.LastDeliveredAt = unixTime(lastDelivered)
+		d.LastNotNowAt = unixTime(lastNotNow)
+		d.ResolvedAt = unixTime(resolved)
+		deliveries = append(deliveries, d)
+	}
+	if err = rows.Err(); err != nil || len(deliveries) > 0 {
+		return deliveries, err
+	}
+	var exists int
+	err = s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM enrollments WHERE id = ?;`, id).Scan(&exists)
+	if err == nil && exists < 1 {
+		err = fmt.Errorf("%w: enrollment %s", storage.ErrNotFound, id)
+	}
+	return nil, err
+}
+
+// RetrieveQueueStats returns the queue statistics of ids (or all enrollments with pending commands).
+func (s *MySQLStorage) RetrieveQueueStats(ctx context.Context, ids []string) ([]*storage.QueueStats, error) {
+	var where string
+	var args []interface{}
+	if len(ids) > 0 {
+		where = ` AND q.id IN (?` + strings.Repeat(`, ?`, len(ids)-1) + `)`
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+	rows, err := s.db.QueryContext(
+		ctx, `
+SELECT
+    q.id,
+    COUNT(*),
+    UNIX_TIMESTAMP(MIN(q.created_at))
+FROM
+    enrollment_queue AS q
+WHERE
+    q.active = 1 AND q.resolved_at IS NULL`+where+`
+GROUP BY
+    q.id
+ORDER BY
+    q.id;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	found := make(map[string]bool)
+	var stats []*storage.QueueStats
+	for rows.Next() {
+		st := new(storage.QueueStats)
+		var oldest sql.NullInt64
+		if err := rows.Scan(&st.ID, &st.Pending, &oldest); err != nil {
+			return nil, err
+		}
+		st.OldestEnqueuedAt = unixTime(oldest)
+		found[st.ID] = true
+		stats = append(stats, st)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	// explicitly requested enrollments are included without pending commands
+	for _, id := range ids {
+		if !found[id] {
+			found[id] = true
+			stats = append(stats, &storage.QueueStats{ID: id})
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ID < stats[j].ID })
+	return stats, nil
+}
+
+// CountCommands aggregates the command counts in buckets of width
+// interval (rounded down to whole seconds) from since until now.
+func (s *MySQLStorage) CountCommands(ctx context.Context, since time.Time, interval time.Duration) ([]*storage.CommandCounts, error) {
+	if interval < time.Second {
+		return nil, fmt.Errorf("interval too short: %s", interval)
+	}
+	start, width := since.Unix(), int64(interval/time.Second)
+	buckets := storage.CommandCountBuckets(time.Unix(start, 0), time.Now(), time.Duration(width)*time.Second)
+	var args []interface{}
+	for i := 0; i < 3; i++ {
+		args = append(args, start, width, start)
+	}
+	rows, err := s.db.QueryContext(
+		ctx, `
+SELECT
+    'Enqueued', FLOOR((UNIX_TIMESTAMP(q.created_at) - ?) / ?) AS bucket, COUNT(*)
+FROM
+    enrollment_queue AS q
+WHERE
+    q.created_at >= FROM_UNIXTIME(?)
+GROUP BY
+    bucket
+UNION ALL
+SELECT
+    'NotNow', FLOOR((UNIX_TIMESTAMP(q.last_not_now_at) - ?) / ?) AS bucket, COUNT(*)
+FROM
+    enrollment_queue AS q
+WHERE
+    q.last_not_now_at >= FROM_UNIXTIME(?)
+GROUP BY
+    bucket
+UNION ALL
+SELECT
+    r.status, FLOOR((UNIX_TIMESTAMP(q.resolved_at) - ?) / ?) AS bucket, COUNT(*)
+FROM
+    enrollment_queue AS q
+    INNER JOIN command_results AS r
+        ON r.id = q.id AND r.command_uuid = q.command_uuid
+WHERE
+    q.resolved_at >= FROM_UNIXTIME(?) AND
+    r.status IN ('Acknowledged', 'Error', 'CommandFormatError')
+GROUP BY
+    r.status, bucket;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var i, count int
+		if err := rows.Scan(&status, &i, &count); err != nil {
+			return nil, err
+		}
+		if i < 0 || i >= len(buckets) {
+			continue
+		}
+		switch b := buckets[i]; status {
+		case "Enqueued":
+			b.Enqueued += count
+		case "NotNow":
+			b.NotNow += count
+		case "Acknowledged":
+			b.Acknowledged += count
+		default:
+			b.Errored += count
+		}
+	}
+	return buckets, rows.Err()
+}
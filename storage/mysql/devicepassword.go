@@ -0,0 +1,102 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// StoreDevicePassword upserts a device password of an enrollment.
+func (s *MySQLStorage) StoreDevicePassword(ctx context.Context, pw *storage.DevicePassword) error {
+	var verified sql.NullBool
+	if pw.Verified != nil {
+		verified = sql.NullBool{Bool: *pw.Verified, Valid: true}
+	}
+	var rotatedAt sql.NullInt64
+	if pw.RotatedAt != nil {
+		rotatedAt = sql.NullInt64{Int64: pw.RotatedAt.Unix(), Valid: true}
+	}
+	_, err := s.db.ExecContext(
+		ctx, `
+INSERT INTO device_passwords
+    (id, kind, sealed_password, sealed_pending, command_uuid, status, verified, error, rotated_at)
+VALUES
+    (?, ?, ?, ?, ?, ?, ?, ?, FROM_UNIXTIME(?)) AS new
+ON DUPLICATE KEY
+UPDATE
+    sealed_password = new.sealed_password,
+    sealed_pending = new.sealed_pending,
+    command_uuid = new.command_uuid,
+    status = new.status,
+    verified = new.verified,
+    error = new.error,
+    rotated_at = new.rotated_at;`,
+		pw.ID,
+		pw.Kind,
+		pw.SealedPassword,
+		pw.SealedPending,
+		nullEmptyString(pw.CommandUUID),
+		pw.Status,
+		verified,
+		nullEmptyString(pw.Error),
+		rotatedAt,
+	)
+	return err
+}
+
+// RetrieveDevicePasswords retrieves the device passwords of ids (or all enrollments).
+func (s *MySQLStorage) RetrieveDevicePasswords(ctx context.Context, ids []string, kind string) ([]*storage.DevicePassword, error) {
+	query := `
+SELECT
+    id, kind, sealed_password, sealed_pending, command_uuid, status, verified, error,
+    UNIX_TIMESTAMP(rotated_at), UNIX_TIMESTAMP(updated_at)
+FROM
+    device_passwords`
+	var where []string
+	var args []interface{}
+	if len(ids) > 0 {
+		where = append(where, `id IN (?`+strings.Repeat(`, ?`, len(ids)-1)+`)`)
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+	if kind != "" {
+		where = append(where, `kind = ?`)
+		args = append(args, kind)
+	}
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	rows, err := s.db.QueryContext(ctx, query+` ORDER BY id, kind;`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var pws []*storage.DevicePassword
+	for rows.Next() {
+		pw := new(storage.DevicePassword)
+		var commandUUID, pwErr sql.NullString
+		var verified sql.NullBool
+		var rotatedAt sql.NullInt64
+		var updated int64
+		err := rows.Scan(
+			&pw.ID, &pw.Kind, &pw.SealedPassword, &pw.SealedPending, &commandUUID, &pw.Status, &verified, &pwErr,
+			&rotatedAt, &updated,
+		)
+		if err != nil {
+			return nil, err
+		}
+		pw.CommandUUID = commandUUID.String
+		pw.Error = pwErr.String
+		if verified.Valid {
+			pw.Verified = &verified.Bool
+		}
+		pw.RotatedAt = unixTime(rotatedAt)
+		pw.UpdatedAt = time.Unix(updated, 0)
+		pws = append(pws, pw)
+	}
+	return pws, rows.Err()
+}
@@ -0,0 +1,124 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// ListEnrollments lists all enrollments excluding soft-deleted
+// enrollments that have not re-enrolled.
+func (s *MySQLStorage) ListEnrollments(ctx context.Context) ([]*storage.Enrollment, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`
+SELECT
+    e.id, e.device_id, e.type, e.topic, e.enabled, UNIX_TIMESTAMP(e.last_seen_at),
+    u.user_short_name, u.user_long_name,
+    e.push_magic != '', e.awaiting_configuration, e.not_on_console
+FROM
+    enrollments AS e
+    LEFT JOIN users AS u
+        ON u.id = e.user_id AND u.device_id = e.device_id
+WHERE
+    `+notRemoved+`
+ORDER BY
+    e.device_id, e.id;`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var enrollments []*storage.Enrollment
+	for rows.Next() {
+		e := new(storage.Enrollment)
+		var lastSeen sql.NullInt64
+		var shortName, longName sql.NullString
+		if err := rows.Scan(&e.ID, &e.DeviceID, &e.Type, &e.Topic, &e.Enabled, &lastSeen, &shortName, &longName, &e.HasPushMagic, &e.AwaitingConfiguration, &e.NotOnConsole); err != nil {
+			return nil, err
+		}
+		e.LastSeenAt = unixTime(lastSeen)
+		// user channel enrollments reference their device channel
+		// enrollment by device_id
+		if e.ID != e.DeviceID {
+			e.ParentID = e.DeviceID
+		}
+		e.UserShortName, e.UserLongName = shortName.String, longName.String
+		enrollments = append(enrollments, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	storage.LinkUserChannels(enrollments)
+	return enrollments, nil
+}
+
+// EnrollmentEnabled reports whether enrollment id is enabled.
+func (s *MySQLStorage) EnrollmentEnabled(ctx context.Context, id string) (bool, error) {
+	var enabled bool
+	err := s.stmts.enrollmentEnabled.QueryRowContext(ctx, id).Scan(&enabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, storage.ErrNotFound
+	}
+	return enabled, err
+}
+
+// ListUserChannelIDs lists the enabled user channel enrollment IDs of
+// the device channel enrollments deviceIDs.
+func (s *MySQLStorage) ListUserChannelIDs(ctx context.Context, deviceIDs []string) (map[string][]string, error) {
+	if len(deviceIDs) < 1 {
+		return nil, errors.New("no ids provided")
+	}
+	args := make([]interface{}, len(deviceIDs))
+	for i, v := range deviceIDs {
+		args[i] = v
+	}
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT device_id, id FROM enrollments WHERE device_id IN (?`+strings.Repeat(", ?", len(deviceIDs)-1)+`) AND id != device_id AND enabled = 1 ORDER BY device_id, id;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make(map[string][]string)
+	for rows.Next() {
+		var deviceID, id string
+		if err := rows.Scan(&deviceID, &id); err != nil {
+			return nil, err
+		}
+		ids[deviceID] = append(ids[deviceID], id)
+	}
+	return ids, rows.Err()
+}
+
+// CountEnrollments counts the enrollments by status and type.
+func (s *MySQLStorage) CountEnrollments(ctx context.Context) (*storage.EnrollmentCounts, error) {
+	rows, err := s.db.QueryContext(
+		ctx, `
+SELECT
+    type, enabled, awaiting_configuration, COUNT(*)
+FROM
+    enrollments
+GROUP BY
+    type, enabled, awaiting_configuration;`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := &storage.EnrollmentCounts{ByType: make(map[string]int)}
+	for rows.Next() {
+		e := new(storage.Enrollment)
+		var count int
+		if err := rows.Scan(&e.Type, &e.Enabled, &e.AwaitingConfiguration, &count); err != nil {
+			return nil, err
+		}
+		counts.Add(e, count)
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,107 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+)
+
+// encrypt envelope encrypts data if an envelope is configured.
+func (s *MySQLStorage) encrypt(ctx context.Context, data, additionalData []byte) ([]byte, error) {
+	if s.envelope == nil {
+		return data, nil
+	}
+	return s.envelope.Encrypt(ctx, data, additionalData)
+}
+
+// decrypt decrypts envelope encrypted data if an envelope is configured.
+func (s *MySQLStorage) decrypt(ctx context.Context, data, additionalData []byte) ([]byte, error) {
+	if s.envelope == nil {
+		return data, nil
+	}
+	return s.envelope.Decrypt(ctx, data, additionalData)
+}
+
+// secretColumn is a sensitive column whose values are envelope
+// encrypted. The key column is used as the additional data.
+type secretColumn struct {
+	table  string
+	key    string
+	column string
+}
+
+var secretColumns = []secretColumn{
+	{"push_certs", "topic", "key_pem"},
+	{"devices", "id", "unlock_token"},
+}
+
+// RotateSecrets re-encrypts the sensitive columns that are unencrypted
+// or encrypted with a previous KEK with the primary KEK. It returns the
+// number of values re-encrypted.
+func (s *MySQLStorage) RotateSecrets(ctx context.Context) (int, error) {
+	if s.envelope == nil {
+		return 0, nil
+	}
+	var count int
+	for _, c := range secretColumns {
+		n, err := s.rotateColumn(ctx, c)
+		count += n
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+func (s *MySQLStorage) rotateColumn(ctx context.Context, c secretColumn) (int, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT `+c.key+`, `+c.column+` FROM `+c.table+` WHERE `+c.column+` IS NOT NULL;`,
+	)
+	if err != nil {
+		return 0, err
+	}
+	type row struct {
+		key   string
+		value []byte
+	}
+	var due []row
+	for rows.Next() {
+		var r row
+		if err = rows.Scan(&r.key, &r.value); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if s.envelope.NeedsRotation(r.value) {
+			due = append(due, r)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		rows.Close()
+		return 0, err
+	}
+	rows.Close()
+	var count int
+	for _, r := range due {
+		var value []byte
+		if value, err = s.envelope.Decrypt(ctx, r.value, []byte(r.key)); err != nil {
+			return count, err
+		}
+		if value, err = s.envelope.Encrypt(ctx, value, []byte(r.key)); err != nil {
+			return count, err
+		}
+		// only update if unchanged since read
+		var res sql.Result
+		res, err = s.db.ExecContext(
+			ctx,
+			`UPDATE `+c.table+` SET `+c.column+` = ? WHERE `+c.key+` = ? AND `+c.column+` = ?;`,
+			value, r.key, r.value,
+		)
+		if err != nil {
+			return count, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			count++
+		}
+	}
+	return count, nil
+}
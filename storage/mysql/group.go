@@ -0,0 +1,98 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// AddGroupMembers inserts the members ids of group name.
+func (s *MySQLStorage) AddGroupMembers(ctx context.Context, name string, ids []string) error {
+	if len(ids) < 1 {
+		return nil
+	}
+	args := make([]interface{}, 0, len(ids)*2)
+	for _, id := range ids {
+		args = append(args, name, id)
+	}
+	_, err := s.db.ExecContext(
+		ctx,
+		`INSERT IGNORE INTO enrollment_groups (name, id) VALUES (?, ?)`+strings.Repeat(`, (?, ?)`, len(ids)-1)+`;`,
+		args...,
+	)
+	return err
+}
+
+// RemoveGroupMembers deletes the members ids of group name.
+func (s *MySQLStorage) RemoveGroupMembers(ctx context.Context, name string, ids []string) error {
+	if len(ids) < 1 {
+		return nil
+	}
+	args := []interface{}{name}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	_, err := s.db.ExecContext(
+		ctx,
+		`DELETE FROM enrollment_groups WHERE name = ? AND id IN (?`+strings.Repeat(`, ?`, len(ids)-1)+`);`,
+		args...,
+	)
+	return err
+}
+
+// DeleteGroup deletes all members of group name.
+func (s *MySQLStorage) DeleteGroup(ctx context.Context, name string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM enrollment_groups WHERE name = ?;`, name)
+	if err != nil {
+		return err
+	}
+	if ct, err := result.RowsAffected(); err == nil && ct < 1 {
+		return fmt.Errorf("%w: group %s", storage.ErrNotFound, name)
+	}
+	return nil
+}
+
+// RetrieveGroupMembers selects the members of group name.
+func (s *MySQLStorage) RetrieveGroupMembers(ctx context.Context, name string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id FROM enrollment_groups WHERE name = ? ORDER BY id;`, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) < 1 {
+		return nil, fmt.Errorf("%w: group %s", storage.ErrNotFound, name)
+	}
+	return ids, nil
+}
+
+// ListGroups counts the members of the groups.
+func (s *MySQLStorage) ListGroups(ctx context.Context) (map[string]int, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT name, COUNT(*) FROM enrollment_groups GROUP BY name;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := make(map[string]int)
+	for rows.Next() {
+		var name string
+		var ct int
+		if err = rows.Scan(&name, &ct); err != nil {
+			return nil, err
+		}
+		counts[name] = ct
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,165 @@
+package mysql
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// maxConnectBackoff is the longest wait between connection attempts at
+// startup.
+const maxConnectBackoff = 30 * time.Second
+
+// DefaultHealthCheckFailures is the default number of consecutive
+// failed health checks after which the connection pool is reset.
+const DefaultHealthCheckFailures = 3
+
+// health is the state of the database health checks.
+type health struct {
+	interval time.Duration
+	failures int
+	done     chan struct{}
+
+	// accessed atomically
+	checks  uint64
+	failed  uint64
+	resets  uint64
+	healthy int32
+}
+
+// WithConnectRetry retries connecting to the database at startup with
+// exponential backoff (from one second up to 30 seconds) for up to
+// timeout. This allows starting before the database is available
+// (e.g. in orchestrated environments).
+func WithConnectRetry(timeout time.Duration) Option {
+	return func(s *MySQLStorage) {
+		s.connectRetry = timeout
+	}
+}
+
+// WithHealthCheck pings the database every interval and resets the
+// connection pool (closing its idle connections so that new ones are
+// established) after failures consecutive failed pings, and again after
+// every further failures failed pings. Zero failures uses
+// DefaultHealthCheckFailures.
+func WithHealthCheck(interval time.Duration, failures int) Option {
+	return func(s *MySQLStorage) {
+		if failures < 1 {
+			failures = DefaultHealthCheckFailures
+		}
+		s.health = &health{
+			interval: interval,
+			failures: failures,
+			done:     make(chan struct{}),
+			healthy:  1,
+		}
+	}
+}
+
+// connect pings the database, retrying for up to the connect retry
+// timeout.
+func (s *MySQLStorage) connect(ctx context.Context) error {
+	deadline := time.Now().Add(s.connectRetry)
+	backoff := time.Second
+	for {
+		err := s.db.PingContext(ctx)
+		if err == nil || time.Now().Add(backoff).After(deadline) {
+			return err
+		}
+		s.logger.Info("msg", "connecting to database", "retry_in", backoff.String(), "err", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
+
+// resetPool closes the idle connections of the connection pool.
+// In-use connections that fail are discarded by database/sql.
+func (s *MySQLStorage) resetPool() {
+	idle := s.pool.maxIdleConns
+	if idle < 1 {
+		idle = 2 // the database/sql default
+	}
+	s.db.SetMaxIdleConns(-1)
+	s.db.SetMaxIdleConns(idle)
+}
+
+// check runs a health check and returns the number of consecutive
+// failed health checks given the previous number.
+func (s *MySQLStorage) check(consecutive int) int {
+	h := s.health
+	ctx, cancel := context.WithTimeout(context.Background(), h.interval)
+	err := s.db.PingContext(ctx)
+	cancel()
+	atomic.AddUint64(&h.checks, 1)
+	if err == nil {
+		if consecutive >= h.failures {
+			s.logger.Info("msg", "database healthy again", "failures", consecutive)
+		}
+		atomic.StoreInt32(&h.healthy, 1)
+		return 0
+	}
+	atomic.AddUint64(&h.failed, 1)
+	consecutive++
+	s.logger.Info("msg", "database health check", "failures", consecutive, "err", err)
+	if consecutive%h.failures == 0 {
+		atomic.StoreInt32(&h.healthy, 0)
+		atomic.AddUint64(&h.resets, 1)
+		s.logger.Info("msg", "resetting database connection pool", "failures", consecutive)
+		s.resetPool()
+	}
+	return consecutive
+}
+
+// monitor runs health checks until the storage is closed.
+func (s *MySQLStorage) monitor() {
+	ticker := time.NewTicker(s.health.interval)
+	defer ticker.Stop()
+	var consecutive int
+	for {
+		select {
+		case <-s.health.done:
+			return
+		case <-ticker.C:
+		}
+		consecutive = s.check(consecutive)
+	}
+}
+
+// Healthy reports whether the database health checks (see
+// WithHealthCheck) have not failed persistently. Storage without
+// health checks is always healthy.
+func (s *MySQLStorage) Healthy() bool {
+	return s.health == nil || atomic.LoadInt32(&s.health.healthy) == 1
+}
+
+// HealthChecks returns the number of database health checks run.
+func (s *MySQLStorage) HealthChecks() uint64 {
+	if s.health == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&s.health.checks)
+}
+
+// HealthCheckFailures returns the number of failed database health
+// checks.
+func (s *MySQLStorage) HealthCheckFailures() uint64 {
+	if s.health == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&s.health.failed)
+}
+
+// PoolResets returns the number of connection pool resets after
+// persistently failed health checks.
+func (s *MySQLStorage) PoolResets() uint64 {
+	if s.health == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&s.health.resets)
+}
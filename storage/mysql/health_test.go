@@ -0,0 +1,59 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/log"
+)
+
+// pingConn fails pings while fail is set.
+type pingConn struct {
+	fakeConn
+	fail *int32
+}
+
+func (c pingConn) Connect(context.Context) (driver.Conn, error) { return c, nil }
+
+func (c pingConn) Ping(context.Context) error {
+	if atomic.LoadInt32(c.fail) == 1 {
+		return errors.New("database unavailable")
+	}
+	return nil
+}
+
+func TestHealthCheck(t *testing.T) {
+	fail := int32(1)
+	s := &MySQLStorage{logger: log.NopLogger, db: sql.OpenDB(pingConn{fail: &fail})}
+	defer s.db.Close()
+	WithHealthCheck(time.Second, 2)(s)
+
+	if err := s.connect(context.Background()); err == nil {
+		t.Error("expected connect error")
+	}
+
+	consecutive := s.check(0)
+	if consecutive != 1 || !s.Healthy() || s.PoolResets() != 0 {
+		t.Errorf("after one failure: consecutive=%d healthy=%v resets=%d", consecutive, s.Healthy(), s.PoolResets())
+	}
+	consecutive = s.check(consecutive)
+	if consecutive != 2 || s.Healthy() || s.PoolResets() != 1 {
+		t.Errorf("after two failures: consecutive=%d healthy=%v resets=%d", consecutive, s.Healthy(), s.PoolResets())
+	}
+	atomic.StoreInt32(&fail, 0)
+	consecutive = s.check(consecutive)
+	if consecutive != 0 || !s.Healthy() {
+		t.Errorf("after recovery: consecutive=%d healthy=%v", consecutive, s.Healthy())
+	}
+	if have, want := s.HealthChecks(), uint64(3); have != want {
+		t.Errorf("checks: have %d, want %d", have, want)
+	}
+	if have, want := s.HealthCheckFailures(), uint64(2); have != want {
+		t.Errorf("failures: have %d, want %d", have, want)
+	}
+}
@@ -0,0 +1,168 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// StoreIdentityRotation upserts the identity rotation of an enrollment.
+func (s *MySQLStorage) StoreIdentityRotation(ctx context.Context, rot *storage.IdentityRotation) error {
+	_, err := s.db.ExecContext(
+		ctx, `
+INSERT INTO identity_rotations
+    (id, state, command_uuid, cert_hash, previous_cert_hash, error, started_at)
+VALUES
+    (?, ?, ?, ?, ?, ?, FROM_UNIXTIME(?)) AS new
+ON DUPLICATE KEY
+UPDATE
+    state = new.state,
+    command_uuid = new.command_uuid,
+    cert_hash = new.cert_hash,
+    previous_cert_hash = new.previous_cert_hash,
+    error = new.error,
+    started_at = new.started_at;`,
+		rot.ID,
+		rot.State,
+		nullEmptyString(rot.CommandUUID),
+		nullEmptyString(rot.CertHash),
+		nullEmptyString(rot.PreviousCertHash),
+		nullEmptyString(rot.Error),
+		rot.StartedAt.Unix(),
+	)
+	return err
+}
+
+// UpdateIdentityRotation updates the identity rotation of an enrollment
+// if it is in state (and has the same command UUID) and replaces the
+// certificate associations of the enrollment with hash (if not empty)
+// in one transaction.
+func (s *MySQLStorage) UpdateIdentityRotation(ctx context.Context, rot *storage.IdentityRotation, state, hash string) (bool, error) {
+	var updated bool
+	_, err := s.inTx(ctx, func(tx *sql.Tx) (*mdm.Command, error) {
+		var curState string
+		var curUUID sql.NullString
+		err := tx.QueryRowContext(
+			ctx,
+			`SELECT state, command_uuid FROM identity_rotations WHERE id = ? FOR UPDATE;`,
+			rot.ID,
+		).Scan(&curState, &curUUID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if curState != state || curUUID.String != rot.CommandUUID {
+			return nil, nil
+		}
+		_, err = tx.ExecContext(
+			ctx, `
+UPDATE identity_rotations
+SET
+    state = ?,
+    cert_hash = ?,
+    previous_cert_hash = ?,
+    error = ?
+WHERE
+    id = ?;`,
+			rot.State,
+			nullEmptyString(rot.CertHash),
+			nullEmptyString(rot.PreviousCertHash),
+			nullEmptyString(rot.Error),
+			rot.ID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if hash != "" {
+			if err = replaceCertHash(ctx, tx, rot.ID, hash); err != nil {
+				return nil, err
+			}
+		}
+		updated = true
+		return nil, nil
+	})
+	return updated && err == nil, err
+}
+
+// replaceCertHash replaces the certificate associations of id with hash.
+func replaceCertHash(ctx context.Context, tx *sql.Tx, id, hash string) error {
+	hash = strings.ToLower(hash)
+	_, err := tx.ExecContext(
+		ctx,
+		`DELETE FROM cert_auth_associations WHERE id = ? AND sha256 != ?;`,
+		id, hash,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(
+		ctx, `
+INSERT INTO cert_auth_associations (id, sha256) VALUES (?, ?) AS new
+ON DUPLICATE KEY
+UPDATE sha256 = new.sha256;`,
+		id, hash,
+	)
+	return err
+}
+
+// RetrieveIdentityRotations retrieves the identity rotations of ids (or all enrollments).
+func (s *MySQLStorage) RetrieveIdentityRotations(ctx context.Context, ids []string) ([]*storage.IdentityRotation, error) {
+	query := `
+SELECT
+    id, state, command_uuid, cert_hash, previous_cert_hash, error, UNIX_TIMESTAMP(started_at), UNIX_TIMESTAMP(updated_at)
+FROM
+    identity_rotations`
+	var args []interface{}
+	if len(ids) > 0 {
+		query += ` WHERE id IN (?` + strings.Repeat(`, ?`, len(ids)-1) + `)`
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+	rows, err := s.db.QueryContext(ctx, query+` ORDER BY id;`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var rots []*storage.IdentityRotation
+	for rows.Next() {
+		rot := new(storage.IdentityRotation)
+		var commandUUID, certHash, prevCertHash, rotErr sql.NullString
+		var started, updated int64
+		err := rows.Scan(&rot.ID, &rot.State, &commandUUID, &certHash, &prevCertHash, &rotErr, &started, &updated)
+		if err != nil {
+			return nil, err
+		}
+		rot.CommandUUID = commandUUID.String
+		rot.CertHash = certHash.String
+		rot.PreviousCertHash = prevCertHash.String
+		rot.Error = rotErr.String
+		rot.StartedAt = time.Unix(started, 0)
+		rot.UpdatedAt = time.Unix(updated, 0)
+		rots = append(rots, rot)
+	}
+	return rots, rows.Err()
+}
+
+// RetrieveCertHash retrieves the most recent certificate association of id.
+func (s *MySQLStorage) RetrieveCertHash(ctx context.Context, id string) (string, error) {
+	var hash string
+	err := s.db.QueryRowContext(
+		ctx, `
+SELECT sha256 FROM cert_auth_associations
+WHERE id = ?
+ORDER BY updated_at DESC, created_at DESC
+LIMIT 1;`,
+		id,
+	).Scan(&hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return hash, err
+}
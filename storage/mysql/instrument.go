@@ -0,0 +1,210 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// instrument times and counts queries by the name of the storage
+// method running them and logs slow queries.
+type instrument struct {
+	logger log.Logger
+	slow   time.Duration
+
+	mu    sync.Mutex
+	stats map[string]*storage.QueryStats
+}
+
+// pkgPath is the import path of this package.
+var pkgPath = reflect.TypeOf(instrument{}).PkgPath()
+
+// funcLiteral matches the suffix of the names of function literals.
+var funcLiteral = regexp.MustCompile(`(\.func\d+)+$`)
+
+// queryName returns the name of the outermost function of this package
+// calling into database/sql, i.e. usually the storage method running
+// the query (e.g. RetrieveNextCommands).
+func queryName() string {
+	pc := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pc[:runtime.Callers(3, pc)])
+	var name string
+	var inSQL bool
+	for {
+		frame, more := frames.Next()
+		switch {
+		case strings.HasPrefix(frame.Function, "database/sql."):
+			inSQL = true
+		case inSQL && strings.HasPrefix(frame.Function, pkgPath+"."):
+			name = frame.Function
+		case name != "":
+			more = false
+		}
+		if !more {
+			break
+		}
+	}
+	if name == "" {
+		return "unknown"
+	}
+	name = strings.TrimPrefix(name, pkgPath+".")
+	name = strings.TrimPrefix(name, "(*MySQLStorage).")
+	return funcLiteral.ReplaceAllString(name, "")
+}
+
+// compactQuery collapses the whitespace of query for logging.
+func compactQuery(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
+
+// observe records a query started at start. Only the number of the
+// query's arguments is logged (their values may be sensitive).
+func (in *instrument) observe(query string, args int, start time.Time, err error) {
+	d := time.Since(start)
+	if errors.Is(err, driver.ErrSkip) {
+		// database/sql retries with a prepared statement
+		return
+	}
+	name := queryName()
+	slow := in.slow > 0 && d >= in.slow
+	in.mu.Lock()
+	stats, ok := in.stats[name]
+	if !ok {
+		stats = new(storage.QueryStats)
+		in.stats[name] = stats
+	}
+	stats.Count++
+	if err != nil {
+		stats.Errors++
+	}
+	if slow {
+		stats.Slow++
+	}
+	stats.TotalSeconds += d.Seconds()
+	if d.Seconds() > stats.MaxSeconds {
+		stats.MaxSeconds = d.Seconds()
+	}
+	in.mu.Unlock()
+	if slow {
+		logs := []interface{}{
+			"msg", "slow query",
+			"query_name", name,
+			"duration", d.String(),
+			"query", compactQuery(query),
+			"arg_count", args,
+		}
+		if err != nil {
+			logs = append(logs, "err", err)
+		}
+		in.logger.Info(logs...)
+	}
+}
+
+// snapshot returns a copy of the query statistics by query name.
+func (in *instrument) snapshot() map[string]storage.QueryStats {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	stats := make(map[string]storage.QueryStats, len(in.stats))
+	for name, s := range in.stats {
+		stats[name] = *s
+	}
+	return stats
+}
+
+// instrumentedConnector connects instrumented connections.
+type instrumentedConnector struct {
+	driver.Connector
+	in *instrument
+}
+
+func (c *instrumentedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedConn{Conn: conn, in: c.in}, nil
+}
+
+// instrumentedConn instruments the queries of a driver connection. The
+// MySQL driver connection implements all of the optional interfaces
+// used here.
+type instrumentedConn struct {
+	driver.Conn
+	in *instrument
+}
+
+func (c *instrumentedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	rows, err := c.Conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+	c.in.observe(query, len(args), start, err)
+	return rows, err
+}
+
+func (c *instrumentedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	result, err := c.Conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+	c.in.observe(query, len(args), start, err)
+	return result, err
+}
+
+func (c *instrumentedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmt, err := c.Conn.(driver.ConnPrepareContext).PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedStmt{Stmt: stmt, query: query, in: c.in}, nil
+}
+
+func (c *instrumentedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+func (c *instrumentedConn) Ping(ctx context.Context) error {
+	return c.Conn.(driver.Pinger).Ping(ctx)
+}
+
+func (c *instrumentedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	return c.Conn.(driver.NamedValueChecker).CheckNamedValue(nv)
+}
+
+func (c *instrumentedConn) ResetSession(ctx context.Context) error {
+	return c.Conn.(driver.SessionResetter).ResetSession(ctx)
+}
+
+func (c *instrumentedConn) IsValid() bool {
+	return c.Conn.(driver.Validator).IsValid()
+}
+
+// instrumentedStmt instruments the queries of a prepared statement.
+type instrumentedStmt struct {
+	driver.Stmt
+	query string
+	in    *instrument
+}
+
+func (s *instrumentedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	rows, err := s.Stmt.(driver.StmtQueryContext).QueryContext(ctx, args)
+	s.in.observe(s.query, len(args), start, err)
+	return rows, err
+}
+
+func (s *instrumentedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	result, err := s.Stmt.(driver.StmtExecContext).ExecContext(ctx, args)
+	s.in.observe(s.query, len(args), start, err)
+	return result, err
+}
+
+func (s *instrumentedStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	return s.Stmt.(driver.NamedValueChecker).CheckNamedValue(nv)
+}
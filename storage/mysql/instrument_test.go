@@ -0,0 +1,133 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// fakeConn executes queries (slowly if they contain "slow") without a
+// database. Queries with arguments require prepared statements like
+// the MySQL driver without interpolateParams. It implements the
+// optional interfaces of the MySQL driver.
+type fakeConn struct{}
+
+func (fakeConn) Connect(context.Context) (driver.Conn, error) { return fakeConn{}, nil }
+func (fakeConn) Driver() driver.Driver                        { return nil }
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt(query), nil }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                 { return nil, driver.ErrSkip }
+
+func (fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if len(args) > 0 {
+		return nil, driver.ErrSkip
+	}
+	return fakeStmt(query).ExecContext(context.Background(), nil)
+}
+
+func (fakeConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	return nil, driver.ErrSkip
+}
+
+func (fakeConn) PrepareContext(_ context.Context, query string) (driver.Stmt, error) {
+	return fakeStmt(query), nil
+}
+
+func (fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	return nil, driver.ErrSkip
+}
+func (fakeConn) Ping(context.Context) error               { return nil }
+func (fakeConn) CheckNamedValue(*driver.NamedValue) error { return driver.ErrSkip }
+func (fakeConn) ResetSession(context.Context) error       { return nil }
+func (fakeConn) IsValid() bool                            { return true }
+
+type fakeStmt string
+
+func (fakeStmt) Close() error  { return nil }
+func (fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), nil)
+}
+
+func (fakeStmt) Query([]driver.Value) (driver.Rows, error) { return nil, driver.ErrSkip }
+
+func (fakeStmt) QueryContext(context.Context, []driver.NamedValue) (driver.Rows, error) {
+	return nil, driver.ErrSkip
+}
+
+func (fakeStmt) CheckNamedValue(*driver.NamedValue) error { return driver.ErrSkip }
+
+func (s fakeStmt) ExecContext(context.Context, []driver.NamedValue) (driver.Result, error) {
+	if strings.Contains(string(s), "slow") {
+		time.Sleep(20 * time.Millisecond)
+	}
+	return driver.ResultNoRows, nil
+}
+
+// recordLogger records the key-value pairs of Info logs.
+type recordLogger struct {
+	mu   sync.Mutex
+	logs [][]interface{}
+}
+
+func (l *recordLogger) Info(logs ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logs = append(l.logs, logs)
+}
+
+func (l *recordLogger) Debug(...interface{})           {}
+func (l *recordLogger) With(...interface{}) log.Logger { return l }
+
+func TestQueryInstrumentation(t *testing.T) {
+	logger := new(recordLogger)
+	in := &instrument{logger: logger, slow: 10 * time.Millisecond, stats: make(map[string]*storage.QueryStats)}
+	db := sql.OpenDB(&instrumentedConnector{Connector: fakeConn{}, in: in})
+	defer db.Close()
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, "UPDATE fast"); err != nil {
+		t.Fatal(err)
+	}
+	func() {
+		if _, err := db.ExecContext(ctx, "UPDATE   slow\n WHERE id = ?", "secret"); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	stats := in.snapshot()
+	st, ok := stats["TestQueryInstrumentation"]
+	if !ok {
+		t.Fatalf("no stats for test: %v", stats)
+	}
+	if st.Count != 2 || st.Slow != 1 || st.Errors != 0 || st.MaxSeconds < 0.01 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+
+	if len(logger.logs) != 1 {
+		t.Fatalf("expected one log: %v", logger.logs)
+	}
+	logs := make(map[interface{}]interface{})
+	for i := 0; i+1 < len(logger.logs[0]); i += 2 {
+		logs[logger.logs[0][i]] = logger.logs[0][i+1]
+	}
+	if have, want := logs["query"], "UPDATE slow WHERE id = ?"; have != want {
+		t.Errorf("query: have %v, want %v", have, want)
+	}
+	if have, want := logs["arg_count"], 1; have != want {
+		t.Errorf("arg_count: have %v, want %v", have, want)
+	}
+	for _, v := range logger.logs[0] {
+		if v == "secret" {
+			t.Error("argument logged")
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// StoreInventory upserts the non-empty attributes of inv.
+func (s *MySQLStorage) StoreInventory(ctx context.Context, inv *storage.DeviceInventory) error {
+	var fileVault sql.NullBool
+	if inv.FileVaultEnabled != nil {
+		fileVault = sql.NullBool{Bool: *inv.FileVaultEnabled, Valid: true}
+	}
+	_, err := s.db.ExecContext(
+		ctx, `
+INSERT INTO inventory
+    (id, serial_number, model, model_name, product_name, device_name, os_version, build_version, filevault_enabled)
+VALUES
+    (?, ?, ?, ?, ?, ?, ?, ?, ?) AS new
+ON DUPLICATE KEY
+UPDATE
+    serial_number = COALESCE(new.serial_number, inventory.serial_number),
+    model = COALESCE(new.model, inventory.model),
+    model_name = COALESCE(new.model_name, inventory.model_name),
+    product_name = COALESCE(new.product_name, inventory.product_name),
+    device_name = COALESCE(new.device_name, inventory.device_name),
+    os_version = COALESCE(new.os_version, inventory.os_version),
+    build_version = COALESCE(new.build_version, inventory.build_version),
+    filevault_enabled = COALESCE(new.filevault_enabled, inventory.filevault_enabled);`,
+		inv.ID,
+		nullEmptyString(inv.SerialNumber),
+		nullEmptyString(inv.Model),
+		nullEmptyString(inv.ModelName),
+		nullEmptyString(inv.ProductName),
+		nullEmptyString(inv.DeviceName),
+		nullEmptyString(inv.OSVersion),
+		nullEmptyString(inv.BuildVersion),
+		fileVault,
+	)
+	return err
+}
+
+// RetrieveInventory retrieves the inventory of ids (or all enrollments).
+func (s *MySQLStorage) RetrieveInventory(ctx context.Context, ids []string) ([]*storage.DeviceInventory, error) {
+	query := `
+SELECT
+    id, serial_number, model, model_name, product_name, device_name,
+    os_version, build_version, filevault_enabled, UNIX_TIMESTAMP(updated_at)
+FROM
+    inventory`
+	var args []interface{}
+	if len(ids) > 0 {
+		query += ` WHERE id IN (?` + strings.Repeat(`, ?`, len(ids)-1) + `)`
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+	rows, err := s.db.QueryContext(ctx, query+` ORDER BY id;`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var invs []*storage.DeviceInventory
+	for rows.Next() {
+		inv := new(storage.DeviceInventory)
+		var serial, model, modelName, productName, deviceName, osVersion, buildVersion sql.NullString
+		var fileVault sql.NullBool
+		var updated int64
+		err := rows.Scan(
+			&inv.ID, &serial, &model, &modelName, &productName, &deviceName,
+			&osVersion, &buildVersion, &fileVault, &updated,
+		)
+		if err != nil {
+			return nil, err
+		}
+		inv.SerialNumber = serial.String
+		inv.Model = model.String
+		inv.ModelName = modelName.String
+		inv.ProductName = productName.String
+		inv.DeviceName = deviceName.String
+		inv.OSVersion = osVersion.String
+		inv.BuildVersion = buildVersion.String
+		if fileVault.Valid {
+			inv.FileVaultEnabled = &fileVault.Bool
+		}
+		inv.UpdatedAt = time.Unix(updated, 0)
+		invs = append(invs, inv)
+	}
+	return invs, rows.Err()
+}
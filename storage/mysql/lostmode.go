@@ -0,0 +1,109 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+func nullFloat(f sql.NullFloat64) *float64 {
+	if !f.Valid {
+		return nil
+	}
+	return &f.Float64
+}
+
+// StoreLostMode upserts the Lost Mode state of an enrollment.
+func (s *MySQLStorage) StoreLostMode(ctx context.Context, state *storage.LostModeState) error {
+	var locatedAt sql.NullInt64
+	if state.LocatedAt != nil {
+		locatedAt = sql.NullInt64{Int64: state.LocatedAt.Unix(), Valid: true}
+	}
+	_, err := s.db.ExecContext(
+		ctx, `
+INSERT INTO lost_mode
+    (id, state, message, phone_number, footnote, command_uuid, request_type, error,
+     latitude, longitude, horizontal_accuracy, located_at)
+VALUES
+    (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, FROM_UNIXTIME(?)) AS new
+ON DUPLICATE KEY
+UPDATE
+    state = new.state,
+    message = new.message,
+    phone_number = new.phone_number,
+    footnote = new.footnote,
+    command_uuid = new.command_uuid,
+    request_type = new.request_type,
+    error = new.error,
+    latitude = new.latitude,
+    longitude = new.longitude,
+    horizontal_accuracy = new.horizontal_accuracy,
+    located_at = new.located_at;`,
+		state.ID,
+		state.State,
+		nullEmptyString(state.Message),
+		nullEmptyString(state.PhoneNumber),
+		nullEmptyString(state.Footnote),
+		nullEmptyString(state.CommandUUID),
+		nullEmptyString(state.RequestType),
+		nullEmptyString(state.Error),
+		state.Latitude,
+		state.Longitude,
+		state.HorizontalAccuracy,
+		locatedAt,
+	)
+	return err
+}
+
+// RetrieveLostMode retrieves the Lost Mode states of ids (or all enrollments).
+func (s *MySQLStorage) RetrieveLostMode(ctx context.Context, ids []string) ([]*storage.LostModeState, error) {
+	query := `
+SELECT
+    id, state, message, phone_number, footnote, command_uuid, request_type, error,
+    latitude, longitude, horizontal_accuracy, UNIX_TIMESTAMP(located_at), UNIX_TIMESTAMP(updated_at)
+FROM
+    lost_mode`
+	var args []interface{}
+	if len(ids) > 0 {
+		query += ` WHERE id IN (?` + strings.Repeat(`, ?`, len(ids)-1) + `)`
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+	rows, err := s.db.QueryContext(ctx, query+` ORDER BY id;`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var states []*storage.LostModeState
+	for rows.Next() {
+		st := new(storage.LostModeState)
+		var message, phoneNumber, footnote, commandUUID, requestType, stErr sql.NullString
+		var lat, lon, acc sql.NullFloat64
+		var locatedAt sql.NullInt64
+		var updated int64
+		err := rows.Scan(
+			&st.ID, &st.State, &message, &phoneNumber, &footnote, &commandUUID, &requestType, &stErr,
+			&lat, &lon, &acc, &locatedAt, &updated,
+		)
+		if err != nil {
+			return nil, err
+		}
+		st.Message = message.String
+		st.PhoneNumber = phoneNumber.String
+		st.Footnote = footnote.String
+		st.CommandUUID = commandUUID.String
+		st.RequestType = requestType.String
+		st.Error = stErr.String
+		st.Latitude = nullFloat(lat)
+		st.Longitude = nullFloat(lon)
+		st.HorizontalAccuracy = nullFloat(acc)
+		st.LocatedAt = unixTime(locatedAt)
+		st.UpdatedAt = time.Unix(updated, 0)
+		states = append(states, st)
+	}
+	return states, rows.Err()
+}
@@ -0,0 +1,11 @@
+/* Adds the enrollment_queue dequeue index to schemas created before it
+ * was part of schema.sql. Without it retrieving the next command reads
+ * every queued row of an enrollment (including inactive ones) which
+ * degrades badly once the queue holds millions of rows.
+ *
+ * The index is built online (concurrent reads and writes are allowed)
+ * but may take a while on large tables.
+ */
+ALTER TABLE enrollment_queue
+    ADD INDEX queue_dequeue (id, active, created_at),
+    ALGORITHM=INPLACE, LOCK=NONE;
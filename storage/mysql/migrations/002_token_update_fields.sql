@@ -0,0 +1,8 @@
+/* Adds the parsed TokenUpdate columns to schemas created before they
+ * were part of schema.sql. Existing enrollments report false until
+ * their next TokenUpdate.
+ */
+ALTER TABLE enrollments
+    ADD COLUMN awaiting_configuration BOOLEAN NOT NULL DEFAULT 0,
+    ADD COLUMN not_on_console         BOOLEAN NOT NULL DEFAULT 0,
+    ADD INDEX (awaiting_configuration);
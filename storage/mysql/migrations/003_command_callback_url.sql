@@ -0,0 +1,5 @@
+/* Adds the command callback URL column to schemas created before it
+ * was part of schema.sql.
+ */
+ALTER TABLE commands
+    ADD COLUMN callback_url TEXT NULL;
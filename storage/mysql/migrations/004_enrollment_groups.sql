@@ -0,0 +1,14 @@
+/* Adds the enrollment groups table to schemas created before it was
+ * part of schema.sql.
+ */
+CREATE TABLE enrollment_groups (
+    name VARCHAR(255) NOT NULL,
+    id   VARCHAR(255) NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (name, id),
+
+    CHECK (name != ''),
+    CHECK (id != '')
+);
@@ -0,0 +1,14 @@
+/* Adds the smart groups table to schemas created before it was part
+ * of schema.sql.
+ */
+CREATE TABLE smart_groups (
+    name   VARCHAR(255) NOT NULL,
+    filter TEXT NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (name),
+
+    CHECK (name != '')
+);
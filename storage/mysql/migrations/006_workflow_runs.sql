@@ -0,0 +1,26 @@
+/* Adds the workflow runs table to schemas created before it was part
+ * of schema.sql.
+ */
+CREATE TABLE workflow_runs (
+    id       VARCHAR(255) NOT NULL,
+    workflow VARCHAR(255) NOT NULL,
+
+    state        VARCHAR(31)  NOT NULL,
+    step         INTEGER      NOT NULL DEFAULT 0,
+    command_uuid VARCHAR(127) NULL,
+    attempts     INTEGER      NOT NULL DEFAULT 0,
+    error        TEXT         NULL,
+    started_at   TIMESTAMP    NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id, workflow),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    CHECK (workflow != ''),
+    CHECK (state != '')
+);
@@ -0,0 +1,28 @@
+/* Adds the identity rotations table to schemas created before it was
+ * part of schema.sql and the previous certificate hash (restored when
+ * a presented identity fails) to the table as first created. Schemas
+ * that already have the table only need the ALTER TABLE.
+ */
+CREATE TABLE identity_rotations (
+    id VARCHAR(255) NOT NULL,
+
+    state        VARCHAR(31)  NOT NULL,
+    command_uuid VARCHAR(127) NULL,
+    cert_hash    CHAR(64)     NULL,
+    error        TEXT         NULL,
+    started_at   TIMESTAMP    NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    CHECK (state != '')
+);
+
+ALTER TABLE identity_rotations
+    ADD COLUMN previous_cert_hash CHAR(64) NULL AFTER cert_hash;
@@ -0,0 +1,11 @@
+/* Adds the command delivery audit trail columns to schemas created
+ * before they were part of schema.sql. Commands already queued report
+ * no deliveries until they are next delivered.
+ */
+ALTER TABLE enrollment_queue
+    ADD COLUMN first_delivered_at TIMESTAMP NULL,
+    ADD COLUMN last_delivered_at  TIMESTAMP NULL,
+    ADD COLUMN delivery_count     INTEGER   NOT NULL DEFAULT 0,
+    ADD COLUMN last_not_now_at    TIMESTAMP NULL,
+    ADD COLUMN not_now_count      INTEGER   NOT NULL DEFAULT 0,
+    ADD COLUMN resolved_at        TIMESTAMP NULL;
@@ -0,0 +1,6 @@
+/* Adds the enrollment last seen column to schemas created before it
+ * was part of schema.sql. Existing enrollments are not seen until
+ * their next TokenUpdate or MDM endpoint connection.
+ */
+ALTER TABLE enrollments
+    ADD COLUMN last_seen_at TIMESTAMP NULL AFTER enabled;
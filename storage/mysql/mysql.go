@@ -2,33 +2,181 @@
 package mysql
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/jessepeterson/nanomdm/cryptoutil"
 	"github.com/jessepeterson/nanomdm/log"
 	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
 
-	_ "github.com/go-sql-driver/mysql"
+	gomysql "github.com/go-sql-driver/mysql"
 )
 
 var ErrNoCert = errors.New("no certificate in MDM Request")
 
 type MySQLStorage struct {
-	logger log.Logger
-	db     *sql.DB
+	logger   log.Logger
+	db       *sql.DB
+	envelope *cryptoutil.Envelope
+	stmts    *statements
+	pool     pool
+	in       *instrument
+
+	connectRetry time.Duration
+	health       *health
+
+	// partitioned is set if the commands and command_results tables
+	// are partitioned (see partition.sql). Their uniqueness is then
+	// no longer enforced by primary keys.
+	partitioned bool
+}
+
+// pool are the connection pool settings. Zero values keep the
+// database/sql defaults.
+type pool struct {
+	maxOpenConns    int
+	maxIdleConns    int
+	connMaxLifetime time.Duration
+	connMaxIdleTime time.Duration
+}
+
+type Option func(*MySQLStorage)
+
+// WithMaxOpenConns limits the number of open database connections.
+func WithMaxOpenConns(n int) Option {
+	return func(s *MySQLStorage) {
+		s.pool.maxOpenConns = n
+	}
+}
+
+// WithMaxIdleConns sets the number of idle database connections kept
+// in the connection pool.
+func WithMaxIdleConns(n int) Option {
+	return func(s *MySQLStorage) {
+		s.pool.maxIdleConns = n
+	}
 }
 
-func New(conn string, logger log.Logger) (*MySQLStorage, error) {
-	db, err := sql.Open("mysql", conn)
+// WithConnMaxLifetime closes database connections after they have been
+// open for d. This should be less than the server's wait_timeout (and
+// that of any proxies or load balancers).
+func WithConnMaxLifetime(d time.Duration) Option {
+	return func(s *MySQLStorage) {
+		s.pool.connMaxLifetime = d
+	}
+}
+
+// WithConnMaxIdleTime closes database connections after they have been
+// idle for d.
+func WithConnMaxIdleTime(d time.Duration) Option {
+	return func(s *MySQLStorage) {
+		s.pool.connMaxIdleTime = d
+	}
+}
+
+// WithEnvelope encrypts sensitive columns (push certificate private
+// keys and Unlock Tokens) at rest using envelope encryption.
+func WithEnvelope(envelope *cryptoutil.Envelope) Option {
+	return func(s *MySQLStorage) {
+		s.envelope = envelope
+	}
+}
+
+// WithQueryInstrumentation times and counts the queries by the name
+// of the storage method running them (see QueryStats) and logs queries
+// taking at least slow (without their arguments). Zero slow logs no
+// queries.
+func WithQueryInstrumentation(slow time.Duration) Option {
+	return func(s *MySQLStorage) {
+		s.in = &instrument{slow: slow, stats: make(map[string]*storage.QueryStats)}
+	}
+}
+
+func New(conn string, logger log.Logger, opts ...Option) (*MySQLStorage, error) {
+	s := &MySQLStorage{logger: logger}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.in == nil {
+		db, err := sql.Open("mysql", conn)
+		if err != nil {
+			return nil, err
+		}
+		s.db = db
+	} else {
+		cfg, err := gomysql.ParseDSN(conn)
+		if err != nil {
+			return nil, err
+		}
+		connector, err := gomysql.NewConnector(cfg)
+		if err != nil {
+			return nil, err
+		}
+		s.in.logger = logger
+		s.db = sql.OpenDB(&instrumentedConnector{Connector: connector, in: s.in})
+	}
+	db := s.db
+	err := s.connect(context.Background())
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
-	err = db.Ping()
-	if err != nil {
+	if s.pool.maxOpenConns > 0 {
+		db.SetMaxOpenConns(s.pool.maxOpenConns)
+	}
+	if s.pool.maxIdleConns > 0 {
+		db.SetMaxIdleConns(s.pool.maxIdleConns)
+	}
+	if s.pool.connMaxLifetime > 0 {
+		db.SetConnMaxLifetime(s.pool.connMaxLifetime)
+	}
+	if s.pool.connMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(s.pool.connMaxIdleTime)
+	}
+	if err = s.prepare(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if s.partitioned, err = isPartitioned(context.Background(), db); err != nil {
+		s.Close()
 		return nil, err
 	}
-	return &MySQLStorage{db: db, logger: logger}, nil
+	if s.health != nil {
+		go s.monitor()
+	}
+	return s, nil
+}
+
+// QueryStats returns the query statistics by storage method name if
+// instrumented (see WithQueryInstrumentation).
+func (s *MySQLStorage) QueryStats() map[string]storage.QueryStats {
+	if s.in == nil {
+		return nil
+	}
+	return s.in.snapshot()
+}
+
+// DBStats returns the database connection pool statistics.
+func (s *MySQLStorage) DBStats() sql.DBStats {
+	return s.db.Stats()
+}
+
+// isPartitioned reports whether the commands or command_results tables
+// are partitioned.
+func isPartitioned(ctx context.Context, db *sql.DB) (bool, error) {
+	var ct int
+	err := db.QueryRowContext(
+		ctx,
+		`SELECT COUNT(*) FROM information_schema.partitions WHERE table_schema = DATABASE() AND table_name IN ('commands', 'command_results') AND partition_name IS NOT NULL;`,
+	).Scan(&ct)
+	if err != nil {
+		return false, fmt.Errorf("checking partitioning: %w", err)
+	}
+	return ct > 0, nil
 }
 
 // nullEmptyString returns a NULL string if s is empty.
@@ -66,8 +214,12 @@ func (s *MySQLStorage) storeDeviceTokenUpdate(r *mdm.Request, msg *mdm.TokenUpda
 	args := []interface{}{msg.Raw}
 	// separately store the Unlock Token per MDM spec
 	if len(msg.UnlockToken) > 0 {
+		unlockToken, err := s.encrypt(r.Context, msg.UnlockToken, []byte(r.ID))
+		if err != nil {
+			return err
+		}
 		query += `, unlock_token = ?, unlock_token_at = CURRENT_TIMESTAMP`
-		args = append(args, msg.UnlockToken)
+		args = append(args, unlockToken)
 	}
 	query += ` WHERE id = ? LIMIT 1;`
 	args = append(args, r.ID)
@@ -124,9 +276,9 @@ func (s *MySQLStorage) StoreTokenUpdate(r *mdm.Request, msg *mdm.TokenUpdate) er
 	_, err = s.db.ExecContext(
 		r.Context, `
 INSERT INTO enrollments
-	(id, device_id, user_id, type, topic, push_magic, token_hex)
+	(id, device_id, user_id, type, topic, push_magic, token_hex, awaiting_configuration, not_on_console, last_seen_at)
 VALUES
-	(?, ?, ?, ?, ?, ?, ?) AS new
+	(?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP) AS new
 ON DUPLICATE KEY
 UPDATE
     device_id = new.device_id,
@@ -135,6 +287,9 @@ UPDATE
     topic = new.topic,
     push_magic = new.push_magic,
     token_hex = new.token_hex,
+    awaiting_configuration = new.awaiting_configuration,
+    not_on_console = new.not_on_console,
+    last_seen_at = CURRENT_TIMESTAMP,
 	enabled = 1;`,
 		r.ID,
 		deviceId,
@@ -143,6 +298,8 @@ UPDATE
 		msg.Topic,
 		msg.PushMagic,
 		msg.Token.String(),
+		msg.AwaitingConfiguration,
+		msg.NotOnConsole,
 	)
 	return err
 }
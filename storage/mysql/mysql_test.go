@@ -0,0 +1,25 @@
+package mysql
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/log"
+	"github.com/jessepeterson/nanomdm/storage/storagetest"
+)
+
+// BenchmarkQueue benchmarks the MySQL database (with the schema
+// loaded) at the DSN of the NANOMDM_MYSQL_DSN environment variable.
+func BenchmarkQueue(b *testing.B) {
+	dsn := os.Getenv("NANOMDM_MYSQL_DSN")
+	if dsn == "" {
+		b.Skip("NANOMDM_MYSQL_DSN not set")
+	}
+	s, err := New(dsn, log.NopLogger)
+	if err != nil {
+		b.Fatal(err)
+	}
+	storagetest.BenchmarkQueue(b, s, strconv.FormatInt(time.Now().UnixNano(), 16))
+}
@@ -0,0 +1,96 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// StoreOSUpdateState upserts the OS update state of an enrollment.
+func (s *MySQLStorage) StoreOSUpdateState(ctx context.Context, state *storage.OSUpdateState) error {
+	_, err := s.db.ExecContext(
+		ctx, `
+INSERT INTO os_update_states
+    (id, cohort, product_key, product_version, install_action, command_uuid, state, device_status, percent_complete, error)
+VALUES
+    (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) AS new
+ON DUPLICATE KEY
+UPDATE
+    cohort = new.cohort,
+    product_key = new.product_key,
+    product_version = new.product_version,
+    install_action = new.install_action,
+    command_uuid = new.command_uuid,
+    state = new.state,
+    device_status = new.device_status,
+    percent_complete = new.percent_complete,
+    error = new.error;`,
+		state.ID,
+		nullEmptyString(state.Cohort),
+		nullEmptyString(state.ProductKey),
+		nullEmptyString(state.ProductVersion),
+		nullEmptyString(state.InstallAction),
+		nullEmptyString(state.CommandUUID),
+		state.State,
+		nullEmptyString(state.DeviceStatus),
+		state.PercentComplete,
+		nullEmptyString(state.Error),
+	)
+	return err
+}
+
+// RetrieveOSUpdateStates retrieves the OS update states of ids (or all enrollments).
+func (s *MySQLStorage) RetrieveOSUpdateStates(ctx context.Context, ids []string, cohort string) ([]*storage.OSUpdateState, error) {
+	query := `
+SELECT
+    id, cohort, product_key, product_version, install_action, command_uuid,
+    state, device_status, percent_complete, error, UNIX_TIMESTAMP(updated_at)
+FROM
+    os_update_states`
+	var where []string
+	var args []interface{}
+	if len(ids) > 0 {
+		where = append(where, `id IN (?`+strings.Repeat(`, ?`, len(ids)-1)+`)`)
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+	if cohort != "" {
+		where = append(where, `cohort = ?`)
+		args = append(args, cohort)
+	}
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	rows, err := s.db.QueryContext(ctx, query+` ORDER BY id;`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var states []*storage.OSUpdateState
+	for rows.Next() {
+		st := new(storage.OSUpdateState)
+		var cohort, productKey, productVersion, installAction, commandUUID, deviceStatus, stErr sql.NullString
+		var updated int64
+		err := rows.Scan(
+			&st.ID, &cohort, &productKey, &productVersion, &installAction, &commandUUID,
+			&st.State, &deviceStatus, &st.PercentComplete, &stErr, &updated,
+		)
+		if err != nil {
+			return nil, err
+		}
+		st.Cohort = cohort.String
+		st.ProductKey = productKey.String
+		st.ProductVersion = productVersion.String
+		st.InstallAction = installAction.String
+		st.CommandUUID = commandUUID.String
+		st.DeviceStatus = deviceStatus.String
+		st.Error = stErr.String
+		st.UpdatedAt = time.Unix(updated, 0)
+		states = append(states, st)
+	}
+	return states, rows.Err()
+}
@@ -0,0 +1,65 @@
+/* Optional monthly partitioning of the commands and command_results
+ * tables for large fleets. Partitions of old months can then be
+ * dropped instantly instead of deleting millions of rows.
+ *
+ * MySQL does not support foreign keys on (or referencing) partitioned
+ * tables and requires the partitioning column to be part of every
+ * unique key. So this drops the foreign keys to and from these tables
+ * and adds created_at to their primary keys. NanoMDM detects the
+ * partitioned tables at startup and then enforces unique command UUIDs
+ * and one result per enrollment and command itself. Note cascading
+ * deletes (e.g. of results when an enrollment row is deleted) no
+ * longer happen.
+ *
+ * Apply this to a schema.sql schema (with the migrations applied). The
+ * foreign key names below are the ones MySQL generates; check them
+ * with SHOW CREATE TABLE first. Adjust the months to the age of your
+ * data: rows older than the first partition boundary land in the first
+ * partition.
+ */
+ALTER TABLE enrollment_queue
+    DROP FOREIGN KEY enrollment_queue_ibfk_2;
+
+ALTER TABLE command_results
+    DROP FOREIGN KEY command_results_ibfk_1,
+    DROP FOREIGN KEY command_results_ibfk_2;
+
+ALTER TABLE commands
+    MODIFY created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    DROP PRIMARY KEY,
+    ADD PRIMARY KEY (command_uuid, created_at)
+PARTITION BY RANGE (UNIX_TIMESTAMP(created_at)) (
+    PARTITION p202610 VALUES LESS THAN (UNIX_TIMESTAMP('2026-11-01 00:00:00')),
+    PARTITION p202611 VALUES LESS THAN (UNIX_TIMESTAMP('2026-12-01 00:00:00')),
+    PARTITION p202612 VALUES LESS THAN (UNIX_TIMESTAMP('2027-01-01 00:00:00')),
+    PARTITION pmax VALUES LESS THAN MAXVALUE
+);
+
+ALTER TABLE command_results
+    MODIFY created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    DROP PRIMARY KEY,
+    ADD PRIMARY KEY (id, command_uuid, created_at)
+PARTITION BY RANGE (UNIX_TIMESTAMP(created_at)) (
+    PARTITION p202610 VALUES LESS THAN (UNIX_TIMESTAMP('2026-11-01 00:00:00')),
+    PARTITION p202611 VALUES LESS THAN (UNIX_TIMESTAMP('2026-12-01 00:00:00')),
+    PARTITION p202612 VALUES LESS THAN (UNIX_TIMESTAMP('2027-01-01 00:00:00')),
+    PARTITION pmax VALUES LESS THAN MAXVALUE
+);
+
+/* Before each month begins split its partition off of pmax (for both
+ * tables), e.g.:
+ *
+ * ALTER TABLE commands REORGANIZE PARTITION pmax INTO (
+ *     PARTITION p202701 VALUES LESS THAN (UNIX_TIMESTAMP('2027-02-01 00:00:00')),
+ *     PARTITION pmax VALUES LESS THAN MAXVALUE
+ * );
+ *
+ * And to drop a month of commands or results:
+ *
+ * ALTER TABLE command_results DROP PARTITION p202610;
+ * ALTER TABLE commands DROP PARTITION p202610;
+ *
+ * Queued commands whose command row was dropped disappear from the
+ * queue (their enrollment_queue rows are left behind) so only drop
+ * months older than any command still queued to an active enrollment.
+ */
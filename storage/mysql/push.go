@@ -2,8 +2,8 @@ package mysql
 
 import (
 	"context"
+	"database/sql"
 	"errors"
-	"strings"
 
 	"github.com/jessepeterson/nanomdm/mdm"
 )
@@ -16,32 +16,54 @@ func (s *MySQLStorage) RetrievePushInfo(ctx context.Context, ids []string) (map[
 	if len(ids) < 1 {
 		return nil, errors.New("no ids provided")
 	}
-	qs := "?" + strings.Repeat(", ?", len(ids)-1)
-	args := make([]interface{}, len(ids))
-	for i, v := range ids {
-		args[i] = v
+	pushInfos := make(map[string]*mdm.Push)
+	if len(ids) == 1 {
+		if err := s.queryPushInfo(ctx, pushInfos, s.stmts.pushInfo, ids[0]); err != nil {
+			return nil, err
+		}
+		return pushInfos, nil
 	}
-	rows, err := s.db.QueryContext(
-		ctx,
-		`SELECT id, topic, push_magic, token_hex FROM enrollments WHERE id IN (`+qs+`);`,
-		args...,
-	)
+	args := make([]interface{}, pushInfoBatchSize)
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > pushInfoBatchSize {
+			n = pushInfoBatchSize
+		}
+		// pad a short batch by repeating the first id
+		for i := range args {
+			if i < n {
+				args[i] = ids[i]
+			} else {
+				args[i] = ids[0]
+			}
+		}
+		if err := s.queryPushInfo(ctx, pushInfos, s.stmts.pushInfoBatch, args...); err != nil {
+			return nil, err
+		}
+		ids = ids[n:]
+	}
+	return pushInfos, nil
+}
+
+// queryPushInfo executes the prepared push info stmt and collects the
+// results into pushInfos.
+func (s *MySQLStorage) queryPushInfo(ctx context.Context, pushInfos map[string]*mdm.Push, stmt *sql.Stmt, args ...interface{}) error {
+	rows, err := stmt.QueryContext(ctx, args...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
-	pushInfos := make(map[string]*mdm.Push)
 	for rows.Next() {
 		push := new(mdm.Push)
 		var id, token string
 		if err := rows.Scan(&id, &push.Topic, &push.PushMagic, &token); err != nil {
-			return nil, err
+			return err
 		}
 		// convert from hex
 		if err := push.SetTokenString(token); err != nil {
-			return nil, err
+			return err
 		}
 		pushInfos[id] = push
 	}
-	return pushInfos, rows.Err()
+	return rows.Err()
 }
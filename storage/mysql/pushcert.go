@@ -3,9 +3,13 @@ package mysql
 import (
 	"context"
 	"crypto/tls"
+	"database/sql"
+	"errors"
+	"fmt"
 	"strconv"
 
 	"github.com/jessepeterson/nanomdm/cryptoutil"
+	"github.com/jessepeterson/nanomdm/storage"
 )
 
 func (s *MySQLStorage) RetrievePushCert(ctx context.Context, topic string) (*tls.Certificate, string, error) {
@@ -16,10 +20,15 @@ func (s *MySQLStorage) RetrievePushCert(ctx context.Context, topic string) (*tls
 		`SELECT cert_pem, key_pem, stale_token FROM push_certs WHERE topic = ?;`,
 		topic,
 	).Scan(&certPEM, &keyPEM, &staleToken)
-	if err != nil {
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "", fmt.Errorf("%w: push cert of topic %s", storage.ErrNotFound, topic)
+	} else if err != nil {
+		return nil, "", err
+	}
+	if keyPEM, err = s.decrypt(ctx, keyPEM, []byte(topic)); err != nil {
 		return nil, "", err
 	}
-	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	cert, err := cryptoutil.X509KeyPair(ctx, certPEM, keyPEM)
 	if err != nil {
 		return nil, "", err
 	}
@@ -45,6 +54,9 @@ func (s *MySQLStorage) StorePushCert(ctx context.Context, pemCert, pemKey []byte
 	if err != nil {
 		return err
 	}
+	if pemKey, err = s.encrypt(ctx, pemKey, []byte(topic)); err != nil {
+		return err
+	}
 	_, err = s.db.ExecContext(
 		ctx, `
 INSERT INTO push_certs
@@ -60,3 +72,32 @@ UPDATE
 	)
 	return err
 }
+
+func (s *MySQLStorage) ListPushCerts(ctx context.Context) (map[string][]byte, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT topic, cert_pem FROM push_certs;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	certs := make(map[string][]byte)
+	for rows.Next() {
+		var topic string
+		var certPEM []byte
+		if err = rows.Scan(&topic, &certPEM); err != nil {
+			return nil, err
+		}
+		certs[topic] = certPEM
+	}
+	return certs, rows.Err()
+}
+
+func (s *MySQLStorage) DeletePushCert(ctx context.Context, topic string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM push_certs WHERE topic = ?;`, topic)
+	if err != nil {
+		return err
+	}
+	if ct, err := result.RowsAffected(); err == nil && ct < 1 {
+		return fmt.Errorf("%w: push cert of topic %s", storage.ErrNotFound, topic)
+	}
+	return nil
+}
@@ -5,20 +5,89 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
 )
 
-func enqueue(ctx context.Context, tx *sql.Tx, ids []string, cmd *mdm.Command) error {
-	if len(ids) < 1 {
-		return errors.New("no id(s) supplied to queue command to")
+// ErrDuplicateCommand is returned when enqueueing a command UUID that
+// already exists. It wraps storage.ErrConflict.
+var ErrDuplicateCommand = fmt.Errorf("duplicate command UUID (%w)", storage.ErrConflict)
+
+// errDupEntry is the MySQL duplicate key error number.
+const errDupEntry = 1062
+
+// enabledIDs returns those of ids that are enabled enrollments and the
+// errors of the others.
+func enabledIDs(ctx context.Context, tx *sql.Tx, ids []string) ([]string, map[string]error, error) {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	rows, err := tx.QueryContext(
+		ctx,
+		`SELECT id, enabled FROM enrollments WHERE id IN (?`+strings.Repeat(`, ?`, len(ids)-1)+`);`,
+		args...,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	enabled := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		var e bool
+		if err = rows.Scan(&id, &e); err != nil {
+			return nil, nil, err
+		}
+		enabled[id] = e
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	var ok []string
+	idErrs := make(map[string]error)
+	for _, id := range ids {
+		if e, found := enabled[id]; !found {
+			idErrs[id] = fmt.Errorf("%w: enrollment %s", storage.ErrNotFound, id)
+		} else if !e {
+			idErrs[id] = fmt.Errorf("%w: %s", storage.ErrDisabledEnrollment, id)
+		} else {
+			ok = append(ok, id)
+		}
+	}
+	return ok, idErrs, nil
+}
+
+func enqueue(ctx context.Context, tx *sql.Tx, ids []string, cmd *mdm.Command, partitioned bool) error {
+	if partitioned {
+		// the commands primary key includes created_at so we need to
+		// check for duplicate command UUIDs ourselves.
+		var ct int
+		err := tx.QueryRowContext(
+			ctx,
+			`SELECT COUNT(*) FROM commands WHERE command_uuid = ? FOR UPDATE;`,
+			cmd.CommandUUID,
+		).Scan(&ct)
+		if err != nil {
+			return err
+		}
+		if ct > 0 {
+			return fmt.Errorf("%w: %s", ErrDuplicateCommand, cmd.CommandUUID)
+		}
 	}
 	_, err := tx.ExecContext(
 		ctx,
 		`INSERT INTO commands (command_uuid, request_type, command) VALUES (?, ?, ?);`,
 		cmd.CommandUUID, cmd.Command.RequestType, cmd.Raw,
 	)
-	if err != nil {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) && myErr.Number == errDupEntry {
+		return fmt.Errorf("%w: %s", ErrDuplicateCommand, cmd.CommandUUID)
+	} else if err != nil {
 		return err
 	}
 	query := `INSERT INTO enrollment_queue (id, command_uuid) VALUES (?, ?)`
@@ -32,25 +101,142 @@ func enqueue(ctx context.Context, tx *sql.Tx, ids []string, cmd *mdm.Command) er
 }
 
 func (m *MySQLStorage) EnqueueCommand(ctx context.Context, ids []string, cmd *mdm.Command) (map[string]error, error) {
+	if len(ids) < 1 {
+		return nil, errors.New("no id(s) supplied to queue command to")
+	}
 	tx, err := m.db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
-	if err = enqueue(ctx, tx, ids, cmd); err != nil {
+	ids, idErrs, err := enabledIDs(ctx, tx, ids)
+	if err == nil && len(ids) > 0 {
+		err = enqueue(ctx, tx, ids, cmd, m.partitioned)
+	}
+	if err != nil {
 		if rbErr := tx.Rollback(); rbErr != nil {
 			return nil, fmt.Errorf("rollback error: %w; while trying to handle error: %v", rbErr, err)
 		}
 		return nil, err
 	}
-	return nil, tx.Commit()
+	return idErrs, tx.Commit()
 }
 
 func (s *MySQLStorage) StoreCommandReport(r *mdm.Request, result *mdm.CommandResults) error {
-	if result.Status == "Idle" {
-		// TODO: store LastSeen?
-		return nil
+	if s.partitioned && result.Status != "Idle" {
+		// storing a partitioned result needs a transaction anyway
+		var dupErr error
+		_, err := s.inTx(r.Context, func(tx *sql.Tx) (*mdm.Command, error) {
+			err := s.storeCommandReport(r, tx, result)
+			if errors.Is(err, storage.ErrDuplicateReport) {
+				dupErr = err
+				return nil, nil
+			}
+			return nil, err
+		})
+		if err != nil {
+			return err
+		}
+		return dupErr
+	}
+	return s.storeCommandReport(r, nil, result)
+}
+
+// StoreCommandReportAndRetrieveNext stores the command report and
+// retrieves the next command in one transaction.
+func (s *MySQLStorage) StoreCommandReportAndRetrieveNext(r *mdm.Request, result *mdm.CommandResults, skipNotNow bool) (*mdm.Command, error) {
+	var dupErr error
+	cmd, err := s.inTx(r.Context, func(tx *sql.Tx) (*mdm.Command, error) {
+		err := s.storeCommandReport(r, tx, result)
+		if errors.Is(err, storage.ErrDuplicateReport) {
+			dupErr = err
+		} else if err != nil {
+			return nil, err
+		}
+		return s.retrieveNextCommand(r, tx, skipNotNow)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cmd, dupErr
+}
+
+// inTx runs f in a transaction and commits it if f succeeds.
+func (s *MySQLStorage) inTx(ctx context.Context, f func(*sql.Tx) (*mdm.Command, error)) (*mdm.Command, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
 	}
-	_, err := s.db.ExecContext(
+	cmd, err := f(tx)
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return nil, fmt.Errorf("rollback error: %w; while trying to handle error: %v", rbErr, err)
+		}
+		return nil, err
+	}
+	return cmd, tx.Commit()
+}
+
+// dbtx executes queries with or without a transaction.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// conn returns tx if not nil or the database otherwise.
+func (s *MySQLStorage) conn(tx *sql.Tx) dbtx {
+	if tx != nil {
+		return tx
+	}
+	return s.db
+}
+
+// txStmt returns the prepared stmt specific to tx if not nil.
+func txStmt(ctx context.Context, tx *sql.Tx, stmt *sql.Stmt) *sql.Stmt {
+	if tx != nil {
+		return tx.StmtContext(ctx, stmt)
+	}
+	return stmt
+}
+
+// storeCommandReport stores result using tx if not nil.
+// A nil tx is only valid for non-partitioned results tables.
+func (s *MySQLStorage) storeCommandReport(r *mdm.Request, tx *sql.Tx, result *mdm.CommandResults) error {
+	_, err := txStmt(r.Context, tx, s.stmts.updateLastSeen).ExecContext(r.Context, r.ID)
+	if err != nil || result.Status == "Idle" {
+		return err
+	}
+	var ct int
+	err = txStmt(r.Context, tx, s.stmts.duplicateReport).QueryRowContext(
+		r.Context, r.ID, result.CommandUUID, result.Status, result.Raw,
+	).Scan(&ct)
+	if err != nil {
+		return err
+	} else if ct > 0 {
+		return fmt.Errorf("%w: %s", storage.ErrDuplicateReport, result.CommandUUID)
+	}
+	if s.partitioned {
+		err = storePartitionedCommandResult(r, tx, result)
+	} else {
+		err = s.storeCommandResult(r, tx, result)
+	}
+	if err != nil {
+		return err
+	}
+	// update the delivery audit trail
+	set := `resolved_at = COALESCE(resolved_at, CURRENT_TIMESTAMP)`
+	if result.Status == "NotNow" {
+		set = `last_not_now_at = CURRENT_TIMESTAMP, not_now_count = not_now_count + 1`
+	}
+	_, err = s.conn(tx).ExecContext(
+		r.Context,
+		`UPDATE enrollment_queue SET `+set+` WHERE id = ? AND command_uuid = ?;`,
+		r.ID, result.CommandUUID,
+	)
+	return err
+}
+
+func (s *MySQLStorage) storeCommandResult(r *mdm.Request, tx *sql.Tx, result *mdm.CommandResults) error {
+	_, err := s.conn(tx).ExecContext(
 		r.Context, `
 INSERT INTO command_results
     (id, command_uuid, status, result)
@@ -68,36 +254,124 @@ UPDATE
 	return err
 }
 
+// storePartitionedCommandResult stores result when the command_results
+// primary key includes created_at. Then an INSERT of an existing
+// result would not be a duplicate key so we update it ourselves.
+func storePartitionedCommandResult(r *mdm.Request, tx *sql.Tx, result *mdm.CommandResults) error {
+	var ct int
+	err := tx.QueryRowContext(
+		r.Context,
+		`SELECT COUNT(*) FROM command_results WHERE id = ? AND command_uuid = ? FOR UPDATE;`,
+		r.ID, result.CommandUUID,
+	).Scan(&ct)
+	if err != nil {
+		return err
+	}
+	if ct > 0 {
+		_, err = tx.ExecContext(
+			r.Context,
+			`UPDATE command_results SET status = ?, result = ? WHERE id = ? AND command_uuid = ?;`,
+			result.Status, result.Raw, r.ID, result.CommandUUID,
+		)
+		return err
+	}
+	_, err = tx.ExecContext(
+		r.Context,
+		`INSERT INTO command_results (id, command_uuid, status, result) VALUES (?, ?, ?, ?);`,
+		r.ID, result.CommandUUID, result.Status, result.Raw,
+	)
+	return err
+}
+
 func (s *MySQLStorage) RetrieveNextCommand(r *mdm.Request, skipNotNow bool) (*mdm.Command, error) {
-	statusWhere := "status IS NULL"
-	if !skipNotNow {
-		statusWhere = `(` + statusWhere + ` OR status = 'NotNow')`
+	return s.retrieveNextCommand(r, nil, skipNotNow)
+}
+
+// retrieveNextCommand retrieves the next command using tx if not nil.
+func (s *MySQLStorage) retrieveNextCommand(r *mdm.Request, tx *sql.Tx, skipNotNow bool) (*mdm.Command, error) {
+	next := s.stmts.nextCommand
+	if skipNotNow {
+		next = s.stmts.nextCommandSkipNotNow
 	}
 	command := new(mdm.Command)
-	err := s.db.QueryRowContext(
-		r.Context,
-		`SELECT command_uuid, request_type, command FROM view_queue WHERE id = ? AND active = 1 AND `+statusWhere+` LIMIT 1;`,
-		r.ID,
-	).Scan(&command.CommandUUID, &command.Command.RequestType, &command.Raw)
+	err := txStmt(r.Context, tx, next).QueryRowContext(r.Context, r.ID).Scan(
+		&command.CommandUUID, &command.Command.RequestType, &command.Raw,
+	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, err
 	}
-	return command, nil
+	// update the delivery audit trail
+	_, err = txStmt(r.Context, tx, s.stmts.updateDelivered).ExecContext(r.Context, r.ID, command.CommandUUID)
+	return command, err
+}
+
+// RetrieveNextCommands retrieves up to limit of the next commands in
+// queue order without recording their delivery.
+func (s *MySQLStorage) RetrieveNextCommands(r *mdm.Request, skipNotNow bool, limit int) ([]*mdm.Command, error) {
+	next := s.stmts.nextCommands
+	if skipNotNow {
+		next = s.stmts.nextCommandsSkipNotNow
+	}
+	rows, err := next.QueryContext(r.Context, r.ID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var cmds []*mdm.Command
+	for rows.Next() {
+		command := new(mdm.Command)
+		if err := rows.Scan(&command.CommandUUID, &command.Command.RequestType, &command.Raw); err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, command)
+	}
+	return cmds, rows.Err()
+}
+
+// StoreCommandDelivered records the delivery of command uuid.
+func (s *MySQLStorage) StoreCommandDelivered(r *mdm.Request, uuid string) error {
+	_, err := s.stmts.updateDelivered.ExecContext(r.Context, r.ID, uuid)
+	return err
 }
 
 func (s *MySQLStorage) ClearQueue(r *mdm.Request) error {
 	if r.ParentID != "" {
 		return errors.New("can only clear a device channel queue")
 	}
+	return clearQueue(r.Context, s.db, r.ID)
+}
+
+// ReplaceQueue clears the queue of r and enqueues cmd in one transaction.
+func (s *MySQLStorage) ReplaceQueue(r *mdm.Request, cmd *mdm.Command) error {
+	if r.ParentID != "" {
+		return errors.New("can only replace a device channel queue")
+	}
+	_, err := s.inTx(r.Context, func(tx *sql.Tx) (*mdm.Command, error) {
+		if err := clearQueue(r.Context, tx, r.ID); err != nil {
+			return nil, err
+		}
+		ids, idErrs, err := enabledIDs(r.Context, tx, []string{r.ID})
+		if err != nil {
+			return nil, err
+		} else if idErr := idErrs[r.ID]; idErr != nil {
+			return nil, idErr
+		}
+		return nil, enqueue(r.Context, tx, ids, cmd, s.partitioned)
+	})
+	return err
+}
+
+// clearQueue clears (marks inactive) the queue of device id.
+func clearQueue(ctx context.Context, db dbtx, id string) error {
 	// Because we're joining on and WHERE-ing by the enrollments table
 	// this will clear (mark inactive) the queue of not only this
 	// device ID, but all user-channel enrollments with a 'parent' ID of
 	// this device, too.
-	_, err := s.db.ExecContext(
-		r.Context,
+	_, err := db.ExecContext(
+		ctx,
 		`
 UPDATE
     enrollment_queue AS q
@@ -113,7 +387,112 @@ WHERE
     e.device_id = ? AND
     active = 1 AND
     (r.status IS NULL OR r.status = 'NotNow');`,
-		r.ID,
+		id,
 	)
 	return err
 }
+
+// PurgeQueues deletes the queues of disabled or idle enrollments.
+func (s *MySQLStorage) PurgeQueues(ctx context.Context, idleBefore time.Time, archive func(*storage.PurgedCommand) error) (int, error) {
+	// the queues of soft-deleted enrollments are kept for restoring
+	where := `(e.enabled = 0 AND ` + notRemoved + `)`
+	var args []interface{}
+	if !idleBefore.IsZero() {
+		// fallback to when the enrollment was updated for enrollments
+		// never seen since last seen tracking was added
+		where = `(` + where + ` OR COALESCE(e.last_seen_at, e.updated_at) < FROM_UNIXTIME(?))`
+		args = append(args, idleBefore.Unix())
+	}
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT DISTINCT e.id FROM enrollments AS e INNER JOIN enrollment_queue AS q ON q.id = e.id WHERE `+where+`;`,
+		args...,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	var total int
+	for _, id := range ids {
+		n, err := s.purgeQueue(ctx, id, archive)
+		if err != nil {
+			return total, fmt.Errorf("purging queue of %s: %w", id, err)
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// purgeQueue archives and deletes the queue of enrollment id.
+func (s *MySQLStorage) purgeQueue(ctx context.Context, id string, archive func(*storage.PurgedCommand) error) (int, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT command_uuid, request_type, command, status, result FROM view_queue WHERE id = ?;`,
+		id,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var uuids []interface{}
+	for rows.Next() {
+		c := &storage.PurgedCommand{ID: id}
+		var status sql.NullString
+		if err := rows.Scan(&c.CommandUUID, &c.RequestType, &c.Command, &status, &c.Result); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		c.Status = status.String
+		if archive != nil {
+			if err := archive(c); err != nil {
+				rows.Close()
+				return 0, err
+			}
+		}
+		uuids = append(uuids, c.CommandUUID)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil || len(uuids) < 1 {
+		return 0, err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	in := `IN (?` + strings.Repeat(", ?", len(uuids)-1) + `)`
+	args := append([]interface{}{id}, uuids...)
+	for _, query := range []string{
+		`DELETE FROM command_results WHERE id = ? AND command_uuid ` + in + `;`,
+		`DELETE FROM enrollment_queue WHERE id = ? AND command_uuid ` + in + `;`,
+	} {
+		if _, err = tx.ExecContext(ctx, query, args...); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		// delete the commands no longer queued for any enrollment
+		_, err = tx.ExecContext(
+			ctx,
+			`DELETE c FROM commands AS c LEFT JOIN enrollment_queue AS q ON q.command_uuid = c.command_uuid WHERE q.command_uuid IS NULL AND c.command_uuid `+in+`;`,
+			uuids...,
+		)
+	}
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return 0, fmt.Errorf("rollback error: %w; while trying to handle error: %v", rbErr, err)
+		}
+		return 0, err
+	}
+	return len(uuids), tx.Commit()
+}
@@ -0,0 +1,157 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
+	"github.com/jessepeterson/nanomdm/storage/storagetest"
+)
+
+// scriptConn records the statements executed and answers queries with
+// the rows of the result whose key is contained in the query.
+type scriptConn struct {
+	fakeConn
+	mu      *sync.Mutex
+	execs   *[]string
+	results map[string][][]driver.Value
+}
+
+func newScriptDB(results map[string][][]driver.Value) (*sql.DB, *scriptConn) {
+	c := &scriptConn{mu: new(sync.Mutex), execs: new([]string), results: results}
+	return sql.OpenDB(c), c
+}
+
+func (c *scriptConn) Connect(context.Context) (driver.Conn, error) { return c, nil }
+
+func (c *scriptConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*c.execs = append(*c.execs, query)
+	return driver.ResultNoRows, nil
+}
+
+func (c *scriptConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	for key, rows := range c.results {
+		if strings.Contains(query, key) {
+			return &scriptRows{rows: rows}, nil
+		}
+	}
+	return nil, errors.New("unexpected query: " + query)
+}
+
+func (c *scriptConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) { return c, nil }
+func (c *scriptConn) Commit() error                                                { return nil }
+func (c *scriptConn) Rollback() error                                              { return nil }
+
+// executed returns the statements executed that contain s.
+func (c *scriptConn) executed(s string) (found []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, query := range *c.execs {
+		if strings.Contains(query, s) {
+			found = append(found, query)
+		}
+	}
+	return
+}
+
+type scriptRows struct {
+	rows [][]driver.Value
+}
+
+func (r *scriptRows) Columns() []string {
+	if len(r.rows) < 1 {
+		return []string{"c"}
+	}
+	return make([]string, len(r.rows[0]))
+}
+
+func (r *scriptRows) Close() error { return nil }
+
+func (r *scriptRows) Next(dest []driver.Value) error {
+	if len(r.rows) < 1 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestIsPartitioned(t *testing.T) {
+	for _, ct := range []int64{0, 2} {
+		db, _ := newScriptDB(map[string][][]driver.Value{"information_schema.partitions": {{ct}}})
+		partitioned, err := isPartitioned(context.Background(), db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have, want := partitioned, ct > 0; have != want {
+			t.Errorf("%d partitions: have %v, want %v", ct, have, want)
+		}
+		db.Close()
+	}
+}
+
+func TestEnqueuePartitioned(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		existing    int64
+		partitioned bool
+		err         error
+	}{
+		{0, true, nil},
+		{1, true, storage.ErrConflict},
+		{1, false, nil}, // the primary key catches duplicates
+	} {
+		db, c := newScriptDB(map[string][][]driver.Value{
+			"FROM enrollments": {{"A", true}},
+			"FROM commands":    {{test.existing}},
+		})
+		s := &MySQLStorage{db: db, partitioned: test.partitioned}
+		_, err := s.EnqueueCommand(ctx, []string{"A"}, storagetest.Command("1"))
+		if !errors.Is(err, test.err) {
+			t.Errorf("%+v: have %v, want %v", test, err, test.err)
+		}
+		inserted := len(c.executed("INSERT INTO commands")) > 0
+		if want := test.err == nil; inserted != want {
+			t.Errorf("%+v: command inserted: have %v, want %v", test, inserted, want)
+		}
+		db.Close()
+	}
+}
+
+func TestStorePartitionedCommandResult(t *testing.T) {
+	ctx := context.Background()
+	r := &mdm.Request{Context: ctx, EnrollID: &mdm.EnrollID{ID: "A"}}
+	for _, test := range []struct {
+		existing int64
+		want     string
+	}{
+		{0, "INSERT INTO command_results"},
+		{1, "UPDATE command_results"},
+	} {
+		db, c := newScriptDB(map[string][][]driver.Value{"FROM command_results": {{test.existing}}})
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		report := &mdm.CommandResults{CommandUUID: "1", Status: "Acknowledged"}
+		if err = storePartitionedCommandResult(r, tx, report); err != nil {
+			t.Fatal(err)
+		}
+		if err = tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if len(c.executed(test.want)) != 1 || len(c.executed("command_results")) != 1 {
+			t.Errorf("%d existing: have %v, want %q", test.existing, *c.execs, test.want)
+		}
+		db.Close()
+	}
+}
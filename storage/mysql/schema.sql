@@ -85,8 +85,16 @@ CREATE TABLE enrollments (
     push_magic VARCHAR(127) NOT NULL,
     token_hex  VARCHAR(255) NOT NULL, -- TODO: Perhaps just CHAR(64)?
 
+    -- Parsed fields of the last TokenUpdate.
+    awaiting_configuration BOOLEAN NOT NULL DEFAULT 0,
+    not_on_console         BOOLEAN NOT NULL DEFAULT 0,
+
     enabled BOOLEAN NOT NULL DEFAULT 1,
 
+    -- When the enrollment last sent a TokenUpdate or connected to the
+    -- MDM endpoint.
+    last_seen_at TIMESTAMP NULL,
+
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
 
@@ -105,6 +113,8 @@ CREATE TABLE enrollments (
     CHECK (type != ''),
     INDEX (type),
 
+    INDEX (awaiting_configuration),
+
     CHECK (topic != ''),
     CHECK (push_magic != ''),
     CHECK (LENGTH(token) > 0)
@@ -120,6 +130,8 @@ CREATE TABLE commands (
     request_type VARCHAR(63)  NOT NULL,
     -- Raw command Plist
     command      TEXT         NOT NULL,
+    -- URL the command results are posted to
+    callback_url TEXT         NULL,
 
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
@@ -177,6 +189,16 @@ CREATE TABLE enrollment_queue (
     active   BOOLEAN NOT NULL DEFAULT 1,
     priority TINYINT NOT NULL DEFAULT 0,
 
+    -- Delivery audit trail. The command is considered enqueued at
+    -- created_at. A delivery_count greater than one means the command
+    -- was re-delivered (i.e. after a NotNow).
+    first_delivered_at TIMESTAMP NULL,
+    last_delivered_at  TIMESTAMP NULL,
+    delivery_count     INTEGER   NOT NULL DEFAULT 0,
+    last_not_now_at    TIMESTAMP NULL,
+    not_now_count      INTEGER   NOT NULL DEFAULT 0,
+    resolved_at        TIMESTAMP NULL,
+
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
 
@@ -188,7 +210,11 @@ CREATE TABLE enrollment_queue (
 
     FOREIGN KEY (command_uuid)
         REFERENCES commands (command_uuid)
-        ON DELETE CASCADE ON UPDATE CASCADE
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    -- dequeueing reads the active queue of an enrollment in order. see
+    -- migrations/001_queue_dequeue_index.sql for existing schemas.
+    INDEX queue_dequeue (id, active, created_at)
 );
 
 /* An enrollment's queue is a view into commands, enrollment queued
@@ -221,6 +247,331 @@ ORDER BY
     q.created_at;
 
 
+/* Well-known device attributes collected from command results (e.g.
+ * DeviceInformation and SecurityInfo) for basic asset reporting.
+ */
+CREATE TABLE inventory (
+    id VARCHAR(255) NOT NULL,
+
+    serial_number     VARCHAR(127) NULL,
+    model             VARCHAR(127) NULL,
+    model_name        VARCHAR(255) NULL,
+    product_name      VARCHAR(127) NULL,
+    device_name       VARCHAR(255) NULL,
+    os_version        VARCHAR(31)  NULL,
+    build_version     VARCHAR(31)  NULL,
+    filevault_enabled BOOLEAN      NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    INDEX (serial_number),
+    INDEX (model),
+    INDEX (os_version)
+);
+
+
+/* OS update rollout state of an enrollment. Each enrollment has at
+ * most one current OS update.
+ */
+CREATE TABLE os_update_states (
+    id VARCHAR(255) NOT NULL,
+
+    cohort          VARCHAR(127) NULL,
+    product_key     VARCHAR(255) NULL,
+    product_version VARCHAR(31)  NULL,
+    install_action  VARCHAR(31)  NULL,
+    command_uuid    VARCHAR(127) NULL,
+
+    state            VARCHAR(31)  NOT NULL,
+    device_status    VARCHAR(63)  NULL,
+    percent_complete DOUBLE       NOT NULL DEFAULT 0,
+    error            TEXT         NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    CHECK (state != ''),
+    INDEX (cohort),
+    INDEX (state)
+);
+
+
+/* Application install commands sent to an enrollment and their
+ * results.
+ */
+CREATE TABLE app_installs (
+    id           VARCHAR(255) NOT NULL,
+    command_uuid VARCHAR(127) NOT NULL,
+
+    request_type    VARCHAR(63)  NOT NULL,
+    identifier      VARCHAR(255) NULL,
+    itunes_store_id BIGINT       NULL,
+    manifest_url    TEXT         NULL,
+
+    status VARCHAR(31) NULL,
+    state  VARCHAR(63) NULL,
+    error  TEXT        NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id, command_uuid),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    CHECK (command_uuid != ''),
+    INDEX (identifier)
+);
+
+
+/* Application manifests hosted for InstallApplication and
+ * InstallEnterpriseApplication commands.
+ */
+CREATE TABLE app_manifests (
+    name     VARCHAR(255) NOT NULL,
+    manifest MEDIUMBLOB   NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (name),
+    CHECK (name != '')
+);
+
+
+/* Applications installed on an enrollment. */
+CREATE TABLE app_inventory (
+    id         VARCHAR(255) NOT NULL,
+    identifier VARCHAR(255) NOT NULL,
+
+    name           VARCHAR(255) NULL,
+    version        VARCHAR(63)  NULL,
+    short_version  VARCHAR(63)  NULL,
+    managed_status VARCHAR(63)  NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id, identifier),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    CHECK (identifier != ''),
+    INDEX (identifier)
+);
+
+
+/* Lost Mode state and last location of an enrollment. */
+CREATE TABLE lost_mode (
+    id VARCHAR(255) NOT NULL,
+
+    state        VARCHAR(31)  NOT NULL,
+    message      TEXT         NULL,
+    phone_number VARCHAR(63)  NULL,
+    footnote     TEXT         NULL,
+    command_uuid VARCHAR(127) NULL,
+    request_type VARCHAR(63)  NULL,
+    error        TEXT         NULL,
+
+    latitude            DOUBLE    NULL,
+    longitude           DOUBLE    NULL,
+    horizontal_accuracy DOUBLE    NULL,
+    located_at          TIMESTAMP NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    CHECK (state != '')
+);
+
+
+/* Escrowed Activation Lock bypass codes. Codes are sealed (encrypted)
+ * before storage.
+ */
+CREATE TABLE bypass_codes (
+    id          VARCHAR(255) NOT NULL,
+    sealed_code BLOB         NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+
+/* Managed device passwords (recovery lock and firmware passwords).
+ * Passwords are sealed (encrypted) before storage.
+ */
+CREATE TABLE device_passwords (
+    id   VARCHAR(255) NOT NULL,
+    kind VARCHAR(31)  NOT NULL,
+
+    sealed_password BLOB         NULL,
+    sealed_pending  BLOB         NULL,
+    command_uuid    VARCHAR(127) NULL,
+    status          VARCHAR(31)  NOT NULL,
+    verified        BOOLEAN      NULL,
+    error           TEXT         NULL,
+    rotated_at      TIMESTAMP    NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id, kind),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    CHECK (kind != ''),
+    CHECK (status != '')
+);
+
+
+CREATE TABLE identity_rotations (
+    id VARCHAR(255) NOT NULL,
+
+    state              VARCHAR(31)  NOT NULL,
+    command_uuid       VARCHAR(127) NULL,
+    cert_hash          CHAR(64)     NULL,
+    previous_cert_hash CHAR(64)     NULL,
+    error              TEXT         NULL,
+    started_at         TIMESTAMP    NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    CHECK (state != '')
+);
+
+
+/* Workflow (command sequence) runs of enrollments. */
+CREATE TABLE workflow_runs (
+    id       VARCHAR(255) NOT NULL,
+    workflow VARCHAR(255) NOT NULL,
+
+    state        VARCHAR(31)  NOT NULL,
+    step         INTEGER      NOT NULL DEFAULT 0,
+    command_uuid VARCHAR(127) NULL,
+    attempts     INTEGER      NOT NULL DEFAULT 0,
+    error        TEXT         NULL,
+    started_at   TIMESTAMP    NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id, workflow),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    CHECK (workflow != ''),
+    CHECK (state != '')
+);
+
+
+/* Named groups of enrollments. Members are not foreign keys of
+ * enrollments so that groups may name enrollments before they enroll.
+ */
+CREATE TABLE enrollment_groups (
+    name VARCHAR(255) NOT NULL,
+    id   VARCHAR(255) NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (name, id),
+
+    CHECK (name != ''),
+    CHECK (id != '')
+);
+
+
+/* Saved inventory filter expressions (smart groups). Membership is
+ * evaluated on demand against the inventory table.
+ */
+CREATE TABLE smart_groups (
+    name   VARCHAR(255) NOT NULL,
+    filter TEXT NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (name),
+
+    CHECK (name != '')
+);
+
+
+/* Soft-deleted (checked-out) device channel enrollments. The queue
+ * entries pending at deletion are kept so that they can be restored
+ * when the device re-enrolls.
+ */
+CREATE TABLE deleted_enrollments (
+    id VARCHAR(255) NOT NULL,
+
+    deleted_at TIMESTAMP NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE deleted_enrollment_queue (
+    device_id    VARCHAR(255) NOT NULL,
+    id           VARCHAR(255) NOT NULL,
+    command_uuid VARCHAR(127) NOT NULL,
+
+    PRIMARY KEY (id, command_uuid),
+
+    FOREIGN KEY (device_id)
+        REFERENCES deleted_enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    FOREIGN KEY (id, command_uuid)
+        REFERENCES enrollment_queue (id, command_uuid)
+        ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+
 CREATE TABLE push_certs (
     topic VARCHAR(255) NOT NULL,
 
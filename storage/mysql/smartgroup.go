@@ -0,0 +1,71 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// StoreSmartGroup upserts smart group g.
+func (s *MySQLStorage) StoreSmartGroup(ctx context.Context, g *storage.SmartGroup) error {
+	_, err := s.db.ExecContext(
+		ctx, `
+INSERT INTO smart_groups
+    (name, filter)
+VALUES
+    (?, ?) AS new
+ON DUPLICATE KEY
+UPDATE
+    filter = new.filter;`,
+		g.Name,
+		g.Filter,
+	)
+	return err
+}
+
+// RetrieveSmartGroups retrieves the smart groups of names (or all).
+func (s *MySQLStorage) RetrieveSmartGroups(ctx context.Context, names []string) ([]*storage.SmartGroup, error) {
+	query := `
+SELECT
+    name, filter, UNIX_TIMESTAMP(updated_at)
+FROM
+    smart_groups`
+	var args []interface{}
+	if len(names) > 0 {
+		query += ` WHERE name IN (?` + strings.Repeat(`, ?`, len(names)-1) + `)`
+		for _, name := range names {
+			args = append(args, name)
+		}
+	}
+	rows, err := s.db.QueryContext(ctx, query+` ORDER BY name;`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var groups []*storage.SmartGroup
+	for rows.Next() {
+		g := new(storage.SmartGroup)
+		var updated int64
+		if err = rows.Scan(&g.Name, &g.Filter, &updated); err != nil {
+			return nil, err
+		}
+		g.UpdatedAt = time.Unix(updated, 0)
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+// DeleteSmartGroup deletes smart group name.
+func (s *MySQLStorage) DeleteSmartGroup(ctx context.Context, name string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM smart_groups WHERE name = ?;`, name)
+	if err != nil {
+		return err
+	}
+	if ct, err := result.RowsAffected(); err == nil && ct < 1 {
+		return fmt.Errorf("%w: smart group %s", storage.ErrNotFound, name)
+	}
+	return nil
+}
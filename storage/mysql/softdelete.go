@@ -0,0 +1,200 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// notRemoved is a condition on enrollments (aliased as e) excluding
+// soft-deleted enrollments and their sub-enrollments that have not
+// re-enrolled.
+const notRemoved = `(e.enabled = 1 OR NOT EXISTS (SELECT 1 FROM deleted_enrollments AS d WHERE d.id = e.device_id))`
+
+// SoftDelete records the device channel enrollment and the pending queue
+// entries of it and its sub-enrollments as deleted. The entries of a
+// previous deletion that was not restored are kept.
+func (s *MySQLStorage) SoftDelete(r *mdm.Request) error {
+	if r.ParentID != "" {
+		return errors.New("can only delete a device channel")
+	}
+	tx, err := s.db.BeginTx(r.Context, nil)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(
+		r.Context, `
+INSERT INTO deleted_enrollments
+    (id, deleted_at)
+VALUES
+    (?, CURRENT_TIMESTAMP) AS new
+ON DUPLICATE KEY
+UPDATE
+    deleted_at = new.deleted_at;`,
+		r.ID,
+	)
+	if err == nil {
+		_, err = tx.ExecContext(
+			r.Context, `
+INSERT IGNORE INTO deleted_enrollment_queue
+    (device_id, id, command_uuid)
+SELECT
+    e.device_id, q.id, q.command_uuid
+FROM
+    enrollment_queue AS q
+    INNER JOIN enrollments AS e
+        ON q.id = e.id
+    LEFT JOIN command_results r
+        ON r.command_uuid = q.command_uuid AND r.id = q.id
+WHERE
+    e.device_id = ? AND
+    q.active = 1 AND
+    (r.status IS NULL OR r.status = 'NotNow');`,
+			r.ID,
+		)
+	}
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("rollback error: %w; while trying to handle error: %v", rbErr, err)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// RetrieveDeletedEnrollments returns the deleted enrollments of ids or
+// of all enrollments if none are given.
+func (s *MySQLStorage) RetrieveDeletedEnrollments(ctx context.Context, ids []string) ([]*storage.DeletedEnrollment, error) {
+	var where string
+	var args []interface{}
+	if len(ids) > 0 {
+		where = `WHERE d.id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)`
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+	rows, err := s.db.QueryContext(
+		ctx, `
+SELECT
+    d.id,
+    UNIX_TIMESTAMP(d.deleted_at),
+    e.enabled,
+    (SELECT COUNT(*) FROM deleted_enrollment_queue AS q WHERE q.device_id = d.id)
+FROM
+    deleted_enrollments AS d
+    INNER JOIN enrollments AS e
+        ON e.id = d.id
+`+where+`
+ORDER BY
+    d.id;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var deleted []*storage.DeletedEnrollment
+	for rows.Next() {
+		d := new(storage.DeletedEnrollment)
+		var deletedAt sql.NullInt64
+		if err := rows.Scan(&d.ID, &deletedAt, &d.Enabled, &d.Pending); err != nil {
+			return nil, err
+		}
+		if t := unixTime(deletedAt); t != nil {
+			d.DeletedAt = *t
+		}
+		deleted = append(deleted, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, d := range deleted {
+		if d.SubEnrollments, err = s.subEnrollmentIDs(ctx, d.ID); err != nil {
+			return nil, err
+		}
+	}
+	return deleted, nil
+}
+
+// subEnrollmentIDs returns the IDs of the user channel enrollments of
+// device channel enrollment id.
+func (s *MySQLStorage) subEnrollmentIDs(ctx context.Context, id string) ([]string, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id FROM enrollments WHERE device_id = ? AND id != device_id ORDER BY id;`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var subID string
+		if err := rows.Scan(&subID); err != nil {
+			return nil, err
+		}
+		ids = append(ids, subID)
+	}
+	return ids, rows.Err()
+}
+
+// RestoreEnrollment re-activates the unresolved queue entries pending
+// at deletion. User channel enrollments remain associated with their
+// device in the enrollments table so need no restoring.
+func (s *MySQLStorage) RestoreEnrollment(ctx context.Context, id string) (int, error) {
+	var enabled bool
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT e.enabled FROM deleted_enrollments AS d INNER JOIN enrollments AS e ON e.id = d.id WHERE d.id = ?;`,
+		id,
+	).Scan(&enabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, storage.ErrNotDeleted
+	} else if err != nil {
+		return 0, err
+	}
+	if !enabled {
+		return 0, storage.ErrNotReEnrolled
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.ExecContext(
+		ctx, `
+UPDATE
+    enrollment_queue AS q
+    INNER JOIN deleted_enrollment_queue AS d
+        ON d.id = q.id AND d.command_uuid = q.command_uuid
+    LEFT JOIN command_results r
+        ON r.command_uuid = q.command_uuid AND r.id = q.id
+SET
+    q.active = 1
+WHERE
+    d.device_id = ? AND
+    q.active = 0 AND
+    (r.status IS NULL OR r.status = 'NotNow');`,
+		id,
+	)
+	var count int64
+	if err == nil {
+		count, err = res.RowsAffected()
+	}
+	if err == nil {
+		// the queue entries cascade
+		_, err = tx.ExecContext(ctx, `DELETE FROM deleted_enrollments WHERE id = ?;`, id)
+	}
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return 0, fmt.Errorf("rollback error: %w; while trying to handle error: %v", rbErr, err)
+		}
+		return 0, err
+	}
+	return int(count), tx.Commit()
+}
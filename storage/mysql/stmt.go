@@ -0,0 +1,127 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// pushInfoBatchSize is the number of ids in the prepared batch push
+// info query. Batches with fewer ids are padded by repeating an id.
+const pushInfoBatchSize = 64
+
+// statements are the prepared statements of the hot-path queries run
+// on every check-in or push. Preparing them once saves parsing and
+// planning the queries on every execution.
+type statements struct {
+	nextCommand            *sql.Stmt
+	nextCommandSkipNotNow  *sql.Stmt
+	nextCommands           *sql.Stmt
+	nextCommandsSkipNotNow *sql.Stmt
+	updateDelivered        *sql.Stmt
+	updateLastSeen         *sql.Stmt
+	enrollmentEnabled      *sql.Stmt
+	duplicateReport        *sql.Stmt
+
+	enrollmentHasCertHash *sql.Stmt
+	hasCertHash           *sql.Stmt
+	isCertHashAssociated  *sql.Stmt
+
+	pushInfo      *sql.Stmt
+	pushInfoBatch *sql.Stmt
+}
+
+const nextCommandQuery = `SELECT command_uuid, request_type, command FROM view_queue WHERE id = ? AND active = 1 AND %s LIMIT %s;`
+
+// prepare prepares the hot-path statements.
+func (s *MySQLStorage) prepare(ctx context.Context) error {
+	stmts := new(statements)
+	for _, p := range []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&stmts.nextCommand, fmt.Sprintf(nextCommandQuery, `(status IS NULL OR status = 'NotNow')`, "1")},
+		{&stmts.nextCommandSkipNotNow, fmt.Sprintf(nextCommandQuery, `status IS NULL`, "1")},
+		{&stmts.nextCommands, fmt.Sprintf(nextCommandQuery, `(status IS NULL OR status = 'NotNow')`, "?")},
+		{&stmts.nextCommandsSkipNotNow, fmt.Sprintf(nextCommandQuery, `status IS NULL`, "?")},
+		{&stmts.updateDelivered, `
+UPDATE
+    enrollment_queue
+SET
+    first_delivered_at = COALESCE(first_delivered_at, CURRENT_TIMESTAMP),
+    last_delivered_at = CURRENT_TIMESTAMP,
+    delivery_count = delivery_count + 1
+WHERE
+    id = ? AND command_uuid = ?;`},
+		{&stmts.updateLastSeen, `UPDATE enrollments SET last_seen_at = CURRENT_TIMESTAMP WHERE id = ?;`},
+		{&stmts.enrollmentEnabled, `SELECT enabled FROM enrollments WHERE id = ?;`},
+		// a NotNow is only a duplicate if the command was not
+		// re-delivered since (i.e. a device may NotNow it again).
+		{&stmts.duplicateReport, `
+SELECT
+    COUNT(*)
+FROM
+    command_results AS r
+    INNER JOIN enrollment_queue AS q
+        ON q.id = r.id AND q.command_uuid = r.command_uuid
+WHERE
+    r.id = ? AND r.command_uuid = ? AND r.status = ? AND r.result = ? AND
+    (r.status != 'NotNow' OR q.last_delivered_at IS NULL OR q.last_not_now_at >= q.last_delivered_at);`},
+		{&stmts.enrollmentHasCertHash, `SELECT COUNT(*) FROM cert_auth_associations WHERE id = ?;`},
+		{&stmts.hasCertHash, `SELECT COUNT(*) FROM cert_auth_associations WHERE sha256 = ?;`},
+		{&stmts.isCertHashAssociated, `SELECT COUNT(*) FROM cert_auth_associations WHERE id = ? AND sha256 = ?;`},
+		{&stmts.pushInfo, `SELECT e.id, e.topic, e.push_magic, e.token_hex FROM enrollments AS e WHERE e.id = ? AND ` + notRemoved + `;`},
+		{&stmts.pushInfoBatch, `SELECT e.id, e.topic, e.push_magic, e.token_hex FROM enrollments AS e WHERE e.id IN (?` + strings.Repeat(", ?", pushInfoBatchSize-1) + `) AND ` + notRemoved + `;`},
+	} {
+		stmt, err := s.db.PrepareContext(ctx, p.query)
+		if err != nil {
+			stmts.close()
+			return fmt.Errorf("preparing statement: %w", err)
+		}
+		*p.stmt = stmt
+	}
+	s.stmts = stmts
+	return nil
+}
+
+// close closes the prepared statements.
+func (stmts *statements) close() error {
+	var firstErr error
+	for _, stmt := range []*sql.Stmt{
+		stmts.nextCommand,
+		stmts.nextCommandSkipNotNow,
+		stmts.nextCommands,
+		stmts.nextCommandsSkipNotNow,
+		stmts.updateDelivered,
+		stmts.updateLastSeen,
+		stmts.enrollmentEnabled,
+		stmts.duplicateReport,
+		stmts.enrollmentHasCertHash,
+		stmts.hasCertHash,
+		stmts.isCertHashAssociated,
+		stmts.pushInfo,
+		stmts.pushInfoBatch,
+	} {
+		if stmt == nil {
+			continue
+		}
+		if err := stmt.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Close stops the health checks (see WithHealthCheck) and closes the
+// prepared statements and the database.
+func (s *MySQLStorage) Close() error {
+	if s.health != nil {
+		close(s.health.done)
+	}
+	err := s.stmts.close()
+	if dbErr := s.db.Close(); err == nil {
+		err = dbErr
+	}
+	return err
+}
@@ -0,0 +1,34 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// StoreUnlockToken stores the sealed Unlock Token of a device. It is
+// additionally envelope encrypted if an envelope is configured.
+func (s *MySQLStorage) StoreUnlockToken(ctx context.Context, id string, sealedToken []byte) error {
+	unlockToken, err := s.encrypt(ctx, sealedToken, []byte(id))
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(
+		ctx,
+		`UPDATE devices SET unlock_token = ?, unlock_token_at = CURRENT_TIMESTAMP WHERE id = ? LIMIT 1;`,
+		unlockToken, id,
+	)
+	return err
+}
+
+// RetrieveUnlockToken retrieves the sealed Unlock Token of a device.
+func (s *MySQLStorage) RetrieveUnlockToken(ctx context.Context, id string) ([]byte, error) {
+	var unlockToken []byte
+	err := s.db.QueryRowContext(ctx, `SELECT unlock_token FROM devices WHERE id = ?;`, id).Scan(&unlockToken)
+	if errors.Is(err, sql.ErrNoRows) || unlockToken == nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return s.decrypt(ctx, unlockToken, []byte(id))
+}
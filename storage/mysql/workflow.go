@@ -0,0 +1,75 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// StoreWorkflowRun upserts the workflow run of an enrollment.
+func (s *MySQLStorage) StoreWorkflowRun(ctx context.Context, run *storage.WorkflowRun) error {
+	_, err := s.db.ExecContext(
+		ctx, `
+INSERT INTO workflow_runs
+    (id, workflow, state, step, command_uuid, attempts, error, started_at)
+VALUES
+    (?, ?, ?, ?, ?, ?, ?, FROM_UNIXTIME(?)) AS new
+ON DUPLICATE KEY
+UPDATE
+    state = new.state,
+    step = new.step,
+    command_uuid = new.command_uuid,
+    attempts = new.attempts,
+    error = new.error,
+    started_at = new.started_at;`,
+		run.ID,
+		run.Workflow,
+		run.State,
+		run.Step,
+		nullEmptyString(run.CommandUUID),
+		run.Attempts,
+		nullEmptyString(run.Error),
+		run.StartedAt.Unix(),
+	)
+	return err
+}
+
+// RetrieveWorkflowRuns retrieves the workflow runs of ids (or all enrollments).
+func (s *MySQLStorage) RetrieveWorkflowRuns(ctx context.Context, ids []string) ([]*storage.WorkflowRun, error) {
+	query := `
+SELECT
+    id, workflow, state, step, command_uuid, attempts, error, UNIX_TIMESTAMP(started_at), UNIX_TIMESTAMP(updated_at)
+FROM
+    workflow_runs`
+	var args []interface{}
+	if len(ids) > 0 {
+		query += ` WHERE id IN (?` + strings.Repeat(`, ?`, len(ids)-1) + `)`
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+	rows, err := s.db.QueryContext(ctx, query+` ORDER BY id, workflow;`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var runs []*storage.WorkflowRun
+	for rows.Next() {
+		run := new(storage.WorkflowRun)
+		var commandUUID, runErr sql.NullString
+		var started, updated int64
+		err := rows.Scan(&run.ID, &run.Workflow, &run.State, &run.Step, &commandUUID, &run.Attempts, &runErr, &started, &updated)
+		if err != nil {
+			return nil, err
+		}
+		run.CommandUUID = commandUUID.String
+		run.Error = runErr.String
+		run.StartedAt = time.Unix(started, 0)
+		run.UpdatedAt = time.Unix(updated, 0)
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
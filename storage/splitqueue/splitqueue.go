@@ -0,0 +1,153 @@
+// Package splitqueue stores the command queues of enrollments in a
+// different storage backend than the enrollments themselves.
+//
+// The command queue is written to on every device connection and for
+// every enqueued command while enrollments, push info, and certificates
+// change rarely so the two scale very differently. Splitting them lets
+// the queue be backed by a system suited to it (see storage.QueueStore).
+package splitqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// Storage stores command queues in a queue storage and everything else
+// in the enrollment storage. The enrollment storage still validates
+// enrollments: commands are only enqueued to enabled enrollments and
+// device channel queues are cleared with those of their user channels.
+//
+// The queues of soft-deleted enrollments are not kept for restoring:
+// restoring an enrollment only restores its user channel associations.
+type Storage struct {
+	storage.AllStorage
+	queue storage.QueueStore
+}
+
+// New creates a new split queue storage that stores command queues in
+// queue and everything else in store.
+func New(store storage.AllStorage, queue storage.QueueStore) *Storage {
+	return &Storage{AllStorage: store, queue: queue}
+}
+
+// StoreCommandReport stores report in the queue storage. The enrollment
+// storage sees an Idle report (which only updates the last seen time).
+func (s *Storage) StoreCommandReport(r *mdm.Request, report *mdm.CommandResults) error {
+	idle := *report
+	idle.Status = "Idle"
+	if err := s.AllStorage.StoreCommandReport(r, &idle); err != nil {
+		return err
+	}
+	return s.queue.StoreCommandReport(r, report)
+}
+
+func (s *Storage) RetrieveNextCommand(r *mdm.Request, skipNotNow bool) (*mdm.Command, error) {
+	return s.queue.RetrieveNextCommand(r, skipNotNow)
+}
+
+func (s *Storage) RetrieveNextCommands(r *mdm.Request, skipNotNow bool, limit int) ([]*mdm.Command, error) {
+	return s.queue.RetrieveNextCommands(r, skipNotNow, limit)
+}
+
+func (s *Storage) StoreCommandDelivered(r *mdm.Request, uuid string) error {
+	return s.queue.StoreCommandDelivered(r, uuid)
+}
+
+// ClearQueue clears the queue of the device channel enrollment of r and
+// of its user channel enrollments.
+func (s *Storage) ClearQueue(r *mdm.Request) error {
+	if r.ParentID != "" {
+		return errors.New("can only clear a device channel queue")
+	}
+	userChannels, err := s.AllStorage.ListUserChannelIDs(r.Context, []string{r.ID})
+	if err != nil {
+		return fmt.Errorf("listing user channels: %w", err)
+	}
+	if err = s.queue.ClearQueue(r); err != nil {
+		return err
+	}
+	for _, id := range userChannels[r.ID] {
+		ur := r.Clone()
+		ur.EnrollID = &mdm.EnrollID{Type: mdm.User, ID: id, ParentID: r.ID}
+		if err = s.queue.ClearQueue(ur); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnqueueCommand enqueues command to the enabled enrollments of ids.
+func (s *Storage) EnqueueCommand(ctx context.Context, ids []string, command *mdm.Command) (map[string]error, error) {
+	idErrs := make(map[string]error)
+	var enabled []string
+	for _, id := range ids {
+		ok, err := s.AllStorage.EnrollmentEnabled(ctx, id)
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			idErrs[id] = err
+		case err != nil:
+			return nil, err
+		case !ok:
+			idErrs[id] = fmt.Errorf("%w: %s", storage.ErrDisabledEnrollment, id)
+		default:
+			enabled = append(enabled, id)
+		}
+	}
+	if len(enabled) < 1 {
+		return idErrs, nil
+	}
+	queueErrs, err := s.queue.EnqueueCommand(ctx, enabled, command)
+	for id, err := range queueErrs {
+		idErrs[id] = err
+	}
+	return idErrs, err
+}
+
+// RetrieveCommandDeliveries returns the delivery audit trail of the
+// commands queued for id. ErrNotFound is returned for unknown
+// enrollments.
+func (s *Storage) RetrieveCommandDeliveries(ctx context.Context, id string) ([]*storage.CommandDelivery, error) {
+	if _, err := s.AllStorage.EnrollmentEnabled(ctx, id); err != nil {
+		return nil, err
+	}
+	return s.queue.RetrieveCommandDeliveries(ctx, id)
+}
+
+func (s *Storage) RetrieveQueueStats(ctx context.Context, ids []string) ([]*storage.QueueStats, error) {
+	return s.queue.RetrieveQueueStats(ctx, ids)
+}
+
+func (s *Storage) CountCommands(ctx context.Context, since time.Time, interval time.Duration) ([]*storage.CommandCounts, error) {
+	return s.queue.CountCommands(ctx, since, interval)
+}
+
+// PurgeQueues purges the queues of enrollments that are disabled
+// (including soft-deleted ones, see Storage) or, if idleBefore is not
+// zero, were last seen before idleBefore.
+func (s *Storage) PurgeQueues(ctx context.Context, idleBefore time.Time, archive func(*storage.PurgedCommand) error) (int, error) {
+	enrollments, err := s.AllStorage.ListEnrollments(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var total int
+	for _, e := range enrollments {
+		purge := !e.Enabled
+		if !idleBefore.IsZero() && e.LastSeenAt != nil && e.LastSeenAt.Before(idleBefore) {
+			purge = true
+		}
+		if !purge {
+			continue
+		}
+		n, err := s.queue.PurgeQueue(ctx, e.ID, archive)
+		if err != nil {
+			return total, fmt.Errorf("purging queue of %s: %w", e.ID, err)
+		}
+		total += n
+	}
+	return total, nil
+}
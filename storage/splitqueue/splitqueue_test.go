@@ -0,0 +1,123 @@
+package splitqueue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
+	"github.com/jessepeterson/nanomdm/storage/file"
+	"github.com/jessepeterson/nanomdm/storage/memqueue"
+	"github.com/jessepeterson/nanomdm/storage/storagetest"
+)
+
+func newStorage(t testing.TB) *Storage {
+	fileStorage, err := file.New(t.TempDir(), file.WithSyncInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fileStorage.Close() })
+	return New(fileStorage, memqueue.New())
+}
+
+func TestSplitQueue(t *testing.T) {
+	ctx := context.Background()
+	s := newStorage(t)
+	r, err := storagetest.Enroll(ctx, s, "AAAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idErrs, err := s.EnqueueCommand(ctx, []string{r.ID, "unknown"}, storagetest.Command("A"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idErrs) != 1 || !errors.Is(idErrs["unknown"], storage.ErrNotFound) {
+		t.Fatalf("unexpected enqueue errors: %v", idErrs)
+	}
+	if _, err = s.EnqueueCommand(ctx, []string{r.ID}, storagetest.Command("B")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the command queue is not in the enrollment storage
+	if cmd, err := s.AllStorage.RetrieveNextCommand(r, false); err != nil || cmd != nil {
+		t.Fatalf("enrollment storage: have %v (%v), want no command", cmd, err)
+	}
+
+	cmd, err := s.RetrieveNextCommand(r, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := cmd.CommandUUID, "A"; have != want {
+		t.Fatalf("next command: have %q, want %q", have, want)
+	}
+	if err = s.StoreCommandReport(r, &mdm.CommandResults{CommandUUID: "A", Status: "NotNow"}); err != nil {
+		t.Fatal(err)
+	}
+	// skipping NotNow'd commands
+	if cmd, err = s.RetrieveNextCommand(r, true); err != nil {
+		t.Fatal(err)
+	} else if have, want := cmd.CommandUUID, "B"; have != want {
+		t.Fatalf("next command: have %q, want %q", have, want)
+	}
+	report := &mdm.CommandResults{CommandUUID: "B", Status: "Acknowledged", Raw: []byte("B")}
+	if err = s.StoreCommandReport(r, report); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.StoreCommandReport(r, report); !errors.Is(err, storage.ErrDuplicateReport) {
+		t.Fatalf("have %v, want %v", err, storage.ErrDuplicateReport)
+	}
+
+	// the enrollment storage records the last seen time
+	enrollments, err := s.ListEnrollments(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(enrollments) != 1 || enrollments[0].LastSeenAt == nil {
+		t.Fatalf("expected last seen time of enrollment: %v", enrollments)
+	}
+
+	deliveries, err := s.RetrieveCommandDeliveries(ctx, r.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 2 || deliveries[0].NotNowCount != 1 || deliveries[1].ResolvedAt == nil {
+		t.Fatalf("unexpected deliveries: %v", deliveries)
+	}
+	if _, err = s.RetrieveCommandDeliveries(ctx, "unknown"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("have %v, want %v", err, storage.ErrNotFound)
+	}
+
+	stats, err := s.RetrieveQueueStats(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 || stats[0].Pending != 1 {
+		t.Fatalf("unexpected queue stats: %v", stats)
+	}
+
+	if err = s.ClearQueue(r); err != nil {
+		t.Fatal(err)
+	}
+	if cmd, err = s.RetrieveNextCommand(r, false); err != nil || cmd != nil {
+		t.Fatalf("cleared queue: have %v (%v), want no command", cmd, err)
+	}
+
+	var archived int
+	n, err := s.PurgeQueues(ctx, time.Now().Add(time.Hour), func(*storage.PurgedCommand) error {
+		archived++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || archived != 2 {
+		t.Errorf("purged %d (archived %d), want 2", n, archived)
+	}
+}
+
+func BenchmarkQueue(b *testing.B) {
+	storagetest.BenchmarkQueue(b, newStorage(b), "5B1D")
+}
@@ -5,6 +5,8 @@ package storage
 import (
 	"context"
 	"crypto/tls"
+	"errors"
+	"time"
 
 	"github.com/jessepeterson/nanomdm/mdm"
 )
@@ -16,6 +18,27 @@ type CheckinStore interface {
 	Disable(r *mdm.Request) error
 }
 
+// ErrDuplicateReport is returned by StoreCommandReport when the same
+// command report was already stored, i.e. a device re-sent it after a
+// network failure. Only the last seen time is updated. It does not
+// indicate a failure.
+var ErrDuplicateReport = errors.New("duplicate command report")
+
+// Errors returned (possibly wrapped) by storage backends so that callers
+// can tell failures apart with errors.Is.
+var (
+	// ErrNotFound is returned when the requested item (e.g. an
+	// enrollment or push certificate) does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when an item conflicts with an existing
+	// item (e.g. a duplicate command UUID).
+	ErrConflict = errors.New("conflict")
+	// ErrDisabledEnrollment is returned when an operation requires an
+	// enabled enrollment (e.g. enqueueing a command) but the
+	// enrollment is disabled (i.e. unenrolled or re-enrolling).
+	ErrDisabledEnrollment = errors.New("enrollment disabled")
+)
+
 // CommandAndReportResultsStore stores and retrieves MDM command queue data.
 type CommandAndReportResultsStore interface {
 	StoreCommandReport(r *mdm.Request, report *mdm.CommandResults) error
@@ -23,12 +46,64 @@ type CommandAndReportResultsStore interface {
 	ClearQueue(r *mdm.Request) error
 }
 
+// CommandReportAndNextStore stores a command report and retrieves the
+// next command in a single storage transaction. It is optional: the
+// NanoMDM service uses it in place of separate StoreCommandReport and
+// RetrieveNextCommand calls when its ServiceStore implements it. For
+// duplicate reports the next command is returned with (a wrapped)
+// ErrDuplicateReport.
+type CommandReportAndNextStore interface {
+	StoreCommandReportAndRetrieveNext(r *mdm.Request, report *mdm.CommandResults, skipNotNow bool) (*mdm.Command, error)
+}
+
+// QueueReplaceStore clears a queue and enqueues a command to it in a
+// single storage transaction so that no other command can be enqueued
+// in between. It is optional: users fall back to separate ClearQueue
+// and EnqueueCommand calls when storage does not implement it.
+type QueueReplaceStore interface {
+	// ReplaceQueue clears the queue of the device channel enrollment of
+	// r (and its user channel enrollments) and enqueues cmd to it.
+	ReplaceQueue(r *mdm.Request, cmd *mdm.Command) error
+}
+
+// NextCommandsStore retrieves several of the next queued commands so
+// that a service can choose which of them to deliver.
+type NextCommandsStore interface {
+	// RetrieveNextCommands retrieves up to limit of the next commands
+	// in queue order (previously NotNow'd commands first unless
+	// skipNotNow). Their delivery is not recorded.
+	RetrieveNextCommands(r *mdm.Request, skipNotNow bool, limit int) ([]*mdm.Command, error)
+	// StoreCommandDelivered records the delivery of command uuid.
+	StoreCommandDelivered(r *mdm.Request, uuid string) error
+}
+
 // ServiceStore stores & retrieves both command and check-in data.
 type ServiceStore interface {
 	CheckinStore
 	CommandAndReportResultsStore
 }
 
+// QueueStore stores the command queues of enrollments. It allows the
+// queue to be backed by a different system than the enrollment storage
+// (see the splitqueue package). Unlike the queue of the enrollment
+// storage it does not know about enrollments: EnqueueCommand enqueues
+// to any id, ClearQueue only clears the queue of r.ID, and
+// RetrieveCommandDeliveries returns no deliveries for unknown ids.
+type QueueStore interface {
+	CommandAndReportResultsStore
+	NextCommandsStore
+	CommandEnqueuer
+	CommandDeliveryStore
+	QueueStatsStore
+	CommandCountStore
+
+	// PurgeQueue deletes the queued commands, command results, and
+	// command delivery audit trail of enrollment id. If archive is not
+	// nil each command is passed to it before deletion and an error
+	// skips purging. The number of purged commands is returned.
+	PurgeQueue(ctx context.Context, id string, archive func(*PurgedCommand) error) (int, error)
+}
+
 // PushStore stores and retrieves APNs push-related data.
 type PushStore interface {
 	RetrievePushInfo(context.Context, []string) (map[string]*mdm.Push, error)
@@ -41,15 +116,514 @@ type PushCertStore interface {
 	// and should turn stale (and return true) if the certificate has
 	// changed—such as being renewed.
 	IsPushCertStale(ctx context.Context, topic string, staleToken string) (bool, error)
+	// RetrievePushCert returns ErrNotFound if no push certificate of
+	// topic is stored.
 	RetrievePushCert(ctx context.Context, topic string) (cert *tls.Certificate, staleToken string, err error)
 	StorePushCert(ctx context.Context, pemCert, pemKey []byte) error
 }
 
+// PushCertListStore lists and deletes stored APNs push certificates.
+type PushCertListStore interface {
+	// ListPushCerts returns the PEM-encoded certificates of all stored
+	// push certificates by topic.
+	ListPushCerts(ctx context.Context) (map[string][]byte, error)
+	// DeletePushCert deletes the push certificate and private key of
+	// topic. It returns ErrNotFound if none is stored.
+	DeletePushCert(ctx context.Context, topic string) error
+}
+
 // CommandEnqueuer is able to enqueue MDM commands.
 type CommandEnqueuer interface {
+	// EnqueueCommand enqueues cmd to ids. Errors of individual ids are
+	// returned by id: ErrNotFound for unknown enrollments,
+	// ErrDisabledEnrollment for disabled enrollments, and ErrConflict
+	// for duplicate command UUIDs.
 	EnqueueCommand(ctx context.Context, id []string, cmd *mdm.Command) (map[string]error, error)
 }
 
+// Enrollment is a summary of an MDM enrollment.
+type Enrollment struct {
+	ID       string `json:"id"`
+	DeviceID string `json:"device_id"`
+	Type     string `json:"type"`
+	Topic    string `json:"topic,omitempty"`
+	Enabled  bool   `json:"enabled"`
+	// LastSeenAt is when the enrollment last sent a TokenUpdate or
+	// connected to the MDM endpoint.
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+
+	// ParentID is the device channel enrollment ID of a user channel
+	// enrollment as recorded at TokenUpdate.
+	ParentID      string `json:"parent_id,omitempty"`
+	UserShortName string `json:"user_short_name,omitempty"`
+	UserLongName  string `json:"user_long_name,omitempty"`
+	// UserChannels are the user channel enrollment IDs of a device
+	// channel enrollment.
+	UserChannels []string `json:"user_channels,omitempty"`
+
+	// Parsed fields of the last TokenUpdate.
+	HasPushMagic          bool `json:"has_push_magic"`
+	AwaitingConfiguration bool `json:"awaiting_configuration"`
+	NotOnConsole          bool `json:"not_on_console,omitempty"`
+}
+
+// LinkUserChannels sets the UserChannels of the device channel
+// enrollments from the ParentIDs of the user channel enrollments.
+func LinkUserChannels(enrollments []*Enrollment) {
+	devices := make(map[string]*Enrollment)
+	for _, e := range enrollments {
+		if e.ParentID == "" {
+			devices[e.ID] = e
+		}
+	}
+	for _, e := range enrollments {
+		if parent, ok := devices[e.ParentID]; ok && e.ParentID != "" {
+			parent.UserChannels = append(parent.UserChannels, e.ID)
+		}
+	}
+}
+
+// EnrollmentLister lists MDM enrollments.
+type EnrollmentLister interface {
+	ListEnrollments(ctx context.Context) ([]*Enrollment, error)
+}
+
+// EnrollmentStatusStore reports whether enrollments are enabled.
+type EnrollmentStatusStore interface {
+	// EnrollmentEnabled reports whether enrollment id is enabled. It
+	// returns ErrNotFound for unknown enrollments.
+	EnrollmentEnabled(ctx context.Context, id string) (bool, error)
+}
+
+// UserChannelLister lists the user channel enrollments of devices.
+type UserChannelLister interface {
+	// ListUserChannelIDs lists the enabled user channel enrollment IDs
+	// of the device channel enrollment IDs deviceIDs. Devices without
+	// user channel enrollments are omitted.
+	ListUserChannelIDs(ctx context.Context, deviceIDs []string) (map[string][]string, error)
+}
+
+// CommandDelivery is the delivery audit trail of a queued command for
+// a single enrollment. Nil times mean the event has not happened.
+type CommandDelivery struct {
+	ID          string `json:"id"`
+	CommandUUID string `json:"command_uuid"`
+	RequestType string `json:"request_type,omitempty"`
+	// Status is the status of the last command report, if any.
+	Status string `json:"status,omitempty"`
+	// Active is false once the queue has been cleared of this command.
+	Active bool `json:"active"`
+
+	EnqueuedAt       time.Time  `json:"enqueued_at"`
+	FirstDeliveredAt *time.Time `json:"first_delivered_at,omitempty"`
+	LastDeliveredAt  *time.Time `json:"last_delivered_at,omitempty"`
+	// DeliveryCount is the number of times the command was sent to the
+	// enrollment. Counts above one are re-deliveries (after NotNow).
+	DeliveryCount int        `json:"delivery_count"`
+	LastNotNowAt  *time.Time `json:"last_not_now_at,omitempty"`
+	NotNowCount   int        `json:"not_now_count"`
+	// ResolvedAt is when a final (non-NotNow) report was received.
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// CommandDeliveryStore retrieves command delivery audit trails.
+type CommandDeliveryStore interface {
+	// RetrieveCommandDeliveries returns the delivery audit trail of the
+	// commands queued for enrollment id in queue order. ErrNotFound
+	// is returned for unknown enrollments.
+	RetrieveCommandDeliveries(ctx context.Context, id string) ([]*CommandDelivery, error)
+}
+
+// QueueStats are the statistics of the pending (active and unresolved)
+// commands queued for an enrollment.
+type QueueStats struct {
+	ID      string `json:"id"`
+	Pending int    `json:"pending"`
+	// OldestEnqueuedAt is when the oldest pending command was enqueued.
+	OldestEnqueuedAt *time.Time `json:"oldest_enqueued_at,omitempty"`
+}
+
+// QueueStatsStore retrieves command queue statistics.
+type QueueStatsStore interface {
+	// RetrieveQueueStats returns the queue statistics of ids or, if
+	// none are given, of all enrollments with pending commands.
+	RetrieveQueueStats(ctx context.Context, ids []string) ([]*QueueStats, error)
+}
+
+// EnrollmentCounts are the numbers of enrollments by status and type.
+type EnrollmentCounts struct {
+	Total                 int `json:"total"`
+	Enabled               int `json:"enabled"`
+	Disabled              int `json:"disabled"`
+	AwaitingConfiguration int `json:"awaiting_configuration"`
+	// ByType are the numbers of enabled enrollments by enrollment type.
+	ByType map[string]int `json:"by_type"`
+}
+
+// Add adds n enrollments like e to the counts.
+func (c *EnrollmentCounts) Add(e *Enrollment, n int) {
+	if c.ByType == nil {
+		c.ByType = make(map[string]int)
+	}
+	c.Total += n
+	if !e.Enabled {
+		c.Disabled += n
+		return
+	}
+	c.Enabled += n
+	c.ByType[e.Type] += n
+	if e.AwaitingConfiguration {
+		c.AwaitingConfiguration += n
+	}
+}
+
+// EnrollmentCountStore counts enrollments.
+type EnrollmentCountStore interface {
+	CountEnrollments(ctx context.Context) (*EnrollmentCounts, error)
+}
+
+// CommandCounts are the numbers of commands enqueued (per enrollment)
+// and reported in the time bucket starting at Start.
+type CommandCounts struct {
+	Start    time.Time `json:"start"`
+	Enqueued int       `json:"enqueued"`
+	// Acknowledged and Errored count commands resolved with an
+	// Acknowledged and an Error (or CommandFormatError) status.
+	Acknowledged int `json:"acknowledged"`
+	Errored      int `json:"errored"`
+	// NotNow counts commands whose last NotNow was in the bucket.
+	NotNow int `json:"not_now"`
+}
+
+// CommandCountBuckets returns the empty command count buckets of width
+// interval from since until now.
+func CommandCountBuckets(since, now time.Time, interval time.Duration) []*CommandCounts {
+	var buckets []*CommandCounts
+	for start := since; start.Before(now); start = start.Add(interval) {
+		buckets = append(buckets, &CommandCounts{Start: start})
+	}
+	return buckets
+}
+
+// CountDeliveries adds the command delivery audit trails deliveries to
+// the command count buckets (see CommandCountBuckets) of width interval.
+func CountDeliveries(buckets []*CommandCounts, interval time.Duration, deliveries []*CommandDelivery) {
+	bucket := func(t *time.Time) *CommandCounts {
+		if t == nil || len(buckets) < 1 || t.Before(buckets[0].Start) {
+			return nil
+		}
+		i := int(t.Sub(buckets[0].Start) / interval)
+		if i >= len(buckets) {
+			return nil
+		}
+		return buckets[i]
+	}
+	for _, d := range deliveries {
+		if b := bucket(&d.EnqueuedAt); b != nil {
+			b.Enqueued++
+		}
+		if b := bucket(d.LastNotNowAt); b != nil {
+			b.NotNow++
+		}
+		if b := bucket(d.ResolvedAt); b != nil {
+			switch d.Status {
+			case "Acknowledged":
+				b.Acknowledged++
+			case "Error", "CommandFormatError":
+				b.Errored++
+			}
+		}
+	}
+}
+
+// CommandCountStore counts commands over time.
+type CommandCountStore interface {
+	// CountCommands returns the command counts in buckets of width
+	// interval from since until now (oldest first).
+	CountCommands(ctx context.Context, since time.Time, interval time.Duration) ([]*CommandCounts, error)
+}
+
+// PurgedCommand is a command (and its result, if any) purged from the
+// queue of an enrollment.
+type PurgedCommand struct {
+	ID          string
+	CommandUUID string
+	RequestType string
+	Status      string
+	Command     []byte
+	Result      []byte
+}
+
+// QueuePurgeStore deletes the command queues of enrollments.
+type QueuePurgeStore interface {
+	// PurgeQueues deletes the queued commands, command results, and
+	// command delivery audit trails of enrollments that are disabled
+	// (e.g. checked-out) or, if idleBefore is not zero, that were last
+	// seen before idleBefore. If archive is not nil each command is
+	// passed to it before deletion and an error skips purging that
+	// enrollment. The number of purged commands is returned.
+	PurgeQueues(ctx context.Context, idleBefore time.Time, archive func(*PurgedCommand) error) (int, error)
+}
+
+// DeletedEnrollment is a soft-deleted (i.e. checked-out) device channel
+// enrollment whose command queue and sub-enrollments can be restored.
+type DeletedEnrollment struct {
+	ID        string    `json:"id"`
+	DeletedAt time.Time `json:"deleted_at"`
+	// SubEnrollments are the IDs of the user channel enrollments of the
+	// device at deletion.
+	SubEnrollments []string `json:"sub_enrollments,omitempty"`
+	// Pending is the number of pending commands at deletion.
+	Pending int `json:"pending"`
+	// Enabled is true once the device has re-enrolled.
+	Enabled bool `json:"enabled"`
+}
+
+var (
+	// ErrNotDeleted is returned when restoring an enrollment that is
+	// not soft-deleted.
+	ErrNotDeleted = errors.New("enrollment not deleted")
+	// ErrNotReEnrolled is returned when restoring a deleted enrollment
+	// that has not re-enrolled.
+	ErrNotReEnrolled = errors.New("enrollment not re-enrolled")
+)
+
+// SoftDeleteStore soft-deletes and restores enrollments.
+type SoftDeleteStore interface {
+	// SoftDelete records device channel enrollment r.ID (and its
+	// sub-enrollments) as deleted along with its pending commands.
+	SoftDelete(r *mdm.Request) error
+	// RetrieveDeletedEnrollments returns the deleted enrollments of ids
+	// or, if none are given, all deleted enrollments.
+	RetrieveDeletedEnrollments(ctx context.Context, ids []string) ([]*DeletedEnrollment, error)
+	// RestoreEnrollment re-activates the commands pending at deletion
+	// and restores the sub-enrollment associations of deleted device
+	// channel enrollment id. The device must have re-enrolled with the
+	// same enrollment ID. The number of re-activated commands is
+	// returned.
+	RestoreEnrollment(ctx context.Context, id string) (int, error)
+}
+
+// DeviceInventory is a set of well-known device attributes collected
+// from command results. Empty values are unknown.
+type DeviceInventory struct {
+	ID               string    `json:"id"`
+	SerialNumber     string    `json:"serial_number,omitempty"`
+	Model            string    `json:"model,omitempty"`
+	ModelName        string    `json:"model_name,omitempty"`
+	ProductName      string    `json:"product_name,omitempty"`
+	DeviceName       string    `json:"device_name,omitempty"`
+	OSVersion        string    `json:"os_version,omitempty"`
+	BuildVersion     string    `json:"build_version,omitempty"`
+	FileVaultEnabled *bool     `json:"filevault_enabled,omitempty"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// Merge sets the non-empty attributes of from in inv.
+func (inv *DeviceInventory) Merge(from *DeviceInventory) {
+	for _, f := range []struct{ dst, src *string }{
+		{&inv.SerialNumber, &from.SerialNumber},
+		{&inv.Model, &from.Model},
+		{&inv.ModelName, &from.ModelName},
+		{&inv.ProductName, &from.ProductName},
+		{&inv.DeviceName, &from.DeviceName},
+		{&inv.OSVersion, &from.OSVersion},
+		{&inv.BuildVersion, &from.BuildVersion},
+	} {
+		if *f.src != "" {
+			*f.dst = *f.src
+		}
+	}
+	if from.FileVaultEnabled != nil {
+		inv.FileVaultEnabled = from.FileVaultEnabled
+	}
+	if from.UpdatedAt.After(inv.UpdatedAt) {
+		inv.UpdatedAt = from.UpdatedAt
+	}
+}
+
+// InventoryStore stores and retrieves device inventory.
+type InventoryStore interface {
+	// StoreInventory merges the non-empty attributes of inv into the
+	// inventory of enrollment inv.ID.
+	StoreInventory(ctx context.Context, inv *DeviceInventory) error
+	// RetrieveInventory retrieves the inventory of enrollments ids or
+	// of all enrollments if ids is empty.
+	RetrieveInventory(ctx context.Context, ids []string) ([]*DeviceInventory, error)
+}
+
+// OSUpdateState is the state of an OS update rollout to an enrollment.
+type OSUpdateState struct {
+	ID string `json:"id"`
+	// Cohort groups enrollments of a staged rollout.
+	Cohort         string `json:"cohort,omitempty"`
+	ProductKey     string `json:"product_key,omitempty"`
+	ProductVersion string `json:"product_version,omitempty"`
+	InstallAction  string `json:"install_action,omitempty"`
+	// CommandUUID is the UUID of the ScheduleOSUpdate command.
+	CommandUUID string `json:"command_uuid,omitempty"`
+	State       string `json:"state"`
+	// DeviceStatus is the last update status reported by the device.
+	DeviceStatus    string    `json:"device_status,omitempty"`
+	PercentComplete float64   `json:"percent_complete,omitempty"`
+	Error           string    `json:"error,omitempty"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// OSUpdateStore stores and retrieves OS update rollout states.
+type OSUpdateStore interface {
+	// StoreOSUpdateState stores (replaces) the state of enrollment state.ID.
+	StoreOSUpdateState(ctx context.Context, state *OSUpdateState) error
+	// RetrieveOSUpdateStates retrieves the states of enrollments ids
+	// (or all enrollments if ids is empty) optionally limited to cohort.
+	RetrieveOSUpdateStates(ctx context.Context, ids []string, cohort string) ([]*OSUpdateState, error)
+}
+
+// AppInstall tracks an application install command sent to an enrollment.
+type AppInstall struct {
+	ID            string `json:"id"`
+	CommandUUID   string `json:"command_uuid"`
+	RequestType   string `json:"request_type"`
+	Identifier    string `json:"identifier,omitempty"`
+	ITunesStoreID int64  `json:"itunes_store_id,omitempty"`
+	ManifestURL   string `json:"manifest_url,omitempty"`
+	// Status is the status of the command result (e.g. Acknowledged,
+	// Error, NotNow) or empty if the command has no result yet.
+	Status string `json:"status,omitempty"`
+	// State is the app install state reported by the device (e.g.
+	// Queued, Installing, Managed).
+	State     string    `json:"state,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AppInstallStore stores and retrieves application install tracking.
+type AppInstallStore interface {
+	// StoreAppInstall stores (replaces) the install of inst.ID and
+	// inst.CommandUUID.
+	StoreAppInstall(ctx context.Context, inst *AppInstall) error
+	// RetrieveAppInstalls retrieves the installs of ids (or all
+	// enrollments if ids is empty).
+	RetrieveAppInstalls(ctx context.Context, ids []string) ([]*AppInstall, error)
+}
+
+// AppManifestStore stores and retrieves hosted application manifests.
+type AppManifestStore interface {
+	StoreAppManifest(ctx context.Context, name string, manifest []byte) error
+	// RetrieveAppManifest returns a nil manifest if name is not found.
+	RetrieveAppManifest(ctx context.Context, name string) ([]byte, error)
+}
+
+// InstalledApp is an application installed on an enrollment.
+type InstalledApp struct {
+	ID           string `json:"id"`
+	Identifier   string `json:"identifier"`
+	Name         string `json:"name,omitempty"`
+	Version      string `json:"version,omitempty"`
+	ShortVersion string `json:"short_version,omitempty"`
+	// ManagedStatus is the status of a managed app (e.g. Managed,
+	// Installing, UserRejected) or empty for unmanaged apps.
+	ManagedStatus string    `json:"managed_status,omitempty"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// AppInventoryStore stores and retrieves the applications installed on
+// enrollments.
+type AppInventoryStore interface {
+	// StoreAppInventory replaces the installed applications of id.
+	StoreAppInventory(ctx context.Context, id string, apps []*InstalledApp) error
+	// RetrieveAppInventory retrieves the installed applications of ids
+	// (or all enrollments if ids is empty).
+	RetrieveAppInventory(ctx context.Context, ids []string) ([]*InstalledApp, error)
+}
+
+// LostModeState is the Lost Mode state of an enrollment. Location
+// fields are those of the last DeviceLocation result.
+type LostModeState struct {
+	ID string `json:"id"`
+	// State is one of enabling, enabled, disabling, or disabled.
+	State       string `json:"state"`
+	Message     string `json:"message,omitempty"`
+	PhoneNumber string `json:"phone_number,omitempty"`
+	Footnote    string `json:"footnote,omitempty"`
+	// CommandUUID and RequestType are of the last Lost Mode command.
+	CommandUUID        string     `json:"command_uuid,omitempty"`
+	RequestType        string     `json:"request_type,omitempty"`
+	Error              string     `json:"error,omitempty"`
+	Latitude           *float64   `json:"latitude,omitempty"`
+	Longitude          *float64   `json:"longitude,omitempty"`
+	HorizontalAccuracy *float64   `json:"horizontal_accuracy,omitempty"`
+	LocatedAt          *time.Time `json:"located_at,omitempty"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+}
+
+// LostModeStore stores and retrieves Lost Mode states.
+type LostModeStore interface {
+	// StoreLostMode stores (replaces) the Lost Mode state of state.ID.
+	StoreLostMode(ctx context.Context, state *LostModeState) error
+	// RetrieveLostMode retrieves the Lost Mode states of ids (or all
+	// enrollments if ids is empty).
+	RetrieveLostMode(ctx context.Context, ids []string) ([]*LostModeState, error)
+}
+
+// BypassCodeStore stores and retrieves escrowed Activation Lock bypass
+// codes. Codes are sealed (encrypted) by the caller.
+type BypassCodeStore interface {
+	StoreBypassCode(ctx context.Context, id string, sealedCode []byte) error
+	// RetrieveBypassCode returns a nil code if none is escrowed for id.
+	RetrieveBypassCode(ctx context.Context, id string) (sealedCode []byte, err error)
+}
+
+// UnlockTokenStore stores and retrieves escrowed device Unlock Tokens.
+// Tokens are sealed (encrypted) by the caller.
+type UnlockTokenStore interface {
+	StoreUnlockToken(ctx context.Context, id string, sealedToken []byte) error
+	// RetrieveUnlockToken returns a nil token if none is escrowed for id.
+	RetrieveUnlockToken(ctx context.Context, id string) (sealedToken []byte, err error)
+}
+
+// CommandCallbackStore stores the URLs that the results of commands
+// are posted to.
+type CommandCallbackStore interface {
+	// StoreCommandCallback stores the callback URL of the command
+	// enqueued for ids.
+	StoreCommandCallback(ctx context.Context, ids []string, commandUUID, url string) error
+	// RetrieveCommandCallback returns an empty URL if the command has
+	// no callback for enrollment id.
+	RetrieveCommandCallback(ctx context.Context, id, commandUUID string) (url string, err error)
+}
+
+// DevicePassword is a managed device password (e.g. recovery lock or
+// firmware password) of an enrollment. Passwords are sealed (encrypted)
+// by the caller.
+type DevicePassword struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	// SealedPassword is the password the device acknowledged setting.
+	SealedPassword []byte `json:"sealed_password,omitempty"`
+	// SealedPending is the password of an outstanding set command.
+	SealedPending []byte `json:"sealed_pending,omitempty"`
+	// CommandUUID is the UUID of the last set or verify command.
+	CommandUUID string `json:"command_uuid,omitempty"`
+	// Status is one of pending, set, cleared, or failed.
+	Status string `json:"status"`
+	// Verified is the result of the last verify command.
+	Verified  *bool      `json:"verified,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// DevicePasswordStore stores and retrieves managed device passwords.
+type DevicePasswordStore interface {
+	// StoreDevicePassword stores (replaces) the password of pw.ID and pw.Kind.
+	StoreDevicePassword(ctx context.Context, pw *DevicePassword) error
+	// RetrieveDevicePasswords retrieves the passwords of ids (or all
+	// enrollments if ids is empty) of kind (or all kinds if empty).
+	RetrieveDevicePasswords(ctx context.Context, ids []string, kind string) ([]*DevicePassword, error)
+}
+
 // CertAuthStore stores and retrieves cert-to-enrollment associations.
 type CertAuthStore interface {
 	HasCertHash(r *mdm.Request, hash string) (bool, error)
@@ -57,3 +631,125 @@ type CertAuthStore interface {
 	IsCertHashAssociated(r *mdm.Request, hash string) (bool, error)
 	AssociateCertHash(r *mdm.Request, hash string) error
 }
+
+// IdentityRotation is the MDM identity (certificate) rotation of an
+// enrollment.
+type IdentityRotation struct {
+	ID string `json:"id"`
+	// State is one of pending, installed, presented, rotated, or
+	// failed.
+	State string `json:"state"`
+	// CommandUUID is the UUID of the InstallProfile command.
+	CommandUUID string `json:"command_uuid,omitempty"`
+	// CertHash is the hash of the new certificate once presented.
+	CertHash string `json:"cert_hash,omitempty"`
+	// PreviousCertHash is the hash of the certificate associated with
+	// the enrollment when the rotation started. It is associated again
+	// if the rotation is rolled back.
+	PreviousCertHash string    `json:"previous_cert_hash,omitempty"`
+	Error            string    `json:"error,omitempty"`
+	StartedAt        time.Time `json:"started_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// IdentityRotationStore stores and retrieves identity rotations.
+type IdentityRotationStore interface {
+	// StoreIdentityRotation stores (replaces) the rotation of rot.ID.
+	StoreIdentityRotation(ctx context.Context, rot *IdentityRotation) error
+	// UpdateIdentityRotation replaces the stored rotation of rot.ID
+	// with rot only if the stored rotation is in state and has the
+	// command UUID of rot (i.e. a compare-and-set) and reports whether
+	// it was replaced. If hash is not empty the certificate
+	// associations of the enrollment are replaced with hash in the
+	// same transaction.
+	UpdateIdentityRotation(ctx context.Context, rot *IdentityRotation, state, hash string) (bool, error)
+	// RetrieveIdentityRotations retrieves the rotations of ids (or all
+	// enrollments if ids is empty).
+	RetrieveIdentityRotations(ctx context.Context, ids []string) ([]*IdentityRotation, error)
+	// RetrieveCertHash retrieves the hash of the certificate (most
+	// recently) associated with enrollment id. It is empty if there is
+	// none.
+	RetrieveCertHash(ctx context.Context, id string) (string, error)
+}
+
+// GroupStore stores named groups of enrollments. Members are not
+// checked against the stored enrollments so groups may name
+// enrollments before they enroll.
+type GroupStore interface {
+	// AddGroupMembers adds enrollments ids to group name, creating the
+	// group if needed.
+	AddGroupMembers(ctx context.Context, name string, ids []string) error
+	// RemoveGroupMembers removes enrollments ids from group name.
+	// Groups without members no longer exist.
+	RemoveGroupMembers(ctx context.Context, name string, ids []string) error
+	// DeleteGroup deletes group name. It returns ErrNotFound if the
+	// group does not exist.
+	DeleteGroup(ctx context.Context, name string) error
+	// RetrieveGroupMembers returns the sorted enrollment IDs of group
+	// name. It returns ErrNotFound if the group does not exist.
+	RetrieveGroupMembers(ctx context.Context, name string) ([]string, error)
+	// ListGroups returns the number of members of all groups by name.
+	ListGroups(ctx context.Context) (map[string]int, error)
+}
+
+// SmartGroup is a named inventory filter expression. Its members are
+// the enrollments whose inventory matches the filter at the time of
+// evaluation.
+type SmartGroup struct {
+	Name      string    `json:"name"`
+	Filter    string    `json:"filter"`
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+}
+
+// SmartGroupStore stores smart groups. Filters are stored as given
+// and are not evaluated or validated by storage.
+type SmartGroupStore interface {
+	// StoreSmartGroup stores (replaces) smart group g.Name.
+	StoreSmartGroup(ctx context.Context, g *SmartGroup) error
+	// RetrieveSmartGroups retrieves the smart groups of names (or all
+	// smart groups if names is empty) sorted by name.
+	RetrieveSmartGroups(ctx context.Context, names []string) ([]*SmartGroup, error)
+	// DeleteSmartGroup deletes smart group name. It returns
+	// ErrNotFound if the smart group does not exist.
+	DeleteSmartGroup(ctx context.Context, name string) error
+}
+
+// WorkflowRun is the execution state of a workflow (a sequence of
+// commands) for an enrollment.
+type WorkflowRun struct {
+	ID       string `json:"id"`
+	Workflow string `json:"workflow"`
+	// State is one of running, completed, or failed.
+	State string `json:"state"`
+	// Step is the index of the current (or, once done, last) step.
+	Step int `json:"step"`
+	// CommandUUID is the UUID of the command of the current step.
+	CommandUUID string `json:"command_uuid,omitempty"`
+	// Attempts is the number of times the current step was enqueued.
+	Attempts int `json:"attempts"`
+	// Error is the error of the last failed step.
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WorkflowStore stores and retrieves workflow runs.
+type WorkflowStore interface {
+	// StoreWorkflowRun stores (replaces) the run of workflow
+	// run.Workflow for enrollment run.ID.
+	StoreWorkflowRun(ctx context.Context, run *WorkflowRun) error
+	// RetrieveWorkflowRuns retrieves the workflow runs of ids (or all
+	// enrollments if ids is empty) sorted by ID and workflow.
+	RetrieveWorkflowRuns(ctx context.Context, ids []string) ([]*WorkflowRun, error)
+}
+
+// QueryStats are the statistics of the queries run by a storage method
+// of instrumented storage. Durations are those of executing the queries
+// (not of reading their rows).
+type QueryStats struct {
+	Count        uint64  `json:"count"`
+	Errors       uint64  `json:"errors"`
+	Slow         uint64  `json:"slow"`
+	TotalSeconds float64 `json:"total_seconds"`
+	MaxSeconds   float64 `json:"max_seconds"`
+}
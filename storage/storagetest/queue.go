@@ -0,0 +1,163 @@
+// Package storagetest contains benchmarks shared by the storage
+// backends so that their performance can be compared.
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/jessepeterson/nanomdm/mdm"
+	"github.com/jessepeterson/nanomdm/storage"
+)
+
+// QueueStore is the storage used by the queue benchmarks.
+type QueueStore interface {
+	storage.CheckinStore
+	storage.CommandAndReportResultsStore
+	storage.CommandEnqueuer
+}
+
+const checkinFormat = `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>MessageType</key>
+	<string>%s</string>
+	<key>PushMagic</key>
+	<string>CEFDF0BD-E342-4A27-8742-E930EA116B0A</string>
+	<key>Token</key>
+	<data>R+juwGLC9ynsFwPBs+GPGXHYXwC+dkRdNAgLqnAbX1E=</data>
+	<key>Topic</key>
+	<string>com.apple.mgmt.External.storagetest</string>
+	<key>UDID</key>
+	<string>%s</string>
+</dict>
+</plist>`
+
+// Enroll stores the Authenticate and TokenUpdate check-in messages of
+// the device enrollment id in s and returns its request.
+func Enroll(ctx context.Context, s storage.CheckinStore, id string) (*mdm.Request, error) {
+	r := &mdm.Request{
+		EnrollID: &mdm.EnrollID{ID: id, Type: mdm.Device},
+		Context:  ctx,
+	}
+	for _, messageType := range []string{"Authenticate", "TokenUpdate"} {
+		msg, err := mdm.DecodeCheckin([]byte(fmt.Sprintf(checkinFormat, messageType, id)))
+		if err != nil {
+			return nil, err
+		}
+		switch msg := msg.(type) {
+		case *mdm.Authenticate:
+			err = s.StoreAuthenticate(r, msg)
+		case *mdm.TokenUpdate:
+			err = s.StoreTokenUpdate(r, msg)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("storing %s: %w", messageType, err)
+		}
+	}
+	return r, nil
+}
+
+// Command returns a new DeviceInformation command with uuid.
+func Command(uuid string) *mdm.Command {
+	return &mdm.Command{
+		CommandUUID: uuid,
+		Command:     struct{ RequestType string }{"DeviceInformation"},
+		Raw: []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>Command</key>
+	<dict>
+		<key>RequestType</key>
+		<string>DeviceInformation</string>
+	</dict>
+	<key>CommandUUID</key>
+	<string>` + uuid + `</string>
+</dict>
+</plist>`),
+	}
+}
+
+// BenchmarkQueue runs the queue benchmarks against s. The enrollment
+// and command identifiers are prefixed with prefix (hex digits, as
+// they're UDIDs) so that persistent backends can be benchmarked
+// repeatedly.
+func BenchmarkQueue(b *testing.B, s QueueStore, prefix string) {
+	ctx := context.Background()
+	var runs int
+	// enroll returns a request of a new enrollment.
+	enroll := func(b *testing.B) *mdm.Request {
+		runs++
+		r, err := Enroll(ctx, s, fmt.Sprintf("%s-%08X", prefix, runs))
+		if err != nil {
+			b.Fatal(err)
+		}
+		return r
+	}
+
+	b.Run("Enqueue", func(b *testing.B) {
+		r := enroll(b)
+		ids := []string{r.ID}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := s.EnqueueCommand(ctx, ids, Command(r.ID+"-"+strconv.Itoa(i))); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("EnqueueMany", func(b *testing.B) {
+		var ids []string
+		for i := 0; i < 100; i++ {
+			ids = append(ids, enroll(b).ID)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := s.EnqueueCommand(ctx, ids, Command(ids[0]+"-"+strconv.Itoa(i))); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	// a device connecting, receiving its next command, and
+	// acknowledging it
+	b.Run("NextAndReport", func(b *testing.B) {
+		r := enroll(b)
+		for i := 0; i < b.N; i++ {
+			if _, err := s.EnqueueCommand(ctx, []string{r.ID}, Command(r.ID+"-"+strconv.Itoa(i))); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			cmd, err := s.RetrieveNextCommand(r, false)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if cmd == nil {
+				b.Fatal("no next command")
+			}
+			err = s.StoreCommandReport(r, &mdm.CommandResults{CommandUUID: cmd.CommandUUID, Status: "Acknowledged"})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	// an idle device connecting to an empty queue
+	b.Run("Idle", func(b *testing.B) {
+		r := enroll(b)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			err := s.StoreCommandReport(r, &mdm.CommandResults{Status: "Idle"})
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err = s.RetrieveNextCommand(r, false); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
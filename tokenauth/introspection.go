@@ -0,0 +1,86 @@
+package tokenauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Introspection is an identity provider that validates tokens with an
+// OAuth 2.0 token introspection endpoint (RFC 7662) of an identity
+// provider. The identity subject is the "username" of active tokens or
+// else their "sub".
+type Introspection struct {
+	url          string
+	clientID     string
+	clientSecret string
+	client       *http.Client
+}
+
+// IntrospectionOption configures an Introspection.
+type IntrospectionOption func(*Introspection)
+
+// WithIntrospectionClient uses client to call the introspection
+// endpoint (e.g. to connect through a proxy).
+func WithIntrospectionClient(client *http.Client) IntrospectionOption {
+	return func(i *Introspection) {
+		i.client = client
+	}
+}
+
+// NewIntrospection creates a new token introspection identity provider
+// authenticating to the endpoint at introspectionURL with the client
+// credentials.
+func NewIntrospection(introspectionURL, clientID, clientSecret string, opts ...IntrospectionOption) *Introspection {
+	i := &Introspection{
+		url:          introspectionURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		client:       http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(i)
+	}
+	return i
+}
+
+// Identify introspects token.
+func (i *Introspection) Identify(ctx context.Context, token string) (*Identity, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if i.clientID != "" {
+		req.SetBasicAuth(url.QueryEscape(i.clientID), url.QueryEscape(i.clientSecret))
+	}
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token introspection: unexpected HTTP status %d %s", resp.StatusCode, resp.Status)
+	}
+	introspection := new(struct {
+		Active   bool   `json:"active"`
+		Subject  string `json:"sub"`
+		Username string `json:"username"`
+	})
+	if err = json.NewDecoder(resp.Body).Decode(introspection); err != nil {
+		return nil, fmt.Errorf("token introspection: %w", err)
+	}
+	subject := introspection.Username
+	if subject == "" {
+		subject = introspection.Subject
+	}
+	if !introspection.Active || subject == "" {
+		return nil, ErrInvalidToken
+	}
+	return &Identity{Subject: subject}, nil
+}
@@ -0,0 +1,104 @@
+// Package tokenauth authenticates the MDM requests of account-driven
+// User Enrollments with bearer access tokens (sent in the
+// Authorization header) rather than client certificates.
+package tokenauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalidToken is returned by identity providers for unknown,
+// expired, or otherwise invalid tokens.
+var ErrInvalidToken = errors.New("invalid bearer token")
+
+// Identity is the identity a bearer token was issued to.
+type Identity struct {
+	// Subject identifies the user, e.g. their Managed Apple Account.
+	Subject string `yaml:"subject"`
+}
+
+// Hash returns the hex SHA-256 hash that the enrollment enrollID
+// authenticated with the tokens of id is associated with (in place of a
+// certificate hash). Enrollments are thereby bound to the identity they
+// enrolled with. The hash differs per enrollment so that the same
+// identity can enroll more than one device.
+func (id *Identity) Hash(enrollID string) string {
+	hashed := sha256.Sum256([]byte("tokenauth:" + enrollID + "\n" + id.Subject))
+	return hex.EncodeToString(hashed[:])
+}
+
+// IdentityProvider maps bearer tokens to identities.
+type IdentityProvider interface {
+	// Identify returns the identity of token or ErrInvalidToken.
+	Identify(ctx context.Context, token string) (*Identity, error)
+}
+
+type contextKeyIdentity struct{}
+
+// NewContext returns a copy of ctx with the token identity id.
+func NewContext(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, contextKeyIdentity{}, id)
+}
+
+// FromContext returns the token identity of ctx or nil if the request
+// was not authenticated with a bearer token.
+func FromContext(ctx context.Context) *Identity {
+	if ctx == nil {
+		return nil
+	}
+	id, _ := ctx.Value(contextKeyIdentity{}).(*Identity)
+	return id
+}
+
+// StaticToken is a fixed bearer token of an identity.
+type StaticToken struct {
+	Token    string `yaml:"token"`
+	Identity `yaml:",inline"`
+}
+
+// StaticTokens is an identity provider of fixed tokens.
+type StaticTokens []*StaticToken
+
+// LoadStaticTokens loads static tokens from the YAML file at path of
+// the form:
+//
+//	tokens:
+//	  - token: "secret"
+//	    subject: "user@example.com"
+func LoadStaticTokens(path string) (StaticTokens, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		Tokens StaticTokens `yaml:"tokens"`
+	}
+	if err := yaml.Unmarshal(b, &config); err != nil {
+		return nil, fmt.Errorf("parsing tokens: %w", err)
+	}
+	for i, t := range config.Tokens {
+		if t.Token == "" || t.Subject == "" {
+			return nil, fmt.Errorf("token %d: missing token or subject", i+1)
+		}
+	}
+	return config.Tokens, nil
+}
+
+// Identify returns the identity of token.
+func (s StaticTokens) Identify(_ context.Context, token string) (*Identity, error) {
+	for _, t := range s {
+		if subtle.ConstantTimeCompare([]byte(t.Token), []byte(token)) == 1 {
+			id := t.Identity
+			return &id, nil
+		}
+	}
+	return nil, ErrInvalidToken
+}
@@ -0,0 +1,43 @@
+package tokenauth
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStaticTokens(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tokenauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tokens.yaml")
+	config := "tokens:\n  - token: t1\n    subject: user@example.com\n"
+	if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := LoadStaticTokens(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := tokens.Identify(context.Background(), "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Subject != "user@example.com" {
+		t.Errorf("subject: have %q, want %q", id.Subject, "user@example.com")
+	}
+	if _, err = tokens.Identify(context.Background(), "t2"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("have %v, want %v", err, ErrInvalidToken)
+	}
+	if other := (&Identity{Subject: "other@example.com"}).Hash("e1"); other == id.Hash("e1") || len(other) != 64 {
+		t.Errorf("unexpected hash: %s", other)
+	}
+	if id.Hash("e1") == id.Hash("e2") {
+		t.Error("hash should differ per enrollment")
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/cryptoutil"
+	"github.com/jessepeterson/nanomdm/mdm"
+)
+
+// ca issues device identity certificates.
+type ca struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newCA generates a new self-signed CA.
+func newCA() (*ca, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "NanoMDM loadgen CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	return &ca{cert: cert, key: key}, err
+}
+
+// loadCA loads a CA from its PEM certificate and (EC) private key.
+func loadCA(pemCert, pemKey []byte) (*ca, error) {
+	pair, err := tls.X509KeyPair(pemCert, pemKey)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("CA private key is not an EC key")
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	return &ca{cert: cert, key: key}, err
+}
+
+// issue generates a new client certificate and key for cn.
+func (c *ca) issue(cn string, serial int64) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, c.cert, &key.PublicKey, c.key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+const checkinContentType = "application/x-apple-aspen-mdm-checkin"
+
+// device is a simulated MDM device.
+type device struct {
+	udid       string
+	identity   tls.Certificate
+	signer     *cryptoutil.CMSSigner
+	pushMagic  string
+	token      []byte
+	topic      string
+	serverURL  string
+	checkinURL string
+	certHeader string
+	client     *http.Client
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newDevice creates a device with a random UDID, push token, and an
+// identity issued by ca.
+func newDevice(ca *ca, serial int64) (*device, error) {
+	d := &device{
+		udid:      strings.ToUpper(randomHex(20)),
+		pushMagic: randomHex(16),
+		token:     make([]byte, 32),
+	}
+	rand.Read(d.token)
+	var err error
+	if d.identity, err = ca.issue(d.udid, serial); err != nil {
+		return nil, err
+	}
+	d.signer, err = cryptoutil.NewCMSSigner(d.identity)
+	return d, err
+}
+
+func (d *device) authenticate() error {
+	_, err := d.send(d.checkinURL, checkinContentType, fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>MessageType</key>
+	<string>Authenticate</string>
+	<key>Model</key>
+	<string>loadgen</string>
+	<key>SerialNumber</key>
+	<string>%s</string>
+	<key>Topic</key>
+	<string>%s</string>
+	<key>UDID</key>
+	<string>%s</string>
+</dict>
+</plist>`, d.udid[:12], d.topic, d.udid))
+	return err
+}
+
+func (d *device) tokenUpdate() error {
+	_, err := d.send(d.checkinURL, checkinContentType, fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>MessageType</key>
+	<string>TokenUpdate</string>
+	<key>PushMagic</key>
+	<string>%s</string>
+	<key>Token</key>
+	<data>%s</data>
+	<key>Topic</key>
+	<string>%s</string>
+	<key>UDID</key>
+	<string>%s</string>
+</dict>
+</plist>`, d.pushMagic, base64.StdEncoding.EncodeToString(d.token), d.topic, d.udid))
+	return err
+}
+
+// report sends a command report with status (for uuid unless Idle)
+// and returns the next command, if any.
+func (d *device) report(status, uuid string) (*mdm.Command, error) {
+	var cmdUUID string
+	if uuid != "" {
+		cmdUUID = "\n\t<key>CommandUUID</key>\n\t<string>" + uuid + "</string>"
+	}
+	body, err := d.send(d.serverURL, "", fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>%s
+	<key>Status</key>
+	<string>%s</string>
+	<key>UDID</key>
+	<string>%s</string>
+</dict>
+</plist>`, cmdUUID, status, d.udid))
+	if err != nil || len(body) < 1 {
+		return nil, err
+	}
+	return mdm.DecodeCommand(body)
+}
+
+// send PUTs body to target with the device's identity and returns the
+// response body.
+func (d *device) send(target, contentType, body string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPut, target, bytes.NewBufferString(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if d.certHeader != "" {
+		req.Header.Set(d.certHeader, escapeCert(d.identity.Certificate[0]))
+	} else {
+		sig, err := d.signer.SignDetached([]byte(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Mdm-Signature", base64.StdEncoding.EncodeToString(sig))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	}
+	return respBody, nil
+}
+
+// escapeCert URL-escapes the PEM certificate of der like Nginx'
+// $ssl_client_escaped_cert.
+func escapeCert(der []byte) string {
+	return url.QueryEscape(string(cryptoutil.PEMCertificate(der)))
+}
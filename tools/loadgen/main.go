@@ -0,0 +1,298 @@
+// Command loadgen simulates MDM devices enrolling in and connecting to
+// a NanoMDM server at configurable rates and reports the latencies of
+// their requests for capacity planning and performance testing.
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/cryptoutil"
+	"github.com/jessepeterson/nanomdm/mdm"
+)
+
+// overridden by -ldflags -X
+var version = "unknown"
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: %s [flags]
+
+Simulates -devices devices enrolling (Authenticate and TokenUpdate
+check-ins) in the NanoMDM server at -url and then connecting to it
+-connects times each. Queued commands are acknowledged. Device identity
+certificates are issued by the CA at -ca and -ca-key which the server
+must trust (with its own -ca flag). Create one with -init.
+
+flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	var (
+		flURL         = flag.String("url", "", "MDM server URL (e.g. http://localhost:9000/mdm)")
+		flCheckinURL  = flag.String("checkin-url", "", "MDM check-in URL (default -url)")
+		flCA          = flag.String("ca", "loadgen-ca.pem", "path to CA certificate to issue device identities with")
+		flCAKey       = flag.String("ca-key", "loadgen-ca.key", "path to CA private key")
+		flInit        = flag.Bool("init", false, "create a new CA at -ca and -ca-key and exit")
+		flCertHeader  = flag.String("cert-header", "", "send identities in this HTTP header (like nanomdm -cert-header) instead of Mdm-Signature")
+		flTopic       = flag.String("topic", "com.apple.mgmt.External.loadgen", "APNs topic of devices")
+		flDevices     = flag.Int("devices", 10, "number of devices")
+		flConnects    = flag.Int("connects", 1, "number of connections per device after enrolling")
+		flConcurrency = flag.Int("concurrency", 10, "number of devices sending requests at the same time")
+		flEnrollRate  = flag.Float64("enroll-rate", 0, "enrollments per second (0 for unlimited)")
+		flConnectRate = flag.Float64("connect-rate", 0, "connections per second (0 for unlimited)")
+		flInsecure    = flag.Bool("insecure", false, "skip TLS server certificate verification")
+		flVersion     = flag.Bool("version", false, "print version")
+	)
+	flag.Usage = usage
+	flag.Parse()
+
+	if *flVersion {
+		fmt.Println(version)
+		return
+	}
+
+	if *flInit {
+		if err := writeCA(*flCA, *flCAKey); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("wrote CA to %s and %s", *flCA, *flCAKey)
+		return
+	}
+
+	if *flURL == "" || *flDevices < 1 || *flConcurrency < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *flCheckinURL == "" {
+		*flCheckinURL = *flURL
+	}
+
+	pemCert, err := ioutil.ReadFile(*flCA)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pemKey, err := ioutil.ReadFile(*flCAKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ca, err := loadCA(pemCert, pemKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	if *flInsecure {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+
+	log.Printf("issuing %d device identities", *flDevices)
+	devices := make([]*device, *flDevices)
+	for i := range devices {
+		d, err := newDevice(ca, int64(i)+2)
+		if err != nil {
+			log.Fatal(err)
+		}
+		d.topic = *flTopic
+		d.serverURL = *flURL
+		d.checkinURL = *flCheckinURL
+		d.certHeader = *flCertHeader
+		d.client = client
+		devices[i] = d
+	}
+
+	results := newResults()
+
+	log.Printf("enrolling %d devices", len(devices))
+	enroll := newLimiter(*flEnrollRate)
+	start := time.Now()
+	run(devices, 1, *flConcurrency, func(d *device) {
+		enroll.wait()
+		if results.time("Authenticate", d.authenticate) == nil {
+			results.time("TokenUpdate", d.tokenUpdate)
+		}
+	})
+	enrolled := time.Since(start)
+
+	log.Printf("connecting %d devices %d times", len(devices), *flConnects)
+	connect := newLimiter(*flConnectRate)
+	start = time.Now()
+	run(devices, *flConnects, *flConcurrency, func(d *device) {
+		connect.wait()
+		d.connect(results)
+	})
+	connected := time.Since(start)
+
+	fmt.Printf("enrollment: %d devices in %s\n", len(devices), enrolled.Round(time.Millisecond))
+	fmt.Printf("connections: %d in %s\n\n", len(devices)*(*flConnects), connected.Round(time.Millisecond))
+	results.print(os.Stdout)
+}
+
+// connect performs one device connection: an Idle report and the
+// acknowledgement of any queued commands.
+func (d *device) connect(results *results) {
+	var cmd *mdm.Command
+	results.time("Idle", func() (err error) {
+		cmd, err = d.report("Idle", "")
+		return
+	})
+	for cmd != nil {
+		uuid := cmd.CommandUUID
+		err := results.time("Acknowledged", func() (err error) {
+			cmd, err = d.report("Acknowledged", uuid)
+			return
+		})
+		if err != nil {
+			return
+		}
+	}
+}
+
+// run calls f for each of devices n times with up to concurrency calls
+// at the same time.
+func run(devices []*device, n, concurrency int, f func(*device)) {
+	work := make(chan *device)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range work {
+				f(d)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		for _, d := range devices {
+			work <- d
+		}
+	}
+	close(work)
+	wg.Wait()
+}
+
+// writeCA creates a new CA and writes it to the certPath and keyPath
+// PEM files.
+func writeCA(certPath, keyPath string) error {
+	ca, err := newCA()
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalECPrivateKey(ca.key)
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(certPath, cryptoutil.PEMCertificate(ca.cert.Raw), 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+}
+
+// limiter paces calls to wait to a rate per second.
+type limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// newLimiter creates a new limiter of rate per second. A rate of zero
+// is unlimited.
+func newLimiter(rate float64) *limiter {
+	if rate <= 0 {
+		return &limiter{}
+	}
+	return &limiter{interval: time.Duration(float64(time.Second) / rate)}
+}
+
+// wait blocks until the next call is due.
+func (l *limiter) wait() {
+	if l.interval == 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	due := l.next
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+	time.Sleep(due.Sub(now))
+}
+
+// results collects request latencies by message type.
+type results struct {
+	mu        sync.Mutex
+	order     []string
+	latencies map[string][]time.Duration
+	errors    map[string]int
+}
+
+func newResults() *results {
+	return &results{
+		latencies: make(map[string][]time.Duration),
+		errors:    make(map[string]int),
+	}
+}
+
+// time calls f and records its latency or error as a request of
+// messageType.
+func (r *results) time(messageType string, f func() error) error {
+	start := time.Now()
+	err := f()
+	elapsed := time.Since(start)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.latencies[messageType]; !ok {
+		r.order = append(r.order, messageType)
+		r.latencies[messageType] = nil
+	}
+	if err != nil {
+		if r.errors[messageType] == 0 {
+			log.Printf("%s: %v", messageType, err)
+		}
+		r.errors[messageType]++
+		return err
+	}
+	r.latencies[messageType] = append(r.latencies[messageType], elapsed)
+	return nil
+}
+
+// print writes the request counts and latency percentiles.
+func (r *results) print(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Fprintf(w, "%-14s %8s %8s %10s %10s %10s %10s\n", "request", "ok", "errors", "p50", "p90", "p99", "max")
+	for _, messageType := range r.order {
+		l := r.latencies[messageType]
+		sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+		fmt.Fprintf(w, "%-14s %8d %8d %10s %10s %10s %10s\n",
+			messageType, len(l), r.errors[messageType],
+			percentile(l, 50), percentile(l, 90), percentile(l, 99), percentile(l, 100),
+		)
+	}
+}
+
+// percentile returns the pth percentile of the sorted latencies l.
+func percentile(l []time.Duration, p int) time.Duration {
+	if len(l) < 1 {
+		return 0
+	}
+	i := (len(l)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return l[i].Round(10 * time.Microsecond)
+}
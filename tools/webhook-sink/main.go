@@ -0,0 +1,146 @@
+// Command webhook-sink receives NanoMDM webhook events for developing
+// and debugging webhook integrations. It verifies request signatures,
+// pretty-prints events (including their raw check-in and command
+// result plists), and optionally records them to disk.
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/service/microwebhook"
+)
+
+// overridden by -ldflags -X
+var version = "unknown"
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: %s [flags]
+
+Receives NanoMDM webhook events (e.g. with nanomdm -webhook-url
+http://localhost:9000/webhook) and prints them. With -secret requests
+must be signed with the same -webhook-signing-secret. With -dir each
+event body is recorded to a file.
+
+flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	var (
+		flListen  = flag.String("listen", ":9000", "HTTP listen address")
+		flSecret  = flag.String("secret", "", "webhook signing secret to verify requests with")
+		flDir     = flag.String("dir", "", "directory to record event bodies to")
+		flRaw     = flag.Bool("raw", false, "print request bodies as received instead of pretty-printing")
+		flVersion = flag.Bool("version", false, "print version")
+	)
+	flag.Usage = usage
+	flag.Parse()
+
+	if *flVersion {
+		fmt.Println(version)
+		return
+	}
+
+	if *flDir != "" {
+		if err := os.MkdirAll(*flDir, 0755); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	s := &sink{secret: []byte(*flSecret), dir: *flDir, raw: *flRaw}
+	log.Printf("listening on %s", *flListen)
+	log.Fatal(http.ListenAndServe(*flListen, s))
+}
+
+type sink struct {
+	secret []byte
+	dir    string
+	raw    bool
+
+	mu  sync.Mutex // serializes output and numbering
+	seq int
+}
+
+func (s *sink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	if len(s.secret) > 0 && !microwebhook.VerifySignature(s.secret, body, r.Header.Get(microwebhook.SignatureHeader)) {
+		log.Printf("#%d %s %s: invalid signature %q", s.seq, r.Method, r.URL.Path, r.Header.Get(microwebhook.SignatureHeader))
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	log.Printf("#%d %s %s (%s, %d bytes)", s.seq, r.Method, r.URL.Path, r.Header.Get("Content-Type"), len(body))
+	if s.raw {
+		fmt.Printf("%s\n", body)
+	} else {
+		fmt.Println(prettyPrint(body))
+	}
+	if s.dir != "" {
+		name := filepath.Join(s.dir, time.Now().UTC().Format("20060102T150405")+"-"+strconv.Itoa(s.seq)+".json")
+		if err = ioutil.WriteFile(name, body, 0644); err != nil {
+			log.Printf("recording event: %v", err)
+		}
+	}
+}
+
+// prettyPrint indents the JSON body with the raw_payload fields (of
+// MicroMDM-compatible or CloudEvents events) decoded as plist text.
+func prettyPrint(body []byte) string {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return string(body)
+	}
+	decodeRawPayloads(v)
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return string(body)
+	}
+	return buf.String()
+}
+
+// decodeRawPayloads replaces the base64 raw_payload strings in v with
+// their decoded text.
+func decodeRawPayloads(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if s, ok := e.(string); ok && k == "raw_payload" {
+				if b, err := base64.StdEncoding.DecodeString(s); err == nil {
+					v[k] = string(b)
+				}
+				continue
+			}
+			decodeRawPayloads(e)
+		}
+	case []interface{}:
+		for _, e := range v {
+			decodeRawPayloads(e)
+		}
+	}
+}
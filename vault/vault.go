@@ -0,0 +1,195 @@
+// Package vault reads secrets from HashiCorp Vault using its HTTP API.
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jessepeterson/nanomdm/log"
+)
+
+// RefPrefix is the prefix of secret references. For example
+// "vault:secret/data/nanomdm#api_key" refers to the "api_key" field of
+// the secret at path "secret/data/nanomdm".
+const RefPrefix = "vault:"
+
+// DefaultRefresh is the refresh interval of watched secrets without a
+// lease duration.
+const DefaultRefresh = 5 * time.Minute
+
+// Client reads secrets from Vault with a token.
+type Client struct {
+	addr   string
+	token  string
+	client *http.Client
+	logger log.Logger
+}
+
+type Option func(*Client)
+
+func WithLogger(logger log.Logger) Option {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// WithHTTPClient sets the HTTP client used to talk to Vault.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.client = client
+	}
+}
+
+// New creates a new Vault client for the Vault server at addr
+// authenticating with token.
+func New(addr, token string, opts ...Option) *Client {
+	c := &Client{
+		addr:   strings.TrimRight(addr, "/"),
+		token:  token,
+		client: http.DefaultClient,
+		logger: log.NopLogger,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// NewFromEnv creates a new Vault client from the standard VAULT_ADDR
+// and VAULT_TOKEN environment variables. The token may instead be read
+// from the file named by VAULT_TOKEN_FILE.
+func NewFromEnv(opts ...Option) (*Client, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return nil, errors.New("VAULT_ADDR not set")
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if path := os.Getenv("VAULT_TOKEN_FILE"); token == "" && path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	if token == "" {
+		return nil, errors.New("VAULT_TOKEN not set")
+	}
+	return New(addr, token, opts...), nil
+}
+
+// Secret is a secret read from Vault.
+type Secret struct {
+	Data          map[string]interface{}
+	LeaseDuration time.Duration
+	Renewable     bool
+}
+
+// Read reads the secret at path. The data of KV version 2 secrets is
+// unwrapped.
+func (c *Client) Read(ctx context.Context, path string) (*Secret, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.addr+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", c.token)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault: reading %s: HTTP status %d", path, resp.StatusCode)
+	}
+	body := &struct {
+		Data          map[string]interface{} `json:"data"`
+		LeaseDuration int                    `json:"lease_duration"`
+		Renewable     bool                   `json:"renewable"`
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(body); err != nil {
+		return nil, fmt.Errorf("vault: decoding %s: %w", path, err)
+	}
+	secret := &Secret{
+		Data:          body.Data,
+		LeaseDuration: time.Duration(body.LeaseDuration) * time.Second,
+		Renewable:     body.Renewable,
+	}
+	// KV version 2 nests the secret data and includes metadata
+	if data, ok := body.Data["data"].(map[string]interface{}); ok {
+		if _, ok := body.Data["metadata"]; ok {
+			secret.Data = data
+		}
+	}
+	return secret, nil
+}
+
+// IsRef returns true if s is a secret reference.
+func IsRef(s string) bool {
+	return strings.HasPrefix(s, RefPrefix)
+}
+
+// Lookup returns the value of the secret reference ref and its lease
+// duration.
+func (c *Client) Lookup(ctx context.Context, ref string) (string, time.Duration, error) {
+	ref = strings.TrimPrefix(ref, RefPrefix)
+	i := strings.LastIndex(ref, "#")
+	if i < 1 || i == len(ref)-1 {
+		return "", 0, fmt.Errorf("vault: invalid reference %q: expected path#key", ref)
+	}
+	path, key := ref[:i], ref[i+1:]
+	secret, err := c.Read(ctx, path)
+	if err != nil {
+		return "", 0, err
+	}
+	value, ok := secret.Data[key]
+	if !ok {
+		return "", 0, fmt.Errorf("vault: key %q not found at %s", key, path)
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", 0, fmt.Errorf("vault: key %q at %s is not a string", key, path)
+	}
+	return s, secret.LeaseDuration, nil
+}
+
+// refreshAfter returns when to look up a secret with lease again.
+func refreshAfter(lease time.Duration) time.Duration {
+	if lease > 0 {
+		// before the lease expires
+		return lease * 2 / 3
+	}
+	return DefaultRefresh
+}
+
+// Watch looks up the secret reference ref (whose current value and
+// lease duration are value and lease) again before its lease expires
+// (or every DefaultRefresh if it has no lease) and calls fn with
+// changed values until ctx is done. Lookup errors are logged and
+// retried.
+func (c *Client) Watch(ctx context.Context, ref, value string, lease time.Duration, fn func(string)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(refreshAfter(lease)):
+		}
+		newValue, newLease, err := c.Lookup(ctx, ref)
+		if err != nil {
+			c.logger.Info("msg", "watching secret", "ref", ref, "err", err)
+			lease = 0
+			continue
+		}
+		lease = newLease
+		if newValue != value {
+			value = newValue
+			c.logger.Info("msg", "secret rotated", "ref", ref)
+			fn(value)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package vault
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLookup(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/nanomdm":
+			w.Write([]byte(`{"data": {"data": {"api_key": "kv2"}, "metadata": {"version": 1}}}`))
+		case "/v1/database/creds/nanomdm":
+			w.Write([]byte(`{"lease_duration": 3600, "renewable": true, "data": {"dsn": "user:pass@/nanomdm"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	c := New(srv.URL, "token")
+	ctx := context.Background()
+
+	value, lease, err := c.Lookup(ctx, "vault:secret/data/nanomdm#api_key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "kv2" || lease != 0 {
+		t.Errorf("have %q (%s), want %q", value, lease, "kv2")
+	}
+
+	value, lease, err = c.Lookup(ctx, "vault:database/creds/nanomdm#dsn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "user:pass@/nanomdm" || lease != time.Hour {
+		t.Errorf("have %q (%s), want %q (%s)", value, lease, "user:pass@/nanomdm", time.Hour)
+	}
+
+	for _, ref := range []string{"vault:secret/data/nanomdm#missing", "vault:secret/data/other#key", "vault:nokey"} {
+		if _, _, err = c.Lookup(ctx, ref); err == nil {
+			t.Errorf("expected error for %s", ref)
+		}
+	}
+}